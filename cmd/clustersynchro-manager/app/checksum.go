@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
+)
+
+type verifyChecksumsOptions struct {
+	Storage *storageoptions.StorageOptions
+
+	Repair        bool
+	BatchSize     int
+	RowsPerSecond int
+	CursorFile    string
+}
+
+// NewVerifyChecksumsCommand scans the stored objects for the ones mismatched with their checksums,
+// it can be run while the clustersynchro-manager is syncing the resources.
+func NewVerifyChecksumsCommand(ctx context.Context) *cobra.Command {
+	opts := &verifyChecksumsOptions{Storage: storageoptions.NewStorageOptions()}
+	cmd := &cobra.Command{
+		Use:   "verify-checksums",
+		Short: "Verify the stored objects with their checksums, and repair the corrupted ones by deleting them to be resynced",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(opts.Storage.Validate()); err != nil {
+				return err
+			}
+			return runVerifyChecksums(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	opts.Storage.AddFlags(namedFlagSets.FlagSet("storage"))
+
+	fs := namedFlagSets.FlagSet("verify")
+	fs.BoolVar(&opts.Repair, "repair", opts.Repair, "delete the corrupted resources, they are recreated when the resources are relisted by the sync")
+	fs.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "the number of the resources scanned by each batch, the storage default is used if it is not positive")
+	fs.IntVar(&opts.RowsPerSecond, "rows-per-second", opts.RowsPerSecond, "the rate limit of the scanned resources, the storage default is used if it is not positive")
+	fs.StringVar(&opts.CursorFile, "cursor-file", opts.CursorFile, "the file to save the cursor after each batch, the scanning is resumed from the saved cursor")
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runVerifyChecksums(ctx context.Context, opts *verifyChecksumsOptions) error {
+	factory, err := storage.NewStorageFactory(opts.Storage.Name, opts.Storage.ConfigPath)
+	if err != nil {
+		return err
+	}
+	verifier, ok := factory.(storage.ChecksumVerifier)
+	if !ok {
+		return fmt.Errorf("storage %s doesn't support verifying the checksums", opts.Storage.Name)
+	}
+
+	verifyOpts := storage.ChecksumVerifyOptions{
+		Repair:        opts.Repair,
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+	}
+	if opts.CursorFile != "" {
+		cursor, err := os.ReadFile(opts.CursorFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		verifyOpts.Cursor = string(cursor)
+		verifyOpts.Progress = func(summary storage.ChecksumVerifySummary) {
+			if err := os.WriteFile(opts.CursorFile, []byte(summary.Cursor), 0o600); err != nil {
+				klog.ErrorS(err, "Failed to save the cursor", "file", opts.CursorFile)
+			}
+		}
+	}
+
+	summary, err := verifier.VerifyChecksums(ctx, verifyOpts)
+	klog.InfoS("Verified the checksums", "scanned", summary.Scanned, "unchecked", summary.Unchecked, "mismatched", summary.Mismatched,
+		"repaired", summary.Repaired, "conflicted", summary.Conflicted, "cursor", summary.Cursor)
+	return err
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage"
+)
+
+type migrateClusterStateOptions struct {
+	SourceType      string
+	SourceDSN       string
+	DestinationType string
+	DestinationDSN  string
+
+	Cluster    string
+	BatchSize  int
+	CursorFile string
+}
+
+// NewMigrateClusterStateCommand copies the sync state of a cluster from the source database to the destination database,
+// e.g. from mysql to postgres, so the clustersynchro-manager of the destination resumes the cluster without relisting its resources.
+// The cluster should not be synced to the source during the migration.
+func NewMigrateClusterStateCommand(ctx context.Context) *cobra.Command {
+	opts := &migrateClusterStateOptions{}
+	cmd := &cobra.Command{
+		Use:   "migrate-cluster-state",
+		Short: "Dump the stored resources and the checkpoints of a cluster from the source database and restore them into the destination database",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.validate(); err != nil {
+				return err
+			}
+			return runMigrateClusterState(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	fs := namedFlagSets.FlagSet("migrate")
+	fs.StringVar(&opts.SourceType, "source-type", opts.SourceType, "the type of the source database, one of mysql, postgres and sqlite")
+	fs.StringVar(&opts.SourceDSN, "source-dsn", opts.SourceDSN, "the DSN of the source database")
+	fs.StringVar(&opts.DestinationType, "destination-type", opts.DestinationType, "the type of the destination database, one of mysql, postgres and sqlite")
+	fs.StringVar(&opts.DestinationDSN, "destination-dsn", opts.DestinationDSN, "the DSN of the destination database, the schema is migrated if it doesn't exist")
+	fs.StringVar(&opts.Cluster, "cluster", opts.Cluster, "the name of the migrated cluster")
+	fs.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "the number of the resources read and written by each batch, the storage default is used if it is not positive")
+	fs.StringVar(&opts.CursorFile, "cursor-file", opts.CursorFile, "the file to save the cursor after each batch, the restore is resumed from the saved cursor")
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func (o *migrateClusterStateOptions) validate() error {
+	if o.SourceType == "" || o.SourceDSN == "" || o.DestinationType == "" || o.DestinationDSN == "" {
+		return errors.New("source-type, source-dsn, destination-type and destination-dsn are required")
+	}
+	if o.Cluster == "" {
+		return errors.New("cluster is required")
+	}
+	return nil
+}
+
+func runMigrateClusterState(ctx context.Context, opts *migrateClusterStateOptions) error {
+	source, err := internalstorage.NewStorageFactoryWithConfig(&internalstorage.Config{Type: opts.SourceType, DSN: opts.SourceDSN})
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	destination, err := internalstorage.NewStorageFactoryWithConfig(&internalstorage.Config{Type: opts.DestinationType, DSN: opts.DestinationDSN})
+	if err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+
+	restoreOpts := storage.RestoreOptions{BatchSize: opts.BatchSize}
+	if opts.CursorFile != "" {
+		cursor, err := os.ReadFile(opts.CursorFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		restoreOpts.Cursor = string(cursor)
+		restoreOpts.Progress = func(cursor string) {
+			if err := os.WriteFile(opts.CursorFile, []byte(cursor), 0o600); err != nil {
+				klog.ErrorS(err, "Failed to save the cursor", "file", opts.CursorFile)
+			}
+		}
+	}
+
+	// the dump is streamed to the restore, so the state of the cluster is never buffered
+	reader, writer := io.Pipe()
+	dumped := make(chan error, 1)
+	go func() {
+		_, err := source.DumpClusterState(ctx, opts.Cluster, storage.DumpOptions{BatchSize: opts.BatchSize}, writer)
+		writer.CloseWithError(err)
+		dumped <- err
+	}()
+
+	summary, err := destination.RestoreClusterState(ctx, restoreOpts, reader)
+	// the failed restore stops the dump
+	reader.CloseWithError(err)
+	dumpErr := <-dumped
+	klog.InfoS("Migrated the cluster state", "cluster", opts.Cluster, "restored", summary.Restored, "skipped", summary.Skipped,
+		"checkpoints", summary.Checkpoints, "resources", summary.Manifest.Resources, "checksum", summary.Manifest.Checksum, "cursor", summary.Cursor)
+	if err != nil {
+		return err
+	}
+	if dumpErr != nil {
+		return fmt.Errorf("failed to dump the cluster state: %w", dumpErr)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
+)
+
+type rewriteEncryptionOptions struct {
+	Storage *storageoptions.StorageOptions
+
+	DryRun        bool
+	BatchSize     int
+	RowsPerSecond int
+	CursorFile    string
+}
+
+// NewRewriteEncryptionCommand rewrites the stored objects by the current encryption config, e.g. after the key is rotated,
+// it can be run while the clustersynchro-manager is syncing the resources.
+func NewRewriteEncryptionCommand(ctx context.Context) *cobra.Command {
+	opts := &rewriteEncryptionOptions{Storage: storageoptions.NewStorageOptions()}
+	cmd := &cobra.Command{
+		Use:   "rewrite-encryption",
+		Short: "Rewrite the stored objects by the current encryption config, e.g. re-encrypt the objects after the key is rotated",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(opts.Storage.Validate()); err != nil {
+				return err
+			}
+			return runRewriteEncryption(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	opts.Storage.AddFlags(namedFlagSets.FlagSet("storage"))
+
+	fs := namedFlagSets.FlagSet("rewrite")
+	fs.BoolVar(&opts.DryRun, "dry-run", opts.DryRun, "only count the resources to be rewritten")
+	fs.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "the number of the resources scanned by each batch, the storage default is used if it is not positive")
+	fs.IntVar(&opts.RowsPerSecond, "rows-per-second", opts.RowsPerSecond, "the rate limit of the scanned resources, the storage default is used if it is not positive")
+	fs.StringVar(&opts.CursorFile, "cursor-file", opts.CursorFile, "the file to save the cursor after each batch, the scanning is resumed from the saved cursor")
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runRewriteEncryption(ctx context.Context, opts *rewriteEncryptionOptions) error {
+	factory, err := storage.NewStorageFactory(opts.Storage.Name, opts.Storage.ConfigPath)
+	if err != nil {
+		return err
+	}
+	rewriter, ok := factory.(storage.EncryptionRewriter)
+	if !ok {
+		return fmt.Errorf("storage %s doesn't support rewriting the encryption", opts.Storage.Name)
+	}
+
+	rewriteOpts := storage.EncryptionRewriteOptions{
+		DryRun:        opts.DryRun,
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+	}
+	if opts.CursorFile != "" {
+		cursor, err := os.ReadFile(opts.CursorFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		rewriteOpts.Cursor = string(cursor)
+		rewriteOpts.Progress = func(summary storage.EncryptionRewriteSummary) {
+			if err := os.WriteFile(opts.CursorFile, []byte(summary.Cursor), 0o600); err != nil {
+				klog.ErrorS(err, "Failed to save the cursor", "file", opts.CursorFile)
+			}
+		}
+	}
+
+	summary, err := rewriter.RewriteEncryption(ctx, rewriteOpts)
+	klog.InfoS("Rewrote the encryption", "scanned", summary.Scanned, "encrypted", summary.Encrypted, "decrypted", summary.Decrypted,
+		"failed", summary.Failed, "conflicted", summary.Conflicted, "cursor", summary.Cursor)
+	return err
+}
@@ -2,10 +2,14 @@ package options
 
 import (
 	"fmt"
+	"os"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -27,6 +31,7 @@ import (
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
 	"github.com/clusterpedia-io/clusterpedia/pkg/synchromanager/clustersynchro"
+	"github.com/clusterpedia-io/clusterpedia/pkg/synchromanager/clustersynchro/informer"
 )
 
 const (
@@ -45,9 +50,25 @@ type Options struct {
 	Metrics          *metrics.Options
 	KubeStateMetrics *kubestatemetrics.Options
 
-	WorkerNumber            int // WorkerNumber is the number of worker goroutines
-	PageSizeForResourceSync int64
-	ShardingName            string
+	WorkerNumber               int // WorkerNumber is the number of worker goroutines
+	PageSizeForResourceSync    int64
+	MinPageSizeForResourceSync int64
+	MaxPageSizeForResourceSync int64
+	RelistOnlyResources        []string
+	ResourceSyncPriorities     map[string]int
+	GVKMismatchPolicy          string
+	MaxConsecutiveForbidden    int
+	ShortWatchThreshold        time.Duration
+	VerifyStoreConsistency     bool
+	StoreConsistencyInterval   time.Duration
+	StoreConsistencyThreshold  int64
+	StoreConsistencyQPS        float64
+	StorageEpochCheckInterval  time.Duration
+	InitialSyncSmallCount      int64
+	InitialSyncWindow          time.Duration
+	InitialSyncConcurrency     int
+	ClusterLeaseDuration       time.Duration
+	ShardingName               string
 }
 
 func NewClusterSynchroManagerOptions() (*Options, error) {
@@ -80,6 +101,10 @@ func NewClusterSynchroManagerOptions() (*Options, error) {
 	options.KubeStateMetrics = kubestatemetrics.NewOptions()
 
 	options.WorkerNumber = 5
+	options.StoreConsistencyInterval = 10 * time.Minute
+	options.StoreConsistencyQPS = 1
+	options.StorageEpochCheckInterval = time.Minute
+	options.InitialSyncSmallCount = 1000
 	return &options, nil
 }
 
@@ -95,6 +120,22 @@ func (o *Options) Flags() cliflag.NamedFlagSets {
 
 	syncfs := fss.FlagSet("resource sync")
 	syncfs.Int64Var(&o.PageSizeForResourceSync, "page-size", o.PageSizeForResourceSync, "The requested chunk size of initial and resync watch lists for resource sync")
+	syncfs.Int64Var(&o.MinPageSizeForResourceSync, "min-page-size", o.MinPageSizeForResourceSync, "The lower bound of the adaptive page size for resource sync, it works with the AdaptivePageSizeForResourceSync feature gate")
+	syncfs.Int64Var(&o.MaxPageSizeForResourceSync, "max-page-size", o.MaxPageSizeForResourceSync, "The upper bound of the adaptive page size for resource sync, it works with the AdaptivePageSizeForResourceSync feature gate")
+	syncfs.StringSliceVar(&o.RelistOnlyResources, "relist-only-resources", o.RelistOnlyResources, "The resources synced by periodic relist instead of watch, in the format of <resource>.<group>, such as pods.metrics.k8s.io")
+	syncfs.StringToIntVar(&o.ResourceSyncPriorities, "resource-sync-priorities", o.ResourceSyncPriorities, "The sync priorities of the resources in the format of <resource>.<group>=<priority>, such as pods=100,deployments.apps=100, the greater value has the higher priority and the default priority is 0. The resources start syncing after the resources of the higher priorities are initially synced")
+	syncfs.StringVar(&o.GVKMismatchPolicy, "gvk-mismatch-policy", o.GVKMismatchPolicy, "The handling of the watch events whose version is different from the synced version, e.g. the storage version of the CRD is changed, one of drop, accept-version and relist. Default is drop")
+	syncfs.DurationVar(&o.ClusterLeaseDuration, "cluster-lease-duration", o.ClusterLeaseDuration, "The duration of the leases of the clusters in the storage, the manager writes the resources of a cluster only with its lease, so the managers of the different shardings never write the same cluster concurrently. The storage rejects the writes without the lease if its fencing is enabled. 0 means the leases are disabled")
+	syncfs.IntVar(&o.MaxConsecutiveForbidden, "max-consecutive-forbidden", o.MaxConsecutiveForbidden, "The number of the consecutive forbidden errors of the list and watch after which the resource sync is stopped and retried only periodically, 0 means keep retrying with the backoff until the permissions are granted")
+	syncfs.DurationVar(&o.ShortWatchThreshold, "short-watch-threshold", o.ShortWatchThreshold, "The duration under which the watch closed without any events is treated as an unexpected close and restarted with the backoff, it is measured by the local clock. Default is 1s")
+	syncfs.BoolVar(&o.VerifyStoreConsistency, "verify-store-consistency", o.VerifyStoreConsistency, "Verify the number of the stored resources after the initial list of a resource is synced, the discrepancy is logged and exported by the clusterpedia_resource_synchro_store_discrepancy metric. It requires the storage to count the resources")
+	syncfs.DurationVar(&o.StoreConsistencyInterval, "store-consistency-interval", o.StoreConsistencyInterval, "The min interval between the consistency checks of a resource")
+	syncfs.Int64Var(&o.StoreConsistencyThreshold, "store-consistency-relist-threshold", o.StoreConsistencyThreshold, "The resource is relisted to rewrite the resources if the discrepancy is greater than the threshold, the relists are bounded to 3 times in a row. 0 means the discrepancy is only reported")
+	syncfs.Float64Var(&o.StoreConsistencyQPS, "store-consistency-qps", o.StoreConsistencyQPS, "The max number of the consistency checks per second of all the resources")
+	syncfs.Int64Var(&o.InitialSyncSmallCount, "initial-sync-small-count", o.InitialSyncSmallCount, "The max stored count of the small resources of a reconnected cluster, which start the initial sync immediately, it works with the initial-sync-window and initial-sync-concurrency")
+	syncfs.DurationVar(&o.InitialSyncWindow, "initial-sync-window", o.InitialSyncWindow, "The time the initial syncs of the large resources of a reconnected cluster are spread over, the resources with the smaller stored counts start first and the resources never stored start immediately. 0 means the large resources are not staggered")
+	syncfs.IntVar(&o.InitialSyncConcurrency, "initial-sync-concurrency", o.InitialSyncConcurrency, "The max number of the large resources of a cluster initially synced concurrently, 0 means unlimited")
+	syncfs.DurationVar(&o.StorageEpochCheckInterval, "storage-epoch-check-interval", o.StorageEpochCheckInterval, "The interval to check the epoch of the storage, the resources of all clusters are relisted from scratch when the epoch is changed, e.g. the stored resources are truncated or the epoch is reset by the reset-storage-epoch command. It requires the storage to support the epoch, 0 means the epoch is not checked")
 
 	options.BindLeaderElectionFlags(&o.LeaderElection, genericfs)
 
@@ -119,6 +160,38 @@ func (o *Options) Validate() error {
 	if o.WorkerNumber <= 0 {
 		errs = append(errs, fmt.Errorf("worker-number must be greater than 0"))
 	}
+	if o.MinPageSizeForResourceSync < 0 || o.MaxPageSizeForResourceSync < 0 {
+		errs = append(errs, fmt.Errorf("min-page-size and max-page-size must not be negative"))
+	}
+	if o.MaxPageSizeForResourceSync != 0 && o.MinPageSizeForResourceSync > o.MaxPageSizeForResourceSync {
+		errs = append(errs, fmt.Errorf("min-page-size must not be greater than max-page-size"))
+	}
+	if _, err := informer.ParseGVKMismatchPolicy(o.GVKMismatchPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if o.MaxConsecutiveForbidden < 0 {
+		errs = append(errs, fmt.Errorf("max-consecutive-forbidden must not be negative"))
+	}
+	if o.ShortWatchThreshold < 0 {
+		errs = append(errs, fmt.Errorf("short-watch-threshold must not be negative"))
+	}
+	if o.VerifyStoreConsistency {
+		if o.StoreConsistencyInterval < 0 || o.StoreConsistencyThreshold < 0 {
+			errs = append(errs, fmt.Errorf("store-consistency-interval and store-consistency-relist-threshold must not be negative"))
+		}
+		if o.StoreConsistencyQPS <= 0 {
+			errs = append(errs, fmt.Errorf("store-consistency-qps must be greater than 0"))
+		}
+	}
+	if o.InitialSyncSmallCount < 0 || o.InitialSyncWindow < 0 || o.InitialSyncConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("initial-sync-small-count, initial-sync-window and initial-sync-concurrency must not be negative"))
+	}
+	if o.StorageEpochCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("storage-epoch-check-interval must not be negative"))
+	}
+	if o.ClusterLeaseDuration < 0 {
+		errs = append(errs, fmt.Errorf("cluster-lease-duration must not be negative"))
+	}
 	return utilerrors.NewAggregate(errs)
 }
 
@@ -155,6 +228,21 @@ func (o *Options) Config() (*config.Config, error) {
 	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: client.CoreV1().Events("")})
 	eventRecorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: ClusterSynchroManagerUserAgent})
 
+	relistOnlyResources := make([]schema.GroupResource, 0, len(o.RelistOnlyResources))
+	for _, resource := range o.RelistOnlyResources {
+		relistOnlyResources = append(relistOnlyResources, schema.ParseGroupResource(resource))
+	}
+
+	resourcePriorities := make(map[schema.GroupResource]int, len(o.ResourceSyncPriorities))
+	for resource, priority := range o.ResourceSyncPriorities {
+		resourcePriorities[schema.ParseGroupResource(resource)] = priority
+	}
+
+	gvkMismatchPolicy, err := informer.ParseGVKMismatchPolicy(o.GVKMismatchPolicy)
+	if err != nil {
+		return nil, err
+	}
+
 	metricsConfig := o.Metrics.Config()
 	metricsStoreBuilder, err := o.KubeStateMetrics.MetricsStoreBuilderConfig().New()
 	if err != nil {
@@ -162,6 +250,29 @@ func (o *Options) Config() (*config.Config, error) {
 	}
 	kubeStateMetricsServerConfig := o.KubeStateMetrics.ServerConfig(metricsConfig)
 
+	var leaseHolder string
+	if o.ClusterLeaseDuration > 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		leaseHolder = hostname + "_" + string(uuid.NewUUID())
+	}
+
+	var storeConsistencyChecker *clustersynchro.StoreConsistencyChecker
+	if o.VerifyStoreConsistency {
+		storeConsistencyChecker = clustersynchro.NewStoreConsistencyChecker(o.StoreConsistencyInterval, o.StoreConsistencyThreshold, o.StoreConsistencyQPS)
+	}
+
+	var initialSyncSchedule *informer.StartScheduleConfig
+	if o.InitialSyncWindow > 0 || o.InitialSyncConcurrency > 0 {
+		initialSyncSchedule = &informer.StartScheduleConfig{
+			SmallCount:  o.InitialSyncSmallCount,
+			Window:      o.InitialSyncWindow,
+			Concurrency: o.InitialSyncConcurrency,
+		}
+	}
+
 	if o.ShardingName != "" {
 		o.LeaderElection.ResourceName = fmt.Sprintf("%s-%s", o.LeaderElection.ResourceName, o.ShardingName)
 	}
@@ -181,6 +292,19 @@ func (o *Options) Config() (*config.Config, error) {
 		ClusterSyncConfig: clustersynchro.ClusterSyncConfig{
 			MetricsStoreBuilder:     metricsStoreBuilder,
 			PageSizeForResourceSync: o.PageSizeForResourceSync,
+
+			MinPageSizeForResourceSync: o.MinPageSizeForResourceSync,
+			MaxPageSizeForResourceSync: o.MaxPageSizeForResourceSync,
+			RelistOnlyResources:        relistOnlyResources,
+			ResourcePriorities:         resourcePriorities,
+			InitialSyncSchedule:        initialSyncSchedule,
+			GVKMismatchPolicy:          gvkMismatchPolicy,
+			MaxConsecutiveForbidden:    o.MaxConsecutiveForbidden,
+			ShortWatchThreshold:        o.ShortWatchThreshold,
+			StoreConsistencyChecker:    storeConsistencyChecker,
+			StorageEpochCheckInterval:  o.StorageEpochCheckInterval,
+			LeaseHolder:                leaseHolder,
+			LeaseDuration:              o.ClusterLeaseDuration,
 		},
 
 		LeaderElection: o.LeaderElection,
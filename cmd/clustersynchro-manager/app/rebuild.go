@@ -0,0 +1,92 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
+)
+
+type rebuildOptions struct {
+	Storage *storageoptions.StorageOptions
+
+	VerifyOnly    bool
+	BatchSize     int
+	RowsPerSecond int
+	CursorFile    string
+}
+
+// NewRebuildSecondaryDataCommand rebuilds the secondary data of the stored resources, e.g. the owner uid,
+// it can be run while the clustersynchro-manager is syncing the resources.
+func NewRebuildSecondaryDataCommand(ctx context.Context) *cobra.Command {
+	opts := &rebuildOptions{Storage: storageoptions.NewStorageOptions()}
+	cmd := &cobra.Command{
+		Use:   "rebuild-secondary-data",
+		Short: "Verify and rebuild the secondary data derived from the stored objects, e.g. the owner uid",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(opts.Storage.Validate()); err != nil {
+				return err
+			}
+			return runRebuild(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	opts.Storage.AddFlags(namedFlagSets.FlagSet("storage"))
+
+	fs := namedFlagSets.FlagSet("rebuild")
+	fs.BoolVar(&opts.VerifyOnly, "verify-only", opts.VerifyOnly, "only report the mismatches without fixing them")
+	fs.IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "the number of the resources scanned by each batch, the storage default is used if it is not positive")
+	fs.IntVar(&opts.RowsPerSecond, "rows-per-second", opts.RowsPerSecond, "the rate limit of the scanned resources, the storage default is used if it is not positive")
+	fs.StringVar(&opts.CursorFile, "cursor-file", opts.CursorFile, "the file to save the cursor after each batch, the rebuilding is resumed from the saved cursor")
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runRebuild(ctx context.Context, opts *rebuildOptions) error {
+	factory, err := storage.NewStorageFactory(opts.Storage.Name, opts.Storage.ConfigPath)
+	if err != nil {
+		return err
+	}
+	rebuilder, ok := factory.(storage.SecondaryDataRebuilder)
+	if !ok {
+		return fmt.Errorf("storage %s doesn't support rebuilding the secondary data", opts.Storage.Name)
+	}
+
+	rebuildOpts := storage.RebuildOptions{
+		VerifyOnly:    opts.VerifyOnly,
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+	}
+	if opts.CursorFile != "" {
+		cursor, err := os.ReadFile(opts.CursorFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		rebuildOpts.Cursor = string(cursor)
+		rebuildOpts.Progress = func(summary storage.RebuildSummary) {
+			if err := os.WriteFile(opts.CursorFile, []byte(summary.Cursor), 0o600); err != nil {
+				klog.ErrorS(err, "Failed to save the cursor", "file", opts.CursorFile)
+			}
+		}
+	}
+
+	summary, err := rebuilder.RebuildSecondaryData(ctx, rebuildOpts)
+	klog.InfoS("Rebuilt the secondary data", "scanned", summary.Scanned, "mismatched", summary.Mismatched,
+		"fixed", summary.Fixed, "conflicted", summary.Conflicted, "cursor", summary.Cursor)
+	return err
+}
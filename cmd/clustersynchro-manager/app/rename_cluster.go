@@ -0,0 +1,73 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
+)
+
+type renameClusterOptions struct {
+	Storage *storageoptions.StorageOptions
+
+	OldName string
+	NewName string
+}
+
+// NewRenameClusterCommand renames the stored cluster, e.g. the cluster is re-registered by another name,
+// the synchro of the cluster should be stopped during the rename.
+func NewRenameClusterCommand(ctx context.Context) *cobra.Command {
+	opts := &renameClusterOptions{Storage: storageoptions.NewStorageOptions()}
+	cmd := &cobra.Command{
+		Use:   "rename-cluster",
+		Short: "Rename the stored resources and the sync state of a cluster, the interrupted rename is resumed by running it again",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(opts.Storage.Validate()); err != nil {
+				return err
+			}
+			if opts.OldName == "" || opts.NewName == "" {
+				return errors.New("old-name and new-name are required")
+			}
+			return runRenameCluster(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	opts.Storage.AddFlags(namedFlagSets.FlagSet("storage"))
+
+	fs := namedFlagSets.FlagSet("rename")
+	fs.StringVar(&opts.OldName, "old-name", opts.OldName, "the stored name of the cluster")
+	fs.StringVar(&opts.NewName, "new-name", opts.NewName, "the new name of the cluster, which must not have the stored resources")
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runRenameCluster(ctx context.Context, opts *renameClusterOptions) error {
+	factory, err := storage.NewStorageFactory(opts.Storage.Name, opts.Storage.ConfigPath)
+	if err != nil {
+		return err
+	}
+	renamer, ok := factory.(storage.ClusterRenamer)
+	if !ok {
+		return fmt.Errorf("storage %s doesn't support renaming the clusters", opts.Storage.Name)
+	}
+
+	if err := renamer.RenameCluster(ctx, opts.OldName, opts.NewName); err != nil {
+		return err
+	}
+	klog.InfoS("Renamed the cluster", "oldName", opts.OldName, "newName", opts.NewName)
+	return nil
+}
@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+	"k8s.io/component-base/term"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	storageoptions "github.com/clusterpedia-io/clusterpedia/pkg/storage/options"
+)
+
+type resetStorageEpochOptions struct {
+	Storage *storageoptions.StorageOptions
+}
+
+// NewResetStorageEpochCommand resets the epoch of the storage, e.g. after the stored resources are restored from a backup,
+// the running clustersynchro-managers relist the resources of all clusters from scratch once they observe the new epoch.
+func NewResetStorageEpochCommand(ctx context.Context) *cobra.Command {
+	opts := &resetStorageEpochOptions{Storage: storageoptions.NewStorageOptions()}
+	cmd := &cobra.Command{
+		Use:   "reset-storage-epoch",
+		Short: "Reset the epoch of the storage, so the resources of all clusters are resynced from scratch",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utilerrors.NewAggregate(opts.Storage.Validate()); err != nil {
+				return err
+			}
+			return runResetStorageEpoch(ctx, opts)
+		},
+	}
+
+	var namedFlagSets cliflag.NamedFlagSets
+	opts.Storage.AddFlags(namedFlagSets.FlagSet("storage"))
+
+	for _, f := range namedFlagSets.FlagSets {
+		cmd.Flags().AddFlagSet(f)
+	}
+	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
+	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+	return cmd
+}
+
+func runResetStorageEpoch(ctx context.Context, opts *resetStorageEpochOptions) error {
+	factory, err := storage.NewStorageFactory(opts.Storage.Name, opts.Storage.ConfigPath)
+	if err != nil {
+		return err
+	}
+	epochs, ok := factory.(storage.StorageEpochManager)
+	if !ok {
+		return fmt.Errorf("storage %s doesn't support the storage epoch", opts.Storage.Name)
+	}
+
+	epoch, err := epochs.ResetStorageEpoch(ctx)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Reset the storage epoch", "epoch", epoch)
+	return nil
+}
@@ -81,6 +81,13 @@ func NewClusterSynchroManagerCommand(ctx context.Context) *cobra.Command {
 
 	cols, _, _ := term.TerminalSize(cmd.OutOrStdout())
 	cliflag.SetUsageAndHelpFunc(cmd, namedFlagSets, cols)
+
+	cmd.AddCommand(NewRebuildSecondaryDataCommand(ctx))
+	cmd.AddCommand(NewVerifyChecksumsCommand(ctx))
+	cmd.AddCommand(NewRewriteEncryptionCommand(ctx))
+	cmd.AddCommand(NewMigrateClusterStateCommand(ctx))
+	cmd.AddCommand(NewRenameClusterCommand(ctx))
+	cmd.AddCommand(NewResetStorageEpochCommand(ctx))
 	return cmd
 }
 
@@ -0,0 +1,127 @@
+package apiserver
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/utils/clock"
+
+	clusterv1alpha2 "github.com/clusterpedia-io/api/cluster/v1alpha2"
+	clusterlister "github.com/clusterpedia-io/clusterpedia/pkg/generated/listers/cluster/v1alpha2"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/filters"
+)
+
+const (
+	// authorizedDecisionTTL is how long the decisions of the authorizer are reused by the requests of the same user,
+	// the changed permissions of the user take effect after it.
+	authorizedDecisionTTL = 10 * time.Second
+
+	authorizedDecisionCacheSize = 10000
+)
+
+// authorizedClustersResolver allows the user to access the clusters whose PediaClusters the user is allowed to get,
+// the user allowed to get all PediaClusters is allowed to access all clusters.
+//
+// The decisions are cached per user and cluster for a short TTL, otherwise every request of the restricted users
+// would authorize all PediaClusters.
+type authorizedClustersResolver struct {
+	authorizer authorizer.Authorizer
+	lister     clusterlister.PediaClusterLister
+	decisions  *cache.LRUExpireCache
+}
+
+var _ filters.AllowedClustersResolver = &authorizedClustersResolver{}
+
+func newAuthorizedClustersResolver(clock clock.PassiveClock, authorizer authorizer.Authorizer, lister clusterlister.PediaClusterLister) *authorizedClustersResolver {
+	return &authorizedClustersResolver{
+		authorizer: authorizer,
+		lister:     lister,
+		decisions:  cache.NewLRUExpireCacheWithClock(authorizedDecisionCacheSize, clock),
+	}
+}
+
+// authorizedDecisionKey is the key of the cached decision of the user and the cluster,
+// the empty cluster is the decision of all PediaClusters.
+type authorizedDecisionKey struct {
+	user    string
+	cluster string
+}
+
+func (r *authorizedClustersResolver) AllowedClusters(ctx context.Context, user user.Info) (sets.Set[string], bool, error) {
+	allowed, err := r.authorize(ctx, user, "")
+	if err != nil || allowed {
+		return nil, allowed, err
+	}
+
+	clusters, err := r.lister.List(labels.Everything())
+	if err != nil {
+		return nil, false, err
+	}
+	names := sets.New[string]()
+	for _, cluster := range clusters {
+		allowed, err := r.authorize(ctx, user, cluster.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		if allowed {
+			names.Insert(cluster.Name)
+		}
+	}
+	return names, false, nil
+}
+
+func (r *authorizedClustersResolver) authorize(ctx context.Context, user user.Info, name string) (bool, error) {
+	key := authorizedDecisionKey{user: userKey(user), cluster: name}
+	if allowed, ok := r.decisions.Get(key); ok {
+		return allowed.(bool), nil
+	}
+
+	decision, _, err := r.authorizer.Authorize(ctx, authorizer.AttributesRecord{
+		User:            user,
+		Verb:            "get",
+		APIGroup:        clusterv1alpha2.GroupName,
+		APIVersion:      clusterv1alpha2.SchemeGroupVersion.Version,
+		Resource:        "pediaclusters",
+		Name:            name,
+		ResourceRequest: true,
+	})
+	if err != nil {
+		return false, err
+	}
+	allowed := decision == authorizer.DecisionAllow
+	r.decisions.Add(key, allowed, authorizedDecisionTTL)
+	return allowed, nil
+}
+
+// userKey identifies the user by all the attributes the authorizers may decide on.
+func userKey(user user.Info) string {
+	var b strings.Builder
+	b.WriteString(user.GetName())
+	b.WriteString("\x00")
+	b.WriteString(user.GetUID())
+	b.WriteString("\x00")
+	groups := append([]string(nil), user.GetGroups()...)
+	sort.Strings(groups)
+	b.WriteString(strings.Join(groups, "\x01"))
+
+	extra := user.GetExtra()
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b.WriteString("\x00")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(strings.Join(extra[key], "\x01"))
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package apiserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/client-go/tools/cache"
+	clocktesting "k8s.io/utils/clock/testing"
+
+	clusterv1alpha2 "github.com/clusterpedia-io/api/cluster/v1alpha2"
+	clusterlister "github.com/clusterpedia-io/clusterpedia/pkg/generated/listers/cluster/v1alpha2"
+)
+
+// countingAuthorizer allows the users to get the PediaClusters by the names, the empty name is all PediaClusters.
+type countingAuthorizer struct {
+	allowed map[string]sets.Set[string]
+	calls   int
+}
+
+func (a *countingAuthorizer) Authorize(_ context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	a.calls++
+	if a.allowed[attrs.GetUser().GetName()].Has(attrs.GetName()) {
+		return authorizer.DecisionAllow, "", nil
+	}
+	return authorizer.DecisionNoOpinion, "", nil
+}
+
+func TestAuthorizedClustersResolver(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, name := range []string{"cluster-1", "cluster-2", "cluster-3"} {
+		require.NoError(t, indexer.Add(&clusterv1alpha2.PediaCluster{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	authz := &countingAuthorizer{allowed: map[string]sets.Set[string]{
+		"admin":  sets.New(""),
+		"team-a": sets.New("cluster-1", "cluster-3"),
+	}}
+	clock := clocktesting.NewFakeClock(time.Now())
+	resolver := newAuthorizedClustersResolver(clock, authz, clusterlister.NewPediaClusterLister(indexer))
+
+	teamA := &user.DefaultInfo{Name: "team-a", Groups: []string{"system:authenticated"}}
+	clusters, all, err := resolver.AllowedClusters(context.Background(), teamA)
+	require.NoError(t, err)
+	assert.False(t, all)
+	assert.Equal(t, sets.New("cluster-1", "cluster-3"), clusters)
+	// all PediaClusters and each of them
+	assert.Equal(t, 4, authz.calls)
+
+	// the decisions are reused by the requests of the same user
+	clusters, _, err = resolver.AllowedClusters(context.Background(), teamA)
+	require.NoError(t, err)
+	assert.Equal(t, sets.New("cluster-1", "cluster-3"), clusters)
+	assert.Equal(t, 4, authz.calls)
+
+	// the user with other groups is authorized again
+	_, _, err = resolver.AllowedClusters(context.Background(), &user.DefaultInfo{Name: "team-a", Groups: []string{"team-b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 8, authz.calls)
+
+	_, all, err = resolver.AllowedClusters(context.Background(), &user.DefaultInfo{Name: "admin"})
+	require.NoError(t, err)
+	assert.True(t, all)
+	assert.Equal(t, 9, authz.calls)
+
+	// the decisions expire after the TTL
+	clock.Step(authorizedDecisionTTL + time.Second)
+	_, _, err = resolver.AllowedClusters(context.Background(), teamA)
+	require.NoError(t, err)
+	assert.Equal(t, 13, authz.calls)
+}
@@ -14,9 +14,11 @@ import (
 	"k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	"k8s.io/apiserver/pkg/server/healthz"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/client-go/discovery"
 	clientrest "k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
+	"k8s.io/utils/clock"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/api/clusterpedia/install"
@@ -25,6 +27,7 @@ import (
 	"github.com/clusterpedia-io/clusterpedia/pkg/generated/clientset/versioned"
 	informers "github.com/clusterpedia-io/clusterpedia/pkg/generated/informers/externalversions"
 	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver"
+	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver/features"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 	"github.com/clusterpedia-io/clusterpedia/pkg/utils/filters"
 )
@@ -135,9 +138,21 @@ func (config completedConfig) New() (*ClusterPediaServer, error) {
 		return nil, err
 	}
 
+	var clustersResolver filters.AllowedClustersResolver
+	if utilfeature.DefaultFeatureGate.Enabled(features.ClusterAuthorization) {
+		clustersResolver = newAuthorizedClustersResolver(clock.RealClock{},
+			config.GenericConfig.Authorization.Authorizer,
+			clusterpediaInformerFactory.Cluster().V1alpha2().PediaClusters().Lister(),
+		)
+	}
+
 	handlerChainFunc := config.GenericConfig.BuildHandlerChainFunc
 	config.GenericConfig.BuildHandlerChainFunc = func(apiHandler http.Handler, c *genericapiserver.Config) http.Handler {
-		handler := handlerChainFunc(apiHandler, c)
+		if clustersResolver != nil {
+			apiHandler = filters.WithAllowedClusters(apiHandler, clustersResolver)
+		}
+		// the requester and the allowed clusters are populated inside the authentication filter
+		handler := handlerChainFunc(filters.WithRequester(apiHandler), c)
 		handler = filters.WithRequestQuery(handler)
 		handler = filters.WithAcceptHeader(handler)
 		return handler
@@ -0,0 +1,251 @@
+// Package querybuilder builds the url queries of the list requests of the clusterpedia apiserver,
+// and the internal list options parsed from the queries by the apiserver.
+//
+// The supported parameters:
+//
+//	names, clusters, namespaces   the comma separated names, clusters and namespaces of the resources
+//	orderby                       the comma separated fields, each field is optionally followed by ` desc`
+//	ownerUID                      the uid of the owner of the resources
+//	ownerName, ownerNamespace     the name and the namespace of the owner, the owner is matched by its own scope
+//	ownerGR                       the group resource of the owner, e.g. `deployments.apps`
+//	ownerSeniority                the seniority of the owner, e.g. 1 selects the pods owned by the replicasets of the deployment
+//	since, before                 the range of the creation timestamps of the resources
+//	limit, continue               the page size and the continue token of the previous page
+//	withContinue                  whether the continue token is returned
+//	withRemainingCount            whether the remaining count is returned
+//	onlyMetadata                  returns the metadata of the resources only
+//	labelSelector, fieldSelector  the label selector and the enhanced field selector
+//
+// The fuzzy names are sent by the label selector, and the other parameters of the storage are set by the Param,
+// e.g. the referencesTo of the internalstorage.
+package querybuilder
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/api/clusterpedia/scheme"
+	"github.com/clusterpedia-io/api/clusterpedia/v1beta1"
+)
+
+// SearchLabelFuzzyName selects the resources whose names contain the values,
+// it is the same as the fuzzy name label of the internalstorage.
+const SearchLabelFuzzyName = "internalstorage.clusterpedia.io/fuzzy-name"
+
+// Builder builds the url query by the fluent calls, the first invalid value is returned by the URLQuery and the ListOptions.
+type Builder struct {
+	query url.Values
+
+	labelSelector string
+	fuzzyNames    []string
+	orderBy       []string
+
+	err error
+}
+
+func New() *Builder {
+	return &Builder{query: url.Values{}}
+}
+
+func (b *Builder) Names(names ...string) *Builder {
+	return b.setList("names", names)
+}
+
+func (b *Builder) Clusters(clusters ...string) *Builder {
+	return b.setList("clusters", clusters)
+}
+
+func (b *Builder) Namespaces(namespaces ...string) *Builder {
+	return b.setList("namespaces", namespaces)
+}
+
+func (b *Builder) OwnerUID(uid string) *Builder {
+	return b.set("ownerUID", uid)
+}
+
+// OwnerName selects the resources owned by the owner of the name, the owner is matched by the OwnerGroupResource
+// and the OwnerNamespace if they are set.
+func (b *Builder) OwnerName(name string) *Builder {
+	return b.set("ownerName", name)
+}
+
+func (b *Builder) OwnerNamespace(namespace string) *Builder {
+	return b.set("ownerNamespace", namespace)
+}
+
+func (b *Builder) OwnerGroupResource(gr schema.GroupResource) *Builder {
+	return b.set("ownerGR", gr.String())
+}
+
+// Seniority selects the resources owned by the descendants of the owner, 0 means the owner itself.
+func (b *Builder) Seniority(seniority int) *Builder {
+	if seniority < 0 {
+		return b.fail(fmt.Errorf("invalid owner seniority %d: it must be non-negative", seniority))
+	}
+	return b.set("ownerSeniority", strconv.Itoa(seniority))
+}
+
+// OrderBy appends the ascending order of the field.
+func (b *Builder) OrderBy(field string) *Builder {
+	return b.appendOrderBy(field, false)
+}
+
+// OrderByDesc appends the descending order of the field.
+func (b *Builder) OrderByDesc(field string) *Builder {
+	return b.appendOrderBy(field, true)
+}
+
+func (b *Builder) Since(t time.Time) *Builder {
+	return b.set("since", t.UTC().Format(time.RFC3339))
+}
+
+func (b *Builder) Before(t time.Time) *Builder {
+	return b.set("before", t.UTC().Format(time.RFC3339))
+}
+
+func (b *Builder) Limit(limit int64) *Builder {
+	if limit < 0 {
+		return b.fail(fmt.Errorf("invalid limit %d: it must be non-negative", limit))
+	}
+	return b.set("limit", strconv.FormatInt(limit, 10))
+}
+
+func (b *Builder) Continue(token string) *Builder {
+	return b.set("continue", token)
+}
+
+func (b *Builder) WithContinue(with bool) *Builder {
+	return b.set("withContinue", strconv.FormatBool(with))
+}
+
+func (b *Builder) WithRemainingCount(with bool) *Builder {
+	return b.set("withRemainingCount", strconv.FormatBool(with))
+}
+
+func (b *Builder) OnlyMetadata() *Builder {
+	return b.set("onlyMetadata", "true")
+}
+
+// LabelSelector sets the label selector, the fuzzy names are added to it.
+func (b *Builder) LabelSelector(selector string) *Builder {
+	if _, err := labels.Parse(selector); err != nil {
+		return b.fail(fmt.Errorf("invalid label selector %q: %w", selector, err))
+	}
+	b.labelSelector = selector
+	return b
+}
+
+func (b *Builder) FieldSelector(selector string) *Builder {
+	return b.set("fieldSelector", selector)
+}
+
+// FuzzyNames selects the resources whose names contain all of the names.
+func (b *Builder) FuzzyNames(names ...string) *Builder {
+	b.fuzzyNames = append(b.fuzzyNames, names...)
+	return b
+}
+
+// Param sets the parameter which isn't covered by the builder, e.g. the parameters of the storage.
+func (b *Builder) Param(key string, values ...string) *Builder {
+	b.query[key] = append([]string(nil), values...)
+	return b
+}
+
+// URLQuery returns the url query of the list request.
+func (b *Builder) URLQuery() (url.Values, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	query := make(url.Values, len(b.query)+2)
+	for key, values := range b.query {
+		query[key] = append([]string(nil), values...)
+	}
+	if len(b.orderBy) != 0 {
+		query.Set("orderby", strings.Join(b.orderBy, ","))
+	}
+
+	selector := b.labelSelector
+	if len(b.fuzzyNames) != 0 {
+		requirement, err := labels.NewRequirement(SearchLabelFuzzyName, selection.In, b.fuzzyNames)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fuzzy names: %w", err)
+		}
+		if selector != "" {
+			selector += ","
+		}
+		selector += requirement.String()
+	}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	return query, nil
+}
+
+// Encode returns the encoded url query of the list request.
+func (b *Builder) Encode() (string, error) {
+	query, err := b.URLQuery()
+	if err != nil {
+		return "", err
+	}
+	return query.Encode(), nil
+}
+
+// ListOptions returns the list options parsed from the url query by the apiserver.
+func (b *Builder) ListOptions() (*internal.ListOptions, error) {
+	query, err := b.URLQuery()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &internal.ListOptions{}
+	if err := scheme.ParameterCodec.DecodeParameters(query, v1beta1.SchemeGroupVersion, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func (b *Builder) set(key, value string) *Builder {
+	b.query.Set(key, value)
+	return b
+}
+
+// setList sets the comma separated values, the values can't contain the commas.
+func (b *Builder) setList(key string, values []string) *Builder {
+	for _, value := range values {
+		if value == "" || strings.Contains(value, ",") {
+			return b.fail(fmt.Errorf("invalid %s %q: it must be non-empty without the commas", key, value))
+		}
+	}
+	if len(values) == 0 {
+		b.query.Del(key)
+		return b
+	}
+	return b.set(key, strings.Join(values, ","))
+}
+
+func (b *Builder) appendOrderBy(field string, desc bool) *Builder {
+	if field == "" || strings.ContainsAny(field, ", ") {
+		return b.fail(fmt.Errorf("invalid order by field %q: it must be non-empty without the commas and the spaces", field))
+	}
+	if desc {
+		field += " desc"
+	}
+	b.orderBy = append(b.orderBy, field)
+	return b
+}
+
+func (b *Builder) fail(err error) *Builder {
+	if b.err == nil {
+		b.err = err
+	}
+	return b
+}
@@ -0,0 +1,105 @@
+package querybuilder
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/api/clusterpedia/scheme"
+	"github.com/clusterpedia-io/api/clusterpedia/v1beta1"
+
+	storagequerybuilder "github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+func TestBuilderRoundTrip(t *testing.T) {
+	since := time.Date(2024, 1, 1, 8, 0, 0, 0, time.FixedZone("UTC+8", 8*3600))
+	before := since.Add(24 * time.Hour)
+	builder := New().
+		Names("web-1", "web-2").
+		Clusters("cluster-1", "cluster-2").
+		Namespaces("default").
+		OwnerName("web").
+		OwnerNamespace("default").
+		OwnerGroupResource(schema.GroupResource{Group: "apps", Resource: "deployments"}).
+		Seniority(1).
+		OrderBy("namespace").
+		OrderByDesc("created_at").
+		Since(since).
+		Before(before).
+		Limit(10).
+		Continue("20").
+		WithContinue(true).
+		WithRemainingCount(false).
+		OnlyMetadata().
+		LabelSelector("app=web").
+		FuzzyNames("web", "prod").
+		Param("referencesTo", "v1/secrets/default/foo")
+
+	encoded, err := builder.Encode()
+	require.NoError(t, err)
+	query, err := url.ParseQuery(encoded)
+	require.NoError(t, err)
+
+	// the options are parsed from the encoded query by the apiserver
+	var parsed internal.ListOptions
+	require.NoError(t, scheme.ParameterCodec.DecodeParameters(query, v1beta1.SchemeGroupVersion, &parsed))
+	options, err := builder.ListOptions()
+	require.NoError(t, err)
+	for _, opts := range []*internal.ListOptions{&parsed, options} {
+		assert.Equal(t, []string{"web-1", "web-2"}, opts.Names)
+		assert.Equal(t, []string{"cluster-1", "cluster-2"}, opts.ClusterNames)
+		assert.Equal(t, []string{"default"}, opts.Namespaces)
+		assert.Equal(t, "web", opts.OwnerName)
+		assert.Equal(t, "default", opts.OwnerNamespace)
+		assert.Equal(t, schema.GroupResource{Group: "apps", Resource: "deployments"}, opts.OwnerGroupResource)
+		assert.Equal(t, 1, opts.OwnerSeniority)
+		assert.Equal(t, []internal.OrderBy{{Field: "namespace"}, {Field: "created_at", Desc: true}}, opts.OrderBy)
+		require.NotNil(t, opts.Since)
+		assert.True(t, since.Equal(opts.Since.Time))
+		require.NotNil(t, opts.Before)
+		assert.True(t, before.Equal(opts.Before.Time))
+		assert.Equal(t, int64(10), opts.Limit)
+		assert.Equal(t, "20", opts.Continue)
+		assert.Equal(t, true, *opts.WithContinue)
+		assert.Equal(t, false, *opts.WithRemainingCount)
+		assert.True(t, opts.OnlyMetadata)
+		assert.Equal(t, "app=web", opts.LabelSelector.String())
+		assert.Equal(t, SearchLabelFuzzyName+" in (prod,web)", opts.ExtraLabelSelector.String())
+		assert.Equal(t, []string{"v1/secrets/default/foo"}, opts.URLQuery["referencesTo"])
+	}
+
+	owner, err := New().OwnerUID("uid-1").Seniority(0).ListOptions()
+	require.NoError(t, err)
+	assert.Equal(t, "uid-1", owner.OwnerUID)
+	assert.Zero(t, owner.OwnerSeniority)
+}
+
+func TestBuilderInvalidValues(t *testing.T) {
+	for name, builder := range map[string]*Builder{
+		"comma in clusters":     New().Clusters("cluster-1,cluster-2"),
+		"empty namespace":       New().Namespaces(""),
+		"negative seniority":    New().Seniority(-1),
+		"negative limit":        New().Limit(-1),
+		"space in order by":     New().OrderBy("name desc"),
+		"invalid selector":      New().LabelSelector("app in (web"),
+		"invalid fuzzy name":    New().FuzzyNames("web prod"),
+		"first error is kept":   New().Limit(-1).Clusters("cluster-1"),
+		"error after the valid": New().Clusters("cluster-1").Names("a,b"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := builder.URLQuery()
+			assert.Error(t, err)
+			_, err = builder.ListOptions()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSearchLabelFuzzyName(t *testing.T) {
+	assert.Equal(t, storagequerybuilder.SearchLabelFuzzyName, SearchLabelFuzzyName)
+}
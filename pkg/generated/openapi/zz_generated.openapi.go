@@ -942,6 +942,12 @@ func schema_clusterpedia_io_api_clusterpedia_v1beta1_ListOptions(ref common.Refe
 							Format: "",
 						},
 					},
+					"ownerNamespace": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
 					"since": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
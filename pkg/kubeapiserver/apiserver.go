@@ -137,6 +137,10 @@ func (c completedConfig) New(delegationTarget genericapiserver.DelegationTarget)
 	genericserver.Handler.NonGoRestfulMux.HandlePrefix("/api/", resourceHandler)
 	genericserver.Handler.NonGoRestfulMux.HandlePrefix("/apis/", resourceHandler)
 
+	if differ, ok := c.ExtraConfig.StorageFactory.(storage.ClusterDiffer); ok {
+		genericserver.Handler.NonGoRestfulMux.Handle(DiffPath, diffHandler(differ))
+	}
+
 	_ = NewClusterResourceController(restManager, discoveryManager, c.ExtraConfig.InformerFactory.Cluster().V1alpha2().PediaClusters())
 	return genericserver, nil
 }
@@ -0,0 +1,89 @@
+package kubeapiserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// DiffPath is the path of the diff of the resources of two clusters, which is served at
+// `/apis/clusterpedia.io/v1beta1/resources/diff` by the clusterpedia apiserver, e.g.
+// `?source=cluster-1&target=cluster-2&resources=deployments.apps&namespaceMapping=team-a=team-b&compare=true`.
+const DiffPath = "/diff"
+
+func diffHandler(differ storage.ClusterDiffer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		opts, err := parseDiffOptions(req.URL.Query())
+		if err != nil {
+			responsewriters.ErrorNegotiated(apierrors.NewBadRequest(err.Error()), Codecs, schema.GroupVersion{}, w, req)
+			return
+		}
+
+		report, err := differ.DiffClusters(req.Context(), opts)
+		if err != nil {
+			responsewriters.ErrorNegotiated(err, Codecs, schema.GroupVersion{}, w, req)
+			return
+		}
+		responsewriters.WriteRawJSON(http.StatusOK, report, w)
+	})
+}
+
+// parseDiffOptions parses the options of the diff from the url query, the resources are comma-separated
+// `<resource>.<version>.<group>` or `<resource>.<group>`, and the namespace mapping is comma-separated `<source>=<target>`.
+func parseDiffOptions(query url.Values) (storage.DiffOptions, error) {
+	opts := storage.DiffOptions{
+		Source:   query.Get("source"),
+		Target:   query.Get("target"),
+		Continue: query.Get("continue"),
+	}
+
+	for _, value := range query["resources"] {
+		for _, arg := range strings.Split(value, ",") {
+			if arg = strings.TrimSpace(arg); arg == "" {
+				continue
+			}
+			if gvr, gr := schema.ParseResourceArg(arg); gvr != nil {
+				opts.Resources = append(opts.Resources, *gvr)
+			} else {
+				opts.Resources = append(opts.Resources, gr.WithVersion(""))
+			}
+		}
+	}
+
+	for _, value := range query["namespaceMapping"] {
+		for _, mapping := range strings.Split(value, ",") {
+			if mapping = strings.TrimSpace(mapping); mapping == "" {
+				continue
+			}
+			source, target, ok := strings.Cut(mapping, "=")
+			if !ok || source == "" || target == "" {
+				return opts, fmt.Errorf("invalid namespace mapping %q, the mapping should be `<source>=<target>`", mapping)
+			}
+			if opts.NamespaceMapping == nil {
+				opts.NamespaceMapping = make(map[string]string)
+			}
+			opts.NamespaceMapping[source] = target
+		}
+	}
+
+	var err error
+	if value := query.Get("compare"); value != "" {
+		if opts.Compare, err = strconv.ParseBool(value); err != nil {
+			return opts, fmt.Errorf("invalid compare %q: %w", value, err)
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if opts.Limit, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return opts, fmt.Errorf("invalid limit %q: %w", value, err)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,41 @@
+package kubeapiserver
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestParseDiffOptions(t *testing.T) {
+	query, err := url.ParseQuery("source=cluster-1&target=cluster-2&resources=deployments.v1.apps,pods&resources=jobs.batch" +
+		"&namespaceMapping=team-a=team-b,team-c=team-d&compare=true&limit=10&continue=abc")
+	require.NoError(t, err)
+
+	opts, err := parseDiffOptions(query)
+	require.NoError(t, err)
+	assert.Equal(t, storage.DiffOptions{
+		Source: "cluster-1",
+		Target: "cluster-2",
+		Resources: []schema.GroupVersionResource{
+			{Group: "apps", Version: "v1", Resource: "deployments"},
+			{Resource: "pods"},
+			{Group: "batch", Resource: "jobs"},
+		},
+		NamespaceMapping: map[string]string{"team-a": "team-b", "team-c": "team-d"},
+		Compare:          true,
+		Limit:            10,
+		Continue:         "abc",
+	}, opts)
+
+	for _, invalid := range []string{"namespaceMapping=team-a", "namespaceMapping==team-b", "compare=yes", "limit=ten"} {
+		query, err := url.ParseQuery(invalid)
+		require.NoError(t, err)
+		_, err = parseDiffOptions(query)
+		assert.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,39 @@
+package features
+
+import (
+	"k8s.io/apimachinery/pkg/util/runtime"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/component-base/featuregate"
+)
+
+const (
+	// StreamingListResponse is a feature gate for the apiserver to stream the json response of the list request,
+	// the resources are decoded from the storage and encoded to the response one by one,
+	// instead of being materialized in the list object before encoding.
+	//
+	// The Table and PartialObjectMetadataList responses are not streamed,
+	// they are converted from the whole list.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	StreamingListResponse featuregate.Feature = "StreamingListResponse"
+
+	// ClusterAuthorization is a feature gate for the apiserver to restrict the requester to the clusters
+	// whose PediaClusters the requester is allowed to get by the authorizer, e.g. the RBAC.
+	// The queries of all clusters are narrowed to the allowed clusters, and the queries naming the other clusters are forbidden.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	ClusterAuthorization featuregate.Feature = "ClusterAuthorization"
+)
+
+func init() {
+	runtime.Must(utilfeature.DefaultMutableFeatureGate.Add(defaultKubeAPIServerFeatureGates))
+}
+
+// defaultKubeAPIServerFeatureGates consists of all known kube apiserver-specific feature keys.
+// To add a new feature, define a key for it above and add it here.
+var defaultKubeAPIServerFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
+	StreamingListResponse: {Default: false, PreRelease: featuregate.Alpha},
+	ClusterAuthorization:  {Default: false, PreRelease: featuregate.Alpha},
+}
@@ -3,6 +3,7 @@ package kubeapiserver
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -12,12 +13,15 @@ import (
 	"k8s.io/apiserver/pkg/endpoints/handlers"
 	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
 	genericrequest "k8s.io/apiserver/pkg/endpoints/request"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 	"k8s.io/apiserver/pkg/warning"
 	"k8s.io/klog/v2"
 
 	clusterv1alpha2 "github.com/clusterpedia-io/api/cluster/v1alpha2"
 	clusterlister "github.com/clusterpedia-io/clusterpedia/pkg/generated/listers/cluster/v1alpha2"
 	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver/discovery"
+	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver/features"
+	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver/resourcerest"
 	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
 )
 
@@ -126,8 +130,20 @@ func (r *ResourceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			return
 		}
 
+		if revisions, _ := strconv.ParseBool(req.URL.Query().Get(resourcerest.URLQueryRevisions)); revisions {
+			handler = revisionsHandler(storage, requestInfo.Name, gvr.GroupVersion())
+			break
+		}
 		handler = handlers.GetResource(storage, reqScope)
 	case "list":
+		if aggregate := req.URL.Query().Get(resourcerest.URLQueryAggregate); aggregate != "" {
+			handler = aggregatedListHandler(storage, aggregate, gvr.GroupVersion())
+			break
+		}
+		if utilfeature.DefaultFeatureGate.Enabled(features.StreamingListResponse) && storage.Streamable() && streamable(req) {
+			handler = streamListHandler(storage, reqScope)
+			break
+		}
 		handler = handlers.ListResource(storage, nil, reqScope, false, r.minRequestTimeout)
 	case "watch":
 		handler = handlers.ListResource(storage, storage, reqScope, true, r.minRequestTimeout)
@@ -142,3 +158,34 @@ func (r *ResourceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		handler.ServeHTTP(w, req)
 	}
 }
+
+// aggregatedListHandler serves the distinct namespaces or clusters of the resources, or the summed resource requests of the pods,
+// so the clients don't have to list all the resources to collect them.
+func aggregatedListHandler(storage *resourcerest.RESTStorage, aggregate string, gv schema.GroupVersion) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var list interface{}
+		var err error
+		if aggregate == resourcerest.AggregateResourceRequests {
+			list, err = storage.SumResourceRequests(req.Context())
+		} else {
+			list, err = storage.ListAggregated(req.Context(), aggregate)
+		}
+		if err != nil {
+			responsewriters.ErrorNegotiated(err, Codecs, gv, w, req)
+			return
+		}
+		responsewriters.WriteRawJSON(http.StatusOK, list, w)
+	})
+}
+
+// revisionsHandler serves the retained revisions of the object whose history is enabled.
+func revisionsHandler(storage *resourcerest.RESTStorage, name string, gv schema.GroupVersion) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		list, err := storage.ListRevisions(req.Context(), name)
+		if err != nil {
+			responsewriters.ErrorNegotiated(err, Codecs, gv, w, req)
+			return
+		}
+		responsewriters.WriteRawJSON(http.StatusOK, list, w)
+	})
+}
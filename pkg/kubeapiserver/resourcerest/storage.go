@@ -3,6 +3,9 @@ package resourcerest
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
@@ -37,6 +40,44 @@ type RESTStorage struct {
 	TableConvertor rest.TableConvertor
 }
 
+// URLQueryAggregate is the url query of the list request to return the distinct namespaces or clusters
+// of the matched resources instead of the resources.
+const URLQueryAggregate = "aggregate"
+
+const (
+	AggregateNamespaces = "namespaces"
+	AggregateClusters   = "clusters"
+
+	// AggregateResourceRequests sums the resource requests of the containers of the matched pods
+	// by the clusters and namespaces.
+	AggregateResourceRequests = "resourceRequests"
+)
+
+// URLQueryAt is the url query of the get request to get the object as it was at the time, which is formatted by RFC3339.
+// The URLQueryRevisions of the get request lists the retained revisions of the object instead of the object.
+const (
+	URLQueryAt        = "at"
+	URLQueryRevisions = "revisions"
+)
+
+// AggregatedList is the response of the aggregated list request.
+type AggregatedList struct {
+	Items []string `json:"items"`
+
+	// Continue is set when there may be more items, it is passed by the continue url query of the next request.
+	Continue string `json:"continue,omitempty"`
+}
+
+// ResourceRequestsList is the response of the resource requests aggregated list request.
+type ResourceRequestsList struct {
+	Items []storage.ResourceRequestsSummary `json:"items"`
+}
+
+// RevisionList is the response of the revisions request.
+type RevisionList struct {
+	Items []storage.ResourceRevision `json:"items"`
+}
+
 var _ rest.Lister = &RESTStorage{}
 var _ rest.Getter = &RESTStorage{}
 var _ rest.Watcher = &RESTStorage{}
@@ -61,12 +102,62 @@ func (s *RESTStorage) Get(ctx context.Context, name string, _ *metav1.GetOptions
 	}
 
 	obj := s.New()
+	if at := request.RequestQueryFrom(ctx).Get(URLQueryAt); at != "" {
+		reader, ok := s.Storage.(storage.ResourceHistoryReader)
+		if !ok {
+			return nil, apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "get at")
+		}
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid %s %q: %v", URLQueryAt, at, err))
+		}
+		if err := reader.GetAt(ctx, clusterName, requestInfo.Namespace, name, t, obj); err != nil {
+			return nil, interpretGetError(err, s.DefaultQualifiedResource, name)
+		}
+		return obj, nil
+	}
+
+	// the PartialObjectMetadata is converted from the returned object by the handler, so only the metadata is read
+	if getter, ok := s.Storage.(storage.ResourceMetadataGetter); ok && s.acceptsPartialObjectMetadata(ctx, "PartialObjectMetadata") {
+		partial := &metav1.PartialObjectMetadata{}
+		if err := getter.GetMetadata(ctx, clusterName, requestInfo.Namespace, name, partial); err != nil {
+			return nil, interpretGetError(err, s.DefaultQualifiedResource, name)
+		}
+		return partial, nil
+	}
+
 	if err := s.Storage.Get(ctx, clusterName, requestInfo.Namespace, name, obj); err != nil {
-		return nil, storeerr.InterpretGetError(err, s.DefaultQualifiedResource, name)
+		return nil, interpretGetError(err, s.DefaultQualifiedResource, name)
 	}
 	return obj, nil
 }
 
+// ListRevisions returns the retained revisions of the object, the newest first.
+func (s *RESTStorage) ListRevisions(ctx context.Context, name string) (*RevisionList, error) {
+	reader, ok := s.Storage.(storage.ResourceHistoryReader)
+	if !ok {
+		return nil, apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "revisions")
+	}
+
+	clusterName := request.ClusterNameValue(ctx)
+	if clusterName == "" {
+		return nil, errors.New("missing cluster")
+	}
+	requestInfo, ok := genericrequest.RequestInfoFrom(ctx)
+	if !ok {
+		return nil, errors.New("missing RequestInfo")
+	}
+
+	items, err := reader.ListRevisions(ctx, clusterName, requestInfo.Namespace, name)
+	if err != nil {
+		return nil, interpretGetError(err, s.DefaultQualifiedResource, name)
+	}
+	if items == nil {
+		items = []storage.ResourceRevision{}
+	}
+	return &RevisionList{Items: items}, nil
+}
+
 func (s *RESTStorage) resolveListOptions(ctx context.Context) (*internal.ListOptions, error) {
 	options := &internal.ListOptions{}
 	query := request.RequestQueryFrom(ctx)
@@ -90,6 +181,9 @@ func (s *RESTStorage) resolveListOptions(ctx context.Context) (*internal.ListOpt
 	if (options.OwnerUID != "" || options.OwnerName != "") && len(options.ClusterNames) != 1 {
 		return nil, apierrors.NewBadRequest("If searching by owner uid or name, then the cluster must be specified")
 	}
+	if options.OwnerNamespace != "" && options.OwnerName == "" {
+		return nil, apierrors.NewBadRequest("The owner namespace is only used to search by the owner name")
+	}
 
 	if options.WithRemainingCount == nil {
 		if enabled := utilfeature.DefaultFeatureGate.Enabled(genericfeatures.RemainingItemCount); enabled {
@@ -97,16 +191,26 @@ func (s *RESTStorage) resolveListOptions(ctx context.Context) (*internal.ListOpt
 		}
 	}
 
-	if accept := request.AcceptHeaderFrom(ctx); accept != "" {
-		if mediaType, ok := negotiation.NegotiateMediaTypeOptions(accept, s.Serializer.SupportedMediaTypes(), negotiation.PartialObjectMetadataEndpointRestrictions); ok {
-			if target := mediaType.Convert; target != nil && target.Kind == "PartialObjectMetadataList" {
-				options.OnlyMetadata = true
-			}
-		}
+	if s.acceptsPartialObjectMetadata(ctx, "PartialObjectMetadataList") {
+		options.OnlyMetadata = true
 	}
 	return options, nil
 }
 
+// acceptsPartialObjectMetadata returns true if the request accepts the PartialObjectMetadata or the PartialObjectMetadataList.
+func (s *RESTStorage) acceptsPartialObjectMetadata(ctx context.Context, kind string) bool {
+	accept := request.AcceptHeaderFrom(ctx)
+	if accept == "" {
+		return false
+	}
+	mediaType, ok := negotiation.NegotiateMediaTypeOptions(accept, s.Serializer.SupportedMediaTypes(), negotiation.PartialObjectMetadataEndpointRestrictions)
+	if !ok {
+		return false
+	}
+	target := mediaType.Convert
+	return target != nil && target.Kind == kind
+}
+
 func (s *RESTStorage) List(ctx context.Context, _ *metainternalversion.ListOptions) (runtime.Object, error) {
 	options, err := s.resolveListOptions(ctx)
 	if err != nil {
@@ -115,11 +219,94 @@ func (s *RESTStorage) List(ctx context.Context, _ *metainternalversion.ListOptio
 
 	objs := s.NewList()
 	if err := s.Storage.List(ctx, objs, options); err != nil {
-		return nil, storeerr.InterpretListError(err, s.DefaultQualifiedResource)
+		return nil, interpretListError(err, s.DefaultQualifiedResource)
 	}
 	return objs, nil
 }
 
+// ListAggregated returns the distinct namespaces or clusters of the resources matched by the list request.
+func (s *RESTStorage) ListAggregated(ctx context.Context, aggregate string) (*AggregatedList, error) {
+	aggregator, ok := s.Storage.(storage.ResourceAggregator)
+	if !ok {
+		return nil, apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "aggregate")
+	}
+
+	options, err := s.resolveListOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []string
+	switch aggregate {
+	case AggregateNamespaces:
+		items, err = aggregator.ListNamespaces(ctx, options)
+	case AggregateClusters:
+		items, err = aggregator.ListClusters(ctx, options)
+	default:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("unsupported aggregate %q, only %q, %q and %q are supported", aggregate, AggregateNamespaces, AggregateClusters, AggregateResourceRequests))
+	}
+	if err != nil {
+		return nil, interpretListError(err, s.DefaultQualifiedResource)
+	}
+
+	list := &AggregatedList{Items: items}
+	if list.Items == nil {
+		list.Items = []string{}
+	}
+	if options.Limit > 0 && int64(len(items)) == options.Limit {
+		offset, _ := strconv.ParseInt(options.Continue, 10, 64)
+		list.Continue = strconv.FormatInt(offset+options.Limit, 10)
+	}
+	return list, nil
+}
+
+// SumResourceRequests returns the resource requests of the containers of the pods matched by the list request,
+// summed by the clusters and namespaces.
+func (s *RESTStorage) SumResourceRequests(ctx context.Context) (*ResourceRequestsList, error) {
+	aggregator, ok := s.Storage.(storage.ResourceRequestsAggregator)
+	if !ok {
+		return nil, apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "aggregate")
+	}
+
+	options, err := s.resolveListOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := aggregator.SumResourceRequests(ctx, options)
+	if err != nil {
+		return nil, interpretListError(err, s.DefaultQualifiedResource)
+	}
+	if items == nil {
+		items = []storage.ResourceRequestsSummary{}
+	}
+	return &ResourceRequestsList{Items: items}, nil
+}
+
+// Streamable returns true if the storage supports listing the resources by the stream.
+func (s *RESTStorage) Streamable() bool {
+	_, ok := s.Storage.(storage.ResourceStreamer)
+	return ok
+}
+
+// ListStream lists the resources like List, but the objects are passed to the visitor one by one.
+func (s *RESTStorage) ListStream(ctx context.Context, visitor storage.ListVisitor) error {
+	streamer, ok := s.Storage.(storage.ResourceStreamer)
+	if !ok {
+		return apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "list stream")
+	}
+
+	options, err := s.resolveListOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := streamer.ListStream(ctx, s.NewFunc, options, visitor); err != nil {
+		return interpretListError(err, s.DefaultQualifiedResource)
+	}
+	return nil
+}
+
 func (s *RESTStorage) Watch(ctx context.Context, _ *metainternalversion.ListOptions) (watch.Interface, error) {
 	options, err := s.resolveListOptions(ctx)
 	if err != nil {
@@ -130,6 +317,9 @@ func (s *RESTStorage) Watch(ctx context.Context, _ *metainternalversion.ListOpti
 	if apierrors.IsMethodNotSupported(err) {
 		return nil, apierrors.NewMethodNotSupported(s.DefaultQualifiedResource, "watch")
 	}
+	if err != nil {
+		return nil, err
+	}
 	return inter, nil
 }
 
@@ -140,3 +330,20 @@ func (s *RESTStorage) ConvertToTable(ctx context.Context, object runtime.Object,
 
 	return printers.NewDefaultTableConvertor(s.DefaultQualifiedResource).ConvertToTable(ctx, object, tableOptions)
 }
+
+// interpretGetError converts the error of the storage to the status of the get, the NotFound of the storage
+// wraps the sentinel, which isn't recognized by the storeerr.
+func interpretGetError(err error, qualifiedResource schema.GroupResource, name string) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierrors.NewNotFound(qualifiedResource, name)
+	}
+	return storeerr.InterpretGetError(err, qualifiedResource, name)
+}
+
+// interpretListError converts the error of the storage to the status of the list like the interpretGetError.
+func interpretListError(err error, qualifiedResource schema.GroupResource) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return apierrors.NewNotFound(qualifiedResource, "")
+	}
+	return storeerr.InterpretListError(err, qualifiedResource)
+}
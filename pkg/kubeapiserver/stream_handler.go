@@ -0,0 +1,132 @@
+package kubeapiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/kubeapiserver/resourcerest"
+)
+
+// flushInterval is the number of the objects written between the flushes of the response
+const flushInterval = 100
+
+// streamable returns true if the list response can be streamed, only the plain json response is streamed,
+// the response negotiated with the parameters, e.g. `as=Table`, is converted from the whole list.
+func streamable(req *http.Request) bool {
+	if req.URL.Query().Has("pretty") {
+		return false
+	}
+
+	accept := strings.TrimSpace(strings.Split(req.Header.Get("Accept"), ",")[0])
+	if accept == "" {
+		return true
+	}
+
+	mediaType, params, err := mime.ParseMediaType(accept)
+	if err != nil {
+		return false
+	}
+	delete(params, "q")
+	if len(params) != 0 {
+		return false
+	}
+	switch mediaType {
+	case runtime.ContentTypeJSON, "application/*", "*/*":
+		return true
+	}
+	return false
+}
+
+func streamListHandler(storage *resourcerest.RESTStorage, scope *handlers.RequestScope) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := runtime.SerializerInfoForMediaType(scope.Serializer.SupportedMediaTypes(), runtime.ContentTypeJSON)
+		if !ok {
+			responsewriters.ErrorNegotiated(fmt.Errorf("no serializer for %s", runtime.ContentTypeJSON), scope.Serializer, scope.Kind.GroupVersion(), w, req)
+			return
+		}
+
+		writer := &streamListWriter{
+			w:       w,
+			encoder: scope.Serializer.EncoderForVersion(info.Serializer, scope.Kind.GroupVersion()),
+			listGVK: scope.Kind.GroupVersion().WithKind(scope.Kind.Kind + "List"),
+		}
+		err := storage.ListStream(req.Context(), writer)
+		if !writer.started {
+			if err != nil {
+				responsewriters.ErrorNegotiated(err, scope.Serializer, scope.Kind.GroupVersion(), w, req)
+			}
+			return
+		}
+
+		if err != nil {
+			// the status has been written, the response is left incomplete,
+			// so the client fails to decode it instead of receiving a truncated list.
+			klog.ErrorS(err, "Failed to stream the list response", "resource", scope.Resource)
+			return
+		}
+		writer.finish()
+	})
+}
+
+// streamListWriter writes the list in json, the list meta is written before the items.
+type streamListWriter struct {
+	w       http.ResponseWriter
+	encoder runtime.Encoder
+	listGVK schema.GroupVersionKind
+
+	started bool
+	items   int
+}
+
+func (sw *streamListWriter) VisitListMeta(meta metav1.ListMeta) error {
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	sw.w.Header().Set("Content-Type", runtime.ContentTypeJSON)
+	sw.w.WriteHeader(http.StatusOK)
+	sw.started = true
+
+	_, err = fmt.Fprintf(sw.w, `{"kind":%q,"apiVersion":%q,"metadata":%s,"items":[`, sw.listGVK.Kind, sw.listGVK.GroupVersion().String(), metadata)
+	return err
+}
+
+func (sw *streamListWriter) VisitObject(obj runtime.Object) error {
+	if sw.items > 0 {
+		if _, err := io.WriteString(sw.w, ","); err != nil {
+			return err
+		}
+	}
+	if err := sw.encoder.Encode(obj, sw.w); err != nil {
+		return err
+	}
+
+	sw.items++
+	if sw.items%flushInterval == 0 {
+		sw.flush()
+	}
+	return nil
+}
+
+func (sw *streamListWriter) finish() {
+	_, _ = io.WriteString(sw.w, "]}\n")
+	sw.flush()
+}
+
+func (sw *streamListWriter) flush() {
+	if flusher, ok := sw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,70 @@
+package kubeapiserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/json"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/scheme"
+)
+
+func TestStreamable(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		expect bool
+	}{
+		{"/apis/apps/v1/deployments", "", true},
+		{"/apis/apps/v1/deployments", "application/json", true},
+		{"/apis/apps/v1/deployments", "application/json;q=0.9, application/yaml", true},
+		{"/apis/apps/v1/deployments", "*/*", true},
+		{"/apis/apps/v1/deployments?pretty=true", "application/json", false},
+		{"/apis/apps/v1/deployments", "application/json;as=Table;v=v1;g=meta.k8s.io,application/json", false},
+		{"/apis/apps/v1/deployments", "application/vnd.kubernetes.protobuf", false},
+		{"/apis/apps/v1/deployments", "application/yaml", false},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		req.Header.Set("Accept", test.accept)
+		assert.Equal(t, test.expect, streamable(req), "%s %s", test.url, test.accept)
+	}
+}
+
+func TestStreamListWriter(t *testing.T) {
+	info, ok := runtime.SerializerInfoForMediaType(scheme.LegacyResourceCodecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	require.True(t, ok)
+
+	recorder := httptest.NewRecorder()
+	writer := &streamListWriter{
+		w:       recorder,
+		encoder: scheme.LegacyResourceCodecs.EncoderForVersion(info.Serializer, appsv1.SchemeGroupVersion),
+		listGVK: appsv1.SchemeGroupVersion.WithKind("DeploymentList"),
+	}
+
+	remain := int64(1)
+	require.NoError(t, writer.VisitListMeta(metav1.ListMeta{Continue: "2", RemainingItemCount: &remain}))
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, writer.VisitObject(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name}}))
+	}
+	writer.finish()
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, runtime.ContentTypeJSON, recorder.Header().Get("Content-Type"))
+
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), list))
+	assert.Equal(t, "DeploymentList", list.Kind)
+	assert.Equal(t, "apps/v1", list.APIVersion)
+	assert.Equal(t, "2", list.Continue)
+	assert.Equal(t, &remain, list.RemainingItemCount)
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, "a", list.Items[0].Name)
+	assert.Equal(t, "Deployment", list.Items[1].Kind)
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+)
+
+// The sentinels of the causes of the errors returned by the ResourceStorage, the storage layers wrap the errors
+// with the sentinels of their causes, so the callers, e.g. the synchro, branch on errors.Is instead of the messages.
+//
+// The error whose cause is unknown wraps none of them, and the caller should not retry it. The errors which are
+// the api statuses for the requesters, e.g. the Forbidden cluster, are returned as is.
+var (
+	// ErrNotFound is the cause of the error if the object doesn't exist.
+	ErrNotFound = errors.New("storage: object not found")
+
+	// ErrConflict is the cause of the error if the object already exists, e.g. the write violates the unique key of the storage.
+	// The violations of the other constraints, e.g. the not null, aren't conflicts.
+	ErrConflict = errors.New("storage: conflict")
+
+	// ErrTooLarge is the cause of the error if the object or the query exceeds the limits of the storage.
+	ErrTooLarge = errors.New("storage: too large")
+
+	// ErrTransient is the cause of the error if the storage is temporarily unavailable, e.g. the lost connection,
+	// the timeout or the deadlock, the operation can be retried. The recoverable exceptions are always transient.
+	ErrTransient = errors.New("storage: transient")
+
+	// ErrSchemaOutdated is the cause of the error if the schema of the storage is older than the one required
+	// by the operation, e.g. the missing column, the operation fails until the migrations are applied.
+	ErrSchemaOutdated = errors.New("storage: schema outdated")
+
+	// ErrFenced is the cause of the error if the writer doesn't hold the lease of the cluster, e.g. the lease
+	// is expired or acquired by another writer, the writes are accepted again once the lease is acquired.
+	ErrFenced = errors.New("storage: fenced")
+
+	// ErrWriteDropped is the cause of the error if the writes accepted by the storage which writes the objects
+	// asynchronously are dropped by the errors, the writer should relist the resources to correct them.
+	ErrWriteDropped = errors.New("storage: write dropped")
+)
+
+// Error is the error of the storage layer wrapped with the sentinel of its cause,
+// it keeps the text of the original error.
+type Error struct {
+	Cause error
+	Err   error
+}
+
+func NewError(cause, err error) error {
+	return &Error{Cause: cause, Err: err}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Cause
+}
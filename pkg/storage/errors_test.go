@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	genericstorage "k8s.io/apiserver/pkg/storage"
+)
+
+func TestErrorContract(t *testing.T) {
+	notFound := genericstorage.NewKeyNotFoundError("cluster-1/default/foo", 0)
+	err := fmt.Errorf("get: %w", NewError(ErrNotFound, notFound))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotErrorIs(t, err, ErrConflict)
+	assert.Equal(t, "get: "+notFound.Error(), err.Error())
+
+	// the original error is still reachable by the callers which check its type
+	var storageErr *genericstorage.StorageError
+	assert.ErrorAs(t, err, &storageErr)
+	assert.True(t, genericstorage.IsNotFound(storageErr))
+
+	// the recoverable exceptions are always transient
+	err = NewRecoverableException(errors.New("connection refused"))
+	assert.ErrorIs(t, err, ErrTransient)
+	assert.True(t, IsRecoverableException(NewError(ErrTransient, err)))
+
+	for _, sentinel := range []error{ErrNotFound, ErrConflict, ErrTooLarge, ErrTransient, ErrSchemaOutdated, ErrFenced} {
+		assert.NotErrorIs(t, errors.New("unknown"), sentinel)
+	}
+}
@@ -0,0 +1,66 @@
+package internalstorage
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+var _ storage.ResourceAggregator = &ResourceStorage{}
+
+func (s *ResourceStorage) ListNamespaces(ctx context.Context, opts *internal.ListOptions) ([]string, error) {
+	return s.listDistinct(ctx, "namespace", opts)
+}
+
+func (s *ResourceStorage) ListClusters(ctx context.Context, opts *internal.ListOptions) ([]string, error) {
+	return s.listDistinct(ctx, "cluster", opts)
+}
+
+// listDistinct returns the distinct values of the column of the resources matched by the list options,
+// the values are sorted by the column, and the order by of the list options is ignored.
+func (s *ResourceStorage) listDistinct(ctx context.Context, column string, opts *internal.ListOptions) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := s.queryLimit.validateListOptions(opts); err != nil {
+		return nil, err
+	}
+
+	opts = s.clusterAliases.resolveListOptions(opts).DeepCopy()
+	opts.OrderBy = nil
+	opts.WithRemainingCount = nil
+
+	version, err := s.listVersion(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	query := querySplitObjects(s.queryWithLabelSelector(s.db.WithContext(ctx).Model(&Resource{}), false), s.splitObject).Where(map[string]interface{}{
+		"group":    s.storageGroupResource.Group,
+		"version":  version,
+		"resource": s.storageGroupResource.Resource,
+	})
+	if column == "namespace" {
+		query = query.Where("namespace <> ?", "")
+	}
+	_, _, query, err = applyListOptionsToResourceQuery(s.db, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	if result := query.Distinct().Order(column).Pluck(column, &values); result.Error != nil {
+		return nil, InterpretDBError(s.storageGroupResource.String(), result.Error)
+	}
+	setSpanAttributes(ctx, attribute.Int("count", len(values)))
+	return values, nil
+}
@@ -0,0 +1,76 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestResourceStorageAggregation(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	resources := []struct {
+		cluster   string
+		resource  string
+		namespace string
+	}{
+		{"cluster-2", "pods", "kube-system"},
+		{"cluster-1", "pods", "default"},
+		{"cluster-1", "pods", "default"},
+		{"cluster-1", "pods", "kube-system"},
+		{"cluster-3", "pods", "monitoring"},
+		// the other resource
+		{"cluster-4", "configmaps", "other"},
+	}
+	for i, r := range resources {
+		require.NoError(t, db.Create(&Resource{
+			Cluster:         r.cluster,
+			Version:         "v1",
+			Resource:        r.resource,
+			Kind:            "Kind",
+			Namespace:       r.namespace,
+			Name:            fmt.Sprintf("resource-%d", i),
+			UID:             types.UID(fmt.Sprintf("uid-%d", i)),
+			ResourceVersion: "1",
+			Object:          []byte("{}"),
+		}).Error)
+	}
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("pods"))
+	namespaces, err := rs.ListNamespaces(context.Background(), &internal.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "kube-system", "monitoring"}, namespaces)
+
+	namespaces, err = rs.ListNamespaces(context.Background(), &internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "kube-system"}, namespaces)
+
+	clusters, err := rs.ListClusters(context.Background(), &internal.ListOptions{Namespaces: []string{"kube-system"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, clusters)
+
+	// paginated by the limit and continue
+	clusters, err = rs.ListClusters(context.Background(), &internal.ListOptions{ListOptions: metainternal.ListOptions{Limit: 2}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, clusters)
+
+	clusters, err = rs.ListClusters(context.Background(), &internal.ListOptions{ListOptions: metainternal.ListOptions{Limit: 2, Continue: "2"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-3"}, clusters)
+
+	// the cluster-scoped resources have no namespaces
+	rs = newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("nodes"))
+	namespaces, err = rs.ListNamespaces(context.Background(), &internal.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, namespaces)
+}
@@ -0,0 +1,50 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	clusterv1alpha2 "github.com/clusterpedia-io/api/cluster/v1alpha2"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+// allowedClusterNames restricts the cluster names of the query by the clusters allowed for the requester in the context,
+// the query of all clusters is narrowed to the allowed clusters, and the query naming a forbidden cluster is rejected.
+// The restricted is false if the requester is allowed to access all clusters, then the cluster names are returned as is.
+func allowedClusterNames(ctx context.Context, clusterNames []string) (_ []string, restricted bool, _ error) {
+	allowed, ok := request.AllowedClustersFrom(ctx)
+	if !ok {
+		return clusterNames, false, nil
+	}
+
+	if len(clusterNames) == 0 {
+		return sets.List(allowed), true, nil
+	}
+	for _, cluster := range clusterNames {
+		if err := checkAllowedCluster(allowed, cluster); err != nil {
+			return nil, true, err
+		}
+	}
+	return clusterNames, true, nil
+}
+
+// checkClusterAllowed returns Forbidden if the cluster isn't allowed for the requester in the context.
+func checkClusterAllowed(ctx context.Context, cluster string) error {
+	allowed, ok := request.AllowedClustersFrom(ctx)
+	if !ok {
+		return nil
+	}
+	return checkAllowedCluster(allowed, cluster)
+}
+
+func checkAllowedCluster(allowed sets.Set[string], cluster string) error {
+	if allowed.Has(cluster) {
+		return nil
+	}
+	return apierrors.NewForbidden(schema.GroupResource{Group: clusterv1alpha2.GroupName, Resource: "pediaclusters"}, cluster,
+		fmt.Errorf("the requester isn't allowed to access the resources of the cluster"))
+}
@@ -0,0 +1,85 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+func TestResourceStorage_AllowedClusters(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	for i, cluster := range []string{"cluster-1", "cluster-2", "cluster-3"} {
+		require.NoError(t, rs.Create(context.Background(), cluster, &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy", UID: types.UID(fmt.Sprint(i)), ResourceVersion: "1"},
+		}))
+	}
+
+	list := func(ctx context.Context, clusters ...string) ([]string, error) {
+		list := &appsv1.DeploymentList{}
+		if err := rs.List(ctx, list, &internal.ListOptions{ClusterNames: clusters}); err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, item := range list.Items {
+			var resource Resource
+			require.NoError(t, db.Where("uid = ?", item.UID).First(&resource).Error)
+			names = append(names, resource.Cluster)
+		}
+		return names, nil
+	}
+
+	// the requester without the allowed clusters can access all clusters
+	clusters, err := list(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-2", "cluster-3"}, clusters)
+
+	ctx := request.WithAllowedClusters(context.Background(), sets.New("cluster-1", "cluster-2"))
+
+	// the query of all clusters is narrowed silently
+	clusters, err = list(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-1", "cluster-2"}, clusters)
+
+	clusters, err = list(ctx, "cluster-2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cluster-2"}, clusters)
+
+	_, err = list(ctx, "cluster-1", "cluster-3")
+	assert.True(t, apierrors.IsForbidden(err))
+
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "deploy", &appsv1.Deployment{}))
+	err = rs.Get(ctx, "cluster-3", "default", "deploy", &appsv1.Deployment{})
+	assert.True(t, apierrors.IsForbidden(err))
+
+	namespaces, err := rs.ListNamespaces(ctx, &internal.ListOptions{ClusterNames: []string{"cluster-3"}})
+	assert.True(t, apierrors.IsForbidden(err))
+	assert.Empty(t, namespaces)
+
+	// the requester allowed to access no clusters lists nothing
+	ctx = request.WithAllowedClusters(context.Background(), sets.New[string]())
+	clusters, err = list(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, clusters)
+}
@@ -0,0 +1,150 @@
+package internalstorage
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+const (
+	defaultAttributionUserBuckets = 16
+
+	// unknownUser is the label of the queries without the requester, e.g. the queries of the synchro.
+	unknownUser = "unknown"
+)
+
+var queriesTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "queries_total",
+		Help:           "Number of the database queries, partitioned by the user of the request.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"user"},
+)
+
+func init() {
+	legacyregistry.MustRegister(queriesTotal)
+}
+
+// AttributionConfig attributes the queries to the users of the requests, it takes effect with the QueryAttribution feature gate.
+type AttributionConfig struct {
+	// Users are the users reported by the `user` label of the queries metrics,
+	// the other users are hashed into the buckets to bound the cardinality of the label.
+	Users []string `yaml:"users"`
+
+	// UserBuckets is the number of the buckets of the users which are not in the Users, Default is 16.
+	UserBuckets int `yaml:"userBuckets"`
+
+	// DisableSQLComment disables the `/* user=..., uri=... */` comments of the queries,
+	// the comments are recorded in the slow query logs of both the storage and the database.
+	DisableSQLComment bool `yaml:"disableSQLComment"`
+}
+
+type queryAttribution struct {
+	users             map[string]struct{}
+	buckets           uint32
+	disableSQLComment bool
+}
+
+// registerAttribution registers the callbacks to attribute the queries to the requester in the context of the statement.
+func registerAttribution(db *gorm.DB, config AttributionConfig) error {
+	attribution := &queryAttribution{
+		users:             make(map[string]struct{}, len(config.Users)),
+		buckets:           defaultAttributionUserBuckets,
+		disableSQLComment: config.DisableSQLComment,
+	}
+	for _, user := range config.Users {
+		attribution.users[user] = struct{}{}
+	}
+	if config.UserBuckets > 0 {
+		attribution.buckets = uint32(config.UserBuckets)
+	}
+
+	const name = "clusterpedia:attribution"
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register(name, attribution.attribute); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register(name, attribution.attribute); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register(name, attribution.attribute); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register(name, attribution.attribute); err != nil {
+		return err
+	}
+	return callbacks.Row().Before("gorm:row").Register(name, attribution.attribute)
+}
+
+func (a *queryAttribution) attribute(db *gorm.DB) {
+	if !utilfeature.DefaultFeatureGate.Enabled(QueryAttribution) || db.Statement.Context == nil {
+		return
+	}
+
+	requester, ok := request.RequesterFrom(db.Statement.Context)
+	if !ok {
+		queriesTotal.WithLabelValues(unknownUser).Inc()
+		return
+	}
+	queriesTotal.WithLabelValues(a.userLabel(requester.User)).Inc()
+
+	setSpanAttributes(db.Statement.Context,
+		attribute.String("user", requester.User),
+		attribute.StringSlice("groups", requester.Groups),
+		attribute.String("uri", requester.URI),
+	)
+
+	if !a.disableSQLComment {
+		addQueryComment(db.Statement, fmt.Sprintf("user=%s, uri=%s", sanitizeComment(requester.User), sanitizeComment(requester.URI)))
+	}
+}
+
+// userLabel returns the label of the user, the users out of the allowlist are hashed into the buckets.
+func (a *queryAttribution) userLabel(user string) string {
+	if user == "" {
+		return unknownUser
+	}
+	if _, ok := a.users[user]; ok {
+		return user
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(user))
+	return fmt.Sprintf("bucket-%d", h.Sum32()%a.buckets)
+}
+
+// sanitizeComment replaces the characters that may close the comment or break the statement,
+// the values are written into the SQL directly since the comments are not parameterized.
+func sanitizeComment(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case strings.ContainsRune("-_.:/@=", r):
+			return r
+		}
+		return '_'
+	}, value)
+}
+
+// addQueryComment prepends the comment to the statement, the clauses of the statement are added
+// by the callbacks after the comment, their expressions are merged and the comment is retained.
+func addQueryComment(stmt *gorm.Statement, comment string) {
+	for _, name := range []string{"SELECT", "INSERT", "UPDATE", "DELETE"} {
+		c := stmt.Clauses[name]
+		c.BeforeExpression = clause.Expr{SQL: "/* " + comment + " */"}
+		stmt.Clauses[name] = c
+	}
+}
@@ -0,0 +1,53 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+func TestQueryAttribution(t *testing.T) {
+	require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set("QueryAttribution=true"))
+	defer func() {
+		require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set("QueryAttribution=false"))
+	}()
+
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, registerAttribution(db, AttributionConfig{Users: []string{"admin"}}))
+
+	ctx := request.WithRequester(context.Background(), request.Requester{
+		User:   "admin*/; DROP TABLE resources; --",
+		Groups: []string{"system:masters"},
+		URI:    "/apis/apps/v1/deployments",
+	})
+
+	before, err := testutil.GetCounterMetricValue(queriesTotal.WithLabelValues("admin"))
+	require.NoError(t, err)
+	stmt := db.Session(&gorm.Session{DryRun: true}).WithContext(ctx).Model(&Resource{}).Where("name = ?", "test").Find(&[]Resource{}).Statement
+	assert.Equal(t,
+		"/* user=admin_/__DROP_TABLE_resources__--, uri=/apis/apps/v1/deployments */ SELECT * FROM `resources` WHERE name = ?",
+		stmt.SQL.String(),
+	)
+
+	ctx = request.WithRequester(context.Background(), request.Requester{User: "admin", URI: "/apis/apps/v1/deployments"})
+	var resources []Resource
+	require.NoError(t, db.WithContext(ctx).Where("name = ?", "test").Find(&resources).Error)
+	after, err := testutil.GetCounterMetricValue(queriesTotal.WithLabelValues("admin"))
+	require.NoError(t, err)
+	assert.Equal(t, before+1, after)
+
+	attribution := &queryAttribution{users: map[string]struct{}{"admin": {}}, buckets: 4}
+	assert.Equal(t, "admin", attribution.userLabel("admin"))
+	assert.Equal(t, unknownUser, attribution.userLabel(""))
+	assert.Regexp(t, `^bucket-[0-3]$`, attribution.userLabel("alice"))
+	assert.Equal(t, attribution.userLabel("alice"), attribution.userLabel("alice"))
+}
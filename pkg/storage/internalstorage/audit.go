@@ -0,0 +1,194 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	AuditSinkLog   = "log"
+	AuditSinkTable = "table"
+
+	defaultAuditQueueSize = 1000
+	defaultAuditBatchSize = 100
+)
+
+type AuditOperation string
+
+const (
+	AuditOperationCreate AuditOperation = "create"
+	AuditOperationUpdate AuditOperation = "update"
+	AuditOperationDelete AuditOperation = "delete"
+)
+
+var auditRecordsDroppedTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "audit_records_dropped_total",
+		Help:           "Number of the audit records dropped since the audit sink is slow or failed to write them.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"sink", "reason"},
+)
+
+func init() {
+	legacyregistry.MustRegister(auditRecordsDroppedTotal)
+
+	registerMigration(migration{
+		version:  6,
+		name:     "create the audit records table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &AuditRecord{})
+		},
+	})
+}
+
+// AuditConfig records the mutations persisted by the storage, e.g. for the security audit.
+type AuditConfig struct {
+	// Sink is the sink of the audit records, one of log and table, the audit is disabled if it is empty.
+	// The log sink writes the records as the structured logs, and the table sink writes them into
+	// the audit_records table asynchronously.
+	Sink string `yaml:"sink"`
+
+	// QueueSize is the size of the queue of the records to be written by the table sink, Default is 1000.
+	QueueSize int `yaml:"queueSize"`
+
+	// BatchSize is the max number of the records written by each insert of the table sink, Default is 100.
+	BatchSize int `yaml:"batchSize"`
+
+	// Strict blocks the mutations while the queue of the table sink is full,
+	// otherwise the records are dropped and counted by the audit_records_dropped_total metric.
+	Strict bool `yaml:"strict"`
+}
+
+// AuditRecord is the record of the mutation persisted by the storage, the record of the table sink is stored as is.
+type AuditRecord struct {
+	ID uint `gorm:"primaryKey"`
+
+	Cluster   string         `gorm:"size:253;not null"`
+	Group     string         `gorm:"size:63;not null"`
+	Version   string         `gorm:"size:15;not null"`
+	Resource  string         `gorm:"size:63;not null"`
+	Namespace string         `gorm:"size:253;not null"`
+	Name      string         `gorm:"size:253;not null"`
+	Operation AuditOperation `gorm:"size:15;not null"`
+
+	// OldResourceVersion is the stored resource version before the update or delete, it is empty for the create.
+	OldResourceVersion string `gorm:"size:255;not null"`
+
+	// NewResourceVersion is the resource version written by the create or update, it is empty for the delete.
+	NewResourceVersion string `gorm:"size:255;not null"`
+
+	Time time.Time `gorm:"not null;index"`
+}
+
+// AuditSink receives the records after the mutations are persisted, the sink shouldn't block the synchro.
+type AuditSink interface {
+	Audit(ctx context.Context, record *AuditRecord)
+}
+
+// newAuditSink returns nil if the audit is disabled.
+func newAuditSink(db *gorm.DB, config AuditConfig) (AuditSink, error) {
+	switch config.Sink {
+	case "":
+		return nil, nil
+	case AuditSinkLog:
+		return logAuditSink{}, nil
+	case AuditSinkTable:
+		sink := newTableAuditSink(db, config)
+		go sink.run()
+		return sink, nil
+	}
+	return nil, fmt.Errorf("audit: not support sink: %s", config.Sink)
+}
+
+// logAuditSink writes the records as the structured logs.
+type logAuditSink struct{}
+
+func (logAuditSink) Audit(_ context.Context, record *AuditRecord) {
+	klog.InfoS("Storage mutation", "operation", record.Operation, "cluster", record.Cluster,
+		"group", record.Group, "version", record.Version, "resource", record.Resource,
+		"namespace", record.Namespace, "name", record.Name,
+		"oldResourceVersion", record.OldResourceVersion, "newResourceVersion", record.NewResourceVersion, "time", record.Time)
+}
+
+// tableAuditSink writes the records into the audit_records table in batches by a goroutine,
+// the records are queued by the bounded queue, so the slow database doesn't block the synchro.
+type tableAuditSink struct {
+	db        *gorm.DB
+	queue     chan *AuditRecord
+	batchSize int
+	strict    bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newTableAuditSink(db *gorm.DB, config AuditConfig) *tableAuditSink {
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultAuditQueueSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultAuditBatchSize
+	}
+	return &tableAuditSink{
+		db:        db,
+		queue:     make(chan *AuditRecord, queueSize),
+		batchSize: batchSize,
+		strict:    config.Strict,
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *tableAuditSink) Audit(ctx context.Context, record *AuditRecord) {
+	if !s.strict {
+		select {
+		case s.queue <- record:
+		default:
+			auditRecordsDroppedTotal.WithLabelValues(AuditSinkTable, "queue_full").Inc()
+		}
+		return
+	}
+
+	select {
+	case s.queue <- record:
+	case <-ctx.Done():
+		auditRecordsDroppedTotal.WithLabelValues(AuditSinkTable, "canceled").Inc()
+	}
+}
+
+// run writes the queued records until the sink is closed, the records queued at the time are written in one batch.
+func (s *tableAuditSink) run() {
+	defer close(s.done)
+
+	batch := make([]*AuditRecord, 0, s.batchSize)
+	for record := range s.queue {
+		batch = append(batch, record)
+		for len(batch) < s.batchSize && len(s.queue) > 0 {
+			batch = append(batch, <-s.queue)
+		}
+
+		if err := s.db.Create(batch).Error; err != nil {
+			klog.ErrorS(err, "Failed to write the audit records", "count", len(batch))
+			auditRecordsDroppedTotal.WithLabelValues(AuditSinkTable, "write_failed").Add(float64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+}
+
+// close stops the sink after the queued records are written.
+func (s *tableAuditSink) close() {
+	s.closeOnce.Do(func() { close(s.queue) })
+	<-s.done
+}
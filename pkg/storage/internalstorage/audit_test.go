@@ -0,0 +1,106 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestResourceStorage_Audit(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&AuditRecord{}))
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	sink := newTableAuditSink(db, AuditConfig{})
+	go sink.run()
+	rs.audit = sink
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	deletedObj, err := rs.ConvertDeletedObject(deploy)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+
+	// the mutations of the missing object aren't audited
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+	sink.close()
+
+	var records []AuditRecord
+	require.NoError(t, db.Order("id").Find(&records).Error)
+	require.Len(t, records, 3)
+	for i, expected := range []struct {
+		operation          AuditOperation
+		oldResourceVersion string
+		newResourceVersion string
+	}{
+		{AuditOperationCreate, "", "1"},
+		{AuditOperationUpdate, "1", "2"},
+		{AuditOperationDelete, "2", ""},
+	} {
+		record := records[i]
+		assert.Equal(t, expected.operation, record.Operation)
+		assert.Equal(t, expected.oldResourceVersion, record.OldResourceVersion)
+		assert.Equal(t, expected.newResourceVersion, record.NewResourceVersion)
+		assert.Equal(t, "cluster-1", record.Cluster)
+		assert.Equal(t, "apps", record.Group)
+		assert.Equal(t, "deployments", record.Resource)
+		assert.Equal(t, "default/deploy-1", record.Namespace+"/"+record.Name)
+		assert.False(t, record.Time.IsZero())
+	}
+}
+
+func TestTableAuditSink_Backpressure(t *testing.T) {
+	counter := func(reason string) float64 {
+		value, err := testutil.GetCounterMetricValue(auditRecordsDroppedTotal.WithLabelValues(AuditSinkTable, reason))
+		require.NoError(t, err)
+		return value
+	}
+
+	// the sink isn't running, so the queue is full after the first record
+	sink := newTableAuditSink(nil, AuditConfig{QueueSize: 1})
+	before := counter("queue_full")
+	sink.Audit(context.Background(), &AuditRecord{})
+	sink.Audit(context.Background(), &AuditRecord{})
+	assert.Equal(t, float64(1), counter("queue_full")-before)
+	assert.Len(t, sink.queue, 1)
+
+	// the strict sink blocks the mutation until the record is queued or the context is done
+	strict := newTableAuditSink(nil, AuditConfig{QueueSize: 1, Strict: true})
+	strict.Audit(context.Background(), &AuditRecord{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := make(chan struct{})
+	go func() {
+		defer close(queued)
+		strict.Audit(ctx, &AuditRecord{})
+	}()
+	select {
+	case <-queued:
+		t.Fatal("the strict sink doesn't block while the queue is full")
+	default:
+	}
+
+	before = counter("canceled")
+	cancel()
+	<-queued
+	assert.Equal(t, float64(1), counter("canceled")-before)
+}
@@ -0,0 +1,178 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	budgetKindRead  = "read"
+	budgetKindWrite = "write"
+)
+
+var (
+	connectionBudgetInUse = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "connection_budget_in_use",
+			Help:           "Number of the reads or writes holding the connection budget of the database, partitioned by the database name and the kind of the budget.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"db_name", "kind"},
+	)
+
+	connectionBudgetWaiting = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "connection_budget_waiting",
+			Help:           "Number of the reads or writes waiting for the connection budget of the database, partitioned by the database name and the kind of the budget.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"db_name", "kind"},
+	)
+
+	connectionBudgetRejectionsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "connection_budget_rejections_total",
+			Help:           "Number of the reads rejected with 429 since the connection budget of the database is exhausted, partitioned by the database name and the kind of the budget.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"db_name", "kind"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(connectionBudgetInUse)
+	legacyregistry.MustRegister(connectionBudgetWaiting)
+	legacyregistry.MustRegister(connectionBudgetRejectionsTotal)
+}
+
+// connectionBudget is the budgets of the concurrent reads and writes of the resource storages of a database,
+// the reads and the writes are limited separately, so that the expensive reads can't take all the connections
+// of the pool from the writes of the synchros. The budget of the kind is unlimited if it is nil.
+type connectionBudget struct {
+	reads  *budgetSemaphore
+	writes *budgetSemaphore
+}
+
+func newConnectionBudget(name string, config ConnectionBudgetConfig) *connectionBudget {
+	if config.Reads <= 0 && config.Writes <= 0 {
+		return nil
+	}
+
+	budget := &connectionBudget{}
+	if config.Reads > 0 {
+		budget.reads = newBudgetSemaphore(name, budgetKindRead, config.Reads, true, config.ReadWaitTimeout)
+	}
+	if config.Writes > 0 {
+		budget.writes = newBudgetSemaphore(name, budgetKindWrite, config.Writes, false, 0)
+	}
+	return budget
+}
+
+// addConnectionBudget adds the connection budget of the database, the budget isn't added if it's disabled by the config.
+func (s *StorageFactory) addConnectionBudget(name string, db *gorm.DB, config ConnectionBudgetConfig) {
+	budget := newConnectionBudget(name, config)
+	if budget == nil {
+		return
+	}
+	if s.budgets == nil {
+		s.budgets = make(map[*gorm.DB]*connectionBudget)
+	}
+	s.budgets[db] = budget
+}
+
+// acquireRead acquires the budget of a read, the read is rejected with 429 if the budget is exhausted.
+func (b *connectionBudget) acquireRead(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	return b.reads.acquire(ctx)
+}
+
+// acquireWrite acquires the budget of a write, the write waits for the budget until the ctx is done.
+func (b *connectionBudget) acquireWrite(ctx context.Context) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	return b.writes.acquire(ctx)
+}
+
+type budgetSemaphore struct {
+	semaphore *semaphore.Weighted
+	size      int64
+
+	// reject rejects the acquisition after the waitTimeout, or immediately if the waitTimeout is 0,
+	// otherwise the acquisition waits until the ctx is done.
+	reject      bool
+	waitTimeout time.Duration
+
+	inUse      metrics.GaugeMetric
+	waiting    metrics.GaugeMetric
+	rejections metrics.CounterMetric
+}
+
+func newBudgetSemaphore(name, kind string, size int64, reject bool, waitTimeout time.Duration) *budgetSemaphore {
+	return &budgetSemaphore{
+		semaphore:   semaphore.NewWeighted(size),
+		size:        size,
+		reject:      reject,
+		waitTimeout: waitTimeout,
+		inUse:       connectionBudgetInUse.WithLabelValues(name, kind),
+		waiting:     connectionBudgetWaiting.WithLabelValues(name, kind),
+		rejections:  connectionBudgetRejectionsTotal.WithLabelValues(name, kind),
+	}
+}
+
+// acquire acquires the budget, the returned release must be called after the query is done.
+func (s *budgetSemaphore) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+
+	if !s.semaphore.TryAcquire(1) {
+		if s.reject && s.waitTimeout <= 0 {
+			s.rejections.Inc()
+			return nil, s.exhaustedError()
+		}
+
+		waitCtx := ctx
+		if s.reject {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx, s.waitTimeout)
+			defer cancel()
+		}
+
+		s.waiting.Inc()
+		err := s.semaphore.Acquire(waitCtx, 1)
+		s.waiting.Dec()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			s.rejections.Inc()
+			return nil, s.exhaustedError()
+		}
+	}
+
+	s.inUse.Inc()
+	return func() {
+		s.inUse.Dec()
+		s.semaphore.Release(1)
+	}, nil
+}
+
+func (s *budgetSemaphore) exhaustedError() error {
+	return apierrors.NewTooManyRequests(fmt.Sprintf("the budget of %d concurrent queries of the storage is exhausted, please try again later", s.size), 1)
+}
@@ -0,0 +1,149 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics/testutil"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestConnectionBudget(t *testing.T) {
+	assert.Nil(t, newConnectionBudget("disabled", ConnectionBudgetConfig{}))
+
+	budget := newConnectionBudget("test-budget", ConnectionBudgetConfig{Reads: 1, Writes: 1})
+	rejections := func(kind string) float64 {
+		value, err := testutil.GetCounterMetricValue(connectionBudgetRejectionsTotal.WithLabelValues("test-budget", kind))
+		require.NoError(t, err)
+		return value
+	}
+	waiting := func(kind string) float64 {
+		value, err := testutil.GetGaugeMetricValue(connectionBudgetWaiting.WithLabelValues("test-budget", kind))
+		require.NoError(t, err)
+		return value
+	}
+
+	readRejections := rejections(budgetKindRead)
+
+	// the reads are rejected immediately if the budget is exhausted
+	releaseRead, err := budget.acquireRead(context.Background())
+	require.NoError(t, err)
+	_, err = budget.acquireRead(context.Background())
+	assert.True(t, apierrors.IsTooManyRequests(err))
+	assert.Equal(t, float64(1), rejections(budgetKindRead)-readRejections)
+
+	// the writes aren't limited by the reads, and they wait for the budget
+	releaseWrite, err := budget.acquireWrite(context.Background())
+	require.NoError(t, err)
+	acquired := make(chan func())
+	go func() {
+		release, err := budget.acquireWrite(context.Background())
+		assert.NoError(t, err)
+		acquired <- release
+	}()
+	assert.Eventually(t, func() bool { return waiting(budgetKindWrite) == 1 }, 5*time.Second, 10*time.Millisecond)
+	releaseWrite()
+	(<-acquired)()
+	assert.Equal(t, float64(0), waiting(budgetKindWrite))
+	assert.Zero(t, rejections(budgetKindWrite))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	releaseWrite, err = budget.acquireWrite(context.Background())
+	require.NoError(t, err)
+	_, err = budget.acquireWrite(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	releaseWrite()
+
+	// the reads wait for the budget until the wait timeout
+	budget.reads.waitTimeout = 50 * time.Millisecond
+	_, err = budget.acquireRead(context.Background())
+	assert.True(t, apierrors.IsTooManyRequests(err))
+	assert.Equal(t, float64(2), rejections(budgetKindRead)-readRejections)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		releaseRead()
+	}()
+	releaseRead, err = budget.acquireRead(context.Background())
+	require.NoError(t, err)
+	releaseRead()
+}
+
+// TestConnectionBudgetStress saturates the reads of the resource storage while the writes are measured,
+// the writes keep flowing under the latency bound since they aren't limited by the budget of the reads.
+func TestConnectionBudgetStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip the stress test in the short mode")
+	}
+
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newPreparedTestResourceStorage(t, db, false)
+	rs.lightUpdates = false
+	rs.budget = newConnectionBudget("stress", ConnectionBudgetConfig{Reads: 1, ReadWaitTimeout: 10 * time.Millisecond, Writes: 1})
+
+	for i := 0; i < 100; i++ {
+		deploy := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: fmt.Sprintf("deploy-%d", i), ResourceVersion: "1"},
+		}
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var readers sync.WaitGroup
+	var reads, rejected atomic.Int64
+	for i := 0; i < 16; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for ctx.Err() == nil {
+				err := rs.List(ctx, &appsv1.DeploymentList{}, &internal.ListOptions{})
+				switch {
+				case err == nil:
+					reads.Add(1)
+				case apierrors.IsTooManyRequests(err):
+					rejected.Add(1)
+				}
+			}
+		}()
+	}
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-written", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-2", deploy))
+
+	latencies := make([]time.Duration, 0, 50)
+	for i := 0; i < cap(latencies); i++ {
+		deploy.ResourceVersion = strconv.Itoa(i + 2)
+		start := time.Now()
+		require.NoError(t, rs.Update(context.Background(), "cluster-2", deploy))
+		latencies = append(latencies, time.Since(start))
+	}
+	cancel()
+	readers.Wait()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p99 := latencies[len(latencies)*99/100]
+	t.Logf("reads %d, rejected reads %d, write latency p50 %s, p99 %s", reads.Load(), rejected.Load(), latencies[len(latencies)/2], p99)
+
+	assert.NotZero(t, rejected.Load(), "the reads should be saturated")
+	assert.Less(t, p99, time.Second)
+}
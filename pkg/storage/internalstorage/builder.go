@@ -1,180 +1,24 @@
 package internalstorage
 
 import (
-	"fmt"
-	"strings"
-
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-)
 
-type JSONQueryExpression struct {
-	column string
-	keys   []string
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
 
-	not    bool
-	values []string
-}
+// The json expressions are built by the querybuilder, the aliases are kept for the existing callers.
+type (
+	JSONQueryExpression   = querybuilder.JSONQueryExpression
+	JSONCompareExpression = querybuilder.JSONCompareExpression
+)
 
 func JSONQuery(column string, keys ...string) *JSONQueryExpression {
-	return &JSONQueryExpression{column: column, keys: keys}
-}
-
-func (jsonQuery *JSONQueryExpression) Exist() *JSONQueryExpression {
-	jsonQuery.not = false
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) NotExist() *JSONQueryExpression {
-	jsonQuery.not = true
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) Equal(value string) *JSONQueryExpression {
-	jsonQuery.not, jsonQuery.values = false, []string{value}
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) NotEqual(value string) *JSONQueryExpression {
-	jsonQuery.not, jsonQuery.values = true, []string{value}
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) In(values ...string) *JSONQueryExpression {
-	jsonQuery.not, jsonQuery.values = false, values
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) NotIn(values ...string) *JSONQueryExpression {
-	jsonQuery.not, jsonQuery.values = true, values
-	return jsonQuery
-}
-
-func (jsonQuery *JSONQueryExpression) writeJSONKey(builder clause.Builder) {
-	writeString(builder, "JSON_EXTRACT(")
-
-	builder.WriteQuoted(jsonQuery.column)
-	writeString(builder, ",")
-	builder.AddVar(builder, fmt.Sprintf(`$."%s"`, strings.Join(jsonQuery.keys, `"."`)))
-
-	writeString(builder, ")")
-}
-
-func (jsonQuery *JSONQueryExpression) writePostgresJSONKey(builder clause.Builder) {
-	builder.WriteQuoted(jsonQuery.column)
-	for _, key := range jsonQuery.keys[0 : len(jsonQuery.keys)-1] {
-		writeString(builder, " -> ")
-		builder.AddVar(builder, key)
-	}
-	writeString(builder, " ->> ")
-	builder.AddVar(builder, jsonQuery.keys[len(jsonQuery.keys)-1])
-}
-
-func (jsonQuery *JSONQueryExpression) writeJSONKeyWithJSON_UNQUOTE(builder clause.Builder) {
-	writeString(builder, "JSON_UNQUOTE(")
-	jsonQuery.writeJSONKey(builder)
-	writeString(builder, ")")
-}
-
-func (jsonQuery *JSONQueryExpression) writeJSONKeyWithCAST_TO_TEXT(builder clause.Builder) {
-	writeString(builder, "CAST(")
-	jsonQuery.writeJSONKey(builder)
-	writeString(builder, " as TEXT)")
-}
-
-func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
-	if len(jsonQuery.keys) == 0 {
-		return
-	}
-
-	if stmt, ok := builder.(*gorm.Statement); ok {
-		dialector := stmt.Dialector.Name()
-		switch dialector {
-		case "mysql", "sqlite3", "sqlite":
-			if jsonQuery.not && len(jsonQuery.values) != 0 {
-				writeString(builder, "(")
-				defer func() {
-					writeString(builder, ")")
-				}()
-
-				jsonQuery.writeJSONKey(builder)
-				writeString(builder, " IS NULL")
-				writeString(builder, " OR ")
-			}
-
-			if dialector == "mysql" {
-				// Wrap`JSON_UNQUOTE` function to convert all json results to strings.
-				// https://github.com/clusterpedia-io/clusterpedia/pull/62
-				jsonQuery.writeJSONKeyWithJSON_UNQUOTE(builder)
-			} else {
-				// Wrap`CAST as TEXT` function to convert all json results to strings.
-				jsonQuery.writeJSONKeyWithCAST_TO_TEXT(builder)
-			}
-
-			switch len(jsonQuery.values) {
-			case 0:
-				if jsonQuery.not {
-					writeString(builder, " IS NULL")
-				} else {
-					writeString(builder, " IS NOT NULL")
-				}
-			case 1:
-				if jsonQuery.not {
-					writeString(builder, " != ")
-				} else {
-					writeString(builder, " = ")
-				}
-				builder.AddVar(builder, jsonQuery.values[0])
-			default:
-				if jsonQuery.not {
-					writeString(builder, " NOT IN ")
-				} else {
-					writeString(builder, " IN ")
-				}
-				builder.AddVar(builder, jsonQuery.values)
-			}
-		case "postgres":
-			if jsonQuery.not && len(jsonQuery.values) != 0 {
-				writeString(builder, "(")
-				defer func() {
-					writeString(builder, ")")
-				}()
-
-				jsonQuery.writePostgresJSONKey(builder)
-				writeString(builder, " IS NULL")
-				writeString(builder, " OR ")
-			}
-
-			jsonQuery.writePostgresJSONKey(builder)
-			switch len(jsonQuery.values) {
-			case 0:
-				if jsonQuery.not {
-					writeString(builder, " IS NULL")
-				} else {
-					writeString(builder, " IS NOT NULL")
-				}
-			case 1:
-				if jsonQuery.not {
-					writeString(builder, " != ")
-				} else {
-					writeString(builder, " = ")
-				}
-				builder.AddVar(builder, jsonQuery.values[0])
-			default:
-				if jsonQuery.not {
-					writeString(builder, " NOT IN ")
-				} else {
-					writeString(builder, " IN ")
-				}
-				builder.AddVar(builder, jsonQuery.values)
-			}
-		}
-	}
+	return querybuilder.JSONQuery(column, keys...)
 }
 
-func writeString(builder clause.Writer, str string) {
-	_, _ = builder.WriteString(str)
+func JSONCompare(column string, left []string, operator string, right []string) *JSONCompareExpression {
+	return querybuilder.JSONCompare(column, left, operator, right)
 }
 
 func buildOwnerQueryByUID(db *gorm.DB, cluster, uid string, seniority int) interface{} {
@@ -190,22 +34,21 @@ func buildOwnerQueryByUID(db *gorm.DB, cluster, uid string, seniority int) inter
 	return ownerQuery.Where("owner_uid IN (?)", parentOwner)
 }
 
-func buildOwnerQueryByName(db *gorm.DB, cluster string, namespaces []string, groupResource schema.GroupResource, name string, seniority int) interface{} {
+// buildOwnerQueryByName builds the query of the uids of the owners by the name, the namespace of the owners is matched
+// by their own scope rather than the namespaces of the owned resources, so both the cluster-scoped owners and the namespaced
+// owners in any namespace are matched, unless the namespace of the owners is specified.
+func buildOwnerQueryByName(db *gorm.DB, cluster string, namespace string, groupResource schema.GroupResource, name string, seniority int) interface{} {
 	ownerQuery := db.Model(Resource{}).Select("uid").Where(map[string]interface{}{"cluster": cluster})
 	if seniority != 0 {
-		parentOwner := buildOwnerQueryByName(db, cluster, namespaces, groupResource, name, seniority-1)
+		parentOwner := buildOwnerQueryByName(db, cluster, namespace, groupResource, name, seniority-1)
 		return ownerQuery.Where("owner_uid IN (?)", parentOwner)
 	}
 
 	if !groupResource.Empty() {
 		ownerQuery = ownerQuery.Where(map[string]interface{}{"group": groupResource.Group, "resource": groupResource.Resource})
 	}
-	switch len(namespaces) {
-	case 0:
-	case 1:
-		ownerQuery = ownerQuery.Where("namespace = ?", namespaces[0])
-	default:
-		ownerQuery = ownerQuery.Where("namespace IN (?)", namespaces)
+	if namespace != "" {
+		ownerQuery = ownerQuery.Where("namespace = ?", namespace)
 	}
 	return ownerQuery.Where("name = ?", name)
 }
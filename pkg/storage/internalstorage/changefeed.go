@@ -0,0 +1,608 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	defaultChangeFeedMaxLag        = 30 * time.Second
+	defaultChangeFeedRetryInterval = 30 * time.Second
+	defaultChangeFeedPollInterval  = 2 * time.Second
+	defaultChangeFeedPollMargin    = time.Minute
+	defaultChangeFeedPollBatchSize = 500
+
+	// changeFeedSaveInterval is the min interval of the saves of the position while the binlog is tailed.
+	changeFeedSaveInterval = time.Second
+)
+
+var (
+	changeFeedEventsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "change_feed_events_total",
+			Help:           "Number of the events published by the change feed by the source, one of binlog and poll.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"source"},
+	)
+
+	changeFeedFallbacksTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "change_feed_fallbacks_total",
+			Help:           "Number of the fallbacks of the change feed to the polling by the reason, one of unavailable and behind.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(changeFeedEventsTotal, changeFeedFallbacksTotal)
+
+	registerMigration(migration{
+		version:  17,
+		name:     "create the change feed positions table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ChangeFeedPosition{})
+		},
+	})
+}
+
+// ChangeFeedConfig feeds the watch hub with the changes of the resources table read from the MySQL binlog,
+// so the watches of the processes which don't write the resources, e.g. the apiserver, receive the events.
+//
+// The server should run with gtid_mode=ON, binlog_format=ROW and binlog_row_image=FULL, and the user needs
+// the REPLICATION SLAVE and REPLICATION CLIENT privileges. The GTID set of the published transactions is persisted
+// by the ServerID, and the feed resumes from it after the restart, so the events are published at least once.
+//
+// The feed falls back to polling the resources by the synced_at when the binlog is unavailable or falls behind,
+// and retries the binlog after the RetryInterval. The polled objects are published as modified, even if they are created,
+// and the deletions aren't published while polling.
+//
+// It requires the WatchHub, and only supports the default database of MySQL without the Databases and Routes.
+type ChangeFeedConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// ServerID is the server id of the binlog replica, it must be unique in the replicas of the server,
+	// including the other processes running the change feed.
+	ServerID uint32 `yaml:"serverID"`
+
+	// User and Password are the replication user, Default is the user of the database.
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+
+	// MaxLag is the max lag of the tailed transactions behind their commits, the feed falls back to polling
+	// if the binlog falls behind, Default is 30s.
+	MaxLag time.Duration `yaml:"maxLag"`
+
+	// RetryInterval is the interval of the retries of the binlog while polling, Default is 30s.
+	RetryInterval time.Duration `yaml:"retryInterval"`
+
+	// PollInterval is the interval of the polls while the binlog is unavailable, Default is 2s.
+	PollInterval time.Duration `yaml:"pollInterval"`
+
+	// PollMargin is subtracted from the time of the last poll when the resources are polled, since the concurrent writes
+	// may be committed out of order. It should be longer than the write timeout, Default is 1m.
+	PollMargin time.Duration `yaml:"pollMargin"`
+}
+
+// ChangeFeedPosition is the GTID set of the transactions published by the change feed of the server id,
+// and the time of the last published transaction, which the polls start from.
+type ChangeFeedPosition struct {
+	ServerID uint32    `gorm:"primaryKey;autoIncrement:false"`
+	GTIDSet  string    `gorm:"column:gtid_set;type:text;not null"`
+	SyncedAt time.Time `gorm:"not null"`
+}
+
+// errChangeFeedBehind is returned by the tail if the binlog falls behind the max lag.
+var errChangeFeedBehind = errors.New("the binlog falls behind")
+
+// feedEvent is the event of a row of the resources table.
+type feedEvent struct {
+	gvr   schema.GroupVersionResource
+	event *hubEvent
+}
+
+// binlogTransaction is the tailed transaction, whose events are published when it is committed.
+type binlogTransaction struct {
+	sid         uuid.UUID
+	gno         int64
+	committedAt time.Time
+	begun       bool
+	events      []*feedEvent
+}
+
+// changeFeed publishes the changes of the resources to the watch hub by a goroutine.
+type changeFeed struct {
+	serverID      uint32
+	syncerConfig  replication.BinlogSyncerConfig
+	source        binlogSource
+	hub           *watchHub
+	encryption    *objectEncryption
+	clock         clock.Clock
+	maxLag        time.Duration
+	retryInterval time.Duration
+	pollInterval  time.Duration
+	pollMargin    time.Duration
+	pollBatchSize int
+
+	db                  *gorm.DB
+	database            string
+	splitColumnsMissing bool
+
+	// position is the GTID set of the published transactions, and syncedAt is the time of the last one,
+	// they and the other states below are only accessed by the goroutine after the feed is started.
+	position *mysql.MysqlGTIDSet
+	syncedAt time.Time
+	dirty    bool
+	savedAt  time.Time
+
+	// polled are the synced_at of the rows published by the polls, the rows synced before the margin of the next poll
+	// are forgotten.
+	polled map[uint]time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newChangeFeed returns nil if the change feed is disabled, the hub is fed by the feed if it is enabled.
+func newChangeFeed(cfg *Config, hub *watchHub, encryption *objectEncryption) (*changeFeed, error) {
+	config := cfg.ChangeFeed
+	if !config.Enabled {
+		return nil, nil
+	}
+	switch {
+	case hub == nil:
+		return nil, errors.New("changeFeed requires the watchHub")
+	case cfg.Type != "mysql":
+		return nil, fmt.Errorf("changeFeed only supports mysql, got %s", cfg.Type)
+	case len(cfg.Databases) != 0 || len(cfg.Routes) != 0:
+		return nil, errors.New("changeFeed doesn't support the databases and routes")
+	case config.ServerID == 0:
+		return nil, errors.New("changeFeed.serverID is required")
+	case config.MaxLag < 0 || config.RetryInterval < 0 || config.PollInterval < 0 || config.PollMargin < 0:
+		return nil, errors.New("changeFeed.maxLag, retryInterval, pollInterval and pollMargin must not be negative")
+	}
+
+	syncerConfig, database, err := newBinlogSyncerConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("changeFeed: %w", err)
+	}
+	feed := newChangeFeedWithSource(config, nil, hub, encryption, clock.RealClock{})
+	feed.syncerConfig, feed.database = syncerConfig, database
+	return feed, nil
+}
+
+func newChangeFeedWithSource(config ChangeFeedConfig, source binlogSource, hub *watchHub, encryption *objectEncryption, clock clock.Clock) *changeFeed {
+	maxLag := config.MaxLag
+	if maxLag == 0 {
+		maxLag = defaultChangeFeedMaxLag
+	}
+	retryInterval := config.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = defaultChangeFeedRetryInterval
+	}
+	pollInterval := config.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultChangeFeedPollInterval
+	}
+	pollMargin := config.PollMargin
+	if pollMargin == 0 {
+		pollMargin = defaultChangeFeedPollMargin
+	}
+
+	hub.fed = true
+	ctx, cancel := context.WithCancel(context.Background())
+	return &changeFeed{
+		serverID:      config.ServerID,
+		source:        source,
+		hub:           hub,
+		encryption:    encryption,
+		clock:         clock,
+		maxLag:        maxLag,
+		retryInterval: retryInterval,
+		pollInterval:  pollInterval,
+		pollMargin:    pollMargin,
+		pollBatchSize: defaultChangeFeedPollBatchSize,
+		polled:        make(map[uint]time.Time),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+}
+
+// start loads the position of the feed and publishes the changes by a goroutine, the feed starts from the transactions
+// executed by the server if the position isn't persisted.
+func (f *changeFeed) start(db *gorm.DB, splitColumnsMissing bool) error {
+	if f == nil {
+		return nil
+	}
+	f.db, f.splitColumnsMissing = db, splitColumnsMissing
+	if f.source == nil {
+		f.source = &mysqlBinlogSource{config: f.syncerConfig, db: db}
+	}
+
+	var positions []ChangeFeedPosition
+	if err := db.Where("server_id = ?", f.serverID).Limit(1).Find(&positions).Error; err != nil {
+		return fmt.Errorf("change feed: failed to load the position: %w", err)
+	}
+	if len(positions) != 0 {
+		position, err := mysql.ParseMysqlGTIDSet(positions[0].GTIDSet)
+		if err != nil {
+			return fmt.Errorf("change feed: the position %q is malformed: %w", positions[0].GTIDSet, err)
+		}
+		f.position, f.syncedAt = position.(*mysql.MysqlGTIDSet), positions[0].SyncedAt
+	} else {
+		// the changes before the first start aren't published
+		executed, err := f.source.executed(f.ctx)
+		if err != nil {
+			return fmt.Errorf("change feed: %w", err)
+		}
+		f.position, f.syncedAt, f.dirty = executed, f.clock.Now(), true
+		if err := f.savePosition(); err != nil {
+			return fmt.Errorf("change feed: failed to save the position: %w", err)
+		}
+	}
+	go f.run()
+	return nil
+}
+
+// run tails the binlog, and polls the resources until the binlog is retried if it fails.
+func (f *changeFeed) run() {
+	defer close(f.done)
+
+	for {
+		err := f.tail()
+		if f.ctx.Err() != nil {
+			return
+		}
+
+		reason := "unavailable"
+		if errors.Is(err, errChangeFeedBehind) {
+			reason = "behind"
+		}
+		changeFeedFallbacksTotal.WithLabelValues(reason).Inc()
+		klog.ErrorS(err, "The change feed falls back to polling the resources", "serverID", f.serverID, "retryInterval", f.retryInterval)
+
+		retry := f.clock.NewTimer(f.retryInterval)
+		for polling := true; polling; {
+			if err := f.poll(); err != nil && f.ctx.Err() == nil {
+				klog.ErrorS(err, "Failed to poll the resources", "serverID", f.serverID)
+			}
+
+			poll := f.clock.NewTimer(f.pollInterval)
+			select {
+			case <-f.ctx.Done():
+				poll.Stop()
+				retry.Stop()
+				return
+			case <-retry.C():
+				poll.Stop()
+				polling = false
+			case <-poll.C():
+			}
+		}
+	}
+}
+
+// tail publishes the transactions of the binlog after the position until the binlog fails or falls behind,
+// or the feed is closed.
+func (f *changeFeed) tail() error {
+	columns, err := f.columnIndexes()
+	if err != nil {
+		return err
+	}
+	stream, err := f.source.stream(f.position)
+	if err != nil {
+		return fmt.Errorf("failed to start the binlog from %s: %w", f.position, err)
+	}
+	defer stream.Close()
+	// the position is saved when the binlog stops
+	defer f.trySavePosition(true)
+
+	var trx *binlogTransaction
+	for {
+		event, err := stream.GetEvent(f.ctx)
+		if err != nil {
+			return err
+		}
+
+		switch e := event.Event.(type) {
+		case *replication.GTIDEvent:
+			sid, err := uuid.FromBytes(e.SID)
+			if err != nil {
+				return fmt.Errorf("the GTID event is malformed: %w", err)
+			}
+			committedAt := e.ImmediateCommitTime()
+			if committedAt.IsZero() {
+				committedAt = time.Unix(int64(event.Header.Timestamp), 0)
+			}
+			if lag := f.clock.Since(committedAt); lag > f.maxLag {
+				return fmt.Errorf("%w: the transaction %s:%d is committed %s ago", errChangeFeedBehind, sid, e.GNO, lag.Round(time.Second))
+			}
+			trx = &binlogTransaction{sid: sid, gno: e.GNO, committedAt: committedAt}
+
+		case *replication.QueryEvent:
+			if trx == nil {
+				continue
+			}
+			query := string(e.Query)
+			if query == "BEGIN" {
+				trx.begun = true
+				continue
+			}
+			// the DDL is committed by itself, and the transaction of the non-transactional tables is committed by the COMMIT
+			if !trx.begun || query == "COMMIT" {
+				f.commit(trx)
+				trx = nil
+			}
+
+		case *replication.RowsEvent:
+			if trx == nil || string(e.Table.Schema) != f.database || string(e.Table.Table) != "resources" {
+				continue
+			}
+			if int(e.ColumnCount) != len(columns) {
+				// the columns are altered since they are read
+				if columns, err = f.columnIndexes(); err != nil {
+					return err
+				}
+				if int(e.ColumnCount) != len(columns) {
+					return fmt.Errorf("the binlog has %d columns of the resources table, but the table has %d", e.ColumnCount, len(columns))
+				}
+			}
+			trx.events = append(trx.events, f.rowsEvents(event.Header.EventType, e, columns)...)
+
+		case *replication.XIDEvent:
+			if trx != nil {
+				f.commit(trx)
+				trx = nil
+			}
+		}
+		f.trySavePosition(false)
+	}
+}
+
+// commit publishes the events of the transaction and adds it to the position.
+func (f *changeFeed) commit(trx *binlogTransaction) {
+	for _, event := range trx.events {
+		f.hub.publish(event.gvr, event.event)
+	}
+	changeFeedEventsTotal.WithLabelValues("binlog").Add(float64(len(trx.events)))
+
+	f.position.AddGTID(trx.sid, trx.gno)
+	if trx.committedAt.After(f.syncedAt) {
+		f.syncedAt = trx.committedAt
+	}
+	f.dirty = true
+}
+
+// poll publishes the resources synced since the last poll as the modified objects, and moves the position to
+// the transactions executed before they are read, so the binlog is retried from them.
+func (f *changeFeed) poll() error {
+	executed, err := f.source.executed(f.ctx)
+	if err != nil {
+		return err
+	}
+	polledAt := f.clock.Now()
+
+	columns := []string{"id", "cluster", "group", "version", "resource", "namespace", "name", "object", "synced_at"}
+	if !f.splitColumnsMissing {
+		columns = append(columns, "spec", "status")
+	}
+	var lastSyncedAt time.Time
+	var lastID uint
+	var published int
+	for {
+		query := f.db.WithContext(f.ctx).Model(&Resource{}).Select(columns)
+		if lastID == 0 {
+			query = query.Where("synced_at >= ?", f.syncedAt.Add(-f.pollMargin))
+		} else {
+			query = query.Where("synced_at > ? OR (synced_at = ? AND id > ?)", lastSyncedAt, lastSyncedAt, lastID)
+		}
+		var resources []Resource
+		if err := query.Order("synced_at, id").Limit(f.pollBatchSize).Find(&resources).Error; err != nil {
+			return err
+		}
+
+		for _, resource := range resources {
+			if syncedAt, ok := f.polled[resource.ID]; ok && syncedAt.Equal(resource.SyncedAt) {
+				continue
+			}
+			f.polled[resource.ID] = resource.SyncedAt
+
+			event, err := f.newEvent(watch.Modified, resource.GroupVersionResource(), resource.Cluster, resource.Namespace, resource.Name,
+				resource.Object, resource.Spec, resource.Status)
+			if err != nil {
+				klog.ErrorS(err, "Failed to publish the polled object", "cluster", resource.Cluster, "namespace", resource.Namespace, "name", resource.Name)
+				continue
+			}
+			f.hub.publish(event.gvr, event.event)
+			published++
+		}
+		if len(resources) < f.pollBatchSize {
+			break
+		}
+		last := resources[len(resources)-1]
+		lastSyncedAt, lastID = last.SyncedAt, last.ID
+	}
+	changeFeedEventsTotal.WithLabelValues("poll").Add(float64(published))
+
+	for id, syncedAt := range f.polled {
+		if syncedAt.Before(polledAt.Add(-f.pollMargin)) {
+			delete(f.polled, id)
+		}
+	}
+	f.position, f.syncedAt, f.dirty = executed, polledAt, true
+	f.trySavePosition(true)
+	return nil
+}
+
+// rowsEvents returns the events of the rows, the updated rows are the pairs of the rows before and after the update,
+// and the updates which don't change the objects, e.g. the light updates, aren't published.
+func (f *changeFeed) rowsEvents(eventType replication.EventType, rows *replication.RowsEvent, columns map[string]int) []*feedEvent {
+	var events []*feedEvent
+	add := func(eventType watch.EventType, row []interface{}) {
+		event, err := f.rowEvent(eventType, row, columns)
+		if err != nil {
+			klog.ErrorS(err, "Failed to publish the row of the binlog", "cluster", rowString(row, columns, "cluster"),
+				"namespace", rowString(row, columns, "namespace"), "name", rowString(row, columns, "name"))
+			return
+		}
+		events = append(events, event)
+	}
+
+	switch eventType {
+	case replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, row := range rows.Rows {
+			add(watch.Added, row)
+		}
+	case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range rows.Rows {
+			add(watch.Deleted, row)
+		}
+	case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		for i := 0; i+1 < len(rows.Rows); i += 2 {
+			before, after := rows.Rows[i], rows.Rows[i+1]
+			if rowString(before, columns, "uid") != rowString(after, columns, "uid") {
+				// the row of the recreated object is replaced
+				add(watch.Deleted, before)
+				add(watch.Added, after)
+				continue
+			}
+
+			changed := false
+			for _, column := range []string{"object", "spec", "status"} {
+				if rowString(before, columns, column) != rowString(after, columns, column) {
+					changed = true
+				}
+			}
+			if changed {
+				add(watch.Modified, after)
+			}
+		}
+	}
+	return events
+}
+
+func (f *changeFeed) rowEvent(eventType watch.EventType, row []interface{}, columns map[string]int) (*feedEvent, error) {
+	gvr := schema.GroupVersionResource{
+		Group:    rowString(row, columns, "group"),
+		Version:  rowString(row, columns, "version"),
+		Resource: rowString(row, columns, "resource"),
+	}
+	return f.newEvent(eventType, gvr, rowString(row, columns, "cluster"), rowString(row, columns, "namespace"), rowString(row, columns, "name"),
+		rowBytes(row, columns, "object"), rowBytes(row, columns, "spec"), rowBytes(row, columns, "status"))
+}
+
+// newEvent returns the event of the stored object, which is decrypted and assembled with its spec and status.
+func (f *changeFeed) newEvent(eventType watch.EventType, gvr schema.GroupVersionResource, cluster, namespace, name string, object, spec, status []byte) (*feedEvent, error) {
+	object, err := f.encryption.decrypt(object)
+	if err != nil {
+		return nil, err
+	}
+	object = assembleObject(object, spec, status)
+
+	var partial struct {
+		Metadata struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &partial); err != nil {
+		return nil, fmt.Errorf("the object is malformed: %w", err)
+	}
+	return &feedEvent{
+		gvr: gvr,
+		event: &hubEvent{
+			eventType: eventType,
+			cluster:   cluster,
+			namespace: namespace,
+			name:      name,
+			labels:    partial.Metadata.Labels,
+			object:    object,
+		},
+	}, nil
+}
+
+// columnIndexes returns the indexes of the columns of the resources table in the rows of the binlog.
+func (f *changeFeed) columnIndexes() (map[string]int, error) {
+	columns, err := f.source.columns(f.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the columns of the resources table: %w", err)
+	}
+	indexes := make(map[string]int, len(columns))
+	for i, column := range columns {
+		indexes[column] = i
+	}
+	return indexes, nil
+}
+
+func rowBytes(row []interface{}, columns map[string]int, column string) []byte {
+	i, ok := columns[column]
+	if !ok || i >= len(row) {
+		return nil
+	}
+	switch value := row[i].(type) {
+	case string:
+		return []byte(value)
+	case []byte:
+		return value
+	}
+	return nil
+}
+
+func rowString(row []interface{}, columns map[string]int, column string) string {
+	return string(rowBytes(row, columns, column))
+}
+
+// trySavePosition saves the changed position at most once per the changeFeedSaveInterval unless it is forced,
+// the position isn't saved after every transaction, since the transactions are published at least once.
+func (f *changeFeed) trySavePosition(force bool) {
+	if !f.dirty || (!force && f.clock.Since(f.savedAt) < changeFeedSaveInterval) {
+		return
+	}
+	if err := f.savePosition(); err != nil {
+		klog.ErrorS(err, "Failed to save the position of the change feed", "serverID", f.serverID)
+	}
+}
+
+func (f *changeFeed) savePosition() error {
+	err := f.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "server_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"gtid_set", "synced_at"}),
+	}).Create(&ChangeFeedPosition{ServerID: f.serverID, GTIDSet: f.position.String(), SyncedAt: f.syncedAt}).Error
+	if err != nil {
+		return err
+	}
+	f.dirty, f.savedAt = false, f.clock.Now()
+	return nil
+}
+
+// close stops the feed, the position is saved when the binlog or the poll stops.
+func (f *changeFeed) close() {
+	if f == nil {
+		return
+	}
+	f.cancel()
+	<-f.done
+}
@@ -0,0 +1,151 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/siddontang/go-log/log"
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+const defaultChangeFeedHeartbeatPeriod = 10 * time.Second
+
+// binlogSource reads the binlog and the states of the server of the change feed.
+type binlogSource interface {
+	// executed returns the GTID set of the transactions executed by the server.
+	executed(ctx context.Context) (*mysql.MysqlGTIDSet, error)
+
+	// columns returns the columns of the resources table in the order of the rows of the binlog.
+	columns(ctx context.Context) ([]string, error)
+
+	// stream streams the events of the binlog after the GTID set.
+	stream(gtids *mysql.MysqlGTIDSet) (binlogStream, error)
+}
+
+type binlogStream interface {
+	GetEvent(ctx context.Context) (*replication.BinlogEvent, error)
+	Close()
+}
+
+// mysqlBinlogSource reads the binlog by the replication protocol, and the states by the connection of the database.
+type mysqlBinlogSource struct {
+	config replication.BinlogSyncerConfig
+	db     *gorm.DB
+}
+
+func newBinlogSyncerConfig(cfg *Config) (replication.BinlogSyncerConfig, string, error) {
+	mysqlConfig, err := cfg.genMySQLConfig()
+	if err != nil {
+		return replication.BinlogSyncerConfig{}, "", err
+	}
+	host, port, err := net.SplitHostPort(mysqlConfig.Addr)
+	if err != nil {
+		return replication.BinlogSyncerConfig{}, "", fmt.Errorf("the address %q is invalid: %w", mysqlConfig.Addr, err)
+	}
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return replication.BinlogSyncerConfig{}, "", fmt.Errorf("the port %q is invalid: %w", port, err)
+	}
+
+	config := replication.BinlogSyncerConfig{
+		ServerID:         cfg.ChangeFeed.ServerID,
+		Flavor:           mysql.MySQLFlavor,
+		Host:             host,
+		Port:             uint16(portNumber),
+		User:             mysqlConfig.User,
+		Password:         mysqlConfig.Passwd,
+		HeartbeatPeriod:  defaultChangeFeedHeartbeatPeriod,
+		ReadTimeout:      3 * defaultChangeFeedHeartbeatPeriod,
+		DisableRetrySync: true,
+		Logger:           log.NewDefault(binlogLogHandler{}),
+	}
+	if cfg.ChangeFeed.User != "" {
+		config.User, config.Password = cfg.ChangeFeed.User, cfg.ChangeFeed.Password
+	}
+	if cfg.DSN == "" {
+		// the tls of the dsn is registered by its name, so the binlog of the dsn isn't read by tls
+		if config.TLSConfig, err = configTLS(cfg.Host, cfg.SSLMode, cfg.RootCertFile, cfg.CertFile, cfg.KeyFile); err != nil {
+			return replication.BinlogSyncerConfig{}, "", err
+		}
+	}
+	return config, mysqlConfig.DBName, nil
+}
+
+func (s *mysqlBinlogSource) executed(ctx context.Context) (*mysql.MysqlGTIDSet, error) {
+	var mode, executed string
+	if err := s.db.WithContext(ctx).Raw("SELECT @@GLOBAL.gtid_mode, @@GLOBAL.gtid_executed").Row().Scan(&mode, &executed); err != nil {
+		return nil, fmt.Errorf("failed to read the executed GTID set: %w", err)
+	}
+	if mode != "ON" {
+		return nil, fmt.Errorf("the binlog change feed requires gtid_mode=ON, got %s", mode)
+	}
+	gtids, err := mysql.ParseMysqlGTIDSet(strings.ReplaceAll(executed, "\n", ""))
+	if err != nil {
+		return nil, fmt.Errorf("the executed GTID set %q is malformed: %w", executed, err)
+	}
+	return gtids.(*mysql.MysqlGTIDSet), nil
+}
+
+func (s *mysqlBinlogSource) columns(ctx context.Context) ([]string, error) {
+	rows, err := s.db.WithContext(ctx).Raw("SELECT column_name FROM information_schema.columns " +
+		"WHERE table_schema = DATABASE() AND table_name = 'resources' ORDER BY ordinal_position").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		return nil, errors.New("the resources table doesn't exist")
+	}
+	return columns, nil
+}
+
+func (s *mysqlBinlogSource) stream(gtids *mysql.MysqlGTIDSet) (binlogStream, error) {
+	syncer := replication.NewBinlogSyncer(s.config)
+	streamer, err := syncer.StartSyncGTID(gtids.Clone())
+	if err != nil {
+		syncer.Close()
+		return nil, err
+	}
+	return &mysqlBinlogStream{BinlogStreamer: streamer, syncer: syncer}, nil
+}
+
+type mysqlBinlogStream struct {
+	*replication.BinlogStreamer
+	syncer *replication.BinlogSyncer
+}
+
+func (s *mysqlBinlogStream) Close() {
+	s.syncer.Close()
+}
+
+// binlogLogHandler writes the logs of the binlog syncer to the klog, the errors of the syncer are returned to the feed.
+type binlogLogHandler struct{}
+
+func (binlogLogHandler) Write(p []byte) (int, error) {
+	klog.V(4).InfoS(strings.TrimSpace(string(p)), "logger", "binlog")
+	return len(p), nil
+}
+
+func (binlogLogHandler) Close() error {
+	return nil
+}
@@ -0,0 +1,414 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/component-base/metrics/testutil"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// testdata/changefeed/resources.binlog is encoded by testdata/changefeed/generate.go, the transactions of the server
+// are committed one second apart from changeFeedFixtureStartedAt:
+//
+//	1: the DDL
+//	2: the deployment nginx and the pod nginx-0 are created
+//	3: the deployment is scaled
+//	4: the light update of the deployment
+//	5: the rows of the other table
+//	6: the rows of the resources table of the other database
+//	7: the row of the pod is replaced by the recreated pod
+//	8: the deployment is deleted
+const (
+	changeFeedFixture       = "testdata/changefeed/resources.binlog"
+	changeFeedFixtureServer = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	changeFeedTestServerID  = 1001
+)
+
+var (
+	changeFeedFixtureStartedAt = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	podsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	nginxObject = `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"nginx","namespace":"default",` +
+		`"uid":"7c3a4e4e-5b1d-4d8e-9a36-0f5a3c1b2d01","resourceVersion":"%s","labels":{"app":"nginx"}},` +
+		`"spec":{"replicas":%d,"paused":false},"status":{"replicas":1}}`
+	podObject = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx-0","namespace":"default",` +
+		`"uid":"%s","resourceVersion":"%s","labels":{"app":"nginx"}},` +
+		`"spec":{"containers":[{"name":"nginx","image":"nginx:1.25"}],"nodeName":null},"status":%s}`
+)
+
+var errFixtureEnd = errors.New("the end of the fixture")
+
+// fakeBinlogSource replays the events of the fixture, the transactions in the GTID set of the stream are skipped
+// as the server skips them.
+type fakeBinlogSource struct {
+	events []*replication.BinlogEvent
+
+	mu            sync.Mutex
+	executedGTIDs string
+	// streamErrs are returned by the next streams.
+	streamErrs []error
+	// failAfter fails the next stream after the number of events if it is positive.
+	failAfter int
+	// follow blocks the streams at the end of the fixture instead of failing them.
+	follow  bool
+	streams int
+}
+
+func newFakeBinlogSource(t *testing.T) *fakeBinlogSource {
+	source := &fakeBinlogSource{executedGTIDs: changeFeedFixtureServer + ":1-8"}
+	err := replication.NewBinlogParser().ParseFile(changeFeedFixture, 0, func(event *replication.BinlogEvent) error {
+		source.events = append(source.events, event)
+		return nil
+	})
+	require.NoError(t, err)
+	return source
+}
+
+func (s *fakeBinlogSource) executed(_ context.Context) (*mysql.MysqlGTIDSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gtids, err := mysql.ParseMysqlGTIDSet(s.executedGTIDs)
+	if err != nil {
+		return nil, err
+	}
+	return gtids.(*mysql.MysqlGTIDSet), nil
+}
+
+func (s *fakeBinlogSource) columns(_ context.Context) ([]string, error) {
+	return []string{"id", "group", "version", "resource", "kind", "cluster", "namespace", "name", "owner_uid", "uid",
+		"resource_version", "object", "metadata", "spec", "status", "checksum", "content_hash", "key_hash",
+		"created_at", "synced_at", "deleted_at"}, nil
+}
+
+func (s *fakeBinlogSource) stream(gtids *mysql.MysqlGTIDSet) (binlogStream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams++
+	if len(s.streamErrs) != 0 {
+		err := s.streamErrs[0]
+		s.streamErrs = s.streamErrs[1:]
+		return nil, err
+	}
+
+	stream := &fakeBinlogStream{failAfter: s.failAfter, follow: s.follow}
+	s.failAfter = 0
+	skipped := false
+	for _, event := range s.events {
+		if e, ok := event.Event.(*replication.GTIDEvent); ok {
+			gtid, err := mysql.ParseMysqlGTIDSet(fmt.Sprintf("%s:%d", changeFeedFixtureServer, e.GNO))
+			if err != nil {
+				return nil, err
+			}
+			skipped = gtids.Contain(gtid)
+		}
+		if !skipped {
+			stream.events = append(stream.events, event)
+		}
+	}
+	return stream, nil
+}
+
+func (s *fakeBinlogSource) streamCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams
+}
+
+type fakeBinlogStream struct {
+	events    []*replication.BinlogEvent
+	failAfter int
+	follow    bool
+	sent      int
+}
+
+func (s *fakeBinlogStream) GetEvent(ctx context.Context) (*replication.BinlogEvent, error) {
+	if s.failAfter > 0 && s.sent == s.failAfter {
+		return nil, errors.New("connection reset by peer")
+	}
+	if s.sent == len(s.events) {
+		if !s.follow {
+			return nil, errFixtureEnd
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	s.sent++
+	return s.events[s.sent-1], nil
+}
+
+func (s *fakeBinlogStream) Close() {}
+
+func newChangeFeedTestFeed(t *testing.T, source *fakeBinlogSource, clock *clocktesting.FakeClock) (*gorm.DB, *watchHub, *changeFeed) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	require.NoError(t, db.AutoMigrate(&ChangeFeedPosition{}))
+
+	hub := newWatchHub(WatchHubConfig{Enabled: true})
+	feed := newChangeFeedWithSource(ChangeFeedConfig{Enabled: true, ServerID: changeFeedTestServerID}, source, hub, nil, clock)
+	feed.database = "clusterpedia"
+	feed.db = db
+	return db, hub, feed
+}
+
+func hubEvents(hub *watchHub, gvr schema.GroupVersionResource) []*hubEvent {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if log := hub.logs[gvr]; log != nil {
+		return append([]*hubEvent(nil), log.events...)
+	}
+	return nil
+}
+
+func setChangeFeedPosition(t *testing.T, feed *changeFeed, gtids string, syncedAt time.Time) {
+	position, err := mysql.ParseMysqlGTIDSet(gtids)
+	require.NoError(t, err)
+	feed.position, feed.syncedAt = position.(*mysql.MysqlGTIDSet), syncedAt
+}
+
+func loadChangeFeedPosition(t *testing.T, db *gorm.DB) ChangeFeedPosition {
+	var position ChangeFeedPosition
+	require.NoError(t, db.Where("server_id = ?", changeFeedTestServerID).First(&position).Error)
+	return position
+}
+
+func assertHubEvent(t *testing.T, event *hubEvent, eventType watch.EventType, name, object string) {
+	t.Helper()
+	assert.Equal(t, eventType, event.eventType)
+	assert.Equal(t, "cluster-1", event.cluster)
+	assert.Equal(t, "default", event.namespace)
+	assert.Equal(t, name, event.name)
+	assert.Equal(t, map[string]string{"app": "nginx"}, event.labels)
+	assert.JSONEq(t, object, string(event.object))
+}
+
+func TestChangeFeedTail(t *testing.T) {
+	source := newFakeBinlogSource(t)
+	clock := clocktesting.NewFakeClock(changeFeedFixtureStartedAt.Add(8 * time.Second))
+	db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+	setChangeFeedPosition(t, feed, "", changeFeedFixtureStartedAt)
+
+	assert.ErrorIs(t, feed.tail(), errFixtureEnd)
+
+	// the light update and the rows of the other table and database aren't published
+	deployments := hubEvents(hub, deploymentsGVR)
+	require.Len(t, deployments, 3)
+	assertHubEvent(t, deployments[0], watch.Added, "nginx", fmt.Sprintf(nginxObject, "100", 1))
+	assertHubEvent(t, deployments[1], watch.Modified, "nginx", fmt.Sprintf(nginxObject, "101", 2))
+	assertHubEvent(t, deployments[2], watch.Deleted, "nginx", fmt.Sprintf(nginxObject, "101", 2))
+
+	// the spec and status of the split pod are assembled
+	pods := hubEvents(hub, podsGVR)
+	require.Len(t, pods, 3)
+	assertHubEvent(t, pods[0], watch.Added, "nginx-0",
+		fmt.Sprintf(podObject, "0d6f1c58-2a8b-4f3e-8e0b-6e1f2a3b4c02", "200", `{"phase":"Running","restartCount":70000}`))
+	assertHubEvent(t, pods[1], watch.Deleted, "nginx-0",
+		fmt.Sprintf(podObject, "0d6f1c58-2a8b-4f3e-8e0b-6e1f2a3b4c02", "200", `{"phase":"Running","restartCount":70000}`))
+	assertHubEvent(t, pods[2], watch.Added, "nginx-0",
+		fmt.Sprintf(podObject, "5a9e7b13-c4d2-4b6a-b1f0-3d2c1e0f9a03", "300", `{"phase":"Pending"}`))
+
+	// the position is saved when the binlog stops
+	position := loadChangeFeedPosition(t, db)
+	assert.Equal(t, changeFeedFixtureServer+":1-8", position.GTIDSet)
+	assert.True(t, changeFeedFixtureStartedAt.Add(7*time.Second).Equal(position.SyncedAt))
+}
+
+func TestChangeFeedStart(t *testing.T) {
+	t.Run("first start", func(t *testing.T) {
+		source := newFakeBinlogSource(t)
+		source.follow = true
+		clock := clocktesting.NewFakeClock(changeFeedFixtureStartedAt.Add(time.Hour))
+		db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+
+		// the transactions executed before the first start aren't published
+		require.NoError(t, feed.start(db, false))
+		t.Cleanup(feed.close)
+		position := loadChangeFeedPosition(t, db)
+		assert.Equal(t, changeFeedFixtureServer+":1-8", position.GTIDSet)
+		assert.True(t, clock.Now().Equal(position.SyncedAt))
+
+		require.Eventually(t, func() bool { return source.streamCount() == 1 }, 5*time.Second, 5*time.Millisecond)
+		assert.Empty(t, hubEvents(hub, deploymentsGVR))
+		assert.Empty(t, hubEvents(hub, podsGVR))
+	})
+
+	t.Run("resume from the position", func(t *testing.T) {
+		source := newFakeBinlogSource(t)
+		source.follow = true
+		clock := clocktesting.NewFakeClock(changeFeedFixtureStartedAt.Add(8 * time.Second))
+		db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+		require.NoError(t, db.Create(&ChangeFeedPosition{
+			ServerID: changeFeedTestServerID,
+			GTIDSet:  changeFeedFixtureServer + ":1-3",
+			SyncedAt: changeFeedFixtureStartedAt.Add(2 * time.Second),
+		}).Error)
+
+		require.NoError(t, feed.start(db, false))
+		require.Eventually(t, func() bool {
+			return len(hubEvents(hub, deploymentsGVR)) == 1 && len(hubEvents(hub, podsGVR)) == 2
+		}, 5*time.Second, 5*time.Millisecond)
+		feed.close()
+
+		assert.Equal(t, watch.Deleted, hubEvents(hub, deploymentsGVR)[0].eventType)
+		pods := hubEvents(hub, podsGVR)
+		assert.Equal(t, watch.Deleted, pods[0].eventType)
+		assert.Equal(t, watch.Added, pods[1].eventType)
+		assert.Equal(t, changeFeedFixtureServer+":1-8", loadChangeFeedPosition(t, db).GTIDSet)
+	})
+}
+
+func TestChangeFeedReconnect(t *testing.T) {
+	source := newFakeBinlogSource(t)
+	clock := clocktesting.NewFakeClock(changeFeedFixtureStartedAt.Add(8 * time.Second))
+	db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+	setChangeFeedPosition(t, feed, "", changeFeedFixtureStartedAt)
+
+	// the binlog fails after the row of the deployment of the transaction 2:
+	// the format description, the previous GTIDs, the transaction 1, and the GTID, BEGIN, table map and rows of the transaction 2
+	source.failAfter = 8
+	err := feed.tail()
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, errChangeFeedBehind)
+	assert.Empty(t, hubEvents(hub, deploymentsGVR))
+	assert.Equal(t, changeFeedFixtureServer+":1", loadChangeFeedPosition(t, db).GTIDSet)
+
+	// the uncommitted transaction is tailed again, and the events are published once
+	assert.ErrorIs(t, feed.tail(), errFixtureEnd)
+	assert.Equal(t, 2, source.streamCount())
+	assert.Len(t, hubEvents(hub, deploymentsGVR), 3)
+	assert.Len(t, hubEvents(hub, podsGVR), 3)
+	assert.Equal(t, changeFeedFixtureServer+":1-8", loadChangeFeedPosition(t, db).GTIDSet)
+}
+
+func createChangeFeedTestResource(t *testing.T, db *gorm.DB, name string, syncedAt time.Time) *Resource {
+	resource := &Resource{
+		Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+		Cluster: "cluster-1", Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: "1",
+		Object: []byte(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"` + name + `","namespace":"default","labels":{"app":"nginx"}}}`),
+	}
+	require.NoError(t, db.Create(resource).Error)
+	require.NoError(t, db.Model(resource).UpdateColumn("synced_at", syncedAt).Error)
+	return resource
+}
+
+func TestChangeFeedFallBehindAndPoll(t *testing.T) {
+	source := newFakeBinlogSource(t)
+	now := changeFeedFixtureStartedAt.Add(time.Hour)
+	clock := clocktesting.NewFakeClock(now)
+	db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+	setChangeFeedPosition(t, feed, "", changeFeedFixtureStartedAt)
+	feed.dirty = true
+	require.NoError(t, feed.savePosition())
+
+	err := feed.tail()
+	assert.ErrorIs(t, err, errChangeFeedBehind)
+	assert.Empty(t, hubEvents(hub, deploymentsGVR))
+	assert.Equal(t, "", loadChangeFeedPosition(t, db).GTIDSet)
+
+	// the rows synced since the position are published as the modified objects
+	createChangeFeedTestResource(t, db, "nginx", now.Add(-2*time.Hour))
+	polled := createChangeFeedTestResource(t, db, "nginx-polled", now.Add(-10*time.Second))
+	require.NoError(t, feed.poll())
+	deployments := hubEvents(hub, deploymentsGVR)
+	require.Len(t, deployments, 1)
+	assertHubEvent(t, deployments[0], watch.Modified, "nginx-polled", string(polled.Object))
+
+	// the binlog is retried from the transactions executed before the poll
+	position := loadChangeFeedPosition(t, db)
+	assert.Equal(t, changeFeedFixtureServer+":1-8", position.GTIDSet)
+	assert.True(t, now.Equal(position.SyncedAt))
+
+	// the rows polled within the margin are published once
+	clock.Step(time.Second)
+	require.NoError(t, feed.poll())
+	assert.Len(t, hubEvents(hub, deploymentsGVR), 1)
+
+	require.NoError(t, db.Model(polled).UpdateColumn("synced_at", clock.Now()).Error)
+	require.NoError(t, feed.poll())
+	deployments = hubEvents(hub, deploymentsGVR)
+	require.Len(t, deployments, 2)
+	assert.Equal(t, "nginx-polled", deployments[1].name)
+}
+
+func TestChangeFeedRunFallsBackToPolling(t *testing.T) {
+	source := newFakeBinlogSource(t)
+	source.follow = true
+	source.streamErrs = []error{errors.New("connection refused")}
+	now := changeFeedFixtureStartedAt.Add(time.Hour)
+	clock := clocktesting.NewFakeClock(now)
+	db, hub, feed := newChangeFeedTestFeed(t, source, clock)
+	require.NoError(t, db.Create(&ChangeFeedPosition{
+		ServerID: changeFeedTestServerID,
+		GTIDSet:  changeFeedFixtureServer + ":1-8",
+		SyncedAt: now.Add(-5 * time.Second),
+	}).Error)
+	createChangeFeedTestResource(t, db, "nginx", now.Add(-10*time.Second))
+
+	fallbacks, err := testutil.GetCounterMetricValue(changeFeedFallbacksTotal.WithLabelValues("unavailable"))
+	require.NoError(t, err)
+
+	require.NoError(t, feed.start(db, true))
+	t.Cleanup(feed.close)
+	require.Eventually(t, func() bool { return len(hubEvents(hub, deploymentsGVR)) == 1 }, 5*time.Second, 5*time.Millisecond)
+	after, err := testutil.GetCounterMetricValue(changeFeedFallbacksTotal.WithLabelValues("unavailable"))
+	require.NoError(t, err)
+	assert.Equal(t, fallbacks+1, after)
+
+	// the binlog is retried after the retry interval
+	assert.Equal(t, 1, source.streamCount())
+	clock.Step(defaultChangeFeedRetryInterval)
+	require.Eventually(t, func() bool { return source.streamCount() == 2 }, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestNewChangeFeed(t *testing.T) {
+	config := func(modify func(cfg *Config)) *Config {
+		cfg := &Config{
+			Type: "mysql", Host: "127.0.0.1", Port: "3306", User: "clusterpedia", Password: "secret", Database: "clusterpedia",
+			ChangeFeed: ChangeFeedConfig{Enabled: true, ServerID: changeFeedTestServerID},
+		}
+		modify(cfg)
+		return cfg
+	}
+
+	feed, err := newChangeFeed(config(func(cfg *Config) { cfg.ChangeFeed.Enabled = false }), newWatchHub(WatchHubConfig{Enabled: true}), nil)
+	require.NoError(t, err)
+	assert.Nil(t, feed)
+
+	for name, cfg := range map[string]*Config{
+		"postgres":  config(func(cfg *Config) { cfg.Type = "postgres" }),
+		"routes":    config(func(cfg *Config) { cfg.Routes = []RouteConfig{{Database: "archive"}} }),
+		"server id": config(func(cfg *Config) { cfg.ChangeFeed.ServerID = 0 }),
+		"max lag":   config(func(cfg *Config) { cfg.ChangeFeed.MaxLag = -time.Second }),
+	} {
+		_, err := newChangeFeed(cfg, newWatchHub(WatchHubConfig{Enabled: true}), nil)
+		assert.Error(t, err, name)
+	}
+	_, err = newChangeFeed(config(func(*Config) {}), nil, nil)
+	assert.Error(t, err)
+
+	// the writes of the hub fed by the change feed don't publish their events
+	hub := newWatchHub(WatchHubConfig{Enabled: true})
+	feed, err = newChangeFeed(config(func(cfg *Config) { cfg.ChangeFeed.User = "replication" }), hub, nil)
+	require.NoError(t, err)
+	assert.False(t, hub.enabled())
+	assert.Equal(t, "clusterpedia", feed.database)
+	assert.Equal(t, "127.0.0.1", feed.syncerConfig.Host)
+	assert.Equal(t, uint16(3306), feed.syncerConfig.Port)
+	assert.Equal(t, "replication", feed.syncerConfig.User)
+	assert.Equal(t, uint32(changeFeedTestServerID), feed.syncerConfig.ServerID)
+}
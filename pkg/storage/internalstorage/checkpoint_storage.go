@@ -0,0 +1,75 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// Checkpoint is the watch progress of the resource synced from the cluster.
+type Checkpoint struct {
+	ID uint `gorm:"primaryKey"`
+
+	Cluster  string `gorm:"size:253;not null;uniqueIndex:uni_cluster_group_version_resource,length:100"`
+	Group    string `gorm:"size:63;not null;uniqueIndex:uni_cluster_group_version_resource"`
+	Version  string `gorm:"size:15;not null;uniqueIndex:uni_cluster_group_version_resource"`
+	Resource string `gorm:"size:63;not null;uniqueIndex:uni_cluster_group_version_resource"`
+
+	ResourceVersion string    `gorm:"size:255;not null"`
+	UpdatedAt       time.Time `gorm:"not null;autoUpdateTime"`
+}
+
+func init() {
+	registerMigration(migration{
+		version:  2,
+		name:     "create the checkpoints table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &Checkpoint{})
+		},
+	})
+}
+
+type CheckpointStore struct {
+	db *gorm.DB
+}
+
+var _ storage.CheckpointStore = &CheckpointStore{}
+
+func (s *CheckpointStore) Save(ctx context.Context, cluster string, gvr schema.GroupVersionResource, resourceVersion string) error {
+	checkpoint := Checkpoint{
+		Cluster:         cluster,
+		Group:           gvr.Group,
+		Version:         gvr.Version,
+		Resource:        gvr.Resource,
+		ResourceVersion: resourceVersion,
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cluster"}, {Name: "group"}, {Name: "version"}, {Name: "resource"}},
+		DoUpdates: clause.AssignmentColumns([]string{"resource_version", "updated_at"}),
+	}).Create(&checkpoint)
+	return InterpretDBError(fmt.Sprintf("%s/%s", cluster, gvr), result.Error)
+}
+
+func (s *CheckpointStore) Load(ctx context.Context, cluster string, gvr schema.GroupVersionResource) (string, error) {
+	var rvs []string
+	result := s.db.WithContext(ctx).Model(&Checkpoint{}).Where(map[string]interface{}{
+		"cluster":  cluster,
+		"group":    gvr.Group,
+		"version":  gvr.Version,
+		"resource": gvr.Resource,
+	}).Limit(1).Pluck("resource_version", &rvs)
+	if result.Error != nil {
+		return "", InterpretDBError(fmt.Sprintf("%s/%s", cluster, gvr), result.Error)
+	}
+	if len(rvs) == 0 {
+		return "", nil
+	}
+	return rvs[0], nil
+}
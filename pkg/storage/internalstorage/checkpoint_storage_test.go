@@ -0,0 +1,54 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func TestCheckpointStore(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	store := &CheckpointStore{db: db}
+	deployments := appsv1.SchemeGroupVersion.WithResource("deployments")
+	daemonsets := appsv1.SchemeGroupVersion.WithResource("daemonsets")
+
+	rv, err := store.Load(context.Background(), "cluster-1", deployments)
+	require.NoError(t, err)
+	assert.Empty(t, rv)
+
+	require.NoError(t, store.Save(context.Background(), "cluster-1", deployments, "10"))
+	require.NoError(t, store.Save(context.Background(), "cluster-1", daemonsets, "20"))
+	require.NoError(t, store.Save(context.Background(), "cluster-2", deployments, "30"))
+
+	// the checkpoint is overwritten by the later save
+	require.NoError(t, store.Save(context.Background(), "cluster-1", deployments, "15"))
+
+	rv, err = store.Load(context.Background(), "cluster-1", deployments)
+	require.NoError(t, err)
+	assert.Equal(t, "15", rv)
+
+	var count int64
+	require.NoError(t, db.Model(&Checkpoint{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+
+	factory := &StorageFactory{db: db}
+	require.NoError(t, factory.CleanClusterResource(context.Background(), "cluster-1", deployments))
+	rv, err = store.Load(context.Background(), "cluster-1", deployments)
+	require.NoError(t, err)
+	assert.Empty(t, rv)
+
+	require.NoError(t, factory.CleanCluster(context.Background(), "cluster-2"))
+	rv, err = store.Load(context.Background(), "cluster-2", deployments)
+	require.NoError(t, err)
+	assert.Empty(t, rv)
+
+	rv, err = store.Load(context.Background(), "cluster-1", daemonsets)
+	require.NoError(t, err)
+	assert.Equal(t, "20", rv)
+}
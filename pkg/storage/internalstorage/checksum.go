@@ -0,0 +1,171 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// ErrChecksumMismatch is the error of the object which is mismatched with the checksum of its row.
+var ErrChecksumMismatch = errors.New("the object is mismatched with its checksum")
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+var _ storage.ChecksumVerifier = &StorageFactory{}
+
+func init() {
+	registerMigration(migration{
+		version:  5,
+		name:     "add the checksum column to the resources table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return addColumnIfNotExists(db, &Resource{}, "Checksum")
+		},
+	})
+}
+
+// checkChecksumColumn records the database without the checksum column, whose checksums are neither written nor verified.
+func (s *StorageFactory) checkChecksumColumn(name string, db *gorm.DB) {
+	if db.Migrator().HasColumn(&Resource{}, "Checksum") {
+		return
+	}
+
+	klog.InfoS("The checksum column doesn't exist, the checksums of the objects are disabled until the migration 5 is applied", "database", name)
+	if s.checksumMissing == nil {
+		s.checksumMissing = make(map[*gorm.DB]bool)
+	}
+	s.checksumMissing[db] = true
+}
+
+// objectChecksum returns the crc32 of the object for the checksum column.
+//
+// The json columns of mysql and postgres don't keep the written text, e.g. the keys are reordered,
+// so the checksum is computed from the canonical json of the object, which is the same after the normalization.
+func objectChecksum(object []byte) (sql.NullInt64, error) {
+	var value interface{}
+	if err := json.Unmarshal(object, &value); err != nil {
+		return sql.NullInt64{}, err
+	}
+	canonical, err := json.Marshal(value)
+	if err != nil {
+		return sql.NullInt64{}, err
+	}
+	return sql.NullInt64{Int64: int64(crc32.Checksum(canonical, checksumTable)), Valid: true}, nil
+}
+
+// verifyChecksum verifies the object with the checksum of its row, the object without the checksum is not verified.
+func verifyChecksum(object []byte, checksum sql.NullInt64) error {
+	if !checksum.Valid {
+		return nil
+	}
+
+	computed, err := objectChecksum(object)
+	if err != nil {
+		return err
+	}
+	if computed != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// checksummedObject is implemented by the objects selected with the checksum of their rows.
+type checksummedObject interface {
+	verifyChecksum() error
+}
+
+func (res Resource) verifyChecksum() error {
+	return verifyChecksum(res.Object, res.Checksum)
+}
+
+func (data ResourceBytes) verifyChecksum() error {
+	return verifyChecksum(data.Object, data.Checksum)
+}
+
+// checksumRow is the row scanned by the checksum verification.
+type checksumRow struct {
+	ID        uint
+	Group     string
+	Resource  string
+	Cluster   string
+	Namespace string
+	Name      string
+	Object    datatypes.JSON
+	Checksum  sql.NullInt64
+}
+
+func (row checksumRow) rowID() uint {
+	return row.ID
+}
+
+// VerifyChecksums scans the resources in batches by the order of the id, and reports or deletes the resources
+// whose objects are mismatched with their checksums. The deleted resources are recreated by the sync when they
+// are relisted, e.g. after the clustersynchro-manager is restarted.
+func (s *StorageFactory) VerifyChecksums(ctx context.Context, opts storage.ChecksumVerifyOptions) (storage.ChecksumVerifySummary, error) {
+	var summary storage.ChecksumVerifySummary
+
+	databases := s.namedDatabases()
+	for name, db := range databases {
+		if s.checksumMissing[db] {
+			return summary, fmt.Errorf("database %s: the checksum column doesn't exist, the migration 5 is pending", name)
+		}
+	}
+
+	err := scanResources(ctx, databases, scanOptions{
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+		Cursor:        opts.Cursor,
+		Progress: func(cursor string) {
+			summary.Cursor = cursor
+			if opts.Progress != nil {
+				opts.Progress(summary)
+			}
+		},
+	}, []string{"id", "group", "resource", "cluster", "namespace", "name", "object", "checksum"}, func(db *gorm.DB, row checksumRow) error {
+		return verifyResourceChecksum(ctx, db, row, opts.Repair, &summary)
+	})
+	return summary, err
+}
+
+func verifyResourceChecksum(ctx context.Context, db *gorm.DB, row checksumRow, repair bool, summary *storage.ChecksumVerifySummary) error {
+	summary.Scanned++
+	if !row.Checksum.Valid {
+		summary.Unchecked++
+		return nil
+	}
+
+	err := verifyChecksum(row.Object, row.Checksum)
+	if err == nil {
+		return nil
+	}
+
+	summary.Mismatched++
+	checksumMismatchesTotal.WithLabelValues(row.Group, row.Resource).Inc()
+	klog.ErrorS(err, "The object is corrupted", "id", row.ID, "group", row.Group, "resource", row.Resource,
+		"cluster", row.Cluster, "namespace", row.Namespace, "name", row.Name)
+	if !repair {
+		return nil
+	}
+
+	// the checksum of the row guards the update of the sync during the scanning
+	result := db.WithContext(ctx).Where(map[string]interface{}{"id": row.ID, "checksum": row.Checksum}).Delete(&Resource{})
+	if result.Error != nil {
+		return InterpretDBError(fmt.Sprintf("resource %d", row.ID), result.Error)
+	}
+	if result.RowsAffected == 0 {
+		summary.Conflicted++
+		return nil
+	}
+	summary.Repaired++
+	checksumRepairsTotal.WithLabelValues(row.Group, row.Resource).Inc()
+	return nil
+}
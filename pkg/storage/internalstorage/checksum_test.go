@@ -0,0 +1,162 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestObjectChecksum(t *testing.T) {
+	checksum, err := objectChecksum([]byte(`{"kind":"Deployment","metadata":{"name":"a","labels":{"app":"<a>"}}}`))
+	require.NoError(t, err)
+	assert.True(t, checksum.Valid)
+
+	// the json normalized by the database has the same checksum
+	normalized, err := objectChecksum([]byte(`{"metadata": {"labels": {"app": "<a>"}, "name": "a"}, "kind": "Deployment"}`))
+	require.NoError(t, err)
+	assert.Equal(t, checksum, normalized)
+
+	changed, err := objectChecksum([]byte(`{"kind":"Deployment","metadata":{"name":"b","labels":{"app":"<a>"}}}`))
+	require.NoError(t, err)
+	assert.NotEqual(t, checksum, changed)
+
+	_, err = objectChecksum([]byte(`{"kind":`))
+	assert.Error(t, err)
+}
+
+func newChecksumTestResourceStorage(t *testing.T) (*ResourceStorage, func()) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1"},
+		}))
+	}
+	return rs, cleanup
+}
+
+func TestResourceStorage_VerifyChecksum(t *testing.T) {
+	rs, cleanup := newChecksumTestResourceStorage(t)
+	defer cleanup()
+
+	var resource Resource
+	require.NoError(t, rs.db.Where("name = ?", "b").First(&resource).Error)
+	require.True(t, resource.Checksum.Valid)
+
+	// the object is still valid json but mismatched with its checksum
+	require.NoError(t, rs.db.Model(&Resource{}).Where("name = ?", "b").
+		Update("object", `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"namespace":"default","name":"c"}}`).Error)
+
+	// the objects aren't verified by default
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "b", &appsv1.Deployment{}))
+	require.NoError(t, rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{}))
+
+	counter := checksumMismatchesTotal.WithLabelValues("apps", "deployments")
+	before, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+
+	rs.verifyChecksum = true
+	err = rs.Get(context.Background(), "cluster-1", "default", "b", &appsv1.Deployment{})
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "a", &appsv1.Deployment{}))
+
+	err = rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{})
+	var decodeErr *ObjectDecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, ResourceIdentity{Cluster: "cluster-1", Namespace: "default", Name: "b"}, decodeErr.Row)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{URLQuery: url.Values{URLQuerySkipUndecodable: []string{"true"}}}))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "a", list.Items[0].Name)
+
+	after, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), after-before)
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	rs, cleanup := newChecksumTestResourceStorage(t)
+	defer cleanup()
+
+	require.NoError(t, rs.db.Model(&Resource{}).Where("name = ?", "b").
+		Update("object", `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"namespace":"default","name":"c"}}`).Error)
+	// the row written before the checksums are supported
+	require.NoError(t, rs.db.Create(&Resource{
+		Cluster: "cluster-1", Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+		Namespace: "default", Name: "d", UID: "d", ResourceVersion: "1",
+		Object: []byte(`{"metadata":{"name":"d"}}`), CreatedAt: time.Now(),
+	}).Error)
+
+	factory := &StorageFactory{db: rs.db}
+	summary, err := factory.VerifyChecksums(context.Background(), storage.ChecksumVerifyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.ChecksumVerifySummary{Scanned: 3, Unchecked: 1, Mismatched: 1, Cursor: `{"default":3}`}, summary)
+
+	counter := checksumRepairsTotal.WithLabelValues("apps", "deployments")
+	before, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+
+	summary, err = factory.VerifyChecksums(context.Background(), storage.ChecksumVerifyOptions{Repair: true, BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, storage.ChecksumVerifySummary{Scanned: 3, Unchecked: 1, Mismatched: 1, Repaired: 1, Cursor: `{"default":3}`}, summary)
+
+	after, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), after-before)
+
+	var names []string
+	require.NoError(t, rs.db.Model(&Resource{}).Order("name").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"a", "d"}, names)
+}
+
+func TestChecksumColumnMissing(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.Migrator().DropColumn(&Resource{}, "Checksum"))
+
+	factory := &StorageFactory{db: db, verifyChecksum: true}
+	factory.checkChecksumColumn(DefaultDatabaseName, db)
+
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	s, err := factory.NewResourceStorage(config)
+	require.NoError(t, err)
+	rs := s.(*ResourceStorage)
+	assert.False(t, rs.verifyChecksum)
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", UID: "a", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	require.NoError(t, rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{}))
+
+	_, err = factory.VerifyChecksums(context.Background(), storage.ChecksumVerifyOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// ClusterLease is the lease of the cluster held by the writer, the token is the fencing token of the lease,
+// which increases each time the lease is acquired by another holder.
+type ClusterLease struct {
+	Cluster   string    `gorm:"size:253;primaryKey"`
+	Holder    string    `gorm:"size:253;not null"`
+	Token     int64     `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+func init() {
+	registerMigration(migration{
+		version:  13,
+		name:     "create the cluster_leases table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ClusterLease{})
+		},
+	})
+}
+
+var _ storage.ClusterLeaser = &StorageFactory{}
+
+// clusterLeases leases the clusters to the writers, and fences the writes by the leases if the fencing is enabled.
+//
+// The expiry of the lease is set and compared by the local clock of the writer, not the clock of the database,
+// so the clock skew between the processes of the writers must be much less than the duration of the leases.
+type clusterLeases struct {
+	db      *gorm.DB
+	fencing bool
+	now     func() time.Time
+
+	lock sync.Mutex
+	// verified are the leases verified by the fencing, the lease can't be acquired by another holder until it expires,
+	// so the writes of the verified lease aren't verified again until then.
+	verified map[string]ClusterLease
+}
+
+func newClusterLeases(db *gorm.DB, fencing bool) *clusterLeases {
+	return &clusterLeases{
+		db:       db,
+		fencing:  fencing,
+		now:      time.Now,
+		verified: make(map[string]ClusterLease),
+	}
+}
+
+func (s *StorageFactory) AcquireClusterLease(ctx context.Context, cluster, holder string, duration time.Duration) (storage.ClusterLease, error) {
+	return s.leases.acquire(ctx, cluster, holder, duration)
+}
+
+func (s *StorageFactory) RenewClusterLease(ctx context.Context, lease storage.ClusterLease, duration time.Duration) (storage.ClusterLease, error) {
+	return s.leases.renew(ctx, lease, duration)
+}
+
+func (l *clusterLeases) acquire(ctx context.Context, cluster, holder string, duration time.Duration) (storage.ClusterLease, error) {
+	if holder == "" || duration <= 0 {
+		return storage.ClusterLease{}, fmt.Errorf("cluster lease: holder and duration are required")
+	}
+
+	now := l.now()
+	var leases []ClusterLease
+	if err := l.db.WithContext(ctx).Where("cluster = ?", cluster).Limit(1).Find(&leases).Error; err != nil {
+		return storage.ClusterLease{}, InterpretDBError(cluster, err)
+	}
+	if len(leases) == 0 {
+		lease := ClusterLease{Cluster: cluster, Holder: holder, Token: 1, ExpiresAt: now.Add(duration)}
+		if err := l.db.WithContext(ctx).Create(&lease).Error; err != nil {
+			// the lease is created by another holder concurrently
+			return storage.ClusterLease{}, InterpretDBError(cluster, err)
+		}
+		return lease.storageLease(), nil
+	}
+
+	current := leases[0]
+	if current.Holder != holder && current.ExpiresAt.After(now) {
+		return storage.ClusterLease{}, storage.NewError(ErrConflict,
+			fmt.Errorf("the lease of the cluster %s is held by %s until %s", cluster, current.Holder, current.ExpiresAt.Format(time.RFC3339)))
+	}
+
+	lease := ClusterLease{Cluster: cluster, Holder: holder, Token: current.Token, ExpiresAt: now.Add(duration)}
+	if current.Holder != holder {
+		lease.Token++
+	}
+	// the lease is swapped by its current token and holder, so only one of the concurrent acquirers wins
+	result := l.db.WithContext(ctx).Model(&ClusterLease{}).
+		Where("cluster = ? AND holder = ? AND token = ?", cluster, current.Holder, current.Token).
+		Updates(map[string]interface{}{"holder": lease.Holder, "token": lease.Token, "expires_at": lease.ExpiresAt})
+	if result.Error != nil {
+		return storage.ClusterLease{}, InterpretDBError(cluster, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return storage.ClusterLease{}, storage.NewError(ErrConflict, fmt.Errorf("the lease of the cluster %s is acquired concurrently", cluster))
+	}
+	return lease.storageLease(), nil
+}
+
+// renew extends the lease, the expired lease can still be renewed if it isn't acquired by another holder.
+func (l *clusterLeases) renew(ctx context.Context, lease storage.ClusterLease, duration time.Duration) (storage.ClusterLease, error) {
+	if duration <= 0 {
+		return storage.ClusterLease{}, fmt.Errorf("cluster lease: duration is required")
+	}
+
+	lease.ExpiresAt = l.now().Add(duration)
+	result := l.db.WithContext(ctx).Model(&ClusterLease{}).
+		Where("cluster = ? AND holder = ? AND token = ?", lease.Cluster, lease.Holder, lease.Token).
+		Update("expires_at", lease.ExpiresAt)
+	if result.Error != nil {
+		return storage.ClusterLease{}, InterpretDBError(lease.Cluster, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return storage.ClusterLease{}, storage.NewError(ErrFenced, fmt.Errorf("the lease of the cluster %s with the token %d is lost", lease.Cluster, lease.Token))
+	}
+	return lease, nil
+}
+
+// fence returns the ErrFenced error if the fencing is enabled and the writer doesn't hold the lease of the cluster.
+//
+// The lease is checked before the write and outside its transaction, so a write can still land right after the lease
+// expires, e.g. if the write is slow. Another holder can't acquire the lease until it expires, so the duration of
+// the leases must be much longer than the writes.
+func (l *clusterLeases) fence(ctx context.Context, cluster string) error {
+	if l == nil || !l.fencing {
+		return nil
+	}
+
+	lease, ok := storage.ClusterLeaseFrom(ctx)
+	if !ok || lease.Cluster != cluster {
+		return storage.NewError(ErrFenced, fmt.Errorf("the write of the cluster %s has no lease", cluster))
+	}
+
+	now := l.now()
+	l.lock.Lock()
+	verified, ok := l.verified[cluster]
+	l.lock.Unlock()
+	if ok && verified.Holder == lease.Holder && verified.Token == lease.Token && now.Before(verified.ExpiresAt) {
+		return nil
+	}
+
+	var leases []ClusterLease
+	if err := l.db.WithContext(ctx).Where("cluster = ?", cluster).Limit(1).Find(&leases).Error; err != nil {
+		return InterpretDBError(cluster, err)
+	}
+	if len(leases) == 0 || leases[0].Holder != lease.Holder || leases[0].Token != lease.Token {
+		return storage.NewError(ErrFenced, fmt.Errorf("the lease of the cluster %s with the token %d is stale", cluster, lease.Token))
+	}
+	if !now.Before(leases[0].ExpiresAt) {
+		return storage.NewError(ErrFenced, fmt.Errorf("the lease of the cluster %s with the token %d is expired", cluster, lease.Token))
+	}
+
+	l.lock.Lock()
+	l.verified[cluster] = leases[0]
+	l.lock.Unlock()
+	return nil
+}
+
+func (lease ClusterLease) storageLease() storage.ClusterLease {
+	return storage.ClusterLease{Cluster: lease.Cluster, Holder: lease.Holder, Token: lease.Token, ExpiresAt: lease.ExpiresAt}
+}
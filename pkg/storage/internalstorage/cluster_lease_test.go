@@ -0,0 +1,141 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newClusterLeaseTestStorage(t *testing.T, fencing bool) (*ResourceStorage, *clusterLeases, *fakeClock) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	require.NoError(t, db.AutoMigrate(&ClusterLease{}))
+
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	leases := newClusterLeases(db, fencing)
+	leases.now = clock.Now
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.leases = leases
+	return rs, leases, clock
+}
+
+func TestClusterLeaseExpiry(t *testing.T) {
+	_, leases, clock := newClusterLeaseTestStorage(t, true)
+	ctx := context.Background()
+
+	lease, err := leases.acquire(ctx, "cluster-1", "shard-a", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), lease.Token)
+
+	// the unexpired lease can't be acquired by another holder
+	_, err = leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// the lease is kept by renewing it
+	clock.now = clock.now.Add(50 * time.Second)
+	lease, err = leases.renew(ctx, lease, time.Minute)
+	require.NoError(t, err)
+	clock.now = clock.now.Add(50 * time.Second)
+	_, err = leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	// the expired lease is acquired by another holder with the new token
+	clock.now = clock.now.Add(time.Minute)
+	taken, err := leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), taken.Token)
+
+	_, err = leases.renew(ctx, lease, time.Minute)
+	assert.ErrorIs(t, err, ErrFenced)
+
+	// the lease of the same holder is reacquired with the same token
+	reacquired, err := leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, taken.Token, reacquired.Token)
+}
+
+func TestClusterLeaseFencing(t *testing.T) {
+	rs, leases, clock := newClusterLeaseTestStorage(t, true)
+	ctx := context.Background()
+
+	// the write without the lease is rejected
+	assert.ErrorIs(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "1")), ErrFenced)
+
+	leaseA, err := leases.acquire(ctx, "cluster-1", "shard-a", time.Minute)
+	require.NoError(t, err)
+	ctxA := storage.WithClusterLease(ctx, leaseA)
+	require.NoError(t, rs.Create(ctxA, "cluster-1", newWriteBehindTestDeployment("a", "1")))
+
+	// the lease of the other cluster doesn't fence the writes of the cluster
+	assert.ErrorIs(t, rs.Create(ctxA, "cluster-2", newWriteBehindTestDeployment("a", "1")), ErrFenced)
+
+	// the shard a is paused longer than its lease, and the shard b takes over the cluster
+	clock.now = clock.now.Add(2 * time.Minute)
+	leaseB, err := leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	require.NoError(t, err)
+	ctxB := storage.WithClusterLease(ctx, leaseB)
+	require.NoError(t, rs.Update(ctxB, "cluster-1", newWriteBehindTestDeployment("a", "2")))
+
+	// the split-brain writes of the shard a are rejected
+	assert.ErrorIs(t, rs.Update(ctxA, "cluster-1", newWriteBehindTestDeployment("a", "3")), ErrFenced)
+	assert.ErrorIs(t, rs.Delete(ctxA, "cluster-1", newWriteBehindTestDeployment("a", "")), ErrFenced)
+
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "a", deploy))
+	assert.Equal(t, "2", deploy.ResourceVersion)
+
+	// the expired lease of the shard b fences its writes until it is renewed
+	clock.now = clock.now.Add(2 * time.Minute)
+	assert.ErrorIs(t, rs.Update(ctxB, "cluster-1", newWriteBehindTestDeployment("a", "3")), ErrFenced)
+	leaseB, err = leases.renew(ctx, leaseB, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, rs.Update(storage.WithClusterLease(ctx, leaseB), "cluster-1", newWriteBehindTestDeployment("a", "3")))
+}
+
+func TestClusterLeaseFencingDisabled(t *testing.T) {
+	rs, _, _ := newClusterLeaseTestStorage(t, false)
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newWriteBehindTestDeployment("a", "1")))
+}
+
+func TestClusterLeaseWriteBehind(t *testing.T) {
+	rs, leases, clock := newClusterLeaseTestStorage(t, true)
+	rs.writeBehind = newWriteBehindQueue(rs, &writeBehindOptions{queueSize: 10, batchSize: 10})
+	ctx := context.Background()
+
+	lease, err := leases.acquire(ctx, "cluster-1", "shard-a", time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, rs.Create(storage.WithClusterLease(ctx, lease), "cluster-1", newWriteBehindTestDeployment("a", "1")))
+
+	// the queued write keeps the lease of its writer, it is fenced if the lease is taken over before it is written
+	require.NoError(t, rs.Create(storage.WithClusterLease(ctx, lease), "cluster-1", newWriteBehindTestDeployment("b", "1")))
+	clock.now = clock.now.Add(2 * time.Minute)
+	_, err = leases.acquire(ctx, "cluster-1", "shard-b", time.Minute)
+	require.NoError(t, err)
+
+	// the fenced writes are dropped and reported by the flush
+	startWriteBehindWorker(rs.writeBehind)
+	err = rs.Flush(ctx)
+	assert.ErrorIs(t, err, ErrWriteDropped)
+	assert.ErrorIs(t, err, ErrFenced)
+	assert.ErrorIs(t, rs.Get(ctx, "cluster-1", "default", "a", &appsv1.Deployment{}), ErrNotFound)
+	assert.ErrorIs(t, rs.Get(ctx, "cluster-1", "default", "b", &appsv1.Deployment{}), ErrNotFound)
+}
@@ -0,0 +1,326 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const defaultRenameBatchSize = 500
+
+// ClusterRename is the rename of the cluster in progress, it is deleted when the rename is completed.
+// The resources renamed before the interruption are stored by the new name, so the collisions aren't checked
+// again when the rename is resumed.
+type ClusterRename struct {
+	OldName   string    `gorm:"size:253;primaryKey"`
+	NewName   string    `gorm:"size:253;not null"`
+	StartedAt time.Time `gorm:"not null"`
+}
+
+func init() {
+	registerMigration(migration{
+		version:  15,
+		name:     "create the cluster_renames table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ClusterRename{})
+		},
+	})
+}
+
+var _ storage.ClusterRenamer = &StorageFactory{}
+
+// clusterAliases maps the old names of the renamed clusters to their new names, so the queries of the old names
+// match the resources of the new names during the transition. The aliases only apply to the reads.
+type clusterAliases map[string]string
+
+func newClusterAliases(config map[string]string) (clusterAliases, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	aliases := make(clusterAliases, len(config))
+	for alias, cluster := range config {
+		if alias == "" || cluster == "" {
+			return nil, fmt.Errorf("cluster aliases: both the alias and the cluster are required")
+		}
+		if alias == cluster {
+			return nil, fmt.Errorf("cluster aliases: %s is the alias of itself", alias)
+		}
+		if _, ok := config[cluster]; ok {
+			return nil, fmt.Errorf("cluster aliases: the cluster %s of the alias %s is an alias too", cluster, alias)
+		}
+		aliases[alias] = cluster
+	}
+	return aliases, nil
+}
+
+// resolve returns the cluster of the alias, or the name itself if it isn't an alias.
+func (a clusterAliases) resolve(name string) string {
+	if cluster, ok := a[name]; ok {
+		return cluster
+	}
+	return name
+}
+
+// resolveListOptions returns the list options whose cluster names are resolved, the options are copied if any name is an alias.
+func (a clusterAliases) resolveListOptions(opts *internal.ListOptions) *internal.ListOptions {
+	for i, name := range opts.ClusterNames {
+		if _, ok := a[name]; !ok {
+			continue
+		}
+
+		opts = opts.DeepCopy()
+		for j := i; j < len(opts.ClusterNames); j++ {
+			opts.ClusterNames[j] = a.resolve(opts.ClusterNames[j])
+		}
+		return opts
+	}
+	return opts
+}
+
+// RenameCluster renames the cluster of the resources in each database by the batches of their ids, the cluster name annotation
+// of the objects is renamed too. The checkpoints, the history revisions and the audit records of the cluster are renamed
+// after the resources, and the lease of the old name is released.
+//
+// The synchro of the cluster should be stopped during the rename, so the rename is rejected if the lease of the old name is held.
+// The rename is recorded until it is completed, the interrupted rename is resumed by renaming the cluster again.
+func (s *StorageFactory) RenameCluster(ctx context.Context, oldName, newName string) error {
+	if oldName == "" || newName == "" {
+		return fmt.Errorf("rename cluster: both the old and new names are required")
+	}
+	if oldName == newName {
+		return fmt.Errorf("rename cluster: the old and new names are the same")
+	}
+
+	if err := s.startClusterRename(ctx, oldName, newName); err != nil {
+		return err
+	}
+	for name, db := range s.namedDatabases() {
+		if err := s.renameClusterResources(ctx, db, oldName, newName); err != nil {
+			return InterpretDBError(fmt.Sprintf("%s/%s", name, oldName), err)
+		}
+		if err := renameClusterInBatches(ctx, db, &ResourceHistory{}, oldName, newName); err != nil {
+			return InterpretDBError(fmt.Sprintf("%s/%s", name, oldName), err)
+		}
+	}
+	s.getCache.purge()
+	s.notFoundCache.purge()
+
+	// the checkpoints, the audit records and the leases are always stored in the default database
+	for _, model := range []interface{}{&Checkpoint{}, &AuditRecord{}} {
+		if err := renameClusterInBatches(ctx, s.db, model, oldName, newName); err != nil {
+			return InterpretDBError(oldName, err)
+		}
+	}
+	if s.db.Migrator().HasTable(&ClusterLease{}) {
+		if err := s.db.WithContext(ctx).Where("cluster = ?", oldName).Delete(&ClusterLease{}).Error; err != nil {
+			return InterpretDBError(oldName, err)
+		}
+	}
+	return InterpretDBError(oldName, s.db.WithContext(ctx).Where("old_name = ?", oldName).Delete(&ClusterRename{}).Error)
+}
+
+// startClusterRename records the rename after the collisions are checked, the recorded rename of the same names is resumed.
+func (s *StorageFactory) startClusterRename(ctx context.Context, oldName, newName string) error {
+	db := s.db.WithContext(ctx)
+	var renames []ClusterRename
+	if err := db.Where("old_name = ? OR new_name = ? OR old_name = ?", oldName, newName, newName).Find(&renames).Error; err != nil {
+		return InterpretDBError(oldName, err)
+	}
+	for _, rename := range renames {
+		if rename.OldName == oldName && rename.NewName == newName {
+			return nil
+		}
+	}
+	if len(renames) != 0 {
+		rename := renames[0]
+		return storage.NewError(ErrConflict, fmt.Errorf("the cluster %s is being renamed to %s since %s", rename.OldName, rename.NewName, rename.StartedAt.Format(time.RFC3339)))
+	}
+
+	if db.Migrator().HasTable(&ClusterLease{}) {
+		var leases []ClusterLease
+		if err := db.Where("cluster = ? AND expires_at > ?", oldName, time.Now()).Find(&leases).Error; err != nil {
+			return InterpretDBError(oldName, err)
+		}
+		if len(leases) != 0 {
+			return storage.NewError(ErrConflict, fmt.Errorf("the cluster %s is synced by %s, the synchro should be stopped before the rename", oldName, leases[0].Holder))
+		}
+	}
+
+	for name, db := range s.namedDatabases() {
+		var ids []uint
+		if err := db.WithContext(ctx).Model(&Resource{}).Where("cluster = ?", newName).Limit(1).Pluck("id", &ids).Error; err != nil {
+			return InterpretDBError(newName, err)
+		}
+		if len(ids) != 0 {
+			return storage.NewError(ErrConflict, fmt.Errorf("the cluster %s already has the stored resources in the database %s", newName, name))
+		}
+	}
+	var ids []uint
+	if err := db.Model(&Checkpoint{}).Where("cluster = ?", newName).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return InterpretDBError(newName, err)
+	}
+	if len(ids) != 0 {
+		return storage.NewError(ErrConflict, fmt.Errorf("the cluster %s already has the checkpoints", newName))
+	}
+
+	rename := ClusterRename{OldName: oldName, NewName: newName, StartedAt: time.Now()}
+	return InterpretDBError(oldName, db.Create(&rename).Error)
+}
+
+// renameClusterResources renames the resources of the old name by the batches, each batch is renamed in a transaction.
+// The renamed resources don't match the old name, so each batch selects the first resources of the old name.
+func (s *StorageFactory) renameClusterResources(ctx context.Context, db *gorm.DB, oldName, newName string) error {
+	columns := []string{"id", "group", "version", "resource", "namespace", "name", "object", "metadata"}
+	if !s.splitColumnsMissing[db] {
+		columns = append(columns, "spec", "status")
+	}
+
+	for {
+		var resources []Resource
+		if err := db.WithContext(ctx).Select(columns).Where("cluster = ?", oldName).Order("id").Limit(defaultRenameBatchSize).Find(&resources).Error; err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			return nil
+		}
+
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			for _, resource := range resources {
+				renamed, err := s.renamedResourceColumns(db, resource, newName)
+				if err != nil {
+					return fmt.Errorf("resource %d: %w", resource.ID, err)
+				}
+				// the synced time is kept, the resource isn't synced by the rename
+				if err := tx.Model(&Resource{}).Where("id = ?", resource.ID).UpdateColumns(renamed).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(resources) < defaultRenameBatchSize {
+			return nil
+		}
+	}
+}
+
+// renamedResourceColumns returns the columns of the resource renamed to the new name, the cluster name annotation
+// of the object and the metadata is replaced, and the columns derived from the object are recomputed.
+func (s *StorageFactory) renamedResourceColumns(db *gorm.DB, resource Resource, newName string) (map[string]interface{}, error) {
+	columns := map[string]interface{}{"cluster": newName}
+	if !s.keyHashMissing[db] {
+		columns["key_hash"] = resourceKeyHash(resource.Group, resource.Version, resource.Resource, newName, resource.Namespace, resource.Name)
+	}
+
+	metadata, renamed, err := renameClusterAnnotation(resource.Metadata, newName)
+	if err != nil {
+		return nil, err
+	}
+	if renamed {
+		columns["metadata"] = datatypes.JSON(metadata)
+	}
+
+	object, err := s.encryption.decrypt(resource.Object)
+	if err != nil {
+		return nil, err
+	}
+	if object, renamed, err = renameObjectClusterAnnotation(object, newName); err != nil || !renamed {
+		return columns, err
+	}
+
+	stored := object
+	if _, _, encrypted := parseEncryptedObject(resource.Object); encrypted {
+		if stored, err = s.encryption.encrypt(object); err != nil {
+			return nil, err
+		}
+	}
+	columns["object"] = datatypes.JSON(stored)
+	if !s.checksumMissing[db] {
+		if columns["checksum"], err = objectChecksum(stored); err != nil {
+			return nil, err
+		}
+	}
+	if !s.contentHashMissing[db] {
+		if columns["content_hash"], err = objectContentHash(assembleObject(object, resource.Spec, resource.Status)); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+// renameObjectClusterAnnotation replaces the cluster name annotation of the object injected by the synchro.
+func renameObjectClusterAnnotation(object []byte, newName string) ([]byte, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(object, &fields); err != nil {
+		return nil, false, err
+	}
+	metadata, renamed, err := renameClusterAnnotation(fields["metadata"], newName)
+	if err != nil || !renamed {
+		return object, false, err
+	}
+	fields["metadata"] = metadata
+	encoded, err := json.Marshal(fields)
+	return encoded, err == nil, err
+}
+
+// renameClusterAnnotation replaces the cluster name annotation of the metadata, the other fields are kept as they are.
+func renameClusterAnnotation(metadata []byte, newName string) ([]byte, bool, error) {
+	if len(metadata) == 0 {
+		return metadata, false, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(metadata, &fields); err != nil {
+		return nil, false, err
+	}
+	var annotations map[string]string
+	if raw, ok := fields["annotations"]; ok {
+		if err := json.Unmarshal(raw, &annotations); err != nil {
+			return nil, false, err
+		}
+	}
+	if _, ok := annotations[internal.ShadowAnnotationClusterName]; !ok {
+		return metadata, false, nil
+	}
+
+	annotations[internal.ShadowAnnotationClusterName] = newName
+	var err error
+	if fields["annotations"], err = json.Marshal(annotations); err != nil {
+		return nil, false, err
+	}
+	encoded, err := json.Marshal(fields)
+	return encoded, err == nil, err
+}
+
+// renameClusterInBatches renames the cluster of the rows of the table by the batches of their ids,
+// the table is skipped if it doesn't exist, e.g. the migrations aren't applied.
+func renameClusterInBatches(ctx context.Context, db *gorm.DB, model interface{}, oldName, newName string) error {
+	if !db.Migrator().HasTable(model) {
+		return nil
+	}
+	for {
+		var ids []uint
+		if err := db.WithContext(ctx).Model(model).Where("cluster = ?", oldName).Order("id").Limit(defaultRenameBatchSize).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if err := db.WithContext(ctx).Model(model).Where("id IN ?", ids).UpdateColumn("cluster", newName).Error; err != nil {
+			return err
+		}
+		if len(ids) < defaultRenameBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newClusterRenameTestFactory(t *testing.T) *StorageFactory {
+	db, err := gorm.Open(gsqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Resource{}, &Checkpoint{}, &ResourceHistory{}, &ClusterLease{}, &ClusterRename{}))
+
+	for _, pod := range []struct{ cluster, name string }{{"cluster-1", "pod-1"}, {"cluster-1", "pod-2"}, {"cluster-2", "pod-3"}} {
+		metadata := fmt.Sprintf(`{"name":%q,"namespace":"default","resourceVersion":"1","annotations":{%q:%q}}`, pod.name, internal.ShadowAnnotationClusterName, pod.cluster)
+		object := fmt.Sprintf(`{"apiVersion":"v1","kind":"Pod","metadata":%s,"spec":{"nodeName":"node-1"}}`, metadata)
+		require.NoError(t, db.Create(&Resource{
+			Cluster: pod.cluster, Version: "v1", Resource: "pods", Kind: "Pod", Namespace: "default", Name: pod.name,
+			UID: "uid", ResourceVersion: "1", Object: []byte(object), Metadata: []byte(metadata),
+			KeyHash: resourceKeyHash("", "v1", "pods", pod.cluster, "default", pod.name), CreatedAt: time.Now(),
+		}).Error)
+	}
+	store := &CheckpointStore{db: db}
+	require.NoError(t, store.Save(context.Background(), "cluster-1", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "100"))
+	require.NoError(t, db.Create(&ResourceHistory{Cluster: "cluster-1", Version: "v1", Resource: "pods", Namespace: "default", Name: "pod-1", Object: []byte(`{}`)}).Error)
+	return &StorageFactory{db: db}
+}
+
+func countClusterRows(t *testing.T, db *gorm.DB, model interface{}, cluster string) int64 {
+	var count int64
+	require.NoError(t, db.Model(model).Where("cluster = ?", cluster).Count(&count).Error)
+	return count
+}
+
+func TestRenameCluster(t *testing.T) {
+	factory := newClusterRenameTestFactory(t)
+	ctx := context.Background()
+	require.NoError(t, factory.RenameCluster(ctx, "cluster-1", "cluster-3"))
+
+	for _, model := range []interface{}{&Resource{}, &Checkpoint{}, &ResourceHistory{}} {
+		assert.Zero(t, countClusterRows(t, factory.db, model, "cluster-1"))
+	}
+	assert.Equal(t, int64(1), countClusterRows(t, factory.db, &Checkpoint{}, "cluster-3"))
+	assert.Equal(t, int64(1), countClusterRows(t, factory.db, &ResourceHistory{}, "cluster-3"))
+
+	var resources []Resource
+	require.NoError(t, factory.db.Where("cluster = ?", "cluster-3").Order("name").Find(&resources).Error)
+	require.Len(t, resources, 2)
+	for _, resource := range resources {
+		assert.Equal(t, resourceKeyHash("", "v1", "pods", "cluster-3", "default", resource.Name), resource.KeyHash)
+		assert.True(t, resource.Checksum.Valid)
+
+		var object corev1.Pod
+		require.NoError(t, json.Unmarshal(resource.Object, &object))
+		assert.Equal(t, "cluster-3", object.Annotations[internal.ShadowAnnotationClusterName])
+		assert.Equal(t, "node-1", object.Spec.NodeName)
+		var metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		}
+		require.NoError(t, json.Unmarshal(resource.Metadata, &metadata))
+		assert.Equal(t, "cluster-3", metadata.Annotations[internal.ShadowAnnotationClusterName])
+	}
+
+	var renames int64
+	require.NoError(t, factory.db.Model(&ClusterRename{}).Count(&renames).Error)
+	assert.Zero(t, renames)
+}
+
+func TestRenameClusterConflict(t *testing.T) {
+	factory := newClusterRenameTestFactory(t)
+	ctx := context.Background()
+
+	err := factory.RenameCluster(ctx, "cluster-1", "cluster-2")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	assert.Equal(t, int64(2), countClusterRows(t, factory.db, &Resource{}, "cluster-1"))
+
+	// the synchro of the cluster still holds the lease
+	require.NoError(t, factory.db.Create(&ClusterLease{Cluster: "cluster-1", Holder: "synchro", Token: 1, ExpiresAt: time.Now().Add(time.Minute)}).Error)
+	assert.ErrorIs(t, factory.RenameCluster(ctx, "cluster-1", "cluster-3"), storage.ErrConflict)
+
+	assert.Error(t, factory.RenameCluster(ctx, "cluster-1", "cluster-1"))
+	assert.Error(t, factory.RenameCluster(ctx, "", "cluster-3"))
+}
+
+func TestRenameClusterResume(t *testing.T) {
+	factory := newClusterRenameTestFactory(t)
+	ctx := context.Background()
+
+	// the rename is interrupted after the first resource is renamed
+	require.NoError(t, factory.db.Create(&ClusterRename{OldName: "cluster-1", NewName: "cluster-3", StartedAt: time.Now()}).Error)
+	require.NoError(t, factory.renameClusterResources(ctx, factory.db.Where("name = ?", "pod-1"), "cluster-1", "cluster-3"))
+	require.Equal(t, int64(1), countClusterRows(t, factory.db, &Resource{}, "cluster-3"))
+
+	// the cluster can't be renamed to another name until the rename is completed
+	assert.ErrorIs(t, factory.RenameCluster(ctx, "cluster-1", "cluster-4"), storage.ErrConflict)
+
+	require.NoError(t, factory.RenameCluster(ctx, "cluster-1", "cluster-3"))
+	assert.Equal(t, int64(2), countClusterRows(t, factory.db, &Resource{}, "cluster-3"))
+	assert.Zero(t, countClusterRows(t, factory.db, &Resource{}, "cluster-1"))
+}
+
+func TestResourceStorage_ClusterAliases(t *testing.T) {
+	factory := newClusterRenameTestFactory(t)
+	ctx := context.Background()
+	require.NoError(t, factory.RenameCluster(ctx, "cluster-1", "cluster-3"))
+
+	aliases, err := newClusterAliases(map[string]string{"cluster-1": "cluster-3"})
+	require.NoError(t, err)
+	gr := schema.GroupResource{Resource: "pods"}
+	resourceConfig, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gr, true)
+	require.NoError(t, err)
+	rs := newTestResourceStorage(factory.db, corev1.SchemeGroupVersion.WithResource("pods"))
+	rs.codec = resourceConfig.Codec
+	rs.clusterAliases = aliases
+
+	var pod corev1.Pod
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "pod-1", &pod))
+	assert.Equal(t, "cluster-3", pod.Annotations[internal.ShadowAnnotationClusterName])
+
+	var list corev1.PodList
+	require.NoError(t, rs.List(ctx, &list, &internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}}))
+	assert.Len(t, list.Items, 3)
+}
+
+func TestNewClusterAliases(t *testing.T) {
+	aliases, err := newClusterAliases(map[string]string{"old": "new"})
+	require.NoError(t, err)
+	assert.Equal(t, "new", aliases.resolve("old"))
+	assert.Equal(t, "other", aliases.resolve("other"))
+
+	opts := &internal.ListOptions{ClusterNames: []string{"other", "old"}}
+	assert.Equal(t, []string{"other", "new"}, aliases.resolveListOptions(opts).ClusterNames)
+	assert.Equal(t, []string{"other", "old"}, opts.ClusterNames)
+
+	for _, config := range []map[string]string{{"old": "old"}, {"old": ""}, {"a": "b", "b": "c"}} {
+		_, err := newClusterAliases(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,277 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+var _ storage.ClusterStateDumper = &StorageFactory{}
+
+// dumpRecord is a line of the dump of the cluster state, only one of the fields is set.
+type dumpRecord struct {
+	Resource   *exportRecord                 `json:"resource,omitempty"`
+	Checkpoint *dumpedCheckpoint             `json:"checkpoint,omitempty"`
+	Manifest   *storage.ClusterStateManifest `json:"manifest,omitempty"`
+}
+
+type dumpedCheckpoint struct {
+	Cluster         string    `json:"cluster"`
+	Group           string    `json:"group,omitempty"`
+	Version         string    `json:"version"`
+	Resource        string    `json:"resource"`
+	ResourceVersion string    `json:"resourceVersion"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// clusterStateChecksum is the sum of the hashes of the resources and the checkpoints, so it doesn't depend on their order.
+// The objects are hashed by their canonical json, since the json may be normalized by the database, e.g. the jsonb of postgres.
+type clusterStateChecksum uint64
+
+func (c *clusterStateChecksum) addResource(record *exportRecord) error {
+	checksum, err := objectChecksum(record.Object)
+	if err != nil {
+		return err
+	}
+	c.add("resource", record.Cluster, record.Group, record.Version, record.Resource, strconv.FormatInt(checksum.Int64, 10))
+	return nil
+}
+
+func (c *clusterStateChecksum) addCheckpoint(checkpoint *dumpedCheckpoint) {
+	c.add("checkpoint", checkpoint.Cluster, checkpoint.Group, checkpoint.Version, checkpoint.Resource, checkpoint.ResourceVersion)
+}
+
+func (c *clusterStateChecksum) add(fields ...string) {
+	h := fnv.New64a()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	*c += clusterStateChecksum(h.Sum64())
+}
+
+func (c clusterStateChecksum) String() string {
+	return fmt.Sprintf("%016x", uint64(c))
+}
+
+// DumpClusterState dumps the resources of each database in a read-only transaction by the order of the id,
+// and then the checkpoints of the cluster. The objects are dumped in plaintext, and written by the config of the restoring storage.
+//
+// The synchro of the cluster should be stopped during the dump, otherwise the dump isn't a consistent snapshot across the databases.
+func (s *StorageFactory) DumpClusterState(ctx context.Context, cluster string, opts storage.DumpOptions, w io.Writer) (storage.ClusterStateManifest, error) {
+	encoder := json.NewEncoder(w)
+	manifest, err := s.scanClusterState(ctx, cluster, opts.BatchSize, func(record dumpRecord) error {
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return manifest, err
+	}
+	manifest.DumpedAt = time.Now().UTC()
+	if err := encoder.Encode(dumpRecord{Manifest: &manifest}); err != nil {
+		return manifest, err
+	}
+	return manifest, nil
+}
+
+// scanClusterState scans the resources and the checkpoints of the cluster, and returns the manifest of them.
+func (s *StorageFactory) scanClusterState(ctx context.Context, cluster string, batchSize int, fn func(record dumpRecord) error) (storage.ClusterStateManifest, error) {
+	manifest := storage.ClusterStateManifest{Cluster: cluster}
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+
+	var checksum clusterStateChecksum
+	databases := s.namedDatabases()
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		db := databases[name]
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var lastID uint
+			for {
+				var resources []Resource
+				if err := tx.Where("cluster = ? AND id > ?", cluster, lastID).Order("id").Limit(batchSize).Find(&resources).Error; err != nil {
+					return err
+				}
+
+				for _, resource := range resources {
+					object, err := s.encryption.decrypt(resource.Object)
+					if err != nil {
+						return fmt.Errorf("resource %d: %w", resource.ID, err)
+					}
+					createdAt, syncedAt := resource.CreatedAt.UTC(), resource.SyncedAt.UTC()
+					record := &exportRecord{
+						Cluster:   resource.Cluster,
+						Group:     resource.Group,
+						Version:   resource.Version,
+						Resource:  resource.Resource,
+						Kind:      resource.Kind,
+						Object:    json.RawMessage(assembleObject(object, resource.Spec, resource.Status)),
+						CreatedAt: &createdAt,
+						SyncedAt:  &syncedAt,
+					}
+					if err := checksum.addResource(record); err != nil {
+						return fmt.Errorf("resource %d: %w", resource.ID, err)
+					}
+					if fn != nil {
+						if err := fn(dumpRecord{Resource: record}); err != nil {
+							return err
+						}
+					}
+					manifest.Resources++
+					lastID = resource.ID
+				}
+				if len(resources) < batchSize {
+					return nil
+				}
+			}
+		}, exportTxOptions(db))
+		if err != nil {
+			return manifest, InterpretDBError(name, err)
+		}
+	}
+
+	// the checkpoints are always stored in the default database
+	var checkpoints []Checkpoint
+	if err := s.db.WithContext(ctx).Where("cluster = ?", cluster).Order("id").Find(&checkpoints).Error; err != nil {
+		return manifest, InterpretDBError(cluster, err)
+	}
+	for _, checkpoint := range checkpoints {
+		record := &dumpedCheckpoint{
+			Cluster:         checkpoint.Cluster,
+			Group:           checkpoint.Group,
+			Version:         checkpoint.Version,
+			Resource:        checkpoint.Resource,
+			ResourceVersion: checkpoint.ResourceVersion,
+			UpdatedAt:       checkpoint.UpdatedAt.UTC(),
+		}
+		checksum.addCheckpoint(record)
+		if fn != nil {
+			if err := fn(dumpRecord{Checkpoint: record}); err != nil {
+				return manifest, err
+			}
+		}
+		manifest.Checkpoints++
+	}
+	manifest.Checksum = checksum.String()
+	return manifest, nil
+}
+
+// RestoreClusterState restores the dump by the batch inserts of the importer, the created and synced times of the resources are kept.
+// The cursor is the number of the records of the dump which have been written, so the dump should be the same when the restore is resumed.
+//
+// The restored state is validated by the counts and the checksum of the manifest, the validation fails if the objects are changed
+// by the config of the storage, e.g. the redactions, or the storage has stored the other resources of the cluster.
+func (s *StorageFactory) RestoreClusterState(ctx context.Context, opts storage.RestoreOptions, r io.Reader) (summary storage.RestoreSummary, err error) {
+	summary.Cursor = opts.Cursor
+	var confirmed int64
+	if opts.Cursor != "" {
+		if confirmed, err = strconv.ParseInt(opts.Cursor, 10, 64); err != nil || confirmed < 0 {
+			return summary, fmt.Errorf("invalid cursor %q", opts.Cursor)
+		}
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+
+	// pending is the index of the record being restored, the records before it have been written when the importer is flushed
+	var pending int64
+	confirm := func(records int64) {
+		summary.Cursor = strconv.FormatInt(records, 10)
+		if opts.Progress != nil {
+			opts.Progress(summary.Cursor)
+		}
+	}
+	importer := &resourceImporter{ctx: ctx, factory: s, batchSize: batchSize}
+	importer.flushed = func() { confirm(pending) }
+	defer func() {
+		summary.Restored, summary.Skipped = importer.summary.Imported, importer.summary.Skipped
+	}()
+
+	var manifest *storage.ClusterStateManifest
+	decoder := json.NewDecoder(r)
+	for ; ; pending++ {
+		var record dumpRecord
+		if err := decoder.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return summary, fmt.Errorf("record %d: %w", pending, err)
+		}
+		if record.Manifest != nil {
+			manifest = record.Manifest
+			break
+		}
+		if pending < confirmed {
+			continue
+		}
+
+		switch {
+		case record.Resource != nil:
+			if err := importer.add(*record.Resource); err != nil {
+				return summary, fmt.Errorf("record %d: %w", pending, err)
+			}
+		case record.Checkpoint != nil:
+			if err := importer.flush(); err != nil {
+				return summary, err
+			}
+			if err := s.restoreCheckpoint(ctx, record.Checkpoint); err != nil {
+				return summary, err
+			}
+			summary.Checkpoints++
+			confirm(pending + 1)
+		}
+	}
+	if err := importer.flush(); err != nil {
+		return summary, err
+	}
+	if manifest == nil {
+		return summary, fmt.Errorf("the dump is truncated, its manifest is missing")
+	}
+	summary.Manifest = *manifest
+
+	restored, err := s.scanClusterState(ctx, manifest.Cluster, batchSize, nil)
+	if err != nil {
+		return summary, err
+	}
+	if restored.Resources != manifest.Resources || restored.Checkpoints != manifest.Checkpoints || restored.Checksum != manifest.Checksum {
+		return summary, fmt.Errorf("the restored state of the cluster %s is mismatched with the dump: %d resources, %d checkpoints and the checksum %s, but the dump has %d resources, %d checkpoints and the checksum %s",
+			manifest.Cluster, restored.Resources, restored.Checkpoints, restored.Checksum, manifest.Resources, manifest.Checkpoints, manifest.Checksum)
+	}
+	return summary, nil
+}
+
+func (s *StorageFactory) restoreCheckpoint(ctx context.Context, record *dumpedCheckpoint) error {
+	checkpoint := Checkpoint{
+		Cluster:         record.Cluster,
+		Group:           record.Group,
+		Version:         record.Version,
+		Resource:        record.Resource,
+		ResourceVersion: record.ResourceVersion,
+		UpdatedAt:       record.UpdatedAt,
+	}
+	result := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "cluster"}, {Name: "group"}, {Name: "version"}, {Name: "resource"}},
+		DoUpdates: clause.AssignmentColumns([]string{"resource_version", "updated_at"}),
+	}).Create(&checkpoint)
+	gvr := schema.GroupVersionResource{Group: record.Group, Version: record.Version, Resource: record.Resource}
+	return InterpretDBError(fmt.Sprintf("%s/%s", record.Cluster, gvr), result.Error)
+}
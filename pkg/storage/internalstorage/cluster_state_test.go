@@ -0,0 +1,146 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func newClusterStateTestFactory(t *testing.T) *StorageFactory {
+	db, err := gorm.Open(gsqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Resource{}, &Checkpoint{}))
+	return &StorageFactory{db: db}
+}
+
+func dumpClusterStateForTest(t *testing.T) (*StorageFactory, []byte) {
+	source := newClusterStateTestFactory(t)
+	createExportTestResources(t, source.db)
+	syncedAt := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, source.db.Model(&Resource{}).Where("1 = 1").Update("synced_at", syncedAt).Error)
+	store := &CheckpointStore{db: source.db}
+	require.NoError(t, store.Save(context.Background(), "cluster-1", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "100"))
+	require.NoError(t, store.Save(context.Background(), "cluster-2", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "200"))
+
+	var buffer bytes.Buffer
+	manifest, err := source.DumpClusterState(context.Background(), "cluster-1", storage.DumpOptions{BatchSize: 2}, &buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-1", manifest.Cluster)
+	assert.Equal(t, int64(3), manifest.Resources)
+	assert.Equal(t, int64(1), manifest.Checkpoints)
+	assert.NotEmpty(t, manifest.Checksum)
+	return source, buffer.Bytes()
+}
+
+func TestDumpAndRestoreClusterState(t *testing.T) {
+	source, dump := dumpClusterStateForTest(t)
+	// the resources, the checkpoint and the manifest
+	assert.Len(t, strings.Split(strings.TrimSpace(string(dump)), "\n"), 5)
+
+	target := newClusterStateTestFactory(t)
+	var cursors []string
+	summary, err := target.RestoreClusterState(context.Background(), storage.RestoreOptions{
+		BatchSize: 2,
+		Progress:  func(cursor string) { cursors = append(cursors, cursor) },
+	}, bytes.NewReader(dump))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), summary.Restored)
+	assert.Equal(t, int64(1), summary.Checkpoints)
+	assert.Equal(t, "4", summary.Cursor)
+	assert.Contains(t, cursors, "2")
+
+	var sourceResources, targetResources []Resource
+	require.NoError(t, source.db.Where("cluster = ?", "cluster-1").Order("name").Find(&sourceResources).Error)
+	require.NoError(t, target.db.Order("name").Find(&targetResources).Error)
+	require.Len(t, targetResources, len(sourceResources))
+	for i := range sourceResources {
+		assert.Equal(t, sourceResources[i].Name, targetResources[i].Name)
+		assert.True(t, sourceResources[i].CreatedAt.Equal(targetResources[i].CreatedAt))
+		assert.True(t, sourceResources[i].SyncedAt.Equal(targetResources[i].SyncedAt))
+		assert.Equal(t, sourceResources[i].OwnerUID, targetResources[i].OwnerUID)
+	}
+
+	rv, err := (&CheckpointStore{db: target.db}).Load(context.Background(), "cluster-1", schema.GroupVersionResource{Version: "v1", Resource: "pods"})
+	require.NoError(t, err)
+	assert.Equal(t, "100", rv)
+
+	// the restored state has the same manifest as the dump
+	restored, err := target.scanClusterState(context.Background(), "cluster-1", 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, summary.Manifest.Checksum, restored.Checksum)
+}
+
+// failingReader fails after reading the lines, like the interrupted stream of the dump.
+func failingReader(dump []byte, lines int) io.Reader {
+	var head []byte
+	for i := 0; i < lines; i++ {
+		end := bytes.IndexByte(dump, '\n')
+		head, dump = append(head, dump[:end+1]...), dump[end+1:]
+	}
+	return io.MultiReader(bytes.NewReader(head), brokenReader{})
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestRestoreClusterStateResume(t *testing.T) {
+	_, dump := dumpClusterStateForTest(t)
+	target := newClusterStateTestFactory(t)
+
+	var cursor string
+	_, err := target.RestoreClusterState(context.Background(), storage.RestoreOptions{
+		BatchSize: 2,
+		Progress:  func(c string) { cursor = c },
+	}, failingReader(dump, 3))
+	assert.Error(t, err)
+	assert.Equal(t, "2", cursor)
+
+	summary, err := target.RestoreClusterState(context.Background(), storage.RestoreOptions{BatchSize: 2, Cursor: cursor}, bytes.NewReader(dump))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Restored)
+	assert.Equal(t, int64(1), summary.Checkpoints)
+
+	var count int64
+	require.NoError(t, target.db.Model(&Resource{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestRestoreClusterStateValidation(t *testing.T) {
+	_, dump := dumpClusterStateForTest(t)
+
+	// the manifest is missing
+	lines := bytes.SplitAfter(dump, []byte("\n"))
+	truncated := bytes.Join(lines[:len(lines)-2], nil)
+	_, err := newClusterStateTestFactory(t).RestoreClusterState(context.Background(), storage.RestoreOptions{}, bytes.NewReader(truncated))
+	assert.ErrorContains(t, err, "truncated")
+
+	// the restored resources are mismatched with the checksum of the dump
+	tampered := bytes.Replace(dump, []byte(`"checksum":"`), []byte(`"checksum":"0`), 1)
+	_, err = newClusterStateTestFactory(t).RestoreClusterState(context.Background(), storage.RestoreOptions{}, bytes.NewReader(tampered))
+	assert.ErrorContains(t, err, "mismatched")
+
+	// the resources stored before the restore are different from the dump
+	target := newClusterStateTestFactory(t)
+	_, err = target.RestoreClusterState(context.Background(), storage.RestoreOptions{}, bytes.NewReader(dump))
+	require.NoError(t, err)
+	require.NoError(t, target.db.Where("name = ?", "pod-1").Delete(&Resource{}).Error)
+	require.NoError(t, target.db.Model(&Resource{}).Where("name = ?", "pod-2").Update("object", `{"metadata":{"name":"pod-2"}}`).Error)
+	summary, err := target.RestoreClusterState(context.Background(), storage.RestoreOptions{}, bytes.NewReader(dump))
+	assert.ErrorContains(t, err, "mismatched")
+	assert.Equal(t, int64(1), summary.Restored)
+	assert.Equal(t, int64(2), summary.Skipped)
+}
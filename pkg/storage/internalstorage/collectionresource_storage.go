@@ -4,16 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
 )
 
 const (
@@ -25,48 +27,94 @@ type CollectionResourceStorage struct {
 	db         *gorm.DB
 	typesQuery *gorm.DB
 
+	// router selects the database by the resource types in the url query,
+	// it is nil if the resources are stored in a single database.
+	router *databaseRouter
+
+	queryLimit QueryLimitConfig
+
+	// encryption decrypts the encrypted objects of the collection resource.
+	encryption *objectEncryption
+
+	// clusterAliases resolve the old names of the renamed clusters in the list options.
+	clusterAliases clusterAliases
+
+	// shadowAnnotations are injected into the returned objects by the rows.
+	shadowAnnotations shadowAnnotations
+
+	// splitObjects builds the json queries of the spec and status from the spec and status columns.
+	splitObjects bool
+
 	collectionResource *internal.CollectionResource
 }
 
 func NewCollectionResourceStorage(db *gorm.DB, cr *internal.CollectionResource) storage.CollectionResourceStorage {
-	storage := &CollectionResourceStorage{db: db, collectionResource: cr.DeepCopy()}
+	return newCollectionResourceStorage(db, nil, cr)
+}
+
+func newCollectionResourceStorage(db *gorm.DB, router *databaseRouter, cr *internal.CollectionResource) *CollectionResourceStorage {
+	storage := &CollectionResourceStorage{db: db, router: router, collectionResource: cr.DeepCopy()}
 	if len(cr.ResourceTypes) == 0 {
 		return storage
 	}
 
-	typesQuery := db
-	groups := make([]string, 0)
+	gvrs := make([]schema.GroupVersionResource, 0, len(cr.ResourceTypes))
 	for _, rt := range cr.ResourceTypes {
-		if rt.Resource == "" && rt.Version == "" {
-			groups = append(groups, rt.Group)
-			continue
-		}
+		gvrs = append(gvrs, schema.GroupVersionResource{Group: rt.Group, Version: rt.Version, Resource: rt.Resource})
+	}
+	storage.typesQuery = buildResourceTypesQuery(db, gvrs)
+	return storage
+}
 
-		where := map[string]interface{}{"group": rt.Group}
-		if rt.Resource != "" {
-			where["resource"] = rt.Resource
-		}
-		if rt.Version != "" {
-			where["version"] = rt.Version
+// buildResourceTypesQuery builds the condition to match the resources of the gvrs in a single query,
+// the fully specified gvrs are matched by `(group, version, resource) IN (...)`,
+// and the gvrs with only the group are matched by `group IN (...)`.
+func buildResourceTypesQuery(db *gorm.DB, gvrs []schema.GroupVersionResource) *gorm.DB {
+	var (
+		groups         []interface{}
+		resourceTuples []interface{}
+	)
+	typesQuery := db
+	for _, gvr := range gvrs {
+		switch {
+		case gvr.Version == "" && gvr.Resource == "":
+			groups = append(groups, gvr.Group)
+		case gvr.Version != "" && gvr.Resource != "":
+			resourceTuples = append(resourceTuples, []interface{}{gvr.Group, gvr.Version, gvr.Resource})
+		default:
+			where := map[string]interface{}{"group": gvr.Group}
+			if gvr.Version != "" {
+				where["version"] = gvr.Version
+			}
+			if gvr.Resource != "" {
+				where["resource"] = gvr.Resource
+			}
+			typesQuery = typesQuery.Or(where)
 		}
-		typesQuery = typesQuery.Or(where)
+	}
+	if len(resourceTuples) != 0 {
+		typesQuery = typesQuery.Or(clause.IN{
+			Column: []clause.Column{{Name: "group"}, {Name: "version"}, {Name: "resource"}},
+			Values: resourceTuples,
+		})
 	}
 	if len(groups) != 0 {
-		typesQuery = typesQuery.Or(map[string]interface{}{"group": groups})
+		typesQuery = typesQuery.Or(clause.IN{Column: clause.Column{Name: "group"}, Values: groups})
 	}
-	storage.typesQuery = typesQuery
-	return storage
+	return typesQuery
 }
 
 func (s *CollectionResourceStorage) query(ctx context.Context, opts *internal.ListOptions) (*gorm.DB, ObjectList, error) {
 	var result ObjectList = &ResourceList{}
 	if opts.OnlyMetadata {
-		result = &ResourceMetadataList{}
+		var err error
+		if result, err = newMetadataList(opts); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	query := s.db.WithContext(ctx).Model(&Resource{})
 	if s.typesQuery != nil {
-		return query.Where(s.typesQuery), result, nil
+		return querySplitObjects(s.db.WithContext(ctx).Model(&Resource{}), s.splitObjects).Where(s.typesQuery), result, nil
 	}
 
 	// The `URLQueryGroups` and `URLQueryResources` only works on *Any Collection Resource*,
@@ -78,30 +126,53 @@ func (s *CollectionResourceStorage) query(ctx context.Context, opts *internal.Li
 	if len(gvrs) == 0 && !all {
 		return nil, nil, apierrors.NewBadRequest("url query - `groups` or `resources` is required")
 	}
-
-	if all {
-		return query, result, nil
+	// the query of the restricted requester is filtered by the allowed clusters
+	if _, restricted := request.AllowedClustersFrom(ctx); all && len(opts.ClusterNames) == 0 && !restricted && s.queryLimit.RejectUnfilteredQuery {
+		return nil, nil, unfilteredQueryError()
 	}
 
-	typesQuery := s.db
-	for _, gvr := range gvrs {
-		where := map[string]interface{}{"group": gvr.Group}
-		if gvr.Version != "" {
-			where["version"] = gvr.Version
+	db := s.db
+	if s.router != nil {
+		if all {
+			db, err = s.router.singleDatabase(sets.New(s.router.names()...))
+		} else {
+			db, err = s.router.collectionDatabase(collectionResourceTypes(gvrs))
 		}
-		if gvr.Resource != "" {
-			where["resource"] = gvr.Resource
+		if err != nil {
+			return nil, nil, apierrors.NewBadRequest(err.Error())
 		}
-		typesQuery = typesQuery.Or(where)
 	}
-	return query.Where(typesQuery), result, nil
+
+	query := querySplitObjects(db.WithContext(ctx).Model(&Resource{}), s.splitObjects)
+	if all {
+		return query, result, nil
+	}
+
+	return query.Where(buildResourceTypesQuery(db, gvrs)), result, nil
 }
 
 func (s *CollectionResourceStorage) Get(ctx context.Context, opts *internal.ListOptions) (*internal.CollectionResource, error) {
+	opts, limited, err := s.queryLimit.limitListOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	pagination := s.listContinue(ctx, opts)
+	if opts, err = pagination.resolve(opts); err != nil {
+		return nil, err
+	}
+	opts = s.clusterAliases.resolveListOptions(opts)
+
 	query, list, err := s.query(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
+	version, err := parseStoredVersion(opts.URLQuery)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		query = query.Where("version = ?", version)
+	}
 	offset, amount, query, err := applyListOptionsToCollectionResourceQuery(query, opts)
 	if err != nil {
 		return nil, err
@@ -111,6 +182,9 @@ func (s *CollectionResourceStorage) Get(ctx context.Context, opts *internal.List
 		return nil, InterpretDBError(s.collectionResource.Name, err)
 	}
 	items := list.Items()
+	if limited && int64(len(items)) == opts.Limit {
+		addResultLimitedWarning(ctx, opts.Limit)
+	}
 	collection := &internal.CollectionResource{
 		TypeMeta:   s.collectionResource.TypeMeta,
 		ObjectMeta: s.collectionResource.ObjectMeta,
@@ -119,10 +193,18 @@ func (s *CollectionResourceStorage) Get(ctx context.Context, opts *internal.List
 
 	gvrs := make(map[schema.GroupVersionResource]struct{})
 	for _, resource := range items {
+		resource, err := s.encryption.decryptObject(resource)
+		if err != nil {
+			return nil, err
+		}
+		resource = assembleSplitObject(resource)
 		obj, err := resource.ConvertToUnstructured()
 		if err != nil {
 			return nil, err
 		}
+		if err := s.shadowAnnotations.inject(obj, resource.GetIdentity().Cluster, resource.GetResourceType().GroupVersionResource()); err != nil {
+			return nil, err
+		}
 		collection.Items = append(collection.Items, obj)
 
 		if resourceType := resource.GetResourceType(); !resourceType.Empty() {
@@ -141,7 +223,7 @@ func (s *CollectionResourceStorage) Get(ctx context.Context, opts *internal.List
 
 	if opts.WithContinue != nil && *opts.WithContinue {
 		if int64(len(items)) == opts.Limit {
-			collection.Continue = strconv.FormatInt(offset+opts.Limit, 10)
+			collection.Continue = pagination.encode(offset + opts.Limit)
 		}
 	}
 
@@ -155,6 +237,14 @@ func (s *CollectionResourceStorage) Get(ctx context.Context, opts *internal.List
 	return collection, nil
 }
 
+func collectionResourceTypes(gvrs []schema.GroupVersionResource) []internal.CollectionResourceType {
+	types := make([]internal.CollectionResourceType, 0, len(gvrs))
+	for _, gvr := range gvrs {
+		types = append(types, internal.CollectionResourceType{Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource})
+	}
+	return types
+}
+
 func resolveGVRsFromURLQuery(query url.Values) (gvrs []schema.GroupVersionResource, all bool, err error) {
 	if query.Has(URLQueryGroups) {
 		for _, group := range strings.Split(query.Get(URLQueryGroups), ",") {
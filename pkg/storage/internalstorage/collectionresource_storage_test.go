@@ -0,0 +1,156 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestBuildResourceTypesQuery(t *testing.T) {
+	gvrs := []schema.GroupVersionResource{
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+		{Group: "", Version: "v1", Resource: "namespaces"},
+		{Group: "batch", Resource: "jobs"},
+		{Group: "rbac.authorization.k8s.io"},
+	}
+
+	postgreSQL, err := toSQL(postgresDB, gvrs, func(query *gorm.DB, gvrs []schema.GroupVersionResource) (*gorm.DB, error) {
+		return query.Where(buildResourceTypesQuery(postgresDB, gvrs)), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT * FROM "resources" WHERE (("group" = 'batch' AND "resource" = 'jobs') OR ("group","version","resource") IN (('apps','v1','deployments'),('','v1','namespaces')) OR "group" = 'rbac.authorization.k8s.io')`, postgreSQL)
+
+	for version, mysqlDB := range mysqlDBs {
+		mysqlSQL, err := toSQL(mysqlDB, gvrs, func(query *gorm.DB, gvrs []schema.GroupVersionResource) (*gorm.DB, error) {
+			return query.Where(buildResourceTypesQuery(mysqlDB, gvrs)), nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT * FROM `resources` WHERE ((`group` = 'batch' AND `resource` = 'jobs') OR (`group`,`version`,`resource`) IN (('apps','v1','deployments'),('','v1','namespaces')) OR `group` = 'rbac.authorization.k8s.io')", mysqlSQL, version)
+	}
+}
+
+func TestCollectionResourceStorageMixedScopes(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	resources := []struct {
+		gvr       schema.GroupVersionResource
+		kind      string
+		namespace string
+		name      string
+	}{
+		{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "Deployment", "default", "a"},
+		{schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "Namespace", "", "b"},
+		{schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, "Deployment", "kube-system", "c"},
+		{schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}, "ClusterRole", "", "d"},
+		{schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, "Namespace", "", "e"},
+		// not in the collection resource
+		{schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "Pod", "default", "f"},
+	}
+	for i, r := range resources {
+		object, err := json.Marshal(map[string]interface{}{
+			"apiVersion": r.gvr.GroupVersion().String(),
+			"kind":       r.kind,
+			"metadata":   map[string]interface{}{"namespace": r.namespace, "name": r.name},
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, db.Create(&Resource{
+			Cluster:         "cluster-1",
+			Group:           r.gvr.Group,
+			Version:         r.gvr.Version,
+			Resource:        r.gvr.Resource,
+			Kind:            r.kind,
+			Namespace:       r.namespace,
+			Name:            r.name,
+			UID:             types.UID(fmt.Sprintf("uid-%d", i)),
+			ResourceVersion: "1",
+			Object:          object,
+		}).Error)
+	}
+
+	storage := NewCollectionResourceStorage(db, &internal.CollectionResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "mixed"},
+		ResourceTypes: []internal.CollectionResourceType{
+			{Group: "apps", Version: "v1", Resource: "deployments"},
+			{Group: "", Version: "v1", Resource: "namespaces"},
+			{Group: "rbac.authorization.k8s.io"},
+		},
+	})
+
+	withContinue, withRemainingCount := true, true
+	opts := &internal.ListOptions{
+		ListOptions:        metainternal.ListOptions{Limit: 2},
+		OrderBy:            []internal.OrderBy{{Field: "name"}},
+		WithContinue:       &withContinue,
+		WithRemainingCount: &withRemainingCount,
+	}
+
+	var names, kinds []string
+	for {
+		collection, err := storage.Get(context.Background(), opts)
+		require.NoError(t, err)
+
+		for _, item := range collection.Items {
+			obj := item.(*unstructured.Unstructured)
+			names = append(names, obj.GetName())
+			kinds = append(kinds, obj.GetKind())
+		}
+
+		// the remaining count is for the combined resources of the collection
+		require.NotNil(t, collection.RemainingItemCount)
+		assert.Equal(t, int64(5-len(names)), *collection.RemainingItemCount)
+
+		if collection.Continue == "" {
+			break
+		}
+		opts.Continue = collection.Continue
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, names)
+	assert.Equal(t, []string{"Deployment", "Namespace", "Deployment", "ClusterRole", "Namespace"}, kinds)
+}
+
+func TestCollectionResourceStorageStoredVersion(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i, version := range []string{"v1", "v1beta1", "v1beta2"} {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: "apps", Version: version, Resource: "deployments", Kind: "Deployment",
+			Namespace: "default", Name: fmt.Sprintf("deploy-%d", i), UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1",
+			Object: []byte(fmt.Sprintf(`{"apiVersion":"apps/%s","kind":"Deployment","metadata":{"namespace":"default","name":"deploy-%d"}}`, version, i)),
+		}).Error)
+	}
+
+	storage := NewCollectionResourceStorage(db, &internal.CollectionResource{
+		ObjectMeta:    metav1.ObjectMeta{Name: "apps"},
+		ResourceTypes: []internal.CollectionResourceType{{Group: "apps"}},
+	})
+
+	collection, err := storage.Get(context.Background(), &internal.ListOptions{
+		URLQuery: url.Values{URLQueryStoredVersion: []string{"v1beta1"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, collection.Items, 1)
+	assert.Equal(t, "apps/v1beta1", collection.Items[0].(*unstructured.Unstructured).GetAPIVersion())
+
+	_, err = storage.Get(context.Background(), &internal.ListOptions{
+		URLQuery: url.Values{URLQueryStoredVersion: []string{"V1;"}},
+	})
+	assert.Error(t, err)
+}
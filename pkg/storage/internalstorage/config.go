@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"gorm.io/gorm/logger"
 	"k8s.io/klog/v2"
@@ -21,6 +22,7 @@ const (
 	defaultMaxIdleConns     = 5
 	defaultMaxOpenConns     = 40
 	defaultConnMaxLifetime  = time.Hour
+	defaultOperationTimeout = time.Minute
 	databasePasswordEnvName = "DB_PASSWORD"
 )
 
@@ -41,7 +43,114 @@ type Config struct {
 	KeyFile      string `yaml:"sslKeyFile"`
 	RootCertFile string `yaml:"sslRootCertFile"`
 
-	ConnPool ConnPoolConfig `yaml:"connPool"`
+	ConnPool   ConnPoolConfig   `yaml:"connPool"`
+	Timeout    TimeoutConfig    `yaml:"timeout"`
+	QueryLimit QueryLimitConfig `yaml:"queryLimit"`
+
+	ConnectionBudget ConnectionBudgetConfig `yaml:"connectionBudget"`
+
+	// IndexHints are the index hints of the list queries of the shapes, they are only applied on MySQL
+	// whose optimizer may pick the wrong index for the queries with multiple filters.
+	IndexHints []IndexHintConfig `yaml:"indexHints"`
+
+	Attribution AttributionConfig `yaml:"attribution"`
+	GetCache    GetCacheConfig    `yaml:"getCache"`
+	ListCache   ListCacheConfig   `yaml:"listCache"`
+	WatchHub    WatchHubConfig    `yaml:"watchHub"`
+	ChangeFeed  ChangeFeedConfig  `yaml:"changeFeed"`
+
+	NotFoundCache NotFoundCacheConfig `yaml:"notFoundCache"`
+
+	// DisableGetSingleflight disables sharing the query of the concurrent get requests of the same object.
+	DisableGetSingleflight bool `yaml:"disableGetSingleflight"`
+
+	// DisableListDebug ignores the debug url query of the lists, which describes how the lists are queried in the responses,
+	// e.g. the limit, the offset and the filters pushed down to the SQL. It should be disabled in production.
+	DisableListDebug bool `yaml:"disableListDebug"`
+
+	// VerifyChecksum verifies the objects read by the get and list requests with the checksums of their rows,
+	// it's for debugging the corrupted objects, since each object is decoded once more.
+	VerifyChecksum bool `yaml:"verifyChecksum"`
+
+	// DecodeWorkers is the number of the workers decoding the objects of a list request in parallel,
+	// 1 decodes the objects sequentially. Default is GOMAXPROCS.
+	DecodeWorkers int `yaml:"decodeWorkers"`
+
+	// LightUpdates only writes the resource version and the synced_at of the updated object whose content is unchanged,
+	// e.g. by the no-op updates, the content is compared by the hash of the object without the resource version
+	// and the managed fields, so the stored object keeps the resource version and the managed fields of its last full update.
+	LightUpdates bool `yaml:"lightUpdates"`
+
+	// PreparedStatements prepares the statements of the get and update of the objects once for each resource,
+	// and executes them with the parameters, the other queries are still built and sent by every call.
+	// The prepared statements aren't used if the QueryAttribution feature gate is enabled.
+	PreparedStatements bool `yaml:"preparedStatements"`
+
+	Encryption EncryptionConfig `yaml:"encryption"`
+
+	// RedactSecrets redacts the values of the data and stringData of the secrets before they are stored, the keys are kept.
+	RedactSecrets bool `yaml:"redactSecrets"`
+
+	// Redactions redact the configured fields of the resources before they are stored.
+	Redactions []RedactionConfig `yaml:"redactions"`
+
+	// References record the references of the written objects, e.g. the secrets mounted by the pods,
+	// so the resources referencing a target can be listed by the referencesTo url query.
+	References ReferencesConfig `yaml:"references"`
+
+	// OwnerResolution tracks the owners referenced by the written objects before the owners are stored,
+	// so the permanently dangling owner references are reported by the metrics.
+	OwnerResolution OwnerResolutionConfig `yaml:"ownerResolution"`
+
+	// ClusterAliases map the old names of the renamed clusters to their new names, so the reads of the old names
+	// match the resources of the new names during the transition, e.g. until the clients use the new names.
+	ClusterAliases map[string]string `yaml:"clusterAliases"`
+
+	// ShadowAnnotations are the shadow annotations computed from the rows and injected into the objects returned by
+	// the gets, lists and watches, one of cluster-name and gvr. The keys have the shadow.clusterpedia.io/ prefix, so the
+	// annotations of the objects themselves are never overwritten. Default is [cluster-name], and an empty list injects nothing.
+	ShadowAnnotations []ShadowAnnotation `yaml:"shadowAnnotations"`
+
+	ObjectSizeLimit ObjectSizeLimitConfig `yaml:"objectSizeLimit"`
+
+	WriteBehind WriteBehindConfig `yaml:"writeBehind"`
+
+	// Fencing rejects the writes of the clusters whose leases aren't held by the writers,
+	// the writers acquire the leases of the clusters by the ClusterLeaser of the storage factory.
+	Fencing bool `yaml:"fencing"`
+
+	// StoragePolicies skip, truncate or sample the objects of the noisy resources before they are stored.
+	StoragePolicies []StoragePolicyConfig `yaml:"storagePolicies"`
+
+	Audit AuditConfig `yaml:"audit"`
+
+	History HistoryConfig `yaml:"history"`
+
+	Notifications NotificationConfig `yaml:"notifications"`
+
+	EventStream EventStreamConfig `yaml:"eventStream"`
+
+	SplitObjects []SplitObjectConfig `yaml:"splitObjects"`
+
+	// LegacyVersions are the previous storage versions of the resources, whose rows are read by the gets
+	// until they are re-encoded by the Reencode or replaced by the sync.
+	LegacyVersions []LegacyVersionConfig `yaml:"legacyVersions"`
+	Reencode       ReencodeConfig        `yaml:"reencode"`
+
+	// LabelSelectorMode is how the label selectors of the lists are applied, one of json, table and memory.
+	// The json mode queries the labels of the objects by the json functions. The table mode maintains the labels
+	// in the resource_labels table and queries the equality of the labels by it, the labels of the existing resources
+	// are backfilled by rebuilding the secondary data. The memory mode filters the listed objects, so the pages
+	// may be shorter than the limit. Default is json.
+	LabelSelectorMode LabelSelectorMode `yaml:"labelSelectorMode"`
+
+	// ResourceVersionMaxLength is the max length of the stored resource versions, the longer ones are stored as their hashes.
+	// It should be no more than the width of the resource_version column, which is 255 after the migration 4,
+	// e.g. set it to 30 while the migration 4 is pending in the safe mode. Default is 255.
+	ResourceVersionMaxLength int `yaml:"resourceVersionMaxLength"`
+
+	// AutoMigrate is the mode to migrate the schema at startup, one of off, safe and full, Default is full.
+	AutoMigrate AutoMigrateMode `yaml:"autoMigrate"`
 
 	MySQL    *MySQLConfig    `yaml:"mysql"`
 	Postgres *PostgresConfig `yaml:"postgres"`
@@ -49,6 +158,36 @@ type Config struct {
 	Params map[string]string `yaml:"params"`
 
 	Log *LogConfig `yaml:"log"`
+
+	Metrics *MetricsConfig `yaml:"metrics"`
+
+	// Middlewares are the names of the middlewares wrapping the resource storages in order, the first one is the outermost,
+	// e.g. the built-in metrics and tracing, or the out-of-tree ones registered by the RegisterResourceStorageMiddleware.
+	// It defaults to the tracing if unset, the spans of the operations aren't started if it is set to an empty list.
+	Middlewares []string `yaml:"middlewares"`
+
+	// Databases are the additional databases keyed by the name, the resources are routed to them by the Routes.
+	// The log, metrics, attribution, get cache, not found cache, get singleflight, watch hub, connection budget, index hints, middlewares and routing configs of the database are ignored, they are shared with the top level config.
+	Databases map[string]*Config `yaml:"databases"`
+	Routes    []RouteConfig      `yaml:"routes"`
+}
+
+// RouteConfig routes the resources matched by the group and resource patterns to the database,
+// the patterns are in the syntax of path.Match. The empty group matches the core group,
+// and the empty resource matches all resources of the group.
+type RouteConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+	Database string `yaml:"database"`
+}
+
+type MetricsConfig struct {
+	// RefreshInterval is the interval to refresh the metrics of the stored resources,
+	// the metrics are disabled if it is unset.
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+
+	// Registerer is the registerer of the metrics, the legacyregistry is used if it is unset.
+	Registerer prometheus.Registerer `yaml:"-"`
 }
 
 type LogConfig struct {
@@ -94,6 +233,120 @@ type ConnPoolConfig struct {
 	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
 }
 
+// TimeoutConfig is the timeouts of the operations of the resource storage,
+// the timeout is applied only if the context of the caller has no deadline.
+type TimeoutConfig struct {
+	// Default is the timeout of the operations without the specific timeout, Default is 1m.
+	Default time.Duration `yaml:"default"`
+
+	Create time.Duration `yaml:"create"`
+	Update time.Duration `yaml:"update"`
+	Delete time.Duration `yaml:"delete"`
+	Get    time.Duration `yaml:"get"`
+	List   time.Duration `yaml:"list"`
+}
+
+func (cfg TimeoutConfig) timeout(operation time.Duration) time.Duration {
+	if operation > 0 {
+		return operation
+	}
+	if cfg.Default > 0 {
+		return cfg.Default
+	}
+	return defaultOperationTimeout
+}
+
+// QueryLimitConfig limits the list queries, the limits of the result size are disabled if they are unset.
+type QueryLimitConfig struct {
+	// MaxPageSize is the maximum limit of the list request, the request with a larger limit is rejected.
+	MaxPageSize int64 `yaml:"maxPageSize"`
+
+	// MaxResultSize is the maximum number of the rows materialized by the list request without the limit,
+	// the request is limited to it, and the continue is returned if there are more rows.
+	MaxResultSize int64 `yaml:"maxResultSize"`
+
+	// MaxListItems is the maximum number of the items of each list in the list options, e.g. the clusters,
+	// namespaces and names, storage.DefaultMaxListOptionsItems is used if it is unset.
+	MaxListItems int `yaml:"maxListItems"`
+
+	// RejectUnfilteredQuery rejects the list queries which are filtered by neither the clusters nor the resource types,
+	// e.g. listing the `any` collection resource of all groups across all clusters.
+	RejectUnfilteredQuery bool `yaml:"rejectUnfilteredQuery"`
+
+	// MaxPostFilterRows is the maximum number of the rows listed by the list request whose filters are partly applied in memory,
+	// e.g. the label selectors of the memory mode, the request which may list more rows is rejected. It's unlimited if it is unset.
+	MaxPostFilterRows int64 `yaml:"maxPostFilterRows"`
+}
+
+// ConnectionBudgetConfig limits the concurrent reads and writes of the resource storages by the separate budgets of each database,
+// so that a burst of the expensive list requests can't take all the connections of the pool from the writes of the synchros.
+// The budgets are disabled if they are unset, and the sum of them should be no more than the max open connections.
+type ConnectionBudgetConfig struct {
+	// Reads is the max number of the concurrent reads of the get and list requests.
+	Reads int64 `yaml:"reads"`
+
+	// ReadWaitTimeout is the max time the reads wait for the exhausted budget before they are rejected with 429,
+	// the reads are rejected immediately if it is unset.
+	ReadWaitTimeout time.Duration `yaml:"readWaitTimeout"`
+
+	// Writes is the max number of the concurrent writes of the synchros, the writes wait for the budget until their timeouts.
+	Writes int64 `yaml:"writes"`
+}
+
+// IndexHintConfig hints the indexes of the list queries of the shape by `USE INDEX`,
+// the hinted indexes are validated against the indexes of the resources table at startup.
+type IndexHintConfig struct {
+	// Shape is the shape of the list query detected by its filters,
+	// one of list-by-owner, list-by-namespace-name, list-by-namespace, list-by-name and list-by-cluster.
+	Shape string `yaml:"shape"`
+
+	Indexes []string `yaml:"indexes"`
+}
+
+func (cfg *Config) autoMigrateMode() AutoMigrateMode {
+	if cfg.AutoMigrate == "" {
+		return AutoMigrateFull
+	}
+	return cfg.AutoMigrate
+}
+
+func (cfg *Config) resourceVersionMaxLength() (int, error) {
+	switch {
+	case cfg.ResourceVersionMaxLength == 0:
+		return resourceVersionColumnSize, nil
+	case cfg.ResourceVersionMaxLength < minResourceVersionMaxLength || cfg.ResourceVersionMaxLength > resourceVersionColumnSize:
+		return 0, fmt.Errorf("resourceVersionMaxLength must be in [%d, %d], got %d",
+			minResourceVersionMaxLength, resourceVersionColumnSize, cfg.ResourceVersionMaxLength)
+	}
+	return cfg.ResourceVersionMaxLength, nil
+}
+
+func (cfg *Config) labelSelectorMode() (LabelSelectorMode, error) {
+	switch cfg.LabelSelectorMode {
+	case "":
+		return LabelSelectorJSON, nil
+	case LabelSelectorJSON, LabelSelectorTable, LabelSelectorMemory:
+		return cfg.LabelSelectorMode, nil
+	}
+	return "", fmt.Errorf("labelSelectorMode must be one of [json, table, memory], got %q", cfg.LabelSelectorMode)
+}
+
+func (cfg *Config) decodeWorkers() (int, error) {
+	if cfg.DecodeWorkers < 0 {
+		return 0, fmt.Errorf("decodeWorkers must not be negative, got %d", cfg.DecodeWorkers)
+	}
+	return cfg.DecodeWorkers, nil
+}
+
+func (cfg *Config) connectionBudget() (ConnectionBudgetConfig, error) {
+	budget := cfg.ConnectionBudget
+	if budget.Reads < 0 || budget.Writes < 0 || budget.ReadWaitTimeout < 0 {
+		return ConnectionBudgetConfig{}, fmt.Errorf("connectionBudget must not be negative, got reads %d, readWaitTimeout %s and writes %d",
+			budget.Reads, budget.ReadWaitTimeout, budget.Writes)
+	}
+	return budget, nil
+}
+
 func (cfg *Config) LoggerConfig() (logger.Config, error) {
 	if cfg.Log == nil {
 		return logger.Config{}, nil
@@ -293,7 +546,7 @@ func (cfg *Config) genSQLiteDSN() (string, error) {
 func (cfg *Config) addMysqlErrorNumbers() {
 	if cfg.MySQL != nil {
 		for _, errCode := range cfg.MySQL.RecoverableErrNumbers {
-			recoverableMysqlErrNumbers.Store(errCode, struct{}{})
+			recoverableMysqlErrNumbers.Store(uint16(errCode), struct{}{})
 		}
 	}
 }
@@ -0,0 +1,61 @@
+package internalstorage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+
+	"gorm.io/gorm"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/klog/v2"
+)
+
+const (
+	updateTypeFull  = "full"
+	updateTypeLight = "light"
+)
+
+func init() {
+	registerMigration(migration{
+		version:  7,
+		name:     "add the content_hash column to the resources table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return addColumnIfNotExists(db, &Resource{}, "ContentHash")
+		},
+	})
+}
+
+// checkContentHashColumn records the database without the content_hash column, whose updates are always full.
+func (s *StorageFactory) checkContentHashColumn(name string, db *gorm.DB) {
+	if db.Migrator().HasColumn(&Resource{}, "ContentHash") {
+		return
+	}
+
+	klog.InfoS("The content_hash column doesn't exist, the light updates are disabled until the migration 7 is applied", "database", name)
+	if s.contentHashMissing == nil {
+		s.contentHashMissing = make(map[*gorm.DB]bool)
+	}
+	s.contentHashMissing[db] = true
+}
+
+// objectContentHash returns the sha256 of the canonical json of the encoded object without the resource version
+// and the managed fields, which are changed by the updates without the changes of the content, e.g. the no-op updates.
+func objectContentHash(encoded []byte) (sql.NullString, error) {
+	var object map[string]interface{}
+	if err := utiljson.Unmarshal(encoded, &object); err != nil {
+		return sql.NullString{}, err
+	}
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "resourceVersion")
+		delete(metadata, "managedFields")
+	}
+
+	canonical, err := json.Marshal(object)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	hash := sha256.Sum256(canonical)
+	return sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true}, nil
+}
@@ -0,0 +1,99 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestObjectContentHash(t *testing.T) {
+	hash, err := objectContentHash([]byte(`{"kind":"Deployment","metadata":{"name":"a","resourceVersion":"1","managedFields":[{"manager":"a"}]}}`))
+	require.NoError(t, err)
+	assert.True(t, hash.Valid)
+	assert.Len(t, hash.String, 64)
+
+	// the resource version and the managed fields are excluded, and the keys are sorted
+	unchanged, err := objectContentHash([]byte(`{"metadata":{"resourceVersion":"2","name":"a"},"kind":"Deployment"}`))
+	require.NoError(t, err)
+	assert.Equal(t, hash, unchanged)
+
+	changed, err := objectContentHash([]byte(`{"kind":"Deployment","metadata":{"name":"a","labels":{"app":"a"}}}`))
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, changed)
+
+	_, err = objectContentHash([]byte(`{"kind":`))
+	assert.Error(t, err)
+}
+
+func TestResourceStorage_LightUpdates(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.lightUpdates = true
+
+	counter := func(updateType string) float64 {
+		value, err := testutil.GetCounterMetricValue(updatesTotal.WithLabelValues("apps", "deployments", updateType))
+		require.NoError(t, err)
+		return value
+	}
+	full, light := counter(updateTypeFull), counter(updateTypeLight)
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+
+	var created Resource
+	require.NoError(t, db.First(&created).Error)
+	require.True(t, created.ContentHash.Valid)
+
+	// the no-op update only writes the resource version
+	deploy.ResourceVersion = "2"
+	deploy.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "kubectl"}}
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	assert.Equal(t, float64(1), counter(updateTypeLight)-light)
+	assert.Equal(t, float64(0), counter(updateTypeFull)-full)
+
+	var resource Resource
+	require.NoError(t, db.First(&resource).Error)
+	assert.Equal(t, "2", resource.ResourceVersion)
+	assert.Equal(t, created.Object, resource.Object)
+	assert.Equal(t, created.ContentHash, resource.ContentHash)
+	assert.False(t, resource.SyncedAt.Before(created.SyncedAt))
+
+	// the changed object is rewritten
+	deploy.ResourceVersion = "3"
+	deploy.Labels = map[string]string{"app": "a"}
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	assert.Equal(t, float64(1), counter(updateTypeFull)-full)
+
+	require.NoError(t, db.First(&resource).Error)
+	assert.Equal(t, "3", resource.ResourceVersion)
+	assert.Contains(t, string(resource.Object), `"resourceVersion":"3"`)
+	assert.NotEqual(t, created.ContentHash, resource.ContentHash)
+
+	got := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", got))
+	assert.Equal(t, deploy.Labels, got.Labels)
+
+	// the rows without the content hash are always fully updated
+	require.NoError(t, db.Model(&Resource{}).Where("id = ?", resource.ID).Update("content_hash", nil).Error)
+	deploy.ResourceVersion = "4"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	assert.Equal(t, float64(2), counter(updateTypeFull)-full)
+	assert.Equal(t, float64(1), counter(updateTypeLight)-light)
+}
@@ -0,0 +1,149 @@
+package internalstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// continueSchemaVersion is the version of the list queries paginated by the continues,
+// it should be bumped if the rows or the order of the list queries are changed.
+const continueSchemaVersion = 1
+
+// listContinue is the opaque continue of the lists, the offset of the next page is only valid for the list
+// with the same ordering and filters served by the same config, e.g. the other apiserver replicas may be
+// configured with another label selector mode or storage version, so the continue issued by one of them
+// is rejected by the others instead of skipping or duplicating the items of the pagination.
+type listContinue struct {
+	Offset  int64  `json:"offset"`
+	OrderBy string `json:"orderBy,omitempty"`
+	Filters string `json:"filters"`
+	Config  string `json:"config"`
+}
+
+// newListContinue returns the continue of the list options, the offset of it is unset. The filters are the hash
+// of the canonical list options, except the pagination and the ordering, and the config is the fingerprint of the storage.
+func newListContinue(ctx context.Context, gvr schema.GroupVersionResource, opts *internal.ListOptions, config string) *listContinue {
+	orderBy := make([]string, 0, len(opts.OrderBy))
+	for _, order := range opts.OrderBy {
+		if order.Desc {
+			orderBy = append(orderBy, order.Field+" desc")
+		} else {
+			orderBy = append(orderBy, order.Field)
+		}
+	}
+
+	filters := *opts
+	filters.Limit, filters.Continue, filters.OrderBy = 0, "", nil
+	filters.WithContinue, filters.WithRemainingCount = nil, nil
+	// the PartialObjectMetadata lists are served from the metadata column, they are the same lists as the objects
+	filters.OnlyMetadata = false
+	return &listContinue{
+		OrderBy: strings.Join(orderBy, ","),
+		Filters: shortHash(canonicalListKey(ctx, gvr, &filters)),
+		Config:  config,
+	}
+}
+
+// resolve checks the continue of the list options, and returns the list options whose continue is the offset
+// of the next page for the query builder. The list options are copied if the continue is resolved.
+//
+// The plain offsets, e.g. set by the `search.clusterpedia.io/offset` label, are the explicit offsets of the
+// client, they are used directly without the checks of the continues issued by the servers.
+func (c *listContinue) resolve(opts *internal.ListOptions) (*internal.ListOptions, error) {
+	if opts.Continue == "" {
+		return opts, nil
+	}
+	if offset, err := strconv.ParseInt(opts.Continue, 10, 64); err == nil {
+		if offset < 0 {
+			return nil, invalidContinueError("the offset is negative")
+		}
+		return opts, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(opts.Continue)
+	if err != nil {
+		return nil, invalidContinueError("the continue is malformed")
+	}
+	token := &listContinue{}
+	if err := json.Unmarshal(decoded, token); err != nil || token.Offset < 0 {
+		return nil, invalidContinueError("the continue is malformed")
+	}
+	switch {
+	case token.OrderBy != c.OrderBy:
+		return nil, invalidContinueError(fmt.Sprintf("the continue was issued for the list ordered by %q, not %q", token.OrderBy, c.OrderBy))
+	case token.Filters != c.Filters:
+		return nil, invalidContinueError("the continue was issued for the list with other filters")
+	case token.Config != c.Config:
+		return nil, invalidContinueError("the continue was issued by the server with another config")
+	}
+
+	opts = opts.DeepCopy()
+	opts.Continue = strconv.FormatInt(token.Offset, 10)
+	return opts, nil
+}
+
+// encode returns the continue of the next page starting from the offset.
+func (c *listContinue) encode(offset int64) string {
+	token := *c
+	token.Offset = offset
+	encoded, err := json.Marshal(token)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func invalidContinueError(reason string) error {
+	return apierrors.NewBadRequest(reason + ", restart the list without the continue")
+}
+
+// continueConfig returns the fingerprint of the config deciding the rows and the order of the lists.
+func (s *ResourceStorage) continueConfig() string {
+	return continueConfigOf(struct {
+		Schema            int
+		StorageVersion    string
+		LabelSelectorMode LabelSelectorMode
+		ClusterAliases    clusterAliases
+	}{continueSchemaVersion, s.storageVersion.String(), s.labelSelectorMode, s.clusterAliases})
+}
+
+// listContinue returns the continue of the list options of the resource storage.
+func (s *ResourceStorage) listContinue(ctx context.Context, opts *internal.ListOptions) *listContinue {
+	return newListContinue(ctx, s.storageGVR(), opts, s.continueConfig())
+}
+
+func (s *CollectionResourceStorage) listContinue(ctx context.Context, opts *internal.ListOptions) *listContinue {
+	gvr := schema.GroupVersionResource{Group: internal.GroupName, Resource: "collectionresources/" + s.collectionResource.Name}
+	return newListContinue(ctx, gvr, opts, continueConfigOf(struct {
+		Schema         int
+		ClusterAliases clusterAliases
+	}{continueSchemaVersion, s.clusterAliases}))
+}
+
+func continueConfigOf(config interface{}) string {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return shortHash(hex.EncodeToString(hash[:]))
+}
+
+// shortHash truncates the hex encoded hash to keep the continues short.
+func shortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
@@ -0,0 +1,111 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/labels"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestListContinue(t *testing.T) {
+	db, rs := newLabelsTestStorage(t, LabelSelectorJSON)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newLabeledDeployment(fmt.Sprintf("deploy-%d", i), map[string]string{"app": "nginx"})))
+	}
+	// the other replica sharing the database with the same config
+	replica := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	replica.codec, replica.labelSelectorMode = rs.codec, rs.labelSelectorMode
+
+	withContinue := true
+	listOptions := func(continueToken string, orderBy ...internal.OrderBy) *internal.ListOptions {
+		opts := &internal.ListOptions{
+			ListOptions:  metainternal.ListOptions{Limit: 2, Continue: continueToken},
+			OrderBy:      orderBy,
+			WithContinue: &withContinue,
+		}
+		opts.LabelSelector = labels.SelectorFromSet(labels.Set{"app": "nginx"})
+		return opts
+	}
+	byName := internal.OrderBy{Field: "name"}
+
+	// the pages are continued by the replicas in turn
+	var names []string
+	var continueToken string
+	for page, storage := range []*ResourceStorage{rs, replica, rs} {
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, storage.List(context.Background(), list, listOptions(continueToken, byName)), "page %d", page)
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		continueToken = list.Continue
+	}
+	assert.Equal(t, []string{"deploy-0", "deploy-1", "deploy-2", "deploy-3", "deploy-4"}, names)
+	assert.Empty(t, continueToken)
+
+	// the plain offset of the offset label is used directly
+	offsetList := &appsv1.DeploymentList{}
+	require.NoError(t, replica.List(context.Background(), offsetList, listOptions("3", byName)))
+	require.Len(t, offsetList.Items, 2)
+	assert.Equal(t, "deploy-3", offsetList.Items[0].Name)
+	assert.Equal(t, "deploy-4", offsetList.Items[1].Name)
+
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, listOptions("", byName)))
+	require.NotEmpty(t, list.Continue)
+
+	for name, test := range map[string]struct {
+		storage func() *ResourceStorage
+		opts    *internal.ListOptions
+	}{
+		"label selector mode changed": {
+			storage: func() *ResourceStorage {
+				changed := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+				changed.codec, changed.labelSelectorMode = rs.codec, LabelSelectorMemory
+				return changed
+			},
+			opts: listOptions(list.Continue, byName),
+		},
+		"cluster aliases changed": {
+			storage: func() *ResourceStorage {
+				changed := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+				changed.codec, changed.labelSelectorMode = rs.codec, rs.labelSelectorMode
+				changed.clusterAliases = clusterAliases{"old-cluster": "cluster-1"}
+				return changed
+			},
+			opts: listOptions(list.Continue, byName),
+		},
+		"ordering changed": {
+			storage: func() *ResourceStorage { return replica },
+			opts:    listOptions(list.Continue, internal.OrderBy{Field: "name", Desc: true}),
+		},
+		"filters changed": {
+			storage: func() *ResourceStorage { return replica },
+			opts: func() *internal.ListOptions {
+				opts := listOptions(list.Continue, byName)
+				opts.Namespaces = []string{"default"}
+				return opts
+			}(),
+		},
+		"negative offset": {
+			storage: func() *ResourceStorage { return replica },
+			opts:    listOptions("-1", byName),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.storage().List(context.Background(), &appsv1.DeploymentList{}, test.opts)
+			assert.True(t, apierrors.IsBadRequest(err), err)
+			assert.ErrorContains(t, err, "restart the list without the continue")
+
+			err = test.storage().ListStream(context.Background(), nil, test.opts, &recordingVisitor{})
+			assert.True(t, apierrors.IsBadRequest(err), err)
+		})
+	}
+}
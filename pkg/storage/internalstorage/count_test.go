@@ -0,0 +1,39 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestCountClusterResources(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	factory := &StorageFactory{db: db}
+
+	create := func(cluster, group, resource string, count int) {
+		for i := 0; i < count; i++ {
+			require.NoError(t, db.Create(&Resource{Cluster: cluster, Group: group, Version: "v1", Resource: resource, Kind: resource,
+				Namespace: "default", Name: fmt.Sprintf("%s-%d", resource, i), ResourceVersion: "1", Object: []byte(`{}`)}).Error)
+		}
+	}
+	create("cluster-1", "apps", "deployments", 3)
+	create("cluster-1", "", "pods", 5)
+	create("cluster-2", "", "pods", 2)
+
+	counts, err := factory.CountClusterResources(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[schema.GroupVersionResource]int64{
+		{Group: "apps", Version: "v1", Resource: "deployments"}: 3,
+		{Version: "v1", Resource: "pods"}:                       5,
+	}, counts)
+
+	counts, err = factory.CountClusterResources(context.Background(), "cluster-3")
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
@@ -0,0 +1,94 @@
+package internalstorage
+
+import (
+	"context"
+	goruntime "runtime"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+)
+
+// convertObjects converts the listed objects by the decode workers, the converted objects are in the order of
+// the listed objects. If skipUndecodable is set, the undecodable objects are logged and left nil,
+// otherwise the conversion is stopped by the first error.
+//
+// The objects are converted sequentially if there is only one worker, the same as the List before the workers.
+func (s *ResourceStorage) convertObjects(ctx context.Context, objects []Object, newObject func() runtime.Object, skipUndecodable bool) ([]runtime.Object, int, error) {
+	converted := make([]runtime.Object, len(objects))
+
+	workers := s.decodeWorkers
+	if workers <= 0 {
+		workers = goruntime.GOMAXPROCS(0)
+	}
+	if workers > len(objects) {
+		workers = len(objects)
+	}
+
+	if workers <= 1 {
+		var skipped int
+		for i, object := range objects {
+			obj, err := s.convertObject(object, newObject())
+			if err != nil {
+				if skipUndecodable {
+					klog.ErrorS(err, "Skip the undecodable row")
+					skipped++
+					continue
+				}
+				return nil, skipped, err
+			}
+			converted[i] = obj
+		}
+		return converted, skipped, nil
+	}
+
+	decodeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		next     atomic.Int64
+		skipped  atomic.Int64
+		errOnce  sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for decodeCtx.Err() == nil {
+				i := int(next.Add(1) - 1)
+				if i >= len(objects) {
+					return
+				}
+
+				obj, err := s.convertObject(objects[i], newObject())
+				if err != nil {
+					if skipUndecodable {
+						klog.ErrorS(err, "Skip the undecodable row")
+						skipped.Add(1)
+						continue
+					}
+
+					// the other workers are stopped by the first error
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				converted[i] = obj
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, int(skipped.Load()), firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, int(skipped.Load()), err
+	}
+	return converted, int(skipped.Load()), nil
+}
@@ -0,0 +1,160 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newTestPodObjects(tb testing.TB, count int) []Object {
+	objects := make([]Object, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("pod-%d", i)
+		pod := &corev1.Pod{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: fmt.Sprint(i + 1),
+				Labels:      map[string]string{"app": "web", "pod-template-hash": "5d8f9c7b6d", "tier": "frontend"},
+				Annotations: map[string]string{"kubectl.kubernetes.io/restartedAt": "2024-01-01T00:00:00Z"},
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f9c7b6d", UID: "web-5d8f9c7b6d"},
+				},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: fmt.Sprintf("node-%d", i%100),
+				Containers: []corev1.Container{{
+					Name:  "web",
+					Image: "nginx:1.25",
+					Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 80, Protocol: corev1.ProtocolTCP}},
+					Env:   []corev1.EnvVar{{Name: "MODE", Value: "production"}, {Name: "LOG_LEVEL", Value: "info"}},
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("128Mi")},
+						Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("512Mi")},
+					},
+					VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/nginx/conf.d"}},
+				}},
+				Volumes: []corev1.Volume{{Name: "config", VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}},
+				}}},
+			},
+			Status: corev1.PodStatus{
+				Phase:  corev1.PodRunning,
+				PodIP:  fmt.Sprintf("10.0.%d.%d", i/250%250, i%250),
+				HostIP: fmt.Sprintf("192.168.0.%d", i%100),
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+					{Type: corev1.ContainersReady, Status: corev1.ConditionTrue},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "web", Ready: true, Image: "nginx:1.25", ImageID: "docker.io/library/nginx@sha256:0123456789abcdef"}},
+			},
+		}
+
+		data, err := json.Marshal(pod)
+		require.NoError(tb, err)
+		objects = append(objects, ResourceBytes{
+			ResourceIdentity: ResourceIdentity{Cluster: "cluster-1", Namespace: "default", Name: name},
+			Object:           data,
+		})
+	}
+	return objects
+}
+
+func newTestPodResourceStorage(tb testing.TB, decodeWorkers int) *ResourceStorage {
+	rs := newTestResourceStorage(nil, corev1.SchemeGroupVersion.WithResource("pods"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "pods"}, true)
+	require.NoError(tb, err)
+	rs.codec = config.Codec
+	rs.decodeWorkers = decodeWorkers
+	return rs
+}
+
+func TestResourceStorage_convertObjects(t *testing.T) {
+	objects := newTestPodObjects(t, 100)
+	newPod := func() runtime.Object { return &corev1.Pod{} }
+
+	sequential, skipped, err := newTestPodResourceStorage(t, 1).convertObjects(context.Background(), objects, newPod, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, skipped)
+
+	// the order of the objects is preserved
+	parallel, skipped, err := newTestPodResourceStorage(t, 8).convertObjects(context.Background(), objects, newPod, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, skipped)
+	assert.Equal(t, sequential, parallel)
+	for i, obj := range parallel {
+		assert.Equal(t, fmt.Sprintf("pod-%d", i), obj.(*corev1.Pod).Name)
+	}
+
+	converted, _, err := newTestPodResourceStorage(t, 8).convertObjects(context.Background(), objects,
+		func() runtime.Object { return &unstructured.Unstructured{} }, false)
+	require.NoError(t, err)
+	assert.Equal(t, "pod-42", converted[42].(*unstructured.Unstructured).GetName())
+
+	// the conversion is stopped by the undecodable object
+	corrupted := append([]Object(nil), objects...)
+	corrupted[37] = ResourceBytes{
+		ResourceIdentity: ResourceIdentity{Cluster: "cluster-1", Namespace: "default", Name: "pod-37"},
+		Object:           []byte(`{"apiVersion":"v1","kind":"Po`),
+	}
+	for _, workers := range []int{1, 8} {
+		rs := newTestPodResourceStorage(t, workers)
+		_, _, err = rs.convertObjects(context.Background(), corrupted, newPod, false)
+		var decodeErr *ObjectDecodeError
+		require.ErrorAs(t, err, &decodeErr, "workers %d", workers)
+		assert.Equal(t, "pod-37", decodeErr.Row.Name)
+
+		converted, skipped, err := rs.convertObjects(context.Background(), corrupted, newPod, true)
+		require.NoError(t, err)
+		assert.Equal(t, 1, skipped)
+		assert.Nil(t, converted[37])
+		assert.Equal(t, "pod-38", converted[38].(*corev1.Pod).Name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = newTestPodResourceStorage(t, 8).convertObjects(ctx, objects, newPod, false)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// BenchmarkResourceStorage_convertObjects compares the sequential decoding of the listed pods with the parallel one.
+func BenchmarkResourceStorage_convertObjects(b *testing.B) {
+	objects := newTestPodObjects(b, 10000)
+
+	for _, into := range []struct {
+		name      string
+		newObject func() runtime.Object
+	}{
+		{"typed", func() runtime.Object { return &corev1.Pod{} }},
+		{"unstructured", func() runtime.Object { return &unstructured.Unstructured{} }},
+	} {
+		for _, workers := range []struct {
+			name    string
+			workers int
+		}{
+			{"sequential", 1},
+			{"parallel", 0},
+		} {
+			rs := newTestPodResourceStorage(b, workers.workers)
+			b.Run(into.name+"/"+workers.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, _, err := rs.convertObjects(context.Background(), objects, into.newObject, false); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
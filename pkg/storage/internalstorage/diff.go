@@ -0,0 +1,449 @@
+package internalstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	defaultDiffLimit = 500
+	maxDiffLimit     = 5000
+
+	// diffCompareBatchSize is the number of the resources existing in both clusters compared by each batch.
+	diffCompareBatchSize = 500
+)
+
+var _ storage.ClusterDiffer = &StorageFactory{}
+
+// diffPhases are the types of the differences in the order of the report, the resources of the clusters
+// are compared only if the DiffOptions.Compare is set.
+var diffPhases = []storage.DiffType{storage.DiffMissingInTarget, storage.DiffMissingInSource, storage.DiffDifferent}
+
+// diffCursor is the position of the diff, the items of the phase in the database, whose keys are no more than the key,
+// and the items of the previous phases and databases have been reported.
+type diffCursor struct {
+	Phase    storage.DiffType `json:"phase"`
+	Database string           `json:"database"`
+
+	Group     string `json:"group"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+func parseDiffCursor(encoded string) (*diffCursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid continue %q: %w", encoded, err)
+	}
+	cursor := &diffCursor{}
+	if err := json.Unmarshal(decoded, cursor); err != nil {
+		return nil, fmt.Errorf("invalid continue %q: %w", encoded, err)
+	}
+	if diffPhaseIndex(cursor.Phase) < 0 {
+		return nil, fmt.Errorf("invalid continue %q: unknown phase %q", encoded, cursor.Phase)
+	}
+	return cursor, nil
+}
+
+func (c *diffCursor) encode() (string, error) {
+	encoded, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// diffRow is the row of the diff queries, the keys are the group, resource, the namespace in the target cluster and name,
+// the objects are only selected by the query of the resources existing in both clusters.
+type diffRow struct {
+	KeyGroup        string
+	KeyResource     string
+	KeyNamespace    string
+	KeyName         string
+	SourceNamespace string
+
+	SourceObject []byte
+	SourceSpec   []byte
+	SourceStatus []byte
+	TargetObject []byte
+	TargetSpec   []byte
+	TargetStatus []byte
+}
+
+func (r diffRow) item(phase storage.DiffType) storage.DiffItem {
+	item := storage.DiffItem{Type: phase, Group: r.KeyGroup, Resource: r.KeyResource, Namespace: r.KeyNamespace, Name: r.KeyName}
+	if phase != storage.DiffMissingInSource && r.SourceNamespace != r.KeyNamespace {
+		item.SourceNamespace = r.SourceNamespace
+	}
+	return item
+}
+
+// DiffClusters compares the resources of the clusters by the phases, the missing resources of either cluster are found
+// by the anti joins of the resources, and the objects of the resources existing in both clusters are compared by the hashes
+// of the normalized objects. The diff of the resources routed to multiple databases is reported by the databases in order.
+func (s *StorageFactory) DiffClusters(ctx context.Context, opts storage.DiffOptions) (*storage.DiffReport, error) {
+	if opts.Source == "" || opts.Target == "" {
+		return nil, apierrors.NewBadRequest("both the source and target clusters are required")
+	}
+	opts.Source, opts.Target = s.clusterAliases.resolve(opts.Source), s.clusterAliases.resolve(opts.Target)
+	if opts.Source == opts.Target {
+		return nil, apierrors.NewBadRequest("the source and target clusters are the same")
+	}
+	for _, cluster := range []string{opts.Source, opts.Target} {
+		if err := checkClusterAllowed(ctx, cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultDiffLimit
+	}
+	if limit > maxDiffLimit {
+		limit = maxDiffLimit
+	}
+
+	cursor, err := parseDiffCursor(opts.Continue)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	databases := s.namedDatabases()
+	names := s.diffDatabaseNames(opts.Resources)
+	report := &storage.DiffReport{Source: opts.Source, Target: opts.Target, Items: []storage.DiffItem{}}
+	for _, phase := range diffPhases {
+		if phase == storage.DiffDifferent && !opts.Compare {
+			continue
+		}
+
+		for _, name := range names {
+			var after *diffCursor
+			if cursor != nil {
+				if c := compareDiffPosition(phase, name, cursor); c < 0 {
+					continue
+				} else if c == 0 {
+					after = cursor
+				}
+			}
+
+			db := databases[name]
+			query := &diffQuery{
+				db:    db.WithContext(ctx),
+				opts:  opts,
+				split: !s.splitColumnsMissing[db],
+			}
+			remaining := limit - int64(len(report.Items))
+			rows, err := s.diffDatabase(ctx, s.budgets[db], query, phase, after, remaining)
+			if err != nil {
+				return nil, InterpretDBError(name, err)
+			}
+			for _, row := range rows {
+				report.Items = append(report.Items, row.item(phase))
+			}
+
+			if int64(len(report.Items)) == limit {
+				last := rows[len(rows)-1]
+				next := &diffCursor{
+					Phase: phase, Database: name,
+					Group: last.KeyGroup, Resource: last.KeyResource, Namespace: last.KeyNamespace, Name: last.KeyName,
+				}
+				if report.Continue, err = next.encode(); err != nil {
+					return nil, err
+				}
+				return report, nil
+			}
+		}
+	}
+	return report, nil
+}
+
+// compareDiffPosition compares the phase of the database with the cursor.
+func compareDiffPosition(phase storage.DiffType, database string, cursor *diffCursor) int {
+	if phase != cursor.Phase {
+		if diffPhaseIndex(phase) < diffPhaseIndex(cursor.Phase) {
+			return -1
+		}
+		return 1
+	}
+	return compareStrings(database, cursor.Database)
+}
+
+func diffPhaseIndex(phase storage.DiffType) int {
+	for i, p := range diffPhases {
+		if p == phase {
+			return i
+		}
+	}
+	return -1
+}
+
+// diffDatabaseNames returns the sorted names of the databases which store the compared resources.
+func (s *StorageFactory) diffDatabaseNames(resources []schema.GroupVersionResource) []string {
+	if s.router == nil {
+		return []string{DefaultDatabaseName}
+	}
+
+	names := sets.New[string]()
+	if len(resources) == 0 {
+		names.Insert(s.router.names()...)
+	}
+	for _, gvr := range resources {
+		names.Insert(s.router.databaseName(gvr.GroupResource()))
+	}
+	return sets.List(names)
+}
+
+// diffDatabase returns at most limit rows of the phase in the database after the cursor,
+// the resources existing in both clusters are scanned in batches until enough different ones are found.
+func (s *StorageFactory) diffDatabase(ctx context.Context, budget *connectionBudget, query *diffQuery, phase storage.DiffType, after *diffCursor, limit int64) ([]diffRow, error) {
+	release, err := budget.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if phase != storage.DiffDifferent {
+		var rows []diffRow
+		if err := query.missing(phase, after, limit).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	var different []diffRow
+	for {
+		var rows []diffRow
+		if err := query.existing(after, diffCompareBatchSize).Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		for _, row := range rows {
+			equal, err := s.equalDiffObjects(row)
+			if err != nil {
+				return nil, fmt.Errorf("compare %s/%s %s/%s: %w", row.KeyGroup, row.KeyResource, row.KeyNamespace, row.KeyName, err)
+			}
+			if !equal {
+				different = append(different, row)
+				if int64(len(different)) == limit {
+					return different, nil
+				}
+			}
+		}
+		if len(rows) < diffCompareBatchSize {
+			return different, nil
+		}
+
+		last := rows[len(rows)-1]
+		after = &diffCursor{Group: last.KeyGroup, Resource: last.KeyResource, Namespace: last.KeyNamespace, Name: last.KeyName}
+	}
+}
+
+func (s *StorageFactory) equalDiffObjects(row diffRow) (bool, error) {
+	source, err := s.normalizedObjectHash(row.SourceObject, row.SourceSpec, row.SourceStatus)
+	if err != nil {
+		return false, fmt.Errorf("the object of the source cluster: %w", err)
+	}
+	target, err := s.normalizedObjectHash(row.TargetObject, row.TargetSpec, row.TargetStatus)
+	if err != nil {
+		return false, fmt.Errorf("the object of the target cluster: %w", err)
+	}
+	return source == target, nil
+}
+
+// diffIgnoredMetadataFields are the cluster-specific fields of the metadata, which are ignored by the comparison,
+// the namespace is ignored since it may be mapped to another namespace.
+var diffIgnoredMetadataFields = []string{
+	"namespace", "uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink",
+}
+
+// normalizedObjectHash returns the sha256 of the canonical json of the stored object without the cluster-specific fields,
+// the status and the uids of the owner references are removed as well.
+func (s *StorageFactory) normalizedObjectHash(stored, spec, status []byte) (string, error) {
+	decrypted, err := s.encryption.decrypt(stored)
+	if err != nil {
+		return "", err
+	}
+
+	var object map[string]interface{}
+	if err := utiljson.Unmarshal(assembleObject(decrypted, spec, status), &object); err != nil {
+		return "", err
+	}
+	if object == nil {
+		return "", errors.New("the object is null")
+	}
+	delete(object, "status")
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		for _, field := range diffIgnoredMetadataFields {
+			delete(metadata, field)
+		}
+		if owners, ok := metadata["ownerReferences"].([]interface{}); ok {
+			for _, owner := range owners {
+				if owner, ok := owner.(map[string]interface{}); ok {
+					delete(owner, "uid")
+				}
+			}
+		}
+	}
+
+	canonical, err := json.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(canonical)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// diffQuery builds the queries of the diff in a database, the resources of the source cluster are aliased as `s`,
+// and the ones of the target cluster are aliased as `t`. The missing resources are found by the `NOT EXISTS`
+// instead of the `EXCEPT`, which isn't supported by MySQL before 8.0.31.
+type diffQuery struct {
+	db   *gorm.DB
+	opts storage.DiffOptions
+
+	// split selects the spec and status columns of the objects.
+	split bool
+}
+
+func (q *diffQuery) column(alias, name string) string {
+	return q.db.Statement.Quote(alias + "." + name)
+}
+
+// namespace returns the namespace of the resource in the target cluster, the namespaces of the source cluster are mapped.
+func (q *diffQuery) namespace(alias string) (string, []interface{}) {
+	column := q.column(alias, "namespace")
+	if alias == "t" || len(q.opts.NamespaceMapping) == 0 {
+		return column, nil
+	}
+
+	namespaces := make([]string, 0, len(q.opts.NamespaceMapping))
+	for namespace := range q.opts.NamespaceMapping {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	var expr strings.Builder
+	vars := make([]interface{}, 0, len(namespaces)*2)
+	expr.WriteString("CASE " + column)
+	for _, namespace := range namespaces {
+		expr.WriteString(" WHEN ? THEN ?")
+		vars = append(vars, namespace, q.opts.NamespaceMapping[namespace])
+	}
+	expr.WriteString(" ELSE " + column + " END")
+	return expr.String(), vars
+}
+
+// match returns the condition matching the resources of the clusters.
+func (q *diffQuery) match() (string, []interface{}) {
+	namespace, vars := q.namespace("s")
+	return fmt.Sprintf("%s = %s AND %s = %s AND %s = %s AND %s = %s",
+		q.column("t", "group"), q.column("s", "group"),
+		q.column("t", "resource"), q.column("s", "resource"),
+		q.column("t", "namespace"), namespace,
+		q.column("t", "name"), q.column("s", "name"),
+	), vars
+}
+
+// keys selects the keys of the resources of the alias and the columns, the rows are ordered by the keys.
+func (q *diffQuery) keys(query *gorm.DB, alias string, after *diffCursor, limit int64, columns ...string) *gorm.DB {
+	namespace, vars := q.namespace(alias)
+	group, resource, name := q.column(alias, "group"), q.column(alias, "resource"), q.column(alias, "name")
+
+	selects := []string{
+		group + " AS key_group",
+		resource + " AS key_resource",
+		namespace + " AS key_namespace",
+		name + " AS key_name",
+		q.column(alias, "namespace") + " AS source_namespace",
+	}
+	query = query.Select(strings.Join(append(selects, columns...), ", "), vars...)
+	if after != nil {
+		cursorVars := append(append([]interface{}{}, vars...), after.Group, after.Resource, after.Namespace, after.Name)
+		query = query.Where(fmt.Sprintf("(%s, %s, %s, %s) > (?, ?, ?, ?)", group, resource, namespace, name), cursorVars...)
+	}
+	return query.Order("key_group, key_resource, key_namespace, key_name").Limit(int(limit))
+}
+
+// resources filters the resources of the alias by the compared resources.
+func (q *diffQuery) resources(query *gorm.DB, alias string) *gorm.DB {
+	if len(q.opts.Resources) == 0 {
+		return query
+	}
+
+	conditions := make([]string, 0, len(q.opts.Resources))
+	var vars []interface{}
+	for _, gvr := range q.opts.Resources {
+		condition := fmt.Sprintf("%s = ? AND %s = ?", q.column(alias, "group"), q.column(alias, "resource"))
+		vars = append(vars, gvr.Group, gvr.Resource)
+		if gvr.Version != "" {
+			condition += fmt.Sprintf(" AND %s = ?", q.column(alias, "version"))
+			vars = append(vars, gvr.Version)
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 1 {
+		return query.Where(conditions[0], vars...)
+	}
+	return query.Where("("+strings.Join(conditions, ") OR (")+")", vars...)
+}
+
+// missing returns the query of the resources which only exist in the source or target cluster by the phase.
+func (q *diffQuery) missing(phase storage.DiffType, after *diffCursor, limit int64) *gorm.DB {
+	alias, other := "s", "t"
+	cluster, otherCluster := q.opts.Source, q.opts.Target
+	if phase == storage.DiffMissingInSource {
+		alias, other = other, alias
+		cluster, otherCluster = otherCluster, cluster
+	}
+
+	match, vars := q.match()
+	exists := q.db.Table("resources AS "+other).Select("1").
+		Where(q.column(other, "cluster")+" = ?", otherCluster).
+		Where(match, vars...)
+
+	query := q.db.Table("resources AS "+alias).Where(q.column(alias, "cluster")+" = ?", cluster)
+	query = q.resources(query, alias).Where("NOT EXISTS (?)", exists)
+	return q.keys(query, alias, after, limit)
+}
+
+// existing returns the query of the objects of the resources which exist in both clusters.
+func (q *diffQuery) existing(after *diffCursor, limit int64) *gorm.DB {
+	var objects []string
+	for _, column := range []string{"object", "spec", "status"} {
+		if column != "object" && !q.split {
+			continue
+		}
+		objects = append(objects,
+			fmt.Sprintf("%s AS source_%s", q.column("s", column), column),
+			fmt.Sprintf("%s AS target_%s", q.column("t", column), column),
+		)
+	}
+
+	match, vars := q.match()
+	query := q.db.Table("resources AS s").
+		Joins(fmt.Sprintf("JOIN resources AS t ON %s = ? AND %s", q.column("t", "cluster"), match), append([]interface{}{q.opts.Target}, vars...)...).
+		Where(q.column("s", "cluster")+" = ?", q.opts.Source)
+	return q.keys(q.resources(query, "s"), "s", after, limit, objects...)
+}
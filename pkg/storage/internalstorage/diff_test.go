@@ -0,0 +1,125 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestDiffQueryMissing(t *testing.T) {
+	opts := storage.DiffOptions{
+		Source:           "cluster-1",
+		Target:           "cluster-2",
+		Resources:        []schema.GroupVersionResource{{Group: "apps", Resource: "deployments"}},
+		NamespaceMapping: map[string]string{"team-a": "team-b"},
+	}
+	after := &diffCursor{Group: "apps", Resource: "deployments", Namespace: "default", Name: "a"}
+	applyFn := func(db *gorm.DB, opts storage.DiffOptions) (*gorm.DB, error) {
+		query := &diffQuery{db: db.Session(&gorm.Session{NewDB: true}), opts: opts}
+		return query.missing(storage.DiffMissingInTarget, after, 10), nil
+	}
+
+	expected := expected{
+		`SELECT "s"."group" AS key_group, "s"."resource" AS key_resource, CASE "s"."namespace" WHEN 'team-a' THEN 'team-b' ELSE "s"."namespace" END AS key_namespace, "s"."name" AS key_name, "s"."namespace" AS source_namespace FROM resources AS s WHERE "s"."cluster" = 'cluster-1' AND ("s"."group" = 'apps' AND "s"."resource" = 'deployments') AND NOT EXISTS (SELECT 1 FROM resources AS t WHERE "t"."cluster" = 'cluster-2' AND ("t"."group" = "s"."group" AND "t"."resource" = "s"."resource" AND "t"."namespace" = CASE "s"."namespace" WHEN 'team-a' THEN 'team-b' ELSE "s"."namespace" END AND "t"."name" = "s"."name")) AND ("s"."group", "s"."resource", CASE "s"."namespace" WHEN 'team-a' THEN 'team-b' ELSE "s"."namespace" END, "s"."name") > ('apps', 'deployments', 'default', 'a') ORDER BY key_group, key_resource, key_namespace, key_name LIMIT 10`,
+		"SELECT `s`.`group` AS key_group, `s`.`resource` AS key_resource, CASE `s`.`namespace` WHEN 'team-a' THEN 'team-b' ELSE `s`.`namespace` END AS key_namespace, `s`.`name` AS key_name, `s`.`namespace` AS source_namespace FROM resources AS s WHERE `s`.`cluster` = 'cluster-1' AND (`s`.`group` = 'apps' AND `s`.`resource` = 'deployments') AND NOT EXISTS (SELECT 1 FROM resources AS t WHERE `t`.`cluster` = 'cluster-2' AND (`t`.`group` = `s`.`group` AND `t`.`resource` = `s`.`resource` AND `t`.`namespace` = CASE `s`.`namespace` WHEN 'team-a' THEN 'team-b' ELSE `s`.`namespace` END AND `t`.`name` = `s`.`name`)) AND (`s`.`group`, `s`.`resource`, CASE `s`.`namespace` WHEN 'team-a' THEN 'team-b' ELSE `s`.`namespace` END, `s`.`name`) > ('apps', 'deployments', 'default', 'a') ORDER BY key_group, key_resource, key_namespace, key_name LIMIT 10",
+		"",
+	}
+	t.Run("postgres", func(t *testing.T) {
+		assertSQL(t, postgresDB, opts, applyFn, expected.postgres, nil)
+	})
+	for version := range mysqlDBs {
+		t.Run("mysql-"+version, func(t *testing.T) {
+			assertSQL(t, mysqlDBs[version], opts, applyFn, expected.mysql, nil)
+		})
+	}
+}
+
+func TestDiffClusters(t *testing.T) {
+	factory := newExportTestFactory(t)
+	resources := []struct {
+		cluster, group, resource, namespace, name string
+		spec                                      string
+	}{
+		{"cluster-1", "apps", "deployments", "default", "same", `{"replicas":1}`},
+		{"cluster-1", "apps", "deployments", "default", "changed", `{"replicas":1}`},
+		{"cluster-1", "apps", "deployments", "default", "only-source", `{"replicas":1}`},
+		{"cluster-1", "", "pods", "team-a", "pod", `{"nodeName":"node-1"}`},
+		{"cluster-2", "apps", "deployments", "default", "same", `{"replicas":1}`},
+		{"cluster-2", "apps", "deployments", "default", "changed", `{"replicas":2}`},
+		{"cluster-2", "apps", "deployments", "default", "only-target", `{"replicas":1}`},
+		{"cluster-2", "", "pods", "team-b", "pod", `{"nodeName":"node-1"}`},
+	}
+	for i, r := range resources {
+		// the cluster-specific fields and the status are different in the clusters
+		object := fmt.Sprintf(`{"metadata":{"name":%q,"namespace":%q,"uid":"uid-%d","resourceVersion":"%d","creationTimestamp":"2024-01-0%dT00:00:00Z"},"spec":%s,"status":{"observedGeneration":%d}}`,
+			r.name, r.namespace, i, i, i%9+1, r.spec, i)
+		require.NoError(t, factory.db.Create(&Resource{
+			Cluster: r.cluster, Group: r.group, Version: "v1", Resource: r.resource, Kind: "Kind",
+			Namespace: r.namespace, Name: r.name, UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: fmt.Sprint(i),
+			Object: []byte(object),
+		}).Error)
+	}
+
+	diff := func(t *testing.T, opts storage.DiffOptions) []storage.DiffItem {
+		opts.Source, opts.Target = "cluster-1", "cluster-2"
+		report, err := factory.DiffClusters(context.Background(), opts)
+		require.NoError(t, err)
+		assert.Empty(t, report.Continue)
+		return report.Items
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		assert.Equal(t, []storage.DiffItem{
+			{Type: storage.DiffMissingInTarget, Resource: "pods", Namespace: "team-a", Name: "pod"},
+			{Type: storage.DiffMissingInTarget, Group: "apps", Resource: "deployments", Namespace: "default", Name: "only-source"},
+			{Type: storage.DiffMissingInSource, Resource: "pods", Namespace: "team-b", Name: "pod"},
+			{Type: storage.DiffMissingInSource, Group: "apps", Resource: "deployments", Namespace: "default", Name: "only-target"},
+		}, diff(t, storage.DiffOptions{}))
+	})
+
+	t.Run("namespace mapping and compare", func(t *testing.T) {
+		assert.Equal(t, []storage.DiffItem{
+			{Type: storage.DiffMissingInTarget, Group: "apps", Resource: "deployments", Namespace: "default", Name: "only-source"},
+			{Type: storage.DiffMissingInSource, Group: "apps", Resource: "deployments", Namespace: "default", Name: "only-target"},
+			{Type: storage.DiffDifferent, Group: "apps", Resource: "deployments", Namespace: "default", Name: "changed"},
+		}, diff(t, storage.DiffOptions{NamespaceMapping: map[string]string{"team-a": "team-b"}, Compare: true}))
+	})
+
+	t.Run("resources", func(t *testing.T) {
+		assert.Equal(t, []storage.DiffItem{
+			{Type: storage.DiffMissingInTarget, Resource: "pods", Namespace: "team-a", Name: "pod"},
+			{Type: storage.DiffMissingInSource, Resource: "pods", Namespace: "team-b", Name: "pod"},
+		}, diff(t, storage.DiffOptions{Resources: []schema.GroupVersionResource{{Resource: "pods"}}, Compare: true}))
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		opts := storage.DiffOptions{Source: "cluster-1", Target: "cluster-2", Compare: true, Limit: 1}
+		var items []storage.DiffItem
+		for {
+			report, err := factory.DiffClusters(context.Background(), opts)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(report.Items), 1)
+			items = append(items, report.Items...)
+			if report.Continue == "" {
+				break
+			}
+			opts.Continue = report.Continue
+		}
+		assert.Equal(t, diff(t, storage.DiffOptions{Compare: true}), items)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := factory.DiffClusters(context.Background(), storage.DiffOptions{Source: "cluster-1", Target: "cluster-1"})
+		assert.Error(t, err)
+		_, err = factory.DiffClusters(context.Background(), storage.DiffOptions{Source: "cluster-1", Target: "cluster-2", Continue: "invalid"})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,79 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	gmysql "gorm.io/driver/mysql"
+	gpostgres "gorm.io/driver/postgres"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// errDryRun is returned by the connections of the dry run databases.
+var errDryRun = errors.New("the dry run database can't be connected")
+
+// dryRunConnector is the connector of the dry run databases, which never connects to the database.
+type dryRunConnector struct{}
+
+func (dryRunConnector) Connect(context.Context) (driver.Conn, error) { return nil, errDryRun }
+
+func (dryRunConnector) Driver() driver.Driver { return dryRunDriver{} }
+
+type dryRunDriver struct{}
+
+func (dryRunDriver) Open(string) (driver.Conn, error) { return nil, errDryRun }
+
+// newDryRunDB opens the db of the dialect whose queries are only generated by the DryRun sessions,
+// the mysql and postgres dbs never connect to the databases, and the sqlite db is in memory.
+// The server version of mysql is required since the generated queries depend on it, e.g. 8.0.27.
+func newDryRunDB(dialect, serverVersion string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch dialect {
+	case "mysql":
+		if serverVersion == "" {
+			return nil, errors.New("the server version of mysql is required")
+		}
+		dialector = gmysql.New(gmysql.Config{Conn: sql.OpenDB(dryRunConnector{}), ServerVersion: serverVersion, SkipInitializeWithVersion: true})
+	case "postgres":
+		dialector = gpostgres.New(gpostgres.Config{Conn: sql.OpenDB(dryRunConnector{})})
+	case "sqlite", "sqlite3":
+		dialector = gsqlite.Open(":memory:")
+	default:
+		return nil, fmt.Errorf("not support storage type: %s", dialect)
+	}
+	return gorm.Open(dialector, &gorm.Config{SkipDefaultTransaction: true, DisableAutomaticPing: true, Logger: logger.Discard})
+}
+
+// RenderListSQL renders the SQL of the list query of the resource on the database of the dialect without connecting to it,
+// e.g. to reproduce the list queries of the support cases. The dialect is one of mysql, postgres and sqlite, and the server
+// version is required by mysql.
+//
+// The queries are rendered with the default config of the storage, the config affecting the queries
+// like the label selector mode and the index hints isn't applied.
+func RenderListSQL(dialect, serverVersion string, config *storage.ResourceStorageConfig, opts *internal.ListOptions) (string, error) {
+	db, err := newDryRunDB(dialect, serverVersion)
+	if err != nil {
+		return "", err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	rs := &ResourceStorage{
+		db:                   db,
+		codec:                config.Codec,
+		storageGroupResource: config.StorageGroupResource,
+		storageVersion:       config.StorageVersion,
+		memoryVersion:        config.MemoryVersion,
+		clusterScoped:        !config.Namespaced,
+	}
+	return rs.ListSQL(context.Background(), opts)
+}
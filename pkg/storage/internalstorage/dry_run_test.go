@@ -0,0 +1,99 @@
+package internalstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/client/querybuilder"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// goldenListSQLDialects are the dialects of the golden SQL of the list queries, the mysql dialects are versioned.
+var goldenListSQLDialects = []struct{ name, dialect, version string }{
+	{"sqlite", "sqlite", ""},
+	{"postgres", "postgres", ""},
+	{"mysql-8.0.27", "mysql", "8.0.27"},
+	{"mysql-5.7.22", "mysql", "5.7.22"},
+}
+
+// TestListSQLGolden renders the SQL of the list queries on all of the dialects and compares them with the golden files
+// in the testdata/list_sql, so the changes of the generated SQL are reviewed with the changes of the golden files.
+// The golden files are regenerated by the UPDATE_GOLDEN env, e.g. `UPDATE_GOLDEN=1 go test -run TestListSQLGolden`.
+func TestListSQLGolden(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]*querybuilder.Builder{
+		"clusters_namespaces_names": querybuilder.New().Clusters("cluster-1", "cluster-2").Namespaces("default", "kube-system").Names("web"),
+		"owner_uid":                 querybuilder.New().Clusters("cluster-1").OwnerUID("uid-1"),
+		"owner_name_seniority":      querybuilder.New().Clusters("cluster-1").Namespaces("default").OwnerName("web").OwnerGroupResource(schema.GroupResource{Group: "apps", Resource: "deployments"}).Seniority(1),
+		"label_selector":            querybuilder.New().LabelSelector("app=web,tier in (frontend,backend),!canary"),
+		"field_selector":            querybuilder.New().FieldSelector("status.phase=Running,spec.nodeName!=node-1"),
+		"fuzzy_names":               querybuilder.New().FuzzyNames("web", "prod"),
+		"order_by":                  querybuilder.New().OrderByDesc("created_at").OrderBy("name").OrderBy("cluster"),
+		"since_before":              querybuilder.New().Since(since).Before(since.Add(24 * time.Hour)),
+		"limit_continue":            querybuilder.New().Clusters("cluster-1").Limit(10).Continue("20").WithContinue(true),
+		"only_metadata":             querybuilder.New().Namespaces("default").OnlyMetadata(),
+	}
+
+	config := &storage.ResourceStorageConfig{
+		StorageGroupResource: schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"},
+		StorageVersion:       appsv1.SchemeGroupVersion,
+		MemoryVersion:        appsv1.SchemeGroupVersion,
+		Namespaced:           true,
+	}
+	for name, builder := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts, err := builder.ListOptions()
+			require.NoError(t, err)
+
+			var rendered strings.Builder
+			for _, dialect := range goldenListSQLDialects {
+				sql, err := RenderListSQL(dialect.dialect, dialect.version, config, opts)
+				require.NoError(t, err, dialect.name)
+				rendered.WriteString("-- " + dialect.name + "\n" + sql + "\n")
+			}
+
+			path := filepath.Join("testdata", "list_sql", name+".sql")
+			if os.Getenv("UPDATE_GOLDEN") != "" {
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				require.NoError(t, os.WriteFile(path, []byte(rendered.String()), 0644))
+			}
+			golden, err := os.ReadFile(path)
+			require.NoError(t, err, "the golden file is generated by the UPDATE_GOLDEN env")
+			assert.Equal(t, string(golden), rendered.String())
+		})
+	}
+}
+
+func TestRenderListSQL(t *testing.T) {
+	config := &storage.ResourceStorageConfig{
+		StorageGroupResource: schema.GroupResource{Resource: "nodes"},
+		StorageVersion:       schema.GroupVersion{Version: "v1"},
+	}
+	sql, err := RenderListSQL("postgres", "", config, &internal.ListOptions{ClusterNames: []string{"cluster-1"}})
+	require.NoError(t, err)
+	assert.Contains(t, sql, `"resource" = 'nodes'`)
+
+	_, err = RenderListSQL("mysql", "", config, &internal.ListOptions{})
+	assert.Error(t, err, "the server version of mysql is required")
+	_, err = RenderListSQL("oracle", "", config, &internal.ListOptions{})
+	assert.Error(t, err)
+
+	// the cluster-scoped resource can't be listed in the namespaces
+	_, err = RenderListSQL("postgres", "", config, &internal.ListOptions{Namespaces: []string{"default"}})
+	assert.Error(t, err)
+
+	// the dry run database is never connected
+	db, err := newDryRunDB("postgres", "")
+	require.NoError(t, err)
+	assert.True(t, errors.Is(db.Exec("SELECT 1").Error, errDryRun))
+}
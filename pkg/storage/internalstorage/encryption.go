@@ -0,0 +1,375 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// encryptedObjectPrefix is the prefix of the encrypted objects, the encrypted object is stored as a json string
+// in the format of `"enc:aesgcm:<key id>:<base64 envelope>"`, since the object column is json.
+const encryptedObjectPrefix = `"enc:aesgcm:`
+
+// EncryptionConfig encrypts the objects of the resources at rest by the envelope encryption, each object is encrypted by
+// a random data key with AES-GCM, and the data key is wrapped by the key encryption key stored alongside the object.
+//
+// The metadata column isn't encrypted, so the OnlyMetadata queries work as before, but the queries of the other fields
+// of the objects, e.g. the extra fields and the where sql, don't match the encrypted objects.
+type EncryptionConfig struct {
+	// Resources are the resources whose objects are encrypted, in the format of `resource.group`, Default is [secrets].
+	Resources []string `yaml:"resources"`
+
+	// Keys are the key encryption keys, the objects are encrypted by the first key and decrypted by the key of their ids,
+	// so the key is rotated by adding the new key at the first and rewriting the encrypted objects by the rewrite-encryption.
+	// The encryption is disabled if the keys are empty.
+	Keys []EncryptionKeyConfig `yaml:"keys"`
+}
+
+type EncryptionKeyConfig struct {
+	// ID is stored with the encrypted objects to find the key to decrypt them, it must not contain `:`.
+	ID string `yaml:"id"`
+
+	// SecretFile is the file of the base64 encoded 32 bytes AES key.
+	SecretFile string `yaml:"secretFile"`
+}
+
+// KeyEncryptionKey wraps the data keys of the encrypted objects, e.g. the static key or the key of the KMS.
+type KeyEncryptionKey interface {
+	ID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// staticKey is the key encryption key loaded from the file, the data keys are wrapped by AES-GCM.
+type staticKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newStaticKey(config EncryptionKeyConfig) (*staticKey, error) {
+	encoded, err := os.ReadFile(config.SecretFile)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("the secret file isn't base64 encoded: %w", err)
+	}
+	if len(secret) != 32 {
+		return nil, fmt.Errorf("the secret must be 32 bytes, got %d bytes", len(secret))
+	}
+
+	aead, err := newAESGCM(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &staticKey{id: config.ID, aead: aead}, nil
+}
+
+func (k *staticKey) ID() string {
+	return k.id
+}
+
+func (k *staticKey) WrapKey(dataKey []byte) ([]byte, error) {
+	return sealData(k.aead, dataKey)
+}
+
+func (k *staticKey) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return openData(k.aead, wrapped)
+}
+
+// objectEncryption encrypts the objects of the configured resources, and decrypts the encrypted objects of any resources,
+// since the resources may be removed from the config after their objects are encrypted.
+type objectEncryption struct {
+	resources map[schema.GroupResource]struct{}
+
+	primary KeyEncryptionKey
+	keys    map[string]KeyEncryptionKey
+}
+
+// newObjectEncryption returns nil if the encryption is disabled.
+func newObjectEncryption(config EncryptionConfig) (*objectEncryption, error) {
+	if len(config.Keys) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]KeyEncryptionKey, 0, len(config.Keys))
+	for _, keyConfig := range config.Keys {
+		if keyConfig.ID == "" || strings.Contains(keyConfig.ID, ":") {
+			return nil, fmt.Errorf("encryption: invalid key id %q", keyConfig.ID)
+		}
+		key, err := newStaticKey(keyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: key %s: %w", keyConfig.ID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	resources := config.Resources
+	if len(resources) == 0 {
+		resources = []string{"secrets"}
+	}
+	return newObjectEncryptionWithKeys(resources, keys)
+}
+
+func newObjectEncryptionWithKeys(resources []string, keys []KeyEncryptionKey) (*objectEncryption, error) {
+	encryption := &objectEncryption{
+		resources: make(map[schema.GroupResource]struct{}, len(resources)),
+		primary:   keys[0],
+		keys:      make(map[string]KeyEncryptionKey, len(keys)),
+	}
+	for _, resource := range resources {
+		encryption.resources[schema.ParseGroupResource(resource)] = struct{}{}
+	}
+	for _, key := range keys {
+		if _, ok := encryption.keys[key.ID()]; ok {
+			return nil, fmt.Errorf("encryption: duplicate key id %q", key.ID())
+		}
+		encryption.keys[key.ID()] = key
+	}
+	return encryption, nil
+}
+
+// encrypts returns whether the objects of the resource are encrypted.
+func (e *objectEncryption) encrypts(gr schema.GroupResource) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.resources[gr]
+	return ok
+}
+
+// encrypt encrypts the object by a new data key wrapped by the primary key.
+func (e *objectEncryption) encrypt(object []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := e.primary.WrapKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealData(aead, object)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 2, 2+len(wrapped)+len(sealed))
+	binary.BigEndian.PutUint16(envelope, uint16(len(wrapped)))
+	envelope = append(append(envelope, wrapped...), sealed...)
+
+	encoded := encryptedObjectPrefix + e.primary.ID() + ":" + base64.RawURLEncoding.EncodeToString(envelope) + `"`
+	return []byte(encoded), nil
+}
+
+// decrypt returns the object as is if it isn't encrypted.
+func (e *objectEncryption) decrypt(stored []byte) ([]byte, error) {
+	keyID, encoded, ok := parseEncryptedObject(stored)
+	if !ok {
+		return stored, nil
+	}
+	if e == nil {
+		return nil, errors.New("the object is encrypted, but the encryption isn't configured")
+	}
+	key, ok := e.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("the object is encrypted by the unknown key %q", keyID)
+	}
+
+	envelope, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(envelope) < 2 {
+		return nil, errors.New("the envelope of the encrypted object is malformed")
+	}
+	size := int(binary.BigEndian.Uint16(envelope))
+	if len(envelope) < 2+size {
+		return nil, errors.New("the envelope of the encrypted object is malformed")
+	}
+	dataKey, err := key.UnwrapKey(envelope[2 : 2+size])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key by the key %q: %w", keyID, err)
+	}
+	aead, err := newAESGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return openData(aead, envelope[2+size:])
+}
+
+// decryptObject decrypts the encrypted object of the row, the object without the encoded object is returned as is.
+func (e *objectEncryption) decryptObject(object Object) (Object, error) {
+	var err error
+	switch o := object.(type) {
+	case Resource:
+		o.Object, err = e.decrypt(o.Object)
+		return o, err
+	case ResourceBytes:
+		o.Object, err = e.decrypt(o.Object)
+		return o, err
+	case Bytes:
+		decrypted, err := e.decrypt(o)
+		return Bytes(decrypted), err
+	}
+	return object, nil
+}
+
+var _ storage.EncryptionRewriter = &StorageFactory{}
+
+// encryptionRow is the row scanned by the encryption rewriting.
+type encryptionRow struct {
+	ID              uint
+	Group           string
+	Resource        string
+	ResourceVersion string
+	Object          datatypes.JSON
+}
+
+func (row encryptionRow) rowID() uint {
+	return row.ID
+}
+
+// RewriteEncryption scans the resources in batches by the order of the id, and rewrites the objects which aren't
+// stored by the current encryption config, e.g. encrypted by the rotated key, or stored in plaintext before their
+// resources are encrypted. The resource updated by the sync during the rewriting is skipped, since it is written
+// by the current encryption config.
+func (s *StorageFactory) RewriteEncryption(ctx context.Context, opts storage.EncryptionRewriteOptions) (storage.EncryptionRewriteSummary, error) {
+	var summary storage.EncryptionRewriteSummary
+	if s.encryption == nil {
+		return summary, errors.New("the encryption isn't configured")
+	}
+
+	err := scanResources(ctx, s.namedDatabases(), scanOptions{
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+		Cursor:        opts.Cursor,
+		Progress: func(cursor string) {
+			summary.Cursor = cursor
+			if opts.Progress != nil {
+				opts.Progress(summary)
+			}
+		},
+	}, []string{"id", "group", "resource", "resource_version", "object"}, func(db *gorm.DB, row encryptionRow) error {
+		return s.rewriteResourceEncryption(ctx, db, row, opts.DryRun, &summary)
+	})
+	return summary, err
+}
+
+func (s *StorageFactory) rewriteResourceEncryption(ctx context.Context, db *gorm.DB, row encryptionRow, dryRun bool, summary *storage.EncryptionRewriteSummary) error {
+	summary.Scanned++
+
+	keyID, _, encrypted := parseEncryptedObject(row.Object)
+	encrypt := s.encryption.encrypts(schema.GroupResource{Group: row.Group, Resource: row.Resource})
+	if encrypt == encrypted && (!encrypted || keyID == s.encryption.primary.ID()) {
+		return nil
+	}
+
+	object, err := s.encryption.decrypt(row.Object)
+	if err != nil {
+		summary.Failed++
+		klog.ErrorS(err, "Failed to decrypt the stored object", "id", row.ID, "group", row.Group, "resource", row.Resource)
+		return nil
+	}
+	// the split object is reassembled before it is encrypted, since the spec and status can't be stored in plaintext
+	split := encrypt && !encrypted && !s.splitColumnsMissing[db]
+	if split {
+		var resource Resource
+		if err := db.WithContext(ctx).Select("spec", "status").Where("id = ?", row.ID).Take(&resource).Error; err != nil {
+			return InterpretDBError(fmt.Sprintf("resource %d", row.ID), err)
+		}
+		object = assembleObject(object, resource.Spec, resource.Status)
+	}
+	if encrypt {
+		if object, err = s.encryption.encrypt(object); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		if encrypt {
+			summary.Encrypted++
+		} else {
+			summary.Decrypted++
+		}
+		return nil
+	}
+
+	updated := map[string]interface{}{"object": datatypes.JSON(object)}
+	if split {
+		updated["spec"], updated["status"] = nil, nil
+	}
+	if !s.checksumMissing[db] {
+		checksum, err := objectChecksum(object)
+		if err != nil {
+			return err
+		}
+		updated["checksum"] = checksum
+	}
+
+	// the resource version of the row guards the update of the sync during the rewriting
+	result := db.WithContext(ctx).Model(&Resource{}).
+		Where(map[string]interface{}{"id": row.ID, "resource_version": row.ResourceVersion}).
+		UpdateColumns(updated)
+	if result.Error != nil {
+		return InterpretDBError(fmt.Sprintf("resource %d", row.ID), result.Error)
+	}
+	if result.RowsAffected == 0 {
+		summary.Conflicted++
+		return nil
+	}
+	if encrypt {
+		summary.Encrypted++
+	} else {
+		summary.Decrypted++
+	}
+	return nil
+}
+
+// parseEncryptedObject returns the key id and the encoded envelope of the encrypted object.
+func parseEncryptedObject(stored []byte) (keyID, encoded string, ok bool) {
+	if !bytes.HasPrefix(stored, []byte(encryptedObjectPrefix)) || !bytes.HasSuffix(stored, []byte(`"`)) {
+		return "", "", false
+	}
+	keyID, encoded, ok = strings.Cut(string(stored[len(encryptedObjectPrefix):len(stored)-1]), ":")
+	return keyID, encoded, ok
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealData prepends the random nonce to the sealed data.
+func sealData(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openData(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("the sealed data is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
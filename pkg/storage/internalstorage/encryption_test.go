@@ -0,0 +1,215 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newTestStaticKey(t *testing.T, id string) KeyEncryptionKey {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), id)
+	require.NoError(t, os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(secret)+"\n"), 0o600))
+	key, err := newStaticKey(EncryptionKeyConfig{ID: id, SecretFile: file})
+	require.NoError(t, err)
+	return key
+}
+
+func TestObjectEncryption(t *testing.T) {
+	key1, key2 := newTestStaticKey(t, "key-1"), newTestStaticKey(t, "key-2")
+	encryption, err := newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key1})
+	require.NoError(t, err)
+	assert.True(t, encryption.encrypts(schema.GroupResource{Resource: "secrets"}))
+	assert.False(t, encryption.encrypts(schema.GroupResource{Group: "apps", Resource: "deployments"}))
+
+	object := []byte(`{"kind":"Secret","data":{"password":"cGFzc3dvcmQ="}}`)
+	encrypted, err := encryption.encrypt(object)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(encrypted, []byte(`"enc:aesgcm:key-1:`)))
+	assert.NotContains(t, string(encrypted), "cGFzc3dvcmQ=")
+
+	decrypted, err := encryption.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, object, decrypted)
+
+	// the plaintext object is returned as is
+	decrypted, err = encryption.decrypt(object)
+	require.NoError(t, err)
+	assert.Equal(t, object, decrypted)
+
+	// the object encrypted by the previous key is decrypted after the key is rotated
+	rotated, err := newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key2, key1})
+	require.NoError(t, err)
+	decrypted, err = rotated.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, object, decrypted)
+
+	// the object can't be decrypted by the unknown key or without the encryption
+	unknown, err := newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key2})
+	require.NoError(t, err)
+	_, err = unknown.decrypt(encrypted)
+	assert.Error(t, err)
+	_, err = (*objectEncryption)(nil).decrypt(encrypted)
+	assert.Error(t, err)
+
+	_, err = newObjectEncryptionWithKeys(nil, []KeyEncryptionKey{key1, key1})
+	assert.Error(t, err)
+	disabled, err := newObjectEncryption(EncryptionConfig{Resources: []string{"secrets"}})
+	require.NoError(t, err)
+	assert.Nil(t, disabled)
+}
+
+func TestResourceStorage_Encryption(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	key1, key2 := newTestStaticKey(t, "key-1"), newTestStaticKey(t, "key-2")
+	encryption, err := newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key1})
+	require.NoError(t, err)
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("secrets"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "secrets"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.encryption, rs.encrypt, rs.verifyChecksum = encryption, true, true
+
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &corev1.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1"},
+			Data:       map[string][]byte{"password": []byte("password-" + name)},
+		}))
+	}
+
+	var resource Resource
+	require.NoError(t, db.Where("name = ?", "a").First(&resource).Error)
+	assert.True(t, bytes.HasPrefix(resource.Object, []byte(`"enc:aesgcm:key-1:`)))
+	assert.Contains(t, string(resource.Metadata), `"name":"a"`)
+
+	secret := &corev1.Secret{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "a", secret))
+	assert.Equal(t, []byte("password-a"), secret.Data["password"])
+
+	list := &corev1.SecretList{}
+	require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{}))
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, []byte("password-b"), list.Items[1].Data["password"])
+
+	metadataList := &metav1.PartialObjectMetadataList{}
+	require.NoError(t, rs.List(context.Background(), metadataList, &internal.ListOptions{OnlyMetadata: true}))
+	require.Len(t, metadataList.Items, 2)
+
+	// the object written before the resource is encrypted
+	rs.encrypt = false
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b", UID: "b", ResourceVersion: "2"},
+		Data:       map[string][]byte{"password": []byte("password-b")},
+	}))
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "b", secret))
+
+	// rotate the key
+	rotated, err := newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key2, key1})
+	require.NoError(t, err)
+	factory := &StorageFactory{db: db, encryption: rotated}
+
+	summary, err := factory.RewriteEncryption(context.Background(), storage.EncryptionRewriteOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, storage.EncryptionRewriteSummary{Scanned: 2, Encrypted: 2, Cursor: `{"default":2}`}, summary)
+
+	summary, err = factory.RewriteEncryption(context.Background(), storage.EncryptionRewriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.EncryptionRewriteSummary{Scanned: 2, Encrypted: 2, Cursor: `{"default":2}`}, summary)
+
+	var resources []Resource
+	require.NoError(t, db.Order("id").Find(&resources).Error)
+	for _, resource := range resources {
+		assert.True(t, bytes.HasPrefix(resource.Object, []byte(`"enc:aesgcm:key-2:`)))
+		assert.NoError(t, resource.verifyChecksum())
+	}
+
+	// the objects are readable without the removed key
+	rs.encryption, err = newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{key2})
+	require.NoError(t, err)
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "b", secret))
+	assert.Equal(t, []byte("password-b"), secret.Data["password"])
+
+	summary, err = factory.RewriteEncryption(context.Background(), storage.EncryptionRewriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.EncryptionRewriteSummary{Scanned: 2, Cursor: `{"default":2}`}, summary)
+
+	// the objects are decrypted after the resource is removed from the encryption
+	factory.encryption, err = newObjectEncryptionWithKeys([]string{"configmaps"}, []KeyEncryptionKey{key2})
+	require.NoError(t, err)
+	summary, err = factory.RewriteEncryption(context.Background(), storage.EncryptionRewriteOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.EncryptionRewriteSummary{Scanned: 2, Decrypted: 2, Cursor: `{"default":2}`}, summary)
+
+	require.NoError(t, db.Where("name = ?", "a").First(&resource).Error)
+	assert.Contains(t, string(resource.Object), "cGFzc3dvcmQtYQ==")
+}
+
+func TestResourceStorageWatch_Encryption(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("secrets"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "secrets"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.encryption, err = newObjectEncryptionWithKeys([]string{"secrets"}, []KeyEncryptionKey{newTestStaticKey(t, "key-1")})
+	require.NoError(t, err)
+	rs.encrypt = true
+	rs.hub = newWatchHub(WatchHubConfig{Enabled: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{})
+	require.NoError(t, err)
+	defer watcher.Stop()
+
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", ResourceVersion: "1"},
+		Data:       map[string][]byte{"password": []byte("password")},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", secret))
+	deletedObj, err := rs.ConvertDeletedObject(secret)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+
+	for _, eventType := range []watch.EventType{watch.Added, watch.Deleted} {
+		select {
+		case event := <-watcher.ResultChan():
+			assert.Equal(t, eventType, event.Type)
+			metaobj, err := meta.Accessor(event.Object)
+			require.NoError(t, err)
+			assert.Equal(t, "a", metaobj.GetName())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the %s event is not received", eventType)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package internalstorage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// storageEpochID is the id of the only row of the storage_epochs table.
+const storageEpochID = 1
+
+// StorageEpoch is the epoch of the stored resources. Populated is set once the resources are stored in the epoch,
+// so the resources table emptied out of band is detected as the loss of the resources.
+type StorageEpoch struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement:false"`
+	Epoch     string    `gorm:"size:64;not null"`
+	Populated bool      `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func init() {
+	registerMigration(migration{
+		version:  16,
+		name:     "create the storage_epochs table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &StorageEpoch{})
+		},
+	})
+}
+
+var _ storage.StorageEpochManager = &StorageFactory{}
+
+// GetStorageEpoch returns the epoch of the stored resources, the epoch is rewritten if it is lost, e.g. an old backup
+// without it is restored, or the resources table is emptied after the resources are stored in the epoch.
+// The resources of the routed databases are not checked.
+func (s *StorageFactory) GetStorageEpoch(ctx context.Context) (string, error) {
+	db := s.db.WithContext(ctx)
+	epoch, err := loadStorageEpoch(db)
+	if err != nil {
+		return "", err
+	}
+	populated, err := resourcesPopulated(db)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case epoch == nil:
+		klog.InfoS("The storage epoch is lost, reset the storage epoch")
+		return writeStorageEpoch(db, nil, populated)
+	case epoch.Populated && !populated:
+		klog.InfoS("The stored resources are lost, reset the storage epoch", "epoch", epoch.Epoch)
+		return writeStorageEpoch(db, epoch, populated)
+	case !epoch.Populated && populated:
+		result := db.Model(&StorageEpoch{}).Where("id = ? AND epoch = ?", storageEpochID, epoch.Epoch).Update("populated", true)
+		if result.Error != nil {
+			return "", InterpretDBError("storage epoch", result.Error)
+		}
+	}
+	return epoch.Epoch, nil
+}
+
+// ResetStorageEpoch rewrites the epoch by a random value, so the synchros resync the resources of all clusters.
+func (s *StorageFactory) ResetStorageEpoch(ctx context.Context) (string, error) {
+	db := s.db.WithContext(ctx)
+	populated, err := resourcesPopulated(db)
+	if err != nil {
+		return "", err
+	}
+
+	epoch, err := newStorageEpoch()
+	if err != nil {
+		return "", err
+	}
+	row := StorageEpoch{ID: storageEpochID, Epoch: epoch, Populated: populated, UpdatedAt: time.Now()}
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error; err != nil {
+		return "", InterpretDBError("storage epoch", err)
+	}
+	klog.InfoS("The storage epoch is reset", "epoch", epoch)
+	return epoch, nil
+}
+
+func loadStorageEpoch(db *gorm.DB) (*StorageEpoch, error) {
+	var epochs []StorageEpoch
+	if err := db.Where("id = ?", storageEpochID).Limit(1).Find(&epochs).Error; err != nil {
+		return nil, InterpretDBError("storage epoch", err)
+	}
+	if len(epochs) == 0 {
+		return nil, nil
+	}
+	return &epochs[0], nil
+}
+
+// writeStorageEpoch replaces the old epoch by a random value, the epoch replaced concurrently, e.g. by the other
+// synchro managers detecting the same loss, is returned instead.
+func writeStorageEpoch(db *gorm.DB, old *StorageEpoch, populated bool) (string, error) {
+	epoch, err := newStorageEpoch()
+	if err != nil {
+		return "", err
+	}
+
+	row := StorageEpoch{ID: storageEpochID, Epoch: epoch, Populated: populated, UpdatedAt: time.Now()}
+	if old == nil {
+		err = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+	} else {
+		err = db.Model(&StorageEpoch{}).Where("id = ? AND epoch = ?", storageEpochID, old.Epoch).
+			Updates(map[string]interface{}{"epoch": row.Epoch, "populated": row.Populated, "updated_at": row.UpdatedAt}).Error
+	}
+	if err != nil {
+		return "", InterpretDBError("storage epoch", err)
+	}
+
+	current, err := loadStorageEpoch(db)
+	if err != nil {
+		return "", err
+	}
+	if current == nil {
+		return "", storage.NewError(ErrTransient, errors.New("the storage epoch is deleted concurrently"))
+	}
+	return current.Epoch, nil
+}
+
+func resourcesPopulated(db *gorm.DB) (bool, error) {
+	var ids []uint
+	if err := db.Model(&Resource{}).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return false, InterpretDBError("storage epoch", err)
+	}
+	return len(ids) != 0, nil
+}
+
+func newStorageEpoch() (string, error) {
+	epoch := make([]byte, 16)
+	if _, err := rand.Read(epoch); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(epoch), nil
+}
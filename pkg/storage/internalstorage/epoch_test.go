@@ -0,0 +1,51 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageEpoch(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&StorageEpoch{}))
+	factory := &StorageFactory{db: db}
+
+	// the epoch is created by the first check, and kept while the resources are stored
+	epoch, err := factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, epoch)
+	require.NoError(t, db.Create(&Resource{Cluster: "cluster-1", Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+		Namespace: "default", Name: "deploy-1", ResourceVersion: "1", Object: []byte(`{}`)}).Error)
+	current, err := factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, epoch, current)
+
+	// the truncated resources are detected
+	require.NoError(t, db.Where("1 = 1").Delete(&Resource{}).Error)
+	truncated, err := factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, epoch, truncated)
+	current, err = factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, truncated, current)
+
+	// the epoch is reset deliberately
+	reset, err := factory.ResetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.NotEqual(t, truncated, reset)
+	current, err = factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, reset, current)
+
+	// the epoch lost with the restored backup is recreated
+	require.NoError(t, db.Where("1 = 1").Delete(&StorageEpoch{}).Error)
+	restored, err := factory.GetStorageEpoch(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, restored)
+	assert.NotEqual(t, reset, restored)
+}
@@ -1,19 +1,24 @@
 package internalstorage
 
 import (
+	"context"
 	"database/sql/driver"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
+	"github.com/mattn/go-sqlite3"
 	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	genericstorage "k8s.io/apiserver/pkg/storage"
 
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
@@ -32,13 +37,134 @@ var recoverableErrors = []error{
 }
 
 func init() {
-	recoverableMysqlErrNumbers.Store(1053, struct{}{}) // ER_SERVER_SHUTDOWN: Server shutdown in progress
-	recoverableMysqlErrNumbers.Store(1205, struct{}{}) // Error 1205: Lock wait timeout exceeded; try restarting transaction.
-	recoverableMysqlErrNumbers.Store(1290, struct{}{}) // Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement.
+	recoverableMysqlErrNumbers.Store(uint16(1053), struct{}{}) // ER_SERVER_SHUTDOWN: Server shutdown in progress
+	recoverableMysqlErrNumbers.Store(uint16(1205), struct{}{}) // Error 1205: Lock wait timeout exceeded; try restarting transaction.
+	recoverableMysqlErrNumbers.Store(uint16(1290), struct{}{}) // Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement.
 
 	recoverablePostgresErrCodes.Store(pgerrcode.AdminShutdown, struct{}{})
 }
 
+// ErrorKind is the machine-readable cause of the storage error.
+type ErrorKind string
+
+const (
+	// ErrorKindDuplicateKey is the violation of the unique or primary key, the object already exists.
+	ErrorKindDuplicateKey ErrorKind = "duplicate_key"
+	// ErrorKindConstraintViolation is the violation of the other constraints, e.g. the not null and the foreign key,
+	// the write is invalid instead of conflicting with the stored object.
+	ErrorKindConstraintViolation ErrorKind = "constraint_violation"
+	ErrorKindConnection          ErrorKind = "connection"
+	ErrorKindTimeout             ErrorKind = "timeout"
+	ErrorKindSerialization       ErrorKind = "serialization"
+	ErrorKindTooLarge            ErrorKind = "too_large"
+	ErrorKindSchemaOutdated      ErrorKind = "schema_outdated"
+	ErrorKindUnknown             ErrorKind = "unknown"
+)
+
+// The sentinels of the causes of the errors returned by the ResourceStorage, the errors interpreted by InterpretDBError
+// wrap them by the kinds of the errors, see the storage package for the contract.
+var (
+	ErrNotFound       = storage.ErrNotFound
+	ErrConflict       = storage.ErrConflict
+	ErrTooLarge       = storage.ErrTooLarge
+	ErrTransient      = storage.ErrTransient
+	ErrSchemaOutdated = storage.ErrSchemaOutdated
+	ErrFenced         = storage.ErrFenced
+	ErrWriteDropped   = storage.ErrWriteDropped
+)
+
+// DBError is the database error classified by InterpretDBError,
+// it keeps the text of the original error.
+type DBError struct {
+	Kind    ErrorKind
+	Dialect string
+
+	Err error
+}
+
+func (e *DBError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DBError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports the sentinel of the cause of the error by its kind.
+func (e *DBError) Is(target error) bool {
+	switch e.Kind {
+	case ErrorKindDuplicateKey:
+		return target == ErrConflict
+	case ErrorKindConnection, ErrorKindTimeout, ErrorKindSerialization:
+		return target == ErrTransient
+	case ErrorKindTooLarge:
+		return target == ErrTooLarge
+	case ErrorKindSchemaOutdated:
+		return target == ErrSchemaOutdated
+	}
+	return false
+}
+
+// ObjectDecodeError is the error of decoding or converting the stored object,
+// it identifies the row of the object, so the corrupted row can be found.
+type ObjectDecodeError struct {
+	GroupResource schema.GroupResource
+	Row           ResourceIdentity
+
+	Err error
+}
+
+func (e *ObjectDecodeError) Error() string {
+	return fmt.Sprintf("failed to decode the object of %s %s: %v", e.GroupResource, e.Row, e.Err)
+}
+
+func (e *ObjectDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ObjectTooLargeError is the error of the object whose encoded size exceeds the object size limit,
+// it is rejected by the overflow, or it is still too large after being truncated.
+type ObjectTooLargeError struct {
+	GroupResource schema.GroupResource
+	Object        ResourceIdentity
+
+	Size     int
+	MaxBytes int
+}
+
+func (e *ObjectTooLargeError) Error() string {
+	return fmt.Sprintf("the encoded object of %s %s is %d bytes, exceeds the limit of %d bytes", e.GroupResource, e.Object, e.Size, e.MaxBytes)
+}
+
+func (e *ObjectTooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// timeoutError is the Timeout error of the apiserver caused by the deadline of the context,
+// it unwraps to the recoverable exception, so the operation can be retried by the caller.
+type timeoutError struct {
+	*apierrors.StatusError
+
+	cause error
+}
+
+func (e *timeoutError) Unwrap() error {
+	return e.cause
+}
+
+// ErrorKindOf returns the kind of the error returned by InterpretDBError,
+// it returns an empty string if the error is not a database error, such as the not found error.
+func ErrorKindOf(err error) ErrorKind {
+	var dbErr *DBError
+	if errors.As(err, &dbErr) {
+		return dbErr.Kind
+	}
+	if errors.Is(err, ErrConflict) {
+		return ErrorKindDuplicateKey
+	}
+	return ""
+}
+
 func InterpretResourceDBError(cluster, name string, err error) error {
 	if err == nil {
 		return nil
@@ -53,39 +179,160 @@ func InterpretDBError(key string, err error) error {
 	}
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return genericstorage.NewKeyNotFoundError(key, 0)
+		return storage.NewError(ErrNotFound, genericstorage.NewKeyNotFoundError(key, 0))
+	}
+
+	kind, dialect := classifyDBError(err)
+	dbErrorsTotal.WithLabelValues(string(kind), dialect).Inc()
+	dbErr := &DBError{Kind: kind, Dialect: dialect, Err: err}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &timeoutError{
+			StatusError: apierrors.NewTimeoutError(fmt.Sprintf("storage error: %s: %v", key, err), 0),
+			cause:       storage.NewRecoverableException(dbErr),
+		}
 	}
 
 	if _, isNetError := err.(net.Error); isNetError {
-		return storage.NewRecoverableException(err)
+		return storage.NewRecoverableException(dbErr)
 	}
 
 	if os.IsTimeout(err) {
-		return storage.NewRecoverableException(err)
+		return storage.NewRecoverableException(dbErr)
 	}
 
 	if errors.Is(err, driver.ErrBadConn) {
-		return storage.NewRecoverableException(fmt.Errorf("storage error: database connection error: %s", err))
+		dbErr.Err = fmt.Errorf("storage error: database connection error: %w", err)
+		return storage.NewRecoverableException(dbErr)
 	}
 
 	for _, re := range recoverableErrors {
 		if errors.Is(err, re) {
-			return storage.NewRecoverableException(err)
+			return storage.NewRecoverableException(dbErr)
 		}
 	}
 
 	// TODO(iceber): add dialector judgment
-	mysqlErr := InterpretMysqlError(key, err)
-	if mysqlErr != err {
+	mysqlErr := InterpretMysqlError(key, dbErr)
+	if mysqlErr != dbErr {
 		return mysqlErr
 	}
 
-	pgError := InterpretPostgresError(key, err)
-	if pgError != err {
+	pgError := InterpretPostgresError(key, dbErr)
+	if pgError != dbErr {
 		return pgError
 	}
 
-	return err
+	return dbErr
+}
+
+// classifyDBError returns the kind of the error and the dialect of the database that returns it,
+// the dialect is "unknown" if the error is not specific to a database driver.
+func classifyDBError(err error) (ErrorKind, string) {
+	var (
+		mysqlErr  *mysql.MySQLError
+		pgErr     *pgconn.PgError
+		sqliteErr sqlite3.Error
+	)
+	switch {
+	case errors.As(err, &mysqlErr):
+		return classifyMysqlError(mysqlErr), "mysql"
+	case errors.As(err, &pgErr):
+		return classifyPostgresError(pgErr), "postgres"
+	case errors.As(err, &sqliteErr):
+		return classifySQLiteError(sqliteErr), "sqlite"
+	}
+
+	switch {
+	case errors.Is(err, mysql.ErrInvalidConn):
+		return ErrorKindConnection, "mysql"
+	case pgconn.Timeout(err):
+		return ErrorKindTimeout, "postgres"
+	case pgconn.SafeToRetry(err):
+		// the request is failed before sending to the server, such as the connect error
+		return ErrorKindConnection, "postgres"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorKindTimeout, "unknown"
+	}
+	if os.IsTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorKindTimeout, "unknown"
+	}
+	if netErr != nil || errors.Is(err, driver.ErrBadConn) {
+		return ErrorKindConnection, "unknown"
+	}
+	for _, re := range recoverableErrors {
+		if errors.Is(err, re) {
+			return ErrorKindConnection, "unknown"
+		}
+	}
+	return ErrorKindUnknown, "unknown"
+}
+
+func classifyMysqlError(err *mysql.MySQLError) ErrorKind {
+	switch err.Number {
+	case 1062: // duplicate entry
+		return ErrorKindDuplicateKey
+	case 1048, 1451, 1452: // column cannot be null, foreign key constraint fails
+		return ErrorKindConstraintViolation
+	case 1213: // deadlock found when trying to get lock
+		return ErrorKindSerialization
+	case 1118, 1153, 1406: // row size too large, packet bigger than max_allowed_packet, data too long for column
+		return ErrorKindTooLarge
+	case 1054, 1146: // unknown column, table doesn't exist
+		return ErrorKindSchemaOutdated
+	case 1205, 3024: // lock wait timeout exceeded, maximum statement execution time exceeded
+		return ErrorKindTimeout
+	case 1040, 1053, 2006, 2013: // too many connections, server shutdown, server has gone away, lost connection
+		return ErrorKindConnection
+	}
+	return ErrorKindUnknown
+}
+
+func classifyPostgresError(err *pgconn.PgError) ErrorKind {
+	switch {
+	case err.Code == pgerrcode.UniqueViolation:
+		return ErrorKindDuplicateKey
+	case pgerrcode.IsIntegrityConstraintViolation(err.Code):
+		return ErrorKindConstraintViolation
+	case err.Code == pgerrcode.SerializationFailure, err.Code == pgerrcode.DeadlockDetected:
+		return ErrorKindSerialization
+	case err.Code == pgerrcode.QueryCanceled, err.Code == pgerrcode.LockNotAvailable:
+		return ErrorKindTimeout
+	case pgerrcode.IsProgramLimitExceeded(err.Code), err.Code == pgerrcode.StringDataRightTruncationDataException:
+		return ErrorKindTooLarge
+	case err.Code == pgerrcode.UndefinedColumn, err.Code == pgerrcode.UndefinedTable:
+		return ErrorKindSchemaOutdated
+	case pgerrcode.IsConnectionException(err.Code), err.Code == pgerrcode.AdminShutdown,
+		err.Code == pgerrcode.TooManyConnections, err.Code == pgerrcode.CannotConnectNow:
+		return ErrorKindConnection
+	}
+	return ErrorKindUnknown
+}
+
+func classifySQLiteError(err sqlite3.Error) ErrorKind {
+	switch err.Code {
+	case sqlite3.ErrConstraint:
+		if err.ExtendedCode == sqlite3.ErrConstraintUnique || err.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return ErrorKindDuplicateKey
+		}
+		return ErrorKindConstraintViolation
+	case sqlite3.ErrBusy, sqlite3.ErrLocked:
+		return ErrorKindSerialization
+	case sqlite3.ErrCantOpen:
+		return ErrorKindConnection
+	case sqlite3.ErrTooBig:
+		return ErrorKindTooLarge
+	case sqlite3.ErrError:
+		// the sqlite reports the missing table and column by the generic error code
+		if message := err.Error(); strings.HasPrefix(message, "no such table") || strings.HasPrefix(message, "no such column") ||
+			strings.Contains(message, "has no column named") {
+			return ErrorKindSchemaOutdated
+		}
+	}
+	return ErrorKindUnknown
 }
 
 func InterpretMysqlError(key string, err error) error {
@@ -101,7 +348,7 @@ func InterpretMysqlError(key string, err error) error {
 
 	switch mysqlErr.Number {
 	case 1062:
-		return genericstorage.NewKeyExistsError(key, 0)
+		return storage.NewError(ErrConflict, genericstorage.NewKeyExistsError(key, 0))
 	case 1040:
 		// klog.Error("too many connections")
 	}
@@ -125,7 +372,7 @@ func InterpretPostgresError(key string, err error) error {
 
 	switch pgError.Code {
 	case pgerrcode.UniqueViolation:
-		return genericstorage.NewKeyExistsError(key, 0)
+		return storage.NewError(ErrConflict, genericstorage.NewKeyExistsError(key, 0))
 	}
 	return err
 }
@@ -0,0 +1,138 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	genericstorage "k8s.io/apiserver/pkg/storage"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestInterpretDBErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		kind        ErrorKind
+		dialect     string
+		recoverable bool
+		exist       bool
+		cause       error
+	}{
+		{"mysql duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, ErrorKindDuplicateKey, "mysql", false, true, ErrConflict},
+		{"mysql column cannot be null", &mysql.MySQLError{Number: 1048, Message: "Column 'name' cannot be null"}, ErrorKindConstraintViolation, "mysql", false, false, nil},
+		{"mysql foreign key fails", &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, ErrorKindConstraintViolation, "mysql", false, false, nil},
+		{"mysql deadlock", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, ErrorKindSerialization, "mysql", false, false, ErrTransient},
+		{"mysql lock wait timeout", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, ErrorKindTimeout, "mysql", true, false, ErrTransient},
+		{"mysql server gone away", &mysql.MySQLError{Number: 2006, Message: "MySQL server has gone away"}, ErrorKindConnection, "mysql", false, false, ErrTransient},
+		{"mysql invalid connection", mysql.ErrInvalidConn, ErrorKindConnection, "mysql", false, false, ErrTransient},
+		{"mysql data too long", &mysql.MySQLError{Number: 1406, Message: "Data too long for column"}, ErrorKindTooLarge, "mysql", false, false, ErrTooLarge},
+		{"mysql unknown column", &mysql.MySQLError{Number: 1054, Message: "Unknown column"}, ErrorKindSchemaOutdated, "mysql", false, false, ErrSchemaOutdated},
+		{"postgres unique violation", &pgconn.PgError{Code: pgerrcode.UniqueViolation}, ErrorKindDuplicateKey, "postgres", false, true, ErrConflict},
+		{"postgres not null violation", &pgconn.PgError{Code: pgerrcode.NotNullViolation}, ErrorKindConstraintViolation, "postgres", false, false, nil},
+		{"postgres check violation", &pgconn.PgError{Code: pgerrcode.CheckViolation}, ErrorKindConstraintViolation, "postgres", false, false, nil},
+		{"postgres serialization failure", &pgconn.PgError{Code: pgerrcode.SerializationFailure}, ErrorKindSerialization, "postgres", false, false, ErrTransient},
+		{"postgres query canceled", &pgconn.PgError{Code: pgerrcode.QueryCanceled}, ErrorKindTimeout, "postgres", false, false, ErrTransient},
+		{"postgres admin shutdown", &pgconn.PgError{Code: pgerrcode.AdminShutdown}, ErrorKindConnection, "postgres", true, false, ErrTransient},
+		{"postgres program limit exceeded", &pgconn.PgError{Code: pgerrcode.ProgramLimitExceeded}, ErrorKindTooLarge, "postgres", false, false, ErrTooLarge},
+		{"postgres undefined table", &pgconn.PgError{Code: pgerrcode.UndefinedTable}, ErrorKindSchemaOutdated, "postgres", false, false, ErrSchemaOutdated},
+		{"sqlite unique constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintUnique}, ErrorKindDuplicateKey, "sqlite", false, false, ErrConflict},
+		{"sqlite primary key constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintPrimaryKey}, ErrorKindDuplicateKey, "sqlite", false, false, ErrConflict},
+		{"sqlite not null constraint", sqlite3.Error{Code: sqlite3.ErrConstraint, ExtendedCode: sqlite3.ErrConstraintNotNull}, ErrorKindConstraintViolation, "sqlite", false, false, nil},
+		{"sqlite busy", sqlite3.Error{Code: sqlite3.ErrBusy}, ErrorKindSerialization, "sqlite", false, false, ErrTransient},
+		{"sqlite too big", sqlite3.Error{Code: sqlite3.ErrTooBig}, ErrorKindTooLarge, "sqlite", false, false, ErrTooLarge},
+		{"bad connection", driver.ErrBadConn, ErrorKindConnection, "unknown", true, false, ErrTransient},
+		{"deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), ErrorKindTimeout, "unknown", true, false, ErrTransient},
+		{"unknown error", errors.New("something wrong"), ErrorKindUnknown, "unknown", false, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := dbErrorsTotal.WithLabelValues(string(tt.kind), tt.dialect)
+			before, err := testutil.GetCounterMetricValue(counter)
+			require.NoError(t, err)
+
+			err = InterpretDBError("default/foo", tt.err)
+			require.Error(t, err)
+
+			after, gerr := testutil.GetCounterMetricValue(counter)
+			require.NoError(t, gerr)
+			assert.Equal(t, before+1, after)
+
+			assert.Equal(t, tt.kind, ErrorKindOf(err))
+			assert.Equal(t, tt.recoverable, storage.IsRecoverableException(err))
+			var storageErr *genericstorage.StorageError
+			assert.Equal(t, tt.exist, errors.As(err, &storageErr) && storageErr.Code == genericstorage.ErrCodeKeyExists)
+			for _, sentinel := range []error{ErrNotFound, ErrConflict, ErrTooLarge, ErrTransient, ErrSchemaOutdated} {
+				assert.Equal(t, sentinel == tt.cause, errors.Is(err, sentinel), sentinel)
+			}
+
+			var dbErr *DBError
+			if errors.As(err, &dbErr) {
+				assert.Equal(t, tt.dialect, dbErr.Dialect)
+				assert.True(t, errors.Is(err, tt.err))
+			}
+		})
+	}
+}
+
+func TestInterpretDBErrorDeadlineExceeded(t *testing.T) {
+	err := InterpretDBError("default/foo", context.DeadlineExceeded)
+	assert.True(t, apierrors.IsTimeout(err))
+	assert.True(t, storage.IsRecoverableException(err))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the apiserver converts the error to the status by the type assertion
+	_, ok := err.(apierrors.APIStatus)
+	assert.True(t, ok)
+}
+
+func TestInterpretDBErrorKeepsMessage(t *testing.T) {
+	err := InterpretDBError("default/foo", errors.New("something wrong"))
+	assert.Equal(t, "something wrong", err.Error())
+
+	err = InterpretDBError("default/foo", gorm.ErrRecordNotFound)
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.Empty(t, ErrorKindOf(err))
+
+	// the apiserver recognizes the storage error wrapped by the sentinel
+	var storageErr *genericstorage.StorageError
+	require.ErrorAs(t, err, &storageErr)
+	assert.True(t, genericstorage.IsNotFound(storageErr))
+}
+
+func TestInterpretDBErrorSchemaOutdated(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for _, query := range []string{"SELECT missing FROM resources", "SELECT * FROM missing"} {
+		err := InterpretDBError("default/foo", db.Exec(query).Error)
+		assert.True(t, errors.Is(err, ErrSchemaOutdated), err)
+		assert.Equal(t, ErrorKindSchemaOutdated, ErrorKindOf(err))
+	}
+}
+
+func TestInterpretDBErrorNotNullViolation(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	// the not null violation isn't a conflict, so the write isn't retried as the update of the existing object
+	err = InterpretDBError("default/foo", db.Exec("INSERT INTO resources (`group`) VALUES (NULL)").Error)
+	assert.Equal(t, ErrorKindConstraintViolation, ErrorKindOf(err))
+	assert.False(t, errors.Is(err, ErrConflict), err)
+	assert.False(t, errors.Is(err, ErrTransient), err)
+}
@@ -0,0 +1,452 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultEventStreamTopic        = "clusterpedia.mutations"
+	defaultEventStreamOutboxSize   = 10000
+	defaultEventStreamBatchSize    = 100
+	defaultEventStreamReplayMargin = time.Minute
+	defaultEventStreamRetryBackoff = time.Second
+	maxEventStreamRetryBackoff     = 30 * time.Second
+)
+
+// EventOperation is the operation of the event, the replayed events are the current objects
+// which may be missed by the message bus, e.g. the events dropped while the outbox is full.
+type EventOperation string
+
+const (
+	EventOperationCreate EventOperation = "create"
+	EventOperationUpdate EventOperation = "update"
+	EventOperationDelete EventOperation = "delete"
+	EventOperationReplay EventOperation = "replay"
+)
+
+var (
+	eventStreamMessagesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "event_stream_messages_total",
+			Help:           "Number of the messages of the event stream by the result, one of produced, replayed and dropped.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+
+	eventStreamProduceErrorsTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "event_stream_produce_errors_total",
+			Help:           "Number of the failed produces of the event stream, the failed produces are retried.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(eventStreamMessagesTotal, eventStreamProduceErrorsTotal)
+
+	registerMigration(migration{
+		version:  10,
+		name:     "create the event stream high-water marks table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &EventStreamHighWaterMark{})
+		},
+	})
+}
+
+// EventStreamConfig publishes the mutations persisted by the storage to the message bus for the downstream processing.
+//
+// The events are queued by the in-memory outbox and published at least once, the writes of the events acknowledged
+// by the message bus are recorded by the high-water mark of each database. The stream never blocks the synchro,
+// the events dropped while the outbox is full, e.g. the message bus is down, and the events not acknowledged before
+// the restart are replayed from the resources table as the current objects, except the deletions.
+type EventStreamConfig struct {
+	// Kafka publishes the events to kafka by the kafka rest proxy, the event stream is disabled if it isn't configured.
+	Kafka KafkaConfig `yaml:"kafka"`
+
+	// Topic is the topic of the events, or the prefix of the topics if the TopicPerResource is set,
+	// Default is clusterpedia.mutations.
+	Topic string `yaml:"topic"`
+
+	// TopicPerResource publishes the events of each resource to the topic `<topic>.<group>.<version>.<resource>`,
+	// the group of the core resources is `core`. The resource of the events is also set to the headers of the messages.
+	TopicPerResource bool `yaml:"topicPerResource"`
+
+	// OutboxSize is the size of the outbox of the events to be published, Default is 10000.
+	OutboxSize int `yaml:"outboxSize"`
+
+	// BatchSize is the max number of the events published by each produce, Default is 100.
+	BatchSize int `yaml:"batchSize"`
+
+	// ReplayMargin is subtracted from the high-water mark when the events are replayed, since the concurrent writes
+	// may be acknowledged out of order. It should be longer than the write timeout, Default is 1m.
+	ReplayMargin time.Duration `yaml:"replayMargin"`
+}
+
+// EventStreamHighWaterMark is the time before which the writes of the events of the topic are acknowledged
+// by the message bus, it is compared with the synced_at of the resources when the events are replayed.
+type EventStreamHighWaterMark struct {
+	Topic    string    `gorm:"size:249;primaryKey"`
+	SyncedAt time.Time `gorm:"not null"`
+}
+
+// EventMessage is the message of the event published to the message bus.
+type EventMessage struct {
+	Topic string
+
+	// Key is `<cluster>/<namespace>/<name>` of the object, so the events of an object are published in order.
+	Key string
+
+	// Headers are the cluster, group, version, resource and operation of the event.
+	Headers map[string]string
+
+	// Value is the json of the operation and the object, the object of the deletion is empty.
+	Value []byte
+}
+
+type eventPayload struct {
+	Operation EventOperation  `json:"operation"`
+	Object    json.RawMessage `json:"object,omitempty"`
+}
+
+// EventProducer publishes the messages to the message bus, the messages are acknowledged if it returns nil.
+type EventProducer interface {
+	Produce(ctx context.Context, messages []*EventMessage) error
+}
+
+// streamedEvent is the queued event, the time is taken before the write, so it isn't later than the synced_at of the written row.
+type streamedEvent struct {
+	db      *gorm.DB
+	time    time.Time
+	message *EventMessage
+}
+
+// eventStream publishes the queued events by a goroutine.
+type eventStream struct {
+	producer         EventProducer
+	topic            string
+	topicPerResource bool
+	outbox           chan *streamedEvent
+	batchSize        int
+	replayMargin     time.Duration
+	retryBackoff     time.Duration
+
+	// splitColumnsMissing are the databases without the spec and status columns.
+	splitColumnsMissing map[*gorm.DB]bool
+
+	// highWaterMarks are the times of the acknowledged events of the databases, they are only accessed by the goroutine.
+	highWaterMarks map[*gorm.DB]time.Time
+
+	mu sync.Mutex
+	// dropped are the times of the earliest dropped events of the databases which are waiting for the replays.
+	dropped map[*gorm.DB]time.Time
+
+	closeOnce sync.Once
+	stopCh    chan struct{}
+	done      chan struct{}
+}
+
+// newEventStream returns nil if the event stream is disabled.
+func newEventStream(config EventStreamConfig) (*eventStream, error) {
+	producer, err := newKafkaProducer(config.Kafka)
+	if err != nil || producer == nil {
+		return nil, err
+	}
+	return newEventStreamWithProducer(config, producer), nil
+}
+
+func newEventStreamWithProducer(config EventStreamConfig, producer EventProducer) *eventStream {
+	topic := config.Topic
+	if topic == "" {
+		topic = defaultEventStreamTopic
+	}
+	outboxSize := config.OutboxSize
+	if outboxSize <= 0 {
+		outboxSize = defaultEventStreamOutboxSize
+	}
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultEventStreamBatchSize
+	}
+	replayMargin := config.ReplayMargin
+	if replayMargin <= 0 {
+		replayMargin = defaultEventStreamReplayMargin
+	}
+	return &eventStream{
+		producer:         producer,
+		topic:            topic,
+		topicPerResource: config.TopicPerResource,
+		outbox:           make(chan *streamedEvent, outboxSize),
+		batchSize:        batchSize,
+		replayMargin:     replayMargin,
+		retryBackoff:     defaultEventStreamRetryBackoff,
+		highWaterMarks:   make(map[*gorm.DB]time.Time),
+		dropped:          make(map[*gorm.DB]time.Time),
+		stopCh:           make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+}
+
+// start publishes the events of the databases, the events not acknowledged before the last stop are replayed at first.
+func (s *eventStream) start(databases []*gorm.DB, splitColumnsMissing map[*gorm.DB]bool) error {
+	if s == nil {
+		return nil
+	}
+	s.splitColumnsMissing = splitColumnsMissing
+
+	now := time.Now()
+	for _, db := range databases {
+		var marks []EventStreamHighWaterMark
+		if err := db.Where("topic = ?", s.topic).Limit(1).Find(&marks).Error; err != nil {
+			return fmt.Errorf("event stream: failed to load the high-water mark: %w", err)
+		}
+		if len(marks) == 0 {
+			// the events before the first start aren't published
+			s.highWaterMarks[db] = now
+			if err := s.saveHighWaterMark(db); err != nil {
+				return fmt.Errorf("event stream: failed to save the high-water mark: %w", err)
+			}
+			continue
+		}
+		s.highWaterMarks[db] = marks[0].SyncedAt
+		s.dropped[db] = marks[0].SyncedAt
+	}
+	go s.run()
+	return nil
+}
+
+func (s *eventStream) message(operation EventOperation, cluster string, gvr schema.GroupVersionResource, namespace, name string, object []byte) (*EventMessage, error) {
+	value, err := json.Marshal(eventPayload{Operation: operation, Object: object})
+	if err != nil {
+		return nil, err
+	}
+
+	topic := s.topic
+	if s.topicPerResource {
+		group := gvr.Group
+		if group == "" {
+			group = "core"
+		}
+		topic = strings.Join([]string{s.topic, group, gvr.Version, gvr.Resource}, ".")
+	}
+	return &EventMessage{
+		Topic: topic,
+		Key:   cluster + "/" + namespace + "/" + name,
+		Headers: map[string]string{
+			"cluster":   cluster,
+			"group":     gvr.Group,
+			"version":   gvr.Version,
+			"resource":  gvr.Resource,
+			"operation": string(operation),
+		},
+		Value: value,
+	}, nil
+}
+
+// publish queues the event of the write started at the time, the event is dropped if the outbox is full,
+// and the writes of the database since the time are replayed later.
+func (s *eventStream) publish(db *gorm.DB, at time.Time, message *EventMessage) {
+	select {
+	case s.outbox <- &streamedEvent{db: db, time: at, message: message}:
+	default:
+		eventStreamMessagesTotal.WithLabelValues("dropped").Inc()
+		s.drop(db, at)
+	}
+}
+
+func (s *eventStream) drop(db *gorm.DB, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if dropped, ok := s.dropped[db]; !ok || at.Before(dropped) {
+		s.dropped[db] = at
+	}
+}
+
+// run publishes the queued events in batches, and replays the dropped events when the outbox is drained.
+func (s *eventStream) run() {
+	defer close(s.done)
+
+	batch := make([]*streamedEvent, 0, s.batchSize)
+	messages := make([]*EventMessage, 0, s.batchSize)
+	for {
+		s.replay()
+
+		var event *streamedEvent
+		select {
+		case <-s.stopCh:
+			return
+		case event = <-s.outbox:
+		}
+
+		batch = append(batch[:0], event)
+		for len(batch) < s.batchSize && len(s.outbox) > 0 {
+			batch = append(batch, <-s.outbox)
+		}
+		messages = messages[:0]
+		for _, event := range batch {
+			messages = append(messages, event.message)
+		}
+		if err := s.produce(messages); err != nil {
+			// the events are replayed after the restart, since the high-water marks aren't advanced
+			return
+		}
+		eventStreamMessagesTotal.WithLabelValues("produced").Add(float64(len(messages)))
+
+		advanced := make(map[*gorm.DB]bool)
+		for _, event := range batch {
+			if event.time.After(s.highWaterMarks[event.db]) {
+				s.highWaterMarks[event.db] = event.time
+				advanced[event.db] = true
+			}
+		}
+		for db := range advanced {
+			if err := s.saveHighWaterMark(db); err != nil {
+				klog.ErrorS(err, "Failed to save the high-water mark of the event stream", "topic", s.topic)
+			}
+		}
+	}
+}
+
+// produce produces the messages until they are acknowledged or the stream is stopped.
+func (s *eventStream) produce(messages []*EventMessage) error {
+	backoff := s.retryBackoff
+	for {
+		err := s.producer.Produce(context.Background(), messages)
+		if err == nil {
+			return nil
+		}
+		eventStreamProduceErrorsTotal.Inc()
+		klog.ErrorS(err, "Failed to produce the events, retrying", "topic", s.topic, "count", len(messages), "backoff", backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.stopCh:
+			timer.Stop()
+			return errors.New("the event stream is stopped")
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxEventStreamRetryBackoff {
+			backoff = maxEventStreamRetryBackoff
+		}
+	}
+}
+
+// replay publishes the current objects of the databases written since the dropped events as the replayed events.
+func (s *eventStream) replay() {
+	s.mu.Lock()
+	dropped := s.dropped
+	s.dropped = make(map[*gorm.DB]time.Time)
+	s.mu.Unlock()
+
+	for db, since := range dropped {
+		if err := s.replayDatabase(db, since.Add(-s.replayMargin)); err != nil {
+			klog.ErrorS(err, "Failed to replay the events", "topic", s.topic, "since", since)
+			s.drop(db, since)
+			return
+		}
+	}
+}
+
+func (s *eventStream) replayDatabase(db *gorm.DB, since time.Time) error {
+	var lastSyncedAt time.Time
+	var lastID uint
+	for {
+		columns := []string{"id", "cluster", "group", "version", "resource", "namespace", "name", "object", "synced_at"}
+		if !s.splitColumnsMissing[db] {
+			columns = append(columns, "spec", "status")
+		}
+		query := db.Model(&Resource{}).Select(columns)
+		if lastID == 0 {
+			query = query.Where("synced_at >= ?", since)
+		} else {
+			query = query.Where("synced_at > ? OR (synced_at = ? AND id > ?)", lastSyncedAt, lastSyncedAt, lastID)
+		}
+		var resources []Resource
+		if err := query.Order("synced_at, id").Limit(s.batchSize).Find(&resources).Error; err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			return nil
+		}
+
+		messages := make([]*EventMessage, 0, len(resources))
+		for _, resource := range resources {
+			message, err := s.message(EventOperationReplay, resource.Cluster, resource.GroupVersionResource(),
+				resource.Namespace, resource.Name, assembleObject(resource.Object, resource.Spec, resource.Status))
+			if err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		if err := s.produce(messages); err != nil {
+			return err
+		}
+		eventStreamMessagesTotal.WithLabelValues("replayed").Add(float64(len(messages)))
+
+		last := resources[len(resources)-1]
+		lastSyncedAt, lastID = last.SyncedAt, last.ID
+	}
+}
+
+// saveHighWaterMark persists the high-water mark of the database, which doesn't pass the dropped events waiting for the replay.
+func (s *eventStream) saveHighWaterMark(db *gorm.DB) error {
+	mark := s.highWaterMarks[db]
+	s.mu.Lock()
+	if dropped, ok := s.dropped[db]; ok && dropped.Before(mark) {
+		mark = dropped
+	}
+	s.mu.Unlock()
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "topic"}},
+		DoUpdates: clause.AssignmentColumns([]string{"synced_at"}),
+	}).Create(&EventStreamHighWaterMark{Topic: s.topic, SyncedAt: mark}).Error
+}
+
+// close stops the stream, the queued events are replayed after the restart.
+func (s *eventStream) close() {
+	if s == nil {
+		return
+	}
+	s.closeOnce.Do(func() { close(s.stopCh) })
+	<-s.done
+}
+
+// streamEvent queues the event of the persisted write started at the time, the objects of the encrypted resources
+// are published as they are stored, so they are kept encrypted.
+func (s *ResourceStorage) streamEvent(operation EventOperation, at time.Time, cluster string, metaobj metav1.Object, encoded, stored []byte) {
+	if s.eventStream == nil {
+		return
+	}
+
+	object := encoded
+	if s.encrypt {
+		object = stored
+	}
+	message, err := s.eventStream.message(operation, cluster, s.storageGVR(), metaobj.GetNamespace(), metaobj.GetName(), object)
+	if err != nil {
+		klog.ErrorS(err, "Failed to encode the event", "cluster", cluster, "namespace", metaobj.GetNamespace(), "name", metaobj.GetName())
+		return
+	}
+	s.eventStream.publish(s.db, at, message)
+}
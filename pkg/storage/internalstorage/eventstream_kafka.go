@@ -0,0 +1,134 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultKafkaTimeout = 10 * time.Second
+
+// KafkaConfig publishes the events by the records api v3 of the kafka rest proxy, e.g. the confluent rest proxy,
+// so the storage doesn't depend on the kafka client.
+type KafkaConfig struct {
+	// RESTProxyURL is the url of the kafka rest proxy, e.g. http://kafka-rest-proxy:8082, the kafka is disabled if it is empty.
+	RESTProxyURL string `yaml:"restProxyURL"`
+
+	// ClusterID is the id of the kafka cluster of the rest proxy.
+	ClusterID string `yaml:"clusterID"`
+
+	// Timeout is the timeout of each produce request, Default is 10s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type kafkaProducer struct {
+	endpoint string
+	client   *http.Client
+}
+
+// newKafkaProducer returns nil if the kafka isn't configured.
+func newKafkaProducer(config KafkaConfig) (*kafkaProducer, error) {
+	if config.RESTProxyURL == "" {
+		return nil, nil
+	}
+	if config.ClusterID == "" {
+		return nil, errors.New("event stream: kafka cluster id is required")
+	}
+	if _, err := url.Parse(config.RESTProxyURL); err != nil {
+		return nil, fmt.Errorf("event stream: invalid kafka rest proxy url: %w", err)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultKafkaTimeout
+	}
+	return &kafkaProducer{
+		endpoint: strings.TrimSuffix(config.RESTProxyURL, "/") + "/v3/clusters/" + url.PathEscape(config.ClusterID) + "/topics/",
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+type kafkaRecordData struct {
+	Type string `json:"type"`
+	Data []byte `json:"data"`
+}
+
+type kafkaRecordHeader struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
+type kafkaRecord struct {
+	Key     kafkaRecordData     `json:"key"`
+	Value   kafkaRecordData     `json:"value"`
+	Headers []kafkaRecordHeader `json:"headers,omitempty"`
+}
+
+type kafkaProduceResponse struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// Produce produces the messages one by one in order, the messages before the failed one may be acknowledged,
+// and they are produced again by the retry.
+func (p *kafkaProducer) Produce(ctx context.Context, messages []*EventMessage) error {
+	for _, message := range messages {
+		if err := p.produce(ctx, message); err != nil {
+			return fmt.Errorf("failed to produce to the topic %s: %w", message.Topic, err)
+		}
+	}
+	return nil
+}
+
+func (p *kafkaProducer) produce(ctx context.Context, message *EventMessage) error {
+	record := kafkaRecord{
+		Key:   kafkaRecordData{Type: "BINARY", Data: []byte(message.Key)},
+		Value: kafkaRecordData{Type: "BINARY", Data: message.Value},
+	}
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		record.Headers = append(record.Headers, kafkaRecordHeader{Name: name, Value: []byte(message.Headers[name])})
+	}
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+url.PathEscape(message.Topic)+"/records", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var produced kafkaProduceResponse
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &produced); err != nil && resp.StatusCode == http.StatusOK {
+			return fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK || (produced.ErrorCode != 0 && produced.ErrorCode != http.StatusOK) {
+		return fmt.Errorf("the kafka rest proxy responded %s: %s", resp.Status, produced.Message)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+// fakeBroker is the in-memory message bus, the produces fail while the failures are remaining.
+type fakeBroker struct {
+	mu       sync.Mutex
+	messages []*EventMessage
+	failures int
+}
+
+func (b *fakeBroker) Produce(_ context.Context, messages []*EventMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures > 0 {
+		b.failures--
+		return errors.New("broker is unavailable")
+	}
+	b.messages = append(b.messages, messages...)
+	return nil
+}
+
+func (b *fakeBroker) received() []*EventMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*EventMessage(nil), b.messages...)
+}
+
+func (b *fakeBroker) waitFor(t *testing.T, count int) []*EventMessage {
+	require.Eventually(t, func() bool { return len(b.received()) >= count }, 5*time.Second, 5*time.Millisecond)
+	return b.received()
+}
+
+func newEventStreamTestStorage(t *testing.T, config EventStreamConfig, broker *fakeBroker) (*gorm.DB, *ResourceStorage, *eventStream) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	require.NoError(t, db.AutoMigrate(&EventStreamHighWaterMark{}))
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	resourceConfig, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = resourceConfig.Codec
+
+	stream := newEventStreamWithProducer(config, broker)
+	stream.retryBackoff = time.Millisecond
+	rs.eventStream = stream
+	t.Cleanup(stream.close)
+	return db, rs, stream
+}
+
+func newEventStreamTestDeployment(name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+	}
+}
+
+func decodeEventPayload(t *testing.T, message *EventMessage) (EventOperation, map[string]interface{}) {
+	var payload struct {
+		Operation EventOperation         `json:"operation"`
+		Object    map[string]interface{} `json:"object"`
+	}
+	require.NoError(t, json.Unmarshal(message.Value, &payload))
+	return payload.Operation, payload.Object
+}
+
+func TestEventStream_Publish(t *testing.T) {
+	broker := &fakeBroker{}
+	db, rs, stream := newEventStreamTestStorage(t, EventStreamConfig{TopicPerResource: true}, broker)
+	require.NoError(t, stream.start([]*gorm.DB{db}, nil))
+	started := time.Now()
+
+	deploy := newEventStreamTestDeployment("deploy-1")
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	deletedObj, err := rs.ConvertDeletedObject(deploy)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+
+	messages := broker.waitFor(t, 3)
+	require.Len(t, messages, 3)
+	for i, expected := range []struct {
+		operation       EventOperation
+		resourceVersion string
+	}{
+		{EventOperationCreate, "1"},
+		{EventOperationUpdate, "2"},
+		{EventOperationDelete, ""},
+	} {
+		message := messages[i]
+		assert.Equal(t, "clusterpedia.mutations.apps.v1.deployments", message.Topic)
+		assert.Equal(t, "cluster-1/default/deploy-1", message.Key)
+		assert.Equal(t, map[string]string{
+			"cluster": "cluster-1", "group": "apps", "version": "v1", "resource": "deployments", "operation": string(expected.operation),
+		}, message.Headers)
+
+		operation, object := decodeEventPayload(t, message)
+		assert.Equal(t, expected.operation, operation)
+		if expected.resourceVersion == "" {
+			assert.Nil(t, object)
+		} else {
+			assert.Equal(t, expected.resourceVersion, object["metadata"].(map[string]interface{})["resourceVersion"])
+		}
+	}
+
+	// the high-water mark is advanced by the acknowledged events
+	stream.close()
+	var mark EventStreamHighWaterMark
+	require.NoError(t, db.First(&mark, "topic = ?", defaultEventStreamTopic).Error)
+	assert.False(t, mark.SyncedAt.Before(started))
+}
+
+func TestEventStream_ReplayDropped(t *testing.T) {
+	broker := &fakeBroker{}
+	db, rs, stream := newEventStreamTestStorage(t, EventStreamConfig{OutboxSize: 1}, broker)
+
+	// the stream isn't running, so the events after the first one are dropped
+	before, err := testutil.GetCounterMetricValue(eventStreamMessagesTotal.WithLabelValues("dropped"))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newEventStreamTestDeployment(fmt.Sprintf("deploy-%d", i))))
+	}
+	after, err := testutil.GetCounterMetricValue(eventStreamMessagesTotal.WithLabelValues("dropped"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), after-before)
+
+	// the writes since the dropped events are replayed, and the broker is unavailable at first
+	broker.failures = 2
+	require.NoError(t, stream.start([]*gorm.DB{db}, nil))
+	messages := broker.waitFor(t, 3)
+
+	var replayed []string
+	for _, message := range messages {
+		if operation, _ := decodeEventPayload(t, message); operation == EventOperationReplay {
+			replayed = append(replayed, message.Key)
+		}
+	}
+	assert.Equal(t, []string{"cluster-1/default/deploy-1", "cluster-1/default/deploy-2"}, replayed[len(replayed)-2:])
+}
+
+func TestEventStream_ReplayAfterRestart(t *testing.T) {
+	broker := &fakeBroker{}
+	db, rs, stream := newEventStreamTestStorage(t, EventStreamConfig{ReplayMargin: time.Millisecond}, broker)
+
+	// the writes before the high-water mark were acknowledged before the restart
+	rs.eventStream = nil
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newEventStreamTestDeployment("acknowledged")))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, db.Create(&EventStreamHighWaterMark{Topic: defaultEventStreamTopic, SyncedAt: time.Now()}).Error)
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newEventStreamTestDeployment("unacknowledged")))
+
+	require.NoError(t, stream.start([]*gorm.DB{db}, nil))
+	messages := broker.waitFor(t, 1)
+	require.Len(t, messages, 1)
+	operation, object := decodeEventPayload(t, messages[0])
+	assert.Equal(t, EventOperationReplay, operation)
+	assert.Equal(t, "cluster-1/default/unacknowledged", messages[0].Key)
+	assert.Equal(t, "unacknowledged", object["metadata"].(map[string]interface{})["name"])
+}
+
+func TestKafkaProducer(t *testing.T) {
+	var requests []*http.Request
+	var records []kafkaRecord
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		var record kafkaRecord
+		require.NoError(t, json.Unmarshal(body, &record))
+		requests, records = append(requests, req), append(records, record)
+
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprint(w, `{"error_code":200,"cluster_id":"cluster-id","topic_name":"topic","partition_id":0,"offset":1}`)
+		} else {
+			fmt.Fprint(w, `{"error_code":40403,"message":"This server does not host this topic-partition."}`)
+		}
+	}))
+	defer server.Close()
+
+	producer, err := newKafkaProducer(KafkaConfig{RESTProxyURL: server.URL + "/", ClusterID: "cluster-id"})
+	require.NoError(t, err)
+	message := &EventMessage{
+		Topic: "clusterpedia.mutations", Key: "cluster-1/default/deploy-1",
+		Headers: map[string]string{"operation": "create", "cluster": "cluster-1"},
+		Value:   []byte(`{"operation":"create"}`),
+	}
+	require.NoError(t, producer.Produce(context.Background(), []*EventMessage{message, message}))
+	require.Len(t, records, 2)
+	assert.Equal(t, "/v3/clusters/cluster-id/topics/clusterpedia.mutations/records", requests[0].URL.Path)
+	assert.Equal(t, kafkaRecord{
+		Key:   kafkaRecordData{Type: "BINARY", Data: []byte("cluster-1/default/deploy-1")},
+		Value: kafkaRecordData{Type: "BINARY", Data: []byte(`{"operation":"create"}`)},
+		Headers: []kafkaRecordHeader{
+			{Name: "cluster", Value: []byte("cluster-1")},
+			{Name: "operation", Value: []byte("create")},
+		},
+	}, records[0])
+
+	status = http.StatusNotFound
+	err = producer.Produce(context.Background(), []*EventMessage{message})
+	assert.ErrorContains(t, err, "This server does not host this topic-partition.")
+
+	producer, err = newKafkaProducer(KafkaConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, producer)
+	_, err = newKafkaProducer(KafkaConfig{RESTProxyURL: server.URL})
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/apiserver/pkg/warning"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// URLQueryExplain is the url query to explain the list query instead of executing it,
+// it only works when the AllowListQueryExplain feature gate is enabled.
+const URLQueryExplain = "explain"
+
+// ListExplanation is the explanation of the list query, the query is generated but not executed.
+type ListExplanation struct {
+	// SQL is the generated SQL of the list query with the interpolated parameters.
+	SQL string
+
+	// Plan is the rows of the EXPLAIN plan, it is only supported by mysql and postgres.
+	Plan []string
+}
+
+func explainRequested(opts *internal.ListOptions) bool {
+	if !utilfeature.DefaultMutableFeatureGate.Enabled(AllowListQueryExplain) {
+		return false
+	}
+
+	explain, _ := strconv.ParseBool(opts.URLQuery.Get(URLQueryExplain))
+	return explain
+}
+
+// ExplainList generates the SQL of the list query by the DryRun session of gorm,
+// and returns it with the EXPLAIN plan of the database.
+//
+// The remaining item count is not explained, it is a separate query.
+func (s *ResourceStorage) ExplainList(ctx context.Context, opts *internal.ListOptions) (*ListExplanation, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	stmt, err := s.dryRunListStatement(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	explanation := &ListExplanation{SQL: s.db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...)}
+	switch s.db.Dialector.Name() {
+	case "mysql", "postgres":
+		plan, err := explainQuery(ctx, s.db, stmt.SQL.String(), stmt.Vars)
+		if err != nil {
+			return nil, InterpretDBError(s.storageGroupResource.String(), err)
+		}
+		explanation.Plan = plan
+	}
+	return explanation, nil
+}
+
+// ListSQL returns the SQL of the list query with the interpolated parameters, the query is generated
+// by the DryRun session of gorm and isn't executed, so it doesn't require the connection of the database.
+func (s *ResourceStorage) ListSQL(ctx context.Context, opts *internal.ListOptions) (string, error) {
+	stmt, err := s.dryRunListStatement(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	return s.db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...), nil
+}
+
+// dryRunListStatement generates the statement of the list query without the remaining item count.
+func (s *ResourceStorage) dryRunListStatement(ctx context.Context, opts *internal.ListOptions) (*gorm.Statement, error) {
+	if err := s.checkScope(opts.Namespaces...); err != nil {
+		return nil, err
+	}
+	if err := s.queryLimit.validateListOptions(opts); err != nil {
+		return nil, err
+	}
+
+	opts = opts.DeepCopy()
+	opts.WithRemainingCount = nil
+
+	_, _, query, result, err := s.genListObjectsQuery(ctx, s.db.Session(&gorm.Session{DryRun: true}), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := result.From(query); err != nil {
+		return nil, InterpretDBError(s.storageGroupResource.String(), err)
+	}
+	return query.Statement, nil
+}
+
+// explainQuery executes the EXPLAIN of the query in a read-only transaction which is always rolled back,
+// so the raw SQL from the url query can't modify the data.
+func explainQuery(ctx context.Context, db *gorm.DB, query string, vars []interface{}) ([]string, error) {
+	tx := db.WithContext(ctx).Begin(&sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Raw("EXPLAIN "+query, vars...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var plan []string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		plan = append(plan, formatPlanRow(columns, values))
+	}
+	return plan, rows.Err()
+}
+
+// formatPlanRow formats the row of the EXPLAIN plan, postgres returns the plan in a single column,
+// and mysql returns the plan in multiple columns.
+func formatPlanRow(columns []string, values []sql.NullString) string {
+	if len(columns) == 1 {
+		return values[0].String
+	}
+
+	fields := make([]string, 0, len(columns))
+	for i, column := range columns {
+		if values[i].Valid {
+			fields = append(fields, fmt.Sprintf("%s=%s", column, values[i].String))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// addWarnings returns the explanation to the client by the warnings of the response.
+func (e *ListExplanation) addWarnings(ctx context.Context) {
+	warning.AddWarning(ctx, "", "SQL: "+e.SQL)
+	for _, row := range e.Plan {
+		warning.AddWarning(ctx, "", "EXPLAIN: "+row)
+	}
+}
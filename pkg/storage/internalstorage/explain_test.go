@@ -0,0 +1,107 @@
+package internalstorage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/labels"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestResourceStorage_ExplainList(t *testing.T) {
+	withRemainingCount := true
+	tests := []struct {
+		name     string
+		opts     *internal.ListOptions
+		postgres string
+	}{
+		{
+			"cluster and namespace",
+			&internal.ListOptions{
+				ClusterNames:       []string{"cluster-1"},
+				Namespaces:         []string{"default"},
+				WithRemainingCount: &withRemainingCount,
+			},
+			`SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND cluster = 'cluster-1' AND namespace = 'default'`,
+		},
+		{
+			"owner",
+			&internal.ListOptions{
+				ClusterNames: []string{"cluster-1"},
+				OwnerUID:     "owner-uid",
+			},
+			`SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND cluster = 'cluster-1' AND owner_uid = 'owner-uid'`,
+		},
+		{
+			"labels and fuzzy name",
+			&internal.ListOptions{
+				ListOptions: metainternal.ListOptions{
+					LabelSelector: labels.SelectorFromSet(labels.Set{"app": "foo"}),
+				},
+				ExtraLabelSelector: labels.SelectorFromSet(labels.Set{SearchLabelFuzzyName: "foo"}),
+				OnlyMetadata:       true,
+			},
+			`SELECT "group", version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->'metadata') as metadata FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND "object" -> 'metadata' -> 'labels' ->> 'app' = 'foo' AND name LIKE '%foo%'`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, mock, err := newMockedPostgresDB()
+			require.NoError(t, err)
+
+			// only the EXPLAIN is executed in the read-only transaction
+			mock.ExpectBegin()
+			mock.ExpectQuery(`^EXPLAIN SELECT`).WillReturnRows(
+				sqlmock.NewRows([]string{"QUERY PLAN"}).
+					AddRow("Seq Scan on resources").
+					AddRow("  Filter: (cluster = 'cluster-1')"),
+			)
+			mock.ExpectRollback()
+
+			rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+			explanation, err := rs.ExplainList(context.Background(), test.opts)
+			require.NoError(t, err)
+			assert.Equal(t, test.postgres, explanation.SQL)
+			assert.Equal(t, []string{"Seq Scan on resources", "  Filter: (cluster = 'cluster-1')"}, explanation.Plan)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResourceStorage_ListExplain(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	opts := &internal.ListOptions{
+		ClusterNames: []string{"cluster-1"},
+		URLQuery:     url.Values{URLQueryExplain: []string{"true"}},
+	}
+	assert.False(t, explainRequested(opts))
+
+	require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set("AllowListQueryExplain=true"))
+	defer func() {
+		require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set("AllowListQueryExplain=false"))
+	}()
+	assert.True(t, explainRequested(opts))
+
+	// the plan is not supported by sqlite
+	explanation, err := rs.ExplainList(context.Background(), opts)
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = \"apps\" AND `resource` = \"deployments\" AND `version` = \"v1\" AND cluster = \"cluster-1\"", explanation.SQL)
+	assert.Empty(t, explanation.Plan)
+
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+	assert.Empty(t, list.Items)
+}
@@ -0,0 +1,656 @@
+package internalstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	defaultExportBatchSize = 500
+	exportManifestFile     = "manifest.json"
+)
+
+var _ storage.ResourceExporter = &StorageFactory{}
+
+// exportRecord is the exported resource, the object is stored as is.
+type exportRecord struct {
+	Cluster  string          `json:"cluster,omitempty"`
+	Group    string          `json:"group,omitempty"`
+	Version  string          `json:"version,omitempty"`
+	Resource string          `json:"resource,omitempty"`
+	Kind     string          `json:"kind,omitempty"`
+	Object   json.RawMessage `json:"object,omitempty"`
+
+	// CreatedAt and SyncedAt are the times of the row kept by the dump of the cluster state,
+	// the created time of the imported resource is its creation timestamp if they are not set.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	SyncedAt  *time.Time `json:"syncedAt,omitempty"`
+
+	// Manifest is only set by the last line of the ndjson export.
+	Manifest *storage.ExportManifest `json:"manifest,omitempty"`
+}
+
+// exportCursor is the position of the export, the resources before the GVR of the database,
+// and the resources of the GVR whose id is no more than the ID have been exported.
+type exportCursor struct {
+	Database string `json:"database"`
+	Group    string `json:"group"`
+	Version  string `json:"version"`
+	Resource string `json:"resource"`
+	ID       uint   `json:"id"`
+
+	// Completed is true if all resources of the GVR have been exported.
+	Completed bool `json:"completed,omitempty"`
+
+	Manifest storage.ExportManifest `json:"manifest"`
+}
+
+// compare compares the GVR of the database with the cursor.
+func (c *exportCursor) compare(database string, gvr schema.GroupVersionResource) int {
+	if database != c.Database {
+		return compareStrings(database, c.Database)
+	}
+	return compareGVR(gvr, schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource})
+}
+
+func compareGVR(a, b schema.GroupVersionResource) int {
+	if a.Group != b.Group {
+		return compareStrings(a.Group, b.Group)
+	}
+	if a.Version != b.Version {
+		return compareStrings(a.Version, b.Version)
+	}
+	return compareStrings(a.Resource, b.Resource)
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func gvrKey(gvr schema.GroupVersionResource) string {
+	return path.Join(gvr.Group, gvr.Version, gvr.Resource)
+}
+
+// Export exports the resources of each database in a read-only transaction, so the resources of the database
+// are a consistent snapshot unless the export is resumed. The resources are exported by the order of the GVR and id.
+func (s *StorageFactory) Export(ctx context.Context, opts storage.ExportOptions, w io.Writer) (storage.ExportManifest, error) {
+	cursor := &exportCursor{}
+	if opts.Cursor != "" {
+		if err := json.Unmarshal([]byte(opts.Cursor), cursor); err != nil {
+			return storage.ExportManifest{}, fmt.Errorf("invalid cursor %q: %w", opts.Cursor, err)
+		}
+	} else {
+		cursor.Manifest.ExportedAt = time.Now().UTC()
+	}
+	if cursor.Manifest.Counts == nil {
+		cursor.Manifest.Counts = map[string]int64{}
+	}
+
+	listOptions := opts.ListOptions
+	if listOptions == nil {
+		listOptions = &internal.ListOptions{}
+	}
+	if err := s.queryLimit.validateListOptions(listOptions); err != nil {
+		return cursor.Manifest, err
+	}
+	listOptions = s.clusterAliases.resolveListOptions(listOptions).DeepCopy()
+	listOptions.Limit, listOptions.Continue, listOptions.OrderBy, listOptions.WithContinue, listOptions.WithRemainingCount = 0, "", nil, nil, nil
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+
+	var writer exportWriter
+	switch opts.Format {
+	case storage.ExportFormatNDJSON, "":
+		writer = &ndjsonExportWriter{w: w}
+	case storage.ExportFormatTar:
+		tarWriter, err := newTarExportWriter(w)
+		if err != nil {
+			return cursor.Manifest, err
+		}
+		defer tarWriter.cleanup()
+		writer = tarWriter
+	default:
+		return cursor.Manifest, fmt.Errorf("not support export format: %s", opts.Format)
+	}
+
+	progress := func() error {
+		if opts.Progress == nil {
+			return nil
+		}
+		encoded, err := json.Marshal(cursor)
+		if err != nil {
+			return err
+		}
+		opts.Progress(string(encoded))
+		return nil
+	}
+
+	databases := s.namedDatabases()
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		exporter := &databaseExporter{
+			name:        name,
+			listOptions: listOptions,
+			batchSize:   batchSize,
+			cursor:      cursor,
+			writer:      writer,
+			progress:    progress,
+			encryption:  s.encryption,
+
+			splitObjects: len(s.splitObjects) != 0 && !s.splitColumnsMissing[databases[name]],
+		}
+		err := databases[name].WithContext(ctx).Transaction(exporter.export, exportTxOptions(databases[name]))
+		if err != nil {
+			return cursor.Manifest, InterpretDBError(name, err)
+		}
+	}
+
+	if err := writer.finish(cursor.Manifest); err != nil {
+		return cursor.Manifest, err
+	}
+	return cursor.Manifest, nil
+}
+
+// exportTxOptions returns the options of the read-only transaction, the reads of the transaction
+// see the same snapshot by the repeatable read isolation, sqlite is serializable by default.
+func exportTxOptions(db *gorm.DB) *sql.TxOptions {
+	if db.Dialector.Name() == "sqlite" {
+		return &sql.TxOptions{ReadOnly: true}
+	}
+	return &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead}
+}
+
+type databaseExporter struct {
+	name        string
+	listOptions *internal.ListOptions
+	batchSize   int
+
+	cursor   *exportCursor
+	writer   exportWriter
+	progress func() error
+
+	// encryption decrypts the encrypted objects, the objects are exported in plaintext
+	// and encrypted by the config of the importing storage.
+	encryption *objectEncryption
+
+	// splitObjects builds the json queries of the spec and status from the spec and status columns.
+	splitObjects bool
+}
+
+func (e *databaseExporter) export(tx *gorm.DB) error {
+	query, err := e.filter(tx)
+	if err != nil {
+		return err
+	}
+
+	var gvrs []schema.GroupVersionResource
+	if result := query.Distinct("group", "version", "resource").Find(&gvrs); result.Error != nil {
+		return result.Error
+	}
+	sort.Slice(gvrs, func(i, j int) bool { return compareGVR(gvrs[i], gvrs[j]) < 0 })
+
+	for _, gvr := range gvrs {
+		var lastID uint
+		switch e.cursor.compare(e.name, gvr) {
+		case -1:
+			continue
+		case 0:
+			if e.cursor.Completed {
+				continue
+			}
+			lastID = e.cursor.ID
+		}
+
+		for {
+			query, err := e.filter(tx)
+			if err != nil {
+				return err
+			}
+
+			var resources []Resource
+			result := query.Where(map[string]interface{}{"group": gvr.Group, "version": gvr.Version, "resource": gvr.Resource}).
+				Where("id > ?", lastID).Order("id").Limit(e.batchSize).Find(&resources)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			for _, resource := range resources {
+				object, err := e.encryption.decrypt(resource.Object)
+				if err != nil {
+					return fmt.Errorf("resource %d: %w", resource.ID, err)
+				}
+				record := exportRecord{
+					Cluster:  resource.Cluster,
+					Group:    resource.Group,
+					Version:  resource.Version,
+					Resource: resource.Resource,
+					Kind:     resource.Kind,
+					Object:   json.RawMessage(assembleObject(object, resource.Spec, resource.Status)),
+				}
+				if err := e.writer.write(record); err != nil {
+					return err
+				}
+				lastID = resource.ID
+			}
+			e.cursor.Manifest.Counts[gvrKey(gvr)] += int64(len(resources))
+
+			completed := len(resources) < e.batchSize
+			if completed {
+				if err := e.writer.endGVR(e.name, gvr); err != nil {
+					return err
+				}
+			}
+			e.cursor.Database, e.cursor.Group, e.cursor.Version, e.cursor.Resource = e.name, gvr.Group, gvr.Version, gvr.Resource
+			e.cursor.ID, e.cursor.Completed = lastID, completed
+			if completed || e.writer.resumableByBatch() {
+				if err := e.progress(); err != nil {
+					return err
+				}
+			}
+			if completed {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (e *databaseExporter) filter(tx *gorm.DB) (*gorm.DB, error) {
+	_, _, query, err := applyListOptionsToResourceQuery(tx, querySplitObjects(tx.Model(&Resource{}), e.splitObjects), e.listOptions)
+	return query, err
+}
+
+type exportWriter interface {
+	write(record exportRecord) error
+	endGVR(database string, gvr schema.GroupVersionResource) error
+	finish(manifest storage.ExportManifest) error
+
+	// resumableByBatch returns true if the export can be resumed after each batch,
+	// otherwise it is resumed after each GVR.
+	resumableByBatch() bool
+}
+
+type ndjsonExportWriter struct {
+	w io.Writer
+}
+
+func (w *ndjsonExportWriter) write(record exportRecord) error {
+	return w.writeLine(record)
+}
+
+func (w *ndjsonExportWriter) writeLine(record exportRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
+
+func (w *ndjsonExportWriter) endGVR(string, schema.GroupVersionResource) error { return nil }
+
+func (w *ndjsonExportWriter) finish(manifest storage.ExportManifest) error {
+	return w.writeLine(exportRecord{Manifest: &manifest})
+}
+
+func (w *ndjsonExportWriter) resumableByBatch() bool { return true }
+
+// tarExportWriter buffers the yaml file of the GVR in a temporary file, since the size of the file
+// must be written before the content, and the memory is kept flat.
+type tarExportWriter struct {
+	tw   *tar.Writer
+	file *os.File
+}
+
+func newTarExportWriter(w io.Writer) (*tarExportWriter, error) {
+	file, err := os.CreateTemp("", "clusterpedia-export-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	return &tarExportWriter{tw: tar.NewWriter(w), file: file}, nil
+}
+
+func (w *tarExportWriter) write(record exportRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if data, err = yaml.JSONToYAML(data); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w.file, "---\n"); err != nil {
+		return err
+	}
+	_, err = w.file.Write(data)
+	return err
+}
+
+func (w *tarExportWriter) endGVR(database string, gvr schema.GroupVersionResource) error {
+	size, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		return nil
+	}
+
+	group := gvr.Group
+	if group == "" {
+		group = "core"
+	}
+	name := path.Join(database, group, gvr.Version, gvr.Resource+".yaml")
+	if err := w.writeFile(name, size, io.NewSectionReader(w.file, 0, size)); err != nil {
+		return err
+	}
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = w.file.Seek(0, io.SeekStart)
+	return err
+}
+
+func (w *tarExportWriter) writeFile(name string, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: time.Now()}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarExportWriter) finish(manifest storage.ExportManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := w.writeFile(exportManifestFile, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return err
+	}
+	return w.tw.Close()
+}
+
+func (w *tarExportWriter) resumableByBatch() bool { return false }
+
+func (w *tarExportWriter) cleanup() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+// Import imports the resources in batches, the resources are routed to the databases by the group resource.
+func (s *StorageFactory) Import(ctx context.Context, opts storage.ImportOptions, r io.Reader) (storage.ImportSummary, error) {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultExportBatchSize
+	}
+	importer := &resourceImporter{ctx: ctx, factory: s, batchSize: batchSize}
+
+	switch opts.Format {
+	case storage.ExportFormatNDJSON, "":
+		if err := importer.decode(r); err != nil {
+			return importer.summary, err
+		}
+	case storage.ExportFormatTar:
+		tr := tar.NewReader(r)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return importer.summary, err
+			}
+			if header.Typeflag != tar.TypeReg || header.Name == exportManifestFile {
+				continue
+			}
+			if err := importer.decode(tr); err != nil {
+				return importer.summary, fmt.Errorf("%s: %w", header.Name, err)
+			}
+		}
+	default:
+		return importer.summary, fmt.Errorf("not support import format: %s", opts.Format)
+	}
+
+	if err := importer.flush(); err != nil {
+		return importer.summary, err
+	}
+	return importer.summary, nil
+}
+
+type resourceImporter struct {
+	ctx       context.Context
+	factory   *StorageFactory
+	batchSize int
+
+	db        *gorm.DB
+	resources []Resource
+	summary   storage.ImportSummary
+
+	// labels are the labels of the resources, they are inserted into the labels table in the table mode.
+	labels []map[string]string
+
+	// references are the references of the resources, they are inserted into the references table if the references are recorded.
+	references [][]ResourceReference
+
+	// flushed is called after the added resources are written, it can be nil.
+	flushed func()
+}
+
+// decode decodes the records of the yaml documents or the json lines.
+func (i *resourceImporter) decode(r io.Reader) error {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var record exportRecord
+		if err := decoder.Decode(&record); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if record.Manifest != nil || len(record.Object) == 0 {
+			continue
+		}
+		if err := i.add(record); err != nil {
+			return err
+		}
+	}
+}
+
+func (i *resourceImporter) add(record exportRecord) error {
+	gr := schema.GroupResource{Group: record.Group, Resource: record.Resource}
+	object, err := redactObject(record.Object, i.factory.redactions[gr])
+	if err != nil {
+		return err
+	}
+
+	var obj struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return err
+	}
+
+	references, err := extractReferences(object, obj.Metadata.Namespace, i.factory.references.resourceRules(gr))
+	if err != nil {
+		return err
+	}
+
+	var ownerUID types.UID
+	if owner := metav1.GetControllerOfNoCopy(&obj.Metadata); owner != nil {
+		ownerUID = owner.UID
+	}
+	resource := Resource{
+		Cluster:         record.Cluster,
+		Group:           record.Group,
+		Version:         record.Version,
+		Resource:        record.Resource,
+		Kind:            record.Kind,
+		Namespace:       obj.Metadata.Namespace,
+		Name:            obj.Metadata.Name,
+		OwnerUID:        ownerUID,
+		UID:             obj.Metadata.UID,
+		ResourceVersion: storedResourceVersion(obj.Metadata.ResourceVersion, i.factory.resourceVersionMaxLength),
+		Object:          object,
+		CreatedAt:       obj.Metadata.CreationTimestamp.Time,
+	}
+	if deletedAt := obj.Metadata.DeletionTimestamp; deletedAt != nil {
+		resource.DeletedAt = sql.NullTime{Time: deletedAt.Time, Valid: true}
+	}
+	if record.CreatedAt != nil {
+		resource.CreatedAt = *record.CreatedAt
+	}
+	if record.SyncedAt != nil {
+		resource.SyncedAt = *record.SyncedAt
+	}
+
+	db := i.factory.resourceDB(gr)
+	if i.factory.encryption.encrypts(gr) {
+		encrypted, err := i.factory.encryption.encrypt(object)
+		if err != nil {
+			return err
+		}
+		resource.Object = encrypted
+	} else if i.factory.splitObjects[gr] && !i.factory.splitColumnsMissing[db] {
+		if resource.Object, resource.Spec, resource.Status, err = splitObject(object); err != nil {
+			return err
+		}
+	}
+
+	if !i.factory.checksumMissing[db] {
+		checksum, err := objectChecksum(resource.Object)
+		if err != nil {
+			return err
+		}
+		resource.Checksum = checksum
+	}
+	if !i.factory.contentHashMissing[db] {
+		contentHash, err := objectContentHash(object)
+		if err != nil {
+			return err
+		}
+		resource.ContentHash = contentHash
+	}
+	if !i.factory.keyHashMissing[db] {
+		resource.KeyHash = resourceKeyHash(resource.Group, resource.Version, resource.Resource, resource.Cluster, resource.Namespace, resource.Name)
+	}
+	if db != i.db || len(i.resources) >= i.batchSize {
+		if err := i.flush(); err != nil {
+			return err
+		}
+		i.db = db
+	}
+	i.resources = append(i.resources, resource)
+	i.labels = append(i.labels, obj.Metadata.Labels)
+	i.references = append(i.references, references)
+	return nil
+}
+
+func (i *resourceImporter) flush() error {
+	if err := i.write(); err != nil {
+		return err
+	}
+	if i.flushed != nil {
+		i.flushed()
+	}
+	return nil
+}
+
+func (i *resourceImporter) write() error {
+	if len(i.resources) == 0 {
+		return nil
+	}
+
+	db := i.db.WithContext(i.ctx)
+	if i.factory.checksumMissing[i.db] {
+		db = db.Omit("Checksum")
+	}
+	if i.factory.contentHashMissing[i.db] {
+		db = db.Omit("ContentHash")
+	}
+	if i.factory.splitColumnsMissing[i.db] {
+		db = db.Omit("Spec", "Status")
+	}
+	if i.factory.keyHashMissing[i.db] {
+		db = db.Omit("KeyHash")
+	}
+	if i.factory.labelSelectorMode == LabelSelectorTable || i.factory.references != nil {
+		return i.flushWithSecondaryRows(db)
+	}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&i.resources)
+	if result.Error != nil {
+		return InterpretDBError("import", result.Error)
+	}
+	i.summary.Imported += result.RowsAffected
+	i.summary.Skipped += int64(len(i.resources)) - result.RowsAffected
+	i.resources, i.labels, i.references = nil, nil, nil
+	return nil
+}
+
+// flushWithSecondaryRows inserts the resources one by one in a transaction, since the ids of the resources
+// inserted by the batch can't be known if the existing ones are skipped, and inserts the labels and the references of the inserted ones.
+func (i *resourceImporter) flushWithSecondaryRows(db *gorm.DB) error {
+	var imported int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for index := range i.resources {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&i.resources[index])
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			rows := &secondaryRows{
+				withLabels:     i.factory.labelSelectorMode == LabelSelectorTable,
+				labels:         i.labels[index],
+				withReferences: i.factory.references != nil,
+				references:     i.references[index],
+			}
+			if err := rows.insert(tx, i.resources[index].ID); err != nil {
+				return err
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return InterpretDBError("import", err)
+	}
+	i.summary.Imported += imported
+	i.summary.Skipped += int64(len(i.resources)) - imported
+	i.resources, i.labels, i.references = nil, nil, nil
+	return nil
+}
@@ -0,0 +1,137 @@
+package internalstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func newExportTestFactory(t *testing.T) *StorageFactory {
+	db, err := gorm.Open(gsqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Resource{}))
+	return &StorageFactory{db: db}
+}
+
+func createExportTestResources(t *testing.T, db *gorm.DB) {
+	resources := []struct {
+		cluster, group, version, resource, kind, name string
+	}{
+		{"cluster-1", "", "v1", "pods", "Pod", "pod-1"},
+		{"cluster-1", "apps", "v1", "deployments", "Deployment", "deploy-1"},
+		{"cluster-1", "", "v1", "pods", "Pod", "pod-2"},
+		{"cluster-2", "", "v1", "pods", "Pod", "pod-3"},
+	}
+	for i, r := range resources {
+		object := fmt.Sprintf(`{"apiVersion":%q,"kind":%q,"metadata":{"name":%q,"namespace":"default","uid":"uid-%d","resourceVersion":"%d","creationTimestamp":"2024-01-01T00:00:00Z","ownerReferences":[{"apiVersion":"v1","kind":"Owner","name":"owner","uid":"owner-%d","controller":true}]}}`,
+			strings.TrimPrefix(r.group+"/"+r.version, "/"), r.kind, r.name, i, i, i)
+		require.NoError(t, db.Create(&Resource{
+			Cluster: r.cluster, Group: r.group, Version: r.version, Resource: r.resource, Kind: r.kind,
+			Namespace: "default", Name: r.name, OwnerUID: types.UID(fmt.Sprintf("owner-%d", i)),
+			UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: fmt.Sprint(i),
+			Object: []byte(object), CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}).Error)
+	}
+}
+
+func listExportTestResources(t *testing.T, db *gorm.DB) []string {
+	var resources []Resource
+	require.NoError(t, db.Order("cluster, name").Find(&resources).Error)
+
+	var keys []string
+	for _, r := range resources {
+		keys = append(keys, fmt.Sprintf("%s/%s/%s/%s/%s %s %s", r.Cluster, r.Group, r.Version, r.Resource, r.Name, r.OwnerUID, r.ResourceVersion))
+	}
+	return keys
+}
+
+func TestExportAndImport(t *testing.T) {
+	source := newExportTestFactory(t)
+	createExportTestResources(t, source.db)
+
+	for _, format := range []storage.ExportFormat{storage.ExportFormatNDJSON, storage.ExportFormatTar} {
+		t.Run(string(format), func(t *testing.T) {
+			var buffer bytes.Buffer
+			manifest, err := source.Export(context.Background(), storage.ExportOptions{Format: format, BatchSize: 1}, &buffer)
+			require.NoError(t, err)
+			assert.Equal(t, map[string]int64{"v1/pods": 3, "apps/v1/deployments": 1}, manifest.Counts)
+			assert.False(t, manifest.ExportedAt.IsZero())
+
+			target := newExportTestFactory(t)
+			summary, err := target.Import(context.Background(), storage.ImportOptions{Format: format, BatchSize: 2}, bytes.NewReader(buffer.Bytes()))
+			require.NoError(t, err)
+			assert.Equal(t, storage.ImportSummary{Imported: 4}, summary)
+			assert.Equal(t, listExportTestResources(t, source.db), listExportTestResources(t, target.db))
+
+			// the stored resources are skipped by the rerun import
+			summary, err = target.Import(context.Background(), storage.ImportOptions{Format: format}, bytes.NewReader(buffer.Bytes()))
+			require.NoError(t, err)
+			assert.Equal(t, storage.ImportSummary{Skipped: 4}, summary)
+		})
+	}
+}
+
+func TestExportTarFiles(t *testing.T) {
+	source := newExportTestFactory(t)
+	createExportTestResources(t, source.db)
+
+	var buffer bytes.Buffer
+	_, err := source.Export(context.Background(), storage.ExportOptions{Format: storage.ExportFormatTar}, &buffer)
+	require.NoError(t, err)
+
+	var files []string
+	tr := tar.NewReader(&buffer)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		files = append(files, header.Name)
+	}
+	assert.Equal(t, []string{"default/core/v1/pods.yaml", "default/apps/v1/deployments.yaml", exportManifestFile}, files)
+}
+
+func TestExportFilterAndResume(t *testing.T) {
+	source := newExportTestFactory(t)
+	createExportTestResources(t, source.db)
+
+	opts := storage.ExportOptions{
+		Format:      storage.ExportFormatNDJSON,
+		ListOptions: &internal.ListOptions{ClusterNames: []string{"cluster-1"}},
+		BatchSize:   1,
+	}
+
+	var cursors []string
+	opts.Progress = func(cursor string) { cursors = append(cursors, cursor) }
+	var full bytes.Buffer
+	manifest, err := source.Export(context.Background(), opts, &full)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"v1/pods": 2, "apps/v1/deployments": 1}, manifest.Counts)
+
+	// resume from the cursor after the first pod
+	opts.Progress, opts.Cursor = nil, cursors[0]
+	var resumed bytes.Buffer
+	resumedManifest, err := source.Export(context.Background(), opts, &resumed)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, resumedManifest)
+
+	fullLines := strings.Split(strings.TrimSpace(full.String()), "\n")
+	resumedLines := strings.Split(strings.TrimSpace(resumed.String()), "\n")
+	assert.Equal(t, fullLines[1:], resumedLines)
+}
@@ -0,0 +1,215 @@
+package internalstorage
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// URLQueryExtraFields is the url query to select the extra fields of the objects with `onlyMetadata`,
+// e.g. `extraFields=status.phase,spec.replicas`, the fields are grafted into the returned unstructured objects.
+const URLQueryExtraFields = "extraFields"
+
+const maxExtraFields = 16
+
+var extraFieldSegmentRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseExtraFields parses the extra fields from the url query, the fields must be under spec, status or metadata,
+// and the segments of the fields only contain the letters, digits, `_` and `-`.
+func parseExtraFields(urlQuery url.Values) ([][]string, error) {
+	raw := urlQuery.Get(URLQueryExtraFields)
+	if raw == "" {
+		return nil, nil
+	}
+
+	invalid := func(detail string) error {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"},
+			"urlQuery",
+			field.ErrorList{field.Invalid(field.NewPath(URLQueryExtraFields), raw, detail)},
+		)
+	}
+
+	var fields [][]string
+	for _, f := range strings.Split(raw, ",") {
+		segments := strings.Split(strings.TrimSpace(f), ".")
+		switch segments[0] {
+		case "spec", "status", "metadata":
+		default:
+			return nil, invalid(fmt.Sprintf("field %q is not under spec, status or metadata", f))
+		}
+		if len(segments) < 2 {
+			return nil, invalid(fmt.Sprintf("field %q must be a sub field of %s", f, segments[0]))
+		}
+		for _, segment := range segments {
+			if !extraFieldSegmentRegexp.MatchString(segment) {
+				return nil, invalid(fmt.Sprintf("field %q contains the invalid segment %q", f, segment))
+			}
+		}
+		fields = append(fields, segments)
+	}
+	if len(fields) > maxExtraFields {
+		return nil, invalid(fmt.Sprintf("the number of the fields must be no more than %d", maxExtraFields))
+	}
+	return fields, nil
+}
+
+// newMetadataList returns the object list of the metadata, the extra fields are selected if they are specified.
+func newMetadataList(opts *internal.ListOptions) (ObjectList, error) {
+	fields, err := parseExtraFields(opts.URLQuery)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return &ResourceMetadataList{}, nil
+	}
+	return &ResourceMetadataWithFieldsList{fields: fields}, nil
+}
+
+// ResourceMetadataWithFields is the metadata of the resource with the extra fields extracted from the object,
+// the fields are a json object keyed by the index of the field.
+type ResourceMetadataWithFields struct {
+	ResourceMetadata `gorm:"embedded"`
+
+	Fields datatypes.JSON
+
+	fields [][]string
+}
+
+func (data ResourceMetadataWithFields) graft(obj *unstructured.Unstructured) error {
+	if len(data.Fields) == 0 {
+		return nil
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(data.Fields, &values); err != nil {
+		return err
+	}
+	for i, path := range data.fields {
+		// the fields don't exist on the object are absent
+		value, ok := values[strconv.Itoa(i)]
+		if !ok || value == nil {
+			continue
+		}
+
+		if err := unstructured.SetNestedField(obj.Object, runtime.DeepCopyJSONValue(value), path...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (data ResourceMetadataWithFields) ConvertToUnstructured() (*unstructured.Unstructured, error) {
+	obj, err := data.ResourceMetadata.ConvertToUnstructured()
+	if err != nil {
+		return nil, err
+	}
+	if err := data.graft(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ConvertTo grafts the extra fields only into the unstructured object,
+// the typed objects are converted to the metadata by the apiserver.
+func (data ResourceMetadataWithFields) ConvertTo(codec runtime.Codec, object runtime.Object) (runtime.Object, error) {
+	obj, err := data.ResourceMetadata.ConvertTo(codec, object)
+	if err != nil {
+		return nil, err
+	}
+	if uObj, ok := obj.(*unstructured.Unstructured); ok {
+		if err := data.graft(uObj); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+type ResourceMetadataWithFieldsList struct {
+	fields [][]string
+	items  []ResourceMetadataWithFields
+}
+
+// selectFields selects the metadata and the extra fields, the paths of the fields are passed by the parameters.
+func (list *ResourceMetadataWithFieldsList) selectFields(db *gorm.DB) *gorm.DB {
+	var (
+		args  []string
+		paths = make([]interface{}, 0, len(list.fields))
+	)
+	dialect := db.Dialector.Name()
+	extract := func(column string, path []string) string {
+		switch dialect {
+		case "postgres":
+			paths = append(paths, "{"+strings.Join(path, ",")+"}")
+			return column + " #> ?"
+		case "mysql":
+			paths = append(paths, querybuilder.JSONPath(path))
+			return "JSON_EXTRACT(" + column + ", ?)"
+		default:
+			// the value of `->` is treated as json by the json_object of sqlite
+			paths = append(paths, querybuilder.JSONPath(path))
+			return column + " -> ?"
+		}
+	}
+	split := querybuilder.SplitObjectsQueried(db.Statement)
+	for i, path := range list.fields {
+		var field string
+		if column, keys, ok := querybuilder.SplitKeys(path); ok && split {
+			// the objects written before they are split are extracted from the object
+			splitField := extract(column, keys)
+			field = fmt.Sprintf("COALESCE(%s, %s)", splitField, extract("object", path))
+		} else {
+			field = extract("object", path)
+		}
+		args = append(args, fmt.Sprintf("'%d', %s", i, field))
+	}
+
+	function := "json_object"
+	switch dialect {
+	case "postgres":
+		function = "jsonb_build_object"
+	case "mysql":
+		function = "JSON_OBJECT"
+	}
+	return db.Select(fmt.Sprintf("%s, %s(%s) as fields", resourceMetadataColumns(db), function, strings.Join(args, ", ")), paths...)
+}
+
+func (list *ResourceMetadataWithFieldsList) From(db *gorm.DB) error {
+	items := []ResourceMetadataWithFields{}
+	if result := list.selectFields(db).Find(&items); result.Error != nil {
+		return result.Error
+	}
+	list.items = items
+	return nil
+}
+
+func (list *ResourceMetadataWithFieldsList) Stream(db *gorm.DB, fn func(Object) error) error {
+	return streamRows[ResourceMetadataWithFields](list.selectFields(db), nil, func(object Object) error {
+		data := object.(ResourceMetadataWithFields)
+		data.fields = list.fields
+		return fn(data)
+	})
+}
+
+func (list *ResourceMetadataWithFieldsList) Items() []Object {
+	objects := make([]Object, 0, len(list.items))
+	for _, object := range list.items {
+		object.fields = list.fields
+		objects = append(objects, object)
+	}
+	return objects
+}
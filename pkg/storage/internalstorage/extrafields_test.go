@@ -0,0 +1,96 @@
+package internalstorage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestParseExtraFields(t *testing.T) {
+	fields, err := parseExtraFields(url.Values{URLQueryExtraFields: []string{"status.phase, spec.replicas"}})
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"status", "phase"}, {"spec", "replicas"}}, fields)
+
+	for _, raw := range []string{"data.password", "spec", "spec.'; DROP TABLE resources", "metadata.labels.app.kubernetes.io/name"} {
+		_, err := parseExtraFields(url.Values{URLQueryExtraFields: []string{raw}})
+		assert.True(t, apierrors.IsInvalid(err), raw)
+	}
+}
+
+func TestResourceMetadataWithFieldsList_SQL(t *testing.T) {
+	list := &ResourceMetadataWithFieldsList{fields: [][]string{{"status", "phase"}, {"spec", "replicas"}}}
+	applyFn := func(query *gorm.DB, list *ResourceMetadataWithFieldsList) (*gorm.DB, error) {
+		return list.selectFields(query), nil
+	}
+
+	postgreSQL, err := toSQL(postgresDB, list, applyFn)
+	require.NoError(t, err)
+	assert.Equal(t, `SELECT "group", version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->'metadata') as metadata, jsonb_build_object('0', object #> '{status,phase}', '1', object #> '{spec,replicas}') as fields FROM "resources"`, postgreSQL)
+
+	for version, mysqlDB := range mysqlDBs {
+		mysqlSQL, err := toSQL(mysqlDB, list, applyFn)
+		require.NoError(t, err)
+		assert.Equal(t, "SELECT `group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata, JSON_OBJECT('0', JSON_EXTRACT(object, '$.\"status\".\"phase\"'), '1', JSON_EXTRACT(object, '$.\"spec\".\"replicas\"')) as fields FROM `resources`", mysqlSQL, version)
+	}
+}
+
+func TestCollectionResourceStorage_ExtraFields(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	objects := map[string]string{
+		"a": `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"a"},"spec":{"replicas":3},"status":{"readyReplicas":2}}`,
+		"b": `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"b"},"spec":{"paused":true}}`,
+	}
+	for name, object := range objects {
+		require.NoError(t, db.Create(&Resource{
+			Cluster:         "cluster-1",
+			Group:           appsv1.GroupName,
+			Version:         "v1",
+			Resource:        "deployments",
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            name,
+			UID:             types.UID(name),
+			ResourceVersion: "1",
+			Object:          []byte(object),
+		}).Error)
+	}
+
+	storage := NewCollectionResourceStorage(db, &internal.CollectionResource{
+		ObjectMeta:    metav1.ObjectMeta{Name: "workloads"},
+		ResourceTypes: []internal.CollectionResourceType{{Group: appsv1.GroupName, Version: "v1", Resource: "deployments"}},
+	})
+	collection, err := storage.Get(context.Background(), &internal.ListOptions{
+		OnlyMetadata: true,
+		OrderBy:      []internal.OrderBy{{Field: "name"}},
+		URLQuery:     url.Values{URLQueryExtraFields: []string{"spec.replicas,status.readyReplicas"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, collection.Items, 2)
+
+	a := collection.Items[0].(*unstructured.Unstructured)
+	assert.Equal(t, "a", a.GetName())
+	replicas, _, _ := unstructured.NestedFieldNoCopy(a.Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+	ready, _, _ := unstructured.NestedFieldNoCopy(a.Object, "status", "readyReplicas")
+	assert.Equal(t, int64(2), ready)
+
+	// the fields don't exist on the object are absent
+	b := collection.Items[1].(*unstructured.Unstructured)
+	assert.Equal(t, "b", b.GetName())
+	assert.NotContains(t, b.Object, "spec")
+	assert.NotContains(t, b.Object, "status")
+}
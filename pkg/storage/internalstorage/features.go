@@ -22,6 +22,21 @@ const (
 	// owner: @nekomeowww
 	// alpha: v0.8.0
 	AllowParameterizedSQLQuery featuregate.Feature = "AllowParameterizedSQLQuery"
+
+	// AllowListQueryExplain is a feature gate for the apiserver to allow explaining the list query
+	// by the url query `explain=true`, the generated SQL and the EXPLAIN plan are returned
+	// as the warnings of the response instead of executing the query.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	AllowListQueryExplain featuregate.Feature = "AllowListQueryExplain"
+
+	// QueryAttribution is a feature gate for the storage to attribute the queries to the users of the requests,
+	// the users are attached to the tracing spans, the queries metrics and the comments of the SQL.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	QueryAttribution featuregate.Feature = "QueryAttribution"
 )
 
 func init() {
@@ -33,4 +48,6 @@ func init() {
 var defaultInternalStorageFeatureGates = map[featuregate.Feature]featuregate.FeatureSpec{
 	AllowRawSQLQuery:           {Default: false, PreRelease: featuregate.Alpha},
 	AllowParameterizedSQLQuery: {Default: false, PreRelease: featuregate.Alpha},
+	AllowListQueryExplain:      {Default: false, PreRelease: featuregate.Alpha},
+	QueryAttribution:           {Default: false, PreRelease: featuregate.Alpha},
 }
@@ -0,0 +1,189 @@
+package internalstorage
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/utils/lru"
+)
+
+const (
+	defaultGetCacheMaxEntries = 1000
+	defaultNotFoundCacheTTL   = 3 * time.Second
+)
+
+var getCacheRequestsTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "get_cache_requests_total",
+		Help:           "Number of the get requests served by the get cache, partitioned by the result of hit or miss.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+var notFoundCacheRequestsTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "not_found_cache_requests_total",
+		Help:           "Number of the get requests served by the not found cache, partitioned by the result of hit or miss.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+var getSingleflightSharedTotal = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "get_singleflight_shared_total",
+		Help:           "Number of the get requests which shared the query with the concurrent get requests of the same object.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(getCacheRequestsTotal)
+	legacyregistry.MustRegister(notFoundCacheRequestsTotal)
+	legacyregistry.MustRegister(getSingleflightSharedTotal)
+}
+
+// GetCacheConfig configures the read-through cache of the get requests, the cache is disabled if the TTL is unset.
+//
+// The cached objects are invalidated by the writes in the same process, e.g. the apiserver with the in-process synchro,
+// otherwise the TTL is the only invalidation, the objects may be stale within the TTL.
+type GetCacheConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxEntries is the maximum number of the cached objects, the least recently used objects are evicted, Default is 1000.
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+// NotFoundCacheConfig configures the negative cache of the get requests, which caches the NotFound results
+// to avoid the queries of the missing objects requested repeatedly.
+//
+// Like the get cache, the cached results are invalidated by the writes in the same process,
+// otherwise the objects created in the other processes may be NotFound within the TTL.
+type NotFoundCacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// TTL is the time to cache the NotFound results, Default is 3s.
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxEntries is the maximum number of the cached results, the least recently used results are evicted, Default is 1000.
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+type getCacheKey struct {
+	gvr       schema.GroupVersionResource
+	cluster   string
+	namespace string
+	name      string
+}
+
+func (k getCacheKey) String() string {
+	return strings.Join([]string{k.gvr.String(), k.cluster, k.namespace, k.name}, "/")
+}
+
+type getCacheEntry struct {
+	object          []byte
+	resourceVersion string
+	expiresAt       time.Time
+}
+
+type getCache struct {
+	ttl   time.Duration
+	cache *lru.Cache
+
+	requests *metrics.CounterVec
+
+	// generation is increased by each invalidation, the object read before an invalidation
+	// is not cached, since it may be older than the write which invalidates the cache.
+	lock       sync.Mutex
+	generation uint64
+
+	now func() time.Time
+}
+
+func newGetCache(cfg GetCacheConfig) *getCache {
+	if cfg.TTL <= 0 {
+		return nil
+	}
+	return newTTLCache(cfg.TTL, cfg.MaxEntries, getCacheRequestsTotal)
+}
+
+// newNotFoundCache returns the get cache of the NotFound results, the entries of the cache have no object.
+func newNotFoundCache(cfg NotFoundCacheConfig) *getCache {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = defaultNotFoundCacheTTL
+	}
+	return newTTLCache(ttl, cfg.MaxEntries, notFoundCacheRequestsTotal)
+}
+
+func newTTLCache(ttl time.Duration, maxEntries int, requests *metrics.CounterVec) *getCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultGetCacheMaxEntries
+	}
+	return &getCache{ttl: ttl, cache: lru.New(maxEntries), requests: requests, now: time.Now}
+}
+
+func (c *getCache) get(key getCacheKey) (*getCacheEntry, uint64, bool) {
+	c.lock.Lock()
+	generation := c.generation
+	c.lock.Unlock()
+
+	if value, ok := c.cache.Get(key); ok {
+		if entry := value.(*getCacheEntry); c.now().Before(entry.expiresAt) {
+			c.requests.WithLabelValues("hit").Inc()
+			return entry, generation, true
+		}
+		c.cache.Remove(key)
+	}
+	c.requests.WithLabelValues("miss").Inc()
+	return nil, generation, false
+}
+
+// add caches the object read at the generation, it is ignored if the cache has been invalidated since then.
+func (c *getCache) add(key getCacheKey, generation uint64, object []byte, resourceVersion string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation != c.generation {
+		return
+	}
+
+	c.cache.Add(key, &getCacheEntry{object: object, resourceVersion: resourceVersion, expiresAt: c.now().Add(c.ttl)})
+}
+
+func (c *getCache) invalidate(key getCacheKey) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	c.cache.Remove(key)
+}
+
+// purge drops all the cached objects, e.g. after the resources of a cluster are cleaned.
+func (c *getCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	c.cache.Clear()
+}
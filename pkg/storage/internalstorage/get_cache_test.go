@@ -0,0 +1,186 @@
+package internalstorage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/singleflight"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestGetCache(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	now := time.Now()
+	rs.getCache = newGetCache(GetCacheConfig{TTL: time.Minute})
+	rs.getCache.now = func() time.Time { return now }
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+
+	getResourceVersion := func() string {
+		obj := &appsv1.Deployment{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", obj))
+		return obj.ResourceVersion
+	}
+	hits := func() float64 {
+		value, err := testutil.GetCounterMetricValue(getCacheRequestsTotal.WithLabelValues("hit"))
+		require.NoError(t, err)
+		return value
+	}
+
+	hit := hits()
+	assert.Equal(t, "1", getResourceVersion())
+	assert.Equal(t, "1", getResourceVersion())
+	assert.Equal(t, hit+1, hits())
+
+	// the write in another process is not visible until the cached object is expired
+	require.NoError(t, db.Model(&Resource{}).Where("name = ?", "deploy-1").
+		Update("object", `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"namespace":"default","name":"deploy-1","resourceVersion":"2"}}`).Error)
+	assert.Equal(t, "1", getResourceVersion())
+	now = now.Add(time.Minute)
+	assert.Equal(t, "2", getResourceVersion())
+
+	// the write in the same process invalidates the cached object
+	deploy.ResourceVersion = "3"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	assert.Equal(t, "3", getResourceVersion())
+
+	// the object read before the invalidation is not cached
+	key := rs.getCacheKey("cluster-1", "default", "deploy-1")
+	_, generation, ok := rs.getCache.get(key)
+	require.True(t, ok)
+	rs.getCache.invalidate(key)
+	rs.getCache.add(key, generation, []byte("stale"), "3")
+	_, _, ok = rs.getCache.get(key)
+	assert.False(t, ok)
+}
+
+func TestGetCacheEviction(t *testing.T) {
+	assert.Nil(t, newGetCache(GetCacheConfig{}))
+
+	cache := newGetCache(GetCacheConfig{TTL: time.Minute, MaxEntries: 2})
+	keys := []getCacheKey{{name: "deploy-1"}, {name: "deploy-2"}, {name: "deploy-3"}}
+	for _, key := range keys {
+		_, generation, _ := cache.get(key)
+		cache.add(key, generation, []byte(key.name), "1")
+	}
+
+	_, _, ok := cache.get(keys[0])
+	assert.False(t, ok)
+	entry, _, ok := cache.get(keys[2])
+	require.True(t, ok)
+	assert.Equal(t, []byte("deploy-3"), entry.object)
+
+	cache.purge()
+	_, _, ok = cache.get(keys[2])
+	assert.False(t, ok)
+}
+
+func TestNotFoundCache(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	assert.Nil(t, newNotFoundCache(NotFoundCacheConfig{}))
+	now := time.Now()
+	rs.notFoundCache = newNotFoundCache(NotFoundCacheConfig{Enabled: true})
+	rs.notFoundCache.now = func() time.Time { return now }
+	assert.Equal(t, defaultNotFoundCacheTTL, rs.notFoundCache.ttl)
+
+	get := func() error {
+		return rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{})
+	}
+	hits := func() float64 {
+		value, err := testutil.GetCounterMetricValue(notFoundCacheRequestsTotal.WithLabelValues("hit"))
+		require.NoError(t, err)
+		return value
+	}
+
+	hit := hits()
+	assert.ErrorIs(t, get(), ErrNotFound)
+	assert.ErrorIs(t, get(), ErrNotFound)
+	assert.Equal(t, hit+1, hits())
+
+	// the write in the same process invalidates the cached result
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	require.NoError(t, get())
+
+	// the object created in another process is NotFound until the cached result is expired
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deploy))
+	require.Error(t, get())
+	other := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	other.codec = config.Codec
+	require.NoError(t, other.Create(context.Background(), "cluster-1", deploy))
+	require.Error(t, get())
+	now = now.Add(defaultNotFoundCacheTTL)
+	assert.NoError(t, get())
+}
+
+func TestGetSingleflight(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.getFlight = &singleflight.Group{}
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	objs := make([]*appsv1.Deployment, len(errs))
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			objs[i] = &appsv1.Deployment{}
+			errs[i] = rs.Get(context.Background(), "cluster-1", "default", "deploy-1", objs[i])
+		}(i)
+	}
+	wg.Wait()
+	for i := range errs {
+		require.NoError(t, errs[i])
+		assert.Equal(t, "deploy-1", objs[i].Name)
+	}
+
+	// the caller whose context is done doesn't wait for the shared query
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, rs.Get(ctx, "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+}
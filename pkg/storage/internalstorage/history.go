@@ -0,0 +1,528 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	defaultHistoryQueueSize     = 1000
+	defaultHistoryBatchSize     = 100
+	defaultHistoryMaxRevisions  = 10
+	defaultHistoryMaxAge        = 7 * 24 * time.Hour
+	defaultHistorySweepInterval = 10 * time.Minute
+)
+
+var (
+	historyRevisionsDroppedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "history_revisions_dropped_total",
+			Help:           "Number of the revisions of the resource history dropped since the queue is full or failed to write them.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+
+	historyRevisionsSweptTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "history_revisions_swept_total",
+			Help:           "Number of the revisions of the resource history deleted by the retention.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(historyRevisionsDroppedTotal, historyRevisionsSweptTotal)
+
+	registerMigration(migration{
+		version:  9,
+		name:     "create the resource histories table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ResourceHistory{})
+		},
+	})
+}
+
+// HistoryConfig records the previous revisions of the objects of the configured resources,
+// so the objects can be read as they were at the time, e.g. `?at=2024-01-01T14:00:00Z`.
+//
+// The revisions are written asynchronously by the bounded queue, and they are retained by both
+// the max revisions of each object and the max age, whichever is reached first.
+type HistoryConfig struct {
+	// Resources are the resources whose history is recorded, the history is disabled if it is empty.
+	Resources []HistoryResourceConfig `yaml:"resources"`
+
+	// QueueSize is the size of the queue of the revisions to be written, Default is 1000.
+	// The revisions are dropped and counted by the history_revisions_dropped_total metric if the queue is full.
+	QueueSize int `yaml:"queueSize"`
+
+	// BatchSize is the max number of the revisions written by each insert, Default is 100.
+	BatchSize int `yaml:"batchSize"`
+
+	// MaxRevisions is the max number of the retained revisions of each object, Default is 10.
+	MaxRevisions int `yaml:"maxRevisions"`
+
+	// MaxAge is the max age of the retained revisions since they are replaced, Default is 168h.
+	MaxAge time.Duration `yaml:"maxAge"`
+
+	// SweepInterval is the interval of the sweeps of the revisions beyond the retention, Default is 10m.
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+}
+
+type HistoryResourceConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+}
+
+// ResourceHistory is the previous revision of the object, which was valid from ValidFrom until ValidUntil,
+// when the object is updated or deleted. The object is stored as it was stored in the resources table,
+// the spec and status of the split objects are reassembled, and the encrypted objects are kept encrypted.
+type ResourceHistory struct {
+	ID uint `gorm:"primaryKey"`
+
+	Group     string `gorm:"size:63;not null;index:idx_resource_histories_object"`
+	Version   string `gorm:"size:15;not null;index:idx_resource_histories_object"`
+	Resource  string `gorm:"size:63;not null;index:idx_resource_histories_object"`
+	Cluster   string `gorm:"size:253;not null;index:idx_resource_histories_object,length:100"`
+	Namespace string `gorm:"size:253;not null;index:idx_resource_histories_object,length:50"`
+	Name      string `gorm:"size:253;not null;index:idx_resource_histories_object,length:100"`
+
+	UID             types.UID `gorm:"size:36;not null"`
+	ResourceVersion string    `gorm:"size:255;not null"`
+
+	Object datatypes.JSON `gorm:"not null"`
+
+	// Deleted is set if the revision is ended by the deletion of the object.
+	Deleted bool `gorm:"not null"`
+
+	ValidFrom  time.Time `gorm:"not null"`
+	ValidUntil time.Time `gorm:"not null;index"`
+}
+
+// historyRevision is the queued revision and the database of its resource.
+type historyRevision struct {
+	db       *gorm.DB
+	revision *ResourceHistory
+}
+
+// historyRecorder writes the revisions of the configured resources in batches by a goroutine,
+// and sweeps the revisions beyond the retention periodically.
+type historyRecorder struct {
+	resources     map[schema.GroupResource]bool
+	queue         chan historyRevision
+	batchSize     int
+	maxRevisions  int
+	maxAge        time.Duration
+	sweepInterval time.Duration
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopCh    chan struct{}
+}
+
+// newHistoryRecorder returns nil if the history is disabled.
+func newHistoryRecorder(config HistoryConfig) (*historyRecorder, error) {
+	if len(config.Resources) == 0 {
+		return nil, nil
+	}
+
+	recorder := &historyRecorder{
+		resources:     make(map[schema.GroupResource]bool, len(config.Resources)),
+		batchSize:     config.BatchSize,
+		maxRevisions:  config.MaxRevisions,
+		maxAge:        config.MaxAge,
+		sweepInterval: config.SweepInterval,
+		done:          make(chan struct{}),
+		stopCh:        make(chan struct{}),
+	}
+	for _, resource := range config.Resources {
+		if resource.Resource == "" {
+			return nil, errors.New("history: resource is required")
+		}
+		recorder.resources[schema.GroupResource{Group: resource.Group, Resource: resource.Resource}] = true
+	}
+
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultHistoryQueueSize
+	}
+	recorder.queue = make(chan historyRevision, queueSize)
+	if recorder.batchSize <= 0 {
+		recorder.batchSize = defaultHistoryBatchSize
+	}
+	if recorder.maxRevisions <= 0 {
+		recorder.maxRevisions = defaultHistoryMaxRevisions
+	}
+	if recorder.maxAge <= 0 {
+		recorder.maxAge = defaultHistoryMaxAge
+	}
+	if recorder.sweepInterval <= 0 {
+		recorder.sweepInterval = defaultHistorySweepInterval
+	}
+	return recorder, nil
+}
+
+func (r *historyRecorder) records(gr schema.GroupResource) bool {
+	return r != nil && r.resources[gr]
+}
+
+// start starts writing the queued revisions and sweeping the revisions of the databases.
+func (r *historyRecorder) start(databases []*gorm.DB) {
+	if r == nil {
+		return
+	}
+
+	go r.run()
+	go func() {
+		ticker := time.NewTicker(r.sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			for _, db := range databases {
+				if _, err := r.sweep(context.Background(), db, time.Now()); err != nil {
+					klog.ErrorS(err, "Failed to sweep the resource history")
+				}
+			}
+		}
+	}()
+}
+
+// record queues the revision, the revision is dropped if the queue is full, so the slow database doesn't block the synchro.
+func (r *historyRecorder) record(db *gorm.DB, revision *ResourceHistory) {
+	select {
+	case r.queue <- historyRevision{db: db, revision: revision}:
+	default:
+		historyRevisionsDroppedTotal.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// run writes the queued revisions until the recorder is closed, the revisions queued at the time are written in one batch.
+func (r *historyRecorder) run() {
+	defer close(r.done)
+
+	batch := make([]historyRevision, 0, r.batchSize)
+	for revision := range r.queue {
+		batch = append(batch, revision)
+		for len(batch) < r.batchSize && len(r.queue) > 0 {
+			batch = append(batch, <-r.queue)
+		}
+
+		// the revisions of the same database are written together
+		for len(batch) != 0 {
+			db, revisions := batch[0].db, make([]*ResourceHistory, 0, len(batch))
+			remaining := batch[:0]
+			for _, revision := range batch {
+				if revision.db == db {
+					revisions = append(revisions, revision.revision)
+				} else {
+					remaining = append(remaining, revision)
+				}
+			}
+			if err := r.write(db, revisions); err != nil {
+				klog.ErrorS(err, "Failed to write the revisions of the resource history", "count", len(revisions))
+				historyRevisionsDroppedTotal.WithLabelValues("write_failed").Add(float64(len(revisions)))
+			}
+			batch = remaining
+		}
+	}
+}
+
+// write inserts the revisions, each revision is valid from the end of the previous revision of the object,
+// or from the creation of the object if it is created later, e.g. the object is recreated after the deletion.
+func (r *historyRecorder) write(db *gorm.DB, revisions []*ResourceHistory) error {
+	previous := make(map[historyObjectKey]time.Time, len(revisions))
+	for _, revision := range revisions {
+		key := revision.objectKey()
+		validUntil, ok := previous[key]
+		if !ok {
+			var ends []time.Time
+			if err := db.Model(&ResourceHistory{}).Where(historyObjectCondition(key)).
+				Order("id DESC").Limit(1).Pluck("valid_until", &ends).Error; err != nil {
+				return err
+			}
+			if len(ends) != 0 {
+				validUntil = ends[0]
+			}
+		}
+
+		if validUntil.After(revision.ValidFrom) {
+			revision.ValidFrom = validUntil.UTC()
+		}
+		previous[key] = revision.ValidUntil
+	}
+	return db.Create(revisions).Error
+}
+
+// sweep deletes the revisions which are older than the max age, and the oldest revisions of the objects
+// which have more revisions than the max revisions.
+func (r *historyRecorder) sweep(ctx context.Context, db *gorm.DB, now time.Time) (int64, error) {
+	db = db.WithContext(ctx)
+
+	result := db.Where("valid_until < ?", now.Add(-r.maxAge).UTC()).Delete(&ResourceHistory{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	swept := result.RowsAffected
+
+	columns := make([]string, 0, 6)
+	for _, column := range []string{"group", "version", "resource", "cluster", "namespace", "name"} {
+		columns = append(columns, db.Statement.Quote(column))
+	}
+	var keys []historyObjectKey
+	if err := db.Model(&ResourceHistory{}).
+		Select(strings.Join(columns, ", ")).
+		Group(strings.Join(columns, ", ")).
+		Having("COUNT(*) > ?", r.maxRevisions).
+		Find(&keys).Error; err != nil {
+		return swept, err
+	}
+	for _, key := range keys {
+		// the ids are increasing, so the revisions from the (maxRevisions+1)-th newest one are deleted
+		var ids []uint
+		if err := db.Model(&ResourceHistory{}).Where(historyObjectCondition(key)).
+			Order("id DESC").Offset(r.maxRevisions).Limit(1).Pluck("id", &ids).Error; err != nil {
+			return swept, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		result := db.Where(historyObjectCondition(key)).Where("id <= ?", ids[0]).Delete(&ResourceHistory{})
+		if result.Error != nil {
+			return swept, result.Error
+		}
+		swept += result.RowsAffected
+	}
+	historyRevisionsSweptTotal.Add(float64(swept))
+	return swept, nil
+}
+
+// close stops the recorder after the queued revisions are written.
+func (r *historyRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.closeOnce.Do(func() {
+		close(r.queue)
+		close(r.stopCh)
+	})
+	<-r.done
+}
+
+// historyObjectKey is the key of the revisions of an object.
+type historyObjectKey struct {
+	Group     string
+	Version   string
+	Resource  string
+	Cluster   string
+	Namespace string
+	Name      string
+}
+
+func (h *ResourceHistory) objectKey() historyObjectKey {
+	return historyObjectKey{
+		Group: h.Group, Version: h.Version, Resource: h.Resource,
+		Cluster: h.Cluster, Namespace: h.Namespace, Name: h.Name,
+	}
+}
+
+func historyObjectCondition(key historyObjectKey) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster":   key.Cluster,
+		"group":     key.Group,
+		"version":   key.Version,
+		"resource":  key.Resource,
+		"namespace": key.Namespace,
+		"name":      key.Name,
+	}
+}
+
+// historyRevision returns the stored object before it is updated or deleted as the revision of the history,
+// it is only queried if the history of the resource is enabled, and it is nil if the object doesn't exist.
+func (s *ResourceStorage) historyRevision(ctx context.Context, cluster string, metaobj metav1.Object) (*ResourceHistory, error) {
+	if s.history == nil {
+		return nil, nil
+	}
+
+	var resources []Resource
+	result := s.genGetObjectQuery(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName()).
+		Select(append(s.objectColumns(), "uid", "resource_version", "created_at")).Limit(1).Find(&resources)
+	if result.Error != nil {
+		return nil, InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+	}
+	if len(resources) == 0 {
+		return nil, nil
+	}
+
+	resource := resources[0]
+	return &ResourceHistory{
+		Cluster:         cluster,
+		Group:           s.storageGroupResource.Group,
+		Version:         s.storageVersion.Version,
+		Resource:        s.storageGroupResource.Resource,
+		Namespace:       metaobj.GetNamespace(),
+		Name:            metaobj.GetName(),
+		UID:             resource.UID,
+		ResourceVersion: resource.ResourceVersion,
+		// the encrypted objects aren't split, so they are kept encrypted
+		Object:    assembleObject(resource.Object, resource.Spec, resource.Status),
+		ValidFrom: resource.CreatedAt.UTC(),
+	}, nil
+}
+
+// recordHistory queues the revision replaced by the persisted update or delete.
+func (s *ResourceStorage) recordHistory(revision *ResourceHistory, deleted bool) {
+	if s.history == nil || revision == nil {
+		return
+	}
+	revision.Deleted = deleted
+	revision.ValidUntil = time.Now().UTC()
+	s.history.record(s.db, revision)
+}
+
+func (s *ResourceStorage) historyQuery(ctx context.Context, cluster, namespace, name string) *gorm.DB {
+	return s.db.WithContext(ctx).Model(&ResourceHistory{}).Where(historyObjectCondition(historyObjectKey{
+		Cluster: cluster, Group: s.storageGroupResource.Group, Version: s.storageVersion.Version,
+		Resource: s.storageGroupResource.Resource, Namespace: namespace, Name: name,
+	}))
+}
+
+func (s *ResourceStorage) checkHistoryEnabled() error {
+	if s.history == nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("the history of %s isn't enabled", s.storageGroupResource))
+	}
+	return nil
+}
+
+// GetAt gets the object as it was at the time, from the revision valid at the time or the current object.
+func (s *ResourceStorage) GetAt(ctx context.Context, cluster, namespace, name string, at time.Time, into runtime.Object) error {
+	if err := s.checkHistoryEnabled(); err != nil {
+		return err
+	}
+	if err := s.checkScope(namespace); err != nil {
+		return err
+	}
+	cluster = s.clusterAliases.resolve(cluster)
+	if err := checkClusterAllowed(ctx, cluster); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Get))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	notFound := func() error {
+		return apierrors.NewNotFound(s.storageGroupResource, fmt.Sprintf("%s/%s at %s", cluster, s.objectKey(namespace, name), at.Format(time.RFC3339)))
+	}
+
+	// the revisions of an object don't overlap, so the first revision ended after the time is the one valid at the time
+	var revisions []ResourceHistory
+	if err := s.historyQuery(ctx, cluster, namespace, name).Select("object", "valid_from").
+		Where("valid_until > ?", at.UTC()).Order("valid_until, id").Limit(1).Find(&revisions).Error; err != nil {
+		return InterpretResourceDBError(cluster, s.objectKey(namespace, name), err)
+	}
+
+	var object, spec, status []byte
+	if len(revisions) != 0 {
+		if revisions[0].ValidFrom.After(at) {
+			return notFound()
+		}
+		object = revisions[0].Object
+	} else {
+		var resources []Resource
+		if err := s.genGetObjectQuery(ctx, cluster, namespace, name).Select(append(s.objectColumns(), "created_at")).
+			Limit(1).Find(&resources).Error; err != nil {
+			return InterpretResourceDBError(cluster, s.objectKey(namespace, name), err)
+		}
+		if len(resources) == 0 || resources[0].CreatedAt.After(at) {
+			return notFound()
+		}
+		object, spec, status = resources[0].Object, resources[0].Spec, resources[0].Status
+	}
+
+	if object, err = s.encryption.decrypt(object); err != nil {
+		return s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+	}
+	object = assembleObject(object, spec, status)
+	obj, _, err := s.codec.Decode(object, nil, into)
+	if err != nil {
+		return s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+	}
+	if obj != into {
+		return fmt.Errorf("failed to decode resource, into is %T", into)
+	}
+	return s.injectShadowAnnotations(into, cluster)
+}
+
+// ListRevisions lists the retained revisions of the object, the newest first.
+func (s *ResourceStorage) ListRevisions(ctx context.Context, cluster, namespace, name string) (revisions []storage.ResourceRevision, err error) {
+	if err := s.checkHistoryEnabled(); err != nil {
+		return nil, err
+	}
+	if err := s.checkScope(namespace); err != nil {
+		return nil, err
+	}
+	cluster = s.clusterAliases.resolve(cluster)
+	if err := checkClusterAllowed(ctx, cluster); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Get))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var histories []ResourceHistory
+	if err := s.historyQuery(ctx, cluster, namespace, name).Select("resource_version", "deleted", "valid_from", "valid_until").
+		Order("id DESC").Find(&histories).Error; err != nil {
+		return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), err)
+	}
+
+	revisions = make([]storage.ResourceRevision, 0, len(histories))
+	for _, history := range histories {
+		revisions = append(revisions, storage.ResourceRevision{
+			ResourceVersion: history.ResourceVersion,
+			ValidFrom:       history.ValidFrom,
+			ValidUntil:      history.ValidUntil,
+			Deleted:         history.Deleted,
+		})
+	}
+	return revisions, nil
+}
@@ -0,0 +1,217 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/pointer"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestResourceStorage_History(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&ResourceHistory{}))
+
+	gr := schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gr, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	// the history is disabled by default
+	err = rs.GetAt(context.Background(), "cluster-1", "default", "deploy-1", time.Now(), &appsv1.Deployment{})
+	assert.True(t, apierrors.IsBadRequest(err), err)
+
+	recorder, err := newHistoryRecorder(HistoryConfig{Resources: []HistoryResourceConfig{{Group: gr.Group, Resource: gr.Resource}}})
+	require.NoError(t, err)
+	go recorder.run()
+	rs.history = recorder
+
+	// wait makes the timestamps of the revisions distinct from the times between them
+	wait := func() time.Time {
+		time.Sleep(10 * time.Millisecond)
+		defer time.Sleep(10 * time.Millisecond)
+		return time.Now()
+	}
+
+	beforeCreated := wait()
+	deploy := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1",
+			CreationTimestamp: metav1.NewTime(wait())},
+		Spec: appsv1.DeploymentSpec{Replicas: pointer.Int32(1)},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	atReplicas1 := wait()
+
+	deploy.ResourceVersion, deploy.Spec.Replicas = "2", pointer.Int32(2)
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	atReplicas2 := wait()
+
+	deploy.ResourceVersion, deploy.Spec.Replicas = "3", pointer.Int32(3)
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	atReplicas3 := wait()
+
+	deletedObj, err := rs.ConvertDeletedObject(deploy)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+	afterDeleted := wait()
+	recorder.close()
+
+	for _, at := range []time.Time{beforeCreated, afterDeleted} {
+		err := rs.GetAt(context.Background(), "cluster-1", "default", "deploy-1", at, &appsv1.Deployment{})
+		assert.True(t, apierrors.IsNotFound(err), err)
+	}
+	for at, replicas := range map[time.Time]int32{atReplicas1: 1, atReplicas2: 2, atReplicas3: 3} {
+		var got appsv1.Deployment
+		require.NoError(t, rs.GetAt(context.Background(), "cluster-1", "default", "deploy-1", at, &got))
+		assert.Equal(t, replicas, *got.Spec.Replicas)
+	}
+
+	revisions, err := rs.ListRevisions(context.Background(), "cluster-1", "default", "deploy-1")
+	require.NoError(t, err)
+	require.Len(t, revisions, 3)
+	for i, resourceVersion := range []string{"3", "2", "1"} {
+		assert.Equal(t, resourceVersion, revisions[i].ResourceVersion)
+		assert.Equal(t, i == 0, revisions[i].Deleted)
+		assert.True(t, revisions[i].ValidFrom.Before(revisions[i].ValidUntil))
+		if i != 0 {
+			assert.True(t, revisions[i].ValidUntil.Equal(revisions[i-1].ValidFrom))
+		}
+	}
+
+	// the object recreated after the deletion
+	deploy.ResourceVersion, deploy.CreationTimestamp = "4", metav1.NewTime(wait())
+	recreated := wait()
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	var got appsv1.Deployment
+	require.NoError(t, rs.GetAt(context.Background(), "cluster-1", "default", "deploy-1", recreated, &got))
+	assert.Equal(t, "4", got.ResourceVersion)
+	err = rs.GetAt(context.Background(), "cluster-1", "default", "deploy-1", afterDeleted, &appsv1.Deployment{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+}
+
+func TestHistoryRecorder_Sweep(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&ResourceHistory{}))
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	revisions := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"deploy-1", 3 * time.Hour},
+		{"deploy-1", 50 * time.Minute},
+		{"deploy-1", 40 * time.Minute},
+		{"deploy-1", 30 * time.Minute},
+		{"deploy-1", 20 * time.Minute},
+		{"deploy-1", 10 * time.Minute},
+		{"deploy-2", 2 * time.Hour},
+		{"deploy-2", 10 * time.Minute},
+		{"deploy-3", 5 * time.Minute},
+	}
+	for i, revision := range revisions {
+		require.NoError(t, db.Create(&ResourceHistory{
+			Cluster: "cluster-1", Group: "apps", Version: "v1", Resource: "deployments", Namespace: "default", Name: revision.name,
+			ResourceVersion: fmt.Sprint(i), Object: []byte("{}"),
+			ValidFrom: now.Add(-revision.age - time.Minute), ValidUntil: now.Add(-revision.age),
+		}).Error)
+	}
+
+	recorder, err := newHistoryRecorder(HistoryConfig{
+		Resources:    []HistoryResourceConfig{{Group: "apps", Resource: "deployments"}},
+		MaxRevisions: 3,
+		MaxAge:       time.Hour,
+	})
+	require.NoError(t, err)
+
+	before, err := testutil.GetCounterMetricValue(historyRevisionsSweptTotal)
+	require.NoError(t, err)
+	swept, err := recorder.sweep(context.Background(), db, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), swept)
+	after, err := testutil.GetCounterMetricValue(historyRevisionsSweptTotal)
+	require.NoError(t, err)
+	assert.Equal(t, float64(4), after-before)
+
+	// the revisions older than the max age, and the oldest revisions beyond the max revisions of each object are swept
+	var retained []string
+	require.NoError(t, db.Model(&ResourceHistory{}).Order("id").Pluck("resource_version", &retained).Error)
+	assert.Equal(t, []string{"3", "4", "5", "7", "8"}, retained)
+
+	// the retained revisions aren't swept again
+	swept, err = recorder.sweep(context.Background(), db, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), swept)
+}
+
+func TestHistoryRecorder_SweepNotRecordedObjects(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&ResourceHistory{}))
+
+	// the revisions of the resources which are no longer recorded are swept too
+	now := time.Now()
+	require.NoError(t, db.Create(&ResourceHistory{
+		Cluster: "cluster-1", Resource: "pods", Namespace: "default", Name: "pod-1", Object: []byte("{}"),
+		ValidFrom: now.Add(-3 * time.Hour), ValidUntil: now.Add(-2 * time.Hour),
+	}).Error)
+
+	recorder, err := newHistoryRecorder(HistoryConfig{
+		Resources: []HistoryResourceConfig{{Group: "apps", Resource: "deployments"}},
+		MaxAge:    time.Hour,
+	})
+	require.NoError(t, err)
+	swept, err := recorder.sweep(context.Background(), db, now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), swept)
+}
+
+func TestHistoryRecorder_QueueFull(t *testing.T) {
+	counter := func() float64 {
+		value, err := testutil.GetCounterMetricValue(historyRevisionsDroppedTotal.WithLabelValues("queue_full"))
+		require.NoError(t, err)
+		return value
+	}
+
+	// the recorder isn't running, so the queue is full after the first revision
+	recorder, err := newHistoryRecorder(HistoryConfig{Resources: []HistoryResourceConfig{{Resource: "pods"}}, QueueSize: 1})
+	require.NoError(t, err)
+	before := counter()
+	recorder.record(nil, &ResourceHistory{})
+	recorder.record(nil, &ResourceHistory{})
+	assert.Equal(t, float64(1), counter()-before)
+	assert.Len(t, recorder.queue, 1)
+}
+
+func TestNewHistoryRecorder(t *testing.T) {
+	recorder, err := newHistoryRecorder(HistoryConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, recorder)
+	assert.False(t, recorder.records(schema.GroupResource{Resource: "pods"}))
+
+	recorder, err = newHistoryRecorder(HistoryConfig{Resources: []HistoryResourceConfig{{Resource: "pods"}}})
+	require.NoError(t, err)
+	assert.True(t, recorder.records(schema.GroupResource{Resource: "pods"}))
+	assert.False(t, recorder.records(schema.GroupResource{Group: "apps", Resource: "deployments"}))
+	assert.Equal(t, defaultHistoryMaxRevisions, recorder.maxRevisions)
+	assert.Equal(t, defaultHistoryMaxAge, recorder.maxAge)
+
+	_, err = newHistoryRecorder(HistoryConfig{Resources: []HistoryResourceConfig{{Group: "apps"}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package internalstorage
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// indexHintsSetting is the setting of the list queries with the index hints of the query shapes.
+const indexHintsSetting = "internalstorage:index_hints"
+
+// The shapes of the list queries, the shape is detected by the filters of the list options.
+const (
+	listShapeByOwner         = "list-by-owner"
+	listShapeByNamespaceName = "list-by-namespace-name"
+	listShapeByNamespace     = "list-by-namespace"
+	listShapeByName          = "list-by-name"
+	listShapeByCluster       = "list-by-cluster"
+)
+
+var listShapes = sets.New(listShapeByOwner, listShapeByNamespaceName, listShapeByNamespace, listShapeByName, listShapeByCluster)
+
+// listQueryShape returns the shape of the list query by the filters present in the list options, the owner filter
+// takes precedence over the namespaces and names, and the clusters are only the shape if there is no other filter.
+// It returns the empty shape for the unfiltered query.
+func listQueryShape(opts *internal.ListOptions) string {
+	switch {
+	case len(opts.ClusterNames) == 1 && (opts.OwnerUID != "" || opts.OwnerName != ""):
+		return listShapeByOwner
+	case len(opts.Namespaces) != 0 && len(opts.Names) != 0:
+		return listShapeByNamespaceName
+	case len(opts.Namespaces) != 0:
+		return listShapeByNamespace
+	case len(opts.Names) != 0:
+		return listShapeByName
+	case len(opts.ClusterNames) != 0:
+		return listShapeByCluster
+	}
+	return ""
+}
+
+// indexHints are the indexes used by the list queries of the shapes.
+type indexHints map[string][]string
+
+// newIndexHints validates the shapes of the index hints, the indexes are validated by the databases.
+func newIndexHints(configs []IndexHintConfig) (indexHints, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	hints := make(indexHints, len(configs))
+	for _, config := range configs {
+		if !listShapes.Has(config.Shape) {
+			return nil, fmt.Errorf("index hint: unknown query shape %q, must be one of %v", config.Shape, sets.List(listShapes))
+		}
+		if _, ok := hints[config.Shape]; ok {
+			return nil, fmt.Errorf("index hint: duplicate query shape %q", config.Shape)
+		}
+		if len(config.Indexes) == 0 {
+			return nil, fmt.Errorf("index hint: the indexes of the query shape %q are empty", config.Shape)
+		}
+		hints[config.Shape] = config.Indexes
+	}
+	return hints, nil
+}
+
+// validate checks the hinted indexes exist on the resources table of the database, the queries with
+// the unknown indexes fail on MySQL, e.g. the index of a migration pending in the safe mode.
+func (h indexHints) validate(name string, db *gorm.DB) error {
+	for shape, indexes := range h {
+		for _, index := range indexes {
+			if !db.Migrator().HasIndex(&Resource{}, index) {
+				return fmt.Errorf("database %s: the index %q hinted by the query shape %q doesn't exist", name, index, shape)
+			}
+		}
+	}
+	return nil
+}
+
+// queryWithIndexHints sets the index hints to the list query, they are applied by the applyListOptionsToResourceQuery.
+func queryWithIndexHints(query *gorm.DB, hints indexHints) *gorm.DB {
+	if len(hints) == 0 {
+		return query
+	}
+	return query.Set(indexHintsSetting, hints)
+}
+
+// applyIndexHint adds the `USE INDEX` hint of the shape of the list query, the hints are only applied on MySQL.
+func applyIndexHint(query *gorm.DB, opts *internal.ListOptions) *gorm.DB {
+	hints, ok := query.Get(indexHintsSetting)
+	if !ok || query.Dialector.Name() != "mysql" {
+		return query
+	}
+
+	indexes := hints.(indexHints)[listQueryShape(opts)]
+	if len(indexes) == 0 {
+		return query
+	}
+	quoted := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		quoted = append(quoted, query.Statement.Quote(index))
+	}
+	return query.Table(fmt.Sprintf("resources USE INDEX (%s)", strings.Join(quoted, ", ")))
+}
@@ -0,0 +1,114 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestListQueryShape(t *testing.T) {
+	tests := []struct {
+		options *internal.ListOptions
+		shape   string
+	}{
+		{&internal.ListOptions{}, ""},
+		{&internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}}, listShapeByCluster},
+		{&internal.ListOptions{ClusterNames: []string{"cluster-1"}, Namespaces: []string{"default"}}, listShapeByNamespace},
+		{&internal.ListOptions{Names: []string{"a"}}, listShapeByName},
+		{&internal.ListOptions{Namespaces: []string{"default"}, Names: []string{"a"}}, listShapeByNamespaceName},
+		{&internal.ListOptions{ClusterNames: []string{"cluster-1"}, Namespaces: []string{"default"}, OwnerUID: "uid"}, listShapeByOwner},
+		// the owner is only queried in a single cluster
+		{&internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}, OwnerUID: "uid"}, listShapeByCluster},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.shape, listQueryShape(test.options), "%+v", test.options)
+	}
+}
+
+func TestNewIndexHints(t *testing.T) {
+	hints, err := newIndexHints(nil)
+	require.NoError(t, err)
+	assert.Nil(t, hints)
+
+	hints, err = newIndexHints([]IndexHintConfig{{Shape: "list-by-namespace", Indexes: []string{"idx_group_version_resource_namespace_name"}}})
+	require.NoError(t, err)
+	assert.Equal(t, indexHints{listShapeByNamespace: {"idx_group_version_resource_namespace_name"}}, hints)
+
+	for _, configs := range [][]IndexHintConfig{
+		{{Shape: "list-by-label", Indexes: []string{"idx_cluster"}}},
+		{{Shape: "list-by-name"}},
+		{{Shape: "list-by-name", Indexes: []string{"idx_cluster"}}, {Shape: "list-by-name", Indexes: []string{"idx_cluster"}}},
+	} {
+		_, err := newIndexHints(configs)
+		assert.Error(t, err, "%+v", configs)
+	}
+
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	assert.NoError(t, indexHints{listShapeByCluster: {"idx_cluster"}}.validate("test", db))
+	assert.Error(t, indexHints{listShapeByCluster: {"idx_cluster", "idx_unknown"}}.validate("test", db))
+}
+
+func TestResourceStorage_genListObjectQueryIndexHints(t *testing.T) {
+	hints := indexHints{
+		listShapeByNamespaceName: {"idx_group_version_resource_namespace_name", "uni_group_version_resource_cluster_namespace_name"},
+		listShapeByName:          {"idx_group_version_resource_name"},
+	}
+	tests := []struct {
+		name        string
+		listOptions *internal.ListOptions
+		expected    expected
+	}{
+		{
+			"namespace and name",
+			&internal.ListOptions{Namespaces: []string{"default"}, Names: []string{"a"}},
+			expected{
+				`SELECT * FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND namespace = 'default' AND name = 'a'`,
+				"SELECT * FROM resources USE INDEX (`idx_group_version_resource_namespace_name`, `uni_group_version_resource_cluster_namespace_name`) WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND namespace = 'default' AND name = 'a'",
+				"",
+			},
+		},
+		{
+			"name",
+			&internal.ListOptions{Names: []string{"a"}},
+			expected{
+				`SELECT * FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND name = 'a'`,
+				"SELECT * FROM resources USE INDEX (`idx_group_version_resource_name`) WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND name = 'a'",
+				"",
+			},
+		},
+		{
+			"unhinted shape",
+			&internal.ListOptions{Namespaces: []string{"default"}},
+			expected{
+				`SELECT * FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND namespace = 'default'`,
+				"SELECT * FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND namespace = 'default'",
+				"",
+			},
+		},
+	}
+
+	applyFn := func(db *gorm.DB, options *internal.ListOptions) (*gorm.DB, error) {
+		rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+		rs.indexHints = hints
+		_, _, query, _, err := rs.genListObjectsQuery(context.TODO(), db, options)
+		return query, err
+	}
+	for _, test := range tests {
+		t.Run(test.name+" postgres", func(t *testing.T) {
+			assertSQL(t, postgresDB.Session(&gorm.Session{DryRun: true}), test.listOptions, applyFn, test.expected.postgres, nil)
+		})
+		for version := range mysqlDBs {
+			t.Run(test.name+" mysql-"+version, func(t *testing.T) {
+				assertSQL(t, mysqlDBs[version].Session(&gorm.Session{DryRun: true}), test.listOptions, applyFn, test.expected.mysql, nil)
+			})
+		}
+	}
+}
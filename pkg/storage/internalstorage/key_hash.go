@@ -0,0 +1,90 @@
+package internalstorage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+
+	"gorm.io/gorm"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// resourceKeyIndex is the index of the group, version, resource, cluster, namespace and name of the resources,
+	// it is unique on the tables created before the migration 11.
+	resourceKeyIndex = "uni_group_version_resource_cluster_namespace_name"
+
+	// resourceKeyHashIndex is the unique index of the key_hash column.
+	resourceKeyHashIndex = "uni_resources_key_hash"
+)
+
+func init() {
+	registerMigration(migration{
+		version: 11,
+		name:    "add the key_hash column and its unique index to the resources table",
+		// the backfill and the indexes rewrite the whole table
+		additive: false,
+		migrate: func(db *gorm.DB) error {
+			if err := addColumnIfNotExists(db, &Resource{}, "KeyHash"); err != nil {
+				return err
+			}
+
+			// the mysql index only covers the prefixes of the cluster, namespace and name, so the resources whose names
+			// share the first 100 characters collide, the index is replaced by the non-unique one of the same name.
+			// The postgres and sqlite indexes cover the whole columns, they are kept as is.
+			if db.Dialector.Name() == "mysql" {
+				backfill := "UPDATE `resources` SET `key_hash` = SHA2(CONCAT_WS('/', `group`, `version`, `resource`, `cluster`, `namespace`, `name`), 256) WHERE `key_hash` IS NULL"
+				if err := db.Exec(backfill).Error; err != nil {
+					return err
+				}
+			}
+			if db.DryRun || !db.Migrator().HasIndex(&Resource{}, resourceKeyHashIndex) {
+				if err := db.Migrator().CreateIndex(&Resource{}, resourceKeyHashIndex); err != nil {
+					return err
+				}
+			}
+			if db.Dialector.Name() == "mysql" && (db.DryRun || isUniqueIndex(db, resourceKeyIndex)) {
+				ddl := "ALTER TABLE `resources` DROP INDEX `" + resourceKeyIndex + "`, ADD INDEX `" + resourceKeyIndex +
+					"` (`group`, `version`, `resource`, `cluster`(100), `namespace`(50), `name`(100))"
+				return db.Exec(ddl).Error
+			}
+			return nil
+		},
+	})
+}
+
+// isUniqueIndex returns true if the index of the resources table is unique.
+func isUniqueIndex(db *gorm.DB, name string) bool {
+	indexes, err := db.Migrator().GetIndexes(&Resource{})
+	if err != nil {
+		return false
+	}
+	for _, index := range indexes {
+		if index.Name() == name {
+			unique, _ := index.Unique()
+			return unique
+		}
+	}
+	return false
+}
+
+// checkKeyHashColumn records the database without the key_hash column, whose key hashes aren't written.
+func (s *StorageFactory) checkKeyHashColumn(name string, db *gorm.DB) {
+	if db.Migrator().HasColumn(&Resource{}, "KeyHash") {
+		return
+	}
+
+	klog.InfoS("The key_hash column doesn't exist, the resources whose names share the prefixes of the index may collide until the migration 11 is applied", "database", name)
+	if s.keyHashMissing == nil {
+		s.keyHashMissing = make(map[*gorm.DB]bool)
+	}
+	s.keyHashMissing[db] = true
+}
+
+// resourceKeyHash returns the sha256 of the whole key of the resource, it is the same as the hash
+// computed by the backfill of the migration 11, e.g. `SHA2(CONCAT_WS('/', ...), 256)` of mysql.
+func resourceKeyHash(group, version, resource, cluster, namespace, name string) sql.NullString {
+	hash := sha256.Sum256([]byte(strings.Join([]string{group, version, resource, cluster, namespace, name}, "/")))
+	return sql.NullString{String: hex.EncodeToString(hash[:]), Valid: true}
+}
@@ -0,0 +1,91 @@
+package internalstorage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestResourceStorage_LongNames(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	rs.codec = config.Codec
+
+	// the names share the first 100 characters which are covered by the index of the key on mysql
+	prefix := strings.Repeat("a", 100)
+	names := []string{prefix + "-request-1", prefix + "-request-2"}
+	for _, name := range names {
+		require.Len(t, name, 110)
+		deploy := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+		}
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	}
+
+	for _, name := range names {
+		obj := &appsv1.Deployment{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", name, obj))
+		assert.Equal(t, name, obj.Name)
+
+		var resource Resource
+		require.NoError(t, db.Where("name = ?", name).First(&resource).Error)
+		assert.Equal(t, resourceKeyHash("apps", "v1", "deployments", "cluster-1", "default", name), resource.KeyHash)
+	}
+
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{ClusterNames: []string{"cluster-1"}}))
+	require.Len(t, list.Items, 2)
+	assert.ElementsMatch(t, names, []string{list.Items[0].Name, list.Items[1].Name})
+
+	// the resource with the same key is still rejected by the unique index of the key hash
+	duplicate := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: names[1], ResourceVersion: "2"},
+	}
+	err = rs.Create(context.Background(), "cluster-1", duplicate)
+	var dbErr *DBError
+	require.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, ErrorKindDuplicateKey, dbErr.Kind)
+
+	// the key hash isn't written until the migration 11 is applied
+	rs.keyHashMissing = true
+	assert.Contains(t, rs.missingColumns(), "KeyHash")
+}
+
+func TestKeyHashMigration(t *testing.T) {
+	m := migrations[11]
+	assert.False(t, m.additive)
+
+	ddl, err := dryRunMigration(postgresDB, m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ALTER TABLE "resources" ADD "key_hash" varchar(64)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS "uni_resources_key_hash" ON "resources" ("key_hash")`,
+	}, ddl)
+
+	for version, db := range mysqlDBs {
+		ddl, err := dryRunMigration(db, m)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"ALTER TABLE `resources` ADD `key_hash` varchar(64)",
+			"UPDATE `resources` SET `key_hash` = SHA2(CONCAT_WS('/', `group`, `version`, `resource`, `cluster`, `namespace`, `name`), 256) WHERE `key_hash` IS NULL",
+			"CREATE UNIQUE INDEX `uni_resources_key_hash` ON `resources`(`key_hash`)",
+			"ALTER TABLE `resources` DROP INDEX `uni_group_version_resource_cluster_namespace_name`, ADD INDEX `uni_group_version_resource_cluster_namespace_name` (`group`, `version`, `resource`, `cluster`(100), `namespace`(50), `name`(100))",
+		}, ddl, version)
+	}
+}
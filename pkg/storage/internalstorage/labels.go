@@ -0,0 +1,169 @@
+package internalstorage
+
+import (
+	"fmt"
+	"maps"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// LabelSelectorMode is how the label selectors of the lists are applied, see the querybuilder.LabelSelectorMode.
+type LabelSelectorMode = querybuilder.LabelSelectorMode
+
+const (
+	LabelSelectorJSON   = querybuilder.LabelSelectorJSON
+	LabelSelectorTable  = querybuilder.LabelSelectorTable
+	LabelSelectorMemory = querybuilder.LabelSelectorMemory
+)
+
+// labelSelectorSetting is the setting of the list queries, the label selectors are applied by its mode.
+const labelSelectorSetting = "internalstorage:label_selector"
+
+func init() {
+	registerMigration(migration{
+		version:  12,
+		name:     "create the resource labels table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ResourceLabel{})
+		},
+	})
+}
+
+// ResourceLabel is the label of the resource, the labels of the resources are maintained with the rows of the resources
+// in the same transactions by the LabelSelectorTable, so the equality of the labels can be queried without the json functions.
+type ResourceLabel struct {
+	ResourceID uint `gorm:"primaryKey;autoIncrement:false"`
+
+	// the qualified name of the label key is at most 317 characters, the 253-character prefix and the 63-character name
+	Key   string `gorm:"column:label_key;primaryKey;size:317;index:idx_resource_labels_key_value"`
+	Value string `gorm:"column:label_value;size:63;not null;index:idx_resource_labels_key_value"`
+}
+
+func (ResourceLabel) TableName() string {
+	return querybuilder.LabelsTable
+}
+
+// labelSelectorQuery is the mode of the label selector set to the list query, and whether the database supports the json queries.
+type labelSelectorQuery struct {
+	mode            LabelSelectorMode
+	jsonUnsupported bool
+}
+
+// checkLabelSelector checks the labels table exists if the label selectors are applied by the table,
+// and records the database which can't query the json.
+func (s *StorageFactory) checkLabelSelector(name string, db *gorm.DB) error {
+	if s.labelSelectorMode != LabelSelectorTable {
+		return nil
+	}
+	if !db.Migrator().HasTable(&ResourceLabel{}) {
+		return fmt.Errorf("database %s: the %s table doesn't exist, the migration 12 is required by the label selector mode %q",
+			name, querybuilder.LabelsTable, LabelSelectorTable)
+	}
+	if jsonSupported(db) {
+		return nil
+	}
+
+	klog.InfoS("The database can't query the json, the label selectors are always applied by the labels table", "database", name)
+	if s.jsonUnsupported == nil {
+		s.jsonUnsupported = make(map[*gorm.DB]bool)
+	}
+	s.jsonUnsupported[db] = true
+	return nil
+}
+
+// jsonSupported returns false if the database can't query the json, e.g. the sqlite without the JSON1 extension
+// or the mysql before 5.7.
+func jsonSupported(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "mysql", "sqlite":
+		var value string
+		return db.Raw(`SELECT JSON_EXTRACT('{"a":"b"}', '$.a')`).Scan(&value).Error == nil
+	}
+	return true
+}
+
+// queryWithLabelSelector sets the mode of the label selector to the query, it is applied by the applyListOptionsToQuery.
+// The queries whose objects aren't listed, e.g. the aggregations, can't filter the labels in memory, so they use the json queries.
+func (s *ResourceStorage) queryWithLabelSelector(query *gorm.DB, listed bool) *gorm.DB {
+	mode := s.labelSelectorMode
+	if mode == "" || (mode == LabelSelectorMemory && !listed) {
+		return query
+	}
+	return query.Set(labelSelectorSetting, labelSelectorQuery{mode: mode, jsonUnsupported: s.jsonUnsupported})
+}
+
+// memoryLabelSelector returns the label selector of the list options filtered in memory, it is nil if the label selector
+// is applied by the sql.
+func (s *ResourceStorage) memoryLabelSelector(opts *internal.ListOptions) labels.Selector {
+	if s.labelSelectorMode != LabelSelectorMemory || opts.LabelSelector == nil || opts.LabelSelector.Empty() {
+		return nil
+	}
+	return opts.LabelSelector
+}
+
+// matchLabels returns whether the labels of the listed object are matched by the selector filtered in memory.
+func matchLabels(selector labels.Selector, obj runtime.Object) bool {
+	if selector == nil {
+		return true
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(accessor.GetLabels()))
+}
+
+// deleteResourceLabels deletes the labels of the resources matched by the conditions, e.g. the resources of the cleaned cluster.
+func deleteResourceLabels(db *gorm.DB, conds map[string]interface{}) error {
+	ids := db.Model(&Resource{}).Select("id").Where(conds)
+	return db.Where("resource_id IN (?)", ids).Delete(&ResourceLabel{}).Error
+}
+
+// insertLabels inserts the labels of the resource.
+func insertLabels(tx *gorm.DB, id uint, objectLabels map[string]string) error {
+	if len(objectLabels) == 0 {
+		return nil
+	}
+	rows := make([]ResourceLabel, 0, len(objectLabels))
+	for key, value := range objectLabels {
+		rows = append(rows, ResourceLabel{ResourceID: id, Key: key, Value: value})
+	}
+	return tx.Create(&rows).Error
+}
+
+// replaceLabels replaces the labels of the resource with the labels of the object.
+func replaceLabels(tx *gorm.DB, id uint, objectLabels map[string]string) error {
+	if err := tx.Where("resource_id = ?", id).Delete(&ResourceLabel{}).Error; err != nil {
+		return err
+	}
+	return insertLabels(tx, id, objectLabels)
+}
+
+// storedLabels returns the labels of the resource in the labels table.
+func storedLabels(db *gorm.DB, id uint) (map[string]string, error) {
+	var rows []ResourceLabel
+	if err := db.Where("resource_id = ?", id).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	stored := make(map[string]string, len(rows))
+	for _, row := range rows {
+		stored[row.Key] = row.Value
+	}
+	return stored, nil
+}
+
+// labelsMatched compares the stored labels with the labels of the object, the nil and the empty labels are the same.
+func labelsMatched(stored, objectLabels map[string]string) bool {
+	if len(stored) == 0 && len(objectLabels) == 0 {
+		return true
+	}
+	return maps.Equal(stored, objectLabels)
+}
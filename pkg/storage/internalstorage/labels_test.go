@@ -0,0 +1,131 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newLabelsTestStorage(t *testing.T, mode LabelSelectorMode) (*gorm.DB, *ResourceStorage) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	require.NoError(t, db.AutoMigrate(&ResourceLabel{}))
+
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	rs.codec = config.Codec
+	rs.labelSelectorMode = mode
+	return db, rs
+}
+
+func newLabeledDeployment(name string, deployLabels map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1", Labels: deployLabels},
+	}
+}
+
+func listLabeledNames(t *testing.T, rs *ResourceStorage, selector string) []string {
+	parsed, err := labels.Parse(selector)
+	require.NoError(t, err)
+	opts := &internal.ListOptions{}
+	opts.LabelSelector = parsed
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestResourceStorage_LabelsTable(t *testing.T) {
+	db, rs := newLabelsTestStorage(t, LabelSelectorTable)
+	ctx := context.Background()
+
+	require.NoError(t, rs.Create(ctx, "cluster-1", newLabeledDeployment("web", map[string]string{"app": "web", "tier": "frontend"})))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newLabeledDeployment("db", map[string]string{"app": "db"})))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newLabeledDeployment("unlabeled", nil)))
+
+	var count int64
+	require.NoError(t, db.Model(&ResourceLabel{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+
+	assert.ElementsMatch(t, []string{"web"}, listLabeledNames(t, rs, "app=web"))
+	assert.ElementsMatch(t, []string{"web", "db"}, listLabeledNames(t, rs, "app in (web,db)"))
+	assert.ElementsMatch(t, []string{"db", "unlabeled"}, listLabeledNames(t, rs, "tier!=frontend"))
+
+	// the labels are replaced by the update
+	updated := newLabeledDeployment("web", map[string]string{"app": "api"})
+	updated.ResourceVersion = "2"
+	require.NoError(t, rs.Update(ctx, "cluster-1", updated))
+	assert.Empty(t, listLabeledNames(t, rs, "app=web"))
+	assert.ElementsMatch(t, []string{"web"}, listLabeledNames(t, rs, "app=api"))
+
+	deletedObj, err := rs.ConvertDeletedObject(updated)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(ctx, "cluster-1", deletedObj))
+	require.NoError(t, db.Model(&ResourceLabel{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	factory := &StorageFactory{db: db, labelSelectorMode: LabelSelectorTable}
+	require.NoError(t, factory.CleanCluster(ctx, "cluster-1"))
+	require.NoError(t, db.Model(&ResourceLabel{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestResourceStorage_LabelsMemory(t *testing.T) {
+	_, rs := newLabelsTestStorage(t, LabelSelectorMemory)
+	ctx := context.Background()
+
+	require.NoError(t, rs.Create(ctx, "cluster-1", newLabeledDeployment("web", map[string]string{"app": "web"})))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newLabeledDeployment("db", map[string]string{"app": "db"})))
+
+	assert.ElementsMatch(t, []string{"web"}, listLabeledNames(t, rs, "app=web"))
+	assert.ElementsMatch(t, []string{"web", "db"}, listLabeledNames(t, rs, "app"))
+}
+
+func TestRebuildSecondaryDataBackfillLabels(t *testing.T) {
+	db, _ := newLabelsTestStorage(t, LabelSelectorTable)
+
+	for i, stored := range []map[string]string{nil, {"app": "pod-1"}, {"app": "drifted"}} {
+		metadata := fmt.Sprintf(`{"name":"pod-%d","uid":"uid-%d","resourceVersion":"%d","labels":{"app":"pod-%d"}}`, i, i, i, i)
+		resource := &Resource{
+			Cluster: "cluster-1", Group: "", Version: "v1", Resource: "pods", Kind: "Pod",
+			Namespace: "default", Name: fmt.Sprintf("pod-%d", i), UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: fmt.Sprint(i),
+			Object: []byte(`{"metadata":` + metadata + `}`), Metadata: []byte(metadata), CreatedAt: time.Now(),
+		}
+		require.NoError(t, db.Create(resource).Error)
+		require.NoError(t, insertLabels(db, resource.ID, stored))
+	}
+
+	factory := &StorageFactory{db: db, labelSelectorMode: LabelSelectorTable}
+	summary, err := factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.RebuildSummary{Scanned: 3, Mismatched: 2, Fixed: 2, Cursor: `{"default":3}`}, summary)
+
+	var rows []ResourceLabel
+	require.NoError(t, db.Order("resource_id").Find(&rows).Error)
+	assert.Equal(t, []ResourceLabel{
+		{ResourceID: 1, Key: "app", Value: "pod-0"},
+		{ResourceID: 2, Key: "app", Value: "pod-1"},
+		{ResourceID: 3, Key: "app", Value: "pod-2"},
+	}, rows)
+}
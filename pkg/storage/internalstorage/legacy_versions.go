@@ -0,0 +1,397 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const defaultReencodeBatchSize = 500
+
+var (
+	legacyDecodesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "legacy_decodes_total",
+			Help:           "Number of the objects which can't be decoded by the codec of the storage version and are decoded by the codecs of the previous storage versions, partitioned by the version of the codec.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "version"},
+	)
+
+	legacyReadsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "legacy_reads_total",
+			Help:           "Number of the objects got from the rows of the legacy versions since they aren't stored in the storage version, partitioned by the legacy version.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "version"},
+	)
+
+	legacyEncodedRows = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "legacy_encoded_rows",
+			Help:           "Number of the rows stored in the legacy versions of the resources which aren't re-encoded to the storage versions, updated by the re-encode job.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "version", "resource"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(legacyDecodesTotal, legacyReadsTotal, legacyEncodedRows)
+}
+
+// LegacyVersionConfig is the previous storage versions of the resource, e.g. autoscaling/v2beta2 after the storage version
+// of the horizontalpodautoscalers is changed to autoscaling/v2. The objects which aren't stored in the storage version
+// are got from the rows of the legacy versions until the rows are re-encoded to the storage version.
+type LegacyVersionConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+
+	// Versions are the legacy versions in priority order.
+	Versions []string `yaml:"versions"`
+}
+
+// ReencodeConfig re-encodes the rows of the legacy versions to the storage versions in the background.
+type ReencodeConfig struct {
+	// Interval is the interval of the re-encode rounds, the rows aren't re-encoded if it is unset.
+	Interval time.Duration `yaml:"interval"`
+
+	// BatchSize is the number of the rows queried by each batch. Default is 500.
+	BatchSize int `yaml:"batchSize"`
+}
+
+func newLegacyVersions(configs []LegacyVersionConfig) (map[schema.GroupResource][]string, error) {
+	legacyVersions := make(map[schema.GroupResource][]string, len(configs))
+	for _, config := range configs {
+		if config.Resource == "" {
+			return nil, errors.New("legacy versions: resource is required")
+		}
+		gr := schema.GroupResource{Group: config.Group, Resource: config.Resource}
+		if _, ok := legacyVersions[gr]; ok {
+			return nil, fmt.Errorf("legacy versions: %s is duplicated", gr)
+		}
+		if len(config.Versions) == 0 {
+			return nil, fmt.Errorf("legacy versions: versions of %s are required", gr)
+		}
+		legacyVersions[gr] = config.Versions
+	}
+	return legacyVersions, nil
+}
+
+// legacyVersionsOf returns the legacy versions without the storage version, e.g. the storage version is changed back.
+func legacyVersionsOf(versions []string, storageVersion string) []string {
+	var legacyVersions []string
+	for _, version := range versions {
+		if version != storageVersion {
+			legacyVersions = append(legacyVersions, version)
+		}
+	}
+	return legacyVersions
+}
+
+// decode decodes the stored object by the codec, and then by the legacy codecs in order if the codec fails.
+func (s *ResourceStorage) decode(decode func(codec runtime.Codec) (runtime.Object, error)) (runtime.Object, error) {
+	obj, err := decode(s.codec)
+	if err == nil {
+		return obj, nil
+	}
+	for _, legacy := range s.legacyCodecs {
+		if obj, legacyErr := decode(legacy.Codec); legacyErr == nil {
+			legacyDecodesTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource, legacy.Version.Version).Inc()
+			return obj, nil
+		}
+	}
+	return nil, err
+}
+
+// getLegacyObject returns the stored object of the first legacy version in priority order and the version. It isn't cached,
+// since the object is soon stored in the storage version by the sync or the re-encode job.
+func (s *ResourceStorage) getLegacyObject(ctx context.Context, cluster, namespace, name string) ([]byte, string, error) {
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	defer release()
+
+	var resources []Resource
+	result := s.db.WithContext(ctx).Model(&Resource{}).Select(append(s.objectColumns(), "version")).Where(map[string]interface{}{
+		"cluster":   cluster,
+		"group":     s.storageGroupResource.Group,
+		"resource":  s.storageGroupResource.Resource,
+		"namespace": namespace,
+		"name":      name,
+	}).Where("version IN ?", s.legacyVersions).Find(&resources)
+	if result.Error != nil {
+		return nil, "", InterpretResourceDBError(cluster, s.objectKey(namespace, name), result.Error)
+	}
+
+	for _, version := range s.legacyVersions {
+		for _, resource := range resources {
+			if resource.Version != version {
+				continue
+			}
+
+			object, err := s.encryption.decrypt(resource.Object)
+			if err != nil {
+				return nil, "", s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+			}
+			legacyReadsTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource, version).Inc()
+			setSpanAttributes(ctx, attribute.String("stored_version", version))
+			return assembleObject(object, resource.Spec, resource.Status), version, nil
+		}
+	}
+	return nil, "", InterpretResourceDBError(cluster, s.objectKey(namespace, name), gorm.ErrRecordNotFound)
+}
+
+// reencodeLegacyRows re-encodes the rows of the legacy version to the storage version in batches by the order of the id,
+// the rows which can't be re-encoded are logged and skipped. It returns the number of the re-encoded and the deleted rows.
+func (s *ResourceStorage) reencodeLegacyRows(ctx context.Context, version string, batchSize int) (int, error) {
+	var reencoded int
+	var lastID uint
+	for {
+		var rows []Resource
+		result := s.db.WithContext(ctx).Model(&Resource{}).Select(append(s.objectColumns(), "id", "cluster", "namespace", "name", "version")).
+			Where(map[string]interface{}{
+				"group":    s.storageGroupResource.Group,
+				"version":  version,
+				"resource": s.storageGroupResource.Resource,
+			}).Where("id > ?", lastID).Order("id").Limit(batchSize).Find(&rows)
+		if result.Error != nil {
+			return reencoded, InterpretDBError(s.storageGroupResource.String(), result.Error)
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+			if err := s.reencodeLegacyRow(ctx, row); err != nil {
+				if ctx.Err() != nil {
+					return reencoded, err
+				}
+				klog.ErrorS(err, "Failed to re-encode the legacy row", "gvr", s.storageGroupResource.WithVersion(version), "id", row.ID)
+				continue
+			}
+			reencoded++
+		}
+		if len(rows) < batchSize {
+			return reencoded, nil
+		}
+	}
+}
+
+// reencodeLegacyRow updates the object and the version of the row in place, so its secondary rows are kept.
+// The row is deleted instead if the object is stored in the storage version too, e.g. by the sync after the version is changed.
+func (s *ResourceStorage) reencodeLegacyRow(ctx context.Context, row Resource) error {
+	identity := ResourceIdentity{Cluster: row.Cluster, Namespace: row.Namespace, Name: row.Name}
+	defer s.invalidateGetCaches(row.Cluster, row.Namespace, row.Name)
+
+	var ids []uint
+	if err := s.objectQuery(s.db.WithContext(ctx), row.Cluster, row.Namespace, row.Name).Limit(1).Pluck("id", &ids).Error; err != nil {
+		return InterpretResourceDBError(row.Cluster, row.Name, err)
+	}
+	if len(ids) != 0 {
+		return s.deleteLegacyRow(ctx, row)
+	}
+
+	object, err := s.encryption.decrypt(row.Object)
+	if err != nil {
+		return s.decodeError(identity, err)
+	}
+	object = assembleObject(object, row.Spec, row.Status)
+	obj, err := s.decode(func(codec runtime.Codec) (runtime.Object, error) {
+		obj, _, err := codec.Decode(object, nil, nil)
+		return obj, err
+	})
+	if err != nil {
+		return s.decodeError(identity, err)
+	}
+
+	encoded, buffer, err := s.encodeObject(obj)
+	if err != nil {
+		return err
+	}
+	defer releaseEncodeBuffer(buffer)
+	stored, spec, status, err := s.storedObject(encoded)
+	if err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"version": s.storageVersion.Version,
+		"object":  stored,
+	}
+	if !s.splitColumnsMissing {
+		updates["spec"], updates["status"] = spec, status
+	}
+	if !s.checksumMissing {
+		var checksum sql.NullInt64
+		if checksum, err = objectChecksum(stored); err != nil {
+			return err
+		}
+		updates["checksum"] = checksum
+	}
+	if !s.contentHashMissing {
+		var contentHash sql.NullString
+		if contentHash, err = objectContentHash(encoded); err != nil {
+			return err
+		}
+		updates["content_hash"] = contentHash
+	}
+	if !s.keyHashMissing {
+		updates["key_hash"] = resourceKeyHash(s.storageGroupResource.Group, s.storageVersion.Version, s.storageGroupResource.Resource, row.Cluster, row.Namespace, row.Name)
+	}
+	result := s.db.WithContext(ctx).Model(&Resource{}).Where("id = ? AND version = ?", row.ID, row.Version).Updates(updates)
+	return InterpretResourceDBError(row.Cluster, row.Name, result.Error)
+}
+
+// deleteLegacyRow deletes the row of the legacy version and its secondary rows in a transaction.
+func (s *ResourceStorage) deleteLegacyRow(ctx context.Context, row Resource) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if s.labelSelectorMode == LabelSelectorTable {
+			if err := tx.Where("resource_id = ?", row.ID).Delete(&ResourceLabel{}).Error; err != nil {
+				return err
+			}
+		}
+		if s.referencesEnabled {
+			if err := tx.Where("resource_id = ?", row.ID).Delete(&ResourceReference{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("id = ? AND version = ?", row.ID, row.Version).Delete(&Resource{}).Error
+	})
+	return InterpretResourceDBError(row.Cluster, row.Name, err)
+}
+
+// countLegacyRows counts the rows of the legacy version which aren't re-encoded.
+func (s *ResourceStorage) countLegacyRows(ctx context.Context, version string) (int64, error) {
+	var count int64
+	result := s.db.WithContext(ctx).Model(&Resource{}).Where(map[string]interface{}{
+		"group":    s.storageGroupResource.Group,
+		"version":  version,
+		"resource": s.storageGroupResource.Resource,
+	}).Count(&count)
+	return count, InterpretDBError(s.storageGroupResource.String(), result.Error)
+}
+
+// legacyReencoder re-encodes the rows of the legacy versions of the registered resource storages periodically.
+type legacyReencoder struct {
+	interval  time.Duration
+	batchSize int
+
+	lock     sync.Mutex
+	storages map[schema.GroupResource]*ResourceStorage
+
+	closeOnce sync.Once
+	stopCh    chan struct{}
+}
+
+// newLegacyReencoder returns nil if the re-encode job is disabled.
+func newLegacyReencoder(config ReencodeConfig) *legacyReencoder {
+	if config.Interval <= 0 {
+		return nil
+	}
+
+	reencoder := &legacyReencoder{
+		interval:  config.Interval,
+		batchSize: config.BatchSize,
+		storages:  make(map[schema.GroupResource]*ResourceStorage),
+		stopCh:    make(chan struct{}),
+	}
+	if reencoder.batchSize <= 0 {
+		reencoder.batchSize = defaultReencodeBatchSize
+	}
+	return reencoder
+}
+
+// register registers the resource storage with the legacy versions, the later one of the same resource replaces the former.
+func (r *legacyReencoder) register(rs *ResourceStorage) {
+	if r == nil || len(rs.legacyVersions) == 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.storages[rs.storageGroupResource] = rs
+}
+
+func (r *legacyReencoder) start() {
+	if r == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := r.reencode(context.Background()); err != nil {
+				klog.ErrorS(err, "Failed to re-encode the rows of the legacy versions")
+			}
+		}
+	}()
+}
+
+// reencode re-encodes the rows of the legacy versions of the registered resource storages, and updates the numbers
+// of the remaining rows. It returns the number of the re-encoded and the deleted rows.
+func (r *legacyReencoder) reencode(ctx context.Context) (int, error) {
+	if r == nil {
+		return 0, nil
+	}
+
+	r.lock.Lock()
+	storages := make([]*ResourceStorage, 0, len(r.storages))
+	for _, rs := range r.storages {
+		storages = append(storages, rs)
+	}
+	r.lock.Unlock()
+	sort.Slice(storages, func(i, j int) bool {
+		return storages[i].storageGroupResource.String() < storages[j].storageGroupResource.String()
+	})
+
+	var reencoded int
+	for _, rs := range storages {
+		for _, version := range rs.legacyVersions {
+			n, err := rs.reencodeLegacyRows(ctx, version, r.batchSize)
+			reencoded += n
+			if err != nil {
+				return reencoded, err
+			}
+
+			remaining, err := rs.countLegacyRows(ctx, version)
+			if err != nil {
+				return reencoded, err
+			}
+			legacyEncodedRows.WithLabelValues(rs.storageGroupResource.Group, version, rs.storageGroupResource.Resource).Set(float64(remaining))
+		}
+	}
+	return reencoded, nil
+}
+
+func (r *legacyReencoder) close() {
+	if r == nil {
+		return
+	}
+	r.closeOnce.Do(func() { close(r.stopCh) })
+}
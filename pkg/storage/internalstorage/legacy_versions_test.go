@@ -0,0 +1,103 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/kubernetes/pkg/apis/autoscaling"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestLegacyVersions(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	gr := schema.GroupResource{Group: autoscaling.GroupName, Resource: "horizontalpodautoscalers"}
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gr, true)
+	require.NoError(t, err)
+	require.Equal(t, autoscalingv2.SchemeGroupVersion, config.StorageVersion)
+	rs := newTestResourceStorage(db, gr.WithVersion("v2"))
+	rs.codec = config.Codec
+	rs.legacyVersions = legacyVersionsOf([]string{"v2", "v2beta2"}, "v2")
+	assert.Equal(t, []string{"v2beta2"}, rs.legacyVersions)
+
+	// the objects were stored in v2beta2 before the storage version is changed
+	for _, name := range []string{"hpa-1", "hpa-2"} {
+		object, err := json.Marshal(&autoscalingv2beta2.HorizontalPodAutoscaler{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "autoscaling/v2beta2", Kind: "HorizontalPodAutoscaler"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: "1"},
+			Spec:       autoscalingv2beta2.HorizontalPodAutoscalerSpec{MaxReplicas: 3},
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: gr.Group, Version: "v2beta2", Resource: gr.Resource, Kind: "HorizontalPodAutoscaler",
+			Namespace: "default", Name: name, ResourceVersion: "1", Object: object,
+		}).Error)
+	}
+	// hpa-2 is synced in the storage version
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", &autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hpa-2", UID: "uid-hpa-2", ResourceVersion: "2"},
+		Spec:       autoscalingv2.HorizontalPodAutoscalerSpec{MaxReplicas: 5},
+	}))
+
+	var hpa autoscaling.HorizontalPodAutoscaler
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "hpa-1", &hpa))
+	assert.Equal(t, int32(3), hpa.Spec.MaxReplicas)
+	reads, err := testutil.GetCounterMetricValue(legacyReadsTotal.WithLabelValues(gr.Group, gr.Resource, "v2beta2"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), reads)
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "hpa-2", &hpa))
+	assert.Equal(t, int32(5), hpa.Spec.MaxReplicas)
+
+	// the objects which can't be decoded by the codec are decoded by the legacy codecs
+	rs.codec = runtime.NoopDecoder{Encoder: config.Codec}
+	rs.legacyCodecs = []storage.VersionedCodec{{Version: autoscalingv2beta2.SchemeGroupVersion, Codec: config.Codec}}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "hpa-1", &hpa))
+	decodes, err := testutil.GetCounterMetricValue(legacyDecodesTotal.WithLabelValues(gr.Group, gr.Resource, "v2beta2"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), decodes)
+	rs.codec, rs.legacyCodecs = config.Codec, nil
+
+	// hpa-1 is re-encoded, and the legacy row of hpa-2 is deleted
+	reencoder := newLegacyReencoder(ReencodeConfig{Interval: time.Hour, BatchSize: 1})
+	defer reencoder.close()
+	reencoder.register(rs)
+	reencoded, err := reencoder.reencode(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, reencoded)
+	remaining, err := testutil.GetGaugeMetricValue(legacyEncodedRows.WithLabelValues(gr.Group, "v2beta2", gr.Resource))
+	require.NoError(t, err)
+	assert.Zero(t, remaining)
+
+	var resources []Resource
+	require.NoError(t, db.Order("name").Find(&resources).Error)
+	require.Len(t, resources, 2)
+	for _, resource := range resources {
+		assert.Equal(t, "v2", resource.Version)
+		var obj metav1.TypeMeta
+		require.NoError(t, json.Unmarshal(resource.Object, &obj))
+		assert.Equal(t, "autoscaling/v2", obj.APIVersion)
+	}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "hpa-1", &hpa))
+	assert.Equal(t, int32(3), hpa.Spec.MaxReplicas)
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "hpa-2", &hpa))
+	assert.Equal(t, int32(5), hpa.Spec.MaxReplicas)
+
+	_, err = newLegacyVersions([]LegacyVersionConfig{{Group: "autoscaling", Resource: "horizontalpodautoscalers"}})
+	assert.Error(t, err)
+}
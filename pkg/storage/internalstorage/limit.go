@@ -0,0 +1,91 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/apiserver/pkg/warning"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// validateListOptions validates the user-controllable inputs of the list options,
+// the order by fields are restricted to the supported columns and the json paths unless the raw sql query is allowed.
+func (cfg QueryLimitConfig) validateListOptions(opts *internal.ListOptions) error {
+	validation := storage.ListOptionsValidation{
+		MaxItems:     cfg.MaxListItems,
+		SelectorKeys: sets.New(querybuilder.SearchLabelFuzzyName),
+	}
+	if !utilfeature.DefaultMutableFeatureGate.Enabled(AllowRawSQLQuery) {
+		validation.OrderByFields = querybuilder.SupportedOrderByFields
+		validation.OrderByPath = func(field string) bool {
+			_, ok := querybuilder.JSONOrderByPath(field)
+			return ok
+		}
+	}
+	return storage.ValidateListOptions(opts, validation)
+}
+
+// limitListOptions validates the list options and checks the limit of them, the list options without the limit
+// are limited to the max result size. The returned list options are copied if they are limited,
+// and the continue is always returned for the limited list options.
+func (cfg QueryLimitConfig) limitListOptions(opts *internal.ListOptions) (*internal.ListOptions, bool, error) {
+	if err := cfg.validateListOptions(opts); err != nil {
+		return nil, false, err
+	}
+
+	if cfg.MaxPageSize > 0 && opts.Limit > cfg.MaxPageSize {
+		return nil, false, apierrors.NewRequestEntityTooLargeError(
+			fmt.Sprintf("limit %d exceeds the maximum page size %d of the server", opts.Limit, cfg.MaxPageSize),
+		)
+	}
+
+	if opts.Limit > 0 || cfg.MaxResultSize <= 0 {
+		return opts, false, nil
+	}
+
+	withContinue := true
+	opts = opts.DeepCopy()
+	opts.Limit = cfg.MaxResultSize
+	opts.WithContinue = &withContinue
+	return opts, true, nil
+}
+
+// limitPostFilterRows rejects the list options whose rows are filtered in memory if more rows than the max post filter rows
+// may be listed, the listed rows are limited by the limit of the list options, so the list options should have been limited.
+func (cfg QueryLimitConfig) limitPostFilterRows(opts *internal.ListOptions, filters []string) error {
+	if cfg.MaxPostFilterRows <= 0 || len(filters) == 0 || (opts.Limit > 0 && opts.Limit <= cfg.MaxPostFilterRows) {
+		return nil
+	}
+
+	rows := "all of the matched rows"
+	if opts.Limit > 0 {
+		rows = fmt.Sprintf("%d rows", opts.Limit)
+	}
+	return apierrors.NewBadRequest(fmt.Sprintf(
+		"query too broad, add indexable filters: the filters %s are applied in memory to %s, more than the maximum %d rows of the server, filter by the indexed fields or set a smaller limit",
+		strings.Join(filters, ", "), rows, cfg.MaxPostFilterRows,
+	))
+}
+
+func addResultLimitedWarning(ctx context.Context, limit int64) {
+	warning.AddWarning(ctx, "", fmt.Sprintf("the result is limited to %d items by the server, use the continue to list the rest", limit))
+}
+
+func unfilteredQueryError() error {
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"},
+		"clusters",
+		field.ErrorList{
+			field.Required(field.NewPath("clusters"), "the query of all resource types must be filtered by the clusters"),
+		},
+	)
+}
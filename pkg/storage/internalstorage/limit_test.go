@@ -0,0 +1,135 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestQueryLimit(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Create(&Resource{
+			Cluster:         "cluster-1",
+			Group:           appsv1.GroupName,
+			Version:         "v1",
+			Resource:        "deployments",
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            fmt.Sprintf("deploy-%d", i),
+			UID:             types.UID(fmt.Sprintf("uid-%d", i)),
+			ResourceVersion: "1",
+			Object:          []byte(fmt.Sprintf(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"namespace":"default","name":"deploy-%d"}}`, i)),
+		}).Error)
+	}
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.queryLimit = QueryLimitConfig{MaxPageSize: 10, MaxResultSize: 2}
+
+	// the request without the limit is limited to the max result size
+	opts := &internal.ListOptions{}
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+	assert.Len(t, list.Items, 2)
+	assert.NotEmpty(t, list.Continue)
+	assert.Zero(t, opts.Limit)
+
+	continued := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), continued, &internal.ListOptions{ListOptions: metainternal.ListOptions{Continue: list.Continue}}))
+	assert.Len(t, continued.Items, 1)
+	assert.Empty(t, continued.Continue)
+
+	list = &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{ListOptions: metainternal.ListOptions{Limit: 5}}))
+	assert.Len(t, list.Items, 3)
+
+	err = rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{ListOptions: metainternal.ListOptions{Limit: 20}})
+	assert.True(t, apierrors.IsRequestEntityTooLargeError(err))
+
+	storage := newCollectionResourceStorage(db, nil, &internal.CollectionResource{ObjectMeta: metav1.ObjectMeta{Name: "any"}})
+	storage.queryLimit = QueryLimitConfig{RejectUnfilteredQuery: true}
+	_, err = storage.Get(context.Background(), &internal.ListOptions{URLQuery: url.Values{URLQueryGroups: []string{"*"}}})
+	assert.True(t, apierrors.IsInvalid(err))
+
+	collection, err := storage.Get(context.Background(), &internal.ListOptions{
+		ClusterNames: []string{"cluster-1"},
+		URLQuery:     url.Values{URLQueryGroups: []string{"*"}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, collection.Items, 3)
+}
+
+func TestQueryLimit_PostFilterRows(t *testing.T) {
+	_, rs := newLabelsTestStorage(t, LabelSelectorMemory)
+	for i := 0; i < 4; i++ {
+		app := "nginx"
+		if i%2 == 1 {
+			app = "redis"
+		}
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newLabeledDeployment(fmt.Sprintf("deploy-%d", i), map[string]string{"app": app})))
+	}
+	rs.queryLimit = QueryLimitConfig{MaxPostFilterRows: 3}
+
+	selector, err := labels.Parse("app=nginx")
+	require.NoError(t, err)
+	opts := &internal.ListOptions{}
+	opts.LabelSelector = selector
+	assert.Equal(t, []string{"label:app"}, rs.memoryFilters(opts))
+
+	metric := func(get func() (float64, error)) float64 {
+		value, err := get()
+		require.NoError(t, err)
+		return value
+	}
+	rejected := func() (float64, error) {
+		return testutil.GetCounterMetricValue(postFilterRejectedTotal.WithLabelValues(appsv1.GroupName, "deployments"))
+	}
+	scanned := func() (float64, error) {
+		return testutil.GetHistogramMetricValue(postFilterScannedRows.WithLabelValues(appsv1.GroupName, "deployments"))
+	}
+	returned := func() (float64, error) {
+		return testutil.GetHistogramMetricValue(postFilterReturnedRows.WithLabelValues(appsv1.GroupName, "deployments"))
+	}
+
+	// the rows of the list without the limit may exceed the max post filter rows
+	rejectedBefore := metric(rejected)
+	err = rs.List(context.Background(), &appsv1.DeploymentList{}, opts)
+	assert.True(t, apierrors.IsBadRequest(err))
+	assert.ErrorContains(t, err, "query too broad")
+	assert.Equal(t, float64(1), metric(rejected)-rejectedBefore)
+
+	scannedBefore, returnedBefore := metric(scanned), metric(returned)
+	opts.Limit = 3
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+	assert.Len(t, list.Items, 2)
+	assert.Equal(t, float64(3), metric(scanned)-scannedBefore)
+	assert.Equal(t, float64(2), metric(returned)-returnedBefore)
+
+	// the lists filtered by the database are unlimited
+	opts.Limit, opts.LabelSelector = 0, nil
+	list = &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+	assert.Len(t, list.Items, 4)
+}
@@ -0,0 +1,292 @@
+package internalstorage
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+const (
+	defaultListCacheMaxBytes = 64 << 20
+	maxListCacheTTL          = 5 * time.Second
+)
+
+var listCacheRequestsTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "list_cache_requests_total",
+		Help:           "Number of the list requests served by the list cache, partitioned by the result of hit, miss or bypass.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"result"},
+)
+
+var listCacheSavedSecondsTotal = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "list_cache_saved_seconds_total",
+		Help:           "Total seconds of the list queries saved by the hits of the list cache, each hit saves the duration of the query which cached the items.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+var listCacheBytes = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "list_cache_bytes",
+		Help:           "Total bytes of the encoded items cached by the list cache.",
+		StabilityLevel: metrics.ALPHA,
+	},
+)
+
+func init() {
+	legacyregistry.MustRegister(listCacheRequestsTotal)
+	legacyregistry.MustRegister(listCacheSavedSecondsTotal)
+	legacyregistry.MustRegister(listCacheBytes)
+}
+
+// ListCacheConfig configures the cache of the list requests, which shares the items of the identical list queries
+// issued within the TTL, e.g. by the dashboards. The cache is disabled if the TTL is unset.
+//
+// Unlike the get cache, the cached items are never invalidated by the writes, the TTL is the only invalidation,
+// so the lists may be stale within the TTL. The list requests with the resource version are never served by the cache.
+type ListCacheConfig struct {
+	// TTL is the time to cache the items of the list query, it must not be longer than 5s.
+	TTL time.Duration `yaml:"ttl"`
+
+	// MaxBytes is the maximum total bytes of the cached items, the least recently used lists are evicted, Default is 64MiB.
+	MaxBytes int64 `yaml:"maxBytes"`
+}
+
+type listCacheEntry struct {
+	key string
+
+	objects []Object
+	offset  int64
+	amount  *int64
+	size    int64
+
+	// queried is the duration of the query which cached the items, it is saved by each hit.
+	queried   time.Duration
+	expiresAt time.Time
+}
+
+// listCache is the lru cache of the items of the list queries bounded by the total bytes of the items.
+type listCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	bytes   int64
+
+	now func() time.Time
+}
+
+func newListCache(cfg ListCacheConfig) (*listCache, error) {
+	if cfg.TTL <= 0 {
+		return nil, nil
+	}
+	if cfg.TTL > maxListCacheTTL {
+		return nil, fmt.Errorf("listCache.ttl must not be longer than %s, got %s", maxListCacheTTL, cfg.TTL)
+	}
+	if cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("listCache.maxBytes must not be negative, got %d", cfg.MaxBytes)
+	}
+
+	maxBytes := cfg.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = defaultListCacheMaxBytes
+	}
+	return &listCache{
+		ttl:      cfg.TTL,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		now:      time.Now,
+	}, nil
+}
+
+func (c *listCache) get(key string) (*listCacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		if entry := element.Value.(*listCacheEntry); c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(element)
+			listCacheRequestsTotal.WithLabelValues("hit").Inc()
+			listCacheSavedSecondsTotal.Add(entry.queried.Seconds())
+			return entry, true
+		}
+		c.remove(element)
+	}
+	listCacheRequestsTotal.WithLabelValues("miss").Inc()
+	return nil, false
+}
+
+// add caches the items of the list query, the items larger than the cache are not cached.
+func (c *listCache) add(key string, objects []Object, offset int64, amount *int64, queried time.Duration) {
+	entry := &listCacheEntry{key: key, objects: objects, offset: offset, amount: amount, queried: queried, size: int64(len(key))}
+	for _, object := range objects {
+		entry.size += cachedObjectSize(object)
+	}
+	if entry.size > c.maxBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+	for c.bytes+entry.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+
+	entry.expiresAt = c.now().Add(c.ttl)
+	c.entries[key] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	listCacheBytes.Set(float64(c.bytes))
+}
+
+func (c *listCache) remove(element *list.Element) {
+	entry := c.lru.Remove(element).(*listCacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+	listCacheBytes.Set(float64(c.bytes))
+}
+
+// cachedObjectSize returns the bytes of the encoded object and its identity.
+func cachedObjectSize(object Object) int64 {
+	identity := object.GetIdentity()
+	size := len(identity.Cluster) + len(identity.Namespace) + len(identity.Name)
+	switch object := object.(type) {
+	case ResourceBytes:
+		size += len(object.Object) + len(object.Spec) + len(object.Status)
+	case ResourceMetadata:
+		size += len(object.Metadata)
+	case ResourceMetadataWithFields:
+		size += len(object.Metadata) + len(object.Fields)
+	case Resource:
+		size += len(object.Object)
+	}
+	return int64(size)
+}
+
+// listCacheKey returns the key of the list query in the list cache, it is empty if the cache is disabled
+// or the list can't be served by the cache, e.g. the list requires the resource version.
+func (s *ResourceStorage) listCacheKey(ctx context.Context, opts *internal.ListOptions) string {
+	if s.listCache == nil {
+		return ""
+	}
+	if opts.ResourceVersion != "" || opts.ResourceVersionMatch != "" {
+		listCacheRequestsTotal.WithLabelValues("bypass").Inc()
+		return ""
+	}
+	return canonicalListKey(ctx, s.storageGVR(), opts)
+}
+
+// canonicalListKey returns the hash of the canonical list options, the listed resource and the clusters allowed for the requester,
+// the selectors and the url query are canonicalized by their strings.
+func canonicalListKey(ctx context.Context, gvr schema.GroupVersionResource, opts *internal.ListOptions) string {
+	canonical := struct {
+		GVR                schema.GroupVersionResource
+		Names              []string
+		ClusterNames       []string
+		Namespaces         []string
+		OrderBy            []internal.OrderBy
+		OwnerName          string
+		OwnerNamespace     string
+		OwnerUID           string
+		OwnerGroupResource schema.GroupResource
+		OwnerSeniority     int
+		Since              *time.Time
+		Before             *time.Time
+		WithContinue       *bool
+		WithRemainingCount *bool
+		LabelSelector      string
+		FieldSelector      string
+		ExtraLabelSelector string
+		EnhancedFields     string
+		URLQuery           string
+		Limit              int64
+		Continue           string
+		OnlyMetadata       bool
+		AllowedClusters    []string
+	}{
+		GVR:                gvr,
+		Names:              opts.Names,
+		ClusterNames:       opts.ClusterNames,
+		Namespaces:         opts.Namespaces,
+		OrderBy:            opts.OrderBy,
+		OwnerName:          opts.OwnerName,
+		OwnerNamespace:     opts.OwnerNamespace,
+		OwnerUID:           opts.OwnerUID,
+		OwnerGroupResource: opts.OwnerGroupResource,
+		OwnerSeniority:     opts.OwnerSeniority,
+		WithContinue:       opts.WithContinue,
+		WithRemainingCount: opts.WithRemainingCount,
+		URLQuery:           canonicalURLQuery(opts.URLQuery),
+		Limit:              opts.Limit,
+		Continue:           opts.Continue,
+		OnlyMetadata:       opts.OnlyMetadata,
+	}
+	if opts.Since != nil {
+		canonical.Since = &opts.Since.Time
+	}
+	if opts.Before != nil {
+		canonical.Before = &opts.Before.Time
+	}
+	if opts.LabelSelector != nil {
+		canonical.LabelSelector = opts.LabelSelector.String()
+	}
+	if opts.FieldSelector != nil {
+		canonical.FieldSelector = opts.FieldSelector.String()
+	}
+	if opts.ExtraLabelSelector != nil {
+		canonical.ExtraLabelSelector = opts.ExtraLabelSelector.String()
+	}
+	if opts.EnhancedFieldSelector != nil {
+		canonical.EnhancedFields = opts.EnhancedFieldSelector.String()
+	}
+	// the lists of the requesters restricted to the different clusters are never shared
+	if allowed, ok := request.AllowedClustersFrom(ctx); ok {
+		canonical.AllowedClusters = sets.List(allowed)
+	}
+
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// canonicalURLQuery encodes the url query except the debug, which doesn't change the listed rows.
+func canonicalURLQuery(query url.Values) string {
+	if !query.Has(URLQueryListDebug) {
+		return query.Encode()
+	}
+	query = maps.Clone(query)
+	delete(query, URLQueryListDebug)
+	return query.Encode()
+}
@@ -0,0 +1,130 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/component-base/metrics/testutil"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+func TestListCache(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	now := time.Now()
+	rs.listCache, err = newListCache(ListCacheConfig{TTL: time.Second})
+	require.NoError(t, err)
+	rs.listCache.now = func() time.Time { return now }
+
+	for _, name := range []string{"deploy-1", "deploy-2"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+		}))
+	}
+
+	list := func(ctx context.Context, opts *internal.ListOptions) int {
+		deploys := &appsv1.DeploymentList{}
+		require.NoError(t, rs.List(ctx, deploys, opts))
+		return len(deploys.Items)
+	}
+	requests := func(result string) float64 {
+		value, err := testutil.GetCounterMetricValue(listCacheRequestsTotal.WithLabelValues(result))
+		require.NoError(t, err)
+		return value
+	}
+
+	hit, bypass := requests("hit"), requests("bypass")
+	assert.Equal(t, 2, list(context.Background(), &internal.ListOptions{}))
+	require.NoError(t, db.Where("name = ?", "deploy-2").Delete(&Resource{}).Error)
+
+	// the identical list is served by the cache until the TTL, even though the resource is deleted
+	assert.Equal(t, 2, list(context.Background(), &internal.ListOptions{}))
+	assert.Equal(t, hit+1, requests("hit"))
+
+	// the list with the resource version is never served by the cache
+	opts := &internal.ListOptions{}
+	opts.ResourceVersion = "1"
+	assert.Equal(t, 1, list(context.Background(), opts))
+	assert.Equal(t, bypass+1, requests("bypass"))
+
+	// the lists of the different filters or the different allowed clusters are not shared
+	assert.Equal(t, 1, list(context.Background(), &internal.ListOptions{ClusterNames: []string{"cluster-1"}}))
+	ctx := request.WithAllowedClusters(context.Background(), sets.New("cluster-1"))
+	assert.Equal(t, 1, list(ctx, &internal.ListOptions{}))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, list(context.Background(), &internal.ListOptions{}))
+}
+
+func TestListCacheEviction(t *testing.T) {
+	cache, err := newListCache(ListCacheConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, cache)
+	_, err = newListCache(ListCacheConfig{TTL: time.Minute})
+	assert.Error(t, err)
+
+	cache, err = newListCache(ListCacheConfig{TTL: time.Second, MaxBytes: 200})
+	require.NoError(t, err)
+	object := func(name string, size int) []Object {
+		return []Object{ResourceBytes{ResourceIdentity: ResourceIdentity{Name: name}, Object: make(Bytes, size)}}
+	}
+
+	// the keys and the objects are counted by the bytes of the cache
+	cache.add("a", object("a", 80), 0, nil, time.Millisecond)
+	cache.add("b", object("b", 80), 0, nil, time.Millisecond)
+	assert.Equal(t, int64(164), cache.bytes)
+
+	// the least recently used list is evicted
+	_, ok := cache.get("a")
+	require.True(t, ok)
+	cache.add("c", object("c", 80), 0, nil, time.Millisecond)
+	_, ok = cache.get("b")
+	assert.False(t, ok)
+	_, ok = cache.get("a")
+	assert.True(t, ok)
+
+	// the list larger than the cache is not cached
+	cache.add("d", object("d", 300), 0, nil, time.Millisecond)
+	_, ok = cache.get("d")
+	assert.False(t, ok)
+	assert.Equal(t, int64(164), cache.bytes)
+}
+
+func TestCanonicalListKey(t *testing.T) {
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	parse := func(selector string) *internal.ListOptions {
+		opts := &internal.ListOptions{}
+		opts.LabelSelector = labels.Set{}.AsSelector()
+		if selector != "" {
+			parsed, err := labels.Parse(selector)
+			require.NoError(t, err)
+			opts.LabelSelector = parsed
+		}
+		return opts
+	}
+
+	// the requirements of the selectors are sorted
+	assert.Equal(t, canonicalListKey(context.Background(), gvr, parse("a=1,b=2")), canonicalListKey(context.Background(), gvr, parse("b=2,a=1")))
+	assert.NotEqual(t, canonicalListKey(context.Background(), gvr, parse("a=1")), canonicalListKey(context.Background(), gvr, parse("a=2")))
+	assert.NotEqual(t, canonicalListKey(context.Background(), gvr, parse("")),
+		canonicalListKey(context.Background(), appsv1.SchemeGroupVersion.WithResource("replicasets"), parse("")))
+}
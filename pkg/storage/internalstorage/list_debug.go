@@ -0,0 +1,113 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/warning"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// URLQueryListDebug is the url query to describe how the list is queried in the response,
+// it is ignored if the list debug is disabled by the config.
+const URLQueryListDebug = "debug"
+
+// ListDebugAnnotation is the annotation of the unstructured lists describing how the list is queried,
+// the typed lists have no annotations, so the description is returned by the warning instead.
+const ListDebugAnnotation = "debug.clusterpedia.io/list"
+
+// ListDebug describes how the list is queried, it is recorded by the List and the building of the list query.
+type ListDebug struct {
+	// Limit is the limit of the page applied to the query, 0 means the list isn't paginated.
+	Limit int64 `json:"limit"`
+	// LimitForced is true if the limit is applied by the server instead of the request.
+	LimitForced bool `json:"limitForced,omitempty"`
+
+	// Offset is the offset of the page resolved from the continue.
+	Offset    int64 `json:"offset"`
+	Continued bool  `json:"continued"`
+
+	// CountQuery is true if the remaining item count is counted by a separate query.
+	CountQuery bool `json:"countQuery"`
+
+	// ListCacheHit is true if the objects are served from the list cache, the filters aren't recorded since
+	// the query isn't built.
+	ListCacheHit bool `json:"listCacheHit,omitempty"`
+
+	// SQLFilters are the filters pushed down to the SQL, and ResidualFilters are the ones can't be translated to the SQL.
+	SQLFilters      []string `json:"sqlFilters"`
+	ResidualFilters []string `json:"residualFilters"`
+
+	// QueryTime is the elapsed time of the queries of the objects and the count.
+	QueryTime string `json:"queryTime"`
+
+	// Rows is the number of the listed rows, and Returned is the number of the returned objects after the filters in memory.
+	Rows     int `json:"rows"`
+	Returned int `json:"returned"`
+}
+
+type listDebugKey struct{}
+
+// listDebug returns the context recording the ListDebug of the list, the ListDebug is nil if it isn't requested.
+func (s *ResourceStorage) listDebug(ctx context.Context, opts *internal.ListOptions) (context.Context, *ListDebug) {
+	if s.listDebugDisabled {
+		return ctx, nil
+	}
+	if requested, _ := strconv.ParseBool(opts.URLQuery.Get(URLQueryListDebug)); !requested {
+		return ctx, nil
+	}
+
+	debug := &ListDebug{}
+	return context.WithValue(ctx, listDebugKey{}, debug), debug
+}
+
+func listDebugFrom(ctx context.Context) *ListDebug {
+	debug, _ := ctx.Value(listDebugKey{}).(*ListDebug)
+	return debug
+}
+
+func (debug *ListDebug) observeQuery(started time.Time, rows int, countQuery bool) {
+	if debug == nil {
+		return
+	}
+	debug.QueryTime = time.Since(started).String()
+	debug.Rows, debug.CountQuery = rows, countQuery
+}
+
+// write returns the ListDebug by the annotation of the unstructured list, or by the warning for the other lists.
+func (debug *ListDebug) write(ctx context.Context, listObject runtime.Object) {
+	if debug == nil {
+		return
+	}
+	if debug.SQLFilters == nil {
+		debug.SQLFilters = []string{}
+	}
+	if debug.ResidualFilters == nil {
+		debug.ResidualFilters = []string{}
+	}
+	data, err := json.Marshal(debug)
+	if err != nil {
+		return
+	}
+
+	if list, ok := listObject.(*unstructured.UnstructuredList); ok {
+		metadata, _ := list.Object["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			list.Object["metadata"] = metadata
+		}
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		if annotations == nil {
+			annotations = make(map[string]interface{})
+			metadata["annotations"] = annotations
+		}
+		annotations[ListDebugAnnotation] = string(data)
+		return
+	}
+	warning.AddWarning(ctx, "", ListDebugAnnotation+": "+string(data))
+}
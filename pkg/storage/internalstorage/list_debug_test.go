@@ -0,0 +1,103 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/warning"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+type recordingWarnings []string
+
+func (w *recordingWarnings) AddWarning(_, text string) {
+	*w = append(*w, text)
+}
+
+func TestListDebug(t *testing.T) {
+	_, rs := newLabelsTestStorage(t, LabelSelectorMemory)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newLabeledDeployment(fmt.Sprintf("deploy-%d", i), map[string]string{"app": "nginx"})))
+	}
+	rs.queryLimit = QueryLimitConfig{MaxResultSize: 2}
+
+	withRemainingCount := true
+	listOptions := func(debug string) *internal.ListOptions {
+		opts := &internal.ListOptions{
+			ListOptions:        metainternal.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "nginx"})},
+			WithRemainingCount: &withRemainingCount,
+			URLQuery:           url.Values{URLQueryListDebug: []string{debug}},
+		}
+		opts.Namespaces = []string{"default"}
+		return opts
+	}
+	assertListDebug := func(t *testing.T, data string) {
+		var debug ListDebug
+		require.NoError(t, json.Unmarshal([]byte(data), &debug))
+		assert.Equal(t, int64(2), debug.Limit)
+		assert.True(t, debug.LimitForced)
+		assert.Equal(t, int64(0), debug.Offset)
+		assert.True(t, debug.CountQuery)
+		assert.Contains(t, debug.SQLFilters, "namespace")
+		assert.Equal(t, []string{"label:app"}, debug.ResidualFilters)
+		assert.NotEmpty(t, debug.QueryTime)
+		assert.Equal(t, 2, debug.Rows)
+		assert.Equal(t, 2, debug.Returned)
+	}
+
+	t.Run("unstructured list", func(t *testing.T) {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+		require.NoError(t, rs.List(context.Background(), list, listOptions("true")))
+		annotations, _, err := unstructured.NestedStringMap(list.Object, "metadata", "annotations")
+		require.NoError(t, err)
+		assertListDebug(t, annotations[ListDebugAnnotation])
+
+		// the continue isn't bound to the debug
+		next := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+		opts := listOptions("false")
+		opts.Continue = list.GetContinue()
+		require.NoError(t, rs.List(context.Background(), next, opts))
+		assert.Len(t, next.Items, 1)
+	})
+
+	t.Run("typed list", func(t *testing.T) {
+		var warnings recordingWarnings
+		ctx := warning.WithWarningRecorder(context.Background(), &warnings)
+		require.NoError(t, rs.List(ctx, &appsv1.DeploymentList{}, listOptions("true")))
+
+		var debugs []string
+		for _, w := range warnings {
+			if data, ok := strings.CutPrefix(w, ListDebugAnnotation+": "); ok {
+				debugs = append(debugs, data)
+			}
+		}
+		require.Len(t, debugs, 1)
+		assertListDebug(t, debugs[0])
+	})
+
+	t.Run("not requested", func(t *testing.T) {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+		require.NoError(t, rs.List(context.Background(), list, listOptions("false")))
+		assert.NotContains(t, list.Object["metadata"], "annotations")
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rs.listDebugDisabled = true
+		defer func() { rs.listDebugDisabled = false }()
+
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+		require.NoError(t, rs.List(context.Background(), list, listOptions("true")))
+		assert.NotContains(t, list.Object["metadata"], "annotations")
+	})
+}
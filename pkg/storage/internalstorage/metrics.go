@@ -0,0 +1,458 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+var (
+	dbErrorsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "errors_total",
+			Help:           "Number of the database errors interpreted by the storage, partitioned by the kind of the error and the dialect.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind", "dialect"},
+	)
+
+	corruptRowsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "corrupt_rows_total",
+			Help:           "Number of the rows whose objects can't be decoded when they are read, partitioned by the group and the resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+
+	checksumMismatchesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "checksum_mismatches_total",
+			Help:           "Number of the objects found mismatched with their checksums by the verification of the reads and the checksum scan, partitioned by the group and the resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+
+	checksumRepairsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "checksum_repairs_total",
+			Help:           "Number of the rows mismatched with their checksums which are deleted by the checksum scan to be resynced, partitioned by the group and the resource.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+
+	updatesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "updates_total",
+			Help:           "Number of the updates of the resources, partitioned by the group, the resource and the type of the update, the light updates only write the resource versions of the unchanged objects.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource", "type"},
+	)
+
+	storedResourcesDesc = prometheus.NewDesc(
+		"clusterpedia_stored_resources",
+		"Number of the resources stored in the storage.",
+		[]string{"db_name", "dialect", "cluster", "group", "resource"}, nil,
+	)
+
+	resourceSyncedAtDesc = prometheus.NewDesc(
+		"clusterpedia_resource_synced_at_seconds",
+		"Unix timestamp of the latest synced resource in the storage.",
+		[]string{"db_name", "dialect", "cluster", "group", "resource"}, nil,
+	)
+
+	storedResourcesBytesDesc = prometheus.NewDesc(
+		"clusterpedia_stored_resources_bytes",
+		"Total size in bytes of the encoded objects of the resources stored in the storage.",
+		[]string{"db_name", "dialect", "cluster", "group", "resource"}, nil,
+	)
+
+	resourcesTableBytesDesc = prometheus.NewDesc(
+		"clusterpedia_resources_table_bytes",
+		"Total size in bytes of the resources table including the indexes and the toasted data, only reported by postgres.",
+		[]string{"db_name", "dialect"}, nil,
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(dbErrorsTotal)
+	legacyregistry.MustRegister(corruptRowsTotal)
+	legacyregistry.MustRegister(checksumMismatchesTotal)
+	legacyregistry.MustRegister(checksumRepairsTotal)
+	legacyregistry.MustRegister(updatesTotal)
+}
+
+var _ storage.StorageStatsReporter = &StorageFactory{}
+
+// StorageStats returns the stats of the databases refreshed by the resource metrics,
+// so the stats are only available if the refresh interval of the metrics is set.
+func (s *StorageFactory) StorageStats() ([]storage.DatabaseStats, error) {
+	if len(s.stats) == 0 {
+		return nil, errors.New("the storage stats are disabled, the refresh interval of the metrics is unset")
+	}
+
+	stats := make([]storage.DatabaseStats, 0, len(s.stats))
+	for _, source := range s.stats {
+		stats = append(stats, source.databaseStats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats, nil
+}
+
+type resourceStatKey struct {
+	cluster  string
+	group    string
+	resource string
+}
+
+type resourceStat struct {
+	count    int64
+	bytes    int64
+	syncedAt time.Time
+}
+
+// resourceStatRow is the row of the grouped query, the `version` column is grouped to use the
+// composite index of the resources table, the rows of different versions are merged into one stat.
+type resourceStatRow struct {
+	Group    string
+	Version  string
+	Resource string
+	Cluster  string
+	Count    int64
+	Bytes    int64
+	SyncedAt timestamp
+}
+
+// timestamp scans the aggregated time,
+// sqlite returns the MAX(synced_at) as text instead of time.
+type timestamp time.Time
+
+func (t *timestamp) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = timestamp{}
+		return nil
+	case time.Time:
+		*t = timestamp(v)
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("unsupported type %T of the timestamp", value)
+}
+
+func (t *timestamp) parse(value string) error {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.Parse(format, value); err == nil {
+			*t = timestamp(parsed)
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to parse the timestamp %q", value)
+}
+
+// resourceMetricsCollector collects the metrics of the stored resources from the storages registered with it,
+// the storages are keyed by the database name and the dialect, so multiple storage factories can share the collector of a registerer.
+type resourceMetricsCollector struct {
+	lock    sync.RWMutex
+	sources map[string]*resourceStatsSource
+}
+
+var _ prometheus.Collector = &resourceMetricsCollector{}
+
+func metricsRegisterer(config *MetricsConfig) prometheus.Registerer {
+	if config.Registerer == nil {
+		return legacyregistry.Registerer()
+	}
+	return config.Registerer
+}
+
+// registerConnPoolMetrics registers the stats of the connection pool of the database with the db_name label,
+// the registration is skipped if the stats of the same database name is already registered.
+func registerConnPoolMetrics(name string, db *gorm.DB, config *MetricsConfig) error {
+	if config == nil {
+		return nil
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	err = metricsRegisterer(config).Register(collectors.NewDBStatsCollector(sqlDB, name))
+	var registeredErr prometheus.AlreadyRegisteredError
+	if errors.As(err, &registeredErr) {
+		klog.InfoS("The connection pool metrics of the database is already registered", "database", name)
+		return nil
+	}
+	return err
+}
+
+// registerResourceMetrics registers the collector to the registerer of the config and starts the refresh,
+// the legacyregistry is used if the registerer is unset. The returned source is also used by the stats of the storage factory,
+// it is nil if the metrics are disabled.
+//
+// The registration is idempotent, if the collector is already registered to the registerer,
+// the storage is added to it and replaces the previous storage of the same database name and dialect.
+func registerResourceMetrics(name string, db *gorm.DB, config *MetricsConfig) (*resourceStatsSource, error) {
+	if config == nil || config.RefreshInterval <= 0 {
+		return nil, nil
+	}
+
+	registerer := metricsRegisterer(config)
+
+	collector := &resourceMetricsCollector{}
+	if err := registerer.Register(collector); err != nil {
+		var registeredErr prometheus.AlreadyRegisteredError
+		if !errors.As(err, &registeredErr) {
+			return nil, err
+		}
+
+		existing, ok := registeredErr.ExistingCollector.(*resourceMetricsCollector)
+		if !ok {
+			return nil, err
+		}
+		collector = existing
+	}
+
+	source := newResourceStatsSource(name, db, config.RefreshInterval)
+	collector.addSource(source)
+	return source, nil
+}
+
+func (c *resourceMetricsCollector) addSource(source *resourceStatsSource) {
+	ctx, cancel := context.WithCancel(context.Background())
+	source.cancel = cancel
+
+	c.lock.Lock()
+	if c.sources == nil {
+		c.sources = make(map[string]*resourceStatsSource)
+	}
+	key := source.name + "/" + source.dialect
+	if previous := c.sources[key]; previous != nil && previous.cancel != nil {
+		klog.InfoS("Replace the storage of the resource metrics", "database", source.name, "dialect", source.dialect)
+		previous.cancel()
+	}
+	c.sources[key] = source
+	c.lock.Unlock()
+
+	go source.run(ctx)
+}
+
+func (c *resourceMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storedResourcesDesc
+	ch <- resourceSyncedAtDesc
+	ch <- storedResourcesBytesDesc
+	ch <- resourcesTableBytesDesc
+}
+
+func (c *resourceMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, source := range c.sources {
+		source.collect(ch)
+	}
+}
+
+// resourceStatsSource periodically refreshes the number, the encoded size and the latest synced time
+// of the resources stored in a storage, the collected metrics are the result of the last refresh.
+type resourceStatsSource struct {
+	name     string
+	db       *gorm.DB
+	dialect  string
+	interval time.Duration
+	cancel   context.CancelFunc
+
+	refreshing atomic.Bool
+
+	lock        sync.RWMutex
+	stats       map[resourceStatKey]resourceStat
+	tableBytes  *int64
+	refreshedAt time.Time
+}
+
+func newResourceStatsSource(name string, db *gorm.DB, interval time.Duration) *resourceStatsSource {
+	source := &resourceStatsSource{name: name, db: db, interval: interval}
+	if db != nil {
+		source.dialect = db.Dialector.Name()
+	}
+	return source
+}
+
+func (c *resourceStatsSource) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.tryRefresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryRefresh refreshes the stats in the background, it is skipped if the previous refresh is still running.
+func (c *resourceStatsSource) tryRefresh(ctx context.Context) {
+	if !c.refreshing.CompareAndSwap(false, true) {
+		klog.V(4).Info("The previous refresh of the resource metrics is still running, skip this refresh")
+		return
+	}
+
+	go func() {
+		defer c.refreshing.Store(false)
+		if err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+			klog.ErrorS(err, "Failed to refresh the resource metrics")
+		}
+	}()
+}
+
+func (c *resourceStatsSource) refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+
+	var rows []resourceStatRow
+	if result := resourceStatsQuery(c.db.WithContext(ctx)).Scan(&rows); result.Error != nil {
+		return InterpretDBError("resource stats", result.Error)
+	}
+
+	stats := make(map[resourceStatKey]resourceStat, len(rows))
+	for _, row := range rows {
+		key := resourceStatKey{cluster: row.Cluster, group: row.Group, resource: row.Resource}
+		stat := stats[key]
+		stat.count += row.Count
+		stat.bytes += row.Bytes
+		if syncedAt := time.Time(row.SyncedAt); syncedAt.After(stat.syncedAt) {
+			stat.syncedAt = syncedAt
+		}
+		stats[key] = stat
+	}
+
+	tableBytes, err := resourcesTableBytes(c.db.WithContext(ctx))
+	if err != nil {
+		return InterpretDBError("resources table size", err)
+	}
+
+	c.lock.Lock()
+	c.stats = stats
+	c.tableBytes = tableBytes
+	c.refreshedAt = time.Now()
+	c.lock.Unlock()
+	return nil
+}
+
+// resourceStatsQuery groups the resources in the order of the `uni_group_version_resource_cluster_namespace_name` index.
+//
+// The size of the objects is the length of the encoded objects, on postgres it is the stored size of the jsonb,
+// which may be compressed.
+func resourceStatsQuery(db *gorm.DB) *gorm.DB {
+	db = db.Model(&Resource{})
+	switch db.Dialector.Name() {
+	case "sqlite", "sqlite3", "mysql":
+		return db.Select("`group`, version, resource, cluster, COUNT(*) AS count, COALESCE(SUM(LENGTH(object)), 0) AS bytes, MAX(synced_at) AS synced_at").
+			Group("`group`, version, resource, cluster")
+	case "postgres":
+		return db.Select(`"group", version, resource, cluster, COUNT(*) AS count, COALESCE(SUM(pg_column_size(object)), 0) AS bytes, MAX(synced_at) AS synced_at`).
+			Group(`"group", version, resource, cluster`)
+	default:
+		panic("storage: only support sqlite3, mysql or postgres")
+	}
+}
+
+// resourcesTableBytes returns the total size of the resources table, it is nil except for postgres.
+func resourcesTableBytes(db *gorm.DB) (*int64, error) {
+	if db.Dialector.Name() != "postgres" {
+		return nil, nil
+	}
+
+	var size int64
+	if err := db.Raw("SELECT pg_total_relation_size(?::regclass)", "resources").Scan(&size).Error; err != nil {
+		return nil, err
+	}
+	return &size, nil
+}
+
+func (c *resourceStatsSource) databaseStats() storage.DatabaseStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := storage.DatabaseStats{
+		Name:        c.name,
+		Dialect:     c.dialect,
+		TableBytes:  c.tableBytes,
+		RefreshedAt: c.refreshedAt,
+		Resources:   make([]storage.ResourceStats, 0, len(c.stats)),
+	}
+	for key, stat := range c.stats {
+		resource := storage.ResourceStats{
+			Cluster:  key.cluster,
+			Group:    key.group,
+			Resource: key.resource,
+			Count:    stat.count,
+			Bytes:    stat.bytes,
+			SyncedAt: stat.syncedAt,
+		}
+		if stat.count > 0 {
+			resource.AvgBytes = stat.bytes / stat.count
+		}
+		stats.Resources = append(stats.Resources, resource)
+	}
+	sort.Slice(stats.Resources, func(i, j int) bool {
+		a, b := stats.Resources[i], stats.Resources[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Resource < b.Resource
+	})
+	return stats
+}
+
+func (c *resourceStatsSource) collect(ch chan<- prometheus.Metric) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for key, stat := range c.stats {
+		ch <- prometheus.MustNewConstMetric(storedResourcesDesc, prometheus.GaugeValue, float64(stat.count), c.name, c.dialect, key.cluster, key.group, key.resource)
+		ch <- prometheus.MustNewConstMetric(storedResourcesBytesDesc, prometheus.GaugeValue, float64(stat.bytes), c.name, c.dialect, key.cluster, key.group, key.resource)
+		if !stat.syncedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(resourceSyncedAtDesc, prometheus.GaugeValue, float64(stat.syncedAt.Unix()), c.name, c.dialect, key.cluster, key.group, key.resource)
+		}
+	}
+	if c.tableBytes != nil {
+		ch <- prometheus.MustNewConstMetric(resourcesTableBytesDesc, prometheus.GaugeValue, float64(*c.tableBytes), c.name, c.dialect)
+	}
+}
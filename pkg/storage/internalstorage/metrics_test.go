@@ -0,0 +1,148 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestResourceMetricsCollector(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	syncedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	resources := []struct {
+		cluster  string
+		group    string
+		version  string
+		resource string
+		syncedAt time.Time
+	}{
+		{"cluster-1", "apps", "v1", "deployments", syncedAt},
+		{"cluster-1", "apps", "v1", "deployments", syncedAt.Add(time.Minute)},
+		// the different versions are merged into one stat
+		{"cluster-1", "apps", "v1beta1", "deployments", syncedAt.Add(2 * time.Minute)},
+		{"cluster-1", "", "v1", "pods", syncedAt},
+		{"cluster-2", "", "v1", "pods", syncedAt.Add(time.Hour)},
+	}
+	for i, r := range resources {
+		require.NoError(t, db.Create(&Resource{
+			Cluster:         r.cluster,
+			Group:           r.group,
+			Version:         r.version,
+			Resource:        r.resource,
+			Kind:            "Kind",
+			Namespace:       "default",
+			Name:            fmt.Sprintf("resource-%d", i),
+			UID:             types.UID(fmt.Sprintf("uid-%d", i)),
+			ResourceVersion: "1",
+			Object:          []byte("{}"),
+		}).Error)
+
+		// the synced_at is updated automatically on create
+		require.NoError(t, db.Model(&Resource{}).Where("uid = ?", fmt.Sprintf("uid-%d", i)).
+			UpdateColumn("synced_at", r.syncedAt).Error)
+	}
+
+	source := newResourceStatsSource(DefaultDatabaseName, db, time.Minute)
+	require.NoError(t, source.refresh(context.Background()))
+	collector := &resourceMetricsCollector{sources: map[string]*resourceStatsSource{"default/sqlite": source}}
+
+	expected := fmt.Sprintf(`
+# HELP clusterpedia_resource_synced_at_seconds Unix timestamp of the latest synced resource in the storage.
+# TYPE clusterpedia_resource_synced_at_seconds gauge
+clusterpedia_resource_synced_at_seconds{cluster="cluster-1",db_name="default",dialect="sqlite",group="",resource="pods"} %d
+clusterpedia_resource_synced_at_seconds{cluster="cluster-1",db_name="default",dialect="sqlite",group="apps",resource="deployments"} %d
+clusterpedia_resource_synced_at_seconds{cluster="cluster-2",db_name="default",dialect="sqlite",group="",resource="pods"} %d
+# HELP clusterpedia_stored_resources Number of the resources stored in the storage.
+# TYPE clusterpedia_stored_resources gauge
+clusterpedia_stored_resources{cluster="cluster-1",db_name="default",dialect="sqlite",group="",resource="pods"} 1
+clusterpedia_stored_resources{cluster="cluster-1",db_name="default",dialect="sqlite",group="apps",resource="deployments"} 3
+clusterpedia_stored_resources{cluster="cluster-2",db_name="default",dialect="sqlite",group="",resource="pods"} 1
+`, syncedAt.Unix(), syncedAt.Add(2*time.Minute).Unix(), syncedAt.Add(time.Hour).Unix())
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"clusterpedia_stored_resources", "clusterpedia_resource_synced_at_seconds"))
+
+	expected = `
+# HELP clusterpedia_stored_resources_bytes Total size in bytes of the encoded objects of the resources stored in the storage.
+# TYPE clusterpedia_stored_resources_bytes gauge
+clusterpedia_stored_resources_bytes{cluster="cluster-1",db_name="default",dialect="sqlite",group="",resource="pods"} 2
+clusterpedia_stored_resources_bytes{cluster="cluster-1",db_name="default",dialect="sqlite",group="apps",resource="deployments"} 6
+clusterpedia_stored_resources_bytes{cluster="cluster-2",db_name="default",dialect="sqlite",group="",resource="pods"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"clusterpedia_stored_resources_bytes", "clusterpedia_resources_table_bytes"))
+
+	factory := &StorageFactory{db: db}
+	_, err = factory.StorageStats()
+	assert.Error(t, err)
+
+	factory.stats = []*resourceStatsSource{source}
+	stats, err := factory.StorageStats()
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, DefaultDatabaseName, stats[0].Name)
+	assert.Nil(t, stats[0].TableBytes)
+	assert.False(t, stats[0].RefreshedAt.IsZero())
+	expectedStats := []storage.ResourceStats{
+		{Cluster: "cluster-1", Group: "", Resource: "pods", Count: 1, Bytes: 2, AvgBytes: 2, SyncedAt: syncedAt},
+		{Cluster: "cluster-1", Group: "apps", Resource: "deployments", Count: 3, Bytes: 6, AvgBytes: 2, SyncedAt: syncedAt.Add(2 * time.Minute)},
+		{Cluster: "cluster-2", Group: "", Resource: "pods", Count: 1, Bytes: 2, AvgBytes: 2, SyncedAt: syncedAt.Add(time.Hour)},
+	}
+	require.Len(t, stats[0].Resources, len(expectedStats))
+	for i, stat := range stats[0].Resources {
+		assert.True(t, expectedStats[i].SyncedAt.Equal(stat.SyncedAt))
+		stat.SyncedAt = expectedStats[i].SyncedAt
+		assert.Equal(t, expectedStats[i], stat)
+	}
+}
+
+func TestResourceMetricsCollectorSkipRunningRefresh(t *testing.T) {
+	source := newResourceStatsSource(DefaultDatabaseName, nil, time.Minute)
+	source.refreshing.Store(true)
+
+	// the refresh with nil db would panic if it is not skipped
+	source.tryRefresh(context.Background())
+	assert.True(t, source.refreshing.Load())
+}
+
+func TestRegisterResourceMetricsIdempotent(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	require.NoError(t, db.Create(&Resource{
+		Cluster: "cluster-1", Version: "v1", Resource: "pods", Kind: "Pod",
+		Namespace: "default", Name: "pod-1", UID: "uid-1", ResourceVersion: "1", Object: []byte("{}"),
+	}).Error)
+
+	registry := prometheus.NewRegistry()
+	config := &MetricsConfig{RefreshInterval: time.Hour, Registerer: registry}
+
+	// the storages share the collector of the registerer,
+	// and the storage of the same dialect replaces the previous one.
+	_, err = registerResourceMetrics(DefaultDatabaseName, db, config)
+	require.NoError(t, err)
+	_, err = registerResourceMetrics(DefaultDatabaseName, db, config)
+	require.NoError(t, err)
+
+	expected := `
+# HELP clusterpedia_stored_resources Number of the resources stored in the storage.
+# TYPE clusterpedia_stored_resources gauge
+clusterpedia_stored_resources{cluster="cluster-1",db_name="default",dialect="sqlite",group="",resource="pods"} 1
+`
+	assert.Eventually(t, func() bool {
+		return testutil.GatherAndCompare(registry, strings.NewReader(expected), "clusterpedia_stored_resources") == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,354 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/tracing"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+var resourceStorageOperationDuration = metrics.NewHistogramVec(
+	&metrics.HistogramOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "resource_storage_operation_duration_seconds",
+		Help:           "Duration of the operations of the resource storages wrapped by the metrics middleware, partitioned by the group, the resource, the operation and the result, one of success and error.",
+		Buckets:        metrics.DefBuckets,
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"group", "resource", "operation", "result"},
+)
+
+func init() {
+	legacyregistry.MustRegister(resourceStorageOperationDuration)
+
+	RegisterResourceStorageMiddleware("metrics", newMetricsResourceStorage)
+	RegisterResourceStorageMiddleware("tracing", newTracingResourceStorage)
+}
+
+// ChainableResourceStorage is the resource storage with the optional interfaces implemented by the resource storages
+// of the internalstorage, the middlewares wrap all of them, so the optional interfaces asserted by the callers, e.g. the
+// storage.ResourceStreamer, are called through the chain. A middleware which is only interested in some methods embeds
+// the next resource storage, and the other methods are forwarded to it.
+type ChainableResourceStorage interface {
+	storage.ResourceStorage
+	storage.PreconditionDeleter
+	storage.LatestResourceVersionGetter
+	storage.ResourceCounter
+	storage.ResourceStreamer
+	storage.ResourceAggregator
+	storage.ResourceRequestsAggregator
+	storage.ResourceMetadataGetter
+	storage.ResourceHistoryReader
+	storage.ResourceStorageCloser
+}
+
+var _ ChainableResourceStorage = &ResourceStorage{}
+
+// ResourceStorageMiddleware wraps the next resource storage of the chain, e.g. to record the metrics of the operations.
+// The config is the config of the wrapped resource storage.
+type ResourceStorageMiddleware func(config *storage.ResourceStorageConfig, next ChainableResourceStorage) ChainableResourceStorage
+
+var resourceStorageMiddlewares = make(map[string]ResourceStorageMiddleware)
+
+// defaultMiddlewares are the middlewares of the resource storages if the Middlewares of the config is unset,
+// the spans of the operations are started by the tracing middleware.
+var defaultMiddlewares = []string{"tracing"}
+
+// RegisterResourceStorageMiddleware registers the middleware by the name, so it can be configured by the Middlewares
+// of the config, e.g. the out-of-tree middlewares registered by the init of their packages. The built-in middlewares
+// are metrics and tracing.
+func RegisterResourceStorageMiddleware(name string, middleware ResourceStorageMiddleware) {
+	if _, ok := resourceStorageMiddlewares[name]; ok {
+		panic(fmt.Sprintf("resource storage middleware %s has been registered", name))
+	}
+	resourceStorageMiddlewares[name] = middleware
+}
+
+// middlewareChain is the ordered middlewares of the resource storages, the first one is the outermost.
+type middlewareChain []ResourceStorageMiddleware
+
+func newMiddlewareChain(names []string) (middlewareChain, error) {
+	chain := make(middlewareChain, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		middleware, ok := resourceStorageMiddlewares[name]
+		if !ok {
+			return nil, fmt.Errorf("resource storage middleware %s is unregistered", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("resource storage middleware %s is duplicated", name)
+		}
+		seen[name] = true
+		chain = append(chain, middleware)
+	}
+	return chain, nil
+}
+
+// wrap wraps the resource storage by the chain, the resource storage is returned as is if the chain is empty.
+func (c middlewareChain) wrap(config *storage.ResourceStorageConfig, rs *ResourceStorage) ChainableResourceStorage {
+	var next ChainableResourceStorage = rs
+	for i := len(c) - 1; i >= 0; i-- {
+		next = c[i](config, next)
+	}
+	return next
+}
+
+// metricsResourceStorage records the durations of the operations, the duration of the watch is the duration to start it.
+type metricsResourceStorage struct {
+	ChainableResourceStorage
+
+	group, resource string
+}
+
+func newMetricsResourceStorage(config *storage.ResourceStorageConfig, next ChainableResourceStorage) ChainableResourceStorage {
+	return &metricsResourceStorage{
+		ChainableResourceStorage: next,
+		group:                    config.StorageGroupResource.Group,
+		resource:                 config.StorageGroupResource.Resource,
+	}
+}
+
+func (s *metricsResourceStorage) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	resourceStorageOperationDuration.WithLabelValues(s.group, s.resource, operation, result).Observe(time.Since(start).Seconds())
+}
+
+func (s *metricsResourceStorage) Get(ctx context.Context, cluster, namespace, name string, obj runtime.Object) (err error) {
+	defer func(start time.Time) { s.observe("get", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Get(ctx, cluster, namespace, name, obj)
+}
+
+func (s *metricsResourceStorage) List(ctx context.Context, listObj runtime.Object, opts *internal.ListOptions) (err error) {
+	defer func(start time.Time) { s.observe("list", start, err) }(time.Now())
+	return s.ChainableResourceStorage.List(ctx, listObj, opts)
+}
+
+func (s *metricsResourceStorage) Watch(ctx context.Context, opts *internal.ListOptions) (w watch.Interface, err error) {
+	defer func(start time.Time) { s.observe("watch", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Watch(ctx, opts)
+}
+
+func (s *metricsResourceStorage) Create(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	defer func(start time.Time) { s.observe("create", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Create(ctx, cluster, obj)
+}
+
+func (s *metricsResourceStorage) Update(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	defer func(start time.Time) { s.observe("update", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Update(ctx, cluster, obj)
+}
+
+func (s *metricsResourceStorage) Delete(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	defer func(start time.Time) { s.observe("delete", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Delete(ctx, cluster, obj)
+}
+
+func (s *metricsResourceStorage) DeleteWithPreconditions(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) (err error) {
+	defer func(start time.Time) { s.observe("delete", start, err) }(time.Now())
+	return s.ChainableResourceStorage.DeleteWithPreconditions(ctx, cluster, namespace, name, preconditions)
+}
+
+func (s *metricsResourceStorage) GetLatestResourceVersion(ctx context.Context, cluster string) (_ string, err error) {
+	defer func(start time.Time) { s.observe("get_latest_resource_version", start, err) }(time.Now())
+	return s.ChainableResourceStorage.GetLatestResourceVersion(ctx, cluster)
+}
+
+func (s *metricsResourceStorage) CountResources(ctx context.Context, cluster string) (_ int64, err error) {
+	defer func(start time.Time) { s.observe("count", start, err) }(time.Now())
+	return s.ChainableResourceStorage.CountResources(ctx, cluster)
+}
+
+func (s *metricsResourceStorage) ListStream(ctx context.Context, newObject func() runtime.Object, opts *internal.ListOptions, visitor storage.ListVisitor) (err error) {
+	defer func(start time.Time) { s.observe("list_stream", start, err) }(time.Now())
+	return s.ChainableResourceStorage.ListStream(ctx, newObject, opts, visitor)
+}
+
+func (s *metricsResourceStorage) ListNamespaces(ctx context.Context, opts *internal.ListOptions) (_ []string, err error) {
+	defer func(start time.Time) { s.observe("list_namespaces", start, err) }(time.Now())
+	return s.ChainableResourceStorage.ListNamespaces(ctx, opts)
+}
+
+func (s *metricsResourceStorage) ListClusters(ctx context.Context, opts *internal.ListOptions) (_ []string, err error) {
+	defer func(start time.Time) { s.observe("list_clusters", start, err) }(time.Now())
+	return s.ChainableResourceStorage.ListClusters(ctx, opts)
+}
+
+func (s *metricsResourceStorage) SumResourceRequests(ctx context.Context, opts *internal.ListOptions) (_ []storage.ResourceRequestsSummary, err error) {
+	defer func(start time.Time) { s.observe("sum_resource_requests", start, err) }(time.Now())
+	return s.ChainableResourceStorage.SumResourceRequests(ctx, opts)
+}
+
+func (s *metricsResourceStorage) GetMetadata(ctx context.Context, cluster, namespace, name string, into *metav1.PartialObjectMetadata) (err error) {
+	defer func(start time.Time) { s.observe("get_metadata", start, err) }(time.Now())
+	return s.ChainableResourceStorage.GetMetadata(ctx, cluster, namespace, name, into)
+}
+
+func (s *metricsResourceStorage) GetAt(ctx context.Context, cluster, namespace, name string, at time.Time, into runtime.Object) (err error) {
+	defer func(start time.Time) { s.observe("get_at", start, err) }(time.Now())
+	return s.ChainableResourceStorage.GetAt(ctx, cluster, namespace, name, at, into)
+}
+
+func (s *metricsResourceStorage) ListRevisions(ctx context.Context, cluster, namespace, name string) (_ []storage.ResourceRevision, err error) {
+	defer func(start time.Time) { s.observe("list_revisions", start, err) }(time.Now())
+	return s.ChainableResourceStorage.ListRevisions(ctx, cluster, namespace, name)
+}
+
+func (s *metricsResourceStorage) Flush(ctx context.Context) (err error) {
+	defer func(start time.Time) { s.observe("flush", start, err) }(time.Now())
+	return s.ChainableResourceStorage.Flush(ctx)
+}
+
+// tracingResourceStorage starts the spans of the operations, the resource storage adds the attributes of the results
+// to them, e.g. the rows affected by the writes, and starts the child spans of the steps, e.g. building the list query.
+type tracingResourceStorage struct {
+	ChainableResourceStorage
+
+	gvr, scope    string
+	clusterScoped bool
+}
+
+func newTracingResourceStorage(config *storage.ResourceStorageConfig, next ChainableResourceStorage) ChainableResourceStorage {
+	scope := "Cluster"
+	if config.Namespaced {
+		scope = "Namespaced"
+	}
+	return &tracingResourceStorage{
+		ChainableResourceStorage: next,
+		gvr:                      config.StorageGroupResource.WithVersion(config.StorageVersion.Version).String(),
+		scope:                    scope,
+		clusterScoped:            !config.Namespaced,
+	}
+}
+
+func (s *tracingResourceStorage) attributes(cluster string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cluster", cluster),
+		attribute.String("gvr", s.gvr),
+		attribute.String("scope", s.scope),
+	}
+}
+
+// objectAttributes are the span attributes of the requests of an object, the cluster-scoped object has no namespace.
+func (s *tracingResourceStorage) objectAttributes(cluster, namespace, name string) []attribute.KeyValue {
+	attributes := s.attributes(cluster)
+	if !s.clusterScoped {
+		attributes = append(attributes, attribute.String("namespace", namespace))
+	}
+	return append(attributes, attribute.String("name", name))
+}
+
+func (s *tracingResourceStorage) listAttributes(opts *internal.ListOptions) []attribute.KeyValue {
+	return s.attributes(strings.Join(opts.ClusterNames, ","))
+}
+
+func (s *tracingResourceStorage) Get(ctx context.Context, cluster, namespace, name string, obj runtime.Object) (err error) {
+	ctx, span := tracing.Start(ctx, "Get resource", s.objectAttributes(cluster, namespace, name)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.Get(ctx, cluster, namespace, name, obj)
+}
+
+func (s *tracingResourceStorage) List(ctx context.Context, listObj runtime.Object, opts *internal.ListOptions) (err error) {
+	ctx, span := tracing.Start(ctx, "List resources", s.listAttributes(opts)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.List(ctx, listObj, opts)
+}
+
+func (s *tracingResourceStorage) Watch(ctx context.Context, opts *internal.ListOptions) (w watch.Interface, err error) {
+	spanCtx, span := tracing.Start(ctx, "Watch resources", s.listAttributes(opts)...)
+	defer func() { endSpan(spanCtx, span, err) }()
+	// the watch outlives the span, it isn't started with the context of the span
+	return s.ChainableResourceStorage.Watch(ctx, opts)
+}
+
+func (s *tracingResourceStorage) Create(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	ctx, span := tracing.Start(ctx, "Create resource", s.attributes(cluster)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.Create(ctx, cluster, obj)
+}
+
+func (s *tracingResourceStorage) Update(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	ctx, span := tracing.Start(ctx, "Update resource", s.attributes(cluster)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.Update(ctx, cluster, obj)
+}
+
+func (s *tracingResourceStorage) Delete(ctx context.Context, cluster string, obj runtime.Object) (err error) {
+	ctx, span := tracing.Start(ctx, "Delete resource", s.attributes(cluster)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.Delete(ctx, cluster, obj)
+}
+
+func (s *tracingResourceStorage) DeleteWithPreconditions(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) (err error) {
+	ctx, span := tracing.Start(ctx, "Delete resource", s.objectAttributes(cluster, namespace, name)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.DeleteWithPreconditions(ctx, cluster, namespace, name, preconditions)
+}
+
+func (s *tracingResourceStorage) GetLatestResourceVersion(ctx context.Context, cluster string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "Get latest resource version", s.attributes(cluster)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.GetLatestResourceVersion(ctx, cluster)
+}
+
+func (s *tracingResourceStorage) CountResources(ctx context.Context, cluster string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Count resources", s.attributes(cluster)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.CountResources(ctx, cluster)
+}
+
+func (s *tracingResourceStorage) ListStream(ctx context.Context, newObject func() runtime.Object, opts *internal.ListOptions, visitor storage.ListVisitor) (err error) {
+	ctx, span := tracing.Start(ctx, "List resources stream", s.listAttributes(opts)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.ListStream(ctx, newObject, opts, visitor)
+}
+
+func (s *tracingResourceStorage) ListNamespaces(ctx context.Context, opts *internal.ListOptions) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "List distinct namespace", s.listAttributes(opts)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.ListNamespaces(ctx, opts)
+}
+
+func (s *tracingResourceStorage) ListClusters(ctx context.Context, opts *internal.ListOptions) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "List distinct cluster", s.listAttributes(opts)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.ListClusters(ctx, opts)
+}
+
+func (s *tracingResourceStorage) SumResourceRequests(ctx context.Context, opts *internal.ListOptions) (_ []storage.ResourceRequestsSummary, err error) {
+	ctx, span := tracing.Start(ctx, "Sum resource requests", s.listAttributes(opts)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.SumResourceRequests(ctx, opts)
+}
+
+func (s *tracingResourceStorage) GetMetadata(ctx context.Context, cluster, namespace, name string, into *metav1.PartialObjectMetadata) (err error) {
+	ctx, span := tracing.Start(ctx, "Get resource metadata", s.objectAttributes(cluster, namespace, name)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.GetMetadata(ctx, cluster, namespace, name, into)
+}
+
+func (s *tracingResourceStorage) GetAt(ctx context.Context, cluster, namespace, name string, at time.Time, into runtime.Object) (err error) {
+	ctx, span := tracing.Start(ctx, "Get resource at", s.objectAttributes(cluster, namespace, name)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.GetAt(ctx, cluster, namespace, name, at, into)
+}
+
+func (s *tracingResourceStorage) ListRevisions(ctx context.Context, cluster, namespace, name string) (_ []storage.ResourceRevision, err error) {
+	ctx, span := tracing.Start(ctx, "List resource revisions", s.objectAttributes(cluster, namespace, name)...)
+	defer func() { endSpan(ctx, span, err) }()
+	return s.ChainableResourceStorage.ListRevisions(ctx, cluster, namespace, name)
+}
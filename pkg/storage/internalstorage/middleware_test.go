@@ -0,0 +1,104 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+// recordingResourceStorage records the creates, the gets and the counts passing through it.
+type recordingResourceStorage struct {
+	ChainableResourceStorage
+
+	name  string
+	calls *[]string
+}
+
+func (s *recordingResourceStorage) Create(ctx context.Context, cluster string, obj runtime.Object) error {
+	*s.calls = append(*s.calls, s.name+" create")
+	return s.ChainableResourceStorage.Create(ctx, cluster, obj)
+}
+
+func (s *recordingResourceStorage) Get(ctx context.Context, cluster, namespace, name string, obj runtime.Object) error {
+	*s.calls = append(*s.calls, s.name+" get")
+	return s.ChainableResourceStorage.Get(ctx, cluster, namespace, name, obj)
+}
+
+func (s *recordingResourceStorage) CountResources(ctx context.Context, cluster string) (int64, error) {
+	*s.calls = append(*s.calls, s.name+" count")
+	return s.ChainableResourceStorage.CountResources(ctx, cluster)
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var calls []string
+	for _, name := range []string{"test-outer", "test-inner"} {
+		name := name
+		RegisterResourceStorageMiddleware(name, func(_ *storage.ResourceStorageConfig, next ChainableResourceStorage) ChainableResourceStorage {
+			return &recordingResourceStorage{ChainableResourceStorage: next, name: name, calls: &calls}
+		})
+	}
+	defer delete(resourceStorageMiddlewares, "test-outer")
+	defer delete(resourceStorageMiddlewares, "test-inner")
+	assert.Panics(t, func() { RegisterResourceStorageMiddleware("metrics", newMetricsResourceStorage) })
+
+	_, err := newMiddlewareChain([]string{"test-outer", "unknown"})
+	assert.Error(t, err)
+	_, err = newMiddlewareChain([]string{"metrics", "metrics"})
+	assert.Error(t, err)
+
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	rs := newTestResourceStorage(db, gvr)
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gvr.GroupResource(), true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	empty, err := newMiddlewareChain(nil)
+	require.NoError(t, err)
+	assert.Same(t, rs, empty.wrap(config, rs))
+
+	chain, err := newMiddlewareChain([]string{"test-outer", "metrics", "tracing", "test-inner"})
+	require.NoError(t, err)
+	wrapped := chain.wrap(config, rs)
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", UID: "uid-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, wrapped.Create(context.Background(), "cluster-1", deploy))
+	require.NoError(t, wrapped.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+	assert.Error(t, wrapped.Get(context.Background(), "cluster-1", "default", "deploy-2", &appsv1.Deployment{}))
+	assert.Equal(t, []string{"test-outer create", "test-inner create", "test-outer get", "test-inner get", "test-outer get", "test-inner get"}, calls)
+
+	for result, count := range map[string]uint64{"success": 1, "error": 1} {
+		observed, err := testutil.GetHistogramMetricCount(resourceStorageOperationDuration.WithLabelValues(appsv1.GroupName, "deployments", "get", result))
+		require.NoError(t, err)
+		assert.Equal(t, count, observed, result)
+	}
+
+	// the optional interfaces are called through the middlewares
+	calls = nil
+	var counter storage.ResourceCounter = wrapped
+	count, err := counter.CountResources(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, []string{"test-outer count", "test-inner count"}, calls)
+	observed, err := testutil.GetHistogramMetricCount(resourceStorageOperationDuration.WithLabelValues(appsv1.GroupName, "deployments", "count", "success"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), observed)
+
+	assert.Equal(t, schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, wrapped.GetStorageConfig().StorageGroupResource)
+}
@@ -0,0 +1,195 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"k8s.io/klog/v2"
+)
+
+// AutoMigrateMode is the mode to migrate the schema of the database at startup.
+type AutoMigrateMode string
+
+const (
+	// AutoMigrateOff doesn't change the schema, the operators migrate the schema manually.
+	AutoMigrateOff AutoMigrateMode = "off"
+
+	// AutoMigrateSafe only applies the additive migrations which don't block the writes,
+	// the DDL of the other migrations is logged to be applied manually.
+	AutoMigrateSafe AutoMigrateMode = "safe"
+
+	// AutoMigrateFull applies all migrations and auto migrates the tables by the models.
+	AutoMigrateFull AutoMigrateMode = "full"
+)
+
+// SchemaMigration is the migration applied to the database.
+type SchemaMigration struct {
+	Version   uint      `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// migration is a versioned step of the schema, the features need the new tables or columns
+// register the migrations instead of only editing the tags of the models.
+type migration struct {
+	version uint
+	name    string
+
+	// additive is true if the migration only creates the tables or adds the columns without blocking the writes,
+	// e.g. adding the index to the existing table is not additive.
+	additive bool
+
+	// migrate applies the migration, the DDL of the migration that isn't additive is recorded by the dry run,
+	// so it should only execute the DDL without querying the database.
+	migrate func(db *gorm.DB) error
+}
+
+var migrations = map[uint]migration{}
+
+// registerMigration registers the migration, the migrations are applied in the order of the versions.
+func registerMigration(m migration) {
+	if _, ok := migrations[m.version]; ok {
+		panic(fmt.Sprintf("internalstorage: migration %d is registered twice", m.version))
+	}
+	migrations[m.version] = m
+}
+
+func sortedMigrations() []migration {
+	sorted := make([]migration, 0, len(migrations))
+	for _, m := range migrations {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].version < sorted[j].version })
+	return sorted
+}
+
+func init() {
+	registerMigration(migration{
+		version:  1,
+		name:     "create the resources table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			// the primary key is allocated by AUTO_RANDOM on TiDB
+			var resource interface{} = &Resource{}
+			if isTiDB(db) {
+				resource = &tidbResource{}
+			}
+			return createTableIfNotExists(db, resource)
+		},
+	})
+	registerMigration(migration{
+		version:  3,
+		name:     "add the metadata column to the resources table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return addColumnIfNotExists(db, &Resource{}, "Metadata")
+		},
+	})
+}
+
+// createTableIfNotExists creates the table, the existence isn't checked in the dry run,
+// which can't query the database.
+func createTableIfNotExists(db *gorm.DB, model interface{}) error {
+	if !db.DryRun && db.Migrator().HasTable(model) {
+		return nil
+	}
+	return db.Migrator().CreateTable(model)
+}
+
+// addColumnIfNotExists adds the nullable column of the field, the existence isn't checked in the dry run.
+func addColumnIfNotExists(db *gorm.DB, model interface{}, field string) error {
+	if !db.DryRun && db.Migrator().HasColumn(model, field) {
+		return nil
+	}
+	return db.Migrator().AddColumn(model, field)
+}
+
+// migrateSchema applies the pending migrations by the mode, the migrations are applied in order,
+// and the migrations after the one which isn't applied in the safe mode are left pending.
+func migrateSchema(db *gorm.DB, mode AutoMigrateMode, migrations []migration) error {
+	switch mode {
+	case AutoMigrateOff:
+		klog.InfoS("The auto migration of the schema is disabled")
+		return nil
+	case AutoMigrateSafe, AutoMigrateFull:
+	default:
+		return fmt.Errorf("autoMigrate must be one of [off, safe, full], got %q", mode)
+	}
+
+	if err := createTableIfNotExists(db, &SchemaMigration{}); err != nil {
+		return err
+	}
+
+	var applied []SchemaMigration
+	if result := db.Find(&applied); result.Error != nil {
+		return result.Error
+	}
+	versions := make(map[uint]struct{}, len(applied))
+	for _, m := range applied {
+		versions[m.Version] = struct{}{}
+	}
+
+	var pending []migration
+	for _, m := range migrations {
+		if _, ok := versions[m.version]; ok {
+			continue
+		}
+		if len(pending) != 0 || (mode == AutoMigrateSafe && !m.additive) {
+			pending = append(pending, m)
+			continue
+		}
+
+		if err := m.migrate(db); err != nil {
+			return fmt.Errorf("migration %d(%s): %w", m.version, m.name, err)
+		}
+		// the migration may be recorded by the other instances at the same time
+		record := SchemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now()}
+		if result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record); result.Error != nil {
+			return fmt.Errorf("migration %d(%s): failed to record: %w", m.version, m.name, result.Error)
+		}
+		klog.InfoS("Applied the migration of the schema", "version", m.version, "name", m.name)
+	}
+
+	for _, m := range pending {
+		ddl, err := dryRunMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("migration %d(%s): failed to generate the DDL: %w", m.version, m.name, err)
+		}
+		klog.InfoS("The migration of the schema is pending, apply the DDL manually and insert the version into the schema_migrations table",
+			"version", m.version, "name", m.name, "additive", m.additive, "ddl", ddl)
+	}
+	if mode == AutoMigrateFull {
+		return autoMigrate(db)
+	}
+	return nil
+}
+
+// dryRunMigration returns the DDL of the migration without executing it.
+func dryRunMigration(db *gorm.DB, m migration) ([]string, error) {
+	recorder := &ddlRecorder{Interface: logger.Discard}
+	if err := m.migrate(db.Session(&gorm.Session{DryRun: true, Logger: recorder})); err != nil {
+		return nil, err
+	}
+	return recorder.statements, nil
+}
+
+// ddlRecorder records the statements executed by the dry run.
+type ddlRecorder struct {
+	logger.Interface
+
+	statements []string
+}
+
+func (r *ddlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+func (r *ddlRecorder) Trace(_ context.Context, _ time.Time, fc func() (sql string, rowsAffected int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
@@ -0,0 +1,51 @@
+package internalstorage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMigrateSchema(t *testing.T) {
+	db, err := gorm.Open(gsqlite.Open(filepath.Join(t.TempDir(), "test.db")))
+	require.NoError(t, err)
+
+	addIndex := migration{
+		version: 100,
+		name:    "add the index of the uid",
+		migrate: func(db *gorm.DB) error {
+			return db.Exec("CREATE INDEX idx_uid ON resources (uid)").Error
+		},
+	}
+	steps := append(sortedMigrations(), addIndex)
+
+	require.NoError(t, migrateSchema(db, AutoMigrateOff, steps))
+	assert.False(t, db.Migrator().HasTable(&SchemaMigration{}))
+
+	appliedVersions := func() []uint {
+		var versions []uint
+		require.NoError(t, db.Model(&SchemaMigration{}).Order("version").Pluck("version", &versions).Error)
+		return versions
+	}
+
+	// the index isn't additive, it is left pending in the safe mode
+	require.NoError(t, migrateSchema(db, AutoMigrateSafe, steps))
+	assert.True(t, db.Migrator().HasTable(&Resource{}))
+	assert.True(t, db.Migrator().HasTable(&Checkpoint{}))
+	assert.False(t, db.Migrator().HasIndex(&Resource{}, "idx_uid"))
+	assert.Equal(t, []uint{1, 2, 3}, appliedVersions())
+
+	ddl, err := dryRunMigration(db, addIndex)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"CREATE INDEX idx_uid ON resources (uid)"}, ddl)
+
+	require.NoError(t, migrateSchema(db, AutoMigrateFull, steps))
+	assert.True(t, db.Migrator().HasIndex(&Resource{}, "idx_uid"))
+	assert.Equal(t, []uint{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 100}, appliedVersions())
+
+	assert.Error(t, migrateSchema(db, "unknown", steps))
+}
@@ -0,0 +1,412 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NotificationSignatureHeader is the header of the hex encoded HMAC-SHA256 signature of the payload
+	// signed by the secret of the webhook, in the format of `sha256=<signature>`.
+	NotificationSignatureHeader = "X-Clusterpedia-Signature"
+
+	defaultWebhookQueueSize      = 1000
+	defaultWebhookTimeout        = 10 * time.Second
+	defaultWebhookMaxRetries     = 5
+	defaultWebhookInitialBackoff = time.Second
+	defaultWebhookMaxBackoff     = 30 * time.Second
+)
+
+type NotificationType string
+
+const (
+	NotificationTypeCreated NotificationType = "Created"
+	NotificationTypeUpdated NotificationType = "Updated"
+	NotificationTypeDeleted NotificationType = "Deleted"
+)
+
+var (
+	notificationsDroppedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "notifications_dropped_total",
+			Help:           "Number of the notifications dropped since the queue of the webhook is full or the delivery failed after the retries.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"webhook", "reason"},
+	)
+
+	notificationDeliveriesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "notification_deliveries_total",
+			Help:           "Number of the delivery attempts of the notifications by the result, one of success, retry and failure.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"webhook", "result"},
+	)
+
+	notificationDeliveryDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "notification_delivery_duration_seconds",
+			Help:           "Duration of the delivery attempts of the notifications.",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"webhook"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(notificationsDroppedTotal, notificationDeliveriesTotal, notificationDeliveryDuration)
+}
+
+// NotificationConfig notifies the external automation of the changes of the resources persisted by the storage.
+type NotificationConfig struct {
+	// Webhooks are the webhooks receiving the notifications, the notification is disabled if it is empty.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig posts the notifications of the changes matched by the rules to the url as json,
+// the notifications are queued by the bounded queue and delivered one by one with the retries.
+type WebhookConfig struct {
+	// Name is the name of the webhook in the metrics and logs.
+	Name string `yaml:"name"`
+
+	URL string `yaml:"url"`
+
+	// SecretFile is the file of the secret to sign the payloads, the signature is set to the X-Clusterpedia-Signature header.
+	// The payloads aren't signed if it is empty.
+	SecretFile string `yaml:"secretFile"`
+
+	// Rules select the notified changes, all changes are notified if it is empty.
+	Rules []NotificationRule `yaml:"rules"`
+
+	// QueueSize is the size of the queue of the notifications to be delivered, Default is 1000.
+	// The notifications are dropped and counted by the notifications_dropped_total metric if the queue is full.
+	QueueSize int `yaml:"queueSize"`
+
+	// Timeout is the timeout of each delivery attempt, Default is 10s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// MaxRetries is the max number of the retries of the failed delivery, Default is 5.
+	// The delivery is retried if the request fails or the response is 429 or 5xx.
+	MaxRetries int `yaml:"maxRetries"`
+
+	// InitialBackoff is the backoff before the first retry, which is doubled by each retry up to the MaxBackoff,
+	// Default is 1s and 30s.
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+// NotificationRule matches the changes of the resources, the empty fields match all.
+type NotificationRule struct {
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+
+	Clusters   []string           `yaml:"clusters"`
+	Namespaces []string           `yaml:"namespaces"`
+	Types      []NotificationType `yaml:"types"`
+}
+
+func (rule NotificationRule) matches(notification *ResourceNotification) bool {
+	if (rule.Group != "" && rule.Group != notification.Group) ||
+		(rule.Version != "" && rule.Version != notification.Version) ||
+		(rule.Resource != "" && rule.Resource != notification.Resource) {
+		return false
+	}
+	return matchesAny(rule.Clusters, notification.Cluster) && matchesAny(rule.Namespaces, notification.Metadata.Namespace) &&
+		matchesAny(rule.Types, notification.Type)
+}
+
+func matchesAny[T comparable](values []T, value T) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ResourceNotification is the payload of the notification of the persisted change.
+//
+// The metadata of the deleted object only has the namespace and name, since the deleted object passed by the synchro
+// doesn't have the other fields.
+type ResourceNotification struct {
+	Type     NotificationType  `json:"type"`
+	Cluster  string            `json:"cluster"`
+	Group    string            `json:"group"`
+	Version  string            `json:"version"`
+	Resource string            `json:"resource"`
+	Metadata metav1.ObjectMeta `json:"metadata"`
+	Time     time.Time         `json:"time"`
+}
+
+// NotificationSink receives the notifications after the changes are persisted, the sink shouldn't block the synchro.
+type NotificationSink interface {
+	Notify(ctx context.Context, notification *ResourceNotification)
+}
+
+// newNotificationSink returns nil if the notification is disabled.
+func newNotificationSink(config NotificationConfig) (NotificationSink, error) {
+	if len(config.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	var sinks webhookSinks
+	names := make(map[string]bool, len(config.Webhooks))
+	for _, webhook := range config.Webhooks {
+		if names[webhook.Name] {
+			return nil, fmt.Errorf("notification: duplicate webhook %q", webhook.Name)
+		}
+		names[webhook.Name] = true
+
+		sink, err := newWebhookSink(webhook)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	for _, sink := range sinks {
+		go sink.run()
+	}
+	return sinks, nil
+}
+
+type webhookSinks []*webhookSink
+
+func (sinks webhookSinks) Notify(ctx context.Context, notification *ResourceNotification) {
+	for _, sink := range sinks {
+		sink.Notify(ctx, notification)
+	}
+}
+
+// webhookSink delivers the notifications to the webhook by a goroutine, the notifications are queued by the bounded queue,
+// so the slow webhook doesn't block the synchro.
+type webhookSink struct {
+	name   string
+	url    string
+	secret []byte
+	rules  []NotificationRule
+	client *http.Client
+	queue  chan *ResourceNotification
+
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newWebhookSink(config WebhookConfig) (*webhookSink, error) {
+	if config.Name == "" {
+		return nil, errors.New("notification: webhook name is required")
+	}
+	if config.URL == "" {
+		return nil, fmt.Errorf("notification: webhook %s: url is required", config.Name)
+	}
+
+	var secret []byte
+	if config.SecretFile != "" {
+		data, err := os.ReadFile(config.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("notification: webhook %s: %w", config.Name, err)
+		}
+		if secret = []byte(strings.TrimSpace(string(data))); len(secret) == 0 {
+			return nil, fmt.Errorf("notification: webhook %s: the secret file is empty", config.Name)
+		}
+	}
+
+	queueSize := config.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultWebhookQueueSize
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	maxRetries := config.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultWebhookMaxRetries
+	}
+	initialBackoff := config.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = defaultWebhookInitialBackoff
+	}
+	maxBackoff := config.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultWebhookMaxBackoff
+	}
+
+	return &webhookSink{
+		name:   config.Name,
+		url:    config.URL,
+		secret: secret,
+		rules:  config.Rules,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan *ResourceNotification, queueSize),
+
+		maxRetries:     maxRetries,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		done:           make(chan struct{}),
+	}, nil
+}
+
+func (s *webhookSink) matches(notification *ResourceNotification) bool {
+	if len(s.rules) == 0 {
+		return true
+	}
+	for _, rule := range s.rules {
+		if rule.matches(notification) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *webhookSink) Notify(_ context.Context, notification *ResourceNotification) {
+	if !s.matches(notification) {
+		return
+	}
+
+	select {
+	case s.queue <- notification:
+	default:
+		notificationsDroppedTotal.WithLabelValues(s.name, "queue_full").Inc()
+	}
+}
+
+// run delivers the queued notifications until the sink is closed.
+func (s *webhookSink) run() {
+	defer close(s.done)
+
+	for notification := range s.queue {
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			klog.ErrorS(err, "Failed to encode the notification", "webhook", s.name)
+			notificationsDroppedTotal.WithLabelValues(s.name, "encode_failed").Inc()
+			continue
+		}
+
+		if err := s.deliver(payload); err != nil {
+			klog.ErrorS(err, "Failed to deliver the notification", "webhook", s.name, "type", notification.Type,
+				"cluster", notification.Cluster, "resource", notification.Resource,
+				"namespace", notification.Metadata.Namespace, "name", notification.Metadata.Name)
+			notificationsDroppedTotal.WithLabelValues(s.name, "delivery_failed").Inc()
+		}
+	}
+}
+
+// deliver posts the payload, the failed delivery is retried with the backoff until the retries are exhausted.
+func (s *webhookSink) deliver(payload []byte) error {
+	backoff := s.initialBackoff
+	for retries := 0; ; retries++ {
+		retry, err := s.post(payload)
+		if err == nil {
+			notificationDeliveriesTotal.WithLabelValues(s.name, "success").Inc()
+			return nil
+		}
+		if !retry || retries >= s.maxRetries {
+			notificationDeliveriesTotal.WithLabelValues(s.name, "failure").Inc()
+			return err
+		}
+		notificationDeliveriesTotal.WithLabelValues(s.name, "retry").Inc()
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// post posts the payload once, and returns whether the failed delivery can be retried.
+func (s *webhookSink) post(payload []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.secret) != 0 {
+		req.Header.Set(NotificationSignatureHeader, "sha256="+signPayload(s.secret, payload))
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	notificationDeliveryDuration.WithLabelValues(s.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("the webhook responded %s", resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// close stops the sink after the queued notifications are delivered.
+func (s *webhookSink) close() {
+	s.closeOnce.Do(func() { close(s.queue) })
+	<-s.done
+}
+
+func signPayload(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyChange sends the notification of the persisted change to the notification sink.
+func (s *ResourceStorage) notifyChange(ctx context.Context, notificationType NotificationType, cluster string, metaobj metav1.Object) {
+	if s.notifications == nil {
+		return
+	}
+	s.notifications.Notify(ctx, &ResourceNotification{
+		Type:     notificationType,
+		Cluster:  cluster,
+		Group:    s.storageGroupResource.Group,
+		Version:  s.storageVersion.Version,
+		Resource: s.storageGroupResource.Resource,
+		Metadata: metav1.ObjectMeta{
+			Name:              metaobj.GetName(),
+			Namespace:         metaobj.GetNamespace(),
+			UID:               metaobj.GetUID(),
+			ResourceVersion:   metaobj.GetResourceVersion(),
+			Generation:        metaobj.GetGeneration(),
+			CreationTimestamp: metaobj.GetCreationTimestamp(),
+			DeletionTimestamp: metaobj.GetDeletionTimestamp(),
+			Labels:            metaobj.GetLabels(),
+			Annotations:       metaobj.GetAnnotations(),
+			OwnerReferences:   metaobj.GetOwnerReferences(),
+		},
+		Time: time.Now(),
+	})
+}
@@ -0,0 +1,214 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+// webhookRecorder records the payloads posted to the webhook, and responds the queued status codes before 200.
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads [][]byte
+	headers  []http.Header
+	statuses []int
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	payload, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.statuses) != 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	r.payloads = append(r.payloads, payload)
+	r.headers = append(r.headers, req.Header.Clone())
+}
+
+func TestResourceStorage_Notifications(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	webhook := &webhookRecorder{}
+	server := httptest.NewServer(webhook)
+	defer server.Close()
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0600))
+	sink, err := newWebhookSink(WebhookConfig{
+		Name: "deployments", URL: server.URL, SecretFile: secretFile,
+		Rules: []NotificationRule{{Group: "apps", Resource: "deployments", Namespaces: []string{"default"}}},
+	})
+	require.NoError(t, err)
+	go sink.run()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.notifications = sink
+
+	deploy := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1",
+			Labels: map[string]string{"app": "deploy-1"}},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	deletedObj, err := rs.ConvertDeletedObject(deploy)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+
+	// the changes not matched by the rules and the deletion of the missing object aren't notified
+	other := deploy.DeepCopy()
+	other.Namespace = "kube-system"
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", other))
+	require.NoError(t, rs.Delete(context.Background(), "cluster-1", deletedObj))
+	sink.close()
+
+	require.Len(t, webhook.payloads, 3)
+	for i, expected := range []struct {
+		notificationType NotificationType
+		resourceVersion  string
+	}{
+		{NotificationTypeCreated, "1"},
+		{NotificationTypeUpdated, "2"},
+		{NotificationTypeDeleted, ""},
+	} {
+		var notification ResourceNotification
+		require.NoError(t, json.Unmarshal(webhook.payloads[i], &notification))
+		assert.Equal(t, expected.notificationType, notification.Type)
+		assert.Equal(t, "cluster-1", notification.Cluster)
+		assert.Equal(t, "apps/v1/deployments", notification.Group+"/"+notification.Version+"/"+notification.Resource)
+		assert.Equal(t, "default/deploy-1", notification.Metadata.Namespace+"/"+notification.Metadata.Name)
+		assert.Equal(t, expected.resourceVersion, notification.Metadata.ResourceVersion)
+		assert.False(t, notification.Time.IsZero())
+
+		assert.Equal(t, "application/json", webhook.headers[i].Get("Content-Type"))
+		assert.Equal(t, "sha256="+signPayload([]byte("secret"), webhook.payloads[i]), webhook.headers[i].Get(NotificationSignatureHeader))
+	}
+}
+
+func TestWebhookSink_Retry(t *testing.T) {
+	counter := func(name, result string) float64 {
+		value, err := testutil.GetCounterMetricValue(notificationDeliveriesTotal.WithLabelValues(name, result))
+		require.NoError(t, err)
+		return value
+	}
+	dropped := func(name, reason string) float64 {
+		value, err := testutil.GetCounterMetricValue(notificationsDroppedTotal.WithLabelValues(name, reason))
+		require.NoError(t, err)
+		return value
+	}
+
+	tests := []struct {
+		name      string
+		statuses  []int
+		delivered bool
+		retries   float64
+	}{
+		{"retried", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, true, 2},
+		{"not retried", []int{http.StatusBadRequest}, false, 0},
+		{"retries exhausted", []int{500, 500, 500, 500}, false, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			webhook := &webhookRecorder{statuses: test.statuses}
+			server := httptest.NewServer(webhook)
+			defer server.Close()
+
+			sink, err := newWebhookSink(WebhookConfig{
+				Name: test.name, URL: server.URL, MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond,
+			})
+			require.NoError(t, err)
+			go sink.run()
+
+			sink.Notify(context.Background(), &ResourceNotification{Type: NotificationTypeCreated, Cluster: "cluster-1"})
+			sink.close()
+
+			if test.delivered {
+				assert.Len(t, webhook.payloads, 1)
+				assert.Equal(t, float64(1), counter(test.name, "success"))
+				assert.Equal(t, float64(0), dropped(test.name, "delivery_failed"))
+			} else {
+				assert.Empty(t, webhook.payloads)
+				assert.Equal(t, float64(1), counter(test.name, "failure"))
+				assert.Equal(t, float64(1), dropped(test.name, "delivery_failed"))
+			}
+			assert.Equal(t, test.retries, counter(test.name, "retry"))
+		})
+	}
+}
+
+func TestWebhookSink_QueueFull(t *testing.T) {
+	// the sink isn't running, so the queue is full after the first notification
+	sink, err := newWebhookSink(WebhookConfig{Name: "queue-full", URL: "http://127.0.0.1", QueueSize: 1})
+	require.NoError(t, err)
+	sink.Notify(context.Background(), &ResourceNotification{})
+	sink.Notify(context.Background(), &ResourceNotification{})
+
+	value, err := testutil.GetCounterMetricValue(notificationsDroppedTotal.WithLabelValues("queue-full", "queue_full"))
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), value)
+	assert.Len(t, sink.queue, 1)
+}
+
+func TestNotificationRule(t *testing.T) {
+	notification := &ResourceNotification{
+		Type: NotificationTypeCreated, Cluster: "cluster-1", Group: "networking.k8s.io", Version: "v1", Resource: "ingresses",
+		Metadata: metav1.ObjectMeta{Namespace: "default", Name: "ingress-1"},
+	}
+	tests := []struct {
+		rule    NotificationRule
+		matched bool
+	}{
+		{NotificationRule{}, true},
+		{NotificationRule{Group: "networking.k8s.io", Resource: "ingresses", Types: []NotificationType{NotificationTypeCreated}}, true},
+		{NotificationRule{Group: "networking.k8s.io", Version: "v1beta1", Resource: "ingresses"}, false},
+		{NotificationRule{Resource: "ingresses", Types: []NotificationType{NotificationTypeDeleted}}, false},
+		{NotificationRule{Clusters: []string{"cluster-2", "cluster-1"}, Namespaces: []string{"default"}}, true},
+		{NotificationRule{Clusters: []string{"cluster-2"}}, false},
+		{NotificationRule{Namespaces: []string{"kube-system"}}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matched, test.rule.matches(notification), "%+v", test.rule)
+	}
+}
+
+func TestNewNotificationSink(t *testing.T) {
+	sink, err := newNotificationSink(NotificationConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, sink)
+
+	for _, config := range []NotificationConfig{
+		{Webhooks: []WebhookConfig{{URL: "http://127.0.0.1"}}},
+		{Webhooks: []WebhookConfig{{Name: "webhook"}}},
+		{Webhooks: []WebhookConfig{{Name: "webhook", URL: "http://127.0.0.1"}, {Name: "webhook", URL: "http://127.0.0.1"}}},
+		{Webhooks: []WebhookConfig{{Name: "webhook", URL: "http://127.0.0.1", SecretFile: filepath.Join(t.TempDir(), "missing")}}},
+	} {
+		_, err := newNotificationSink(config)
+		assert.Error(t, err, "%+v", config)
+	}
+}
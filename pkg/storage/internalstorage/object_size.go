@@ -0,0 +1,168 @@
+package internalstorage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// TruncatedAnnotation is set on the stored object which is truncated by the object size limit or the storage policy,
+// its value is how the object is truncated, e.g. metadataOnly.
+const TruncatedAnnotation = "internalstorage.clusterpedia.io/truncated"
+
+// ObjectSizeOverflow is how the object larger than the object size limit is stored.
+type ObjectSizeOverflow string
+
+const (
+	// ObjectSizeOverflowReject rejects the object with the ObjectTooLargeError.
+	ObjectSizeOverflowReject ObjectSizeOverflow = "reject"
+
+	// ObjectSizeOverflowMetadataOnly stores the apiVersion, kind and metadata of the object.
+	ObjectSizeOverflowMetadataOnly ObjectSizeOverflow = "metadataOnly"
+
+	// ObjectSizeOverflowElideFields stores the object without the elided fields of its resource.
+	ObjectSizeOverflowElideFields ObjectSizeOverflow = "elideFields"
+)
+
+var objectSizeOverflowsTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "object_size_overflows_total",
+		Help:           "Number of the written objects exceeding the object size limit, partitioned by the resource and the overflow.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"group", "version", "resource", "overflow"},
+)
+
+func init() {
+	legacyregistry.MustRegister(objectSizeOverflowsTotal)
+}
+
+// ObjectSizeLimitConfig limits the size of the encoded objects written to the storage, the size is measured
+// after the redactions and before the encryption. The limit is disabled if the MaxBytes is unset.
+type ObjectSizeLimitConfig struct {
+	// MaxBytes is the maximum bytes of the encoded object.
+	MaxBytes int `yaml:"maxBytes"`
+
+	// Overflow is how the larger object is stored, reject, metadataOnly or elideFields. Default is reject.
+	// The truncated object which is still larger than the MaxBytes is rejected.
+	Overflow ObjectSizeOverflow `yaml:"overflow"`
+
+	// ElidedFields are the fields of the resources elided by the elideFields overflow, e.g. the data of the configmaps,
+	// they are configured as the redactions, the field is removed if its marker is empty.
+	ElidedFields []RedactionConfig `yaml:"elidedFields"`
+}
+
+type objectSizeLimit struct {
+	maxBytes     int
+	overflow     ObjectSizeOverflow
+	elidedFields map[schema.GroupResource][]redactedField
+}
+
+func newObjectSizeLimit(cfg ObjectSizeLimitConfig) (*objectSizeLimit, error) {
+	if cfg.MaxBytes < 0 {
+		return nil, fmt.Errorf("objectSizeLimit.maxBytes must not be negative, got %d", cfg.MaxBytes)
+	}
+	if cfg.MaxBytes == 0 {
+		return nil, nil
+	}
+
+	limit := &objectSizeLimit{maxBytes: cfg.MaxBytes, overflow: cfg.Overflow}
+	switch cfg.Overflow {
+	case "":
+		limit.overflow = ObjectSizeOverflowReject
+	case ObjectSizeOverflowReject, ObjectSizeOverflowMetadataOnly:
+	case ObjectSizeOverflowElideFields:
+		if len(cfg.ElidedFields) == 0 {
+			return nil, fmt.Errorf("objectSizeLimit.elidedFields is required by the %s overflow", cfg.Overflow)
+		}
+		elidedFields, err := newObjectRedactions(false, cfg.ElidedFields)
+		if err != nil {
+			return nil, fmt.Errorf("objectSizeLimit.elidedFields: %w", err)
+		}
+		limit.elidedFields = elidedFields
+	default:
+		return nil, fmt.Errorf("objectSizeLimit.overflow %q is not supported", cfg.Overflow)
+	}
+	return limit, nil
+}
+
+// limitObjectSize returns the object to be stored if the encoded object exceeds the object size limit,
+// and whether the returned object is truncated by the overflow.
+func (s *ResourceStorage) limitObjectSize(cluster string, metaobj metav1.Object, encoded []byte) ([]byte, bool, error) {
+	if s.objectSizeLimit == nil || len(encoded) <= s.objectSizeLimit.maxBytes {
+		return encoded, false, nil
+	}
+
+	gvr := s.storageGVR()
+	objectSizeOverflowsTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, string(s.objectSizeLimit.overflow)).Inc()
+
+	tooLarge := &ObjectTooLargeError{
+		GroupResource: s.storageGroupResource,
+		Object:        ResourceIdentity{Cluster: cluster, Namespace: metaobj.GetNamespace(), Name: metaobj.GetName()},
+		Size:          len(encoded),
+		MaxBytes:      s.objectSizeLimit.maxBytes,
+	}
+	if s.objectSizeLimit.overflow == ObjectSizeOverflowReject {
+		return nil, false, tooLarge
+	}
+
+	object, err := s.objectSizeLimit.truncate(s.storageGroupResource, encoded)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(object) > s.objectSizeLimit.maxBytes {
+		tooLarge.Size = len(object)
+		return nil, false, tooLarge
+	}
+	return object, true, nil
+}
+
+// truncate truncates the encoded object by the overflow.
+func (l *objectSizeLimit) truncate(gr schema.GroupResource, encoded []byte) ([]byte, error) {
+	if l.overflow == ObjectSizeOverflowMetadataOnly {
+		return truncateObject(encoded, string(l.overflow), keepMetadataOnly)
+	}
+	return truncateObject(encoded, string(l.overflow), func(object map[string]interface{}) {
+		for _, field := range l.elidedFields[gr] {
+			redactField(object, field)
+		}
+	})
+}
+
+// truncateObject truncates the encoded object, and marks it by the TruncatedAnnotation with the truncation.
+func truncateObject(encoded []byte, truncation string, truncate func(object map[string]interface{})) ([]byte, error) {
+	var object map[string]interface{}
+	if err := utiljson.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+	truncate(object)
+
+	metadata, ok := object["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+		object["metadata"] = metadata
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = make(map[string]interface{})
+		metadata["annotations"] = annotations
+	}
+	annotations[TruncatedAnnotation] = truncation
+	return json.Marshal(object)
+}
+
+// keepMetadataOnly removes the fields of the object except the apiVersion, kind and metadata.
+func keepMetadataOnly(object map[string]interface{}) {
+	for key := range object {
+		if key != "apiVersion" && key != "kind" && key != "metadata" {
+			delete(object, key)
+		}
+	}
+}
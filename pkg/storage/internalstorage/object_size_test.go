@@ -0,0 +1,184 @@
+package internalstorage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestResourceStorage_ObjectSizeLimit(t *testing.T) {
+	const maxBytes = 1 << 20
+	largeData := strings.Repeat("x", 3<<20)
+
+	newConfigMap := func(name, resourceVersion, data string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: resourceVersion,
+				Labels: map[string]string{"app": name},
+			},
+			Data:       map[string]string{"large": data},
+			BinaryData: map[string][]byte{"small": []byte("small")},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config ObjectSizeLimitConfig
+
+		rejected bool
+		assert   func(t *testing.T, stored *corev1.ConfigMap)
+	}{
+		{
+			name:     "reject",
+			config:   ObjectSizeLimitConfig{MaxBytes: maxBytes},
+			rejected: true,
+		},
+		{
+			name:   "metadata only",
+			config: ObjectSizeLimitConfig{MaxBytes: maxBytes, Overflow: ObjectSizeOverflowMetadataOnly},
+			assert: func(t *testing.T, stored *corev1.ConfigMap) {
+				assert.Equal(t, "metadataOnly", stored.Annotations[TruncatedAnnotation])
+				assert.Equal(t, "large", stored.Labels["app"])
+				assert.Empty(t, stored.Data)
+				assert.Empty(t, stored.BinaryData)
+			},
+		},
+		{
+			name: "elide fields",
+			config: ObjectSizeLimitConfig{
+				MaxBytes: maxBytes,
+				Overflow: ObjectSizeOverflowElideFields,
+				ElidedFields: []RedactionConfig{{
+					Resource: "configmaps",
+					Fields:   []RedactedFieldConfig{{Path: "data", Marker: "<elided>"}},
+				}},
+			},
+			assert: func(t *testing.T, stored *corev1.ConfigMap) {
+				assert.Equal(t, "elideFields", stored.Annotations[TruncatedAnnotation])
+				assert.Equal(t, map[string]string{"large": "<elided>"}, stored.Data)
+				assert.Equal(t, []byte("small"), stored.BinaryData["small"])
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db, cleanup, err := newSQLiteDB()
+			require.NoError(t, err)
+			defer cleanup()
+
+			gvr := corev1.SchemeGroupVersion.WithResource("configmaps")
+			rs := newTestResourceStorage(db, gvr)
+			config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "configmaps"}, true)
+			require.NoError(t, err)
+			rs.codec = config.Codec
+			rs.objectSizeLimit, err = newObjectSizeLimit(test.config)
+			require.NoError(t, err)
+
+			overflows := func() float64 {
+				value, err := testutil.GetCounterMetricValue(objectSizeOverflowsTotal.WithLabelValues("", "v1", "configmaps", string(rs.objectSizeLimit.overflow)))
+				require.NoError(t, err)
+				return value
+			}
+			before := overflows()
+
+			// the small object is stored as is
+			require.NoError(t, rs.Create(context.Background(), "cluster-1", newConfigMap("small", "1", "small")))
+			assert.Equal(t, before, overflows())
+
+			err = rs.Create(context.Background(), "cluster-1", newConfigMap("large", "1", largeData))
+			assert.Equal(t, before+1, overflows())
+			if test.rejected {
+				assert.ErrorIs(t, err, ErrTooLarge)
+				var tooLarge *ObjectTooLargeError
+				require.ErrorAs(t, err, &tooLarge)
+				assert.Equal(t, "cluster-1/default/large", tooLarge.Object.String())
+				assert.Greater(t, tooLarge.Size, 3<<20)
+
+				var count int64
+				require.NoError(t, db.Model(&Resource{}).Where("name = ?", "large").Count(&count).Error)
+				assert.Zero(t, count)
+				return
+			}
+			require.NoError(t, err)
+
+			get := func(name string) *corev1.ConfigMap {
+				var resource Resource
+				require.NoError(t, db.Where("name = ?", name).First(&resource).Error)
+				assert.Less(t, len(resource.Object), maxBytes)
+
+				stored := &corev1.ConfigMap{}
+				require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", name, stored))
+
+				// the metadata column is marked by the truncation as well
+				assert.Contains(t, string(resource.Metadata), TruncatedAnnotation)
+				return stored
+			}
+			test.assert(t, get("large"))
+
+			// the updated object is limited as well, and it is stored as is once it's small enough
+			require.NoError(t, rs.Update(context.Background(), "cluster-1", newConfigMap("large", "2", largeData+"x")))
+			assert.Equal(t, before+2, overflows())
+			test.assert(t, get("large"))
+
+			require.NoError(t, rs.Update(context.Background(), "cluster-1", newConfigMap("large", "3", "small")))
+			stored := &corev1.ConfigMap{}
+			require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "large", stored))
+			assert.NotContains(t, stored.Annotations, TruncatedAnnotation)
+			assert.Equal(t, "small", stored.Data["large"])
+		})
+	}
+}
+
+func TestResourceStorage_ObjectSizeLimitStillTooLarge(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("configmaps"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "configmaps"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.objectSizeLimit, err = newObjectSizeLimit(ObjectSizeLimitConfig{MaxBytes: 1 << 20, Overflow: ObjectSizeOverflowMetadataOnly})
+	require.NoError(t, err)
+
+	// the metadata of the object is larger than the limit
+	err = rs.Create(context.Background(), "cluster-1", &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default", Name: "a", ResourceVersion: "1",
+			Annotations: map[string]string{"large": strings.Repeat("x", 2<<20)},
+		},
+	})
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestNewObjectSizeLimit(t *testing.T) {
+	limit, err := newObjectSizeLimit(ObjectSizeLimitConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, limit)
+
+	limit, err = newObjectSizeLimit(ObjectSizeLimitConfig{MaxBytes: 1024})
+	require.NoError(t, err)
+	assert.Equal(t, ObjectSizeOverflowReject, limit.overflow)
+
+	for _, config := range []ObjectSizeLimitConfig{
+		{MaxBytes: -1},
+		{MaxBytes: 1024, Overflow: "truncate"},
+		{MaxBytes: 1024, Overflow: ObjectSizeOverflowElideFields},
+		{MaxBytes: 1024, Overflow: ObjectSizeOverflowElideFields, ElidedFields: []RedactionConfig{{Fields: []RedactedFieldConfig{{Path: "data"}}}}},
+	} {
+		_, err := newObjectSizeLimit(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,337 @@
+package internalstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultOwnerResolutionMaxPending    = 100000
+	defaultOwnerResolutionSweepInterval = 5 * time.Minute
+	defaultOwnerResolutionDanglingAfter = 30 * time.Minute
+
+	// ownerResolutionSweepBatchSize is the max number of the owner uids queried by each query of the sweep.
+	ownerResolutionSweepBatchSize = 500
+)
+
+var (
+	unresolvedOwnerReferences = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "unresolved_owner_references",
+			Help:           "Number of the controller owner references of the written objects whose owners aren't stored.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	danglingOwnerReferences = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "dangling_owner_references",
+			Help:           "Number of the unresolved owner references whose owners aren't stored after the dangling duration, updated by the sweeps.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	resolvedOwnerReferencesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "resolved_owner_references_total",
+			Help:           "Number of the owner references resolved by the writes of the owners or by the sweeps.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"by"},
+	)
+
+	untrackedOwnerReferencesTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "untracked_owner_references_total",
+			Help:           "Number of the owner references not tracked since the pending references reach the limit.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(unresolvedOwnerReferences, danglingOwnerReferences, resolvedOwnerReferencesTotal, untrackedOwnerReferencesTotal)
+}
+
+// OwnerResolutionConfig tracks the controller owners referenced by the written objects until the owners are stored,
+// e.g. the pods are listed before their replicasets by the initial lists. The references are resolved by the writes
+// of the owners, and by the periodic sweeps which query the stored owners, so the references whose owners are never
+// stored are reported as dangling.
+type OwnerResolutionConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// MaxPending is the max number of the tracked references, the others are counted
+	// by the untracked_owner_references_total metric. Default is 100000.
+	MaxPending int `yaml:"maxPending"`
+
+	// SweepInterval is the interval of the sweeps, each sweep queries the uids of the pending owners
+	// of each cluster by the cluster index. Default is 5m.
+	SweepInterval time.Duration `yaml:"sweepInterval"`
+
+	// DanglingAfter is the duration after which the unresolved references are reported as dangling. Default is 30m.
+	DanglingAfter time.Duration `yaml:"danglingAfter"`
+}
+
+// ownerKey is the key of the owner referenced by the owner uid of the objects in the cluster.
+type ownerKey struct {
+	cluster string
+	uid     types.UID
+}
+
+// ownedKey is the key of the object referencing the owner.
+type ownedKey struct {
+	cluster   string
+	gr        schema.GroupResource
+	namespace string
+	name      string
+}
+
+// pendingOwner is the owner which isn't stored when it is referenced by the owned objects.
+type pendingOwner struct {
+	since time.Time
+	owned map[ownedKey]struct{}
+}
+
+// ownerResolver tracks the unresolved owner references, the references are the edges from the owned objects to the owners,
+// and each owned object has at most one edge to its controller.
+type ownerResolver struct {
+	lock    sync.Mutex
+	pending map[ownerKey]*pendingOwner
+	owners  map[ownedKey]ownerKey
+
+	maxPending    int
+	sweepInterval time.Duration
+	danglingAfter time.Duration
+
+	closeOnce sync.Once
+	stopCh    chan struct{}
+}
+
+// newOwnerResolver returns nil if the owner resolution is disabled.
+func newOwnerResolver(config OwnerResolutionConfig) *ownerResolver {
+	if !config.Enabled {
+		return nil
+	}
+
+	resolver := &ownerResolver{
+		pending:       make(map[ownerKey]*pendingOwner),
+		owners:        make(map[ownedKey]ownerKey),
+		maxPending:    config.MaxPending,
+		sweepInterval: config.SweepInterval,
+		danglingAfter: config.DanglingAfter,
+		stopCh:        make(chan struct{}),
+	}
+	if resolver.maxPending <= 0 {
+		resolver.maxPending = defaultOwnerResolutionMaxPending
+	}
+	if resolver.sweepInterval <= 0 {
+		resolver.sweepInterval = defaultOwnerResolutionSweepInterval
+	}
+	if resolver.danglingAfter <= 0 {
+		resolver.danglingAfter = defaultOwnerResolutionDanglingAfter
+	}
+	return resolver
+}
+
+// start starts sweeping the pending owners by the stored resources of the databases.
+func (r *ownerResolver) start(databases []*gorm.DB) {
+	if r == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(r.sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			if _, err := r.sweep(context.Background(), databases, time.Now()); err != nil {
+				klog.ErrorS(err, "Failed to sweep the unresolved owner references")
+			}
+		}
+	}()
+}
+
+// written resolves the references to the written object, and tracks the reference of the object to its controller,
+// which is resolved by the later write of the controller or by the sweep.
+func (r *ownerResolver) written(cluster string, gr schema.GroupResource, metaobj metav1.Object, now time.Time) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	defer r.updateMetrics()
+
+	if resolved := r.resolve(ownerKey{cluster: cluster, uid: metaobj.GetUID()}); resolved != 0 {
+		resolvedOwnerReferencesTotal.WithLabelValues("write").Add(float64(resolved))
+	}
+
+	owned := ownedKey{cluster: cluster, gr: gr, namespace: metaobj.GetNamespace(), name: metaobj.GetName()}
+	controller := metav1.GetControllerOfNoCopy(metaobj)
+	if controller == nil || controller.UID == "" {
+		r.untrack(owned)
+		return
+	}
+
+	owner := ownerKey{cluster: cluster, uid: controller.UID}
+	if current, ok := r.owners[owned]; ok {
+		if current == owner {
+			return
+		}
+		r.untrack(owned)
+	}
+	if len(r.owners) >= r.maxPending {
+		untrackedOwnerReferencesTotal.Inc()
+		return
+	}
+
+	pending := r.pending[owner]
+	if pending == nil {
+		pending = &pendingOwner{since: now, owned: make(map[ownedKey]struct{})}
+		r.pending[owner] = pending
+	}
+	pending.owned[owned] = struct{}{}
+	r.owners[owned] = owner
+}
+
+// deleted untracks the reference of the deleted object.
+func (r *ownerResolver) deleted(cluster string, gr schema.GroupResource, metaobj metav1.Object) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.untrack(ownedKey{cluster: cluster, gr: gr, namespace: metaobj.GetNamespace(), name: metaobj.GetName()})
+	r.updateMetrics()
+}
+
+// forgetCluster untracks the references of the cleaned cluster.
+func (r *ownerResolver) forgetCluster(cluster string) {
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for owner := range r.pending {
+		if owner.cluster == cluster {
+			for owned := range r.pending[owner].owned {
+				delete(r.owners, owned)
+			}
+			delete(r.pending, owner)
+		}
+	}
+	r.updateMetrics()
+}
+
+// sweep resolves the pending owners which are stored in the databases, e.g. the owners are written by other storages
+// or before the owned objects, and reports the references unresolved after the dangling duration.
+func (r *ownerResolver) sweep(ctx context.Context, databases []*gorm.DB, now time.Time) (int, error) {
+	if r == nil {
+		return 0, nil
+	}
+
+	r.lock.Lock()
+	uids := make(map[string][]types.UID)
+	for owner := range r.pending {
+		uids[owner.cluster] = append(uids[owner.cluster], owner.uid)
+	}
+	r.lock.Unlock()
+
+	var stored []ownerKey
+	for cluster, clusterUIDs := range uids {
+		for start := 0; start < len(clusterUIDs); start += ownerResolutionSweepBatchSize {
+			batch := clusterUIDs[start:min(start+ownerResolutionSweepBatchSize, len(clusterUIDs))]
+			for _, db := range databases {
+				var found []types.UID
+				if err := db.WithContext(ctx).Model(&Resource{}).Where("cluster = ? AND uid IN ?", cluster, batch).
+					Distinct().Pluck("uid", &found).Error; err != nil {
+					return 0, err
+				}
+				for _, uid := range found {
+					stored = append(stored, ownerKey{cluster: cluster, uid: uid})
+				}
+			}
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var resolved int
+	for _, owner := range stored {
+		resolved += r.resolve(owner)
+	}
+	resolvedOwnerReferencesTotal.WithLabelValues("sweep").Add(float64(resolved))
+
+	var dangling int
+	for _, pending := range r.pending {
+		if now.Sub(pending.since) >= r.danglingAfter {
+			dangling += len(pending.owned)
+		}
+	}
+	danglingOwnerReferences.Set(float64(dangling))
+	r.updateMetrics()
+	return resolved, nil
+}
+
+// resolve untracks the references to the owner, and returns the number of them.
+func (r *ownerResolver) resolve(owner ownerKey) int {
+	pending, ok := r.pending[owner]
+	if !ok {
+		return 0
+	}
+	for owned := range pending.owned {
+		delete(r.owners, owned)
+	}
+	delete(r.pending, owner)
+	return len(pending.owned)
+}
+
+// untrack untracks the reference of the owned object.
+func (r *ownerResolver) untrack(owned ownedKey) {
+	owner, ok := r.owners[owned]
+	if !ok {
+		return
+	}
+	delete(r.owners, owned)
+	if pending := r.pending[owner]; pending != nil {
+		delete(pending.owned, owned)
+		if len(pending.owned) == 0 {
+			delete(r.pending, owner)
+		}
+	}
+}
+
+func (r *ownerResolver) updateMetrics() {
+	unresolvedOwnerReferences.Set(float64(len(r.owners)))
+}
+
+func (r *ownerResolver) close() {
+	if r == nil {
+		return
+	}
+	r.closeOnce.Do(func() { close(r.stopCh) })
+}
@@ -0,0 +1,112 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/pointer"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newOwnedPod(name string, owner types.UID) *corev1.Pod {
+	pod := &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1"},
+	}
+	if owner != "" {
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: string(owner), UID: owner, Controller: pointer.Bool(true)}}
+	}
+	return pod
+}
+
+func TestOwnerResolution(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	resolver := newOwnerResolver(OwnerResolutionConfig{Enabled: true, MaxPending: 3, DanglingAfter: time.Hour})
+	defer resolver.close()
+	newStorage := func(gvr schema.GroupVersionResource) *ResourceStorage {
+		rs := newTestResourceStorage(db, gvr)
+		config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gvr.GroupResource(), true)
+		require.NoError(t, err)
+		rs.codec = config.Codec
+		rs.ownerResolver = resolver
+		return rs
+	}
+	pods := newStorage(corev1.SchemeGroupVersion.WithResource("pods"))
+	replicasets := newStorage(appsv1.SchemeGroupVersion.WithResource("replicasets"))
+
+	// the pods are synced before their replicasets
+	for _, pod := range []*corev1.Pod{newOwnedPod("pod-1", "rs-1"), newOwnedPod("pod-2", "rs-1"), newOwnedPod("pod-3", "rs-2"), newOwnedPod("pod-4", "")} {
+		require.NoError(t, pods.Create(context.Background(), "cluster-1", pod))
+	}
+	assert.Len(t, resolver.owners, 3)
+	assert.Len(t, resolver.pending, 2)
+
+	// the pending references reach the limit
+	require.NoError(t, pods.Create(context.Background(), "cluster-1", newOwnedPod("pod-5", "rs-3")))
+	assert.Len(t, resolver.owners, 3)
+
+	// the replicaset resolves the references of its pods when it is created
+	rs := &appsv1.ReplicaSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "rs-1", UID: "rs-1", ResourceVersion: "1"},
+	}
+	require.NoError(t, replicasets.Create(context.Background(), "cluster-1", rs))
+	assert.Len(t, resolver.owners, 1)
+	assert.NotContains(t, resolver.pending, ownerKey{cluster: "cluster-1", uid: "rs-1"})
+
+	// the reference is replaced by the new controller, and is untracked by the deletion
+	require.NoError(t, pods.Update(context.Background(), "cluster-1", newOwnedPod("pod-3", "rs-4")))
+	assert.Equal(t, map[ownedKey]ownerKey{
+		{cluster: "cluster-1", gr: schema.GroupResource{Resource: "pods"}, namespace: "default", name: "pod-3"}: {cluster: "cluster-1", uid: "rs-4"},
+	}, resolver.owners)
+	deletedObj, err := pods.ConvertDeletedObject(newOwnedPod("pod-3", "rs-4"))
+	require.NoError(t, err)
+	require.NoError(t, pods.Delete(context.Background(), "cluster-1", deletedObj))
+	assert.Empty(t, resolver.owners)
+	assert.Empty(t, resolver.pending)
+
+	// the owners written by others are resolved by the sweep, the others are dangling after the duration
+	now := time.Now()
+	resolver.written("cluster-1", schema.GroupResource{Resource: "pods"}, newOwnedPod("pod-6", "pod-1"), now)
+	resolver.written("cluster-1", schema.GroupResource{Resource: "pods"}, newOwnedPod("pod-7", "rs-5"), now)
+	resolver.written("cluster-2", schema.GroupResource{Resource: "pods"}, newOwnedPod("pod-8", "pod-1"), now)
+	resolved, err := resolver.sweep(context.Background(), []*gorm.DB{db}, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, resolved)
+	assert.Len(t, resolver.owners, 2)
+	dangling, err := testutil.GetGaugeMetricValue(danglingOwnerReferences)
+	require.NoError(t, err)
+	assert.Zero(t, dangling)
+
+	resolved, err = resolver.sweep(context.Background(), []*gorm.DB{db}, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, resolved)
+	assert.Len(t, resolver.owners, 2)
+	dangling, err = testutil.GetGaugeMetricValue(danglingOwnerReferences)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), dangling)
+
+	resolver.forgetCluster("cluster-2")
+	assert.Equal(t, map[ownedKey]ownerKey{
+		{cluster: "cluster-1", gr: schema.GroupResource{Resource: "pods"}, namespace: "default", name: "pod-7"}: {cluster: "cluster-1", uid: "rs-5"},
+	}, resolver.owners)
+
+	var nilResolver *ownerResolver
+	nilResolver.written("cluster-1", schema.GroupResource{Resource: "pods"}, newOwnedPod("pod-1", "rs-1"), now)
+	_, err = nilResolver.sweep(context.Background(), []*gorm.DB{db}, now)
+	assert.NoError(t, err)
+}
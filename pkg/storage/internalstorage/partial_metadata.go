@@ -0,0 +1,118 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// partialObjectMetadataVersion returns the group version of the PartialObjectMetadata requested by the object,
+// the typed PartialObjectMetadata(List) and the unstructured ones of the meta.k8s.io group are served from the metadata column.
+func partialObjectMetadataVersion(obj runtime.Object) (schema.GroupVersion, bool) {
+	switch obj.(type) {
+	case *metav1.PartialObjectMetadata, *metav1.PartialObjectMetadataList:
+		return metav1.SchemeGroupVersion, true
+	case *unstructured.Unstructured, *unstructured.UnstructuredList:
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		if gvk.Group == metav1.GroupName && (gvk.Kind == "PartialObjectMetadata" || gvk.Kind == "PartialObjectMetadataList") {
+			return gvk.GroupVersion(), true
+		}
+	}
+	return schema.GroupVersion{}, false
+}
+
+var _ storage.ResourceMetadataGetter = &ResourceStorage{}
+
+// GetMetadata gets the metadata of the resource from the metadata column, the object isn't read. Unlike the Get of
+// the PartialObjectMetadata, the TypeMeta of the into is the stored group, version and kind of the resource.
+func (s *ResourceStorage) GetMetadata(ctx context.Context, cluster, namespace, name string, into *metav1.PartialObjectMetadata) error {
+	if err := s.checkScope(namespace); err != nil {
+		return err
+	}
+	cluster = s.clusterAliases.resolve(cluster)
+	if err := checkClusterAllowed(ctx, cluster); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Get))
+	defer cancel()
+
+	metadata, err := s.getResourceMetadata(ctx, cluster, namespace, name)
+	if err != nil {
+		return err
+	}
+	if _, err := metadata.ConvertTo(s.codec, into); err != nil {
+		return s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+	}
+	into.TypeMeta = metav1.TypeMeta{APIVersion: schema.GroupVersion{Group: metadata.Group, Version: metadata.Version}.String(), Kind: metadata.Kind}
+	return s.injectShadowAnnotations(into, cluster)
+}
+
+// getMetadata gets the PartialObjectMetadata of the resource from the metadata column, the object isn't read.
+func (s *ResourceStorage) getMetadata(ctx context.Context, cluster, namespace, name string, version schema.GroupVersion, into runtime.Object) error {
+	metadata, err := s.getResourceMetadata(ctx, cluster, namespace, name)
+	if err != nil {
+		return err
+	}
+	if _, err := metadata.ConvertTo(s.codec, into); err != nil {
+		return s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+	}
+	into.GetObjectKind().SetGroupVersionKind(version.WithKind("PartialObjectMetadata"))
+	return s.injectShadowAnnotations(into, cluster)
+}
+
+// getResourceMetadata selects the metadata of the resource, the metadata of the legacy versions is read like the
+// objects if the resource isn't stored in the storage version. The cached NotFound results are shared with the Get.
+func (s *ResourceStorage) getResourceMetadata(ctx context.Context, cluster, namespace, name string) (*ResourceMetadata, error) {
+	if s.notFoundCache != nil {
+		if _, _, ok := s.notFoundCache.get(s.getCacheKey(cluster, namespace, name)); ok {
+			setSpanAttributes(ctx, attribute.Bool("not_found_cache_hit", true))
+			return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), gorm.ErrRecordNotFound)
+		}
+	}
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var metadata ResourceMetadata
+	err = selectResourceMetadata(s.genGetObjectQuery(ctx, cluster, namespace, name)).First(&metadata).Error
+	if err == nil {
+		return &metadata, nil
+	}
+	if len(s.legacyVersions) == 0 || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), err)
+	}
+
+	var metadatas []ResourceMetadata
+	result := selectResourceMetadata(s.db.WithContext(ctx).Model(&Resource{}).Where(map[string]interface{}{
+		"cluster":   cluster,
+		"group":     s.storageGroupResource.Group,
+		"resource":  s.storageGroupResource.Resource,
+		"namespace": namespace,
+		"name":      name,
+	}).Where("version IN ?", s.legacyVersions)).Find(&metadatas)
+	if result.Error != nil {
+		return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), result.Error)
+	}
+	for _, version := range s.legacyVersions {
+		for i := range metadatas {
+			if metadatas[i].Version == version {
+				legacyReadsTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource, version).Inc()
+				setSpanAttributes(ctx, attribute.String("stored_version", version))
+				return &metadatas[i], nil
+			}
+		}
+	}
+	return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), gorm.ErrRecordNotFound)
+}
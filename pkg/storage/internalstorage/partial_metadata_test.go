@@ -0,0 +1,149 @@
+package internalstorage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestResourceStoragePartialObjectMetadata(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newPreparedTestResourceStorage(t, db, false)
+	for _, name := range []string{"deploy-1", "deploy-2"} {
+		deploy := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1", Labels: map[string]string{"app": name}},
+		}
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	}
+	// the objects aren't read, the metadata is served from the metadata column
+	require.NoError(t, db.Model(&Resource{}).Where("1 = 1").Update("object", `{}`).Error)
+
+	partialType := metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"}
+	t.Run("typed list", func(t *testing.T) {
+		list := &metav1.PartialObjectMetadataList{}
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{}))
+		require.Len(t, list.Items, 2)
+		for _, item := range list.Items {
+			assert.Equal(t, partialType, item.TypeMeta)
+			assert.Equal(t, "default", item.Namespace)
+			assert.Equal(t, item.Name, item.Labels["app"])
+		}
+	})
+
+	t.Run("unstructured list", func(t *testing.T) {
+		list := &unstructured.UnstructuredList{}
+		list.SetAPIVersion("meta.k8s.io/v1")
+		list.SetKind("PartialObjectMetadataList")
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{}))
+		require.Len(t, list.Items, 2)
+		for _, item := range list.Items {
+			assert.Equal(t, "meta.k8s.io/v1", item.GetAPIVersion())
+			assert.Equal(t, "PartialObjectMetadata", item.GetKind())
+			assert.Equal(t, item.GetName(), item.GetLabels()["app"])
+			assert.NotContains(t, item.Object, "spec")
+		}
+	})
+
+	t.Run("typed get", func(t *testing.T) {
+		got := &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", got))
+		assert.Equal(t, partialType, got.TypeMeta)
+		assert.Equal(t, "deploy-1", got.Labels["app"])
+
+		err := rs.Get(context.Background(), "cluster-2", "default", "deploy-1", &metav1.PartialObjectMetadata{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("unstructured get", func(t *testing.T) {
+		got := &unstructured.Unstructured{}
+		got.SetAPIVersion("meta.k8s.io/v1")
+		got.SetKind("PartialObjectMetadata")
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-2", got))
+		assert.Equal(t, "PartialObjectMetadata", got.GetKind())
+		assert.Equal(t, "deploy-2", got.GetLabels()["app"])
+	})
+
+	t.Run("metadata get", func(t *testing.T) {
+		got := &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.GetMetadata(context.Background(), "cluster-1", "default", "deploy-1", got))
+		// the TypeMeta is the stored type of the resource
+		assert.Equal(t, metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}, got.TypeMeta)
+		assert.Equal(t, "deploy-1", got.Labels["app"])
+
+		err := rs.GetMetadata(context.Background(), "cluster-2", "default", "deploy-1", &metav1.PartialObjectMetadata{})
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("legacy version", func(t *testing.T) {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: "apps", Version: "v1beta2", Resource: "deployments", Kind: "Deployment",
+			Namespace: "default", Name: "deploy-3", ResourceVersion: "1", Object: []byte(`{}`),
+			Metadata: []byte(`{"namespace":"default","name":"deploy-3","labels":{"app":"deploy-3"}}`),
+		}).Error)
+		err := rs.GetMetadata(context.Background(), "cluster-1", "default", "deploy-3", &metav1.PartialObjectMetadata{})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		rs.legacyVersions = []string{"v1beta2"}
+		defer func() { rs.legacyVersions = nil }()
+		got := &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.GetMetadata(context.Background(), "cluster-1", "default", "deploy-3", got))
+		assert.Equal(t, metav1.TypeMeta{APIVersion: "apps/v1beta2", Kind: "Deployment"}, got.TypeMeta)
+		assert.Equal(t, "deploy-3", got.Labels["app"])
+
+		typed := &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-3", typed))
+		assert.Equal(t, partialType, typed.TypeMeta)
+	})
+}
+
+// BenchmarkResourceStorageGetMetadata compares the Get of the large custom resource with the GetMetadata,
+// which reads the metadata column only.
+func BenchmarkResourceStorageGetMetadata(b *testing.B) {
+	db, cleanup, err := newSQLiteDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, schema.GroupVersionResource{Group: "example.io", Version: "v1", Resource: "foos"})
+	rs.codec = unstructured.UnstructuredJSONScheme
+	foo := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.io/v1",
+		"kind":       "Foo",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": "foo", "resourceVersion": "1", "labels": map[string]interface{}{"app": "foo"}},
+		"spec":       map[string]interface{}{"data": strings.Repeat("x", 256<<10)},
+	}}
+	if err := rs.Create(context.Background(), "cluster-1", foo); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("object", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := rs.Get(context.Background(), "cluster-1", "default", "foo", &unstructured.Unstructured{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("metadata", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := rs.GetMetadata(context.Background(), "cluster-1", "default", "foo", &metav1.PartialObjectMetadata{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
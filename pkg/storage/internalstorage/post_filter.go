@@ -0,0 +1,88 @@
+package internalstorage
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+var (
+	postFilterScannedRows = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "post_filter_scanned_rows",
+			Help:           "Number of the rows listed by the list requests whose filters are partly applied in memory, before the rows are filtered.",
+			Buckets:        metrics.ExponentialBuckets(10, 4, 8),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+
+	postFilterReturnedRows = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "post_filter_returned_rows",
+			Help:           "Number of the rows returned by the list requests whose filters are partly applied in memory, after the rows are filtered.",
+			Buckets:        metrics.ExponentialBuckets(10, 4, 8),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+
+	postFilterRejectedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "post_filter_rejected_total",
+			Help:           "Number of the list requests rejected since their rows filtered in memory may exceed the max post filter rows.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "resource"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(postFilterScannedRows, postFilterReturnedRows, postFilterRejectedTotal)
+}
+
+// memoryFilters returns the filters of the list options applied in memory by the lists, which are described
+// as the post filters by the querybuilder. Only the label selectors of the memory mode are applied in memory.
+func (s *ResourceStorage) memoryFilters(opts *internal.ListOptions) []string {
+	if s.memoryLabelSelector(opts) == nil {
+		return nil
+	}
+
+	options := queryBuilderOptions()
+	options.LabelSelectorMode = s.labelSelectorMode
+	var filters []string
+	for _, filter := range querybuilder.Describe(opts, options).PostFilter {
+		if strings.HasPrefix(filter, "label:") {
+			filters = append(filters, filter)
+		}
+	}
+	return filters
+}
+
+// checkPostFilterRows checks the rows filtered in memory by the list, the rejected lists are recorded by the metrics.
+func (s *ResourceStorage) checkPostFilterRows(opts *internal.ListOptions, filters []string) error {
+	if err := s.queryLimit.limitPostFilterRows(opts, filters); err != nil {
+		postFilterRejectedTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource).Inc()
+		return err
+	}
+	return nil
+}
+
+// observePostFilter records the rows of the list before and after they are filtered in memory.
+func (s *ResourceStorage) observePostFilter(ctx context.Context, scanned, returned int) {
+	postFilterScannedRows.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource).Observe(float64(scanned))
+	postFilterReturnedRows.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource).Observe(float64(returned))
+	setSpanAttributes(ctx, attribute.Int("post_filter.scanned", scanned), attribute.Int("post_filter.returned", returned))
+}
@@ -0,0 +1,251 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"gorm.io/gorm"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/klog/v2"
+)
+
+// preparedParam is the placeholder of a parameter while the prepared query is built,
+// it is bound as a single var by the gorm statement builder.
+type preparedParam struct {
+	name string
+}
+
+// preparedQuery is the statement of a hot path of the ResourceStorage, it is built once by the gorm statement builder
+// and prepared by the first execution, then it is executed with the parameters without being built again.
+//
+// The prepared statement is closed and prepared again after an execution fails, e.g. it's invalidated by the migration
+// of the table, or if the sql.DB of the gorm.DB is changed. The statements are prepared on the new connections
+// of the sql.DB by the database/sql, e.g. after the connections are reconnected.
+//
+// The gorm callbacks aren't run by the prepared query, e.g. the logger and the attribution.
+type preparedQuery struct {
+	name  string
+	build func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB
+
+	// params are the names of the parameters, in the order of the values passed to the execution.
+	params []string
+
+	lock sync.Mutex
+
+	// query is the built statement, positions are the indexes of the parameters in its vars.
+	query     string
+	vars      []interface{}
+	positions []int
+
+	sqlDB *sql.DB
+	stmt  *sql.Stmt
+}
+
+func newPreparedQuery(name string, build func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB, params ...string) *preparedQuery {
+	return &preparedQuery{name: name, build: build, params: params}
+}
+
+// buildLocked builds the statement by the gorm statement builder in the dry run mode.
+func (q *preparedQuery) buildLocked(db *gorm.DB) error {
+	tx := q.build(db.Session(&gorm.Session{DryRun: true, NewDB: true}), func(name string) preparedParam {
+		return preparedParam{name: name}
+	})
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	positions := make([]int, len(q.params))
+	for i, name := range q.params {
+		positions[i] = -1
+		for j, v := range tx.Statement.Vars {
+			if v == (preparedParam{name: name}) {
+				positions[i] = j
+				break
+			}
+		}
+		if positions[i] < 0 {
+			return fmt.Errorf("the parameter %s isn't bound by the query %s", name, q.name)
+		}
+	}
+	q.query, q.vars, q.positions = tx.Statement.SQL.String(), tx.Statement.Vars, positions
+	return nil
+}
+
+// statement returns the prepared statement, it is built and prepared if there is no statement prepared on the sql.DB.
+func (q *preparedQuery) statement(ctx context.Context, db *gorm.DB) (*sql.Stmt, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stmt != nil && q.sqlDB == sqlDB {
+		return q.stmt, nil
+	}
+	if q.stmt != nil {
+		klog.V(4).InfoS("The sql.DB is changed, prepare the statement again", "query", q.name)
+		go q.stmt.Close()
+		q.stmt = nil
+	}
+
+	if q.query == "" {
+		if err := q.buildLocked(db); err != nil {
+			return nil, err
+		}
+	}
+	stmt, err := sqlDB.PrepareContext(ctx, q.query)
+	if err != nil {
+		return nil, err
+	}
+	q.sqlDB, q.stmt = sqlDB, stmt
+	return stmt, nil
+}
+
+// invalidate closes the failed statement, so that it is prepared again by the next execution.
+func (q *preparedQuery) invalidate(stmt *sql.Stmt) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stmt == stmt {
+		go stmt.Close()
+		q.stmt = nil
+	}
+}
+
+func (q *preparedQuery) args(values []interface{}) []interface{} {
+	args := make([]interface{}, len(q.vars))
+	copy(args, q.vars)
+	for i, position := range q.positions {
+		args[position] = values[i]
+	}
+	return args
+}
+
+// exec executes the statement with the values of the parameters.
+func (q *preparedQuery) exec(ctx context.Context, db *gorm.DB, values ...interface{}) (int64, error) {
+	stmt, err := q.statement(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.ExecContext(ctx, q.args(values)...)
+	if err != nil {
+		q.invalidate(stmt)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// queryRow queries the row by the statement with the values of the parameters, and scans the row into the dest.
+// It returns gorm.ErrRecordNotFound if there is no row, the same as the First of the gorm.
+func (q *preparedQuery) queryRow(ctx context.Context, db *gorm.DB, dest []interface{}, values ...interface{}) error {
+	stmt, err := q.statement(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if err := stmt.QueryRowContext(ctx, q.args(values)...).Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return gorm.ErrRecordNotFound
+		}
+		q.invalidate(stmt)
+		return err
+	}
+	return nil
+}
+
+// preparedQueries are the prepared queries of the Get and Update of a ResourceStorage,
+// the statements are fixed by the resource and the columns of the database.
+type preparedQueries struct {
+	get *preparedQuery
+
+	// lightUpdate only updates the resource version of the object with the same content hash.
+	lightUpdate *preparedQuery
+
+	// update and updateDeleted are the full updates of the object, updateDeleted also updates the deleted_at.
+	update        *preparedQuery
+	updateDeleted *preparedQuery
+}
+
+func (s *ResourceStorage) newPreparedQueries() *preparedQueries {
+	where := func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB {
+		return tx.Model(&Resource{}).Where(map[string]interface{}{
+			"cluster":   param("cluster"),
+			"group":     s.storageGroupResource.Group,
+			"version":   s.storageVersion.Version,
+			"resource":  s.storageGroupResource.Resource,
+			"namespace": param("namespace"),
+			"name":      param("name"),
+		})
+	}
+	update := func(name string, columns []string) *preparedQuery {
+		return newPreparedQuery(name, func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB {
+			updated := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				updated[column] = param(column)
+			}
+			return where(tx, param).Updates(updated)
+		}, append(columns, "cluster", "namespace", "name")...)
+	}
+
+	return &preparedQueries{
+		get: newPreparedQuery("get", func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB {
+			return where(tx, param).Select(s.getColumns()).First(&Resource{})
+		}, "cluster", "namespace", "name"),
+		lightUpdate: newPreparedQuery("light update", func(tx *gorm.DB, param func(name string) preparedParam) *gorm.DB {
+			return where(tx, param).Where("content_hash = ?", param("content_hash")).
+				Updates(map[string]interface{}{"resource_version": param("resource_version"), "synced_at": param("synced_at")})
+		}, "resource_version", "synced_at", "cluster", "namespace", "name", "content_hash"),
+		update:        update("update", s.updatedColumns(false)),
+		updateDeleted: update("update deleted", s.updatedColumns(true)),
+	}
+}
+
+// usePreparedQueries returns true if the prepared queries are enabled, the queries are built by every call
+// if the queries are attributed, since the attribution is added to the statement by the gorm callbacks.
+func (s *ResourceStorage) usePreparedQueries() bool {
+	return s.prepared != nil && !utilfeature.DefaultFeatureGate.Enabled(QueryAttribution)
+}
+
+// getColumns are the columns of the stored object queried by the Get.
+func (s *ResourceStorage) getColumns() []string {
+	columns := append(s.objectColumns(), "resource_version")
+	if s.verifyChecksum {
+		columns = append(columns, "checksum")
+	}
+	return columns
+}
+
+// getDest returns the scan destinations of the getColumns, the null spec and status are scanned as nil.
+func (s *ResourceStorage) getDest(resource *Resource) []interface{} {
+	dest := []interface{}{(*[]byte)(&resource.Object)}
+	if !s.splitColumnsMissing {
+		dest = append(dest, (*[]byte)(&resource.Spec), (*[]byte)(&resource.Status))
+	}
+	dest = append(dest, &resource.ResourceVersion)
+	if s.verifyChecksum {
+		dest = append(dest, &resource.Checksum)
+	}
+	return dest
+}
+
+// updatedColumns are the columns written by the full update, the synced_at is set explicitly by the prepared query.
+func (s *ResourceStorage) updatedColumns(deleted bool) []string {
+	columns := []string{"owner_uid", "uid", "resource_version", "object", "metadata", "created_at"}
+	if !s.splitColumnsMissing {
+		columns = append(columns, "spec", "status")
+	}
+	if !s.checksumMissing {
+		columns = append(columns, "checksum")
+	}
+	if !s.contentHashMissing {
+		columns = append(columns, "content_hash")
+	}
+	if deleted {
+		columns = append(columns, "deleted_at")
+	}
+	return append(columns, "synced_at")
+}
@@ -0,0 +1,158 @@
+package internalstorage
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newPreparedTestResourceStorage(t testing.TB, db *gorm.DB, prepared bool) *ResourceStorage {
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.lightUpdates = true
+	if prepared {
+		rs.prepared = rs.newPreparedQueries()
+	}
+	return rs
+}
+
+func TestResourceStoragePreparedQueries(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newPreparedTestResourceStorage(t, db, true)
+	rs.splitObject, rs.verifyChecksum = true, true
+
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+		Spec:       appsv1.DeploymentSpec{MinReadySeconds: 10},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+
+	got := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", got))
+	assert.Equal(t, int32(10), got.Spec.MinReadySeconds)
+	err = rs.Get(context.Background(), "cluster-2", "default", "deploy-1", &appsv1.Deployment{})
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// the light update
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	var resource Resource
+	require.NoError(t, db.First(&resource).Error)
+	assert.Equal(t, "2", resource.ResourceVersion)
+
+	// the full updates, the deleted_at is only written by the update of the deleted object
+	deploy.ResourceVersion, deploy.Spec.MinReadySeconds = "3", 20
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	deletedAt := metav1.Now()
+	deploy.ResourceVersion, deploy.DeletionTimestamp = "4", &deletedAt
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+
+	require.NoError(t, db.First(&resource).Error)
+	assert.Equal(t, "4", resource.ResourceVersion)
+	assert.True(t, resource.DeletedAt.Valid)
+	assert.Contains(t, string(resource.Spec), `"minReadySeconds":20`)
+	assert.True(t, resource.Checksum.Valid)
+
+	got = &appsv1.Deployment{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", got))
+	assert.Equal(t, "4", got.ResourceVersion)
+	assert.Equal(t, int32(20), got.Spec.MinReadySeconds)
+
+	// the statements are prepared once, and prepared again after they fail
+	stmt := rs.prepared.get.stmt
+	require.NotNil(t, stmt)
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+	assert.Same(t, stmt, rs.prepared.get.stmt)
+
+	require.NoError(t, stmt.Close())
+	assert.Error(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+	assert.Nil(t, rs.prepared.get.stmt)
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+	assert.NotSame(t, stmt, rs.prepared.get.stmt)
+
+	// the statements prepared on the replaced sql.DB aren't used
+	reopened, err := gorm.Open(gsqlite.Open("test.db"))
+	require.NoError(t, err)
+	reopenedDB, err := reopened.DB()
+	require.NoError(t, err)
+	defer reopenedDB.Close()
+	rs.db = reopened
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+	assert.Same(t, reopenedDB, rs.prepared.get.sqlDB)
+}
+
+// BenchmarkResourceStoragePreparedQueries compares the Get and Update executed by the prepared statements with the ones
+// built by every call. The benchmark runs against the MySQL specified by the BENCHMARK_MYSQL_DSN env,
+// e.g. `root@tcp(127.0.0.1:3306)/clusterpedia`, and the sqlite if it is not set.
+func BenchmarkResourceStoragePreparedQueries(b *testing.B) {
+	var db *gorm.DB
+	if dsn := os.Getenv("BENCHMARK_MYSQL_DSN"); dsn != "" {
+		var err error
+		if db, err = openDB(DefaultDatabaseName, &Config{Type: "mysql", DSN: dsn}, logger.Discard); err != nil {
+			b.Fatal(err)
+		}
+	} else {
+		var cleanup func()
+		var err error
+		if db, cleanup, err = newSQLiteDB(); err != nil {
+			b.Fatal(err)
+		}
+		defer cleanup()
+	}
+
+	for _, prepared := range []bool{false, true} {
+		name := "built"
+		if prepared {
+			name = "prepared"
+		}
+
+		rs := newPreparedTestResourceStorage(b, db, prepared)
+		rs.lightUpdates = false
+		deploy := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-" + name, ResourceVersion: "1"},
+		}
+		if err := rs.Create(context.Background(), "benchmark", deploy); err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run("get/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := rs.Get(context.Background(), "benchmark", "default", deploy.Name, &appsv1.Deployment{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("update/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				deploy.ResourceVersion = strconv.Itoa(i + 2)
+				if err := rs.Update(context.Background(), "benchmark", deploy); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	if err := db.Where("cluster = ?", "benchmark").Delete(&Resource{}).Error; err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,334 @@
+package querybuilder
+
+import (
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SplitObjectsSetting is the setting of the queries of the resources whose objects are split,
+// the json queries of the spec and status are built from the spec and status columns.
+const SplitObjectsSetting = "internalstorage:split_objects"
+
+// SplitObjectsQueried returns whether the query is of the resource whose objects are split.
+func SplitObjectsQueried(stmt *gorm.Statement) bool {
+	split, ok := stmt.Settings.Load(SplitObjectsSetting)
+	return ok && split.(bool)
+}
+
+// SplitKeys returns the column and the keys of the spec and status of the split objects.
+func SplitKeys(keys []string) (string, []string, bool) {
+	if len(keys) < 2 || (keys[0] != "spec" && keys[0] != "status") {
+		return "", nil, false
+	}
+	return keys[0], keys[1:], true
+}
+
+type JSONQueryExpression struct {
+	column string
+	keys   []string
+
+	not    bool
+	values []string
+}
+
+func JSONQuery(column string, keys ...string) *JSONQueryExpression {
+	return &JSONQueryExpression{column: column, keys: keys}
+}
+
+func (jsonQuery *JSONQueryExpression) Exist() *JSONQueryExpression {
+	jsonQuery.not = false
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) NotExist() *JSONQueryExpression {
+	jsonQuery.not = true
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) Equal(value string) *JSONQueryExpression {
+	jsonQuery.not, jsonQuery.values = false, []string{value}
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) NotEqual(value string) *JSONQueryExpression {
+	jsonQuery.not, jsonQuery.values = true, []string{value}
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) In(values ...string) *JSONQueryExpression {
+	jsonQuery.not, jsonQuery.values = false, values
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) NotIn(values ...string) *JSONQueryExpression {
+	jsonQuery.not, jsonQuery.values = true, values
+	return jsonQuery
+}
+
+func (jsonQuery *JSONQueryExpression) writeJSONKey(builder clause.Builder) {
+	writeSplitJSONKey(builder, jsonQuery.column, jsonQuery.keys, writeJSONExtract)
+}
+
+func writeJSONExtract(builder clause.Builder, column string, keys []string) {
+	writeString(builder, "JSON_EXTRACT(")
+
+	builder.WriteQuoted(column)
+	writeString(builder, ",")
+	builder.AddVar(builder, JSONPath(keys))
+
+	writeString(builder, ")")
+}
+
+// writeSplitJSONKey writes the json key by the write, the keys of the spec and status of the split objects are
+// extracted from the spec and status columns, and fall back to the object for the rows written before the objects are split.
+func writeSplitJSONKey(builder clause.Builder, column string, keys []string, write func(builder clause.Builder, column string, keys []string)) {
+	if stmt, ok := builder.(*gorm.Statement); ok && column == "object" && SplitObjectsQueried(stmt) {
+		if splitColumn, splitKeys, ok := SplitKeys(keys); ok {
+			writeString(builder, "COALESCE(")
+			write(builder, splitColumn, splitKeys)
+			writeString(builder, ", ")
+			write(builder, column, keys)
+			writeString(builder, ")")
+			return
+		}
+	}
+	write(builder, column, keys)
+}
+
+// JSONPath returns the json path of the keys for mysql and sqlite, each key is quoted,
+// so the keys containing the dots and slashes, e.g. `example.com/team`, are treated as a single member.
+func JSONPath(keys []string) string {
+	quoted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		quoted = append(quoted, strconv.Quote(key))
+	}
+	return "$." + strings.Join(quoted, ".")
+}
+
+func (jsonQuery *JSONQueryExpression) writePostgresJSONKey(builder clause.Builder) {
+	writeSplitJSONKey(builder, jsonQuery.column, jsonQuery.keys, writePostgresJSONText)
+}
+
+func writePostgresJSONText(builder clause.Builder, column string, keys []string) {
+	builder.WriteQuoted(column)
+	for _, key := range keys[0 : len(keys)-1] {
+		writeString(builder, " -> ")
+		builder.AddVar(builder, key)
+	}
+	writeString(builder, " ->> ")
+	builder.AddVar(builder, keys[len(keys)-1])
+}
+
+func (jsonQuery *JSONQueryExpression) writeJSONKeyWithJSON_UNQUOTE(builder clause.Builder) {
+	writeString(builder, "JSON_UNQUOTE(")
+	jsonQuery.writeJSONKey(builder)
+	writeString(builder, ")")
+}
+
+func (jsonQuery *JSONQueryExpression) writeJSONKeyWithCAST_TO_TEXT(builder clause.Builder) {
+	writeString(builder, "CAST(")
+	jsonQuery.writeJSONKey(builder)
+	writeString(builder, " as TEXT)")
+}
+
+func (jsonQuery *JSONQueryExpression) Build(builder clause.Builder) {
+	if len(jsonQuery.keys) == 0 {
+		return
+	}
+
+	if stmt, ok := builder.(*gorm.Statement); ok {
+		dialector := stmt.Dialector.Name()
+		switch dialector {
+		case "mysql", "sqlite3", "sqlite":
+			if jsonQuery.not && len(jsonQuery.values) != 0 {
+				writeString(builder, "(")
+				defer func() {
+					writeString(builder, ")")
+				}()
+
+				jsonQuery.writeJSONKey(builder)
+				writeString(builder, " IS NULL")
+				writeString(builder, " OR ")
+			}
+
+			if dialector == "mysql" {
+				// Wrap`JSON_UNQUOTE` function to convert all json results to strings.
+				// https://github.com/clusterpedia-io/clusterpedia/pull/62
+				jsonQuery.writeJSONKeyWithJSON_UNQUOTE(builder)
+			} else {
+				// Wrap`CAST as TEXT` function to convert all json results to strings.
+				jsonQuery.writeJSONKeyWithCAST_TO_TEXT(builder)
+			}
+
+			switch len(jsonQuery.values) {
+			case 0:
+				if jsonQuery.not {
+					writeString(builder, " IS NULL")
+				} else {
+					writeString(builder, " IS NOT NULL")
+				}
+			case 1:
+				if jsonQuery.not {
+					writeString(builder, " != ")
+				} else {
+					writeString(builder, " = ")
+				}
+				builder.AddVar(builder, jsonQuery.values[0])
+			default:
+				if jsonQuery.not {
+					writeString(builder, " NOT IN ")
+				} else {
+					writeString(builder, " IN ")
+				}
+				builder.AddVar(builder, jsonQuery.values)
+			}
+		case "postgres":
+			if jsonQuery.not && len(jsonQuery.values) != 0 {
+				writeString(builder, "(")
+				defer func() {
+					writeString(builder, ")")
+				}()
+
+				jsonQuery.writePostgresJSONKey(builder)
+				writeString(builder, " IS NULL")
+				writeString(builder, " OR ")
+			}
+
+			jsonQuery.writePostgresJSONKey(builder)
+			switch len(jsonQuery.values) {
+			case 0:
+				if jsonQuery.not {
+					writeString(builder, " IS NULL")
+				} else {
+					writeString(builder, " IS NOT NULL")
+				}
+			case 1:
+				if jsonQuery.not {
+					writeString(builder, " != ")
+				} else {
+					writeString(builder, " = ")
+				}
+				builder.AddVar(builder, jsonQuery.values[0])
+			default:
+				if jsonQuery.not {
+					writeString(builder, " NOT IN ")
+				} else {
+					writeString(builder, " IN ")
+				}
+				builder.AddVar(builder, jsonQuery.values)
+			}
+		}
+	}
+}
+
+// JSONCompareExpression compares the values of the two json paths in the column, the missing value is
+// only equal to the missing value, and the ordering comparison with the missing value is false.
+type JSONCompareExpression struct {
+	column   string
+	left     []string
+	operator string
+	right    []string
+}
+
+func JSONCompare(column string, left []string, operator string, right []string) *JSONCompareExpression {
+	return &JSONCompareExpression{column: column, left: left, operator: operator, right: right}
+}
+
+func writePostgresJSONPath(builder clause.Builder, column string, keys []string) {
+	builder.WriteQuoted(column)
+	writeString(builder, " #> ")
+	builder.AddVar(builder, "{"+strings.Join(keys, ",")+"}")
+}
+
+func (compare *JSONCompareExpression) Build(builder clause.Builder) {
+	if len(compare.left) == 0 || len(compare.right) == 0 {
+		return
+	}
+
+	stmt, ok := builder.(*gorm.Statement)
+	if !ok {
+		return
+	}
+
+	// the json values are compared, so the numbers are compared by their values instead of the strings
+	write := writeJSONExtract
+	equal, notEqual := " IS ", " IS NOT "
+	switch stmt.Dialector.Name() {
+	case "mysql":
+		equal, notEqual = " <=> ", " <=> "
+	case "postgres":
+		write = writePostgresJSONPath
+		equal, notEqual = " IS NOT DISTINCT FROM ", " IS DISTINCT FROM "
+	}
+
+	operator := " " + compare.operator + " "
+	switch compare.operator {
+	case "=", "==":
+		operator = equal
+	case "!=":
+		operator = notEqual
+		if stmt.Dialector.Name() == "mysql" {
+			writeString(builder, "NOT (")
+			defer writeString(builder, ")")
+		}
+	}
+
+	writeSplitJSONKey(builder, compare.column, compare.left, write)
+	writeString(builder, operator)
+	writeSplitJSONKey(builder, compare.column, compare.right, write)
+}
+
+// OrderByExpression is the order by of the columns and the json values of the objects, the json values are
+// ordered by their json types, so the numbers are ordered by their values. The objects missing the value are
+// ordered last in both directions on every database, and the ties of the json values are ordered by the id,
+// so the pages of the offsets are stable.
+type OrderByExpression struct {
+	terms []orderByTerm
+}
+
+// orderByTerm is the raw column or the keys of the json value in the object.
+type orderByTerm struct {
+	column string
+	keys   []string
+	desc   bool
+}
+
+func (orderBy *OrderByExpression) Build(builder clause.Builder) {
+	stmt, ok := builder.(*gorm.Statement)
+	if !ok {
+		return
+	}
+
+	write := writeJSONExtract
+	if stmt.Dialector.Name() == "postgres" {
+		write = writePostgresJSONPath
+	}
+
+	var jsonOrdered bool
+	for i, term := range orderBy.terms {
+		if i > 0 {
+			writeString(builder, ",")
+		}
+		if term.keys == nil {
+			builder.WriteQuoted(clause.Column{Name: term.column, Raw: true})
+		} else {
+			jsonOrdered = true
+			writeSplitJSONKey(builder, "object", term.keys, write)
+			writeString(builder, " IS NULL,")
+			writeSplitJSONKey(builder, "object", term.keys, write)
+		}
+		if term.desc {
+			writeString(builder, " DESC")
+		}
+	}
+	if jsonOrdered {
+		writeString(builder, ",id")
+	}
+}
+
+func writeString(builder clause.Writer, str string) {
+	_, _ = builder.WriteString(str)
+}
@@ -0,0 +1,573 @@
+package querybuilder
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+const (
+	SearchLabelFuzzyName = "internalstorage.clusterpedia.io/fuzzy-name"
+
+	// URLQueryAnnotationSelector selects the resources by the annotations with the syntax of the label selector,
+	// e.g. `annotationSelector=example.com/team=payments,example.com/owner`.
+	URLQueryAnnotationSelector = "annotationSelector"
+
+	// URLQueryCompareFields selects the resources by comparing the values of the two fields of the objects,
+	// e.g. `compareFields=spec.replicas!=status.readyReplicas`, the supported operators are ==, =, !=, <, <=, > and >=.
+	// The comparisons of the multiple values are ANDed.
+	URLQueryCompareFields = "compareFields"
+)
+
+// LabelSelectorMode is how the requirements of the label selector are applied.
+type LabelSelectorMode string
+
+const (
+	// LabelSelectorJSON applies the requirements by the json queries of the labels in the objects.
+	LabelSelectorJSON LabelSelectorMode = "json"
+
+	// LabelSelectorTable applies the requirements by the EXISTS subqueries of the labels table if the selector only
+	// contains the equality and set-based inclusion requirements or the database can't query the json,
+	// otherwise the requirements are applied by the json queries.
+	LabelSelectorTable LabelSelectorMode = "table"
+
+	// LabelSelectorMemory doesn't translate the requirements, they are left to the post filter of the listed objects.
+	LabelSelectorMemory LabelSelectorMode = "memory"
+)
+
+// LabelsTable is the table of the labels of the resources queried by the LabelSelectorTable,
+// the label_key and label_value of the resource are stored in the row of its resource_id.
+const LabelsTable = "resource_labels"
+
+// SupportedOrderByFields are the columns which can be ordered by without the raw sql query,
+// the json paths of the objects are also supported, see the JSONOrderByPath.
+var SupportedOrderByFields = sets.New("cluster", "namespace", "name", "created_at", "resource_version")
+
+// JSONOrderByPath returns the keys of the order by field if it is the json path under the spec, status or metadata
+// of the objects, e.g. `spec.replicas` or `metadata.labels['app']`.
+func JSONOrderByPath(orderByField string) ([]string, bool) {
+	keys, err := ParseFieldPath(orderByField)
+	if err != nil || len(keys) < 2 {
+		return nil, false
+	}
+	switch keys[0] {
+	case "spec", "status", "metadata":
+		return keys, true
+	}
+	return nil, false
+}
+
+// Options are the options of the translation that aren't carried by the list options.
+type Options struct {
+	// AllowRawSQL allows the raw where sql and the order by the custom fields.
+	AllowRawSQL bool
+
+	// AllowParameterizedSQL allows the parameterized where sql.
+	AllowParameterizedSQL bool
+
+	// RestrictClusters filters the clusters even if the cluster names of the list options are empty,
+	// the empty cluster names of the restricted requester match no resources.
+	RestrictClusters bool
+
+	// StorageName is the name of the storage in the errors of the unsupported filters.
+	StorageName string
+
+	// LabelSelectorMode is how the requirements of the label selector are applied, the default is the json queries.
+	LabelSelectorMode LabelSelectorMode
+
+	// JSONUnsupported is set if the database can't query the json, e.g. the sqlite without the JSON1 extension.
+	JSONUnsupported bool
+
+	// Filter applies the filters of the caller after the filters of the list options, e.g. the owner of the resources,
+	// the remaining count, the order and the page are applied after it.
+	Filter func(query *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error)
+}
+
+// Filters describes where the filters of the list options are applied.
+type Filters struct {
+	// SQL are the filters applied by the sql.
+	SQL []string
+
+	// PostFilter are the filters that can't be translated to the sql,
+	// the callers need to apply them to the listed objects if they are required.
+	PostFilter []string
+}
+
+func (f *Filters) add(name string, sql bool) {
+	if sql {
+		f.SQL = append(f.SQL, name)
+	} else {
+		f.PostFilter = append(f.PostFilter, name)
+	}
+}
+
+// Result is the result of the translation of the list options.
+type Result struct {
+	Filters Filters
+
+	// Offset is the offset of the page parsed from the continue of the list options.
+	Offset int64
+
+	// RemainingCount is the count of the filtered resources, it is only counted if the list options require it.
+	RemainingCount *int64
+}
+
+// Describe describes the filters of the list options without building the query,
+// the filters are described in the order they are applied by Apply.
+func Describe(opts *internal.ListOptions, options Options) Filters {
+	var filters Filters
+	if len(opts.ClusterNames) != 0 || options.RestrictClusters {
+		filters.add("cluster", true)
+	}
+	if len(opts.Namespaces) != 0 {
+		filters.add("namespace", true)
+	}
+	if len(opts.Names) != 0 {
+		filters.add("name", true)
+	}
+	if opts.Since != nil || opts.Before != nil {
+		filters.add("created_at", true)
+	}
+	if whereSQLApplied(opts.URLQuery, options) {
+		filters.add("where_sql", true)
+	}
+	if opts.LabelSelector != nil {
+		if requirements, selectable := opts.LabelSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				filters.add("label:"+requirement.Key(), options.LabelSelectorMode != LabelSelectorMemory && isSupportedOperator(requirement.Operator()))
+			}
+		}
+	}
+	if annotationSelector, err := parseAnnotationSelector(opts.URLQuery); err == nil && annotationSelector != nil {
+		if requirements, selectable := annotationSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				filters.add("annotation:"+requirement.Key(), isSupportedOperator(requirement.Operator()))
+			}
+		}
+	}
+	if opts.ExtraLabelSelector != nil {
+		if requirements, selectable := opts.ExtraLabelSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				filters.add("extra_label:"+requirement.Key(), requirement.Key() == SearchLabelFuzzyName)
+			}
+		}
+	}
+	if opts.EnhancedFieldSelector != nil {
+		if requirements, selectable := opts.EnhancedFieldSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				names := make([]string, 0, len(requirement.Fields()))
+				for _, f := range requirement.Fields() {
+					names = append(names, f.Name())
+				}
+				filters.add("field:"+strings.Join(names, "."), isSupportedOperator(requirement.Operator()))
+			}
+		}
+	}
+	if len(opts.URLQuery[URLQueryCompareFields]) != 0 {
+		filters.add("compare_fields", true)
+	}
+	return filters
+}
+
+// Apply translates the list options to the query of the resources table, the filters, the order and the page
+// of the list options are applied to the query. The count of the filtered resources is queried before the page
+// is applied if the list options require the remaining count.
+//
+// The filters of the list options which can't be translated to the sql are ignored, they are described by
+// the PostFilter of the result.
+func Apply(query *gorm.DB, opts *internal.ListOptions, options Options) (*gorm.DB, *Result, error) {
+	result := &Result{Filters: Describe(opts, options)}
+
+	switch {
+	case len(opts.ClusterNames) == 0 && !options.RestrictClusters:
+	case len(opts.ClusterNames) == 1:
+		query = query.Where("cluster = ?", opts.ClusterNames[0])
+	default:
+		// the empty cluster names of the restricted requester match no resources
+		query = query.Where("cluster IN ?", opts.ClusterNames)
+	}
+
+	switch len(opts.Namespaces) {
+	case 0:
+	case 1:
+		query = query.Where("namespace = ?", opts.Namespaces[0])
+	default:
+		query = query.Where("namespace IN ?", opts.Namespaces)
+	}
+
+	switch len(opts.Names) {
+	case 0:
+	case 1:
+		query = query.Where("name = ?", opts.Names[0])
+	default:
+		query = query.Where("name IN ?", opts.Names)
+	}
+
+	if opts.Since != nil {
+		query = query.Where("created_at >= ?", opts.Since.Time.UTC())
+	}
+
+	if opts.Before != nil {
+		query = query.Where("created_at < ?", opts.Before.Time.UTC())
+	}
+
+	query, err := ApplyURLQueryWhereSQL(query, opts.URLQuery, options.AllowRawSQL, options.AllowParameterizedSQL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.LabelSelector != nil && options.LabelSelectorMode != LabelSelectorMemory {
+		if requirements, selectable := opts.LabelSelector.Requirements(); selectable {
+			byLabelsTable := useLabelsTable(requirements, options)
+			for _, requirement := range requirements {
+				if byLabelsTable {
+					if labelsQuery := buildLabelsTableQueryByRequirement(&requirement); labelsQuery != nil {
+						query = query.Where(labelsQuery)
+					}
+				} else if jsonQuery := buildJSONQueryByRequirement(&requirement, "metadata", "labels", requirement.Key()); jsonQuery != nil {
+					query = query.Where(jsonQuery)
+				}
+			}
+		}
+	}
+
+	annotationSelector, err := parseAnnotationSelector(opts.URLQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	if annotationSelector != nil {
+		if requirements, selectable := annotationSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				if jsonQuery := buildJSONQueryByRequirement(&requirement, "metadata", "annotations", requirement.Key()); jsonQuery != nil {
+					query = query.Where(jsonQuery)
+				}
+			}
+		}
+	}
+
+	if opts.ExtraLabelSelector != nil {
+		if requirements, selectable := opts.ExtraLabelSelector.Requirements(); selectable {
+			for _, require := range requirements {
+				switch require.Key() {
+				case SearchLabelFuzzyName:
+					for _, name := range require.Values().List() {
+						name = strings.TrimSpace(name)
+						query = query.Where("name LIKE ?", fmt.Sprintf(`%%%s%%`, name))
+					}
+				}
+			}
+		}
+	}
+
+	if opts.EnhancedFieldSelector != nil {
+		if requirements, selectable := opts.EnhancedFieldSelector.Requirements(); selectable {
+			for _, requirement := range requirements {
+				var (
+					fields      []string
+					fieldErrors field.ErrorList
+				)
+				for _, f := range requirement.Fields() {
+					if f.IsList() {
+						fieldErrors = append(fieldErrors, field.Invalid(f.Path(), f.Name(), fmt.Sprintf("Storage<%s>: Not Support list field", options.StorageName)))
+						continue
+					}
+
+					fields = append(fields, f.Name())
+				}
+
+				if len(fieldErrors) != 0 {
+					return nil, nil, apierrors.NewInvalid(schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"}, "fieldSelector", fieldErrors)
+				}
+
+				if jsonQuery := buildJSONQueryByRequirement(&requirement, fields...); jsonQuery != nil {
+					query = query.Where(jsonQuery)
+				}
+			}
+		}
+	}
+
+	comparisons, err := parseCompareFields(opts.URLQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, comparison := range comparisons {
+		query = query.Where(JSONCompare("object", comparison.left, comparison.operator, comparison.right))
+	}
+
+	if options.Filter != nil {
+		query, err = options.Filter(query, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if opts.WithRemainingCount != nil && *opts.WithRemainingCount {
+		result.RemainingCount = new(int64)
+		query = query.Count(result.RemainingCount)
+	}
+
+	// Due to performance reasons, the default order by is not set.
+	// https://github.com/clusterpedia-io/clusterpedia/pull/44
+	//
+	// The order by is a single expression, since gorm doesn't merge the expressions of the order by clauses,
+	// and the json values aren't selected, so the scanned columns of the list aren't changed by the order.
+	orderBy := &OrderByExpression{}
+	for i, orderby := range opts.OrderBy {
+		orderByField := orderby.Field
+		if keys, ok := JSONOrderByPath(orderByField); ok {
+			orderBy.terms = append(orderBy.terms, orderByTerm{keys: keys, desc: orderby.Desc})
+			continue
+		}
+
+		// the field is written to the query without the parameterization,
+		// so the custom field is only allowed with the raw sql query.
+		if !options.AllowRawSQL && !SupportedOrderByFields.Has(orderByField) {
+			return nil, nil, apierrors.NewBadRequest(field.NotSupported(
+				field.NewPath("orderby").Index(i).Child("field"), orderByField, sets.List(SupportedOrderByFields),
+			).Error())
+		}
+		if orderByField == "resource_version" {
+			orderByField = "CAST(resource_version as decimal)"
+		}
+		orderBy.terms = append(orderBy.terms, orderByTerm{column: orderByField, desc: orderby.Desc})
+	}
+	if len(orderBy.terms) != 0 {
+		query = query.Clauses(clause.OrderBy{Expression: orderBy})
+	}
+	// kube ListOptions does not specify a limit default value of 0, gorm will execute limit = 0, resulting in the return of empty data.
+	// https://github.com/go-gorm/gorm/commit/e8f48b5c155b6fbf2e1fe6a554e2280f62af21a7
+	if opts.Limit > 0 {
+		query = query.Limit(int(opts.Limit))
+	}
+
+	offset, err := strconv.Atoi(opts.Continue)
+	if err == nil {
+		query = query.Offset(offset)
+	}
+	result.Offset = int64(offset)
+	return query, result, nil
+}
+
+// whereSQLApplied returns whether the where sql of the url query is applied by the ApplyURLQueryWhereSQL.
+func whereSQLApplied(urlQuery url.Values, options Options) bool {
+	if !options.AllowRawSQL && !options.AllowParameterizedSQL {
+		return false
+	}
+	params, err := NewURLQueryWhereSQLParamsFromURLValues(urlQuery)
+	if err != nil {
+		return false
+	}
+	return params.WhereSQLStatement != "" || (options.AllowRawSQL && params.WhereSQL != "")
+}
+
+// parseAnnotationSelector parses the annotation selector from the url query,
+// a nil selector is returned if the annotation selector is not specified.
+func parseAnnotationSelector(urlQuery url.Values) (labels.Selector, error) {
+	raw := urlQuery.Get(URLQueryAnnotationSelector)
+	if raw == "" {
+		return nil, nil
+	}
+
+	selector, err := labels.Parse(raw)
+	if err != nil {
+		return nil, apierrors.NewInvalid(
+			schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"},
+			"urlQuery",
+			field.ErrorList{
+				field.Invalid(field.NewPath(URLQueryAnnotationSelector), raw, err.Error()),
+			},
+		)
+	}
+	return selector, nil
+}
+
+// fieldComparison is the comparison of the values of the two fields parsed from the compare fields.
+type fieldComparison struct {
+	left     []string
+	operator string
+	right    []string
+}
+
+// parseCompareFields parses the comparisons of the fields from the url query.
+func parseCompareFields(urlQuery url.Values) ([]fieldComparison, error) {
+	var (
+		comparisons []fieldComparison
+		fieldErrors field.ErrorList
+	)
+	for i, raw := range urlQuery[URLQueryCompareFields] {
+		comparison, err := parseFieldComparison(raw)
+		if err != nil {
+			fieldErrors = append(fieldErrors, field.Invalid(field.NewPath(URLQueryCompareFields).Index(i), raw, err.Error()))
+			continue
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	if len(fieldErrors) != 0 {
+		return nil, apierrors.NewInvalid(schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"}, "urlQuery", fieldErrors)
+	}
+	return comparisons, nil
+}
+
+// parseFieldComparison parses the comparison like `spec.replicas!=status.readyReplicas`,
+// the operator in the quoted keys of the paths is ignored.
+func parseFieldComparison(raw string) (fieldComparison, error) {
+	quoted := false
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\'':
+			quoted = !quoted
+			continue
+		case '=', '!', '<', '>':
+		default:
+			continue
+		}
+		if quoted {
+			continue
+		}
+
+		operator := raw[i : i+1]
+		if i+1 < len(raw) && raw[i+1] == '=' {
+			operator = raw[i : i+2]
+		}
+		if operator == "!" {
+			return fieldComparison{}, errors.New("unsupported operator !")
+		}
+
+		left, err := ParseFieldPath(strings.TrimSpace(raw[:i]))
+		if err != nil {
+			return fieldComparison{}, err
+		}
+		right, err := ParseFieldPath(strings.TrimSpace(raw[i+len(operator):]))
+		if err != nil {
+			return fieldComparison{}, err
+		}
+		return fieldComparison{left: left, operator: operator, right: right}, nil
+	}
+	return fieldComparison{}, errors.New("the operator is required, one of ==, =, !=, <, <=, > and >=")
+}
+
+// ParseFieldPath parses the path like `metadata.annotations['example.io/key']` into the keys.
+func ParseFieldPath(path string) ([]string, error) {
+	var keys []string
+	for rest := path; rest != ""; {
+		if strings.HasPrefix(rest, "['") {
+			end := strings.Index(rest, "']")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: the quoted key isn't closed", path)
+			}
+			keys, rest = append(keys, rest[2:end]), rest[end+2:]
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: empty key", path)
+			}
+			keys, rest = append(keys, rest[:end]), rest[end:]
+		}
+
+		if strings.HasPrefix(rest, ".") {
+			if rest = rest[1:]; rest == "" {
+				return nil, fmt.Errorf("invalid path %q: empty key", path)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("path is required")
+	}
+	return keys, nil
+}
+
+// requirement is implemented by both the label requirement and the enhanced field requirement.
+type requirement interface {
+	Operator() selection.Operator
+	Values() sets.String
+}
+
+// buildJSONQueryByRequirement builds the json query of the keys in the object by the requirement,
+// it returns nil if the operator of the requirement is not supported.
+func buildJSONQueryByRequirement(requirement requirement, keys ...string) *JSONQueryExpression {
+	values := requirement.Values().List()
+	jsonQuery := JSONQuery("object", keys...)
+	switch requirement.Operator() {
+	case selection.Exists:
+		return jsonQuery.Exist()
+	case selection.DoesNotExist:
+		return jsonQuery.NotExist()
+	case selection.Equals, selection.DoubleEquals:
+		return jsonQuery.Equal(values[0])
+	case selection.NotEquals:
+		return jsonQuery.NotEqual(values[0])
+	case selection.In:
+		return jsonQuery.In(values...)
+	case selection.NotIn:
+		return jsonQuery.NotIn(values...)
+	}
+	return nil
+}
+
+// useLabelsTable returns whether the requirements of the label selector are applied by the labels table.
+func useLabelsTable(requirements labels.Requirements, options Options) bool {
+	if options.LabelSelectorMode != LabelSelectorTable {
+		return false
+	}
+	if options.JSONUnsupported {
+		return true
+	}
+	for _, requirement := range requirements {
+		switch requirement.Operator() {
+		case selection.Equals, selection.DoubleEquals, selection.In:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// buildLabelsTableQueryByRequirement builds the EXISTS subquery of the labels table by the requirement,
+// the negative requirements match the resources without the label, the same as the label selector.
+// It returns nil if the operator of the requirement is not supported.
+func buildLabelsTableQueryByRequirement(requirement *labels.Requirement) clause.Expression {
+	exists := "EXISTS (SELECT 1 FROM " + LabelsTable + " WHERE " + LabelsTable + ".resource_id = resources.id AND " + LabelsTable + ".label_key = ?"
+	values := requirement.Values().List()
+	switch requirement.Operator() {
+	case selection.Exists:
+		return clause.Expr{SQL: exists + ")", Vars: []interface{}{requirement.Key()}}
+	case selection.DoesNotExist:
+		return clause.Expr{SQL: "NOT " + exists + ")", Vars: []interface{}{requirement.Key()}}
+	case selection.Equals, selection.DoubleEquals:
+		return clause.Expr{SQL: exists + " AND " + LabelsTable + ".label_value = ?)", Vars: []interface{}{requirement.Key(), values[0]}}
+	case selection.NotEquals:
+		return clause.Expr{SQL: "NOT " + exists + " AND " + LabelsTable + ".label_value = ?)", Vars: []interface{}{requirement.Key(), values[0]}}
+	case selection.In:
+		return clause.Expr{SQL: exists + " AND " + LabelsTable + ".label_value IN ?)", Vars: []interface{}{requirement.Key(), values}}
+	case selection.NotIn:
+		return clause.Expr{SQL: "NOT " + exists + " AND " + LabelsTable + ".label_value IN ?)", Vars: []interface{}{requirement.Key(), values}}
+	}
+	return nil
+}
+
+// isSupportedOperator returns whether the operator of the requirement can be translated to the json query.
+func isSupportedOperator(operator selection.Operator) bool {
+	switch operator {
+	case selection.Exists, selection.DoesNotExist,
+		selection.Equals, selection.DoubleEquals, selection.NotEquals,
+		selection.In, selection.NotIn:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,373 @@
+package querybuilder
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	gmysql "gorm.io/driver/mysql"
+	gpostgres "gorm.io/driver/postgres"
+	gsqlite "gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/api/clusterpedia/fields"
+)
+
+// dialects are the databases of the golden sql, the sql is only built by the dry run sessions.
+var dialects = map[string]*gorm.DB{}
+
+func TestMain(m *testing.M) {
+	postgresDB, _, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+	if dialects["postgres"], err = gorm.Open(gpostgres.New(gpostgres.Config{Conn: postgresDB})); err != nil {
+		panic(err)
+	}
+
+	mysqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		panic(err)
+	}
+	mock.ExpectQuery("SELECT VERSION()").WillReturnRows(sqlmock.NewRows([]string{"VERSION()"}).AddRow("8.0.27"))
+	if dialects["mysql"], err = gorm.Open(gmysql.New(gmysql.Config{Conn: mysqlDB})); err != nil {
+		panic(err)
+	}
+
+	if dialects["sqlite"], err = gorm.Open(gsqlite.Open("file::memory:")); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+type golden struct {
+	postgres string
+	mysql    string
+	sqlite   string
+}
+
+func (g golden) sql(dialect string) string {
+	switch dialect {
+	case "postgres":
+		return g.postgres
+	case "mysql":
+		return g.mysql
+	}
+	return g.sqlite
+}
+
+func buildSQL(t *testing.T, db *gorm.DB, opts *internal.ListOptions, options Options) (string, *Result) {
+	query := db.Session(&gorm.Session{DryRun: true}).Table("resources")
+	query, result, err := Apply(query, opts, options)
+	require.NoError(t, err)
+
+	stmt := query.Find(&[]map[string]interface{}{}).Statement
+	return db.Dialector.Explain(stmt.SQL.String(), stmt.Vars...), result
+}
+
+func mustParseFieldSelector(t *testing.T, selector string) fields.Selector {
+	parsed, err := fields.Parse(selector)
+	require.NoError(t, err)
+	return parsed
+}
+
+func mustParseLabelSelector(t *testing.T, selector string) labels.Selector {
+	parsed, err := labels.Parse(selector)
+	require.NoError(t, err)
+	return parsed
+}
+
+func withPage(opts *internal.ListOptions, limit int64, continueToken string) *internal.ListOptions {
+	opts.Limit, opts.Continue = limit, continueToken
+	return opts
+}
+
+func withLabelSelector(opts *internal.ListOptions, selector labels.Selector) *internal.ListOptions {
+	opts.LabelSelector = selector
+	return opts
+}
+
+// TestApplyGoldenSQL locks the sql of the list options on every dialect,
+// the changes of the golden sql change the queries of the existing users.
+func TestApplyGoldenSQL(t *testing.T) {
+	since := metav1.NewTime(time.Date(2022, 3, 4, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name    string
+		opts    *internal.ListOptions
+		options Options
+		filters Filters
+		golden  golden
+	}{
+		{
+			name: "empty",
+			opts: &internal.ListOptions{},
+			golden: golden{
+				`SELECT * FROM "resources"`,
+				"SELECT * FROM `resources`",
+				"SELECT * FROM `resources`",
+			},
+		},
+		{
+			name: "metadata",
+			opts: &internal.ListOptions{
+				ClusterNames: []string{"cluster-1", "cluster-2"},
+				Namespaces:   []string{"default"},
+				Names:        []string{"a", "b"},
+				Since:        &since,
+			},
+			filters: Filters{SQL: []string{"cluster", "namespace", "name", "created_at"}},
+			golden: golden{
+				`SELECT * FROM "resources" WHERE cluster IN ('cluster-1','cluster-2') AND namespace = 'default' AND name IN ('a','b') AND created_at >= '2022-03-04 00:00:00'`,
+				"SELECT * FROM `resources` WHERE cluster IN ('cluster-1','cluster-2') AND namespace = 'default' AND name IN ('a','b') AND created_at >= '2022-03-04 00:00:00'",
+				"SELECT * FROM `resources` WHERE cluster IN (\"cluster-1\",\"cluster-2\") AND namespace = \"default\" AND name IN (\"a\",\"b\") AND created_at >= \"2022-03-04 00:00:00\"",
+			},
+		},
+		{
+			name:    "restricted to no clusters",
+			opts:    &internal.ListOptions{},
+			options: Options{RestrictClusters: true},
+			filters: Filters{SQL: []string{"cluster"}},
+			golden: golden{
+				`SELECT * FROM "resources" WHERE cluster IN (NULL)`,
+				"SELECT * FROM `resources` WHERE cluster IN (NULL)",
+				"SELECT * FROM `resources` WHERE cluster IN (NULL)",
+			},
+		},
+		{
+			name: "selectors",
+			opts: withLabelSelector(&internal.ListOptions{
+				ExtraLabelSelector:    labels.SelectorFromSet(labels.Set{SearchLabelFuzzyName: "ngin"}),
+				EnhancedFieldSelector: mustParseFieldSelector(t, "spec.replicas=2"),
+				URLQuery:              url.Values{URLQueryAnnotationSelector: []string{"team"}},
+			}, labels.SelectorFromSet(labels.Set{"app": "nginx"})),
+			filters: Filters{SQL: []string{"label:app", "annotation:team", "extra_label:" + SearchLabelFuzzyName, "field:spec.replicas"}},
+			golden: golden{
+				`SELECT * FROM "resources" WHERE "object" -> 'metadata' -> 'labels' ->> 'app' = 'nginx' AND "object" -> 'metadata' -> 'annotations' ->> 'team' IS NOT NULL AND name LIKE '%ngin%' AND "object" -> 'spec' ->> 'replicas' = '2'`,
+				"SELECT * FROM `resources` WHERE JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"labels\".\"app\"')) = 'nginx' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"annotations\".\"team\"')) IS NOT NULL AND name LIKE '%ngin%' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"')) = '2'",
+				"SELECT * FROM `resources` WHERE CAST(JSON_EXTRACT(`object`,\"$.\\\"metadata\\\".\\\"labels\\\".\\\"app\\\"\") as TEXT) = \"nginx\" AND CAST(JSON_EXTRACT(`object`,\"$.\\\"metadata\\\".\\\"annotations\\\".\\\"team\\\"\") as TEXT) IS NOT NULL AND name LIKE \"%ngin%\" AND CAST(JSON_EXTRACT(`object`,\"$.\\\"spec\\\".\\\"replicas\\\"\") as TEXT) = \"2\"",
+			},
+		},
+		{
+			name: "unsupported selectors",
+			opts: withLabelSelector(&internal.ListOptions{
+				ExtraLabelSelector: labels.SelectorFromSet(labels.Set{"unknown": "value"}),
+			}, mustParseLabelSelector(t, "replicas>1")),
+			filters: Filters{PostFilter: []string{"label:replicas", "extra_label:unknown"}},
+			golden: golden{
+				`SELECT * FROM "resources"`,
+				"SELECT * FROM `resources`",
+				"SELECT * FROM `resources`",
+			},
+		},
+		{
+			name: "compare fields",
+			opts: &internal.ListOptions{
+				URLQuery: url.Values{URLQueryCompareFields: []string{"spec.replicas!=status.readyReplicas"}},
+			},
+			filters: Filters{SQL: []string{"compare_fields"}},
+			golden: golden{
+				`SELECT * FROM "resources" WHERE "object" #> '{spec,replicas}' IS DISTINCT FROM "object" #> '{status,readyReplicas}'`,
+				"SELECT * FROM `resources` WHERE NOT (JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"') <=> JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"'))",
+				"SELECT * FROM `resources` WHERE JSON_EXTRACT(`object`,\"$.\\\"spec\\\".\\\"replicas\\\"\") IS NOT JSON_EXTRACT(`object`,\"$.\\\"status\\\".\\\"readyReplicas\\\"\")",
+			},
+		},
+		{
+			name: "where sql",
+			opts: &internal.ListOptions{
+				URLQuery: url.Values{URLQueryFieldWhereSQLStatement: []string{"uid = ?"}, URLQueryFieldWhereSQLParam: []string{"abc"}},
+			},
+			options: Options{AllowParameterizedSQL: true},
+			filters: Filters{SQL: []string{"where_sql"}},
+			golden: golden{
+				`SELECT * FROM "resources" WHERE uid = 'abc'`,
+				"SELECT * FROM `resources` WHERE uid = 'abc'",
+				"SELECT * FROM `resources` WHERE uid = \"abc\"",
+			},
+		},
+		{
+			name: "disallowed where sql",
+			opts: &internal.ListOptions{
+				URLQuery: url.Values{URLQueryWhereSQL: []string{"uid = 'abc'"}},
+			},
+			options: Options{AllowParameterizedSQL: true},
+			golden: golden{
+				`SELECT * FROM "resources"`,
+				"SELECT * FROM `resources`",
+				"SELECT * FROM `resources`",
+			},
+		},
+		{
+			name: "order and page",
+			opts: withPage(&internal.ListOptions{
+				OrderBy: []internal.OrderBy{{Field: "namespace"}, {Field: "resource_version", Desc: true}},
+			}, 10, "20"),
+			golden: golden{
+				`SELECT * FROM "resources" ORDER BY namespace,CAST(resource_version as decimal) DESC LIMIT 10 OFFSET 20`,
+				"SELECT * FROM `resources` ORDER BY namespace,CAST(resource_version as decimal) DESC LIMIT 10 OFFSET 20",
+				"SELECT * FROM `resources` ORDER BY namespace,CAST(resource_version as decimal) DESC LIMIT 10 OFFSET 20",
+			},
+		},
+		{
+			name: "json order and page",
+			opts: withPage(&internal.ListOptions{
+				OrderBy: []internal.OrderBy{{Field: "spec.replicas", Desc: true}, {Field: "name"}},
+			}, 10, "20"),
+			golden: golden{
+				`SELECT * FROM "resources" ORDER BY "object" #> '{spec,replicas}' IS NULL,"object" #> '{spec,replicas}' DESC,name,id LIMIT 10 OFFSET 20`,
+				"SELECT * FROM `resources` ORDER BY JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"') IS NULL,JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"') DESC,name,id LIMIT 10 OFFSET 20",
+				"SELECT * FROM `resources` ORDER BY JSON_EXTRACT(`object`,\"$.\\\"spec\\\".\\\"replicas\\\"\") IS NULL,JSON_EXTRACT(`object`,\"$.\\\"spec\\\".\\\"replicas\\\"\") DESC,name,id LIMIT 10 OFFSET 20",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		for _, dialect := range []string{"postgres", "mysql", "sqlite"} {
+			t.Run(fmt.Sprintf("%s %s", test.name, dialect), func(t *testing.T) {
+				sql, result := buildSQL(t, dialects[dialect], test.opts, test.options)
+				assert.Equal(t, test.golden.sql(dialect), sql)
+				assert.Equal(t, test.filters, result.Filters)
+				assert.Equal(t, test.filters, Describe(test.opts, test.options))
+			})
+		}
+	}
+}
+
+func TestApplyLabelSelectorModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector string
+		options  Options
+		filters  Filters
+		sql      string
+	}{
+		{
+			name:     "table",
+			selector: "app=nginx,tier in (web)",
+			options:  Options{LabelSelectorMode: LabelSelectorTable},
+			filters:  Filters{SQL: []string{"label:app", "label:tier"}},
+			sql:      "SELECT * FROM `resources` WHERE (EXISTS (SELECT 1 FROM resource_labels WHERE resource_labels.resource_id = resources.id AND resource_labels.label_key = 'app' AND resource_labels.label_value = 'nginx')) AND (EXISTS (SELECT 1 FROM resource_labels WHERE resource_labels.resource_id = resources.id AND resource_labels.label_key = 'tier' AND resource_labels.label_value IN ('web')))",
+		},
+		{
+			// the selector with the exclusion requirements is applied by the json queries
+			name:     "table with exclusion",
+			selector: "app=nginx,tier!=db",
+			options:  Options{LabelSelectorMode: LabelSelectorTable},
+			filters:  Filters{SQL: []string{"label:app", "label:tier"}},
+			sql:      "SELECT * FROM `resources` WHERE JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"labels\".\"app\"')) = 'nginx' AND (JSON_EXTRACT(`object`,'$.\"metadata\".\"labels\".\"tier\"') IS NULL OR JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"labels\".\"tier\"')) != 'db')",
+		},
+		{
+			name:     "table without json",
+			selector: "tier!=db,!canary",
+			options:  Options{LabelSelectorMode: LabelSelectorTable, JSONUnsupported: true},
+			filters:  Filters{SQL: []string{"label:canary", "label:tier"}},
+			sql:      "SELECT * FROM `resources` WHERE (NOT EXISTS (SELECT 1 FROM resource_labels WHERE resource_labels.resource_id = resources.id AND resource_labels.label_key = 'canary')) AND (NOT EXISTS (SELECT 1 FROM resource_labels WHERE resource_labels.resource_id = resources.id AND resource_labels.label_key = 'tier' AND resource_labels.label_value = 'db'))",
+		},
+		{
+			name:     "memory",
+			selector: "app=nginx",
+			options:  Options{LabelSelectorMode: LabelSelectorMemory},
+			filters:  Filters{PostFilter: []string{"label:app"}},
+			sql:      "SELECT * FROM `resources`",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := withLabelSelector(&internal.ListOptions{}, mustParseLabelSelector(t, test.selector))
+			sql, result := buildSQL(t, dialects["mysql"], opts, test.options)
+			assert.Equal(t, test.sql, sql)
+			assert.Equal(t, test.filters, result.Filters)
+			assert.Equal(t, test.filters, Describe(opts, test.options))
+		})
+	}
+}
+
+func TestApplyResult(t *testing.T) {
+	withRemainingCount := true
+	opts := withPage(&internal.ListOptions{WithRemainingCount: &withRemainingCount}, 0, "5")
+	options := Options{
+		Filter: func(query *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error) {
+			return query.Where("owner_uid = ?", "uid"), nil
+		},
+	}
+
+	// the statement of the dry run is the count, the filter of the caller is applied before it
+	sql, result := buildSQL(t, dialects["mysql"], opts, options)
+	assert.Equal(t, "SELECT count(*) FROM `resources` WHERE owner_uid = 'uid'", sql)
+	assert.Equal(t, int64(5), result.Offset)
+	assert.NotNil(t, result.RemainingCount)
+
+	_, result = buildSQL(t, dialects["mysql"], withPage(&internal.ListOptions{}, 0, "invalid"), Options{})
+	assert.Zero(t, result.Offset)
+	assert.Nil(t, result.RemainingCount)
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *internal.ListOptions
+		options Options
+	}{
+		{
+			name: "list field",
+			opts: &internal.ListOptions{EnhancedFieldSelector: mustParseFieldSelector(t, "spec.containers[].name=nginx")},
+		},
+		{
+			name: "invalid annotation selector",
+			opts: &internal.ListOptions{URLQuery: url.Values{URLQueryAnnotationSelector: []string{"team in"}}},
+		},
+		{
+			name: "invalid compare fields",
+			opts: &internal.ListOptions{URLQuery: url.Values{URLQueryCompareFields: []string{"spec.replicas"}}},
+		},
+		{
+			name: "unsupported order by",
+			opts: &internal.ListOptions{OrderBy: []internal.OrderBy{{Field: "uid"}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := dialects["mysql"].Session(&gorm.Session{DryRun: true}).Table("resources")
+			_, _, err := Apply(query, test.opts, test.options)
+			require.Error(t, err)
+			assert.True(t, apierrors.IsInvalid(err) || apierrors.IsBadRequest(err), err)
+		})
+	}
+
+	// the custom order by is allowed with the raw sql
+	query := dialects["mysql"].Session(&gorm.Session{DryRun: true}).Table("resources")
+	_, _, err := Apply(query, &internal.ListOptions{OrderBy: []internal.OrderBy{{Field: "uid"}}}, Options{AllowRawSQL: true})
+	assert.NoError(t, err)
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []string
+	}{
+		{path: "data", expected: []string{"data"}},
+		{path: "spec.template.spec", expected: []string{"spec", "template", "spec"}},
+		{path: "metadata.annotations['example.io/key']", expected: []string{"metadata", "annotations", "example.io/key"}},
+		{path: "['a.b'].c", expected: []string{"a.b", "c"}},
+	}
+	for _, test := range tests {
+		keys, err := ParseFieldPath(test.path)
+		require.NoError(t, err, test.path)
+		assert.Equal(t, test.expected, keys, test.path)
+	}
+
+	for _, path := range []string{"", "data.", ".data", "a..b", "metadata.annotations['key"} {
+		_, err := ParseFieldPath(path)
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,257 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"golang.org/x/time/rate"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	defaultRebuildBatchSize     = 500
+	defaultRebuildRowsPerSecond = 1000
+)
+
+var _ storage.SecondaryDataRebuilder = &StorageFactory{}
+
+// rebuildRow is the row scanned by the rebuilding, the secondary data are compared with the ones derived from the object.
+type rebuildRow struct {
+	ID              uint
+	OwnerUID        types.UID `gorm:"column:owner_uid"`
+	UID             types.UID
+	ResourceVersion string
+	Object          datatypes.JSON
+	Metadata        datatypes.JSON
+}
+
+func (row rebuildRow) rowID() uint {
+	return row.ID
+}
+
+// RebuildSecondaryData scans the resources in batches by the order of the id, and fixes the owner uid, uid,
+// resource version and metadata which are mismatched with the stored object, e.g. backfills the metadata column.
+// The labels in the labels table are fixed too if the label selectors are applied by the table. The resource updated by the sync
+// during the rebuilding is skipped, since its secondary data is derived from the object by the sync.
+func (s *StorageFactory) RebuildSecondaryData(ctx context.Context, opts storage.RebuildOptions) (storage.RebuildSummary, error) {
+	var summary storage.RebuildSummary
+	err := scanResources(ctx, s.namedDatabases(), scanOptions{
+		BatchSize:     opts.BatchSize,
+		RowsPerSecond: opts.RowsPerSecond,
+		Cursor:        opts.Cursor,
+		Progress: func(cursor string) {
+			summary.Cursor = cursor
+			if opts.Progress != nil {
+				opts.Progress(summary)
+			}
+		},
+	}, []string{"id", "owner_uid", "uid", "resource_version", "object", "metadata"}, func(db *gorm.DB, row rebuildRow) error {
+		return rebuildResource(ctx, db, row, s.encryption, s.resourceVersionMaxLength, s.labelSelectorMode == LabelSelectorTable, opts.VerifyOnly, &summary)
+	})
+	return summary, err
+}
+
+func rebuildResource(ctx context.Context, db *gorm.DB, row rebuildRow, encryption *objectEncryption, resourceVersionMaxLength int, labelsTable, verifyOnly bool, summary *storage.RebuildSummary) error {
+	summary.Scanned++
+
+	object, err := encryption.decrypt(row.Object)
+	if err != nil {
+		klog.ErrorS(err, "Failed to decrypt the stored object", "id", row.ID)
+		return nil
+	}
+	var obj struct {
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &obj); err != nil {
+		// the broken object can't be rebuilt, it is left to be overwritten by the sync
+		klog.ErrorS(err, "Failed to decode the stored object", "id", row.ID)
+		return nil
+	}
+
+	var ownerUID types.UID
+	if owner := metav1.GetControllerOfNoCopy(&obj.Metadata); owner != nil {
+		ownerUID = owner.UID
+	}
+	resourceVersion := storedResourceVersion(obj.Metadata.ResourceVersion, resourceVersionMaxLength)
+	columnsMatched := row.OwnerUID == ownerUID && row.UID == obj.Metadata.UID && row.ResourceVersion == resourceVersion &&
+		metadataMatched(row.Metadata, &obj.Metadata)
+	labelsMismatched := false
+	if labelsTable {
+		stored, err := storedLabels(db.WithContext(ctx), row.ID)
+		if err != nil {
+			return InterpretDBError(fmt.Sprintf("resource %d", row.ID), err)
+		}
+		labelsMismatched = !labelsMatched(stored, obj.Metadata.Labels)
+	}
+	if columnsMatched && !labelsMismatched {
+		return nil
+	}
+
+	summary.Mismatched++
+	klog.V(2).InfoS("The secondary data is mismatched with the stored object", "id", row.ID, "namespace", obj.Metadata.Namespace, "name", obj.Metadata.Name)
+	if verifyOnly {
+		return nil
+	}
+
+	metadata, err := json.Marshal(&obj.Metadata)
+	if err != nil {
+		return err
+	}
+
+	// the resource version of the row guards the update of the sync during the rebuilding,
+	// and the synced_at isn't updated since the resource is not synced.
+	guard := map[string]interface{}{"id": row.ID, "resource_version": row.ResourceVersion}
+	conflicted := false
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var rowsAffected int64
+		if columnsMatched {
+			// the unchanged columns aren't updated, so the guard is checked by the count of the row
+			if err := tx.Model(&Resource{}).Where(guard).Count(&rowsAffected).Error; err != nil {
+				return err
+			}
+		} else {
+			result := tx.Model(&Resource{}).Where(guard).UpdateColumns(map[string]interface{}{
+				"owner_uid":        ownerUID,
+				"uid":              obj.Metadata.UID,
+				"resource_version": resourceVersion,
+				"metadata":         datatypes.JSON(metadata),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			rowsAffected = result.RowsAffected
+		}
+		if rowsAffected == 0 {
+			conflicted = true
+			return nil
+		}
+		if labelsMismatched {
+			return replaceLabels(tx, row.ID, obj.Metadata.Labels)
+		}
+		return nil
+	})
+	if err != nil {
+		return InterpretDBError(fmt.Sprintf("resource %d", row.ID), err)
+	}
+	if conflicted {
+		summary.Conflicted++
+		return nil
+	}
+	summary.Fixed++
+	return nil
+}
+
+// metadataMatched compares the metadata column with the metadata of the object semantically,
+// since the json may be normalized by the database, e.g. the jsonb of postgres.
+func metadataMatched(column datatypes.JSON, metadata *metav1.ObjectMeta) bool {
+	if len(column) == 0 {
+		return false
+	}
+
+	var stored metav1.ObjectMeta
+	if err := json.Unmarshal(column, &stored); err != nil {
+		return false
+	}
+	return equality.Semantic.DeepEqual(&stored, metadata)
+}
+
+func (s *StorageFactory) namedDatabases() map[string]*gorm.DB {
+	if s.router == nil {
+		return map[string]*gorm.DB{DefaultDatabaseName: s.db}
+	}
+	return s.router.databases
+}
+
+// scannedRow is the row scanned by scanResources.
+type scannedRow interface {
+	rowID() uint
+}
+
+type scanOptions struct {
+	BatchSize     int
+	RowsPerSecond int
+
+	// Cursor resumes the scanning from the last scanned ids of the databases.
+	Cursor string
+
+	// Progress is called with the cursor after each batch.
+	Progress func(cursor string)
+}
+
+// scanCursor is the last scanned id of each database, it is encoded to json as the cursor of the summary.
+type scanCursor map[string]uint
+
+// scanResources scans the columns of the resources of the databases in batches by the order of the id,
+// the rate of the scanned rows is limited, so the scanning is safe to run while the resources are synced.
+func scanResources[T scannedRow](ctx context.Context, databases map[string]*gorm.DB, opts scanOptions, columns []string, fn func(db *gorm.DB, row T) error) error {
+	cursor := scanCursor{}
+	if opts.Cursor != "" {
+		if err := json.Unmarshal([]byte(opts.Cursor), &cursor); err != nil {
+			return fmt.Errorf("invalid cursor %q: %w", opts.Cursor, err)
+		}
+	}
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultRebuildBatchSize
+	}
+	rowsPerSecond := opts.RowsPerSecond
+	if rowsPerSecond <= 0 {
+		rowsPerSecond = defaultRebuildRowsPerSecond
+	}
+	if batchSize > rowsPerSecond {
+		batchSize = rowsPerSecond
+	}
+	limiter := rate.NewLimiter(rate.Limit(rowsPerSecond), batchSize)
+
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		db := databases[name]
+		for {
+			if err := limiter.WaitN(ctx, batchSize); err != nil {
+				return err
+			}
+
+			var rows []T
+			result := db.WithContext(ctx).Model(&Resource{}).Select(columns).
+				Where("id > ?", cursor[name]).Order("id").Limit(batchSize).Find(&rows)
+			if result.Error != nil {
+				return InterpretDBError(name, result.Error)
+			}
+
+			for _, row := range rows {
+				if err := fn(db, row); err != nil {
+					return err
+				}
+				cursor[name] = row.rowID()
+			}
+
+			encoded, err := json.Marshal(cursor)
+			if err != nil {
+				return err
+			}
+			if opts.Progress != nil {
+				opts.Progress(string(encoded))
+			}
+
+			if len(rows) < batchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
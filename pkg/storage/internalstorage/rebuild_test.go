@@ -0,0 +1,85 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestRebuildSecondaryData(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i, ownerUID := range []types.UID{"owner-0", "drifted", "owner-2"} {
+		metadata := fmt.Sprintf(`{"name":"pod-%d","uid":"uid-%d","resourceVersion":"%d","ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"rs","uid":"owner-%d","controller":true}]}`, i, i, i, i)
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: "", Version: "v1", Resource: "pods", Kind: "Pod",
+			Namespace: "default", Name: fmt.Sprintf("pod-%d", i), OwnerUID: ownerUID,
+			UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: fmt.Sprint(i),
+			Object: []byte(`{"metadata":` + metadata + `}`), Metadata: []byte(metadata), CreatedAt: time.Now(),
+		}).Error)
+	}
+
+	factory := &StorageFactory{db: db}
+	summary, err := factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{VerifyOnly: true})
+	require.NoError(t, err)
+	assert.Equal(t, storage.RebuildSummary{Scanned: 3, Mismatched: 1, Cursor: `{"default":3}`}, summary)
+
+	// resume from the cursor of the first batch
+	var cursors []string
+	summary, err = factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{
+		BatchSize: 1,
+		Progress:  func(summary storage.RebuildSummary) { cursors = append(cursors, summary.Cursor) },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Fixed)
+	assert.Equal(t, []string{`{"default":1}`, `{"default":2}`, `{"default":3}`, `{"default":3}`}, cursors)
+
+	var resource Resource
+	require.NoError(t, db.Where("name = ?", "pod-1").First(&resource).Error)
+	assert.Equal(t, types.UID("owner-1"), resource.OwnerUID)
+
+	summary, err = factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{Cursor: cursors[0]})
+	require.NoError(t, err)
+	assert.Equal(t, storage.RebuildSummary{Scanned: 2, Cursor: `{"default":3}`}, summary)
+
+	_, err = factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{Cursor: "invalid"})
+	assert.Error(t, err)
+}
+
+func TestRebuildSecondaryDataBackfillMetadata(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i, metadata := range []string{"", `{"name":"pod-1","uid":"uid-1","resourceVersion":"1"}`, `{"name":"drifted"}`} {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: "", Version: "v1", Resource: "pods", Kind: "Pod",
+			Namespace: "default", Name: fmt.Sprintf("pod-%d", i), UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: fmt.Sprint(i),
+			Object:   []byte(fmt.Sprintf(`{"metadata":{"name":"pod-%d","uid":"uid-%d","resourceVersion":"%d"}}`, i, i, i)),
+			Metadata: []byte(metadata), CreatedAt: time.Now(),
+		}).Error)
+	}
+
+	factory := &StorageFactory{db: db}
+	summary, err := factory.RebuildSecondaryData(context.Background(), storage.RebuildOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, storage.RebuildSummary{Scanned: 3, Mismatched: 2, Fixed: 2, Cursor: `{"default":3}`}, summary)
+
+	var metadatas ResourceMetadataList
+	require.NoError(t, metadatas.From(db.Model(&Resource{}).Where("metadata IS NOT NULL").Order("id")))
+	require.Len(t, metadatas, 3)
+	for i, metadata := range metadatas {
+		obj, err := metadata.ConvertToUnstructured()
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("pod-%d", i), obj.GetName())
+	}
+}
@@ -0,0 +1,137 @@
+package internalstorage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+const (
+	// redactedMarker replaces the redacted values of the secrets, and the values of the data are
+	// replaced by its base64, so the redacted secrets can still be decoded.
+	redactedMarker       = "<redacted>"
+	redactedBase64Marker = "PHJlZGFjdGVkPg=="
+)
+
+// secretsRedactedFields are the fields of the secrets redacted by the RedactSecrets, the keys of the data are kept,
+// and the last applied configuration is removed since it contains the data.
+var secretsRedactedFields = []RedactedFieldConfig{
+	{Path: "data", Marker: redactedBase64Marker},
+	{Path: "stringData", Marker: redactedMarker},
+	{Path: "metadata.annotations['kubectl.kubernetes.io/last-applied-configuration']"},
+}
+
+// RedactionConfig redacts the fields of the objects of the resource before they are stored,
+// so the redacted values are never written to the database.
+type RedactionConfig struct {
+	Group    string                `yaml:"group"`
+	Resource string                `yaml:"resource"`
+	Fields   []RedactedFieldConfig `yaml:"fields"`
+}
+
+type RedactedFieldConfig struct {
+	// Path is the path of the field, the keys are separated by `.`, and the key containing `.` is quoted by `['` and `']`,
+	// e.g. `metadata.annotations['kubectl.kubernetes.io/last-applied-configuration']`.
+	Path string `yaml:"path"`
+
+	// Marker replaces the value of the field, the values of the object field are replaced and its keys are kept,
+	// so the existing entries are still visible. The field is removed if the marker is empty.
+	Marker string `yaml:"marker"`
+}
+
+type redactedField struct {
+	path   []string
+	marker string
+}
+
+// newObjectRedactions returns the redacted fields of the resources, the secrets are redacted if redactSecrets is set.
+func newObjectRedactions(redactSecrets bool, configs []RedactionConfig) (map[schema.GroupResource][]redactedField, error) {
+	if redactSecrets {
+		configs = append([]RedactionConfig{{Resource: "secrets", Fields: secretsRedactedFields}}, configs...)
+	}
+
+	redactions := make(map[schema.GroupResource][]redactedField, len(configs))
+	for _, config := range configs {
+		if config.Resource == "" {
+			return nil, fmt.Errorf("redaction: resource is required")
+		}
+
+		gr := schema.GroupResource{Group: config.Group, Resource: config.Resource}
+		for _, field := range config.Fields {
+			path, err := querybuilder.ParseFieldPath(field.Path)
+			if err != nil {
+				return nil, fmt.Errorf("redaction %s: %w", gr, err)
+			}
+			redactions[gr] = append(redactions[gr], redactedField{path: path, marker: field.Marker})
+		}
+	}
+	return redactions, nil
+}
+
+// metadataRedacted returns whether the fields of the metadata are redacted,
+// then the metadata column is extracted from the redacted object.
+func metadataRedacted(fields []redactedField) bool {
+	for _, field := range fields {
+		if field.path[0] == "metadata" {
+			return true
+		}
+	}
+	return false
+}
+
+// redactObject redacts the fields of the encoded object, the object is returned as is if none of the fields exists.
+func redactObject(encoded []byte, fields []redactedField) ([]byte, error) {
+	if len(fields) == 0 {
+		return encoded, nil
+	}
+
+	var object map[string]interface{}
+	if err := utiljson.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+
+	var redacted bool
+	for _, field := range fields {
+		if redactField(object, field) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return encoded, nil
+	}
+	return json.Marshal(object)
+}
+
+func redactField(object map[string]interface{}, field redactedField) bool {
+	parent := object
+	for _, key := range field.path[:len(field.path)-1] {
+		child, ok := parent[key].(map[string]interface{})
+		if !ok {
+			return false
+		}
+		parent = child
+	}
+
+	key := field.path[len(field.path)-1]
+	value, ok := parent[key]
+	if !ok {
+		return false
+	}
+	if field.marker == "" {
+		delete(parent, key)
+		return true
+	}
+
+	if values, ok := value.(map[string]interface{}); ok {
+		for k := range values {
+			values[k] = field.marker
+		}
+		return true
+	}
+	parent[key] = field.marker
+	return true
+}
@@ -0,0 +1,105 @@
+package internalstorage
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestRedactObject(t *testing.T) {
+	redactions, err := newObjectRedactions(false, []RedactionConfig{{
+		Resource: "configmaps",
+		Fields: []RedactedFieldConfig{
+			{Path: "data", Marker: "***"},
+			{Path: "binaryData"},
+			{Path: "metadata.labels.owner", Marker: "***"},
+			{Path: "spec.missing"},
+		},
+	}})
+	require.NoError(t, err)
+	fields := redactions[schema.GroupResource{Resource: "configmaps"}]
+	require.Len(t, fields, 4)
+	assert.True(t, metadataRedacted(fields))
+
+	redacted, err := redactObject([]byte(`{"data":{"a":"1","b":"2"},"binaryData":{"c":"Mw=="},"metadata":{"labels":{"owner":"x","app":"y"},"generation":9007199254740993}}`), fields)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data":{"a":"***","b":"***"},"metadata":{"labels":{"owner":"***","app":"y"},"generation":9007199254740993}}`, string(redacted))
+
+	// the object is returned as is without the redacted fields
+	object := []byte(`{"kind": "ConfigMap"}`)
+	redacted, err = redactObject(object, fields)
+	require.NoError(t, err)
+	assert.Equal(t, object, redacted)
+
+	_, err = newObjectRedactions(false, []RedactionConfig{{Fields: []RedactedFieldConfig{{Path: "data"}}}})
+	assert.Error(t, err)
+}
+
+func TestResourceStorage_RedactSecrets(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	redactions, err := newObjectRedactions(true, nil)
+	require.NoError(t, err)
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("secrets"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "secrets"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.redactedFields = redactions[schema.GroupResource{Resource: "secrets"}]
+
+	newSecret := func(password, resourceVersion string) *corev1.Secret {
+		return &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default", Name: "a", UID: "a", ResourceVersion: resourceVersion,
+				Annotations: map[string]string{
+					"kubectl.kubernetes.io/last-applied-configuration": `{"stringData":{"password":"` + password + `"}}`,
+					"app": "a",
+				},
+			},
+			Data:       map[string][]byte{"password": []byte(password)},
+			StringData: map[string]string{"token": password},
+		}
+	}
+	assertRedacted := func(password string) {
+		var resource Resource
+		require.NoError(t, db.Where("name = ?", "a").First(&resource).Error)
+		for _, column := range []string{string(resource.Object), string(resource.Metadata)} {
+			assert.NotContains(t, column, password)
+			assert.NotContains(t, column, base64.StdEncoding.EncodeToString([]byte(password)))
+		}
+
+		secret := &corev1.Secret{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "a", secret))
+		assert.Equal(t, map[string][]byte{"password": []byte(redactedMarker)}, secret.Data)
+		assert.Equal(t, map[string]string{"token": redactedMarker}, secret.StringData)
+		assert.Equal(t, map[string]string{"app": "a"}, secret.Annotations)
+
+		list := &corev1.SecretList{}
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{}))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, secret.Data, list.Items[0].Data)
+
+		metadataList := &metav1.PartialObjectMetadataList{}
+		require.NoError(t, rs.List(context.Background(), metadataList, &internal.ListOptions{OnlyMetadata: true}))
+		require.Len(t, metadataList.Items, 1)
+		assert.Equal(t, map[string]string{"app": "a"}, metadataList.Items[0].Annotations)
+	}
+
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newSecret("created-password", "1")))
+	assertRedacted("created-password")
+
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", newSecret("updated-password", "2")))
+	assertRedacted("updated-password")
+}
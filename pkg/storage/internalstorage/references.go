@@ -0,0 +1,380 @@
+package internalstorage
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// URLQueryReferencesTo selects the resources referencing the target, e.g. `referencesTo=v1/secrets/default/foo`
+// selects the resources referencing the secret foo in the default namespace. The target is `<group>/<version>/<resource>/<namespace>/<name>`,
+// the group is omitted for the core group and the namespace is omitted for the cluster-scoped targets.
+// The references of the multiple values are ANDed.
+const URLQueryReferencesTo = "referencesTo"
+
+// ReferencesTable is the table of the references of the resources recorded by the reference rules.
+const ReferencesTable = "resource_references"
+
+func init() {
+	registerMigration(migration{
+		version:  14,
+		name:     "create the resource references table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			return createTableIfNotExists(db, &ResourceReference{})
+		},
+	})
+}
+
+// ResourceReference is the target referenced by the resource, e.g. the secret mounted by the pod. The references of the resources
+// are maintained with the rows of the resources in the same transactions, so the resources referencing the target can be listed.
+// The version of the target isn't recorded, since the references are the names of the targets.
+type ResourceReference struct {
+	ResourceID uint `gorm:"primaryKey;autoIncrement:false"`
+
+	Group     string `gorm:"column:target_group;primaryKey;size:253;index:idx_resource_references_target"`
+	Resource  string `gorm:"column:target_resource;primaryKey;size:63;index:idx_resource_references_target"`
+	Namespace string `gorm:"column:target_namespace;primaryKey;size:63;index:idx_resource_references_target"`
+	Name      string `gorm:"column:target_name;primaryKey;size:253;index:idx_resource_references_target"`
+}
+
+func (ResourceReference) TableName() string {
+	return ReferencesTable
+}
+
+// ReferencesConfig records the references of the written objects into the references table by the reference rules,
+// the default rules cover the well-known references of the built-in resources, e.g. the secrets mounted by the pods.
+type ReferencesConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Rules are the reference rules of the custom resources, they are added to the default rules.
+	Rules []ReferenceRuleConfig `yaml:"rules"`
+}
+
+// ReferenceRuleConfig records the names at the paths of the objects of the resource as the references to the target.
+type ReferenceRuleConfig struct {
+	Group    string                `yaml:"group"`
+	Resource string                `yaml:"resource"`
+	Target   ReferenceTargetConfig `yaml:"target"`
+
+	// Paths are the JSONPath of the names of the targets, only the child keys and the `[*]` of all elements are supported,
+	// e.g. `{.spec.volumes[*].secret.secretName}`. The braces and the leading `$` are optional.
+	Paths []string `yaml:"paths"`
+}
+
+type ReferenceTargetConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+
+	// ClusterScoped is set if the target is cluster-scoped, otherwise the target is in the namespace of the referencing object.
+	ClusterScoped bool `yaml:"clusterScoped"`
+}
+
+// podSpecReferenceRules are the references of the pod spec at the prefix, e.g. the pod template of the workloads.
+func podSpecReferenceRules(group, resource, prefix string) []ReferenceRuleConfig {
+	var secrets, configmaps []string
+	for _, containers := range []string{"containers", "initContainers", "ephemeralContainers"} {
+		secrets = append(secrets,
+			prefix+"."+containers+"[*].env[*].valueFrom.secretKeyRef.name",
+			prefix+"."+containers+"[*].envFrom[*].secretRef.name",
+		)
+		configmaps = append(configmaps,
+			prefix+"."+containers+"[*].env[*].valueFrom.configMapKeyRef.name",
+			prefix+"."+containers+"[*].envFrom[*].configMapRef.name",
+		)
+	}
+	secrets = append(secrets,
+		prefix+".volumes[*].secret.secretName",
+		prefix+".volumes[*].projected.sources[*].secret.name",
+		prefix+".imagePullSecrets[*].name",
+	)
+	configmaps = append(configmaps,
+		prefix+".volumes[*].configMap.name",
+		prefix+".volumes[*].projected.sources[*].configMap.name",
+	)
+
+	return []ReferenceRuleConfig{
+		{Group: group, Resource: resource, Target: ReferenceTargetConfig{Resource: "secrets"}, Paths: secrets},
+		{Group: group, Resource: resource, Target: ReferenceTargetConfig{Resource: "configmaps"}, Paths: configmaps},
+		{Group: group, Resource: resource, Target: ReferenceTargetConfig{Resource: "serviceaccounts"}, Paths: []string{prefix + ".serviceAccountName"}},
+		{Group: group, Resource: resource, Target: ReferenceTargetConfig{Resource: "persistentvolumeclaims"}, Paths: []string{prefix + ".volumes[*].persistentVolumeClaim.claimName"}},
+		{Group: group, Resource: resource, Target: ReferenceTargetConfig{Group: "scheduling.k8s.io", Resource: "priorityclasses", ClusterScoped: true}, Paths: []string{prefix + ".priorityClassName"}},
+	}
+}
+
+// defaultReferenceRules are the well-known references of the built-in resources.
+func defaultReferenceRules() []ReferenceRuleConfig {
+	rules := podSpecReferenceRules("", "pods", "spec")
+	rules = append(rules, ReferenceRuleConfig{Resource: "pods", Target: ReferenceTargetConfig{Resource: "nodes", ClusterScoped: true}, Paths: []string{"spec.nodeName"}})
+	for _, resource := range []string{"deployments", "statefulsets", "daemonsets", "replicasets"} {
+		rules = append(rules, podSpecReferenceRules("apps", resource, "spec.template.spec")...)
+	}
+	rules = append(rules, podSpecReferenceRules("batch", "jobs", "spec.template.spec")...)
+	rules = append(rules, podSpecReferenceRules("batch", "cronjobs", "spec.jobTemplate.spec.template.spec")...)
+
+	return append(rules,
+		ReferenceRuleConfig{Group: "networking.k8s.io", Resource: "ingresses", Target: ReferenceTargetConfig{Resource: "services"},
+			Paths: []string{"spec.defaultBackend.service.name", "spec.rules[*].http.paths[*].backend.service.name"}},
+		ReferenceRuleConfig{Group: "networking.k8s.io", Resource: "ingresses", Target: ReferenceTargetConfig{Resource: "secrets"},
+			Paths: []string{"spec.tls[*].secretName"}},
+		ReferenceRuleConfig{Group: "networking.k8s.io", Resource: "ingresses", Target: ReferenceTargetConfig{Group: "networking.k8s.io", Resource: "ingressclasses", ClusterScoped: true},
+			Paths: []string{"spec.ingressClassName"}},
+		ReferenceRuleConfig{Resource: "serviceaccounts", Target: ReferenceTargetConfig{Resource: "secrets"},
+			Paths: []string{"secrets[*].name", "imagePullSecrets[*].name"}},
+		ReferenceRuleConfig{Resource: "persistentvolumeclaims", Target: ReferenceTargetConfig{Resource: "persistentvolumes", ClusterScoped: true},
+			Paths: []string{"spec.volumeName"}},
+		ReferenceRuleConfig{Resource: "persistentvolumeclaims", Target: ReferenceTargetConfig{Group: "storage.k8s.io", Resource: "storageclasses", ClusterScoped: true},
+			Paths: []string{"spec.storageClassName"}},
+		ReferenceRuleConfig{Resource: "persistentvolumes", Target: ReferenceTargetConfig{Group: "storage.k8s.io", Resource: "storageclasses", ClusterScoped: true},
+			Paths: []string{"spec.storageClassName"}},
+	)
+}
+
+type referenceRule struct {
+	target        schema.GroupResource
+	clusterScoped bool
+	paths         [][]string
+}
+
+// objectReferences are the reference rules of the resources.
+type objectReferences struct {
+	rules map[schema.GroupResource][]referenceRule
+}
+
+// newObjectReferences returns the reference rules of the config, the references are disabled if it returns nil.
+func newObjectReferences(config ReferencesConfig) (*objectReferences, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	references := &objectReferences{rules: make(map[schema.GroupResource][]referenceRule)}
+	for _, rule := range append(defaultReferenceRules(), config.Rules...) {
+		if rule.Resource == "" || rule.Target.Resource == "" {
+			return nil, fmt.Errorf("references: the resource and the target resource are required")
+		}
+
+		gr := schema.GroupResource{Group: rule.Group, Resource: rule.Resource}
+		parsed := referenceRule{
+			target:        schema.GroupResource{Group: rule.Target.Group, Resource: rule.Target.Resource},
+			clusterScoped: rule.Target.ClusterScoped,
+		}
+		for _, path := range rule.Paths {
+			keys, err := parseReferencePath(path)
+			if err != nil {
+				return nil, fmt.Errorf("references %s: %w", gr, err)
+			}
+			parsed.paths = append(parsed.paths, keys)
+		}
+		references.rules[gr] = append(references.rules[gr], parsed)
+	}
+	return references, nil
+}
+
+// resourceRules returns the reference rules of the resource, it is safe to call on the nil references.
+func (r *objectReferences) resourceRules(gr schema.GroupResource) []referenceRule {
+	if r == nil {
+		return nil
+	}
+	return r.rules[gr]
+}
+
+// parseReferencePath parses the JSONPath like `{.spec.volumes[*].secret.secretName}` into the keys,
+// the `[*]` is parsed into the `*` key which matches all elements of the list.
+func parseReferencePath(path string) ([]string, error) {
+	trimmed := strings.TrimSpace(path)
+	if strings.HasPrefix(trimmed, "{") {
+		if !strings.HasSuffix(trimmed, "}") {
+			return nil, fmt.Errorf("invalid path %q: the brace isn't closed", path)
+		}
+		trimmed = trimmed[1 : len(trimmed)-1]
+	}
+	trimmed = strings.TrimPrefix(strings.TrimPrefix(trimmed, "$"), ".")
+	return querybuilder.ParseFieldPath(strings.ReplaceAll(trimmed, "[*]", ".*"))
+}
+
+// extractReferences extracts the references of the encoded object in the namespace by the rules,
+// the references are deduplicated and sorted.
+func extractReferences(encoded []byte, namespace string, rules []referenceRule) ([]ResourceReference, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var object map[string]interface{}
+	if err := utiljson.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[ResourceReference]bool)
+	var references []ResourceReference
+	for _, rule := range rules {
+		targetNamespace := namespace
+		if rule.clusterScoped {
+			targetNamespace = ""
+		}
+		for _, path := range rule.paths {
+			for _, name := range referencedNames(object, path) {
+				reference := ResourceReference{Group: rule.target.Group, Resource: rule.target.Resource, Namespace: targetNamespace, Name: name}
+				if !seen[reference] {
+					seen[reference] = true
+					references = append(references, reference)
+				}
+			}
+		}
+	}
+	sort.Slice(references, func(i, j int) bool {
+		a, b := references[i], references[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Resource != b.Resource {
+			return a.Resource < b.Resource
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return references, nil
+}
+
+// referencedNames returns the non-empty strings at the path of the value.
+func referencedNames(value interface{}, path []string) []string {
+	if len(path) == 0 {
+		if name, ok := value.(string); ok && name != "" {
+			return []string{name}
+		}
+		return nil
+	}
+
+	var names []string
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if path[0] != "*" {
+			return referencedNames(v[path[0]], path[1:])
+		}
+		for _, child := range v {
+			names = append(names, referencedNames(child, path[1:])...)
+		}
+	case []interface{}:
+		if path[0] != "*" {
+			return nil
+		}
+		for _, child := range v {
+			names = append(names, referencedNames(child, path[1:])...)
+		}
+	}
+	return names
+}
+
+// checkReferences checks the references table exists if the references are recorded.
+func (s *StorageFactory) checkReferences(name string, db *gorm.DB) error {
+	if s.references == nil || db.Migrator().HasTable(&ResourceReference{}) {
+		return nil
+	}
+	return fmt.Errorf("database %s: the %s table doesn't exist, the migration 14 is required by the references", name, ReferencesTable)
+}
+
+// insertReferences inserts the references of the resource.
+func insertReferences(tx *gorm.DB, id uint, references []ResourceReference) error {
+	if len(references) == 0 {
+		return nil
+	}
+	rows := make([]ResourceReference, len(references))
+	for i, reference := range references {
+		reference.ResourceID = id
+		rows[i] = reference
+	}
+	return tx.Create(&rows).Error
+}
+
+// replaceReferences replaces the references of the resource with the references of the object.
+func replaceReferences(tx *gorm.DB, id uint, references []ResourceReference) error {
+	if err := tx.Where("resource_id = ?", id).Delete(&ResourceReference{}).Error; err != nil {
+		return err
+	}
+	return insertReferences(tx, id, references)
+}
+
+// deleteResourceReferences deletes the references of the resources matched by the conditions, e.g. the resources of the cleaned cluster.
+func deleteResourceReferences(db *gorm.DB, conds map[string]interface{}) error {
+	ids := db.Model(&Resource{}).Select("id").Where(conds)
+	return db.Where("resource_id IN (?)", ids).Delete(&ResourceReference{}).Error
+}
+
+var referenceVersionRegexp = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+// parseReferencesTo parses the targets of the referencesTo from the url query.
+func parseReferencesTo(urlQuery url.Values) ([]ResourceReference, error) {
+	var (
+		targets     []ResourceReference
+		fieldErrors field.ErrorList
+	)
+	for i, raw := range urlQuery[URLQueryReferencesTo] {
+		target, err := parseReferenceTarget(raw)
+		if err != nil {
+			fieldErrors = append(fieldErrors, field.Invalid(field.NewPath(URLQueryReferencesTo).Index(i), raw, err.Error()))
+			continue
+		}
+		targets = append(targets, target)
+	}
+	if len(fieldErrors) != 0 {
+		return nil, apierrors.NewInvalid(schema.GroupKind{Group: internal.GroupName, Kind: "ListOptions"}, "urlQuery", fieldErrors)
+	}
+	return targets, nil
+}
+
+// parseReferenceTarget parses the target like `v1/secrets/default/foo` or `storage.k8s.io/v1/storageclasses/standard`,
+// the first segment is the group if the second one is a version.
+func parseReferenceTarget(raw string) (ResourceReference, error) {
+	segments := strings.Split(raw, "/")
+	var group string
+	if len(segments) > 1 && referenceVersionRegexp.MatchString(segments[1]) {
+		group, segments = segments[0], segments[1:]
+	}
+	if !referenceVersionRegexp.MatchString(segments[0]) {
+		return ResourceReference{}, fmt.Errorf("the version is required, the target is <group>/<version>/<resource>/<namespace>/<name>")
+	}
+
+	target := ResourceReference{Group: group}
+	switch len(segments) {
+	case 3:
+		target.Resource, target.Name = segments[1], segments[2]
+	case 4:
+		target.Resource, target.Namespace, target.Name = segments[1], segments[2], segments[3]
+	default:
+		return ResourceReference{}, fmt.Errorf("the target is <group>/<version>/<resource>/<namespace>/<name>")
+	}
+	if target.Resource == "" || target.Name == "" {
+		return ResourceReference{}, fmt.Errorf("the resource and the name are required")
+	}
+	return target, nil
+}
+
+// queryReferencesTo filters the resources referencing the targets of the url query by the EXISTS subqueries of the references table.
+func (s *ResourceStorage) queryReferencesTo(query *gorm.DB, urlQuery url.Values) (*gorm.DB, error) {
+	targets, err := parseReferencesTo(urlQuery)
+	if err != nil || len(targets) == 0 {
+		return query, err
+	}
+	if !s.referencesEnabled {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("the %s query requires the references of the storage", URLQueryReferencesTo))
+	}
+
+	for _, target := range targets {
+		query = query.Where("EXISTS (SELECT 1 FROM "+ReferencesTable+" WHERE "+ReferencesTable+".resource_id = resources.id AND "+
+			"target_group = ? AND target_resource = ? AND target_namespace = ? AND target_name = ?)",
+			target.Group, target.Resource, target.Namespace, target.Name)
+	}
+	return query, nil
+}
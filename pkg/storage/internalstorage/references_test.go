@@ -0,0 +1,158 @@
+package internalstorage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newReferencesTestStorage(t *testing.T, config ReferencesConfig) (*gorm.DB, *StorageFactory, *ResourceStorage) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+	require.NoError(t, db.AutoMigrate(&ResourceReference{}))
+
+	references, err := newObjectReferences(config)
+	require.NoError(t, err)
+	factory := &StorageFactory{db: db, references: references}
+
+	gr := schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}
+	resourceConfig, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gr, true)
+	require.NoError(t, err)
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	rs.codec = resourceConfig.Codec
+	rs.referencesEnabled = references != nil
+	rs.referenceRules = references.resourceRules(gr)
+	return db, factory, rs
+}
+
+func newReferencingDeployment(name, secret string, configmaps ...string) *appsv1.Deployment {
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+	}
+	podSpec := &deploy.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{Name: "secret", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}}})
+	container := corev1.Container{Name: "app"}
+	for _, configmap := range configmaps {
+		container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: configmap}}})
+	}
+	podSpec.Containers = append(podSpec.Containers, container)
+	return deploy
+}
+
+func listReferencingNames(t *testing.T, rs *ResourceStorage, targets ...string) []string {
+	opts := &internal.ListOptions{}
+	opts.URLQuery = url.Values{URLQueryReferencesTo: targets}
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, opts))
+
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	return names
+}
+
+func TestResourceStorage_References(t *testing.T) {
+	db, factory, rs := newReferencesTestStorage(t, ReferencesConfig{Enabled: true})
+	ctx := context.Background()
+
+	require.NoError(t, rs.Create(ctx, "cluster-1", newReferencingDeployment("web", "tls", "web-config", "shared")))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newReferencingDeployment("api", "tls", "shared")))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newReferencingDeployment("db", "db-password")))
+
+	assert.ElementsMatch(t, []string{"web", "api"}, listReferencingNames(t, rs, "v1/secrets/default/tls"))
+	assert.ElementsMatch(t, []string{"web"}, listReferencingNames(t, rs, "v1/secrets/default/tls", "v1/configmaps/default/web-config"))
+	assert.Empty(t, listReferencingNames(t, rs, "v1/secrets/other/tls"))
+
+	// the references are replaced by the update
+	updated := newReferencingDeployment("web", "tls-v2")
+	updated.ResourceVersion = "2"
+	require.NoError(t, rs.Update(ctx, "cluster-1", updated))
+	assert.ElementsMatch(t, []string{"api"}, listReferencingNames(t, rs, "v1/secrets/default/tls"))
+	assert.ElementsMatch(t, []string{"web"}, listReferencingNames(t, rs, "v1/secrets/default/tls-v2"))
+
+	deletedObj, err := rs.ConvertDeletedObject(updated)
+	require.NoError(t, err)
+	require.NoError(t, rs.Delete(ctx, "cluster-1", deletedObj))
+	var count int64
+	require.NoError(t, db.Model(&ResourceReference{}).Count(&count).Error)
+	// the secret and the configmap of the api, and the secret of the db
+	assert.Equal(t, int64(3), count)
+
+	require.NoError(t, factory.CleanCluster(ctx, "cluster-1"))
+	require.NoError(t, db.Model(&ResourceReference{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestResourceStorage_ReferencesDisabled(t *testing.T) {
+	_, _, rs := newReferencesTestStorage(t, ReferencesConfig{})
+	opts := &internal.ListOptions{}
+	opts.URLQuery = url.Values{URLQueryReferencesTo: {"v1/secrets/default/tls"}}
+	err := rs.List(context.Background(), &appsv1.DeploymentList{}, opts)
+	assert.True(t, apierrors.IsBadRequest(err))
+}
+
+func TestExtractReferencesByCustomRules(t *testing.T) {
+	references, err := newObjectReferences(ReferencesConfig{
+		Enabled: true,
+		Rules: []ReferenceRuleConfig{{
+			Group:    "example.io",
+			Resource: "databases",
+			Target:   ReferenceTargetConfig{Resource: "secrets"},
+			Paths:    []string{"{.spec.credentials.secretName}", "$.spec.replicas[*].tls['secret-name']"},
+		}},
+	})
+	require.NoError(t, err)
+
+	object := []byte(`{"spec":{"credentials":{"secretName":"admin"},"replicas":[{"tls":{"secret-name":"tls-1"}},{"tls":{"secret-name":"admin"}},{}]}}`)
+	extracted, err := extractReferences(object, "default", references.resourceRules(schema.GroupResource{Group: "example.io", Resource: "databases"}))
+	require.NoError(t, err)
+	assert.Equal(t, []ResourceReference{
+		{Resource: "secrets", Namespace: "default", Name: "admin"},
+		{Resource: "secrets", Namespace: "default", Name: "tls-1"},
+	}, extracted)
+
+	_, err = newObjectReferences(ReferencesConfig{Enabled: true, Rules: []ReferenceRuleConfig{{Resource: "databases", Target: ReferenceTargetConfig{Resource: "secrets"}, Paths: []string{"{.spec"}}}})
+	assert.Error(t, err)
+}
+
+func TestParseReferencesTo(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected ResourceReference
+		invalid  bool
+	}{
+		{raw: "v1/secrets/default/foo", expected: ResourceReference{Resource: "secrets", Namespace: "default", Name: "foo"}},
+		{raw: "v1/nodes/node-1", expected: ResourceReference{Resource: "nodes", Name: "node-1"}},
+		{raw: "apps/v1/deployments/default/web", expected: ResourceReference{Group: "apps", Resource: "deployments", Namespace: "default", Name: "web"}},
+		{raw: "storage.k8s.io/v1/storageclasses/standard", expected: ResourceReference{Group: "storage.k8s.io", Resource: "storageclasses", Name: "standard"}},
+		{raw: "secrets/default/foo", invalid: true},
+		{raw: "v1/secrets", invalid: true},
+		{raw: "v1/secrets/default/foo/bar", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			targets, err := parseReferencesTo(url.Values{URLQueryReferencesTo: {test.raw}})
+			if test.invalid {
+				assert.True(t, apierrors.IsInvalid(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []ResourceReference{test.expected}, targets)
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/jinzhu/configor"
+	"golang.org/x/sync/singleflight"
 	"gopkg.in/natefinch/lumberjack.v2"
 	gmysql "gorm.io/driver/mysql"
 	gpostgres "gorm.io/driver/postgres"
@@ -38,18 +39,259 @@ func NewStorageFactory(configPath string) (storage.StorageFactory, error) {
 	if err := configor.Load(cfg, configPath); err != nil {
 		return nil, err
 	}
+	factory, err := NewStorageFactoryWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+// NewStorageFactoryWithConfig returns the storage factory of the config, e.g. the config of the DSN given by the command line.
+func NewStorageFactoryWithConfig(cfg *Config) (*StorageFactory, error) {
+	logger, err := newLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDB(DefaultDatabaseName, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	resourceVersionMaxLength, err := cfg.resourceVersionMaxLength()
+	if err != nil {
+		return nil, err
+	}
+	decodeWorkers, err := cfg.decodeWorkers()
+	if err != nil {
+		return nil, err
+	}
+	connectionBudget, err := cfg.connectionBudget()
+	if err != nil {
+		return nil, err
+	}
+	indexHints, err := newIndexHints(cfg.IndexHints)
+	if err != nil {
+		return nil, err
+	}
+	labelSelectorMode, err := cfg.labelSelectorMode()
+	if err != nil {
+		return nil, err
+	}
+	if err := indexHints.validate(DefaultDatabaseName, db); err != nil {
+		return nil, err
+	}
+
+	stats, err := registerMetrics(DefaultDatabaseName, db, cfg.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	if err := registerAttribution(db, cfg.Attribution); err != nil {
+		return nil, err
+	}
+	encryption, err := newObjectEncryption(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
+	redactions, err := newObjectRedactions(cfg.RedactSecrets, cfg.Redactions)
+	if err != nil {
+		return nil, err
+	}
+	references, err := newObjectReferences(cfg.References)
+	if err != nil {
+		return nil, err
+	}
+	clusterAliases, err := newClusterAliases(cfg.ClusterAliases)
+	if err != nil {
+		return nil, err
+	}
+	shadowAnnotations, err := newShadowAnnotations(cfg.ShadowAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	objectSizeLimit, err := newObjectSizeLimit(cfg.ObjectSizeLimit)
+	if err != nil {
+		return nil, err
+	}
+	writeBehind, err := newWriteBehindOptions(cfg.WriteBehind)
+	if err != nil {
+		return nil, err
+	}
+	storagePolicies, err := newStoragePolicies(cfg.StoragePolicies)
+	if err != nil {
+		return nil, err
+	}
+	splitObjects, err := newSplitObjects(cfg.SplitObjects)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := newAuditSink(db, cfg.Audit)
+	if err != nil {
+		return nil, err
+	}
+	history, err := newHistoryRecorder(cfg.History)
+	if err != nil {
+		return nil, err
+	}
+	notifications, err := newNotificationSink(cfg.Notifications)
+	if err != nil {
+		return nil, err
+	}
+	eventStream, err := newEventStream(cfg.EventStream)
+	if err != nil {
+		return nil, err
+	}
+	hub := newWatchHub(cfg.WatchHub)
+	changeFeed, err := newChangeFeed(cfg, hub, encryption)
+	if err != nil {
+		return nil, err
+	}
+	listCache, err := newListCache(cfg.ListCache)
+	if err != nil {
+		return nil, err
+	}
+	legacyVersions, err := newLegacyVersions(cfg.LegacyVersions)
+	if err != nil {
+		return nil, err
+	}
+	middlewareNames := cfg.Middlewares
+	if middlewareNames == nil {
+		middlewareNames = defaultMiddlewares
+	}
+	middlewares, err := newMiddlewareChain(middlewareNames)
+	if err != nil {
+		return nil, err
+	}
+
+	factory := &StorageFactory{
+		db:            db,
+		timeouts:      cfg.Timeout,
+		queryLimit:    cfg.QueryLimit,
+		getCache:      newGetCache(cfg.GetCache),
+		notFoundCache: newNotFoundCache(cfg.NotFoundCache),
+		listCache:     listCache,
+		hub:           hub,
 
+		resourceVersionMaxLength: resourceVersionMaxLength,
+		verifyChecksum:           cfg.VerifyChecksum,
+		listDebugDisabled:        cfg.DisableListDebug,
+		decodeWorkers:            decodeWorkers,
+		lightUpdates:             cfg.LightUpdates,
+		preparedStatements:       cfg.PreparedStatements,
+		indexHints:               indexHints,
+		labelSelectorMode:        labelSelectorMode,
+		encryption:               encryption,
+		redactions:               redactions,
+		references:               references,
+		ownerResolver:            newOwnerResolver(cfg.OwnerResolution),
+		clusterAliases:           clusterAliases,
+		shadowAnnotations:        shadowAnnotations,
+		objectSizeLimit:          objectSizeLimit,
+		storagePolicies:          storagePolicies,
+		writeBehind:              writeBehind,
+		leases:                   newClusterLeases(db, cfg.Fencing),
+		splitObjects:             splitObjects,
+		audit:                    audit,
+		history:                  history,
+		notifications:            notifications,
+		eventStream:              eventStream,
+		legacyVersions:           legacyVersions,
+		reencoder:                newLegacyReencoder(cfg.Reencode),
+		middlewares:              middlewares,
+	}
+	if stats != nil {
+		factory.stats = append(factory.stats, stats)
+	}
+	factory.checkChecksumColumn(DefaultDatabaseName, db)
+	factory.checkContentHashColumn(DefaultDatabaseName, db)
+	factory.checkKeyHashColumn(DefaultDatabaseName, db)
+	if err := factory.checkLabelSelector(DefaultDatabaseName, db); err != nil {
+		return nil, err
+	}
+	if err := factory.checkReferences(DefaultDatabaseName, db); err != nil {
+		return nil, err
+	}
+	factory.checkSplitColumns(DefaultDatabaseName, db)
+	factory.addConnectionBudget(DefaultDatabaseName, db, connectionBudget)
+	if !cfg.DisableGetSingleflight {
+		factory.getFlight = &singleflight.Group{}
+	}
+	if len(cfg.Databases) == 0 && len(cfg.Routes) == 0 {
+		history.start(factory.databases())
+		factory.ownerResolver.start(factory.databases())
+		factory.reencoder.start()
+		if err := eventStream.start(factory.databases(), factory.splitColumnsMissing); err != nil {
+			return nil, err
+		}
+		if err := changeFeed.start(db, factory.splitColumnsMissing[db]); err != nil {
+			return nil, err
+		}
+		return factory, nil
+	}
+
+	databases := make(map[string]*gorm.DB, len(cfg.Databases))
+	for name, dbConfig := range cfg.Databases {
+		if dbConfig == nil {
+			return nil, fmt.Errorf("database %s: config is empty", name)
+		}
+
+		target, err := openDB(name, dbConfig, logger)
+		if err != nil {
+			return nil, err
+		}
+		stats, err := registerMetrics(name, target, cfg.Metrics)
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			factory.stats = append(factory.stats, stats)
+		}
+		if err := registerAttribution(target, cfg.Attribution); err != nil {
+			return nil, err
+		}
+		factory.checkChecksumColumn(name, target)
+		factory.checkContentHashColumn(name, target)
+		factory.checkKeyHashColumn(name, target)
+		if err := factory.checkLabelSelector(name, target); err != nil {
+			return nil, err
+		}
+		if err := factory.checkReferences(name, target); err != nil {
+			return nil, err
+		}
+		factory.checkSplitColumns(name, target)
+		factory.addConnectionBudget(name, target, connectionBudget)
+		if err := indexHints.validate(name, target); err != nil {
+			return nil, err
+		}
+		databases[name] = target
+	}
+
+	router, err := newDatabaseRouter(db, databases, cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+	factory.router = router
+	history.start(factory.databases())
+	factory.ownerResolver.start(factory.databases())
+	factory.reencoder.start()
+	if err := eventStream.start(factory.databases(), factory.splitColumnsMissing); err != nil {
+		return nil, err
+	}
+	return factory, nil
+}
+
+// openDB opens the database and migrates the tables.
+func openDB(name string, cfg *Config, logger logger.Interface) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 	switch cfg.Type {
 	case "mysql":
 		mysqlConfig, err := cfg.genMySQLConfig()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("database %s: %w", name, err)
 		}
 
 		connector, err := mysql.NewConnector(mysqlConfig)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("database %s: %w", name, err)
 		}
 
 		cfg.addMysqlErrorNumbers()
@@ -57,7 +299,7 @@ func NewStorageFactory(configPath string) (storage.StorageFactory, error) {
 	case "postgres":
 		pgconfig, err := cfg.genPostgresConfig()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("database %s: %w", name, err)
 		}
 
 		cfg.addPostgresErrorCodes()
@@ -65,16 +307,11 @@ func NewStorageFactory(configPath string) (storage.StorageFactory, error) {
 	case "sqlite", "sqlite3":
 		dsn, err := cfg.genSQLiteDSN()
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("database %s: %w", name, err)
 		}
 		dialector = gsqlite.Open(dsn)
 	default:
-		return nil, fmt.Errorf("not support storage type: %s", cfg.Type)
-	}
-
-	logger, err := newLogger(cfg)
-	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("database %s: not support storage type: %s", name, cfg.Type)
 	}
 
 	db, err := gorm.Open(dialector, &gorm.Config{SkipDefaultTransaction: true, Logger: logger})
@@ -93,11 +330,21 @@ func NewStorageFactory(configPath string) (storage.StorageFactory, error) {
 	sqlDB.SetMaxOpenConns(connPool.MaxOpenConns)
 	sqlDB.SetConnMaxLifetime(connPool.ConnMaxLifetime)
 
-	if err := db.AutoMigrate(&Resource{}); err != nil {
-		return nil, err
+	if err := migrateSchema(db, cfg.autoMigrateMode(), sortedMigrations()); err != nil {
+		return nil, fmt.Errorf("database %s: failed to migrate: %w", name, err)
 	}
+	return db, nil
+}
 
-	return &StorageFactory{db}, nil
+func registerMetrics(name string, db *gorm.DB, config *MetricsConfig) (*resourceStatsSource, error) {
+	if err := registerConnPoolMetrics(name, db, config); err != nil {
+		return nil, fmt.Errorf("database %s: failed to register the connection pool metrics: %w", name, err)
+	}
+	stats, err := registerResourceMetrics(name, db, config)
+	if err != nil {
+		return nil, fmt.Errorf("database %s: failed to register the resource metrics: %w", name, err)
+	}
+	return stats, nil
 }
 
 func newLogger(cfg *Config) (logger.Interface, error) {
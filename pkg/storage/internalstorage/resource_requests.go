@@ -0,0 +1,200 @@
+package internalstorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	gmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+var _ storage.ResourceRequestsAggregator = &ResourceStorage{}
+
+var podsGroupResource = schema.GroupResource{Resource: "pods"}
+
+// containerRequests is a row of the resource requests query, the containers with the same requests
+// in a namespace are counted in a row, and the requests are summed after they are parsed as quantities.
+type containerRequests struct {
+	Cluster    string
+	Namespace  string
+	CPU        sql.NullString `gorm:"column:cpu"`
+	Memory     sql.NullString `gorm:"column:memory"`
+	Containers int64
+}
+
+func (s *ResourceStorage) SumResourceRequests(ctx context.Context, opts *internal.ListOptions) ([]storage.ResourceRequestsSummary, error) {
+	if s.storageGroupResource != podsGroupResource {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("the resource requests are only summed for pods, not %s", s.storageGroupResource))
+	}
+	if s.encrypt {
+		return nil, apierrors.NewBadRequest("the resource requests of the encrypted pods can't be summed")
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	query, err := s.genResourceRequestsQuery(ctx, s.db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []containerRequests
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, InterpretDBError(s.storageGroupResource.String(), err)
+	}
+
+	summaries, err := sumContainerRequests(rows)
+	if err != nil {
+		return nil, err
+	}
+	setSpanAttributes(ctx, attribute.Int("count", len(summaries)))
+	return summaries, nil
+}
+
+// genResourceRequestsQuery generates the query of the resource requests of the containers of the pods matched
+// by the list options, the containers are expanded by the json table function of the dialect.
+func (s *ResourceStorage) genResourceRequestsQuery(ctx context.Context, db *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error) {
+	if err := s.queryLimit.validateListOptions(opts); err != nil {
+		return nil, err
+	}
+
+	opts = s.clusterAliases.resolveListOptions(opts).DeepCopy()
+	opts.OrderBy = nil
+	opts.WithRemainingCount = nil
+	opts.Limit = 0
+	opts.Continue = ""
+
+	version, err := s.listVersion(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	join, cpu, memory, err := containerRequestsExpressions(db, s.splitObject)
+	if err != nil {
+		return nil, err
+	}
+
+	query := querySplitObjects(s.queryWithLabelSelector(db.WithContext(ctx).Model(&Resource{}), false), s.splitObject).Where(map[string]interface{}{
+		"group":    s.storageGroupResource.Group,
+		"version":  version,
+		"resource": s.storageGroupResource.Resource,
+	})
+	_, _, query, err = applyListOptionsToResourceQuery(db, query, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Joins(join).
+		Select(fmt.Sprintf("cluster, namespace, %s AS cpu, %s AS memory, COUNT(*) AS containers", cpu, memory)).
+		Group(fmt.Sprintf("cluster, namespace, %s, %s", cpu, memory)), nil
+}
+
+// containerRequestsExpressions returns the join expanding the containers of the pods and the expressions
+// of the cpu and memory requests of the containers by the dialect, the containers are read from the spec column
+// if the objects are split, and from the object column if the object isn't split yet.
+func containerRequestsExpressions(db *gorm.DB, split bool) (join, cpu, memory string, err error) {
+	switch db.Dialector.Name() {
+	case "sqlite":
+		containers := "json_extract(object, '$.spec.containers')"
+		if split {
+			containers = fmt.Sprintf("COALESCE(json_extract(spec, '$.containers'), %s)", containers)
+		}
+		return fmt.Sprintf("CROSS JOIN json_each(%s) AS c", containers),
+			"json_extract(c.value, '$.resources.requests.cpu')",
+			"json_extract(c.value, '$.resources.requests.memory')", nil
+	case "mysql":
+		if !supportsJSONTable(db) {
+			return "", "", "", apierrors.NewBadRequest("the resource requests are only summed on MySQL 8.0 or later")
+		}
+		containers := "object -> '$.spec.containers'"
+		if split {
+			containers = fmt.Sprintf("COALESCE(spec -> '$.containers', %s)", containers)
+		}
+		return fmt.Sprintf("CROSS JOIN JSON_TABLE(%s, '$[*]' COLUMNS (cpu VARCHAR(64) PATH '$.resources.requests.cpu', memory VARCHAR(64) PATH '$.resources.requests.memory')) AS c", containers),
+			"c.cpu", "c.memory", nil
+	case "postgres":
+		containers := "object -> 'spec' -> 'containers'"
+		if split {
+			containers = fmt.Sprintf("COALESCE(spec -> 'containers', %s)", containers)
+		}
+		return fmt.Sprintf("CROSS JOIN jsonb_array_elements(%s) AS c(value)", containers),
+			"c.value -> 'resources' -> 'requests' ->> 'cpu'",
+			"c.value -> 'resources' -> 'requests' ->> 'memory'", nil
+	}
+	return "", "", "", apierrors.NewBadRequest(fmt.Sprintf("the resource requests aren't summed on %s", db.Dialector.Name()))
+}
+
+// sumContainerRequests sums the requests of the rows by the cluster and namespace,
+// the summaries are sorted by the cluster and namespace.
+func sumContainerRequests(rows []containerRequests) ([]storage.ResourceRequestsSummary, error) {
+	type key struct{ cluster, namespace string }
+
+	summaries := make(map[key]*storage.ResourceRequestsSummary)
+	for _, row := range rows {
+		k := key{row.Cluster, row.Namespace}
+		summary, ok := summaries[k]
+		if !ok {
+			summary = &storage.ResourceRequestsSummary{
+				Cluster:   row.Cluster,
+				Namespace: row.Namespace,
+				CPU:       *resource.NewMilliQuantity(0, resource.DecimalSI),
+				Memory:    *resource.NewQuantity(0, resource.BinarySI),
+			}
+			summaries[k] = summary
+		}
+
+		summary.Containers += row.Containers
+		if row.CPU.Valid {
+			cpu, err := resource.ParseQuantity(row.CPU.String)
+			if err != nil {
+				return nil, fmt.Errorf("parse the cpu request %q of the pods in %s/%s: %w", row.CPU.String, row.Cluster, row.Namespace, err)
+			}
+			summary.CPU.Add(*resource.NewMilliQuantity(cpu.MilliValue()*row.Containers, resource.DecimalSI))
+		}
+		if row.Memory.Valid {
+			memory, err := resource.ParseQuantity(row.Memory.String)
+			if err != nil {
+				return nil, fmt.Errorf("parse the memory request %q of the pods in %s/%s: %w", row.Memory.String, row.Cluster, row.Namespace, err)
+			}
+			summary.Memory.Add(*resource.NewQuantity(memory.Value()*row.Containers, resource.BinarySI))
+		}
+	}
+
+	result := make([]storage.ResourceRequestsSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Cluster != result[j].Cluster {
+			return result[i].Cluster < result[j].Cluster
+		}
+		return result[i].Namespace < result[j].Namespace
+	})
+	return result, nil
+}
+
+// supportsJSONTable returns whether the JSON_TABLE is supported by the MySQL, which is added in MySQL 8.0
+// and isn't supported by TiDB.
+func supportsJSONTable(db *gorm.DB) bool {
+	dialector, ok := db.Dialector.(*gmysql.Dialector)
+	if !ok || dialector.Config == nil {
+		return false
+	}
+	return !strings.HasPrefix(dialector.ServerVersion, "5.") && !isTiDB(db)
+}
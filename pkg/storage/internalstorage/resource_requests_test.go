@@ -0,0 +1,141 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestResourceStorage_genResourceRequestsQuery(t *testing.T) {
+	tests := []struct {
+		name        string
+		split       bool
+		listOptions *internal.ListOptions
+		expected    expected
+	}{
+		{
+			"namespaces",
+			false,
+			&internal.ListOptions{Namespaces: []string{"default"}},
+			expected{
+				`SELECT cluster, namespace, c.value -> 'resources' -> 'requests' ->> 'cpu' AS cpu, c.value -> 'resources' -> 'requests' ->> 'memory' AS memory, COUNT(*) AS containers FROM "resources" CROSS JOIN jsonb_array_elements(object -> 'spec' -> 'containers') AS c(value) WHERE "group" = '' AND "resource" = 'pods' AND "version" = 'v1' AND namespace = 'default' GROUP BY cluster, namespace, c.value -> 'resources' -> 'requests' ->> 'cpu', c.value -> 'resources' -> 'requests' ->> 'memory'`,
+				"SELECT cluster, namespace, c.cpu AS cpu, c.memory AS memory, COUNT(*) AS containers FROM `resources` CROSS JOIN JSON_TABLE(object -> '$.spec.containers', '$[*]' COLUMNS (cpu VARCHAR(64) PATH '$.resources.requests.cpu', memory VARCHAR(64) PATH '$.resources.requests.memory')) AS c WHERE `group` = '' AND `resource` = 'pods' AND `version` = 'v1' AND namespace = 'default' GROUP BY cluster, namespace, c.cpu, c.memory",
+				"",
+			},
+		},
+		{
+			"split objects",
+			true,
+			&internal.ListOptions{ClusterNames: []string{"cluster-1"}},
+			expected{
+				`SELECT cluster, namespace, c.value -> 'resources' -> 'requests' ->> 'cpu' AS cpu, c.value -> 'resources' -> 'requests' ->> 'memory' AS memory, COUNT(*) AS containers FROM "resources" CROSS JOIN jsonb_array_elements(COALESCE(spec -> 'containers', object -> 'spec' -> 'containers')) AS c(value) WHERE "group" = '' AND "resource" = 'pods' AND "version" = 'v1' AND cluster = 'cluster-1' GROUP BY cluster, namespace, c.value -> 'resources' -> 'requests' ->> 'cpu', c.value -> 'resources' -> 'requests' ->> 'memory'`,
+				"SELECT cluster, namespace, c.cpu AS cpu, c.memory AS memory, COUNT(*) AS containers FROM `resources` CROSS JOIN JSON_TABLE(COALESCE(spec -> '$.containers', object -> '$.spec.containers'), '$[*]' COLUMNS (cpu VARCHAR(64) PATH '$.resources.requests.cpu', memory VARCHAR(64) PATH '$.resources.requests.memory')) AS c WHERE `group` = '' AND `resource` = 'pods' AND `version` = 'v1' AND cluster = 'cluster-1' GROUP BY cluster, namespace, c.cpu, c.memory",
+				"",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		applyFn := func(db *gorm.DB, options *internal.ListOptions) (*gorm.DB, error) {
+			rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("pods"))
+			rs.splitObject = test.split
+			return rs.genResourceRequestsQuery(context.TODO(), db, options)
+		}
+
+		t.Run(test.name+" postgres", func(t *testing.T) {
+			assertSQL(t, postgresDB.Session(&gorm.Session{DryRun: true}), test.listOptions, applyFn, test.expected.postgres, nil)
+		})
+		t.Run(test.name+" mysql-8.0.27", func(t *testing.T) {
+			assertSQL(t, mysqlDBs["8.0.27"].Session(&gorm.Session{DryRun: true}), test.listOptions, applyFn, test.expected.mysql, nil)
+		})
+		t.Run(test.name+" mysql-5.7.22", func(t *testing.T) {
+			_, err := toSQL(mysqlDBs["5.7.22"].Session(&gorm.Session{DryRun: true}), test.listOptions, applyFn)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestResourceStorageSumResourceRequests(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("pods"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "pods"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	container := func(name, cpu, memory string) corev1.Container {
+		c := corev1.Container{Name: name}
+		if cpu != "" || memory != "" {
+			c.Resources.Requests = corev1.ResourceList{}
+		}
+		if cpu != "" {
+			c.Resources.Requests[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			c.Resources.Requests[corev1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return c
+	}
+	pods := []struct {
+		cluster    string
+		namespace  string
+		name       string
+		containers []corev1.Container
+	}{
+		{"cluster-1", "default", "pod-1", []corev1.Container{container("app", "500m", "128Mi"), container("sidecar", "100m", "64Mi")}},
+		{"cluster-1", "default", "pod-2", []corev1.Container{container("app", "500m", "128Mi")}},
+		// the containers without the requests are counted
+		{"cluster-1", "default", "pod-3", []corev1.Container{container("app", "", ""), container("sidecar", "1", "")}},
+		{"cluster-1", "kube-system", "pod-4", []corev1.Container{container("app", "", "")}},
+		{"cluster-2", "default", "pod-5", []corev1.Container{container("app", "2", "1Gi")}},
+	}
+	for _, p := range pods {
+		pod := &corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: p.namespace, Name: p.name, ResourceVersion: "1"},
+			Spec:       corev1.PodSpec{Containers: p.containers},
+		}
+		require.NoError(t, rs.Create(context.Background(), p.cluster, pod))
+	}
+
+	summaries, err := rs.SumResourceRequests(context.Background(), &internal.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []storage.ResourceRequestsSummary{
+		{Cluster: "cluster-1", Namespace: "default", Containers: 5, CPU: resource.MustParse("2100m"), Memory: resource.MustParse("320Mi")},
+		{Cluster: "cluster-1", Namespace: "kube-system", Containers: 1, CPU: resource.MustParse("0"), Memory: resource.MustParse("0")},
+		{Cluster: "cluster-2", Namespace: "default", Containers: 1, CPU: resource.MustParse("2"), Memory: resource.MustParse("1Gi")},
+	}, normalizeSummaries(summaries))
+
+	summaries, err = rs.SumResourceRequests(context.Background(), &internal.ListOptions{ClusterNames: []string{"cluster-2"}})
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "cluster-2", summaries[0].Cluster)
+
+	// only the requests of the pods are summed
+	rs = newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	_, err = rs.SumResourceRequests(context.Background(), &internal.ListOptions{})
+	assert.Error(t, err)
+}
+
+// normalizeSummaries drops the cached strings of the quantities, so the summaries can be compared with the parsed ones.
+func normalizeSummaries(summaries []storage.ResourceRequestsSummary) []storage.ResourceRequestsSummary {
+	for i := range summaries {
+		summaries[i].CPU = resource.MustParse(summaries[i].CPU.String())
+		summaries[i].Memory = resource.MustParse(summaries[i].Memory.String())
+	}
+	return summaries
+}
@@ -4,10 +4,17 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -20,31 +27,236 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	genericstorage "k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/warning"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/tracing"
+	"k8s.io/klog/v2"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 )
 
 type ResourceStorage struct {
-	db    *gorm.DB
-	codec runtime.Codec
+	db         *gorm.DB
+	codec      runtime.Codec
+	timeouts   TimeoutConfig
+	queryLimit QueryLimitConfig
+
+	// resourceVersionMaxLength is the max length of the stored resource versions, the longer ones are hashed.
+	resourceVersionMaxLength int
+
+	// checksumMissing is set if the checksum column doesn't exist, e.g. the migration 5 is pending in the safe mode,
+	// then the checksums are neither written nor verified.
+	checksumMissing bool
+
+	// verifyChecksum verifies the objects read by the Get and List with the checksums of their rows.
+	verifyChecksum bool
+
+	// listDebugDisabled ignores the debug url query of the lists.
+	listDebugDisabled bool
+
+	// decodeWorkers is the number of the workers decoding the objects of the List, 0 means GOMAXPROCS.
+	decodeWorkers int
+
+	// contentHashMissing is set if the content_hash column doesn't exist, e.g. the migration 7 is pending in the safe mode,
+	// then the content hashes aren't written and the updates are always full.
+	contentHashMissing bool
+
+	// lightUpdates only writes the resource version of the updated object if its content hash is unchanged.
+	lightUpdates bool
+
+	// keyHashMissing is set if the key_hash column doesn't exist, e.g. the migration 11 is pending in the safe mode.
+	keyHashMissing bool
+
+	// labelSelectorMode is how the label selectors are applied, the labels of the written objects are maintained
+	// in the labels table in the table mode.
+	labelSelectorMode LabelSelectorMode
+	jsonUnsupported   bool
+
+	// encryption decrypts the encrypted objects, and encrypts the written objects if encrypt is set.
+	encryption *objectEncryption
+	encrypt    bool
+
+	// splitColumnsMissing is set if the spec and status columns don't exist, e.g. the migration 8 is pending in the safe mode.
+	splitColumnsMissing bool
+
+	// splitObject stores the spec and status of the written objects in the spec and status columns.
+	splitObject bool
+
+	// redactedFields are the fields redacted before the objects are stored.
+	redactedFields []redactedField
+
+	// referencesEnabled maintains the references of the written objects extracted by the referenceRules in the references table.
+	referencesEnabled bool
+	referenceRules    []referenceRule
+
+	// ownerResolver tracks the unresolved owner references of the written objects, it is disabled if it is nil.
+	ownerResolver *ownerResolver
+
+	// clusterAliases resolve the old names of the renamed clusters in the reads.
+	clusterAliases clusterAliases
+
+	// shadowAnnotations are injected into the returned objects by the rows.
+	shadowAnnotations shadowAnnotations
+
+	// objectSizeLimit limits the size of the stored objects, the limit is disabled if it is nil.
+	objectSizeLimit *objectSizeLimit
+
+	// storagePolicy is the storage policy of the resource, all of the objects are stored if it is nil.
+	storagePolicy *storagePolicy
+
+	// writeBehind queues the writes of the write-behind mode, the objects are written synchronously if it is nil.
+	writeBehind *writeBehindQueue
+
+	// leases fence the writes of the clusters whose leases aren't held by the writers.
+	leases *clusterLeases
+
+	// audit receives the records of the mutations, the audit is disabled if it is nil.
+	audit AuditSink
+
+	// history records the revisions replaced by the updates and deletes, the history is disabled if it is nil.
+	history *historyRecorder
+
+	// notifications receive the notifications of the persisted changes, the notification is disabled if it is nil.
+	notifications NotificationSink
+
+	// eventStream publishes the persisted mutations to the message bus, the event stream is disabled if it is nil.
+	eventStream *eventStream
+
+	// getCache caches the objects of the get requests, it is invalidated by the writes of the objects.
+	getCache *getCache
+
+	// notFoundCache caches the NotFound results of the get requests, it is invalidated by the writes of the objects.
+	notFoundCache *getCache
+
+	// listCache shares the objects of the identical list queries within its TTL, it is disabled if it is nil.
+	listCache *listCache
+
+	// getFlight shares the query of the concurrent get requests of the same object, it is disabled if it is nil.
+	getFlight *singleflight.Group
+
+	// hub broadcasts the writes to the watchers in the same process, the watch is not supported if it is nil.
+	hub *watchHub
+
+	// indexHints are the index hints of the list queries of the shapes.
+	indexHints indexHints
+
+	// budget limits the concurrent reads and writes of the database, they are unlimited if it is nil.
+	budget *connectionBudget
+
+	// prepared are the prepared queries of the Get and Update, the queries are built by every call if it is nil.
+	prepared *preparedQueries
+
+	// encodedSize is the moving average of the encoded object sizes of the resource,
+	// the pooled buffers are pre-sized by it before the objects are encoded.
+	encodedSize atomic.Int64
 
 	storageGroupResource schema.GroupResource
 	storageVersion       schema.GroupVersion
 	memoryVersion        schema.GroupVersion
+
+	// legacyVersions are the previous storage versions in priority order, the gets read their rows
+	// if the objects aren't stored in the storage version.
+	legacyVersions []string
+
+	// legacyCodecs decode the objects which can't be decoded by the codec in order.
+	legacyCodecs []storage.VersionedCodec
+
+	// clusterScoped is set if the resource is cluster-scoped, its objects are stored with the empty namespace.
+	clusterScoped bool
 }
 
 func (s *ResourceStorage) GetStorageConfig() *storage.ResourceStorageConfig {
 	return &storage.ResourceStorageConfig{
 		Codec:                s.codec,
+		LegacyCodecs:         s.legacyCodecs,
 		StorageGroupResource: s.storageGroupResource,
 		StorageVersion:       s.storageVersion,
 		MemoryVersion:        s.memoryVersion,
+		Namespaced:           !s.clusterScoped,
+	}
+}
+
+// checkScope rejects the namespaced requests of the cluster-scoped resource, they never match any stored objects.
+func (s *ResourceStorage) checkScope(namespaces ...string) error {
+	if !s.clusterScoped {
+		return nil
+	}
+	for _, namespace := range namespaces {
+		if namespace != "" {
+			return apierrors.NewBadRequest(fmt.Sprintf("%s is cluster-scoped, the namespace %q can't be specified", s.storageGroupResource, namespace))
+		}
+	}
+	return nil
+}
+
+// objectKey returns the key of the object in the errors, the key of the cluster-scoped object is its name.
+func (s *ResourceStorage) objectKey(namespace, name string) string {
+	if s.clusterScoped || namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+// withTimeout applies the timeout to the ctx if the ctx has no deadline,
+// so that a stuck database connection can't block the caller indefinitely.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (s *ResourceStorage) storageGVR() schema.GroupVersionResource {
+	return s.storageGroupResource.WithVersion(s.storageVersion.Version)
+}
+
+// publish broadcasts the written object to the watchers after it is written to the database.
+func (s *ResourceStorage) publish(eventType watch.EventType, cluster string, metaobj metav1.Object, object []byte) {
+	if !s.hub.enabled() {
+		return
+	}
+	s.hub.publish(s.storageGVR(), &hubEvent{
+		eventType: eventType,
+		cluster:   cluster,
+		namespace: metaobj.GetNamespace(),
+		name:      metaobj.GetName(),
+		labels:    metaobj.GetLabels(),
+		object:    object,
+	})
+}
+
+func objectAttributes(metaobj metav1.Object) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("namespace", metaobj.GetNamespace()),
+		attribute.String("name", metaobj.GetName()),
 	}
 }
 
 func (s *ResourceStorage) Create(ctx context.Context, cluster string, obj runtime.Object) error {
+	if s.writeBehind != nil {
+		return s.writeBehind.enqueue(ctx, writeOperationCreate, cluster, obj)
+	}
+	return s.create(ctx, cluster, obj)
+}
+
+func (s *ResourceStorage) create(ctx context.Context, cluster string, obj runtime.Object) error {
+	if err := s.leases.fence(ctx, cluster); err != nil {
+		return err
+	}
+	if skipped, _, err := s.skipsWrite(cluster, obj); err != nil || skipped {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Create))
+	defer cancel()
+
+	release, err := s.budget.acquireWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	gvk := obj.GetObjectKind().GroupVersionKind()
 	if gvk.Kind == "" {
 		return fmt.Errorf("%s: kind is required", gvk)
@@ -54,16 +266,47 @@ func (s *ResourceStorage) Create(ctx context.Context, cluster string, obj runtim
 	if err != nil {
 		return err
 	}
+	setSpanAttributes(ctx, objectAttributes(metaobj)...)
 
 	var ownerUID types.UID
 	if owner := metav1.GetControllerOfNoCopy(metaobj); owner != nil {
 		ownerUID = owner.UID
 	}
 
-	var buffer bytes.Buffer
-	if err := s.codec.Encode(obj, &buffer); err != nil {
+	encoded, buffer, err := s.encodeObject(obj)
+	if err != nil {
+		return err
+	}
+	defer releaseAfterWrite(ctx, buffer)
+	// the references are extracted before the object is truncated
+	secondary, err := s.secondaryRows(metaobj, encoded)
+	if err != nil {
+		return err
+	}
+	encoded, truncated, err := s.limitObjectSize(cluster, metaobj, encoded)
+	if err != nil {
+		return err
+	}
+	metadata, err := s.encodeMetadata(metaobj, encoded, truncated)
+	if err != nil {
+		return err
+	}
+	object, spec, status, err := s.storedObject(encoded)
+	if err != nil {
 		return err
 	}
+	var checksum sql.NullInt64
+	if !s.checksumMissing {
+		if checksum, err = objectChecksum(object); err != nil {
+			return err
+		}
+	}
+	var contentHash sql.NullString
+	if !s.contentHashMissing {
+		if contentHash, err = objectContentHash(encoded); err != nil {
+			return err
+		}
+	}
 
 	resource := Resource{
 		Cluster:         cluster,
@@ -75,26 +318,102 @@ func (s *ResourceStorage) Create(ctx context.Context, cluster string, obj runtim
 		Resource:        s.storageGroupResource.Resource,
 		Version:         s.storageVersion.Version,
 		Kind:            gvk.Kind,
-		ResourceVersion: metaobj.GetResourceVersion(),
-		Object:          buffer.Bytes(),
+		ResourceVersion: storedResourceVersion(metaobj.GetResourceVersion(), s.resourceVersionMaxLength),
+		Object:          object,
+		Metadata:        metadata,
+		Spec:            spec,
+		Status:          status,
+		Checksum:        checksum,
+		ContentHash:     contentHash,
 		CreatedAt:       metaobj.GetCreationTimestamp().Time,
 	}
+	if !s.keyHashMissing {
+		resource.KeyHash = resourceKeyHash(resource.Group, resource.Version, resource.Resource, cluster, resource.Namespace, resource.Name)
+	}
 	if deletedAt := metaobj.GetDeletionTimestamp(); deletedAt != nil {
 		resource.DeletedAt = sql.NullTime{Time: deletedAt.Time, Valid: true}
 	}
 
-	result := s.db.WithContext(ctx).Create(&resource)
-	return InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+	writtenAt := time.Now()
+	var rowsAffected int64
+	var createErr error
+	if secondary != nil {
+		rowsAffected, createErr = s.createWithSecondaryRows(ctx, &resource, secondary)
+	} else {
+		result := s.writeDB(ctx).Omit(s.missingColumns()...).Create(&resource)
+		rowsAffected, createErr = result.RowsAffected, result.Error
+	}
+	s.invalidateGetCaches(cluster, metaobj.GetNamespace(), metaobj.GetName())
+	setSpanAttributes(ctx, attribute.Int64("rows_affected", rowsAffected))
+	if createErr != nil {
+		return InterpretResourceDBError(cluster, metaobj.GetName(), createErr)
+	}
+
+	published := s.publishedBytes(encoded)
+	afterWrite(ctx, func(ctx context.Context) {
+		s.publish(watch.Added, cluster, metaobj, published)
+		s.auditMutation(ctx, AuditOperationCreate, cluster, metaobj, "", metaobj.GetResourceVersion())
+		s.notifyChange(ctx, NotificationTypeCreated, cluster, metaobj)
+		s.streamEvent(EventOperationCreate, writtenAt, cluster, metaobj, encoded, object)
+		s.ownerResolver.written(cluster, s.storageGroupResource, metaobj, writtenAt)
+	})
+	return nil
 }
 
 func (s *ResourceStorage) Update(ctx context.Context, cluster string, obj runtime.Object) error {
+	if s.writeBehind != nil {
+		return s.writeBehind.enqueue(ctx, writeOperationUpdate, cluster, obj)
+	}
+	return s.update(ctx, cluster, obj)
+}
+
+func (s *ResourceStorage) update(ctx context.Context, cluster string, obj runtime.Object) error {
+	if err := s.leases.fence(ctx, cluster); err != nil {
+		return err
+	}
+	if skipped, deleteStored, err := s.skipsWrite(cluster, obj); err != nil || skipped {
+		if deleteStored {
+			// the object may be stored before its labels no longer match the sampled selector
+			return s.delete(ctx, cluster, obj)
+		}
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Update))
+	defer cancel()
+
+	release, err := s.budget.acquireWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	metaobj, err := meta.Accessor(obj)
 	if err != nil {
 		return err
 	}
+	setSpanAttributes(ctx, objectAttributes(metaobj)...)
 
-	var buffer bytes.Buffer
-	if err := s.codec.Encode(obj, &buffer); err != nil {
+	encoded, buffer, err := s.encodeObject(obj)
+	if err != nil {
+		return err
+	}
+	defer releaseAfterWrite(ctx, buffer)
+	secondary, err := s.secondaryRows(metaobj, encoded)
+	if err != nil {
+		return err
+	}
+	encoded, truncated, err := s.limitObjectSize(cluster, metaobj, encoded)
+	if err != nil {
+		return err
+	}
+	metadata, err := s.encodeMetadata(metaobj, encoded, truncated)
+	if err != nil {
+		return err
+	}
+
+	object, spec, status, err := s.storedObject(encoded)
+	if err != nil {
 		return err
 	}
 
@@ -108,66 +427,105 @@ func (s *ResourceStorage) Update(ctx context.Context, cluster string, obj runtim
 	updatedResource := map[string]interface{}{
 		"owner_uid":        ownerUID,
 		"uid":              metaobj.GetUID(),
-		"resource_version": metaobj.GetResourceVersion(),
-		"object":           datatypes.JSON(buffer.Bytes()),
+		"resource_version": storedResourceVersion(metaobj.GetResourceVersion(), s.resourceVersionMaxLength),
+		"object":           datatypes.JSON(object),
+		"metadata":         metadata,
 		"created_at":       metaobj.GetCreationTimestamp().Time,
 	}
+	if !s.splitColumnsMissing {
+		// the columns are cleared if the object isn't split, e.g. the resource is removed from the split objects
+		updatedResource["spec"], updatedResource["status"] = spec, status
+	}
+	if !s.checksumMissing {
+		checksum, err := objectChecksum(object)
+		if err != nil {
+			return err
+		}
+		updatedResource["checksum"] = checksum
+	}
+	var contentHash sql.NullString
+	if !s.contentHashMissing {
+		if contentHash, err = objectContentHash(encoded); err != nil {
+			return err
+		}
+		updatedResource["content_hash"] = contentHash
+	}
 	if deletedAt := metaobj.GetDeletionTimestamp(); deletedAt != nil {
 		updatedResource["deleted_at"] = sql.NullTime{Time: deletedAt.Time, Valid: true}
 	}
 
-	result := s.db.WithContext(ctx).Model(&Resource{}).Where(map[string]interface{}{
-		"cluster":   cluster,
-		"group":     s.storageGroupResource.Group,
-		"version":   s.storageVersion.Version,
-		"resource":  s.storageGroupResource.Resource,
-		"namespace": metaobj.GetNamespace(),
-		"name":      metaobj.GetName(),
-	}).Updates(updatedResource)
-	return InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
-}
-
-func (s *ResourceStorage) ConvertDeletedObject(obj interface{}) (runtime.Object, error) {
-	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	oldResourceVersion, err := s.auditedResourceVersion(ctx, cluster, metaobj)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	revision, err := s.historyRevision(ctx, cluster, metaobj)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// Since it is not necessary to save the complete deleted object to the queue,
-	// we convert the object to `PartialObjectMetadata`
-	return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
-}
-
-func (s *ResourceStorage) deleteObject(cluster, namespace, name string) *gorm.DB {
-	return s.db.Model(&Resource{}).Where(map[string]interface{}{
-		"cluster":   cluster,
-		"group":     s.storageGroupResource.Group,
-		"version":   s.storageVersion.Version,
-		"resource":  s.storageGroupResource.Resource,
-		"namespace": namespace,
-		"name":      name,
-	}).Delete(&Resource{})
-}
-
-func (s *ResourceStorage) Delete(ctx context.Context, cluster string, obj runtime.Object) error {
-	metaobj, err := meta.Accessor(obj)
+	// the object of the same name is recreated, the stored object is gone instead of being modified
+	recreated, replaced, err := s.recreatedObject(ctx, cluster, metaobj, revision)
 	if err != nil {
 		return err
 	}
 
-	if result := s.deleteObject(cluster, metaobj.GetNamespace(), metaobj.GetName()); result.Error != nil {
-		return InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+	var rowsAffected int64
+	var updateErr error
+	updateType := updateTypeFull
+	writtenAt := time.Now()
+	if s.lightUpdates {
+		// the unchanged object isn't rewritten, only the resource version and the synced_at are updated
+		rowsAffected, updateErr = s.lightUpdate(ctx, cluster, metaobj, contentHash.String, updatedResource["resource_version"])
+		if updateErr == nil && rowsAffected != 0 {
+			updateType = updateTypeLight
+		}
+	}
+	if updateType == updateTypeFull && updateErr == nil {
+		if secondary != nil {
+			rowsAffected, updateErr = s.fullUpdateWithSecondaryRows(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName(), updatedResource, secondary)
+		} else {
+			rowsAffected, updateErr = s.fullUpdate(ctx, cluster, metaobj, updatedResource)
+		}
 	}
+	s.invalidateGetCaches(cluster, metaobj.GetNamespace(), metaobj.GetName())
+	setSpanAttributes(ctx, attribute.Int64("rows_affected", rowsAffected), attribute.String("update_type", updateType))
+	if updateErr != nil {
+		return InterpretResourceDBError(cluster, metaobj.GetName(), updateErr)
+	}
+
+	published := s.publishedBytes(encoded)
+	afterWrite(ctx, func(ctx context.Context) {
+		if recreated && rowsAffected != 0 {
+			if replaced != nil {
+				s.hub.publish(s.storageGVR(), replaced)
+			}
+			s.publish(watch.Added, cluster, metaobj, published)
+		} else {
+			s.publish(watch.Modified, cluster, metaobj, published)
+		}
+		if rowsAffected != 0 {
+			updatesTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource, updateType).Inc()
+			s.auditMutation(ctx, AuditOperationUpdate, cluster, metaobj, oldResourceVersion, metaobj.GetResourceVersion())
+			s.notifyChange(ctx, NotificationTypeUpdated, cluster, metaobj)
+			s.streamEvent(EventOperationUpdate, writtenAt, cluster, metaobj, encoded, object)
+			s.ownerResolver.written(cluster, s.storageGroupResource, metaobj, writtenAt)
+		}
+		if rowsAffected != 0 && updateType == updateTypeFull {
+			// the light updates don't change the content of the object, so they don't end the revision,
+			// the revision of the recreated object is ended as deleted.
+			s.recordHistory(revision, recreated)
+		}
+	})
 	return nil
 }
 
-func (s *ResourceStorage) genGetObjectQuery(ctx context.Context, cluster, namespace, name string) *gorm.DB {
-	return s.db.WithContext(ctx).Model(&Resource{}).Select("object").Where(map[string]interface{}{
+// updateQuery returns the query of the row of the updated object.
+func (s *ResourceStorage) updateQuery(ctx context.Context, cluster string, metaobj metav1.Object) *gorm.DB {
+	return s.objectQuery(s.writeDB(ctx), cluster, metaobj.GetNamespace(), metaobj.GetName())
+}
+
+// objectQuery returns the query of the row of the object in the db, e.g. the transaction.
+func (s *ResourceStorage) objectQuery(db *gorm.DB, cluster, namespace, name string) *gorm.DB {
+	return db.Model(&Resource{}).Where(map[string]interface{}{
 		"cluster":   cluster,
 		"group":     s.storageGroupResource.Group,
 		"version":   s.storageVersion.Version,
@@ -177,127 +535,1123 @@ func (s *ResourceStorage) genGetObjectQuery(ctx context.Context, cluster, namesp
 	})
 }
 
-func (s *ResourceStorage) Get(ctx context.Context, cluster, namespace, name string, into runtime.Object) error {
-	var objects [][]byte
-	if result := s.genGetObjectQuery(ctx, cluster, namespace, name).First(&objects); result.Error != nil {
-		return InterpretResourceDBError(cluster, namespace+"/"+name, result.Error)
+// lightUpdate updates the resource version of the object if its content hash is unchanged.
+func (s *ResourceStorage) lightUpdate(ctx context.Context, cluster string, metaobj metav1.Object, contentHash string, resourceVersion interface{}) (int64, error) {
+	if s.usePreparedQueries() && writeBatchFrom(ctx) == nil {
+		return s.prepared.lightUpdate.exec(ctx, s.db, resourceVersion, s.db.NowFunc(), cluster, metaobj.GetNamespace(), metaobj.GetName(), contentHash)
 	}
 
-	obj, _, err := s.codec.Decode(objects[0], nil, into)
-	if err != nil {
-		return err
-	}
-	if obj != into {
-		return fmt.Errorf("failed to decode resource, into is %T", into)
+	result := s.updateQuery(ctx, cluster, metaobj).Where("content_hash = ?", contentHash).
+		Updates(map[string]interface{}{"resource_version": resourceVersion})
+	return result.RowsAffected, result.Error
+}
+
+// fullUpdate writes the updated columns of the object.
+func (s *ResourceStorage) fullUpdate(ctx context.Context, cluster string, metaobj metav1.Object, updatedResource map[string]interface{}) (int64, error) {
+	if s.usePreparedQueries() && writeBatchFrom(ctx) == nil {
+		_, deleted := updatedResource["deleted_at"]
+		query := s.prepared.update
+		if deleted {
+			query = s.prepared.updateDeleted
+		}
+
+		columns := s.updatedColumns(deleted)
+		values := make([]interface{}, 0, len(columns)+3)
+		for _, column := range columns[:len(columns)-1] {
+			values = append(values, updatedResource[column])
+		}
+		values = append(values, s.db.NowFunc(), cluster, metaobj.GetNamespace(), metaobj.GetName())
+		return query.exec(ctx, s.db, values...)
 	}
-	return nil
+
+	result := s.updateQuery(ctx, cluster, metaobj).Updates(updatedResource)
+	return result.RowsAffected, result.Error
 }
 
-func (s *ResourceStorage) genListObjectsQuery(ctx context.Context, opts *internal.ListOptions) (int64, *int64, *gorm.DB, ObjectList, error) {
-	var result ObjectList = &BytesList{}
-	if opts.OnlyMetadata {
-		result = &ResourceMetadataList{}
+// missingColumns are the columns omitted by the Create, which don't exist until their migrations are applied.
+func (s *ResourceStorage) missingColumns() []string {
+	var columns []string
+	if s.checksumMissing {
+		columns = append(columns, "Checksum")
+	}
+	if s.contentHashMissing {
+		columns = append(columns, "ContentHash")
+	}
+	if s.splitColumnsMissing {
+		columns = append(columns, "Spec", "Status")
+	}
+	if s.keyHashMissing {
+		columns = append(columns, "KeyHash")
 	}
+	return columns
+}
 
-	query := s.db.WithContext(ctx).Model(&Resource{})
-	query = query.Where(map[string]interface{}{
-		"group":    s.storageGroupResource.Group,
-		"version":  s.storageVersion.Version,
-		"resource": s.storageGroupResource.Resource,
-	})
-	offset, amount, query, err := applyListOptionsToResourceQuery(s.db, query, opts)
-	return offset, amount, query, result, err
+// encodeBufferPool reuses the buffers of the objects encoded by the Create and Update.
+var encodeBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
 }
 
-func (s *ResourceStorage) List(ctx context.Context, listObject runtime.Object, opts *internal.ListOptions) error {
-	offset, amount, query, result, err := s.genListObjectsQuery(ctx, opts)
-	if err != nil {
-		return err
+// maxPooledEncodeBufferSize is the max capacity of the pooled buffers,
+// so that the buffers of a few huge objects aren't retained by the pool.
+const maxPooledEncodeBufferSize = 1 << 20
+
+// encodeObject encodes the object into a pooled buffer and redacts the configured fields.
+//
+// The encoded bytes may be backed by the returned buffer, they are only valid until the buffer is released.
+// The writes release it by releaseAfterWrite, so the bytes are valid for the side effects of the write,
+// and only the bytes retained after the side effects, e.g. the bytes published to the watchers, are copied.
+func (s *ResourceStorage) encodeObject(obj runtime.Object) ([]byte, *bytes.Buffer, error) {
+	buffer := encodeBufferPool.Get().(*bytes.Buffer)
+	buffer.Reset()
+	if size := s.encodedSize.Load(); size > 0 {
+		// leave some headroom, so that the slightly larger objects are not regrown
+		buffer.Grow(int(size + size/8))
 	}
 
-	if err := result.From(query); err != nil {
-		return InterpretDBError(s.storageGroupResource.String(), err)
+	if err := s.codec.Encode(obj, buffer); err != nil {
+		releaseEncodeBuffer(buffer)
+		return nil, nil, err
 	}
-	objects := result.Items()
+	s.observeEncodedSize(buffer.Len())
 
-	list, err := meta.ListAccessor(listObject)
+	encoded, err := redactObject(buffer.Bytes(), s.redactedFields)
+	if err == nil && s.storagePolicy.metadataOnly() {
+		encoded, err = truncateObject(encoded, string(StoragePolicyMetadataOnly), keepMetadataOnly)
+	}
 	if err != nil {
-		return err
+		releaseEncodeBuffer(buffer)
+		return nil, nil, err
 	}
+	return encoded, buffer, nil
+}
 
-	if opts.WithContinue != nil && *opts.WithContinue {
-		if int64(len(objects)) == opts.Limit {
-			list.SetContinue(strconv.FormatInt(offset+opts.Limit, 10))
-		}
+// observeEncodedSize updates the moving average of the encoded object sizes, the concurrent updates may be lost,
+// which is fine for a size hint.
+func (s *ResourceStorage) observeEncodedSize(size int) {
+	average := s.encodedSize.Load()
+	if average == 0 {
+		s.encodedSize.Store(int64(size))
+		return
 	}
+	s.encodedSize.Store(average + (int64(size)-average)/8)
+}
 
-	if amount != nil {
-		// When offset is too large, the data in the response is empty and the remaining count is negative.
-		// This ensures that `amount = offset + len(objects) + remain`
-		remain := *amount - offset - int64(len(objects))
-		list.SetRemainingItemCount(&remain)
+// releaseEncodeBuffer returns the buffer to the pool, the bytes of the buffer must not be used after it.
+func releaseEncodeBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledEncodeBufferSize {
+		return
 	}
+	encodeBufferPool.Put(buffer)
+}
 
-	if len(objects) == 0 {
+// releaseAfterWrite releases the encode buffer after the side effects of the write have run, the side effects
+// of the batched writes run after the batch is committed. The buffer of the failed batched write is dropped
+// with its side effects and collected by the GC.
+func releaseAfterWrite(ctx context.Context, buffer *bytes.Buffer) {
+	afterWrite(ctx, func(context.Context) { releaseEncodeBuffer(buffer) })
+}
+
+// publishedBytes copies the encoded object for the watchers, which retain it after the encode buffer is released.
+func (s *ResourceStorage) publishedBytes(encoded []byte) []byte {
+	if !s.hub.enabled() {
 		return nil
 	}
+	return bytes.Clone(encoded)
+}
 
-	if unstructuredList, ok := listObject.(*unstructured.UnstructuredList); ok {
-		unstructuredList.Items = make([]unstructured.Unstructured, 0, len(objects))
-		for _, object := range objects {
-			uObj := &unstructured.Unstructured{}
-			obj, err := object.ConvertTo(s.codec, uObj)
-			if err != nil {
-				return err
-			}
+// encodeMetadata extracts the metadata from the redacted object if the fields of the metadata are redacted,
+// or from the truncated object which is annotated by the truncation.
+func (s *ResourceStorage) encodeMetadata(metaobj metav1.Object, encoded []byte, truncated bool) (datatypes.JSON, error) {
+	if truncated || s.storagePolicy.metadataOnly() || metadataRedacted(s.redactedFields) {
+		return extractMetadata(encoded)
+	}
+	return encodeMetadata(metaobj, encoded)
+}
 
-			uObj, ok := obj.(*unstructured.Unstructured)
-			if !ok {
-				return genericstorage.NewInternalError("the converted object is not *unstructured.Unstructured")
-			}
+// storedObject returns the object stored in the object column, which is encrypted if the encryption is enabled for the resource,
+// and the spec and status are split from the object if the objects of the resource are split.
+func (s *ResourceStorage) storedObject(encoded []byte) (object, spec, status datatypes.JSON, err error) {
+	switch {
+	case s.encrypt:
+		object, err = s.encryption.encrypt(encoded)
+		return object, nil, nil, err
+	case s.splitObject:
+		return splitObject(encoded)
+	}
+	return encoded, nil, nil, nil
+}
 
-			if uObj.GroupVersionKind().Empty() {
-				if version := unstructuredList.GetAPIVersion(); version != "" {
-					// set to the same APIVersion as listObject
-					uObj.SetAPIVersion(version)
-				}
-				if rt := object.GetResourceType(); !rt.Empty() {
-					uObj.SetKind(rt.Kind)
-				}
-			}
-			unstructuredList.Items = append(unstructuredList.Items, *uObj)
+// encodeMetadata returns the metadata of the object for the metadata column, the metadata of the typed
+// and unstructured objects is marshaled directly, and the others are extracted from the encoded object.
+func encodeMetadata(metaobj metav1.Object, encoded []byte) (datatypes.JSON, error) {
+	switch m := metaobj.(type) {
+	case *metav1.ObjectMeta:
+		return json.Marshal(m)
+	case *unstructured.Unstructured:
+		metadata, ok := m.Object["metadata"]
+		if !ok {
+			return nil, nil
 		}
-		return nil
+		return json.Marshal(metadata)
 	}
+	return extractMetadata(encoded)
+}
 
-	listPtr, err := meta.GetItemsPtr(listObject)
-	if err != nil {
-		return err
+// extractMetadata extracts the metadata from the encoded object.
+func extractMetadata(encoded []byte) (datatypes.JSON, error) {
+	var object struct {
+		Metadata json.RawMessage `json:"metadata"`
 	}
-
-	v, err := conversion.EnforcePtr(listPtr)
-	if err != nil || v.Kind() != reflect.Slice {
-		return fmt.Errorf("need ptr to slice: %v", err)
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return nil, err
 	}
+	return datatypes.JSON(object.Metadata), nil
+}
 
-	slice := reflect.MakeSlice(v.Type(), len(objects), len(objects))
-	expected := reflect.New(v.Type().Elem()).Interface().(runtime.Object)
-	for i, object := range objects {
-		obj, err := object.ConvertTo(s.codec, expected.DeepCopyObject())
+func (s *ResourceStorage) ConvertDeletedObject(obj interface{}) (runtime.Object, error) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since it is not necessary to save the complete deleted object to the queue,
+	// we convert the object to `PartialObjectMetadata`
+	return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, nil
+}
+
+func (s *ResourceStorage) deleteObject(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) *gorm.DB {
+	query := s.objectQuery(s.writeDB(ctx), cluster, namespace, name)
+	return s.queryPreconditions(query, preconditions).Delete(&Resource{})
+}
+
+// queryPreconditions filters the row of the object by the uid and the resource version of the preconditions.
+func (s *ResourceStorage) queryPreconditions(query *gorm.DB, preconditions *metav1.Preconditions) *gorm.DB {
+	if preconditions == nil {
+		return query
+	}
+	if preconditions.UID != nil {
+		query = query.Where("uid = ?", *preconditions.UID)
+	}
+	if preconditions.ResourceVersion != nil {
+		query = query.Where("resource_version = ?", storedResourceVersion(*preconditions.ResourceVersion, s.resourceVersionMaxLength))
+	}
+	return query
+}
+
+func (s *ResourceStorage) Delete(ctx context.Context, cluster string, obj runtime.Object) error {
+	if s.writeBehind != nil {
+		return s.writeBehind.enqueue(ctx, writeOperationDelete, cluster, obj)
+	}
+	return s.delete(ctx, cluster, obj)
+}
+
+var _ storage.PreconditionDeleter = &ResourceStorage{}
+
+// DeleteWithPreconditions deletes the stored object only if its uid and resource version match the preconditions,
+// e.g. the cleanup tools don't delete the recreated object. The queued writes of the write-behind mode are flushed
+// before the deletion, so the preconditions are checked against the written object.
+func (s *ResourceStorage) DeleteWithPreconditions(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) error {
+	if err := s.checkScope(namespace); err != nil {
+		return err
+	}
+	if err := s.Flush(ctx); err != nil {
+		return err
+	}
+
+	obj := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	return s.deleteWithPreconditions(ctx, cluster, obj, preconditions)
+}
+
+func (s *ResourceStorage) delete(ctx context.Context, cluster string, obj runtime.Object) error {
+	return s.deleteWithPreconditions(ctx, cluster, obj, nil)
+}
+
+// deleteWithPreconditions deletes the stored object, the missing object isn't an error unless the preconditions are set.
+func (s *ResourceStorage) deleteWithPreconditions(ctx context.Context, cluster string, obj runtime.Object, preconditions *metav1.Preconditions) error {
+	if err := s.leases.fence(ctx, cluster); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Delete))
+	defer cancel()
+
+	release, err := s.budget.acquireWrite(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	metaobj, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	setSpanAttributes(ctx, objectAttributes(metaobj)...)
+
+	// The deleted object passed by the synchro only has the namespace and name,
+	// the stored object is sent to the watchers as the last state of the deleted object.
+	var objects [][]byte
+	if s.hub.enabled() {
+		var resources []Resource
+		if result := s.genGetObjectQuery(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName()).Select(s.objectColumns()).Limit(1).Find(&resources); result.Error != nil {
+			return InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+		}
+		if len(resources) != 0 {
+			// the deletion isn't blocked by the object which can't be decrypted, it just isn't sent to the watchers
+			if object, err := s.encryption.decrypt(resources[0].Object); err != nil {
+				klog.ErrorS(err, "Failed to decrypt the deleted object", "cluster", cluster, "namespace", metaobj.GetNamespace(), "name", metaobj.GetName())
+			} else {
+				objects = append(objects, assembleObject(object, resources[0].Spec, resources[0].Status))
+			}
+		}
+	}
+
+	oldResourceVersion, err := s.auditedResourceVersion(ctx, cluster, metaobj)
+	if err != nil {
+		return err
+	}
+	revision, err := s.historyRevision(ctx, cluster, metaobj)
+	if err != nil {
+		return err
+	}
+
+	writtenAt := time.Now()
+	var rowsAffected int64
+	var deleteErr error
+	if s.labelSelectorMode == LabelSelectorTable || s.referencesEnabled {
+		rowsAffected, deleteErr = s.deleteWithSecondaryRows(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName(), preconditions)
+	} else {
+		result := s.deleteObject(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName(), preconditions)
+		rowsAffected, deleteErr = result.RowsAffected, result.Error
+	}
+	s.invalidateGetCaches(cluster, metaobj.GetNamespace(), metaobj.GetName())
+	setSpanAttributes(ctx, attribute.Int64("rows_affected", rowsAffected))
+	if deleteErr != nil {
+		return InterpretResourceDBError(cluster, metaobj.GetName(), deleteErr)
+	}
+	if rowsAffected == 0 && preconditions != nil {
+		return s.preconditionsError(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName(), preconditions)
+	}
+
+	if rowsAffected == 0 {
+		return nil
+	}
+	afterWrite(ctx, func(ctx context.Context) {
+		if len(objects) != 0 {
+			s.publish(watch.Deleted, cluster, metaobj, objects[0])
+		}
+		s.auditMutation(ctx, AuditOperationDelete, cluster, metaobj, oldResourceVersion, "")
+		s.notifyChange(ctx, NotificationTypeDeleted, cluster, metaobj)
+		s.streamEvent(EventOperationDelete, writtenAt, cluster, metaobj, nil, nil)
+		s.ownerResolver.deleted(cluster, s.storageGroupResource, metaobj)
+		s.recordHistory(revision, true)
+	})
+	return nil
+}
+
+// preconditionsError distinguishes the object which doesn't match the preconditions from the missing object,
+// after the deletion with the preconditions deletes nothing.
+func (s *ResourceStorage) preconditionsError(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) error {
+	key := fmt.Sprintf("%s/%s", cluster, s.objectKey(namespace, name))
+
+	var resources []Resource
+	result := s.genGetObjectQuery(ctx, cluster, namespace, name).Select("uid", "resource_version").Limit(1).Find(&resources)
+	if result.Error != nil {
+		return InterpretResourceDBError(cluster, name, result.Error)
+	}
+	if len(resources) == 0 {
+		return storage.NewError(ErrNotFound, apierrors.NewNotFound(s.storageGroupResource, key))
+	}
+
+	stored := resources[0]
+	var reason string
+	switch {
+	case preconditions.UID != nil && *preconditions.UID != stored.UID:
+		reason = fmt.Sprintf("the uid in the precondition is %s, but the stored uid is %s", *preconditions.UID, stored.UID)
+	case preconditions.ResourceVersion != nil:
+		reason = fmt.Sprintf("the resource version in the precondition is %s, but the stored resource version is %s", *preconditions.ResourceVersion, stored.ResourceVersion)
+	default:
+		reason = "the stored object is changed during the deletion"
+	}
+	return storage.NewError(ErrConflict, apierrors.NewConflict(s.storageGroupResource, key, errors.New(reason)))
+}
+
+// auditedResourceVersion returns the stored resource version of the object before it is updated or deleted,
+// it is only queried if the audit is enabled.
+func (s *ResourceStorage) auditedResourceVersion(ctx context.Context, cluster string, metaobj metav1.Object) (string, error) {
+	if s.audit == nil {
+		return "", nil
+	}
+
+	var versions []string
+	result := s.genGetObjectQuery(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName()).Select("resource_version").Limit(1).Find(&versions)
+	if result.Error != nil {
+		return "", InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+	}
+	if len(versions) == 0 {
+		return "", nil
+	}
+	return versions[0], nil
+}
+
+// recreatedObject detects the stored object which is replaced by the update of another object with the same name,
+// e.g. the namespace is deleted and recreated while the deletions are missed by the synchro.
+// The stored uid is only queried if the replacement is observed by the history or the watch hub,
+// the replaced object is returned for the watchers.
+func (s *ResourceStorage) recreatedObject(ctx context.Context, cluster string, metaobj metav1.Object, revision *ResourceHistory) (bool, *hubEvent, error) {
+	watched := s.hub.enabled()
+	if !watched {
+		return revision != nil && revision.UID != metaobj.GetUID(), nil, nil
+	}
+
+	var resources []Resource
+	result := s.genGetObjectQuery(ctx, cluster, metaobj.GetNamespace(), metaobj.GetName()).
+		Select(append(s.objectColumns(), "uid")).Limit(1).Find(&resources)
+	if result.Error != nil {
+		return false, nil, InterpretResourceDBError(cluster, metaobj.GetName(), result.Error)
+	}
+	if len(resources) == 0 || resources[0].UID == metaobj.GetUID() {
+		return false, nil, nil
+	}
+
+	object, err := s.encryption.decrypt(resources[0].Object)
+	if err != nil {
+		// the update isn't blocked by the object which can't be decrypted, it just isn't sent to the watchers
+		klog.ErrorS(err, "Failed to decrypt the replaced object", "cluster", cluster, "namespace", metaobj.GetNamespace(), "name", metaobj.GetName())
+		return true, nil, nil
+	}
+	object = assembleObject(object, resources[0].Spec, resources[0].Status)
+
+	var replaced metav1.PartialObjectMetadata
+	if err := json.Unmarshal(object, &replaced); err != nil {
+		klog.ErrorS(err, "Failed to decode the replaced object", "cluster", cluster, "namespace", metaobj.GetNamespace(), "name", metaobj.GetName())
+		return true, nil, nil
+	}
+	return true, &hubEvent{
+		eventType: watch.Deleted,
+		cluster:   cluster,
+		namespace: metaobj.GetNamespace(),
+		name:      metaobj.GetName(),
+		labels:    replaced.GetLabels(),
+		object:    object,
+	}, nil
+}
+
+// auditMutation sends the record of the persisted mutation to the audit sink.
+func (s *ResourceStorage) auditMutation(ctx context.Context, operation AuditOperation, cluster string, metaobj metav1.Object, oldResourceVersion, newResourceVersion string) {
+	if s.audit == nil {
+		return
+	}
+	s.audit.Audit(ctx, &AuditRecord{
+		Cluster:            cluster,
+		Group:              s.storageGroupResource.Group,
+		Version:            s.storageVersion.Version,
+		Resource:           s.storageGroupResource.Resource,
+		Namespace:          metaobj.GetNamespace(),
+		Name:               metaobj.GetName(),
+		Operation:          operation,
+		OldResourceVersion: oldResourceVersion,
+		NewResourceVersion: newResourceVersion,
+		Time:               time.Now(),
+	})
+}
+
+var _ storage.LatestResourceVersionGetter = &ResourceStorage{}
+
+// GetLatestResourceVersion returns the max resource version of the stored resources of the cluster.
+//
+// The resource version is stored as a string, ordering by its length first
+// to compare the numeric resource versions without the dialect-specific cast.
+func (s *ResourceStorage) GetLatestResourceVersion(ctx context.Context, cluster string) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Get))
+	defer cancel()
+
+	// the latest resource version is queried by the synchro, so it takes the budget of the writes
+	release, err := s.budget.acquireWrite(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var rvs []string
+	result := s.db.WithContext(ctx).Model(&Resource{}).Where(map[string]interface{}{
+		"cluster":  cluster,
+		"group":    s.storageGroupResource.Group,
+		"version":  s.storageVersion.Version,
+		"resource": s.storageGroupResource.Resource,
+	}).Order("LENGTH(resource_version) DESC").Order("resource_version DESC").Limit(1).Pluck("resource_version", &rvs)
+	if result.Error != nil {
+		return "", InterpretDBError(cluster, result.Error)
+	}
+	// the watch can't be resumed from the hashed resource version
+	if len(rvs) == 0 || isHashedResourceVersion(rvs[0]) {
+		return "", nil
+	}
+	return rvs[0], nil
+}
+
+var _ storage.ResourceCounter = &ResourceStorage{}
+
+// CountResources returns the number of the stored resources of the cluster, the count is served by the unique index
+// whose prefix is the group, version, resource and cluster.
+func (s *ResourceStorage) CountResources(ctx context.Context, cluster string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	// the count is queried by the synchro, so it takes the budget of the writes
+	release, err := s.budget.acquireWrite(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var count int64
+	result := s.db.WithContext(ctx).Model(&Resource{}).Where(map[string]interface{}{
+		"cluster":  cluster,
+		"group":    s.storageGroupResource.Group,
+		"version":  s.storageVersion.Version,
+		"resource": s.storageGroupResource.Resource,
+	}).Count(&count)
+	if result.Error != nil {
+		return 0, InterpretDBError(cluster, result.Error)
+	}
+	return count, nil
+}
+
+// objectColumns are the columns of the stored object, the spec and status are selected if the columns exist.
+func (s *ResourceStorage) objectColumns() []string {
+	if s.splitColumnsMissing {
+		return []string{"object"}
+	}
+	return []string{"object", "spec", "status"}
+}
+
+func (s *ResourceStorage) genGetObjectQuery(ctx context.Context, cluster, namespace, name string) *gorm.DB {
+	return s.writeDB(ctx).Model(&Resource{}).Select("object").Where(map[string]interface{}{
+		"cluster":   cluster,
+		"group":     s.storageGroupResource.Group,
+		"version":   s.storageVersion.Version,
+		"resource":  s.storageGroupResource.Resource,
+		"namespace": namespace,
+		"name":      name,
+	})
+}
+
+func (s *ResourceStorage) Get(ctx context.Context, cluster, namespace, name string, into runtime.Object) error {
+	if err := s.checkScope(namespace); err != nil {
+		return err
+	}
+	cluster = s.clusterAliases.resolve(cluster)
+	if err := checkClusterAllowed(ctx, cluster); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.Get))
+	defer cancel()
+
+	if version, ok := partialObjectMetadataVersion(into); ok {
+		return s.getMetadata(ctx, cluster, namespace, name, version, into)
+	}
+
+	version := s.storageVersion.Version
+	object, err := s.getObject(ctx, cluster, namespace, name)
+	if err != nil && len(s.legacyVersions) != 0 && errors.Is(err, ErrNotFound) {
+		object, version, err = s.getLegacyObject(ctx, cluster, namespace, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	obj, err := s.decode(func(codec runtime.Codec) (runtime.Object, error) {
+		obj, _, err := codec.Decode(object, nil, into)
+		return obj, err
+	})
+	if err != nil {
+		obj, err = s.convertUnrecognized(Bytes(object), into, version, err)
+	}
+	if err != nil {
+		return s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+	}
+	if obj != into {
+		return fmt.Errorf("failed to decode resource, into is %T", into)
+	}
+	return s.injectShadowAnnotations(into, cluster)
+}
+
+// injectShadowAnnotations injects the shadow annotations of the row of the cluster into the returned object.
+func (s *ResourceStorage) injectShadowAnnotations(obj runtime.Object, cluster string) error {
+	return s.shadowAnnotations.inject(obj, cluster, s.storageGVR())
+}
+
+// getObject returns the stored object, it is read through the get cache and the not found cache if they are enabled,
+// and the concurrent reads of the same object share one query unless the singleflight is disabled.
+func (s *ResourceStorage) getObject(ctx context.Context, cluster, namespace, name string) ([]byte, error) {
+	key := s.getCacheKey(cluster, namespace, name)
+
+	var generation, notFoundGeneration uint64
+	if s.notFoundCache != nil {
+		var ok bool
+		if _, notFoundGeneration, ok = s.notFoundCache.get(key); ok {
+			setSpanAttributes(ctx, attribute.Bool("not_found_cache_hit", true))
+			return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), gorm.ErrRecordNotFound)
+		}
+	}
+	if s.getCache != nil {
+		var entry *getCacheEntry
+		var ok bool
+		entry, generation, ok = s.getCache.get(key)
+		setSpanAttributes(ctx, attribute.Bool("cache_hit", ok))
+		if ok {
+			return entry.object, nil
+		}
+	}
+
+	query := func() (interface{}, error) {
+		// the budget is only taken by the query of the database, not by the cache hits
+		release, err := s.budget.acquireRead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+
+		var resource Resource
+		if s.usePreparedQueries() {
+			err = s.prepared.get.queryRow(ctx, s.db, s.getDest(&resource), cluster, namespace, name)
+		} else {
+			err = s.genGetObjectQuery(ctx, cluster, namespace, name).Select(s.getColumns()).First(&resource).Error
+		}
+		if err != nil {
+			if s.notFoundCache != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+				s.notFoundCache.add(key, notFoundGeneration, nil, "")
+			}
+			return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), err)
+		}
+		if s.verifyChecksum {
+			if err := resource.verifyChecksum(); err != nil {
+				return nil, s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+			}
+		}
+		object, err := s.encryption.decrypt(resource.Object)
+		if err != nil {
+			return nil, s.decodeError(ResourceIdentity{Cluster: cluster, Namespace: namespace, Name: name}, err)
+		}
+		object = assembleObject(object, resource.Spec, resource.Status)
+		if s.getCache != nil {
+			s.getCache.add(key, generation, object, resource.ResourceVersion)
+		}
+		return object, nil
+	}
+	if s.getFlight == nil {
+		object, err := query()
+		if err != nil {
+			return nil, err
+		}
+		return object.([]byte), nil
+	}
+
+	// the query is run with the context of the first caller, the other callers only wait for
+	// the result within their own contexts.
+	select {
+	case <-ctx.Done():
+		return nil, InterpretResourceDBError(cluster, s.objectKey(namespace, name), ctx.Err())
+	case result := <-s.getFlight.DoChan(key.String(), query):
+		setSpanAttributes(ctx, attribute.Bool("singleflight_shared", result.Shared))
+		if result.Shared {
+			getSingleflightSharedTotal.Inc()
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]byte), nil
+	}
+}
+
+// invalidateGetCaches invalidates the cached object and the cached NotFound result after the object is written.
+func (s *ResourceStorage) invalidateGetCaches(cluster, namespace, name string) {
+	key := s.getCacheKey(cluster, namespace, name)
+	s.getCache.invalidate(key)
+	s.notFoundCache.invalidate(key)
+}
+
+func (s *ResourceStorage) getCacheKey(cluster, namespace, name string) getCacheKey {
+	return getCacheKey{gvr: s.storageGVR(), cluster: cluster, namespace: namespace, name: name}
+}
+
+func (s *ResourceStorage) genListObjectsQuery(ctx context.Context, db *gorm.DB, opts *internal.ListOptions) (int64, *int64, *gorm.DB, ObjectList, error) {
+	opts = s.clusterAliases.resolveListOptions(opts)
+	var result ObjectList = &BytesList{withChecksum: s.verifyChecksum, withSplit: !s.splitColumnsMissing}
+	if opts.OnlyMetadata {
+		var err error
+		if result, err = newMetadataList(opts); err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+
+	version, err := s.listVersion(opts)
+	if err != nil {
+		return 0, nil, nil, nil, err
+	}
+
+	query := querySplitObjects(db.WithContext(ctx).Model(&Resource{}), s.splitObject)
+	query = queryWithIndexHints(query, s.indexHints)
+	query = s.queryWithLabelSelector(query, true)
+	query = query.Where(map[string]interface{}{
+		"group":    s.storageGroupResource.Group,
+		"version":  version,
+		"resource": s.storageGroupResource.Resource,
+	})
+	if query, err = s.queryReferencesTo(query, opts.URLQuery); err != nil {
+		return 0, nil, nil, nil, err
+	}
+	offset, amount, query, err := applyListOptionsToResourceQuery(db, query, opts)
+	return offset, amount, query, result, err
+}
+
+// listObjects queries the objects of the list, the identical list queries within the TTL of the list cache share the objects.
+func (s *ResourceStorage) listObjects(ctx context.Context, opts *internal.ListOptions) (int64, *int64, []Object, error) {
+	key := s.listCacheKey(ctx, opts)
+	if key != "" {
+		if entry, ok := s.listCache.get(key); ok {
+			setSpanAttributes(ctx, attribute.Bool("list_cache_hit", true))
+			if debug := listDebugFrom(ctx); debug != nil {
+				debug.ListCacheHit = true
+			}
+			return entry.offset, entry.amount, entry.objects, nil
+		}
+	}
+
+	started := time.Now()
+	offset, amount, query, result, err := s.genListObjectsQuery(ctx, s.db, opts)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if err := result.From(query); err != nil {
+		return 0, nil, nil, InterpretDBError(s.storageGroupResource.String(), err)
+	}
+	objects := result.Items()
+	if key != "" {
+		s.listCache.add(key, objects, offset, amount, time.Since(started))
+	}
+	return offset, amount, objects, nil
+}
+
+// listVersion returns the stored version of the listed resources, it is the storage version
+// unless the stored version is specified by the url query.
+func (s *ResourceStorage) listVersion(opts *internal.ListOptions) (string, error) {
+	version, err := parseStoredVersion(opts.URLQuery)
+	if err != nil || version == "" {
+		return s.storageVersion.Version, err
+	}
+	return version, nil
+}
+
+func (s *ResourceStorage) List(ctx context.Context, listObject runtime.Object, opts *internal.ListOptions) error {
+	if err := s.checkScope(opts.Namespaces...); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if explainRequested(opts) {
+		explanation, err := s.ExplainList(ctx, opts)
 		if err != nil {
 			return err
 		}
-		slice.Index(i).Set(reflect.ValueOf(obj).Elem())
+		explanation.addWarnings(ctx)
+		return nil
+	}
+
+	ctx, debug := s.listDebug(ctx, opts)
+	opts, limited, err := s.queryLimit.limitListOptions(opts)
+	if err != nil {
+		return err
+	}
+	postFilters := s.memoryFilters(opts)
+	if err := s.checkPostFilterRows(opts, postFilters); err != nil {
+		return err
+	}
+	pagination := s.listContinue(ctx, opts)
+	continued := opts.Continue != ""
+	if opts, err = pagination.resolve(opts); err != nil {
+		return err
+	}
+
+	// the PartialObjectMetadata are always served from the metadata column
+	partialVersion, partial := partialObjectMetadataVersion(listObject)
+	if partial && !opts.OnlyMetadata {
+		opts = opts.DeepCopy()
+		opts.OnlyMetadata = true
+	}
+
+	skipUndecodable, err := parseSkipUndecodable(opts.URLQuery)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	offset, amount, objects, err := s.listObjects(ctx, opts)
+	if err != nil {
+		return err
+	}
+	debug.observeQuery(started, len(objects), amount != nil)
+	setSpanAttributes(ctx, attribute.Int("count", len(objects)))
+	if limited && int64(len(objects)) == opts.Limit {
+		addResultLimitedWarning(ctx, opts.Limit)
+	}
+	returned := len(objects)
+	if len(postFilters) != 0 {
+		defer func() { s.observePostFilter(ctx, len(objects), returned) }()
+	}
+	if debug != nil {
+		debug.Limit, debug.LimitForced = opts.Limit, limited
+		debug.Offset, debug.Continued = offset, continued
+		defer func() {
+			if err == nil {
+				debug.Returned = returned
+				debug.write(ctx, listObject)
+			}
+		}()
+	}
+
+	list, err := meta.ListAccessor(listObject)
+	if err != nil {
+		return err
+	}
+
+	if opts.WithContinue != nil && *opts.WithContinue {
+		if int64(len(objects)) == opts.Limit {
+			list.SetContinue(pagination.encode(offset + opts.Limit))
+		}
+	}
+
+	if amount != nil {
+		// When offset is too large, the data in the response is empty and the remaining count is negative.
+		// This ensures that `amount = offset + len(objects) + remain`
+		remain := *amount - offset - int64(len(objects))
+		list.SetRemainingItemCount(&remain)
+	}
+
+	if len(objects) == 0 {
+		return nil
+	}
+
+	var skipped int
+	defer func() {
+		if skipped != 0 {
+			setSpanAttributes(ctx, attribute.Int("skipped", skipped))
+			addUndecodableSkippedWarning(ctx, skipped)
+		}
+	}()
+
+	// the label selector filtered in memory is applied after the page, so the page may be shorter than the limit
+	selector := s.memoryLabelSelector(opts)
+
+	if unstructuredList, ok := listObject.(*unstructured.UnstructuredList); ok {
+		converted, n, err := s.convertObjects(ctx, objects, func() runtime.Object { return &unstructured.Unstructured{} }, skipUndecodable)
+		skipped = n
+		if err != nil {
+			return err
+		}
+
+		unstructuredList.Items = make([]unstructured.Unstructured, 0, len(objects))
+		for i, obj := range converted {
+			if obj == nil || !matchLabels(selector, obj) {
+				continue
+			}
+
+			uObj, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return genericstorage.NewInternalError("the converted object is not *unstructured.Unstructured")
+			}
+
+			// the object without the type is stamped with the type of its own row like the ListStream,
+			// since the rows may be stored in another version than the listObject.
+			if partial {
+				uObj.SetGroupVersionKind(partialVersion.WithKind("PartialObjectMetadata"))
+			} else if uObj.GroupVersionKind().Empty() {
+				if rt := objects[i].GetResourceType(); !rt.Empty() {
+					uObj.SetGroupVersionKind(schema.GroupVersion{Group: rt.Group, Version: rt.Version}.WithKind(rt.Kind))
+				} else if version := unstructuredList.GetAPIVersion(); version != "" {
+					uObj.SetAPIVersion(version)
+				}
+			}
+			unstructuredList.Items = append(unstructuredList.Items, *uObj)
+		}
+		returned = len(unstructuredList.Items)
+		return nil
+	}
+
+	listPtr, err := meta.GetItemsPtr(listObject)
+	if err != nil {
+		return err
+	}
+
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+
+	expected := reflect.New(v.Type().Elem()).Interface().(runtime.Object)
+	converted, n, err := s.convertObjects(ctx, objects, expected.DeepCopyObject, skipUndecodable)
+	skipped = n
+	if err != nil {
+		return err
+	}
+
+	slice := reflect.MakeSlice(v.Type(), 0, len(objects))
+	for _, obj := range converted {
+		if obj == nil || !matchLabels(selector, obj) {
+			continue
+		}
+		slice = reflect.Append(slice, reflect.ValueOf(obj).Elem())
 	}
 	v.Set(slice)
+	returned = slice.Len()
 	return nil
 }
 
-func (s *ResourceStorage) Watch(_ context.Context, _ *internal.ListOptions) (watch.Interface, error) {
-	return nil, apierrors.NewMethodNotSupported(s.storageGroupResource, "watch")
+// convertObject converts the listed object into the object, the object is verified with the checksum of its row
+// if the verification is enabled, decrypted if it is encrypted and reassembled if it is split,
+// and the error identifies the row of the object.
+func (s *ResourceStorage) convertObject(object Object, into runtime.Object) (runtime.Object, error) {
+	if s.verifyChecksum {
+		if checksummed, ok := object.(checksummedObject); ok {
+			if err := checksummed.verifyChecksum(); err != nil {
+				return nil, s.decodeError(object.GetIdentity(), err)
+			}
+		}
+	}
+
+	object, err := s.encryption.decryptObject(object)
+	if err != nil {
+		return nil, s.decodeError(object.GetIdentity(), err)
+	}
+	object = assembleSplitObject(object)
+	obj, err := s.decode(func(codec runtime.Codec) (runtime.Object, error) {
+		return object.ConvertTo(codec, into)
+	})
+	if err != nil {
+		obj, err = s.convertUnrecognized(object, into, s.storageVersion.Version, err)
+	}
+	if err != nil {
+		return nil, s.decodeError(object.GetIdentity(), err)
+	}
+	if err := s.injectShadowAnnotations(obj, object.GetIdentity().Cluster); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// decodeError identifies the row of the object which can't be decoded in the error, and counts the corrupt row.
+func (s *ResourceStorage) decodeError(row ResourceIdentity, err error) error {
+	corruptRowsTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource).Inc()
+	if errors.Is(err, ErrChecksumMismatch) {
+		checksumMismatchesTotal.WithLabelValues(s.storageGroupResource.Group, s.storageGroupResource.Resource).Inc()
+	}
+	return &ObjectDecodeError{GroupResource: s.storageGroupResource, Row: row, Err: err}
+}
+
+func addUndecodableSkippedWarning(ctx context.Context, skipped int) {
+	warning.AddWarning(ctx, "", fmt.Sprintf("%d rows are skipped since their objects can't be decoded", skipped))
+}
+
+var _ storage.ResourceStreamer = &ResourceStorage{}
+
+// ListStream lists the resources like List, but the rows are scanned and decoded one by one.
+// The continue is computed by the count of the resources up front, since the visitor receives
+// the list meta before the objects.
+func (s *ResourceStorage) ListStream(ctx context.Context, newObject func() runtime.Object, opts *internal.ListOptions, visitor storage.ListVisitor) error {
+	if err := s.checkScope(opts.Namespaces...); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, s.timeouts.timeout(s.timeouts.List))
+	defer cancel()
+
+	release, err := s.budget.acquireRead(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if explainRequested(opts) {
+		explanation, err := s.ExplainList(ctx, opts)
+		if err != nil {
+			return err
+		}
+		explanation.addWarnings(ctx)
+		return visitor.VisitListMeta(metav1.ListMeta{})
+	}
+
+	opts, limited, err := s.queryLimit.limitListOptions(opts)
+	if err != nil {
+		return err
+	}
+	postFilters := s.memoryFilters(opts)
+	if err := s.checkPostFilterRows(opts, postFilters); err != nil {
+		return err
+	}
+	pagination := s.listContinue(ctx, opts)
+	if opts, err = pagination.resolve(opts); err != nil {
+		return err
+	}
+
+	withContinue := opts.WithContinue != nil && *opts.WithContinue && opts.Limit > 0
+	withRemainingCount := opts.WithRemainingCount != nil && *opts.WithRemainingCount
+	if withContinue && !withRemainingCount {
+		opts = opts.DeepCopy()
+		opts.WithRemainingCount = &withContinue
+	}
+
+	offset, amount, query, result, err := s.genListObjectsQuery(ctx, s.db, opts)
+	if err != nil {
+		return err
+	}
+	stream, ok := result.(ObjectStream)
+	if !ok {
+		return genericstorage.NewInternalError("the object list does not support streaming")
+	}
+	skipUndecodable, err := parseSkipUndecodable(opts.URLQuery)
+	if err != nil {
+		return err
+	}
+
+	var listMeta metav1.ListMeta
+	if amount != nil {
+		// the number of the objects in the response, it is the same as the objects listed by List
+		count := max(*amount-offset, 0)
+		if opts.Limit > 0 {
+			count = min(count, opts.Limit)
+		}
+
+		if withContinue && offset+opts.Limit < *amount {
+			listMeta.Continue = pagination.encode(offset + opts.Limit)
+			if limited {
+				addResultLimitedWarning(ctx, opts.Limit)
+			}
+		}
+		if withRemainingCount {
+			remain := *amount - offset - count
+			listMeta.RemainingItemCount = &remain
+		}
+	}
+	if err := visitor.VisitListMeta(listMeta); err != nil {
+		return err
+	}
+
+	var (
+		scanned, count, skipped int
+		visitErr                error
+		selector                = s.memoryLabelSelector(opts)
+	)
+	err = stream.Stream(query, func(object Object) error {
+		scanned++
+		obj, err := s.convertObject(object, newObject())
+		if err != nil {
+			if skipUndecodable {
+				klog.ErrorS(err, "Skip the undecodable row")
+				skipped++
+				return nil
+			}
+		} else if matchLabels(selector, obj) {
+			if uObj, ok := obj.(*unstructured.Unstructured); ok && uObj.GroupVersionKind().Empty() {
+				if rt := object.GetResourceType(); !rt.Empty() {
+					uObj.SetGroupVersionKind(schema.GroupVersion{Group: rt.Group, Version: rt.Version}.WithKind(rt.Kind))
+				}
+			}
+			count++
+			err = visitor.VisitObject(obj)
+		}
+		// the errors of the conversion and the visitor are not the errors of the database
+		visitErr = err
+		return err
+	})
+	setSpanAttributes(ctx, attribute.Int("count", count))
+	if len(postFilters) != 0 {
+		s.observePostFilter(ctx, scanned, count)
+	}
+	if skipped != 0 {
+		setSpanAttributes(ctx, attribute.Int("skipped", skipped))
+		addUndecodableSkippedWarning(ctx, skipped)
+	}
+	if visitErr != nil {
+		return visitErr
+	}
+	if err != nil {
+		return InterpretDBError(s.storageGroupResource.String(), err)
+	}
+	return nil
 }
 
-func applyListOptionsToResourceQuery(db *gorm.DB, query *gorm.DB, opts *internal.ListOptions) (int64, *int64, *gorm.DB, error) {
+// Watch subscribes to the writes of the resource storages in the same process by the watch hub,
+// the watch starts from now if the resource version is unset or 0, otherwise it is resumed from
+// the revision of the hub carried by a bookmark, the other resource versions fail with 410 Gone.
+func (s *ResourceStorage) Watch(ctx context.Context, opts *internal.ListOptions) (watch.Interface, error) {
+	if s.hub == nil {
+		return nil, apierrors.NewMethodNotSupported(s.storageGroupResource, "watch")
+	}
+	var revision int64
+	if rv := opts.ResourceVersion; rv != "" && rv != "0" {
+		var err error
+		if revision, err = strconv.ParseInt(rv, 10, 64); err != nil || revision <= 0 {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("the watch can't be resumed from the resource version %q", rv))
+		}
+	}
+
+	opts = s.clusterAliases.resolveListOptions(opts)
+	clusterNames, restricted, err := allowedClusterNames(ctx, opts.ClusterNames)
+	if err != nil {
+		return nil, err
+	}
+	if restricted {
+		if len(clusterNames) == 0 {
+			return watch.NewEmptyWatch(), nil
+		}
+		opts = opts.DeepCopy()
+		opts.ClusterNames = clusterNames
+	}
+
+	filter, err := newHubEventFilter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := s.hub.subscribe(s.storageGVR(), revision, filter, func(cluster string, object []byte) (runtime.Object, error) {
+		obj, _, err := s.codec.Decode(object, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		return obj, s.injectShadowAnnotations(obj, cluster)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opts.AllowWatchBookmarks {
+		watcher.bookmarkInterval = s.hub.bookmarkInterval
+	}
+	go watcher.process(ctx)
+	return watcher, nil
+}
+
+func applyListOptionsToResourceQuery(db *gorm.DB, query *gorm.DB, opts *internal.ListOptions) (_ int64, _ *int64, _ *gorm.DB, err error) {
+	filters := listFilters(opts, queryBuilderOptionsOf(query))
+	ctx, span := tracing.Start(queryContext(query), "Apply list options to resource query", listFilterAttributes(filters)...)
+	defer func() { endSpan(ctx, span, err) }()
+	if debug := listDebugFrom(ctx); debug != nil {
+		debug.SQLFilters, debug.ResidualFilters = filters.SQL, filters.PostFilter
+	}
+	query = applyIndexHint(query.WithContext(ctx), opts)
+
 	applyFn := func(query *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error) {
 		query, err := applyOwnerToResourceQuery(db, query, opts)
 		if err != nil {
@@ -311,24 +1665,24 @@ func applyListOptionsToResourceQuery(db *gorm.DB, query *gorm.DB, opts *internal
 }
 
 func applyOwnerToResourceQuery(db *gorm.DB, query *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error) {
-	var ownerQuery interface{}
-	switch {
-	case len(opts.ClusterNames) != 1:
+	if len(opts.ClusterNames) != 1 || (opts.OwnerUID == "" && opts.OwnerName == "") {
 		return query, nil
+	}
 
-	case opts.OwnerUID != "":
-		ownerQuery = buildOwnerQueryByUID(db, opts.ClusterNames[0], opts.OwnerUID, opts.OwnerSeniority)
+	ctx, span := tracing.Start(queryContext(query), "Build owner subquery",
+		attribute.String("cluster", opts.ClusterNames[0]),
+		attribute.String("owner_uid", opts.OwnerUID),
+		attribute.String("owner_name", opts.OwnerName),
+		attribute.String("owner_namespace", opts.OwnerNamespace),
+		attribute.Int("owner_seniority", opts.OwnerSeniority),
+	)
+	defer func() { endSpan(ctx, span, nil) }()
 
-	case opts.OwnerName != "":
-		var ownerNamespaces []string
-		if len(opts.Namespaces) != 0 {
-			// match namespaced and clustered owner resources
-			ownerNamespaces = append(opts.Namespaces, "")
-		}
-		ownerQuery = buildOwnerQueryByName(db, opts.ClusterNames[0], ownerNamespaces, opts.OwnerGroupResource, opts.OwnerName, opts.OwnerSeniority)
-
-	default:
-		return query, nil
+	var ownerQuery interface{}
+	if opts.OwnerUID != "" {
+		ownerQuery = buildOwnerQueryByUID(db, opts.ClusterNames[0], opts.OwnerUID, opts.OwnerSeniority)
+	} else {
+		ownerQuery = buildOwnerQueryByName(db, opts.ClusterNames[0], opts.OwnerNamespace, opts.OwnerGroupResource, opts.OwnerName, opts.OwnerSeniority)
 	}
 
 	if _, ok := ownerQuery.(string); ok {
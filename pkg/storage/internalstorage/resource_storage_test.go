@@ -3,19 +3,32 @@ package internalstorage
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics/testutil"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
 )
 
@@ -172,8 +185,36 @@ func TestApplyListOptionsToResourceQuery_Owner(t *testing.T) {
 				OwnerName:    "owner-name-1",
 			},
 			expected{
-				`SELECT * FROM "resources" WHERE cluster = 'cluster-1' AND namespace IN ('ns-1','ns-2') AND owner_uid IN (SELECT "uid" FROM "resources" WHERE "cluster" = 'cluster-1' AND namespace IN ('ns-1','ns-2','') AND name = 'owner-name-1')`,
-				"SELECT * FROM `resources` WHERE cluster = 'cluster-1' AND namespace IN ('ns-1','ns-2') AND owner_uid IN (SELECT `uid` FROM `resources` WHERE `cluster` = 'cluster-1' AND namespace IN ('ns-1','ns-2','') AND name = 'owner-name-1')",
+				`SELECT * FROM "resources" WHERE cluster = 'cluster-1' AND namespace IN ('ns-1','ns-2') AND owner_uid IN (SELECT "uid" FROM "resources" WHERE "cluster" = 'cluster-1' AND name = 'owner-name-1')`,
+				"SELECT * FROM `resources` WHERE cluster = 'cluster-1' AND namespace IN ('ns-1','ns-2') AND owner_uid IN (SELECT `uid` FROM `resources` WHERE `cluster` = 'cluster-1' AND name = 'owner-name-1')",
+				"",
+			},
+		},
+		{
+			"owner name with owner namespace",
+			&internal.ListOptions{
+				ClusterNames:   []string{"cluster-1"},
+				OwnerName:      "owner-name-1",
+				OwnerNamespace: "ns-3",
+			},
+			expected{
+				`SELECT * FROM "resources" WHERE cluster = 'cluster-1' AND owner_uid IN (SELECT "uid" FROM "resources" WHERE "cluster" = 'cluster-1' AND namespace = 'ns-3' AND name = 'owner-name-1')`,
+				"SELECT * FROM `resources` WHERE cluster = 'cluster-1' AND owner_uid IN (SELECT `uid` FROM `resources` WHERE `cluster` = 'cluster-1' AND namespace = 'ns-3' AND name = 'owner-name-1')",
+				"",
+			},
+		},
+		{
+			"owner name with namespaces and owner namespace",
+			&internal.ListOptions{
+				ClusterNames:   []string{"cluster-1"},
+				Namespaces:     []string{"ns-1"},
+				OwnerName:      "owner-name-1",
+				OwnerNamespace: "ns-3",
+				OwnerSeniority: 1,
+			},
+			expected{
+				`SELECT * FROM "resources" WHERE cluster = 'cluster-1' AND namespace = 'ns-1' AND owner_uid IN (SELECT "uid" FROM "resources" WHERE "cluster" = 'cluster-1' AND owner_uid IN (SELECT "uid" FROM "resources" WHERE "cluster" = 'cluster-1' AND namespace = 'ns-3' AND name = 'owner-name-1'))`,
+				"SELECT * FROM `resources` WHERE cluster = 'cluster-1' AND namespace = 'ns-1' AND owner_uid IN (SELECT `uid` FROM `resources` WHERE `cluster` = 'cluster-1' AND owner_uid IN (SELECT `uid` FROM `resources` WHERE `cluster` = 'cluster-1' AND namespace = 'ns-3' AND name = 'owner-name-1'))",
 				"",
 			},
 		},
@@ -184,6 +225,44 @@ func TestApplyListOptionsToResourceQuery_Owner(t *testing.T) {
 	}
 }
 
+func TestApplyListOptionsToResourceQuery_OwnerScope(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	resources := []struct{ resource, namespace, name, uid, owner string }{
+		// the cluster-scoped owner and the namespaced owner share the name
+		{"nodes", "", "owner", "node-uid", ""},
+		{"configmaps", "ns-b", "owner", "configmap-uid", ""},
+		{"pods", "ns-a", "pod-of-node", "pod-1", "node-uid"},
+		// the owner is in another namespace
+		{"pods", "ns-a", "pod-of-configmap", "pod-2", "configmap-uid"},
+		{"pods", "ns-b", "pod-in-owner-namespace", "pod-3", "configmap-uid"},
+	}
+	for _, r := range resources {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Version: "v1", Resource: r.resource, Namespace: r.namespace, Name: r.name,
+			UID: types.UID(r.uid), OwnerUID: types.UID(r.owner), Object: []byte(`{}`),
+		}).Error)
+	}
+
+	list := func(opts *internal.ListOptions) []string {
+		opts.ClusterNames = []string{"cluster-1"}
+		_, _, query, err := applyListOptionsToResourceQuery(db, db.Model(&Resource{}).Where("resource = ?", "pods"), opts)
+		require.NoError(t, err)
+		var names []string
+		require.NoError(t, query.Order("name").Pluck("name", &names).Error)
+		return names
+	}
+
+	assert.Equal(t, []string{"pod-in-owner-namespace", "pod-of-configmap", "pod-of-node"}, list(&internal.ListOptions{OwnerName: "owner"}))
+	assert.Equal(t, []string{"pod-of-configmap", "pod-of-node"}, list(&internal.ListOptions{OwnerName: "owner", Namespaces: []string{"ns-a"}}))
+	assert.Equal(t, []string{"pod-of-configmap"}, list(&internal.ListOptions{OwnerName: "owner", Namespaces: []string{"ns-a"}, OwnerNamespace: "ns-b"}))
+	assert.Equal(t, []string{"pod-in-owner-namespace", "pod-of-configmap"}, list(&internal.ListOptions{OwnerName: "owner", OwnerNamespace: "ns-b"}))
+	assert.Equal(t, []string{"pod-of-node"}, list(&internal.ListOptions{OwnerName: "owner", OwnerGroupResource: schema.GroupResource{Resource: "nodes"}}))
+	assert.Empty(t, list(&internal.ListOptions{OwnerName: "owner", OwnerNamespace: "ns-a"}))
+}
+
 func TestResourceStorage_genGetObjectQuery(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -268,7 +347,7 @@ func TestResourceStorage_genListObjectQuery(t *testing.T) {
 			postgreSQL, err := toSQL(postgresDB.Session(&gorm.Session{DryRun: true}), test.listOptions,
 				func(db *gorm.DB, options *internal.ListOptions) (*gorm.DB, error) {
 					rs := newTestResourceStorage(db, test.resource)
-					_, _, query, _, err := rs.genListObjectsQuery(context.TODO(), options)
+					_, _, query, _, err := rs.genListObjectsQuery(context.TODO(), db, options)
 					return query, err
 				},
 			)
@@ -284,7 +363,7 @@ func TestResourceStorage_genListObjectQuery(t *testing.T) {
 				mysqlSQL, err := toSQL(mysqlDBs[version].Session(&gorm.Session{DryRun: true}), test.listOptions,
 					func(db *gorm.DB, options *internal.ListOptions) (*gorm.DB, error) {
 						rs := newTestResourceStorage(db, test.resource)
-						_, _, query, _, err := rs.genListObjectsQuery(context.TODO(), options)
+						_, _, query, _, err := rs.genListObjectsQuery(context.TODO(), db, options)
 						return query, err
 					},
 				)
@@ -339,7 +418,7 @@ func TestResourceStorage_deleteObject(t *testing.T) {
 			postgreSQL := postgresDB.Session(&gorm.Session{SkipDefaultTransaction: true}).ToSQL(
 				func(tx *gorm.DB) *gorm.DB {
 					rs := newTestResourceStorage(tx, test.resource)
-					return rs.deleteObject(test.cluster, test.namespace, test.resourceName)
+					return rs.deleteObject(context.Background(), test.cluster, test.namespace, test.resourceName, nil)
 				})
 
 			if postgreSQL != test.expected.postgres {
@@ -354,7 +433,7 @@ func TestResourceStorage_deleteObject(t *testing.T) {
 				mysqlSQL := mysqlDBs[version].Session(&gorm.Session{SkipDefaultTransaction: true}).ToSQL(
 					func(tx *gorm.DB) *gorm.DB {
 						rs := newTestResourceStorage(tx, test.resource)
-						return rs.deleteObject(test.cluster, test.namespace, test.resourceName)
+						return rs.deleteObject(context.Background(), test.cluster, test.namespace, test.resourceName, nil)
 					})
 
 				if mysqlSQL != test.expected.mysql {
@@ -461,6 +540,420 @@ func TestResourceStorage_Update(t *testing.T) {
 	require.Len(resourcesAfterUpdates, 1)
 	assert.NotEmpty(resourcesAfterUpdates[0].Object)
 	assert.NotEqual(resourcesAfterUpdates[0].Object, resourcesAfterCreation[0].Object)
+
+	// the metadata column is the same as the metadata of the stored object
+	var stored struct {
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	require.NoError(json.Unmarshal(resourcesAfterUpdates[0].Object, &stored))
+	assert.JSONEq(string(stored.Metadata), string(resourcesAfterUpdates[0].Metadata))
+}
+
+func TestEncodeMetadata(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"name": "pod-1", "labels": map[string]interface{}{"app": "foo"}},
+	}}
+	metadata, err := encodeMetadata(obj, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name":"pod-1","labels":{"app":"foo"}}`, string(metadata))
+
+	metadata, err = encodeMetadata(&unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}}, nil)
+	require.NoError(t, err)
+	assert.Nil(t, metadata)
+}
+
+func TestResourceStorage_GetLatestResourceVersion(t *testing.T) {
+	require := require.New(t)
+
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(err)
+	defer cleanup()
+
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	rs := newTestResourceStorage(db, gvr)
+
+	rv, err := rs.GetLatestResourceVersion(context.Background(), "cluster-1")
+	require.NoError(err)
+	assert.Empty(t, rv)
+
+	resources := []struct {
+		cluster string
+		rv      string
+	}{
+		{"cluster-1", "9"},
+		{"cluster-1", "100"},
+		{"cluster-1", "11"},
+		{"cluster-2", "1000"},
+	}
+	for i, r := range resources {
+		require.NoError(db.Create(&Resource{
+			Cluster:         r.cluster,
+			Group:           gvr.Group,
+			Version:         gvr.Version,
+			Resource:        gvr.Resource,
+			Kind:            "Deployment",
+			Namespace:       "default",
+			Name:            fmt.Sprintf("deploy-%d", i),
+			UID:             types.UID(fmt.Sprintf("uid-%d", i)),
+			ResourceVersion: r.rv,
+			Object:          []byte("{}"),
+		}).Error)
+	}
+
+	rv, err = rs.GetLatestResourceVersion(context.Background(), "cluster-1")
+	require.NoError(err)
+	assert.Equal(t, "100", rv)
+}
+
+func TestResourceStorage_ClusterScoped(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, schema.GroupVersionResource{Version: "v1", Resource: "nodes"})
+	rs.clusterScoped = true
+	assert.False(t, rs.GetStorageConfig().Namespaced)
+	ctx := context.Background()
+
+	// the key of the cluster-scoped object has no leading slash
+	err = rs.Get(ctx, "cluster-1", "", "node-1", &unstructured.Unstructured{})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NotContains(t, err.Error(), "cluster-1//node-1")
+	assert.Contains(t, err.Error(), "cluster-1/node-1")
+
+	err = rs.Get(ctx, "cluster-1", "default", "node-1", &unstructured.Unstructured{})
+	assert.True(t, apierrors.IsBadRequest(err), err)
+	err = rs.List(ctx, &unstructured.UnstructuredList{}, &internal.ListOptions{Namespaces: []string{"default"}})
+	assert.True(t, apierrors.IsBadRequest(err), err)
+	assert.NoError(t, rs.List(ctx, &unstructured.UnstructuredList{}, &internal.ListOptions{}))
+}
+
+func TestResourceStorage_CountResources(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	rs := newTestResourceStorage(db, gvr)
+	count, err := rs.CountResources(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	for i, r := range []struct {
+		cluster string
+		gvr     schema.GroupVersionResource
+	}{
+		{"cluster-1", gvr},
+		{"cluster-1", gvr},
+		{"cluster-2", gvr},
+		{"cluster-1", appsv1.SchemeGroupVersion.WithResource("statefulsets")},
+	} {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: r.cluster, Group: r.gvr.Group, Version: r.gvr.Version, Resource: r.gvr.Resource, Kind: "Deployment",
+			Namespace: "default", Name: fmt.Sprintf("deploy-%d", i), UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1", Object: []byte("{}"),
+		}).Error)
+	}
+
+	count, err = rs.CountResources(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestResourceStorageCanceledContext(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	obj := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo", UID: "foo-uid"},
+	}
+	operations := map[string]func() error{
+		"create": func() error { return rs.Create(ctx, "cluster-1", obj) },
+		"update": func() error { return rs.Update(ctx, "cluster-1", obj) },
+		"delete": func() error { return rs.Delete(ctx, "cluster-1", obj) },
+		"get":    func() error { return rs.Get(ctx, "cluster-1", "default", "foo", &appsv1.Deployment{}) },
+		"list": func() error {
+			return rs.List(ctx, &appsv1.DeploymentList{}, &internal.ListOptions{ClusterNames: []string{"cluster-1"}})
+		},
+		"latest resource version": func() error {
+			_, err := rs.GetLatestResourceVersion(ctx, "cluster-1")
+			return err
+		},
+	}
+	for name, operation := range operations {
+		done := make(chan error, 1)
+		go func() { done <- operation() }()
+
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, context.Canceled, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s is not returned with the canceled context", name)
+		}
+	}
+}
+
+func TestResourceStorage_ListByAnnotationSelector(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	annotations := map[string]map[string]string{
+		"payments": {"example.com/team": "payments", "example.com/owner": "alice"},
+		"orders":   {"example.com/team": "orders"},
+		"none":     nil,
+	}
+	for name, annotations := range annotations {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), Annotations: annotations},
+		}))
+	}
+
+	tests := []struct {
+		selector string
+		expected []string
+	}{
+		{"example.com/team=payments", []string{"payments"}},
+		{"example.com/team", []string{"orders", "payments"}},
+		{"!example.com/owner", []string{"none", "orders"}},
+		{"example.com/team!=payments", []string{"none", "orders"}},
+	}
+	for _, test := range tests {
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{
+			OrderBy:  []internal.OrderBy{{Field: "name"}},
+			URLQuery: url.Values{URLQueryAnnotationSelector: []string{test.selector}},
+		}))
+
+		var names []string
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		assert.Equal(t, test.expected, names, test.selector)
+	}
+}
+
+func TestResourceStorage_ListByStoredVersion(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	for i, version := range []string{"v1", "v1beta1"} {
+		require.NoError(t, db.Create(&Resource{
+			Cluster: fmt.Sprintf("cluster-%d", i), Group: "apps", Version: version, Resource: "deployments", Kind: "Deployment",
+			Namespace: "default", Name: "deploy", UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1",
+			Object: []byte(fmt.Sprintf(`{"apiVersion":"apps/%s","kind":"Deployment","metadata":{"namespace":"default","name":"deploy"}}`, version)),
+		}).Error)
+	}
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	list := func(storedVersion string) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{}
+		list.SetAPIVersion("apps/v1")
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{
+			OnlyMetadata: true,
+			URLQuery:     url.Values{URLQueryStoredVersion: []string{storedVersion}},
+		}))
+		return list
+	}
+
+	// the items are stamped with the version of their own rows instead of the listObject
+	for storedVersion, expected := range map[string]string{"": "cluster-0", "v1": "cluster-0", "v1beta1": "cluster-1"} {
+		items := list(storedVersion).Items
+		require.Len(t, items, 1, storedVersion)
+
+		var resource Resource
+		require.NoError(t, db.Where("cluster = ?", expected).First(&resource).Error)
+		assert.Equal(t, "apps/"+resource.Version, items[0].GetAPIVersion(), storedVersion)
+		assert.Equal(t, "Deployment", items[0].GetKind(), storedVersion)
+	}
+
+	err = rs.List(context.Background(), &unstructured.UnstructuredList{}, &internal.ListOptions{
+		URLQuery: url.Values{URLQueryStoredVersion: []string{"V1;"}},
+	})
+	assert.True(t, apierrors.IsInvalid(err))
+}
+
+func TestResourceStorage_ListByJSONOrder(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	// the deploy-d doesn't set the replicas, and the deploy-b and deploy-e are tied
+	replicas := []string{"3", "1", "10", "", "1"}
+	for i, value := range replicas {
+		name := fmt.Sprintf("deploy-%c", 'a'+i)
+		spec := "{}"
+		if value != "" {
+			spec = `{"replicas":` + value + `}`
+		}
+		require.NoError(t, db.Create(&Resource{
+			Cluster: "cluster-1", Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+			Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1",
+			Object: []byte(fmt.Sprintf(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"namespace":"default","name":%q},"spec":%s}`, name, spec)),
+		}).Error)
+	}
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	list := func(desc bool, continueToken string) ([]string, string) {
+		list := &metav1.PartialObjectMetadataList{}
+		withContinue := true
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{
+			ListOptions:  metainternal.ListOptions{Limit: 2, Continue: continueToken},
+			OnlyMetadata: true,
+			OrderBy:      []internal.OrderBy{{Field: "spec.replicas", Desc: desc}},
+			WithContinue: &withContinue,
+		}))
+
+		var names []string
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return names, list.Continue
+	}
+
+	// the numbers are ordered by their values, the missing values are ordered last and the ties are ordered by the id
+	for desc, expected := range map[bool][]string{
+		false: {"deploy-b", "deploy-e", "deploy-a", "deploy-c", "deploy-d"},
+		true:  {"deploy-c", "deploy-a", "deploy-b", "deploy-e", "deploy-d"},
+	} {
+		var names []string
+		var continueToken string
+		for page := 0; page == 0 || continueToken != ""; page++ {
+			require.Less(t, page, len(replicas))
+			items, next := list(desc, continueToken)
+			names, continueToken = append(names, items...), next
+		}
+		assert.Equal(t, expected, names, "desc: %v", desc)
+	}
+}
+
+func TestResourceStorage_JSONOrderSQL(t *testing.T) {
+	opts := &internal.ListOptions{
+		ListOptions:  metainternal.ListOptions{Limit: 10, Continue: "20"},
+		OnlyMetadata: true,
+		OrderBy:      []internal.OrderBy{{Field: "status.readyReplicas", Desc: true}},
+	}
+	listSQL := func(db *gorm.DB) string {
+		rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+		_, _, query, result, err := rs.genListObjectsQuery(context.TODO(), db.Session(&gorm.Session{DryRun: true}), opts)
+		require.NoError(t, err)
+		require.NoError(t, result.From(query))
+		return db.Dialector.Explain(query.Statement.SQL.String(), query.Statement.Vars...)
+	}
+
+	// the json values are only ordered by, the selected columns are the metadata
+	assert.Equal(t, `SELECT "group", version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->'metadata') as metadata FROM "resources" `+
+		`WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' `+
+		`ORDER BY "object" #> '{status,readyReplicas}' IS NULL,"object" #> '{status,readyReplicas}' DESC,id LIMIT 10 OFFSET 20`, listSQL(postgresDB))
+	for version, mysqlDB := range mysqlDBs {
+		assert.Equal(t, "SELECT `group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata FROM `resources` "+
+			"WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' "+
+			"ORDER BY JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"') IS NULL,JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"') DESC,id LIMIT 10 OFFSET 20", listSQL(mysqlDB), version)
+	}
+}
+
+type recordingVisitor struct {
+	meta    *metav1.ListMeta
+	objects []runtime.Object
+}
+
+func (v *recordingVisitor) VisitListMeta(meta metav1.ListMeta) error {
+	v.meta = &meta
+	return nil
+}
+
+func (v *recordingVisitor) VisitObject(obj runtime.Object) error {
+	v.objects = append(v.objects, obj)
+	return nil
+}
+
+func TestResourceStorage_ListStream(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name)},
+		}))
+	}
+
+	withContinue, withRemainingCount := true, true
+	for _, onlyMetadata := range []bool{false, true} {
+		opts := &internal.ListOptions{
+			ListOptions:        metainternal.ListOptions{Limit: 2},
+			OrderBy:            []internal.OrderBy{{Field: "name"}},
+			WithContinue:       &withContinue,
+			WithRemainingCount: &withRemainingCount,
+			OnlyMetadata:       onlyMetadata,
+		}
+
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, rs.List(context.Background(), list, opts))
+
+		visitor := &recordingVisitor{}
+		require.NoError(t, rs.ListStream(context.Background(), func() runtime.Object { return &appsv1.Deployment{} }, opts, visitor))
+		require.NotNil(t, visitor.meta)
+		assert.Equal(t, list.Continue, visitor.meta.Continue)
+		assert.Equal(t, list.RemainingItemCount, visitor.meta.RemainingItemCount)
+
+		require.Len(t, visitor.objects, len(list.Items))
+		for i, obj := range visitor.objects {
+			assert.Equal(t, list.Items[i].Name, obj.(*appsv1.Deployment).Name)
+		}
+	}
+
+	// the continue is not returned if there are no more resources
+	opts := &internal.ListOptions{ListOptions: metainternal.ListOptions{Limit: 3}, WithContinue: &withContinue}
+	visitor := &recordingVisitor{}
+	require.NoError(t, rs.ListStream(context.Background(), func() runtime.Object { return &appsv1.Deployment{} }, opts, visitor))
+	assert.Empty(t, visitor.meta.Continue)
+	assert.Nil(t, visitor.meta.RemainingItemCount)
+	assert.Len(t, visitor.objects, 3)
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	// the deadline of the caller is kept
+	parent, parentCancel := context.WithTimeout(context.Background(), time.Hour)
+	defer parentCancel()
+	ctx, cancel = withTimeout(parent, time.Minute)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Second)
+
+	assert.Equal(t, defaultOperationTimeout, TimeoutConfig{}.timeout(0))
+	assert.Equal(t, time.Second, TimeoutConfig{Default: time.Second}.timeout(0))
+	assert.Equal(t, time.Millisecond, TimeoutConfig{Default: time.Second}.timeout(time.Millisecond))
 }
 
 func newTestResourceStorage(db *gorm.DB, storageGVK schema.GroupVersionResource) *ResourceStorage {
@@ -470,3 +963,212 @@ func newTestResourceStorage(db *gorm.DB, storageGVK schema.GroupVersionResource)
 		storageVersion:       storageGVK.GroupVersion(),
 	}
 }
+
+// BenchmarkResourceMetadataList compares the OnlyMetadata list served by the metadata column
+// with the one extracting the metadata from the objects, the number of the stored resources
+// is set by the BENCHMARK_RESOURCES env, e.g. 1000000.
+func BenchmarkResourceMetadataList(b *testing.B) {
+	count := 10000
+	if env := os.Getenv("BENCHMARK_RESOURCES"); env != "" {
+		var err error
+		if count, err = strconv.Atoi(env); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	db, cleanup, err := newSQLiteDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	spec := strings.Repeat("x", 2048)
+	resources := make([]Resource, 0, 1000)
+	for i := 0; i < count; i++ {
+		metadata := fmt.Sprintf(`{"name":"deploy-%d","namespace":"default","uid":"uid-%d","resourceVersion":"1","labels":{"app":"foo"}}`, i, i)
+		resources = append(resources, Resource{
+			Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+			Cluster: "cluster-1", Namespace: "default", Name: fmt.Sprintf("deploy-%d", i),
+			UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1",
+			Object:   []byte(fmt.Sprintf(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":%s,"spec":{"data":"%s"}}`, metadata, spec)),
+			Metadata: []byte(metadata), CreatedAt: time.Now(),
+		})
+		if len(resources) == cap(resources) || i == count-1 {
+			if err := db.CreateInBatches(resources, 100).Error; err != nil {
+				b.Fatal(err)
+			}
+			resources = resources[:0]
+		}
+	}
+
+	list := func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var metadatas ResourceMetadataList
+			if err := metadatas.From(db.Model(&Resource{}).Where(map[string]interface{}{"group": "apps", "version": "v1", "resource": "deployments"})); err != nil {
+				b.Fatal(err)
+			}
+			if len(metadatas) != count {
+				b.Fatalf("expected %d resources, got %d", count, len(metadatas))
+			}
+		}
+	}
+	b.Run("column", list)
+
+	if err := db.Model(&Resource{}).Where("1 = 1").UpdateColumn("metadata", nil).Error; err != nil {
+		b.Fatal(err)
+	}
+	b.Run("extract", list)
+}
+
+func TestResourceStorage_UndecodableRows(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1"},
+		}))
+	}
+	// the object is truncated by the partial write
+	require.NoError(t, db.Model(&Resource{}).Where("name = ?", "b").Update("object", `{"apiVersion":"apps/v1","kind":"Deploy`).Error)
+
+	counter := corruptRowsTotal.WithLabelValues("apps", "deployments")
+	before, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+
+	row := ResourceIdentity{Cluster: "cluster-1", Namespace: "default", Name: "b"}
+	assertDecodeError := func(err error) {
+		t.Helper()
+		var decodeErr *ObjectDecodeError
+		require.ErrorAs(t, err, &decodeErr)
+		assert.Equal(t, row, decodeErr.Row)
+		assert.Contains(t, err.Error(), "cluster-1/default/b")
+	}
+
+	assertDecodeError(rs.Get(context.Background(), "cluster-1", "default", "b", &appsv1.Deployment{}))
+	assertDecodeError(rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{}))
+	assertDecodeError(rs.List(context.Background(), &unstructured.UnstructuredList{}, &internal.ListOptions{}))
+
+	skip := &internal.ListOptions{URLQuery: url.Values{URLQuerySkipUndecodable: []string{"true"}}}
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, rs.List(context.Background(), list, skip))
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "a", list.Items[0].Name)
+
+	visitor := &recordingVisitor{}
+	require.NoError(t, rs.ListStream(context.Background(), func() runtime.Object { return &appsv1.Deployment{} }, skip, visitor))
+	require.Len(t, visitor.objects, 1)
+
+	after, err := testutil.GetCounterMetricValue(counter)
+	require.NoError(t, err)
+	assert.Equal(t, float64(5), after-before)
+
+	err = rs.List(context.Background(), list, &internal.ListOptions{URLQuery: url.Values{URLQuerySkipUndecodable: []string{"maybe"}}})
+	assert.True(t, apierrors.IsInvalid(err))
+}
+
+// BenchmarkResourceStorageCreate reports the allocations of the Create, including the encoding of the object.
+func BenchmarkResourceStorageCreate(b *testing.B) {
+	db, cleanup, err := newSQLiteDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	rs := newTestPodResourceStorage(b, 0)
+	rs.db = db
+	objects := newTestPodObjects(b, 1)
+	pod, err := rs.convertObject(objects[0], &v1.Pod{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rs.Create(context.Background(), fmt.Sprintf("cluster-%d", i), pod); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkResourceStorageUpdate reports the allocations of the full Update, including the encoding of the object.
+func BenchmarkResourceStorageUpdate(b *testing.B) {
+	db, cleanup, err := newSQLiteDB()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+
+	rs := newTestPodResourceStorage(b, 0)
+	rs.db = db
+	objects := newTestPodObjects(b, 1)
+	obj, err := rs.convertObject(objects[0], &v1.Pod{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	pod := obj.(*v1.Pod)
+	if err := rs.Create(context.Background(), "cluster-1", pod); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pod.ResourceVersion = strconv.Itoa(i + 2)
+		if err := rs.Update(context.Background(), "cluster-1", pod); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResourceStorage_DeleteWithPreconditions(t *testing.T) {
+	for _, withReferences := range []bool{false, true} {
+		t.Run(fmt.Sprintf("references %t", withReferences), func(t *testing.T) {
+			db, cleanup, err := newSQLiteDB()
+			require.NoError(t, err)
+			defer cleanup()
+			require.NoError(t, db.AutoMigrate(&ResourceReference{}))
+
+			rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+			config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+			require.NoError(t, err)
+			rs.codec = config.Codec
+			rs.referencesEnabled = withReferences
+
+			deploy := &appsv1.Deployment{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", UID: "uid-1", ResourceVersion: "1"},
+			}
+			require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+
+			// the object is recreated with another uid
+			err = rs.DeleteWithPreconditions(context.Background(), "cluster-1", "default", "deploy-1", metav1.NewUIDPreconditions("uid-0"))
+			assert.True(t, apierrors.IsConflict(err), err)
+			assert.ErrorIs(t, err, ErrConflict)
+
+			resourceVersion := "2"
+			err = rs.DeleteWithPreconditions(context.Background(), "cluster-1", "default", "deploy-1",
+				&metav1.Preconditions{UID: &deploy.UID, ResourceVersion: &resourceVersion})
+			assert.True(t, apierrors.IsConflict(err), err)
+			require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{}))
+
+			resourceVersion = "1"
+			require.NoError(t, rs.DeleteWithPreconditions(context.Background(), "cluster-1", "default", "deploy-1",
+				&metav1.Preconditions{UID: &deploy.UID, ResourceVersion: &resourceVersion}))
+			err = rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &appsv1.Deployment{})
+			assert.ErrorIs(t, err, ErrNotFound)
+
+			err = rs.DeleteWithPreconditions(context.Background(), "cluster-1", "default", "deploy-1", metav1.NewUIDPreconditions("uid-1"))
+			assert.True(t, apierrors.IsNotFound(err), err)
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
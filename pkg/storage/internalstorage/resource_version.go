@@ -0,0 +1,75 @@
+package internalstorage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// resourceVersionColumnSize is the width of the resource_version columns migrated by the migration 4.
+	resourceVersionColumnSize = 255
+
+	// minResourceVersionMaxLength is the width of the resource_version columns before the migration 4.
+	minResourceVersionMaxLength = 30
+
+	// hashedResourceVersionPrefix marks the resource version that is hashed since it exceeds the max length.
+	hashedResourceVersionPrefix = "sha256:"
+)
+
+func init() {
+	registerMigration(migration{
+		version: 4,
+		name:    "widen the resource_version columns",
+		// widening the varchar may copy the table on mysql
+		additive: false,
+		migrate: func(db *gorm.DB) error {
+			for _, table := range []string{"resources", "checkpoints"} {
+				var ddl string
+				switch db.Dialector.Name() {
+				case "mysql":
+					ddl = fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN `resource_version` varchar(%d) NOT NULL", table, resourceVersionColumnSize)
+				case "postgres":
+					ddl = fmt.Sprintf(`ALTER TABLE "%s" ALTER COLUMN "resource_version" TYPE varchar(%d)`, table, resourceVersionColumnSize)
+				default:
+					// sqlite doesn't enforce the length of the varchar
+					return nil
+				}
+				if err := db.Exec(ddl).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// storedResourceVersion returns the resource version stored in the resource_version column, the resource version
+// longer than the max length is replaced by its hash, e.g. the opaque resource version of the aggregated apiserver.
+//
+// The numeric resource versions are never hashed, since they are no longer than the min of the max length,
+// so they are still compared numerically.
+func storedResourceVersion(rv string, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = resourceVersionColumnSize
+	}
+	if len(rv) <= maxLength {
+		return rv
+	}
+
+	sum := sha256.Sum256([]byte(rv))
+	hashed := hashedResourceVersionPrefix + hex.EncodeToString(sum[:])
+	if len(hashed) > maxLength {
+		hashed = hashed[:maxLength]
+	}
+	return hashed
+}
+
+// isHashedResourceVersion returns true if the stored resource version may be hashed,
+// it can't be used to resume the watch.
+func isHashedResourceVersion(rv string) bool {
+	return strings.HasPrefix(rv, hashedResourceVersionPrefix)
+}
@@ -0,0 +1,97 @@
+package internalstorage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestStoredResourceVersion(t *testing.T) {
+	opaque := strings.Repeat("a", 64)
+
+	assert.Equal(t, "18446744073709551615", storedResourceVersion("18446744073709551615", minResourceVersionMaxLength))
+	assert.Equal(t, opaque, storedResourceVersion(opaque, 0))
+
+	hashed := storedResourceVersion(opaque, minResourceVersionMaxLength)
+	assert.Len(t, hashed, minResourceVersionMaxLength)
+	assert.True(t, isHashedResourceVersion(hashed))
+	assert.Equal(t, hashed, storedResourceVersion(opaque, minResourceVersionMaxLength))
+
+	hashed = storedResourceVersion(strings.Repeat("a", 300), 0)
+	assert.Len(t, hashed, len(hashedResourceVersionPrefix)+64)
+	assert.False(t, isHashedResourceVersion("1"))
+
+	for _, maxLength := range []int{-1, 29, 256} {
+		_, err := (&Config{ResourceVersionMaxLength: maxLength}).resourceVersionMaxLength()
+		assert.Error(t, err)
+	}
+}
+
+func TestWidenResourceVersionMigration(t *testing.T) {
+	m := migrations[4]
+	assert.False(t, m.additive)
+
+	ddl, err := dryRunMigration(postgresDB, m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ALTER TABLE "resources" ALTER COLUMN "resource_version" TYPE varchar(255)`,
+		`ALTER TABLE "checkpoints" ALTER COLUMN "resource_version" TYPE varchar(255)`,
+	}, ddl)
+
+	for version, db := range mysqlDBs {
+		ddl, err := dryRunMigration(db, m)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"ALTER TABLE `resources` MODIFY COLUMN `resource_version` varchar(255) NOT NULL",
+			"ALTER TABLE `checkpoints` MODIFY COLUMN `resource_version` varchar(255) NOT NULL",
+		}, ddl, version)
+	}
+}
+
+func TestResourceStorageLongResourceVersion(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+
+	opaque := strings.Repeat("a", 64)
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: opaque},
+	}
+	storedResourceVersionOf := func(db *gorm.DB) string {
+		var resource Resource
+		require.NoError(t, db.Where("name = ?", "deploy-1").First(&resource).Error)
+		return resource.ResourceVersion
+	}
+
+	// the 64-character resource version fits the widened column
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	rs.codec = config.Codec
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	assert.Equal(t, opaque, storedResourceVersionOf(db))
+
+	// the resource version is hashed if it exceeds the configured max length, e.g. the column isn't widened yet
+	rs.resourceVersionMaxLength = minResourceVersionMaxLength
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+	assert.Len(t, storedResourceVersionOf(db), minResourceVersionMaxLength)
+
+	obj := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", obj))
+	assert.Equal(t, opaque, obj.ResourceVersion)
+
+	rv, err := rs.GetLatestResourceVersion(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Empty(t, rv)
+}
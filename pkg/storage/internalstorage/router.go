@@ -0,0 +1,145 @@
+package internalstorage
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// DefaultDatabaseName is the name of the database configured at the top level of the config,
+// the resources that don't match any route are stored in it.
+const DefaultDatabaseName = "default"
+
+// databaseRouter routes the resources to the databases by the group and resource,
+// the first matched route wins.
+type databaseRouter struct {
+	databases map[string]*gorm.DB
+	routes    []RouteConfig
+}
+
+func newDatabaseRouter(defaultDB *gorm.DB, databases map[string]*gorm.DB, routes []RouteConfig) (*databaseRouter, error) {
+	router := &databaseRouter{
+		databases: map[string]*gorm.DB{DefaultDatabaseName: defaultDB},
+		routes:    routes,
+	}
+	for name, db := range databases {
+		if name == DefaultDatabaseName {
+			return nil, fmt.Errorf("database name %q is reserved", DefaultDatabaseName)
+		}
+		router.databases[name] = db
+	}
+
+	for _, route := range routes {
+		if _, ok := router.databases[route.Database]; !ok {
+			return nil, fmt.Errorf("route %s/%s: database %q is not configured", route.Group, route.Resource, route.Database)
+		}
+		if _, err := path.Match(route.Group, ""); err != nil {
+			return nil, fmt.Errorf("route group pattern %q: %w", route.Group, err)
+		}
+		if _, err := path.Match(route.Resource, ""); err != nil {
+			return nil, fmt.Errorf("route resource pattern %q: %w", route.Resource, err)
+		}
+	}
+	return router, nil
+}
+
+// matchGroup matches the group, the empty pattern matches the core group.
+func matchGroup(pattern, group string) bool {
+	matched, _ := path.Match(pattern, group)
+	return matched
+}
+
+// matchResource matches the resource, the empty pattern matches all resources.
+func matchResource(pattern, resource string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, _ := path.Match(pattern, resource)
+	return matched
+}
+
+// databaseName returns the name of the database that stores the resource.
+func (r *databaseRouter) databaseName(gr schema.GroupResource) string {
+	for _, route := range r.routes {
+		if matchGroup(route.Group, gr.Group) && matchResource(route.Resource, gr.Resource) {
+			return route.Database
+		}
+	}
+	return DefaultDatabaseName
+}
+
+func (r *databaseRouter) database(gr schema.GroupResource) *gorm.DB {
+	return r.databases[r.databaseName(gr)]
+}
+
+// databaseNamesOfGroup returns the names of the databases that store the resources of the group.
+func (r *databaseRouter) databaseNamesOfGroup(group string) sets.Set[string] {
+	names := sets.New[string]()
+	for _, route := range r.routes {
+		if !matchGroup(route.Group, group) {
+			continue
+		}
+
+		names.Insert(route.Database)
+		if route.Resource == "" || route.Resource == "*" {
+			// all resources of the group are matched by this route
+			return names
+		}
+	}
+	return names.Insert(DefaultDatabaseName)
+}
+
+// collectionDatabase returns the database that stores all the resource types of the collection resource,
+// the list across the databases is not supported.
+func (r *databaseRouter) collectionDatabase(types []internal.CollectionResourceType) (*gorm.DB, error) {
+	names := sets.New[string]()
+	for _, rt := range types {
+		if rt.Resource == "" {
+			names = names.Union(r.databaseNamesOfGroup(rt.Group))
+			continue
+		}
+		names.Insert(r.databaseName(schema.GroupResource{Group: rt.Group, Resource: rt.Resource}))
+	}
+	return r.singleDatabase(names)
+}
+
+func (r *databaseRouter) singleDatabase(names sets.Set[string]) (*gorm.DB, error) {
+	if names.Len() > 1 {
+		return nil, fmt.Errorf("the resources are stored in multiple databases %v, the list across the databases is not supported", sets.List(names))
+	}
+	if names.Len() == 0 {
+		return r.databases[DefaultDatabaseName], nil
+	}
+	return r.databases[sets.List(names)[0]], nil
+}
+
+func (r *databaseRouter) names() []string {
+	names := make([]string, 0, len(r.databases))
+	for name := range r.databases {
+		names = append(names, name)
+	}
+	return names
+}
+
+// all returns all databases, the default database is the first.
+func (r *databaseRouter) all() []*gorm.DB {
+	names := make([]string, 0, len(r.databases))
+	for name := range r.databases {
+		if name != DefaultDatabaseName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	dbs := []*gorm.DB{r.databases[DefaultDatabaseName]}
+	for _, name := range names {
+		dbs = append(dbs, r.databases[name])
+	}
+	return dbs
+}
@@ -0,0 +1,137 @@
+package internalstorage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+func TestDatabaseRouter(t *testing.T) {
+	router, err := newDatabaseRouter(nil, map[string]*gorm.DB{"events": nil, "pods": nil}, []RouteConfig{
+		{Group: "", Resource: "events", Database: "events"},
+		{Group: "events.k8s.io", Database: "events"},
+		{Group: "", Resource: "pod*", Database: "pods"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		gr       schema.GroupResource
+		database string
+	}{
+		{schema.GroupResource{Resource: "events"}, "events"},
+		{schema.GroupResource{Group: "events.k8s.io", Resource: "events"}, "events"},
+		{schema.GroupResource{Resource: "pods"}, "pods"},
+		{schema.GroupResource{Resource: "configmaps"}, DefaultDatabaseName},
+		{schema.GroupResource{Group: "apps", Resource: "deployments"}, DefaultDatabaseName},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.database, router.databaseName(tt.gr), tt.gr.String())
+	}
+
+	assert.Equal(t, sets.New("events", "pods", DefaultDatabaseName), router.databaseNamesOfGroup(""))
+	assert.Equal(t, sets.New("events"), router.databaseNamesOfGroup("events.k8s.io"))
+	assert.Equal(t, sets.New(DefaultDatabaseName), router.databaseNamesOfGroup("apps"))
+
+	_, err = router.collectionDatabase([]internal.CollectionResourceType{{Group: "apps", Resource: "deployments"}, {Group: "batch"}})
+	assert.NoError(t, err)
+
+	_, err = router.collectionDatabase([]internal.CollectionResourceType{{Group: "apps"}, {Group: "", Resource: "pods"}})
+	assert.Error(t, err)
+}
+
+func TestNewDatabaseRouterValidation(t *testing.T) {
+	_, err := newDatabaseRouter(nil, map[string]*gorm.DB{DefaultDatabaseName: nil}, nil)
+	assert.Error(t, err)
+
+	_, err = newDatabaseRouter(nil, nil, []RouteConfig{{Resource: "events", Database: "events"}})
+	assert.Error(t, err)
+
+	_, err = newDatabaseRouter(nil, map[string]*gorm.DB{"events": nil}, []RouteConfig{{Resource: "[", Database: "events"}})
+	assert.Error(t, err)
+}
+
+func TestStorageFactoryRouting(t *testing.T) {
+	dir := t.TempDir()
+	config := `
+type: sqlite
+dsn: ` + filepath.Join(dir, "default.db") + `
+databases:
+  events:
+    type: sqlite
+    dsn: ` + filepath.Join(dir, "events.db") + `
+routes:
+- resource: events
+  database: events
+middlewares: []
+`
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(config), 0o600))
+
+	sf, err := NewStorageFactory(configPath)
+	require.NoError(t, err)
+	factory := sf.(*StorageFactory)
+
+	newStorage := func(gvr schema.GroupVersionResource) storage.ResourceStorage {
+		rs, err := factory.NewResourceStorage(&storage.ResourceStorageConfig{
+			StorageGroupResource: gvr.GroupResource(),
+			StorageVersion:       gvr.GroupVersion(),
+			MemoryVersion:        gvr.GroupVersion(),
+		})
+		require.NoError(t, err)
+		return rs
+	}
+	events := newStorage(corev1.SchemeGroupVersion.WithResource("events"))
+	pods := newStorage(corev1.SchemeGroupVersion.WithResource("pods"))
+	assert.NotSame(t, events.(*ResourceStorage).db, pods.(*ResourceStorage).db)
+	assert.Same(t, factory.db, pods.(*ResourceStorage).db)
+
+	// the tables are migrated on every database
+	for _, db := range factory.databases() {
+		assert.True(t, db.Migrator().HasTable(&Resource{}))
+	}
+
+	for _, name := range []string{"pod-1", "event-1"} {
+		gr := schema.GroupResource{Resource: "pods"}
+		if name == "event-1" {
+			gr.Resource = "events"
+		}
+		require.NoError(t, factory.resourceDB(gr).Create(&Resource{
+			Cluster: "cluster-1", Version: "v1", Resource: gr.Resource, Kind: "Kind",
+			Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: "1", Object: []byte("{}"),
+		}).Error)
+	}
+
+	rvs, err := factory.GetResourceVersions(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Len(t, rvs, 2)
+
+	_, err = factory.NewCollectionResourceStorage(&internal.CollectionResource{
+		ObjectMeta:    metav1.ObjectMeta{Name: CollectionResourceKubeResources},
+		ResourceTypes: []internal.CollectionResourceType{{Group: ""}, {Group: "apps"}},
+	})
+	assert.Error(t, err)
+
+	_, err = factory.NewCollectionResourceStorage(&internal.CollectionResource{
+		ObjectMeta:    metav1.ObjectMeta{Name: CollectionResourceWorkloads},
+		ResourceTypes: []internal.CollectionResourceType{{Group: "apps", Resource: "deployments"}},
+	})
+	assert.NoError(t, err)
+
+	require.NoError(t, factory.CleanCluster(context.Background(), "cluster-1"))
+	rvs, err = factory.GetResourceVersions(context.Background(), "cluster-1")
+	require.NoError(t, err)
+	assert.Empty(t, rvs)
+}
@@ -0,0 +1,133 @@
+package internalstorage
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secondaryRows are the rows of the labels and the references of the object, they are maintained with the row of the resource
+// in the same transactions if the labels table or the references table is maintained.
+type secondaryRows struct {
+	withLabels bool
+	labels     map[string]string
+
+	withReferences bool
+	references     []ResourceReference
+}
+
+// secondaryRows returns the secondary rows of the encoded object, it returns nil if neither of the tables is maintained.
+func (s *ResourceStorage) secondaryRows(metaobj metav1.Object, encoded []byte) (*secondaryRows, error) {
+	rows := &secondaryRows{withLabels: s.labelSelectorMode == LabelSelectorTable, withReferences: s.referencesEnabled}
+	if !rows.withLabels && !rows.withReferences {
+		return nil, nil
+	}
+	rows.labels = metaobj.GetLabels()
+	if rows.withReferences {
+		references, err := extractReferences(encoded, metaobj.GetNamespace(), s.referenceRules)
+		if err != nil {
+			return nil, err
+		}
+		rows.references = references
+	}
+	return rows, nil
+}
+
+func (r *secondaryRows) insert(tx *gorm.DB, id uint) error {
+	if r.withLabels {
+		if err := insertLabels(tx, id, r.labels); err != nil {
+			return err
+		}
+	}
+	if r.withReferences {
+		return insertReferences(tx, id, r.references)
+	}
+	return nil
+}
+
+func (r *secondaryRows) replace(tx *gorm.DB, id uint) error {
+	if r.withLabels {
+		if err := replaceLabels(tx, id, r.labels); err != nil {
+			return err
+		}
+	}
+	if r.withReferences {
+		return replaceReferences(tx, id, r.references)
+	}
+	return nil
+}
+
+// createWithSecondaryRows inserts the row of the resource and its secondary rows in a transaction.
+func (s *ResourceStorage) createWithSecondaryRows(ctx context.Context, resource *Resource, rows *secondaryRows) (int64, error) {
+	var rowsAffected int64
+	err := s.writeDB(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Omit(s.missingColumns()...).Create(resource)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+		return rows.insert(tx, resource.ID)
+	})
+	return rowsAffected, err
+}
+
+// fullUpdateWithSecondaryRows writes the updated columns of the object and replaces its secondary rows in a transaction,
+// the prepared statements can't be executed in the transaction.
+func (s *ResourceStorage) fullUpdateWithSecondaryRows(ctx context.Context, cluster string, namespace, name string, updatedResource map[string]interface{}, rows *secondaryRows) (int64, error) {
+	var rowsAffected int64
+	err := s.writeDB(ctx).Transaction(func(tx *gorm.DB) error {
+		result := s.objectQuery(tx, cluster, namespace, name).Updates(updatedResource)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		rowsAffected = result.RowsAffected
+
+		var ids []uint
+		if err := s.objectQuery(tx, cluster, namespace, name).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := rows.replace(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return rowsAffected, err
+}
+
+// deleteWithSecondaryRows deletes the row of the object and its secondary rows in a transaction.
+func (s *ResourceStorage) deleteWithSecondaryRows(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) (int64, error) {
+	var rowsAffected int64
+	err := s.writeDB(ctx).Transaction(func(tx *gorm.DB) error {
+		ids := s.queryPreconditions(s.objectQuery(tx, cluster, namespace, name), preconditions).Select("id")
+		if s.labelSelectorMode == LabelSelectorTable {
+			if err := tx.Where("resource_id IN (?)", ids).Delete(&ResourceLabel{}).Error; err != nil {
+				return err
+			}
+		}
+		if s.referencesEnabled {
+			if err := tx.Where("resource_id IN (?)", ids).Delete(&ResourceReference{}).Error; err != nil {
+				return err
+			}
+		}
+		result := s.queryPreconditions(s.objectQuery(tx, cluster, namespace, name), preconditions).Delete(&Resource{})
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	return rowsAffected, err
+}
+
+// deleteSecondaryRows deletes the secondary rows of the resources matched by the conditions, e.g. the resources of the cleaned cluster.
+func (s *StorageFactory) deleteSecondaryRows(db *gorm.DB, conds map[string]interface{}) error {
+	if s.labelSelectorMode == LabelSelectorTable {
+		if err := deleteResourceLabels(db, conds); err != nil {
+			return err
+		}
+	}
+	if s.references != nil {
+		return deleteResourceReferences(db, conds)
+	}
+	return nil
+}
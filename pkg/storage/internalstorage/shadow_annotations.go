@@ -0,0 +1,77 @@
+package internalstorage
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils"
+)
+
+// ShadowAnnotation is the name of the shadow annotation computed from the row of the object,
+// which is injected into the objects returned by the gets, lists and watches.
+type ShadowAnnotation string
+
+const (
+	// ShadowAnnotationClusterName injects the cluster of the row by the shadow.clusterpedia.io/cluster-name.
+	ShadowAnnotationClusterName ShadowAnnotation = "cluster-name"
+
+	// ShadowAnnotationGVR injects the stored group, version and resource of the row by the shadow.clusterpedia.io/gvr,
+	// e.g. apps/v1/deployments, or v1/pods for the core group.
+	ShadowAnnotationGVR ShadowAnnotation = "gvr"
+)
+
+var defaultShadowAnnotations = shadowAnnotations{ShadowAnnotationClusterName}
+
+// shadowAnnotations are the shadow annotations injected into the returned objects, nothing is injected if it is empty.
+type shadowAnnotations []ShadowAnnotation
+
+func newShadowAnnotations(config []ShadowAnnotation) (shadowAnnotations, error) {
+	if config == nil {
+		return defaultShadowAnnotations, nil
+	}
+
+	annotations := make(shadowAnnotations, 0, len(config))
+	seen := make(map[ShadowAnnotation]bool, len(config))
+	for _, annotation := range config {
+		switch annotation {
+		case ShadowAnnotationClusterName, ShadowAnnotationGVR:
+		default:
+			return nil, fmt.Errorf("shadowAnnotations must be in [cluster-name, gvr], got %q", annotation)
+		}
+		if seen[annotation] {
+			return nil, fmt.Errorf("shadowAnnotations: %s is duplicated", annotation)
+		}
+		seen[annotation] = true
+		annotations = append(annotations, annotation)
+	}
+	return annotations, nil
+}
+
+// inject injects the shadow annotations of the row into the object, the annotations whose values are unknown,
+// e.g. the cluster of the rows listed without the identities, are left as stored.
+func (annotations shadowAnnotations) inject(obj runtime.Object, cluster string, gvr schema.GroupVersionResource) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	shadows := make(map[string]string, len(annotations))
+	for _, annotation := range annotations {
+		switch annotation {
+		case ShadowAnnotationClusterName:
+			if cluster != "" {
+				shadows[internal.ShadowAnnotationClusterName] = cluster
+			}
+		case ShadowAnnotationGVR:
+			if !gvr.Empty() {
+				shadows[internal.ShadowAnnotationGroupVersionResource] = gvr.GroupVersion().String() + "/" + gvr.Resource
+			}
+		}
+	}
+	if len(shadows) == 0 {
+		return nil
+	}
+	return utils.InjectShadowAnnotations(obj, shadows)
+}
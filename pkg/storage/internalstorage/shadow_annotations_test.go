@@ -0,0 +1,104 @@
+package internalstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestNewShadowAnnotations(t *testing.T) {
+	annotations, err := newShadowAnnotations(nil)
+	require.NoError(t, err)
+	assert.Equal(t, shadowAnnotations{ShadowAnnotationClusterName}, annotations)
+
+	annotations, err = newShadowAnnotations([]ShadowAnnotation{})
+	require.NoError(t, err)
+	assert.Empty(t, annotations)
+
+	_, err = newShadowAnnotations([]ShadowAnnotation{"events"})
+	assert.Error(t, err)
+	_, err = newShadowAnnotations([]ShadowAnnotation{ShadowAnnotationGVR, ShadowAnnotationGVR})
+	assert.Error(t, err)
+}
+
+func TestResourceStorageShadowAnnotations(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newPreparedTestResourceStorage(t, db, false)
+	rs.shadowAnnotations = shadowAnnotations{ShadowAnnotationClusterName, ShadowAnnotationGVR}
+	rs.hub = newWatchHub(WatchHubConfig{Enabled: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{})
+	require.NoError(t, err)
+
+	// the stored object has no shadow annotations
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1", Annotations: map[string]string{"app": "nginx"}},
+	}))
+	expected := map[string]string{
+		"app":                                "nginx",
+		internal.ShadowAnnotationClusterName: "cluster-1",
+		internal.ShadowAnnotationGroupVersionResource: "apps/v1/deployments",
+	}
+
+	t.Run("typed get", func(t *testing.T) {
+		deploy := &appsv1.Deployment{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", deploy))
+		assert.Equal(t, expected, deploy.Annotations)
+	})
+
+	t.Run("partial object metadata get", func(t *testing.T) {
+		partial := &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", partial))
+		assert.Equal(t, expected, partial.Annotations)
+
+		partial = &metav1.PartialObjectMetadata{}
+		require.NoError(t, rs.GetMetadata(context.Background(), "cluster-1", "default", "deploy-1", partial))
+		assert.Equal(t, expected, partial.Annotations)
+	})
+
+	t.Run("lists", func(t *testing.T) {
+		typed := &appsv1.DeploymentList{}
+		require.NoError(t, rs.List(context.Background(), typed, &internal.ListOptions{}))
+		require.Len(t, typed.Items, 1)
+		assert.Equal(t, expected, typed.Items[0].Annotations)
+
+		list := &unstructured.UnstructuredList{}
+		require.NoError(t, rs.List(context.Background(), list, &internal.ListOptions{}))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, expected, list.Items[0].GetAnnotations())
+
+		partial := &metav1.PartialObjectMetadataList{}
+		require.NoError(t, rs.List(context.Background(), partial, &internal.ListOptions{}))
+		require.Len(t, partial.Items, 1)
+		assert.Equal(t, expected, partial.Items[0].Annotations)
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		select {
+		case event := <-watcher.ResultChan():
+			assert.Equal(t, expected, event.Object.(metav1.Object).GetAnnotations())
+		case <-time.After(5 * time.Second):
+			t.Fatal("the event is not received")
+		}
+	})
+
+	// nothing is injected without the shadow annotations
+	rs.shadowAnnotations = nil
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", deploy))
+	assert.Equal(t, map[string]string{"app": "nginx"}, deploy.Annotations)
+}
@@ -0,0 +1,172 @@
+package internalstorage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// splitObjectsSetting is the setting of the queries of the resources whose objects are split,
+// the json queries of the spec and status are built from the spec and status columns by the querybuilder.
+const splitObjectsSetting = querybuilder.SplitObjectsSetting
+
+func init() {
+	registerMigration(migration{
+		version:  8,
+		name:     "add the spec and status columns to the resources table",
+		additive: true,
+		migrate: func(db *gorm.DB) error {
+			if err := addColumnIfNotExists(db, &Resource{}, "Spec"); err != nil {
+				return err
+			}
+			return addColumnIfNotExists(db, &Resource{}, "Status")
+		},
+	})
+}
+
+// SplitObjectConfig stores the spec and status of the objects of the resource in the spec and status columns,
+// so the sql can compare the values of the spec and status without extracting them from the whole object,
+// e.g. `compareFields=spec.replicas!=status.readyReplicas`. The objects are reassembled when they are read.
+//
+// The objects of the encrypted resources aren't split.
+type SplitObjectConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+}
+
+func newSplitObjects(configs []SplitObjectConfig) (map[schema.GroupResource]bool, error) {
+	splitObjects := make(map[schema.GroupResource]bool, len(configs))
+	for _, config := range configs {
+		if config.Resource == "" {
+			return nil, errors.New("split object: resource is required")
+		}
+		splitObjects[schema.GroupResource{Group: config.Group, Resource: config.Resource}] = true
+	}
+	return splitObjects, nil
+}
+
+// checkSplitColumns records the database without the spec and status columns, whose objects aren't split.
+func (s *StorageFactory) checkSplitColumns(name string, db *gorm.DB) {
+	if db.Migrator().HasColumn(&Resource{}, "Spec") && db.Migrator().HasColumn(&Resource{}, "Status") {
+		return
+	}
+
+	if len(s.splitObjects) != 0 {
+		klog.InfoS("The spec and status columns don't exist, the objects aren't split until the migration 8 is applied", "database", name)
+	}
+	if s.splitColumnsMissing == nil {
+		s.splitColumnsMissing = make(map[*gorm.DB]bool)
+	}
+	s.splitColumnsMissing[db] = true
+}
+
+// splitObject splits the spec and status from the encoded object, the other fields of the object are kept in order.
+func splitObject(encoded []byte) (object, spec, status datatypes.JSON, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	if token, err := decoder.Token(); err != nil {
+		return nil, nil, nil, err
+	} else if token != json.Delim('{') {
+		return nil, nil, nil, errors.New("the encoded object isn't a json object")
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("unexpected token %v of the encoded object", token)
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, nil, err
+		}
+
+		switch key {
+		case "spec":
+			spec = datatypes.JSON(value)
+		case "status":
+			status = datatypes.JSON(value)
+		default:
+			if buffer.Len() > 1 {
+				buffer.WriteByte(',')
+			}
+			encodedKey, err := json.Marshal(key)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			buffer.Write(encodedKey)
+			buffer.WriteByte(':')
+			buffer.Write(value)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, nil, nil, err
+	}
+	// the trailing newline of the encoded object is kept
+	buffer.WriteByte('}')
+	buffer.Write(encoded[decoder.InputOffset():])
+	return buffer.Bytes(), spec, status, nil
+}
+
+// assembleObject appends the spec and status to the end of the object, which is where they are encoded
+// by both the typed and the unstructured objects, so the assembled object is the same as the encoded one.
+func assembleObject(object, spec, status []byte) []byte {
+	if len(spec) == 0 && len(status) == 0 {
+		return object
+	}
+	end := bytes.LastIndexByte(object, '}')
+	if end < 0 {
+		return object
+	}
+
+	assembled := make([]byte, 0, len(object)+len(spec)+len(status)+len(`,"spec":,"status":`))
+	assembled = append(assembled, object[:end]...)
+	empty := bytes.Equal(bytes.TrimSpace(object[:end]), []byte("{"))
+	for _, field := range []struct {
+		key   string
+		value []byte
+	}{{`"spec":`, spec}, {`"status":`, status}} {
+		if len(field.value) == 0 {
+			continue
+		}
+		if !empty {
+			assembled = append(assembled, ',')
+		}
+		assembled = append(append(assembled, field.key...), field.value...)
+		empty = false
+	}
+	return append(assembled, object[end:]...)
+}
+
+// assembleSplitObject reassembles the split object of the row, the object which isn't split is returned as is.
+func assembleSplitObject(object Object) Object {
+	switch o := object.(type) {
+	case Resource:
+		o.Object = assembleObject(o.Object, o.Spec, o.Status)
+		return o
+	case ResourceBytes:
+		o.Object = assembleObject(o.Object, o.Spec, o.Status)
+		return o
+	}
+	return object
+}
+
+// querySplitObjects sets the query to build the json queries of the spec and status from the spec and status columns.
+func querySplitObjects(query *gorm.DB, split bool) *gorm.DB {
+	if !split {
+		return query
+	}
+	return query.Set(splitObjectsSetting, true)
+}
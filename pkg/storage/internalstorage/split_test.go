@@ -0,0 +1,242 @@
+package internalstorage
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/json"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/api/clusterpedia/fields"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newTestDeployment(name string, replicas int32, status *appsv1.DeploymentStatus) *appsv1.Deployment {
+	deploy := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name), ResourceVersion: "1"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	if status != nil {
+		deploy.Status = *status
+	}
+	return deploy
+}
+
+func TestSplitObject(t *testing.T) {
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+
+	var typed bytes.Buffer
+	require.NoError(t, config.Codec.Encode(newTestDeployment("a", 3, &appsv1.DeploymentStatus{ReadyReplicas: 1}), &typed))
+
+	unstructuredObj := &unstructured.Unstructured{}
+	require.NoError(t, json.Unmarshal(typed.Bytes(), &unstructuredObj.Object))
+	var unstructuredEncoded bytes.Buffer
+	require.NoError(t, config.Codec.Encode(unstructuredObj, &unstructuredEncoded))
+
+	for name, encoded := range map[string][]byte{
+		"typed":        typed.Bytes(),
+		"unstructured": unstructuredEncoded.Bytes(),
+		"only status":  []byte(`{"kind":"Pod","metadata":{"name":"a"},"status":{"phase":"Running"}}` + "\n"),
+		"no spec":      []byte(`{"kind":"ConfigMap","data":{"spec":"a"}}`),
+		"only spec":    []byte(`{"spec":{"replicas":1}}`),
+	} {
+		object, spec, status, err := splitObject(encoded)
+		require.NoError(t, err, name)
+		assert.NotContains(t, string(object), `"spec":{`, name)
+		assert.NotContains(t, string(object), `"status":{`, name)
+		assert.Equal(t, encoded, assembleObject(object, spec, status), name)
+	}
+
+	object, spec, status, err := splitObject(typed.Bytes())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"replicas":3,"selector":null,"template":{"metadata":{"creationTimestamp":null},"spec":{"containers":null}},"strategy":{}}`, string(spec))
+	assert.JSONEq(t, `{"readyReplicas":1}`, string(status))
+	assert.NotContains(t, string(object), "replicas")
+
+	for _, encoded := range []string{`[]`, `{"kind":`, `"enc:aesgcm:key-1:a"`} {
+		_, _, _, err := splitObject([]byte(encoded))
+		assert.Error(t, err, encoded)
+	}
+}
+
+func TestApplyListOptionsToQuery_CompareFields(t *testing.T) {
+	tests := []struct {
+		name          string
+		compareFields []string
+
+		expected expected
+	}{
+		{
+			"not equal",
+			[]string{"spec.replicas!=status.readyReplicas"},
+			expected{
+				`SELECT * FROM "resources" WHERE "object" #> '{spec,replicas}' IS DISTINCT FROM "object" #> '{status,readyReplicas}'`,
+				"SELECT * FROM `resources` WHERE NOT (JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"') <=> JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"'))",
+				"",
+			},
+		},
+		{
+			"equal and less than",
+			[]string{"spec.replicas == status.replicas", "status.readyReplicas<status.replicas"},
+			expected{
+				`SELECT * FROM "resources" WHERE "object" #> '{spec,replicas}' IS NOT DISTINCT FROM "object" #> '{status,replicas}' AND "object" #> '{status,readyReplicas}' < "object" #> '{status,replicas}'`,
+				"SELECT * FROM `resources` WHERE JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"') <=> JSON_EXTRACT(`object`,'$.\"status\".\"replicas\"') AND JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"') < JSON_EXTRACT(`object`,'$.\"status\".\"replicas\"')",
+				"",
+			},
+		},
+		{
+			"quoted key",
+			[]string{"metadata.annotations['a=b']>=spec.replicas"},
+			expected{
+				`SELECT * FROM "resources" WHERE "object" #> '{metadata,annotations,a=b}' >= "object" #> '{spec,replicas}'`,
+				"SELECT * FROM `resources` WHERE JSON_EXTRACT(`object`,'$.\"metadata\".\"annotations\".\"a=b\"') >= JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"')",
+				"",
+			},
+		},
+		{
+			"without operator",
+			[]string{"spec.replicas"},
+			expected{
+				"",
+				"",
+				`ListOptions.clusterpedia.io "urlQuery" is invalid: compareFields[0]: Invalid value: "spec.replicas": the operator is required, one of ==, =, !=, <, <=, > and >=`,
+			},
+		},
+		{
+			"invalid path",
+			[]string{"spec.replicas!=status."},
+			expected{
+				"",
+				"",
+				`ListOptions.clusterpedia.io "urlQuery" is invalid: compareFields[0]: Invalid value: "spec.replicas!=status.": invalid path "status.": empty key`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		listOptions := &internal.ListOptions{URLQuery: url.Values{URLQueryCompareFields: test.compareFields}}
+		testApplyListOptionsToQuery(t, test.name, listOptions, test.expected)
+	}
+}
+
+func TestApplyListOptionsToQuery_SplitObjects(t *testing.T) {
+	selector, err := fields.Parse("metadata.name=a,spec.replicas=3")
+	require.NoError(t, err)
+	listOptions := &internal.ListOptions{
+		EnhancedFieldSelector: selector,
+		URLQuery:              url.Values{URLQueryCompareFields: []string{"spec.replicas!=status.readyReplicas"}},
+	}
+	applyFn := func(query *gorm.DB, options *internal.ListOptions) (*gorm.DB, error) {
+		_, _, query, err := applyListOptionsToQuery(querySplitObjects(query, true), options, nil)
+		return query, err
+	}
+
+	assertSQL(t, postgresDB, listOptions, applyFn,
+		`SELECT * FROM "resources" WHERE "object" -> 'metadata' ->> 'name' = 'a' AND COALESCE("spec" ->> 'replicas', "object" -> 'spec' ->> 'replicas') = '3' AND `+
+			`COALESCE("spec" #> '{replicas}', "object" #> '{spec,replicas}') IS DISTINCT FROM COALESCE("status" #> '{readyReplicas}', "object" #> '{status,readyReplicas}')`, nil)
+	for _, mysqlDB := range mysqlDBs {
+		assertSQL(t, mysqlDB, listOptions, applyFn,
+			"SELECT * FROM `resources` WHERE JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"name\"')) = 'a' AND JSON_UNQUOTE(COALESCE(JSON_EXTRACT(`spec`,'$.\"replicas\"'), JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"'))) = '3' AND "+
+				"NOT (COALESCE(JSON_EXTRACT(`spec`,'$.\"replicas\"'), JSON_EXTRACT(`object`,'$.\"spec\".\"replicas\"')) <=> COALESCE(JSON_EXTRACT(`status`,'$.\"readyReplicas\"'), JSON_EXTRACT(`object`,'$.\"status\".\"readyReplicas\"')))", nil)
+	}
+}
+
+func TestResourceStorage_SplitObjects(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gvr.GroupResource(), true)
+	require.NoError(t, err)
+
+	// the objects of the cluster-1 are split, and the objects of the cluster-2 are written before they are split
+	split, unsplit := newTestResourceStorage(db, gvr), newTestResourceStorage(db, gvr)
+	split.codec, unsplit.codec = config.Codec, config.Codec
+	split.splitObject = true
+
+	deploys := []*appsv1.Deployment{
+		newTestDeployment("a", 3, &appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 1}),
+		newTestDeployment("b", 3, &appsv1.DeploymentStatus{Replicas: 3, ReadyReplicas: 3}),
+		newTestDeployment("c", 1, nil),
+	}
+	for _, deploy := range deploys {
+		require.NoError(t, split.Create(context.Background(), "cluster-1", deploy))
+		require.NoError(t, unsplit.Create(context.Background(), "cluster-2", deploy))
+	}
+
+	var resource Resource
+	require.NoError(t, db.Where("cluster = ? AND name = ?", "cluster-1", "a").First(&resource).Error)
+	assert.NotContains(t, string(resource.Object), "readyReplicas")
+	assert.JSONEq(t, `{"readyReplicas":1,"replicas":3}`, string(resource.Status))
+	assert.NoError(t, resource.verifyChecksum())
+
+	// the reassembled objects are the same as the objects which aren't split
+	for _, deploy := range deploys {
+		var encoded bytes.Buffer
+		require.NoError(t, config.Codec.Encode(deploy, &encoded))
+
+		object, err := split.getObject(context.Background(), "cluster-1", "default", deploy.Name)
+		require.NoError(t, err)
+		assert.Equal(t, encoded.Bytes(), object)
+		object, err = unsplit.getObject(context.Background(), "cluster-2", "default", deploy.Name)
+		require.NoError(t, err)
+		assert.Equal(t, encoded.Bytes(), object)
+
+		got := &appsv1.Deployment{}
+		require.NoError(t, split.Get(context.Background(), "cluster-1", "default", deploy.Name, got))
+		assert.Equal(t, deploy.Spec.Replicas, got.Spec.Replicas)
+		assert.Equal(t, deploy.Status, got.Status)
+	}
+
+	splitList, unsplitList := &appsv1.DeploymentList{}, &appsv1.DeploymentList{}
+	require.NoError(t, split.List(context.Background(), splitList, &internal.ListOptions{ClusterNames: []string{"cluster-1"}}))
+	require.NoError(t, split.List(context.Background(), unsplitList, &internal.ListOptions{ClusterNames: []string{"cluster-2"}}))
+	require.Len(t, splitList.Items, 3)
+	assert.Equal(t, unsplitList.Items, splitList.Items)
+
+	names := func(opts *internal.ListOptions) []string {
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, split.List(context.Background(), list, opts))
+		var names []string
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+	listOptions := func(clusters []string, compareFields string) *internal.ListOptions {
+		return &internal.ListOptions{ClusterNames: clusters, URLQuery: url.Values{URLQueryCompareFields: []string{compareFields}}}
+	}
+	for _, cluster := range []string{"cluster-1", "cluster-2"} {
+		// the missing ready replicas is different from the replicas
+		assert.Len(t, names(listOptions([]string{cluster}, "spec.replicas!=status.readyReplicas")), 2)
+		assert.Len(t, names(listOptions([]string{cluster}, "spec.replicas=status.readyReplicas")), 1)
+		assert.Len(t, names(listOptions([]string{cluster}, "status.readyReplicas<status.replicas")), 1)
+	}
+
+	selector, err := fields.Parse("spec.replicas=1")
+	require.NoError(t, err)
+	list := &appsv1.DeploymentList{}
+	require.NoError(t, split.List(context.Background(), list, &internal.ListOptions{EnhancedFieldSelector: selector}))
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, "c", list.Items[0].Name)
+
+	// the object is written as a whole after it isn't split
+	require.NoError(t, unsplit.Update(context.Background(), "cluster-1", deploys[0]))
+	require.NoError(t, db.Where("cluster = ? AND name = ?", "cluster-1", "a").First(&resource).Error)
+	assert.Contains(t, string(resource.Object), "readyReplicas")
+	assert.Nil(t, resource.Status)
+	require.NoError(t, split.Get(context.Background(), "cluster-1", "default", "a", &appsv1.Deployment{}))
+}
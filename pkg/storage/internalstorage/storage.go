@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"golang.org/x/sync/singleflight"
 	"gorm.io/gorm"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
@@ -13,39 +14,245 @@ import (
 
 type StorageFactory struct {
 	db *gorm.DB
+
+	// router routes the resources to the databases configured by the routes,
+	// all resources are stored in the db if it is nil.
+	router *databaseRouter
+
+	timeouts   TimeoutConfig
+	queryLimit QueryLimitConfig
+
+	resourceVersionMaxLength int
+	verifyChecksum           bool
+	listDebugDisabled        bool
+	decodeWorkers            int
+
+	// checksumMissing is the databases without the checksum column.
+	checksumMissing map[*gorm.DB]bool
+
+	// contentHashMissing is the databases without the content_hash column.
+	contentHashMissing map[*gorm.DB]bool
+	lightUpdates       bool
+
+	// keyHashMissing is the databases without the key_hash column.
+	keyHashMissing map[*gorm.DB]bool
+
+	// labelSelectorMode is how the label selectors are applied, the labels table is maintained in the table mode.
+	labelSelectorMode LabelSelectorMode
+
+	// jsonUnsupported is the databases which can't query the json.
+	jsonUnsupported map[*gorm.DB]bool
+
+	// preparedStatements prepares the statements of the Get and Update of the resource storages.
+	preparedStatements bool
+
+	// indexHints are the index hints of the list queries of the resource storages.
+	indexHints indexHints
+
+	// splitColumnsMissing is the databases without the spec and status columns.
+	splitColumnsMissing map[*gorm.DB]bool
+
+	// budgets are the connection budgets of the databases, the databases without the budgets are unlimited.
+	budgets map[*gorm.DB]*connectionBudget
+
+	// splitObjects are the resources whose spec and status are stored in the spec and status columns.
+	splitObjects map[schema.GroupResource]bool
+
+	// encryption encrypts the objects of the configured resources, the encryption is disabled if it is nil.
+	encryption *objectEncryption
+
+	// redactions are the fields of the resources redacted before the objects are stored.
+	redactions map[schema.GroupResource][]redactedField
+
+	// objectSizeLimit limits the size of the stored objects, the limit is disabled if it is nil.
+	objectSizeLimit *objectSizeLimit
+
+	// references are the reference rules of the resources, the references aren't recorded if it is nil.
+	references *objectReferences
+
+	// ownerResolver tracks the unresolved owner references of the written objects, it is disabled if it is nil.
+	ownerResolver *ownerResolver
+
+	// clusterAliases resolve the old names of the renamed clusters in the reads of the resources.
+	clusterAliases clusterAliases
+
+	// shadowAnnotations are injected into the objects returned by the resource storages.
+	shadowAnnotations shadowAnnotations
+
+	// storagePolicies are the storage policies of the resources, all of the objects of the other resources are stored.
+	storagePolicies map[schema.GroupResource]*storagePolicy
+
+	// writeBehind is the options of the write-behind mode, the mode is disabled if it is nil.
+	writeBehind *writeBehindOptions
+
+	// leases are the leases of the clusters, the writes are fenced by them if the fencing is enabled.
+	leases *clusterLeases
+
+	// audit receives the records of the mutations of the resource storages, the audit is disabled if it is nil.
+	audit AuditSink
+
+	// history records the revisions of the objects of the configured resources, the history is disabled if it is nil.
+	history *historyRecorder
+
+	// notifications receive the notifications of the changes of the resource storages, the notification is disabled if it is nil.
+	notifications NotificationSink
+
+	// eventStream publishes the mutations of the resource storages to the message bus, the event stream is disabled if it is nil.
+	eventStream *eventStream
+
+	// getCache is the get cache shared by the resource storages, the cache is disabled if it is nil.
+	getCache *getCache
+
+	// notFoundCache is the not found cache shared by the resource storages, the cache is disabled if it is nil.
+	notFoundCache *getCache
+
+	// listCache is the list cache shared by the resource storages, the cache is disabled if it is nil.
+	listCache *listCache
+
+	// getFlight is the singleflight of the get requests shared by the resource storages, it is disabled if it is nil.
+	getFlight *singleflight.Group
+
+	// stats are the periodically refreshed stats of the databases, they are empty if the metrics are disabled.
+	stats []*resourceStatsSource
+
+	// hub is the in-process watch hub shared by the resource storages, the watch is not supported if it is nil.
+	hub *watchHub
+
+	// legacyVersions are the previous storage versions of the resources in priority order.
+	legacyVersions map[schema.GroupResource][]string
+
+	// reencoder re-encodes the rows of the legacy versions of the resource storages, it is disabled if it is nil.
+	reencoder *legacyReencoder
+
+	// middlewares wrap the resource storages, the resource storages aren't wrapped if it is empty.
+	middlewares middlewareChain
+}
+
+func (s *StorageFactory) resourceDB(gr schema.GroupResource) *gorm.DB {
+	if s.router == nil {
+		return s.db
+	}
+	return s.router.database(gr)
+}
+
+func (s *StorageFactory) databases() []*gorm.DB {
+	if s.router == nil {
+		return []*gorm.DB{s.db}
+	}
+	return s.router.all()
 }
 
 func (s *StorageFactory) GetSupportedRequestVerbs() []string {
+	if s.hub != nil {
+		return []string{"get", "list", "watch"}
+	}
 	return []string{"get", "list"}
 }
 
 func (s *StorageFactory) NewResourceStorage(config *storage.ResourceStorageConfig) (storage.ResourceStorage, error) {
-	return &ResourceStorage{
-		db:    s.db,
-		codec: config.Codec,
+	db := s.resourceDB(config.StorageGroupResource)
+	rs := &ResourceStorage{
+		db:            db,
+		codec:         config.Codec,
+		timeouts:      s.timeouts,
+		queryLimit:    s.queryLimit,
+		getCache:      s.getCache,
+		notFoundCache: s.notFoundCache,
+		listCache:     s.listCache,
+		getFlight:     s.getFlight,
+		hub:           s.hub,
+
+		resourceVersionMaxLength: s.resourceVersionMaxLength,
+		checksumMissing:          s.checksumMissing[db],
+		verifyChecksum:           s.verifyChecksum && !s.checksumMissing[db],
+		listDebugDisabled:        s.listDebugDisabled,
+		decodeWorkers:            s.decodeWorkers,
+		contentHashMissing:       s.contentHashMissing[db],
+		lightUpdates:             s.lightUpdates && !s.contentHashMissing[db],
+		keyHashMissing:           s.keyHashMissing[db],
+		labelSelectorMode:        s.labelSelectorMode,
+		jsonUnsupported:          s.jsonUnsupported[db],
+		encryption:               s.encryption,
+		encrypt:                  s.encryption.encrypts(config.StorageGroupResource),
+		splitColumnsMissing:      s.splitColumnsMissing[db],
+		splitObject:              s.splitObjects[config.StorageGroupResource] && !s.splitColumnsMissing[db] && !s.encryption.encrypts(config.StorageGroupResource),
+		redactedFields:           s.redactions[config.StorageGroupResource],
+		referencesEnabled:        s.references != nil,
+		referenceRules:           s.references.resourceRules(config.StorageGroupResource),
+		ownerResolver:            s.ownerResolver,
+		clusterAliases:           s.clusterAliases,
+		shadowAnnotations:        s.shadowAnnotations,
+		objectSizeLimit:          s.objectSizeLimit,
+		storagePolicy:            s.storagePolicies[config.StorageGroupResource],
+		leases:                   s.leases,
+		audit:                    s.audit,
+		notifications:            s.notifications,
+		eventStream:              s.eventStream,
+		budget:                   s.budgets[db],
+		indexHints:               s.indexHints,
 
 		storageGroupResource: config.StorageGroupResource,
 		storageVersion:       config.StorageVersion,
 		memoryVersion:        config.MemoryVersion,
-	}, nil
+		legacyVersions:       legacyVersionsOf(s.legacyVersions[config.StorageGroupResource], config.StorageVersion.Version),
+		legacyCodecs:         config.LegacyCodecs,
+		clusterScoped:        !config.Namespaced,
+	}
+	if s.history.records(config.StorageGroupResource) {
+		rs.history = s.history
+	}
+	if s.preparedStatements {
+		rs.prepared = rs.newPreparedQueries()
+	}
+	if s.writeBehind != nil {
+		rs.writeBehind = newWriteBehindQueue(rs, s.writeBehind)
+	}
+	s.reencoder.register(rs)
+	return s.middlewares.wrap(config, rs), nil
 }
 
 func (s *StorageFactory) NewCollectionResourceStorage(cr *internal.CollectionResource) (storage.CollectionResourceStorage, error) {
 	for i := range collectionResources {
-		if collectionResources[i].Name == cr.Name {
-			return NewCollectionResourceStorage(s.db, cr), nil
+		if collectionResources[i].Name != cr.Name {
+			continue
 		}
+
+		var storage *CollectionResourceStorage
+		switch {
+		case s.router == nil:
+			storage = newCollectionResourceStorage(s.db, nil, cr)
+		case len(cr.ResourceTypes) == 0:
+			// the resource types are specified by the url query of the request
+			storage = newCollectionResourceStorage(s.db, s.router, cr)
+		default:
+			db, err := s.router.collectionDatabase(cr.ResourceTypes)
+			if err != nil {
+				return nil, fmt.Errorf("collection resource %s: %w", cr.Name, err)
+			}
+			storage = newCollectionResourceStorage(db, nil, cr)
+		}
+		storage.queryLimit = s.queryLimit
+		storage.encryption = s.encryption
+		storage.clusterAliases = s.clusterAliases
+		storage.shadowAnnotations = s.shadowAnnotations
+		// the resources of the collection resource may be split, unless the columns are missing in any database
+		storage.splitObjects = len(s.splitObjects) != 0 && len(s.splitColumnsMissing) == 0
+		return storage, nil
 	}
 	return nil, fmt.Errorf("not support collection resource: %s", cr.Name)
 }
 
 func (s *StorageFactory) GetResourceVersions(ctx context.Context, cluster string) (map[schema.GroupVersionResource]map[string]interface{}, error) {
 	var resources []Resource
-	result := s.db.WithContext(ctx).Select("group", "version", "resource", "namespace", "name", "resource_version").
-		Where(map[string]interface{}{"cluster": cluster}).
-		Find(&resources)
-	if result.Error != nil {
-		return nil, InterpretDBError(cluster, result.Error)
+	for _, db := range s.databases() {
+		var rs []Resource
+		result := db.WithContext(ctx).Select("group", "version", "resource", "namespace", "name", "resource_version").
+			Where(map[string]interface{}{"cluster": cluster}).
+			Find(&rs)
+		if result.Error != nil {
+			return nil, InterpretDBError(cluster, result.Error)
+		}
+		resources = append(resources, rs...)
 	}
 
 	resourceversions := make(map[schema.GroupVersionResource]map[string]interface{})
@@ -66,18 +273,73 @@ func (s *StorageFactory) GetResourceVersions(ctx context.Context, cluster string
 	return resourceversions, nil
 }
 
+var _ storage.ClusterResourceCounter = &StorageFactory{}
+
+func (s *StorageFactory) CountClusterResources(ctx context.Context, cluster string) (map[schema.GroupVersionResource]int64, error) {
+	counts := make(map[schema.GroupVersionResource]int64)
+	for _, db := range s.databases() {
+		var rows []struct {
+			Group    string
+			Version  string
+			Resource string
+			Count    int64
+		}
+		columns := db.Statement.Quote("group") + ", version, resource"
+		result := db.WithContext(ctx).Model(&Resource{}).Select(columns + ", COUNT(*) AS count").
+			Where(map[string]interface{}{"cluster": cluster}).
+			Group(columns).
+			Scan(&rows)
+		if result.Error != nil {
+			return nil, InterpretDBError(cluster, result.Error)
+		}
+		for _, row := range rows {
+			counts[schema.GroupVersionResource{Group: row.Group, Version: row.Version, Resource: row.Resource}] += row.Count
+		}
+	}
+	return counts, nil
+}
+
+func (s *StorageFactory) NewCheckpointStore() storage.CheckpointStore {
+	return &CheckpointStore{db: s.db}
+}
+
 func (s *StorageFactory) CleanCluster(ctx context.Context, cluster string) error {
-	result := s.db.WithContext(ctx).Where(map[string]interface{}{"cluster": cluster}).Delete(&Resource{})
+	for _, db := range s.databases() {
+		if err := s.deleteSecondaryRows(db.WithContext(ctx), map[string]interface{}{"cluster": cluster}); err != nil {
+			return InterpretDBError(cluster, err)
+		}
+		result := deleteInBatches(db.WithContext(ctx), &Resource{}, map[string]interface{}{"cluster": cluster})
+		if result.Error != nil {
+			return InterpretDBError(cluster, result.Error)
+		}
+	}
+	s.getCache.purge()
+	s.notFoundCache.purge()
+	s.ownerResolver.forgetCluster(cluster)
+
+	// the checkpoints are always stored in the default database
+	result := s.db.WithContext(ctx).Where(map[string]interface{}{"cluster": cluster}).Delete(&Checkpoint{})
 	return InterpretDBError(cluster, result.Error)
 }
 
 func (s *StorageFactory) CleanClusterResource(ctx context.Context, cluster string, gvr schema.GroupVersionResource) error {
-	result := s.db.WithContext(ctx).Where(map[string]interface{}{
+	where := map[string]interface{}{
 		"cluster":  cluster,
 		"group":    gvr.Group,
 		"version":  gvr.Version,
 		"resource": gvr.Resource,
-	}).Delete(&Resource{})
+	}
+	if err := s.deleteSecondaryRows(s.resourceDB(gvr.GroupResource()).WithContext(ctx), where); err != nil {
+		return InterpretDBError(fmt.Sprintf("%s/%s", cluster, gvr), err)
+	}
+	result := deleteInBatches(s.resourceDB(gvr.GroupResource()).WithContext(ctx), &Resource{}, where)
+	if result.Error != nil {
+		return InterpretDBError(fmt.Sprintf("%s/%s", cluster, gvr), result.Error)
+	}
+	s.getCache.purge()
+	s.notFoundCache.purge()
+
+	result = s.db.WithContext(ctx).Where(where).Delete(&Checkpoint{})
 	return InterpretDBError(fmt.Sprintf("%s/%s", cluster, gvr), result.Error)
 }
 
@@ -0,0 +1,144 @@
+package internalstorage
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+// StoragePolicy is how the objects of the resource are stored.
+type StoragePolicy string
+
+const (
+	// StoragePolicySkip stores none of the objects of the resource.
+	StoragePolicySkip StoragePolicy = "skip"
+
+	// StoragePolicyMetadataOnly stores the apiVersion, kind and metadata of the objects,
+	// the stored objects are marked by the TruncatedAnnotation.
+	StoragePolicyMetadataOnly StoragePolicy = "metadataOnly"
+
+	// StoragePolicySample stores the sampled objects of the resource.
+	StoragePolicySample StoragePolicy = "sample"
+)
+
+var skippedWritesTotal = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace:      "clusterpedia",
+		Subsystem:      "internalstorage",
+		Name:           "skipped_writes_total",
+		Help:           "Number of the writes not stored by the storage policies, partitioned by the resource and the reason of skipped or sampledOut.",
+		StabilityLevel: metrics.ALPHA,
+	},
+	[]string{"group", "version", "resource", "reason"},
+)
+
+func init() {
+	legacyregistry.MustRegister(skippedWritesTotal)
+}
+
+// StoragePolicyConfig is the storage policy of the resource, the writes not stored by the policy still succeed,
+// so they aren't retried by the synchro.
+type StoragePolicyConfig struct {
+	Group    string `yaml:"group"`
+	Resource string `yaml:"resource"`
+
+	// Policy is how the objects of the resource are stored, skip, metadataOnly or sample.
+	Policy StoragePolicy `yaml:"policy"`
+
+	// SampleEvery stores one of every N objects by the sample policy, the objects are sampled by the hash
+	// of their cluster, namespace and name, so an object is either always stored or never stored.
+	SampleEvery int `yaml:"sampleEvery"`
+
+	// LabelSelector stores the objects matching the selector by the sample policy, the stored object
+	// which no longer matches the selector is deleted by its update.
+	LabelSelector string `yaml:"labelSelector"`
+}
+
+type storagePolicy struct {
+	policy      StoragePolicy
+	sampleEvery uint32
+	selector    labels.Selector
+}
+
+func newStoragePolicies(configs []StoragePolicyConfig) (map[schema.GroupResource]*storagePolicy, error) {
+	policies := make(map[schema.GroupResource]*storagePolicy, len(configs))
+	for _, config := range configs {
+		if config.Resource == "" {
+			return nil, fmt.Errorf("storage policy: resource is required")
+		}
+		gr := schema.GroupResource{Group: config.Group, Resource: config.Resource}
+		if _, ok := policies[gr]; ok {
+			return nil, fmt.Errorf("storage policy %s: duplicate policies", gr)
+		}
+		if config.SampleEvery < 0 {
+			return nil, fmt.Errorf("storage policy %s: sampleEvery must not be negative, got %d", gr, config.SampleEvery)
+		}
+
+		policy := &storagePolicy{policy: config.Policy, sampleEvery: uint32(config.SampleEvery)}
+		switch config.Policy {
+		case StoragePolicySkip, StoragePolicyMetadataOnly:
+			if config.SampleEvery != 0 || config.LabelSelector != "" {
+				return nil, fmt.Errorf("storage policy %s: sampleEvery and labelSelector are only supported by the %s policy", gr, StoragePolicySample)
+			}
+		case StoragePolicySample:
+			if config.SampleEvery == 0 && config.LabelSelector == "" {
+				return nil, fmt.Errorf("storage policy %s: sampleEvery or labelSelector is required by the %s policy", gr, StoragePolicySample)
+			}
+			if config.LabelSelector != "" {
+				selector, err := labels.Parse(config.LabelSelector)
+				if err != nil {
+					return nil, fmt.Errorf("storage policy %s: %w", gr, err)
+				}
+				policy.selector = selector
+			}
+		default:
+			return nil, fmt.Errorf("storage policy %s: policy %q is not supported", gr, config.Policy)
+		}
+		policies[gr] = policy
+	}
+	return policies, nil
+}
+
+// metadataOnly returns whether only the metadata of the objects is stored.
+func (p *storagePolicy) metadataOnly() bool {
+	return p != nil && p.policy == StoragePolicyMetadataOnly
+}
+
+// skipsWrite returns whether the written object isn't stored by the storage policy of the resource,
+// and whether the stored object should be deleted, since it's no longer sampled.
+func (s *ResourceStorage) skipsWrite(cluster string, obj runtime.Object) (skipped bool, deleteStored bool, err error) {
+	policy := s.storagePolicy
+	if policy == nil || policy.policy == StoragePolicyMetadataOnly {
+		return false, false, nil
+	}
+
+	gvr := s.storageGVR()
+	if policy.policy == StoragePolicySkip {
+		skippedWritesTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, "skipped").Inc()
+		return true, false, nil
+	}
+
+	metaobj, err := meta.Accessor(obj)
+	if err != nil {
+		return false, false, err
+	}
+	if policy.sampleEvery > 1 {
+		hash := fnv.New32a()
+		hash.Write([]byte(cluster + "/" + metaobj.GetNamespace() + "/" + metaobj.GetName()))
+		if hash.Sum32()%policy.sampleEvery != 0 {
+			skippedWritesTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, "sampledOut").Inc()
+			return true, false, nil
+		}
+	}
+	if policy.selector != nil && !policy.selector.Matches(labels.Set(metaobj.GetLabels())) {
+		skippedWritesTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, "sampledOut").Inc()
+		return true, true, nil
+	}
+	return false, false, nil
+}
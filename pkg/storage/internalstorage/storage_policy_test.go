@@ -0,0 +1,139 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newStoragePolicyTestStorage(t *testing.T, db *gorm.DB, config StoragePolicyConfig) *ResourceStorage {
+	policies, err := newStoragePolicies([]StoragePolicyConfig{config})
+	require.NoError(t, err)
+
+	rs := newTestResourceStorage(db, corev1.SchemeGroupVersion.WithResource("pods"))
+	storageConfig, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Resource: "pods"}, true)
+	require.NoError(t, err)
+	rs.codec = storageConfig.Codec
+	rs.storagePolicy = policies[schema.GroupResource{Resource: "pods"}]
+	return rs
+}
+
+func newStoragePolicyTestPod(name, resourceVersion string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: resourceVersion, Labels: labels},
+		Spec:       corev1.PodSpec{NodeName: "node-1"},
+	}
+}
+
+func skippedWrites(t *testing.T, reason string) float64 {
+	value, err := testutil.GetCounterMetricValue(skippedWritesTotal.WithLabelValues("", "v1", "pods", reason))
+	require.NoError(t, err)
+	return value
+}
+
+func countStoredPods(t *testing.T, db *gorm.DB) int64 {
+	var count int64
+	require.NoError(t, db.Model(&Resource{}).Where("resource = ?", "pods").Count(&count).Error)
+	return count
+}
+
+func TestStoragePolicySkip(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newStoragePolicyTestStorage(t, db, StoragePolicyConfig{Resource: "pods", Policy: StoragePolicySkip})
+	skipped := skippedWrites(t, "skipped")
+
+	// the skipped writes succeed, so they aren't retried by the synchro
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newStoragePolicyTestPod("a", "1", nil)))
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", newStoragePolicyTestPod("a", "2", nil)))
+	assert.Zero(t, countStoredPods(t, db))
+	assert.Equal(t, skipped+2, skippedWrites(t, "skipped"))
+}
+
+func TestStoragePolicyMetadataOnly(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newStoragePolicyTestStorage(t, db, StoragePolicyConfig{Resource: "pods", Policy: StoragePolicyMetadataOnly})
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newStoragePolicyTestPod("a", "1", map[string]string{"app": "a"})))
+
+	pod := &corev1.Pod{}
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "a", pod))
+	assert.Equal(t, "metadataOnly", pod.Annotations[TruncatedAnnotation])
+	assert.Equal(t, "a", pod.Labels["app"])
+	assert.Empty(t, pod.Spec.NodeName)
+
+	var resource Resource
+	require.NoError(t, db.Where("name = ?", "a").First(&resource).Error)
+	assert.NotContains(t, string(resource.Object), "node-1")
+	assert.Contains(t, string(resource.Metadata), TruncatedAnnotation)
+}
+
+func TestStoragePolicySample(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newStoragePolicyTestStorage(t, db, StoragePolicyConfig{Resource: "pods", Policy: StoragePolicySample, SampleEvery: 4})
+	sampledOut := skippedWrites(t, "sampledOut")
+	for i := 0; i < 100; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newStoragePolicyTestPod(fmt.Sprintf("pod-%d", i), "1", nil)))
+	}
+	stored := countStoredPods(t, db)
+	assert.Greater(t, stored, int64(10))
+	assert.Less(t, stored, int64(40))
+	assert.Equal(t, sampledOut+float64(100-stored), skippedWrites(t, "sampledOut"))
+
+	// the objects are sampled by their keys, so the updates of the stored objects are still stored
+	for i := 0; i < 100; i++ {
+		require.NoError(t, rs.Update(context.Background(), "cluster-1", newStoragePolicyTestPod(fmt.Sprintf("pod-%d", i), "2", nil)))
+	}
+	var resourceVersions []string
+	require.NoError(t, db.Model(&Resource{}).Distinct().Pluck("resource_version", &resourceVersions).Error)
+	assert.Equal(t, []string{"2"}, resourceVersions)
+	assert.Equal(t, stored, countStoredPods(t, db))
+}
+
+func TestStoragePolicySampleLabelSelector(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newStoragePolicyTestStorage(t, db, StoragePolicyConfig{Resource: "pods", Policy: StoragePolicySample, LabelSelector: "app=web"})
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newStoragePolicyTestPod("a", "1", map[string]string{"app": "web"})))
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newStoragePolicyTestPod("b", "1", map[string]string{"app": "job"})))
+	assert.Equal(t, int64(1), countStoredPods(t, db))
+
+	// the stored object no longer matching the selector is deleted
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", newStoragePolicyTestPod("a", "2", map[string]string{"app": "job"})))
+	assert.Zero(t, countStoredPods(t, db))
+}
+
+func TestNewStoragePolicies(t *testing.T) {
+	for _, configs := range [][]StoragePolicyConfig{
+		{{Policy: StoragePolicySkip}},
+		{{Resource: "pods", Policy: "drop"}},
+		{{Resource: "pods", Policy: StoragePolicySample}},
+		{{Resource: "pods", Policy: StoragePolicySample, SampleEvery: -1}},
+		{{Resource: "pods", Policy: StoragePolicySample, LabelSelector: "app in ("}},
+		{{Resource: "pods", Policy: StoragePolicySkip, SampleEvery: 2}},
+		{{Resource: "pods", Policy: StoragePolicySkip}, {Resource: "pods", Policy: StoragePolicyMetadataOnly}},
+	} {
+		_, err := newStoragePolicies(configs)
+		assert.Error(t, err, configs)
+	}
+}
@@ -41,7 +41,7 @@ func newSQLiteDB() (*gorm.DB, func(), error) {
 		return nil, func() {}, err
 	}
 
-	err = db.AutoMigrate(&Resource{})
+	err = db.AutoMigrate(&Resource{}, &Checkpoint{})
 	if err != nil {
 		return nil, func() {}, err
 	}
@@ -0,0 +1,509 @@
+//go:build ignore
+
+// generate.go encodes resources.binlog, the binlog of the transactions of the resources table replayed by the tests
+// of the change feed. The events are encoded as MySQL 8.0 writes them with gtid_mode=ON, binlog_format=ROW,
+// binlog_row_image=FULL and binlog_checksum=CRC32, and the columns of the resources table are in the order of
+// the Resource model. Run `go run generate.go` in this directory after the transactions are changed.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	serverUUID = "3e11fa47-71ca-11e1-9e33-c80aa9429562"
+	serverID   = 1
+	database   = "clusterpedia"
+
+	formatDescriptionEvent = 15
+	queryEvent             = 2
+	xidEvent               = 16
+	tableMapEvent          = 19
+	writeRowsEventV2       = 30
+	updateRowsEventV2      = 31
+	deleteRowsEventV2      = 32
+	gtidEvent              = 33
+	previousGTIDsEvent     = 35
+
+	typeLongLong  = 8
+	typeVarchar   = 15
+	typeDatetime2 = 18
+	typeJSON      = 245
+
+	jsonSmallObject = 0x00
+	jsonSmallArray  = 0x02
+	jsonLiteral     = 0x04
+	jsonInt16       = 0x05
+	jsonInt64       = 0x09
+	jsonString      = 0x0c
+
+	rowsStmtEndFlag = 0x0001
+)
+
+// startedAt is the commit time of the first transaction, the transactions are committed one second apart.
+var startedAt = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+type column struct {
+	name     string
+	typ      byte
+	meta     []byte
+	nullable bool
+}
+
+func varchar(name string, size int) column {
+	meta := make([]byte, 2)
+	binary.LittleEndian.PutUint16(meta, uint16(size*4))
+	return column{name: name, typ: typeVarchar, meta: meta}
+}
+
+// resourceColumns are the columns of the resources table, the varchars are utf8mb4.
+var resourceColumns = []column{
+	{name: "id", typ: typeLongLong},
+	varchar("group", 63),
+	varchar("version", 15),
+	varchar("resource", 63),
+	varchar("kind", 63),
+	varchar("cluster", 253),
+	varchar("namespace", 253),
+	varchar("name", 253),
+	varchar("owner_uid", 36),
+	varchar("uid", 36),
+	varchar("resource_version", 255),
+	{name: "object", typ: typeJSON, meta: []byte{4}},
+	{name: "metadata", typ: typeJSON, meta: []byte{4}, nullable: true},
+	{name: "spec", typ: typeJSON, meta: []byte{4}, nullable: true},
+	{name: "status", typ: typeJSON, meta: []byte{4}, nullable: true},
+	{name: "checksum", typ: typeLongLong, nullable: true},
+	{name: "content_hash", typ: typeVarchar, meta: []byte{0, 1}, nullable: true},
+	{name: "key_hash", typ: typeVarchar, meta: []byte{0, 1}, nullable: true},
+	{name: "created_at", typ: typeDatetime2, meta: []byte{3}},
+	{name: "synced_at", typ: typeDatetime2, meta: []byte{3}},
+	{name: "deleted_at", typ: typeDatetime2, meta: []byte{3}, nullable: true},
+}
+
+var highWaterMarkColumns = []column{
+	varchar("topic", 249),
+	{name: "synced_at", typ: typeDatetime2, meta: []byte{3}},
+}
+
+type resource struct {
+	id                                uint64
+	group, version, resource, kind    string
+	cluster, namespace, name, uid, rv string
+	object, spec, status              string
+	createdAt, syncedAt               time.Time
+}
+
+func (r resource) values() []interface{} {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(r.object), &object); err != nil {
+		panic(err)
+	}
+	key := strings.Join([]string{r.group, r.version, r.resource, r.cluster, r.namespace, r.name}, "/")
+	values := []interface{}{
+		r.id, r.group, r.version, r.resource, r.kind, r.cluster, r.namespace, r.name, "", r.uid, r.rv,
+		jsonValue(r.object), jsonValue(string(object["metadata"])), nil, nil,
+		int64(crc32.ChecksumIEEE([]byte(r.object))), fmt.Sprintf("%064x", crc32.ChecksumIEEE([]byte(r.object))),
+		fmt.Sprintf("%064x", crc32.ChecksumIEEE([]byte(key))),
+		r.createdAt, r.syncedAt, nil,
+	}
+	if r.spec != "" {
+		values[13] = jsonValue(r.spec)
+	}
+	if r.status != "" {
+		values[14] = jsonValue(r.status)
+	}
+	return values
+}
+
+type jsonValue string
+
+var (
+	nginx = resource{
+		id: 1, group: "apps", version: "v1", resource: "deployments", kind: "Deployment",
+		cluster: "cluster-1", namespace: "default", name: "nginx", uid: "7c3a4e4e-5b1d-4d8e-9a36-0f5a3c1b2d01", rv: "100",
+		object: `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"nginx","namespace":"default",` +
+			`"uid":"7c3a4e4e-5b1d-4d8e-9a36-0f5a3c1b2d01","resourceVersion":"100","labels":{"app":"nginx"}},` +
+			`"spec":{"replicas":1,"paused":false},"status":{"replicas":1}}`,
+		createdAt: startedAt, syncedAt: startedAt,
+	}
+	scaledNginx = func() resource {
+		r := nginx
+		r.rv = "101"
+		r.object = strings.NewReplacer(`"100"`, `"101"`, `"replicas":1,`, `"replicas":2,`).Replace(nginx.object)
+		r.syncedAt = startedAt.Add(2 * time.Second)
+		return r
+	}()
+	resyncedNginx = func() resource {
+		r := scaledNginx
+		r.rv = "102"
+		r.syncedAt = startedAt.Add(3 * time.Second)
+		return r
+	}()
+
+	// pod is split, its spec and status are stored by their columns.
+	pod = resource{
+		id: 2, version: "v1", resource: "pods", kind: "Pod",
+		cluster: "cluster-1", namespace: "default", name: "nginx-0", uid: "0d6f1c58-2a8b-4f3e-8e0b-6e1f2a3b4c02", rv: "200",
+		object: `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx-0","namespace":"default",` +
+			`"uid":"0d6f1c58-2a8b-4f3e-8e0b-6e1f2a3b4c02","resourceVersion":"200","labels":{"app":"nginx"}}}`,
+		spec:      `{"containers":[{"name":"nginx","image":"nginx:1.25"}],"nodeName":null}`,
+		status:    `{"phase":"Running","restartCount":70000}`,
+		createdAt: startedAt, syncedAt: startedAt,
+	}
+	recreatedPod = func() resource {
+		r := pod
+		r.uid, r.rv = "5a9e7b13-c4d2-4b6a-b1f0-3d2c1e0f9a03", "300"
+		r.object = strings.NewReplacer(pod.uid, r.uid, `"200"`, `"300"`).Replace(pod.object)
+		r.status = `{"phase":"Pending"}`
+		r.createdAt, r.syncedAt = startedAt.Add(6*time.Second), startedAt.Add(6*time.Second)
+		return r
+	}()
+)
+
+type writer struct {
+	bytes.Buffer
+	tableID uint64
+}
+
+func (w *writer) event(at time.Time, eventType byte, body []byte) {
+	size := 19 + len(body) + 4
+	header := make([]byte, 19)
+	binary.LittleEndian.PutUint32(header[0:], uint32(at.Unix()))
+	header[4] = eventType
+	binary.LittleEndian.PutUint32(header[5:], serverID)
+	binary.LittleEndian.PutUint32(header[9:], uint32(size))
+	binary.LittleEndian.PutUint32(header[13:], uint32(w.Len()+size))
+
+	event := append(header, body...)
+	event = binary.LittleEndian.AppendUint32(event, crc32.ChecksumIEEE(event))
+	w.Write(event)
+}
+
+func (w *writer) formatDescription() {
+	body := binary.LittleEndian.AppendUint16(nil, 4)
+	version := make([]byte, 50)
+	copy(version, "8.0.36")
+	body = append(body, version...)
+	body = binary.LittleEndian.AppendUint32(body, uint32(startedAt.Unix()))
+	body = append(body, 19)
+
+	// the post-header lengths of the event types
+	lengths := make([]byte, 41)
+	for eventType, length := range map[int]byte{
+		queryEvent: 13, 4: 8, formatDescriptionEvent: 98, tableMapEvent: 8, 23: 8, 24: 8, 25: 8,
+		writeRowsEventV2: 10, updateRowsEventV2: 10, deleteRowsEventV2: 10, gtidEvent: 42, 34: 42,
+	} {
+		lengths[eventType-1] = length
+	}
+	body = append(body, lengths...)
+	body = append(body, 1) // CRC32
+	w.event(startedAt, formatDescriptionEvent, body)
+
+	// no GTIDs are executed before the binlog
+	w.event(startedAt, previousGTIDsEvent, make([]byte, 8))
+}
+
+func (w *writer) gtid(at time.Time, gno int64) {
+	sid, err := hex.DecodeString(strings.ReplaceAll(serverUUID, "-", ""))
+	if err != nil {
+		panic(err)
+	}
+	body := append([]byte{1}, sid...)
+	body = binary.LittleEndian.AppendUint64(body, uint64(gno))
+	body = append(body, 2) // logical timestamps
+	body = binary.LittleEndian.AppendUint64(body, uint64(gno-1))
+	body = binary.LittleEndian.AppendUint64(body, uint64(gno))
+	body = append(body, fixedInt(uint64(at.UnixMicro()), 7)...)
+	body = append(body, 0) // transaction length
+	body = binary.LittleEndian.AppendUint32(body, 80036)
+	w.event(at, gtidEvent, body)
+}
+
+func (w *writer) query(at time.Time, schema, query string) {
+	body := make([]byte, 13)
+	body[8] = byte(len(schema))
+	body = append(body, schema...)
+	body = append(body, 0)
+	body = append(body, query...)
+	w.event(at, queryEvent, body)
+}
+
+func (w *writer) xid(at time.Time, xid uint64) {
+	w.event(at, xidEvent, binary.LittleEndian.AppendUint64(nil, xid))
+}
+
+func (w *writer) tableMap(at time.Time, schema, table string, columns []column) {
+	w.tableID++
+	body := fixedInt(w.tableID, 6)
+	body = append(body, 1, 0)
+	body = append(body, byte(len(schema)))
+	body = append(body, schema...)
+	body = append(body, 0, byte(len(table)))
+	body = append(body, table...)
+	body = append(body, 0, byte(len(columns)))
+
+	var meta []byte
+	nulls := make([]byte, (len(columns)+7)/8)
+	for i, column := range columns {
+		body = append(body, column.typ)
+		meta = append(meta, column.meta...)
+		if column.nullable {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	body = append(body, byte(len(meta)))
+	body = append(body, meta...)
+	body = append(body, nulls...)
+	w.event(at, tableMapEvent, body)
+}
+
+// rows writes the rows event of the images, the updates are the pairs of the images before and after the update.
+func (w *writer) rows(at time.Time, eventType byte, columns []column, images ...[]interface{}) {
+	body := fixedInt(w.tableID, 6)
+	body = binary.LittleEndian.AppendUint16(body, rowsStmtEndFlag)
+	body = binary.LittleEndian.AppendUint16(body, 2) // no extra data
+	body = append(body, byte(len(columns)))
+
+	bitmap := make([]byte, (len(columns)+7)/8)
+	for i := range columns {
+		bitmap[i/8] |= 1 << (i % 8)
+	}
+	body = append(body, bitmap...)
+	if eventType == updateRowsEventV2 {
+		body = append(body, bitmap...)
+	}
+
+	for _, image := range images {
+		nulls := make([]byte, (len(columns)+7)/8)
+		var values []byte
+		for i, column := range columns {
+			if image[i] == nil {
+				nulls[i/8] |= 1 << (i % 8)
+				continue
+			}
+			values = append(values, encodeValue(column, image[i])...)
+		}
+		body = append(body, nulls...)
+		body = append(body, values...)
+	}
+	w.event(at, eventType, body)
+}
+
+func encodeValue(column column, value interface{}) []byte {
+	switch column.typ {
+	case typeLongLong:
+		switch v := value.(type) {
+		case uint64:
+			return binary.LittleEndian.AppendUint64(nil, v)
+		case int64:
+			return binary.LittleEndian.AppendUint64(nil, uint64(v))
+		}
+	case typeVarchar:
+		v := value.(string)
+		if binary.LittleEndian.Uint16(column.meta) < 256 {
+			return append([]byte{byte(len(v))}, v...)
+		}
+		return append(binary.LittleEndian.AppendUint16(nil, uint16(len(v))), v...)
+	case typeJSON:
+		encoded := encodeJSON(string(value.(jsonValue)))
+		return append(binary.LittleEndian.AppendUint32(nil, uint32(len(encoded))), encoded...)
+	case typeDatetime2:
+		t := value.(time.Time).UTC()
+		packed := uint64(t.Year()*13+int(t.Month()))<<22 | uint64(t.Day())<<17 |
+			uint64(t.Hour())<<12 | uint64(t.Minute())<<6 | uint64(t.Second())
+		encoded := bigEndian(packed+0x8000000000, 5)
+		return append(encoded, bigEndian(uint64(t.Nanosecond()/int(time.Millisecond)*10), 2)...)
+	}
+	panic(fmt.Sprintf("unsupported value %v of the column %s", value, column.name))
+}
+
+// encodeJSON encodes the json to the binary json of MySQL in the small format.
+func encodeJSON(text string) []byte {
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		panic(err)
+	}
+	typ, data := encodeJSONValue(value)
+	return append([]byte{typ}, data...)
+}
+
+func encodeJSONValue(value interface{}) (byte, []byte) {
+	switch v := value.(type) {
+	case nil:
+		return jsonLiteral, []byte{0}
+	case bool:
+		if v {
+			return jsonLiteral, []byte{1}
+		}
+		return jsonLiteral, []byte{2}
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			panic(err)
+		}
+		if n >= -1<<15 && n < 1<<15 {
+			return jsonInt16, binary.LittleEndian.AppendUint16(nil, uint16(n))
+		}
+		return jsonInt64, binary.LittleEndian.AppendUint64(nil, uint64(n))
+	case string:
+		var data []byte
+		for length := len(v); ; length >>= 7 {
+			if length < 0x80 {
+				data = append(data, byte(length))
+				break
+			}
+			data = append(data, byte(length&0x7f|0x80))
+		}
+		return jsonString, append(data, v...)
+	case []interface{}:
+		return jsonSmallArray, encodeJSONContainer(nil, v)
+	case map[string]interface{}:
+		// the keys are sorted by their lengths and then their bytes
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		values := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			values = append(values, v[key])
+		}
+		return jsonSmallObject, encodeJSONContainer(keys, values)
+	}
+	panic(fmt.Sprintf("unsupported json value %v", value))
+}
+
+// encodeJSONContainer encodes the object of the keys or the array, the offsets are from the start of the container.
+func encodeJSONContainer(keys []string, values []interface{}) []byte {
+	header := 4 + 3*len(values) + 4*len(keys)
+	var entries, data []byte
+	for _, key := range keys {
+		entries = binary.LittleEndian.AppendUint16(entries, uint16(header+len(data)))
+		entries = binary.LittleEndian.AppendUint16(entries, uint16(len(key)))
+		data = append(data, key...)
+	}
+	for _, value := range values {
+		typ, encoded := encodeJSONValue(value)
+		entries = append(entries, typ)
+		if typ == jsonLiteral || typ == jsonInt16 {
+			entries = append(entries, encoded...)
+			if len(encoded) == 1 {
+				entries = append(entries, 0)
+			}
+			continue
+		}
+		entries = binary.LittleEndian.AppendUint16(entries, uint16(header+len(data)))
+		data = append(data, encoded...)
+	}
+
+	container := binary.LittleEndian.AppendUint16(nil, uint16(len(values)))
+	container = binary.LittleEndian.AppendUint16(container, uint16(header+len(data)))
+	container = append(container, entries...)
+	return append(container, data...)
+}
+
+func fixedInt(value uint64, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(value >> (8 * i))
+	}
+	return data
+}
+
+func bigEndian(value uint64, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[size-1-i] = byte(value >> (8 * i))
+	}
+	return data
+}
+
+func main() {
+	w := &writer{}
+	w.Write([]byte{0xfe, 'b', 'i', 'n'})
+	w.formatDescription()
+
+	var gno int64
+	transaction := func(write func(at time.Time)) {
+		gno++
+		at := startedAt.Add(time.Duration(gno-1) * time.Second)
+		w.gtid(at, gno)
+		write(at)
+	}
+	rows := func(at time.Time, eventType byte, images ...[]interface{}) {
+		w.tableMap(at, database, "resources", resourceColumns)
+		w.rows(at, eventType, resourceColumns, images...)
+	}
+
+	// 1: the DDL is committed by itself
+	transaction(func(at time.Time) {
+		w.query(at, database, "CREATE TABLE `change_feed_positions` (`server_id` int unsigned AUTO_INCREMENT,"+
+			"`gtid_set` text NOT NULL,`synced_at` datetime(3) NOT NULL,PRIMARY KEY (`server_id`))")
+	})
+	// 2: the deployment and the pod are created
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		rows(at, writeRowsEventV2, nginx.values())
+		rows(at, writeRowsEventV2, pod.values())
+		w.xid(at, uint64(gno))
+	})
+	// 3: the deployment is scaled
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		rows(at, updateRowsEventV2, nginx.values(), scaledNginx.values())
+		w.xid(at, uint64(gno))
+	})
+	// 4: the light update only writes the resource version and the synced_at
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		rows(at, updateRowsEventV2, scaledNginx.values(), resyncedNginx.values())
+		w.xid(at, uint64(gno))
+	})
+	// 5: the rows of the other table
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		w.tableMap(at, database, "event_stream_high_water_marks", highWaterMarkColumns)
+		w.rows(at, writeRowsEventV2, highWaterMarkColumns, []interface{}{"clusterpedia.mutations", at})
+		w.xid(at, uint64(gno))
+	})
+	// 6: the rows of the resources table of the other database
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		w.tableMap(at, "clusterpedia_archive", "resources", resourceColumns)
+		w.rows(at, writeRowsEventV2, resourceColumns, nginx.values())
+		w.xid(at, uint64(gno))
+	})
+	// 7: the row of the pod is replaced by the recreated pod
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		rows(at, updateRowsEventV2, pod.values(), recreatedPod.values())
+		w.xid(at, uint64(gno))
+	})
+	// 8: the deployment is deleted
+	transaction(func(at time.Time) {
+		w.query(at, database, "BEGIN")
+		rows(at, deleteRowsEventV2, resyncedNginx.values())
+		w.xid(at, uint64(gno))
+	})
+
+	if err := os.WriteFile("resources.binlog", w.Bytes(), 0o644); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND cluster IN ("cluster-1","cluster-2") AND namespace IN ("default","kube-system") AND name = "web"
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND cluster IN ('cluster-1','cluster-2') AND namespace IN ('default','kube-system') AND name = 'web'
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND cluster IN ('cluster-1','cluster-2') AND namespace IN ('default','kube-system') AND name = 'web'
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND cluster IN ('cluster-1','cluster-2') AND namespace IN ('default','kube-system') AND name = 'web'
@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND (JSON_EXTRACT(`object`,"$.\"spec\".\"nodeName\"") IS NULL OR CAST(JSON_EXTRACT(`object`,"$.\"spec\".\"nodeName\"") as TEXT) != "node-1") AND CAST(JSON_EXTRACT(`object`,"$.\"status\".\"phase\"") as TEXT) = "Running"
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND ("object" -> 'spec' ->> 'nodeName' IS NULL OR "object" -> 'spec' ->> 'nodeName' != 'node-1') AND "object" -> 'status' ->> 'phase' = 'Running'
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND (JSON_EXTRACT(`object`,'$."spec"."nodeName"') IS NULL OR JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."spec"."nodeName"')) != 'node-1') AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."status"."phase"')) = 'Running'
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND (JSON_EXTRACT(`object`,'$."spec"."nodeName"') IS NULL OR JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."spec"."nodeName"')) != 'node-1') AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."status"."phase"')) = 'Running'
@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND name LIKE "%prod%" AND name LIKE "%web%"
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND name LIKE '%prod%' AND name LIKE '%web%'
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND name LIKE '%prod%' AND name LIKE '%web%'
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND name LIKE '%prod%' AND name LIKE '%web%'
@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND CAST(JSON_EXTRACT(`object`,"$.\"metadata\".\"labels\".\"app\"") as TEXT) = "web" AND CAST(JSON_EXTRACT(`object`,"$.\"metadata\".\"labels\".\"canary\"") as TEXT) IS NULL AND CAST(JSON_EXTRACT(`object`,"$.\"metadata\".\"labels\".\"tier\"") as TEXT) IN ("backend","frontend")
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND "object" -> 'metadata' -> 'labels' ->> 'app' = 'web' AND "object" -> 'metadata' -> 'labels' ->> 'canary' IS NULL AND "object" -> 'metadata' -> 'labels' ->> 'tier' IN ('backend','frontend')
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."app"')) = 'web' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."canary"')) IS NULL AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."tier"')) IN ('backend','frontend')
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."app"')) = 'web' AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."canary"')) IS NULL AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$."metadata"."labels"."tier"')) IN ('backend','frontend')
@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND cluster = "cluster-1" LIMIT 10 OFFSET 20
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND cluster = 'cluster-1' LIMIT 10 OFFSET 20
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND cluster = 'cluster-1' LIMIT 10 OFFSET 20
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND cluster = 'cluster-1' LIMIT 10 OFFSET 20
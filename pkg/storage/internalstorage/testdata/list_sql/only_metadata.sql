@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND namespace = "default"
+-- postgres
+SELECT "group", version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->'metadata') as metadata FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND namespace = 'default'
+-- mysql-8.0.27
+SELECT `group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND namespace = 'default'
+-- mysql-5.7.22
+SELECT `group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND namespace = 'default'
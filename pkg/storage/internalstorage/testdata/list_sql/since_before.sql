@@ -0,0 +1,8 @@
+-- sqlite
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = "apps" AND `resource` = "deployments" AND `version` = "v1" AND created_at >= "2024-01-01 00:00:00" AND created_at < "2024-01-02 00:00:00"
+-- postgres
+SELECT "cluster","namespace","name","object","spec","status" FROM "resources" WHERE "group" = 'apps' AND "resource" = 'deployments' AND "version" = 'v1' AND created_at >= '2024-01-01 00:00:00' AND created_at < '2024-01-02 00:00:00'
+-- mysql-8.0.27
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND created_at >= '2024-01-01 00:00:00' AND created_at < '2024-01-02 00:00:00'
+-- mysql-5.7.22
+SELECT `cluster`,`namespace`,`name`,`object`,`spec`,`status` FROM `resources` WHERE `group` = 'apps' AND `resource` = 'deployments' AND `version` = 'v1' AND created_at >= '2024-01-01 00:00:00' AND created_at < '2024-01-02 00:00:00'
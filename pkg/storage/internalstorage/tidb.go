@@ -0,0 +1,82 @@
+package internalstorage
+
+import (
+	"database/sql"
+	"strings"
+
+	gmysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// tidbDMLBatchSize is the maximum number of the rows deleted by each statement on TiDB,
+// the large deletes are split to avoid the `transaction too large` error.
+const tidbDMLBatchSize = 1000
+
+// isTiDB returns true if the database is TiDB, the server version is queried by `SELECT VERSION()`
+// when the mysql dialector is initialized, e.g. `8.0.11-TiDB-v7.5.0`.
+func isTiDB(db *gorm.DB) bool {
+	dialector, ok := db.Dialector.(*gmysql.Dialector)
+	if !ok || dialector.Config == nil {
+		return false
+	}
+	return strings.Contains(dialector.ServerVersion, "TiDB")
+}
+
+// tidbResource is the model to migrate the resources table on TiDB, the primary key is allocated by AUTO_RANDOM
+// to scatter the inserts of the table instead of the hotspot of the AUTO_INCREMENT allocation.
+type tidbResource struct {
+	Resource
+
+	ID int64 `gorm:"primaryKey;type:bigint AUTO_RANDOM;autoIncrement:false"`
+}
+
+func (tidbResource) TableName() string {
+	return "resources"
+}
+
+// autoMigrate auto migrates the tables by the models, the resources table with the AUTO_RANDOM primary key
+// on TiDB is migrated by the tidbResource, and the table with the AUTO_INCREMENT primary key is kept as is.
+func autoMigrate(db *gorm.DB) error {
+	var resource interface{} = &Resource{}
+	if isTiDB(db) {
+		autoRandom, err := useAutoRandom(db)
+		if err != nil {
+			return err
+		}
+		if autoRandom {
+			resource = &tidbResource{}
+		}
+	}
+	return db.AutoMigrate(resource, &Checkpoint{})
+}
+
+func useAutoRandom(db *gorm.DB) (bool, error) {
+	if !db.Migrator().HasTable(&Resource{}) {
+		return true, nil
+	}
+
+	var info sql.NullString
+	result := db.Raw("SELECT TIDB_ROW_ID_SHARDING_INFO FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?", "resources").Scan(&info)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return strings.HasPrefix(info.String, "PK_AUTO_RANDOM_BITS"), nil
+}
+
+// deleteInBatches deletes the rows matched by the query, the rows are deleted by the bounded-size statements on TiDB.
+// The query must be a new session, e.g. the result of `db.WithContext(ctx)`, it is reused by the batches.
+func deleteInBatches(query *gorm.DB, value interface{}, conds map[string]interface{}) *gorm.DB {
+	if !isTiDB(query) {
+		return query.Where(conds).Delete(value)
+	}
+
+	var rowsAffected int64
+	for {
+		result := query.Where(conds).Limit(tidbDMLBatchSize).Delete(value)
+		rowsAffected += result.RowsAffected
+		if result.Error != nil || result.RowsAffected < tidbDMLBatchSize {
+			result.RowsAffected = rowsAffected
+			return result
+		}
+	}
+}
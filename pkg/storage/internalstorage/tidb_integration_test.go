@@ -0,0 +1,64 @@
+//go:build tidb
+
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm/logger"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// TestTiDBIntegration runs against the TiDB specified by the TIDB_DSN env, e.g. `root@tcp(127.0.0.1:4000)/clusterpedia`,
+// the database should be empty.
+func TestTiDBIntegration(t *testing.T) {
+	dsn := os.Getenv("TIDB_DSN")
+	if dsn == "" {
+		t.Skip("TIDB_DSN is not set")
+	}
+
+	db, err := openDB(DefaultDatabaseName, &Config{Type: "mysql", DSN: dsn}, logger.Discard)
+	require.NoError(t, err)
+	require.True(t, isTiDB(db))
+
+	autoRandom, err := useAutoRandom(db)
+	require.NoError(t, err)
+	assert.True(t, autoRandom)
+
+	// migrating the existing table keeps the AUTO_RANDOM primary key
+	require.NoError(t, autoMigrate(db))
+
+	const cluster = "tidb-integration"
+	resources := make([]Resource, 0, 2*tidbDMLBatchSize+10)
+	for i := 0; i < cap(resources); i++ {
+		resources = append(resources, Resource{
+			Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+			Cluster: cluster, Namespace: "default", Name: fmt.Sprintf("deploy-%d", i),
+			UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1",
+			Object: []byte("{}"), CreatedAt: time.Now(),
+		})
+	}
+	// the names share the prefix covered by the index of the key, they are kept unique by the key hash
+	for _, name := range []string{strings.Repeat("a", 100) + "-request-1", strings.Repeat("a", 100) + "-request-2"} {
+		resources = append(resources, Resource{
+			Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment",
+			Cluster: cluster, Namespace: "default", Name: name, KeyHash: resourceKeyHash("apps", "v1", "deployments", cluster, "default", name),
+			UID: types.UID(name), ResourceVersion: "1", Object: []byte("{}"), CreatedAt: time.Now(),
+		})
+	}
+	require.NoError(t, db.CreateInBatches(resources, 500).Error)
+
+	factory := &StorageFactory{db: db}
+	require.NoError(t, factory.CleanCluster(context.Background(), cluster))
+
+	var count int64
+	require.NoError(t, db.Model(&Resource{}).Where(map[string]interface{}{"cluster": cluster}).Count(&count).Error)
+	assert.Zero(t, count)
+}
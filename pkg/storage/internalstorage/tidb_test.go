@@ -0,0 +1,57 @@
+package internalstorage
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const tidbVersion = "8.0.11-TiDB-v7.5.0"
+
+func TestIsTiDB(t *testing.T) {
+	tidb, _, err := newMockedMySQLDB(tidbVersion)
+	require.NoError(t, err)
+	assert.True(t, isTiDB(tidb))
+
+	for _, version := range mysqlVersions {
+		assert.False(t, isTiDB(mysqlDBs[version]), version)
+	}
+	assert.False(t, isTiDB(postgresDB))
+}
+
+func TestTiDBResourceTable(t *testing.T) {
+	tidb, mock, err := newMockedMySQLDB(tidbVersion)
+	require.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE `resources` (`id` bigint AUTO_RANDOM,")).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, tidb.Migrator().CreateTable(&tidbResource{}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteInBatches(t *testing.T) {
+	tidb, mock, err := newMockedMySQLDB(tidbVersion)
+	require.NoError(t, err)
+
+	statement := regexp.QuoteMeta("DELETE FROM `resources` WHERE `cluster` = ? LIMIT 1000")
+	mock.ExpectExec(statement).WithArgs("cluster-1").WillReturnResult(sqlmock.NewResult(0, tidbDMLBatchSize))
+	mock.ExpectExec(statement).WithArgs("cluster-1").WillReturnResult(sqlmock.NewResult(0, tidbDMLBatchSize))
+	mock.ExpectExec(statement).WithArgs("cluster-1").WillReturnResult(sqlmock.NewResult(0, 10))
+
+	result := deleteInBatches(tidb.Session(&gorm.Session{SkipDefaultTransaction: true}), &Resource{}, map[string]interface{}{"cluster": "cluster-1"})
+	require.NoError(t, result.Error)
+	assert.Equal(t, int64(2*tidbDMLBatchSize+10), result.RowsAffected)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mysql, mock, err := newMockedMySQLDB(mysqlVersions[0])
+	require.NoError(t, err)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM `resources` WHERE `cluster` = ?") + "$").WithArgs("cluster-1").WillReturnResult(sqlmock.NewResult(0, 2010))
+
+	result = deleteInBatches(mysql.Session(&gorm.Session{SkipDefaultTransaction: true}), &Resource{}, map[string]interface{}{"cluster": "cluster-1"})
+	require.NoError(t, result.Error)
+	assert.Equal(t, int64(2010), result.RowsAffected)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,60 @@
+package internalstorage
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"k8s.io/component-base/tracing"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
+)
+
+// traceLogThreshold is the threshold of the span duration to log the k8s.io/utils/trace spans.
+const traceLogThreshold = 500 * time.Millisecond
+
+// endSpan ends the span, the error is recorded and the status of the span is set to error.
+func endSpan(ctx context.Context, span *tracing.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+	}
+	span.End(traceLogThreshold)
+}
+
+// setSpanAttributes sets the attributes of the span in the ctx, such as the rows affected by the query.
+func setSpanAttributes(ctx context.Context, attributes ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attributes...)
+}
+
+// queryContext returns the context of the query, the spans of the query building are the children of its span.
+func queryContext(query *gorm.DB) context.Context {
+	if query.Statement != nil && query.Statement.Context != nil {
+		return query.Statement.Context
+	}
+	return context.Background()
+}
+
+// listFilters returns the filters of the list options that are pushed to SQL,
+// and the filters that are not supported by the SQL and need to be evaluated in memory.
+func listFilters(opts *internal.ListOptions, options querybuilder.Options) querybuilder.Filters {
+	filters := querybuilder.Describe(opts, options)
+	if len(opts.ClusterNames) == 1 && (opts.OwnerUID != "" || opts.OwnerName != "") {
+		filters.SQL = append(filters.SQL, "owner")
+	} else if opts.OwnerUID != "" || opts.OwnerName != "" {
+		// the owner is only supported when listing the resources of a single cluster
+		filters.PostFilter = append(filters.PostFilter, "owner")
+	}
+	return filters
+}
+
+func listFilterAttributes(filters querybuilder.Filters) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.StringSlice("filters.sql", filters.SQL),
+		attribute.StringSlice("filters.memory", filters.PostFilter),
+	}
+}
@@ -0,0 +1,127 @@
+package internalstorage
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+// recordingExporter records the ended spans in memory.
+type recordingExporter struct {
+	lock  sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (e *recordingExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *recordingExporter) Shutdown(_ context.Context) error { return nil }
+
+func (e *recordingExporter) span(t *testing.T, name string) sdktrace.ReadOnlySpan {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, span := range e.spans {
+		if span.Name() == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q is not recorded", name)
+	return nil
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes() {
+		attributes[attr.Key] = attr.Value
+	}
+	return attributes
+}
+
+func TestResourceStorageTracing(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	traced := newTracingResourceStorage(config, rs)
+
+	exporter := &recordingExporter{}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx, root := provider.Tracer("test").Start(context.Background(), "root")
+	defer root.End()
+
+	obj := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo", UID: "foo-uid"},
+	}
+	require.NoError(t, traced.Create(ctx, "cluster-1", obj))
+	require.NoError(t, traced.Update(ctx, "cluster-1", obj))
+	require.NoError(t, traced.Delete(ctx, "cluster-1", obj))
+	// the resource storage doesn't start the spans of the operations by itself
+	require.NoError(t, rs.Create(ctx, "cluster-1", obj))
+	assert.Len(t, exporter.spans, 3)
+
+	for _, name := range []string{"Create resource", "Update resource", "Delete resource"} {
+		span := exporter.span(t, name)
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID(), name)
+		assert.Equal(t, codes.Unset, span.Status().Code, name)
+
+		attributes := spanAttributes(span)
+		assert.Equal(t, "cluster-1", attributes["cluster"].AsString(), name)
+		assert.Equal(t, "apps/v1, Resource=deployments", attributes["gvr"].AsString(), name)
+		assert.Equal(t, "default", attributes["namespace"].AsString(), name)
+		assert.Equal(t, "foo", attributes["name"].AsString(), name)
+		assert.Equal(t, int64(1), attributes["rows_affected"].AsInt64(), name)
+	}
+
+	// the kind is required, the span ends with the error status
+	err = traced.Create(ctx, "cluster-1", &appsv1.Deployment{})
+	assert.Error(t, err)
+	var errorSpan sdktrace.ReadOnlySpan
+	for _, span := range exporter.spans {
+		if span.Name() == "Create resource" && span.Status().Code == codes.Error {
+			errorSpan = span
+		}
+	}
+	require.NotNil(t, errorSpan)
+	assert.Equal(t, err.Error(), errorSpan.Status().Description)
+	assert.Len(t, errorSpan.Events(), 1)
+
+	err = traced.List(ctx, &appsv1.DeploymentList{}, &internal.ListOptions{
+		ClusterNames: []string{"cluster-1"},
+		Namespaces:   []string{"default"},
+		OwnerName:    "owner",
+	})
+	require.NoError(t, err)
+
+	listSpan := exporter.span(t, "List resources")
+	assert.Equal(t, int64(0), spanAttributes(listSpan)["count"].AsInt64())
+
+	applySpan := exporter.span(t, "Apply list options to resource query")
+	assert.Equal(t, listSpan.SpanContext().SpanID(), applySpan.Parent().SpanID())
+	assert.Equal(t, []string{"cluster", "namespace", "owner"}, spanAttributes(applySpan)["filters.sql"].AsStringSlice())
+	assert.Empty(t, spanAttributes(applySpan)["filters.memory"].AsStringSlice())
+
+	ownerSpan := exporter.span(t, "Build owner subquery")
+	assert.Equal(t, applySpan.SpanContext().SpanID(), ownerSpan.Parent().SpanID())
+	assert.Equal(t, "owner", spanAttributes(ownerSpan)["owner_name"].AsString())
+}
@@ -19,15 +19,48 @@ import (
 
 type Object interface {
 	GetResourceType() ResourceType
+	GetIdentity() ResourceIdentity
 	ConvertToUnstructured() (*unstructured.Unstructured, error)
 	ConvertTo(codec runtime.Codec, object runtime.Object) (runtime.Object, error)
 }
 
+// ObjectList scans the rows of the list query into the objects.
 type ObjectList interface {
+	// From selects the scanned columns of the list on the query and scans its rows, the other clauses of the query
+	// are kept, e.g. the order by expressions which aren't selected, so the scanned columns don't depend on the order.
 	From(db *gorm.DB) error
 	Items() []Object
 }
 
+// ObjectStream scans the rows of the query and passes the objects to fn one by one,
+// so the objects are not materialized at once.
+type ObjectStream interface {
+	Stream(db *gorm.DB, fn func(Object) error) error
+}
+
+// streamRows scans the rows into the objects by the scan, the rows are scanned by gorm if the scan is nil.
+func streamRows[T Object](db *gorm.DB, scan func(rows *sql.Rows, object *T) error, fn func(Object) error) error {
+	rows, err := db.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if scan == nil {
+		scan = func(rows *sql.Rows, object *T) error { return db.ScanRows(rows, object) }
+	}
+	for rows.Next() {
+		var object T
+		if err := scan(rows, &object); err != nil {
+			return err
+		}
+		if err := fn(object); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
 type ResourceType struct {
 	Group    string
 	Version  string
@@ -35,6 +68,21 @@ type ResourceType struct {
 	Kind     string
 }
 
+// ResourceIdentity identifies the row of the resource, it is selected alongside the object
+// so that the row of the object which can't be converted can be found.
+type ResourceIdentity struct {
+	Cluster   string
+	Namespace string
+	Name      string
+}
+
+func (id ResourceIdentity) String() string {
+	if id.Namespace == "" {
+		return id.Cluster + "/" + id.Name
+	}
+	return id.Cluster + "/" + id.Namespace + "/" + id.Name
+}
+
 func (rt ResourceType) Empty() bool {
 	return rt == ResourceType{}
 }
@@ -50,20 +98,42 @@ func (rt ResourceType) GroupVersionResource() schema.GroupVersionResource {
 type Resource struct {
 	ID uint `gorm:"primaryKey"`
 
-	Group    string `gorm:"size:63;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
-	Version  string `gorm:"size:15;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
-	Resource string `gorm:"size:63;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
+	Group    string `gorm:"size:63;not null;index:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
+	Version  string `gorm:"size:15;not null;index:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
+	Resource string `gorm:"size:63;not null;index:uni_group_version_resource_cluster_namespace_name;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
 	Kind     string `gorm:"size:63;not null"`
 
-	Cluster         string    `gorm:"size:253;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name,length:100;index:idx_cluster"`
-	Namespace       string    `gorm:"size:253;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name,length:50;index:idx_group_version_resource_namespace_name"`
-	Name            string    `gorm:"size:253;not null;uniqueIndex:uni_group_version_resource_cluster_namespace_name,length:100;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
+	Cluster         string    `gorm:"size:253;not null;index:uni_group_version_resource_cluster_namespace_name,length:100;index:idx_cluster"`
+	Namespace       string    `gorm:"size:253;not null;index:uni_group_version_resource_cluster_namespace_name,length:50;index:idx_group_version_resource_namespace_name"`
+	Name            string    `gorm:"size:253;not null;index:uni_group_version_resource_cluster_namespace_name,length:100;index:idx_group_version_resource_namespace_name;index:idx_group_version_resource_name"`
 	OwnerUID        types.UID `gorm:"column:owner_uid;size:36;not null;default:''"`
 	UID             types.UID `gorm:"size:36;not null"`
-	ResourceVersion string    `gorm:"size:30;not null"`
+	ResourceVersion string    `gorm:"size:255;not null"`
 
 	Object datatypes.JSON `gorm:"not null"`
 
+	// Metadata is the metadata of the object written by the Create and Update to serve the OnlyMetadata queries
+	// without extracting it from the object, it is null for the rows not yet backfilled by the rebuilding.
+	Metadata datatypes.JSON
+
+	// Spec and Status are split from the object of the resources configured by the SplitObjects,
+	// the object is reassembled when it is read. They are null for the objects which aren't split.
+	Spec   datatypes.JSON
+	Status datatypes.JSON
+
+	// Checksum is the checksum of the object written by the Create and Update to detect the corrupted objects,
+	// it is null for the rows written before the migration 5.
+	Checksum sql.NullInt64
+
+	// ContentHash is the hash of the object without the resource version and the managed fields, the update of
+	// the object with the same content hash only writes the resource version. It is null for the rows written before the migration 7.
+	ContentHash sql.NullString `gorm:"size:64"`
+
+	// KeyHash is the hash of the group, version, resource, cluster, namespace and name, whose unique index keeps the
+	// resources unique, since the index of the key only covers the prefixes of the cluster, namespace and name on mysql.
+	// It is null for the rows written before the migration 11 on postgres and sqlite, whose index of the key is unique.
+	KeyHash sql.NullString `gorm:"size:64;uniqueIndex:uni_resources_key_hash"`
+
 	CreatedAt time.Time `gorm:"not null"`
 	SyncedAt  time.Time `gorm:"not null;autoUpdateTime"`
 	DeletedAt sql.NullTime
@@ -86,6 +156,10 @@ func (res Resource) GetResourceType() ResourceType {
 	}
 }
 
+func (res Resource) GetIdentity() ResourceIdentity {
+	return ResourceIdentity{Cluster: res.Cluster, Namespace: res.Namespace, Name: res.Name}
+}
+
 func (res Resource) ConvertToUnstructured() (*unstructured.Unstructured, error) {
 	obj := &unstructured.Unstructured{}
 	if err := json.Unmarshal(res.Object, obj); err != nil {
@@ -100,7 +174,8 @@ func (res Resource) ConvertTo(codec runtime.Codec, object runtime.Object) (runti
 }
 
 type ResourceMetadata struct {
-	ResourceType `gorm:"embedded"`
+	ResourceType     `gorm:"embedded"`
+	ResourceIdentity `gorm:"embedded"`
 
 	Metadata datatypes.JSON
 }
@@ -141,6 +216,12 @@ func (data ResourceMetadata) ConvertTo(codec runtime.Codec, object runtime.Objec
 	if err := json.Unmarshal(data.Metadata, &metadata); err != nil {
 		return nil, err
 	}
+	if partial, ok := object.(*metav1.PartialObjectMetadata); ok {
+		partial.TypeMeta = metav1.TypeMeta{APIVersion: metav1.SchemeGroupVersion.String(), Kind: "PartialObjectMetadata"}
+		partial.ObjectMeta = metadata
+		return partial, nil
+	}
+
 	v := reflect.ValueOf(object)
 	if v.Kind() != reflect.Ptr || v.IsNil() {
 		return nil, errors.New("object is nil or not pointer")
@@ -156,6 +237,10 @@ func (data ResourceMetadata) GetResourceType() ResourceType {
 	return data.ResourceType
 }
 
+func (data ResourceMetadata) GetIdentity() ResourceIdentity {
+	return data.ResourceIdentity
+}
+
 type Bytes datatypes.JSON
 
 func (bytes *Bytes) Scan(data any) error {
@@ -183,6 +268,36 @@ func (bytes Bytes) GetResourceType() ResourceType {
 	return ResourceType{}
 }
 
+func (bytes Bytes) GetIdentity() ResourceIdentity {
+	return ResourceIdentity{}
+}
+
+// ResourceBytes is the encoded object with the identity of its row.
+type ResourceBytes struct {
+	ResourceIdentity `gorm:"embedded"`
+
+	Object   Bytes
+	Spec     Bytes
+	Status   Bytes
+	Checksum sql.NullInt64
+}
+
+func (data ResourceBytes) ConvertToUnstructured() (*unstructured.Unstructured, error) {
+	return data.Object.ConvertToUnstructured()
+}
+
+func (data ResourceBytes) ConvertTo(codec runtime.Codec, object runtime.Object) (runtime.Object, error) {
+	return data.Object.ConvertTo(codec, object)
+}
+
+func (data ResourceBytes) GetResourceType() ResourceType {
+	return ResourceType{}
+}
+
+func (data ResourceBytes) GetIdentity() ResourceIdentity {
+	return data.ResourceIdentity
+}
+
 type ResourceList []Resource
 
 func (list *ResourceList) From(db *gorm.DB) error {
@@ -194,6 +309,10 @@ func (list *ResourceList) From(db *gorm.DB) error {
 	return nil
 }
 
+func (list *ResourceList) Stream(db *gorm.DB, fn func(Object) error) error {
+	return streamRows[Resource](db, nil, fn)
+}
+
 func (list ResourceList) Items() []Object {
 	objects := make([]Object, 0, len(list))
 	for _, object := range list {
@@ -204,23 +323,36 @@ func (list ResourceList) Items() []Object {
 
 type ResourceMetadataList []ResourceMetadata
 
-func (list *ResourceMetadataList) From(db *gorm.DB) error {
+// resourceMetadataColumns selects the metadata column, and falls back to extracting
+// the metadata from the object for the rows whose metadata column is not yet backfilled.
+func resourceMetadataColumns(db *gorm.DB) string {
 	switch db.Dialector.Name() {
 	case "sqlite", "sqlite3", "mysql":
-		db = db.Select("`group`, version, resource, kind, object->>'$.metadata' as metadata")
+		return "`group`, version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->>'$.metadata') as metadata"
 	case "postgres":
-		db = db.Select(`"group", version, resource, kind, object->>'metadata' as metadata`)
+		return `"group", version, resource, kind, cluster, namespace, name, COALESCE(metadata, object->'metadata') as metadata`
 	default:
 		panic("storage: only support sqlite3, mysql or postgres")
 	}
+}
+
+func selectResourceMetadata(db *gorm.DB) *gorm.DB {
+	return db.Select(resourceMetadataColumns(db))
+}
+
+func (list *ResourceMetadataList) From(db *gorm.DB) error {
 	metadatas := []ResourceMetadata{}
-	if result := db.Find(&metadatas); result.Error != nil {
+	if result := selectResourceMetadata(db).Find(&metadatas); result.Error != nil {
 		return result.Error
 	}
 	*list = metadatas
 	return nil
 }
 
+func (list *ResourceMetadataList) Stream(db *gorm.DB, fn func(Object) error) error {
+	return streamRows[ResourceMetadata](selectResourceMetadata(db), nil, fn)
+}
+
 func (list ResourceMetadataList) Items() []Object {
 	objects := make([]Object, 0, len(list))
 	for _, object := range list {
@@ -229,18 +361,43 @@ func (list ResourceMetadataList) Items() []Object {
 	return objects
 }
 
-type BytesList []Bytes
+// BytesList is the list of the encoded objects, the checksums are selected only if they are verified,
+// and the spec and status are selected only if the columns exist.
+type BytesList struct {
+	withChecksum bool
+	withSplit    bool
+	items        []ResourceBytes
+}
+
+// selectResourceBytes selects the object with the identity of its row, the checksum if it is verified,
+// and the spec and status split from the object.
+func (list *BytesList) selectResourceBytes(db *gorm.DB) *gorm.DB {
+	columns := []string{"cluster", "namespace", "name", "object"}
+	if list.withSplit {
+		columns = append(columns, "spec", "status")
+	}
+	if list.withChecksum {
+		columns = append(columns, "checksum")
+	}
+	return db.Select(columns)
+}
 
 func (list *BytesList) From(db *gorm.DB) error {
-	if result := db.Select("object").Find(list); result.Error != nil {
+	items := []ResourceBytes{}
+	if result := list.selectResourceBytes(db).Find(&items); result.Error != nil {
 		return result.Error
 	}
+	list.items = items
 	return nil
 }
 
-func (list BytesList) Items() []Object {
-	objects := make([]Object, 0, len(list))
-	for _, object := range list {
+func (list *BytesList) Stream(db *gorm.DB, fn func(Object) error) error {
+	return streamRows[ResourceBytes](list.selectResourceBytes(db), nil, fn)
+}
+
+func (list *BytesList) Items() []Object {
+	objects := make([]Object, 0, len(list.items))
+	for _, object := range list.items {
 		objects = append(objects, object)
 	}
 	return objects
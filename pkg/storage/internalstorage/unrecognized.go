@@ -0,0 +1,74 @@
+package internalstorage
+
+import (
+	"fmt"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/scheme"
+)
+
+// UnrecognizedObjectError is the error of the stored object whose version isn't recognized by the codecs or the scheme,
+// e.g. the rows of a CRD version which is removed from the scheme. Type is the type of the row the object is stored in.
+type UnrecognizedObjectError struct {
+	StoredAs schema.GroupVersionKind
+	Type     ResourceType
+
+	Err error
+}
+
+func (e *UnrecognizedObjectError) Error() string {
+	return fmt.Sprintf("stored object version not recognized; stored as %s in the row of %s, Kind=%s: %v",
+		e.StoredAs, e.Type.GroupVersionResource(), e.Type.Kind, e.Err)
+}
+
+func (e *UnrecognizedObjectError) Unwrap() error {
+	return e.Err
+}
+
+// convertUnrecognized converts the stored object whose type isn't registered in the codecs into the typed object by the scheme,
+// it is decoded into the unstructured first. If the scheme doesn't recognize the type either, the UnrecognizedObjectError is
+// returned with the stored type, the rows without the type, e.g. the rows of BytesList, are reported in the version.
+func (s *ResourceStorage) convertUnrecognized(object Object, into runtime.Object, version string, err error) (runtime.Object, error) {
+	if !runtime.IsNotRegisteredError(err) {
+		return nil, err
+	}
+	if _, ok := into.(runtime.Unstructured); ok || into == nil {
+		return nil, err
+	}
+
+	u, uerr := object.ConvertToUnstructured()
+	if uerr != nil {
+		return nil, err
+	}
+	gvk := u.GroupVersionKind()
+	if cerr := convertByScheme(u.Object, gvk, into); cerr == nil {
+		return into, nil
+	}
+
+	rowType := object.GetResourceType()
+	if rowType.Empty() {
+		rowType = ResourceType{Group: s.storageGroupResource.Group, Version: version, Resource: s.storageGroupResource.Resource, Kind: gvk.Kind}
+	}
+	return nil, &UnrecognizedObjectError{StoredAs: gvk, Type: rowType, Err: err}
+}
+
+// convertByScheme converts the unstructured content of the gvk into the typed object by the scheme.
+func convertByScheme(content map[string]interface{}, gvk schema.GroupVersionKind, into runtime.Object) error {
+	typed, err := scheme.LegacyResourceScheme.New(gvk)
+	if err != nil {
+		return err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, typed); err != nil {
+		return err
+	}
+
+	// the scheme has no conversion between the same types
+	if reflect.TypeOf(typed) == reflect.TypeOf(into) {
+		reflect.ValueOf(into).Elem().Set(reflect.ValueOf(typed).Elem())
+		return nil
+	}
+	return scheme.LegacyResourceScheme.Convert(typed, into, nil)
+}
@@ -0,0 +1,92 @@
+package internalstorage
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/warning"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// notRegisteredCodec is the codec which doesn't recognize any stored version.
+type notRegisteredCodec struct {
+	runtime.Codec
+}
+
+func (c notRegisteredCodec) Decode(data []byte, _ *schema.GroupVersionKind, _ runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	return nil, nil, runtime.NewNotRegisteredErrForKind("test", appsv1.SchemeGroupVersion.WithKind("Deployment"))
+}
+
+func TestUnrecognizedStoredVersion(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newPreparedTestResourceStorage(t, db, false)
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name), ResourceVersion: "1"},
+		}))
+	}
+	// the object is stored in the version which is absent from the scheme
+	require.NoError(t, db.Model(&Resource{}).Where("name = ?", "b").
+		Update("object", `{"apiVersion":"apps/v1alpha9","kind":"Deployment","metadata":{"namespace":"default","name":"b"}}`).Error)
+	storedAs := schema.GroupVersionKind{Group: "apps", Version: "v1alpha9", Kind: "Deployment"}
+
+	t.Run("get", func(t *testing.T) {
+		err := rs.Get(context.Background(), "cluster-1", "default", "b", &appsv1.Deployment{})
+		var decodeErr *ObjectDecodeError
+		require.ErrorAs(t, err, &decodeErr)
+		assert.Equal(t, ResourceIdentity{Cluster: "cluster-1", Namespace: "default", Name: "b"}, decodeErr.Row)
+
+		var unrecognized *UnrecognizedObjectError
+		require.ErrorAs(t, err, &unrecognized)
+		assert.Equal(t, storedAs, unrecognized.StoredAs)
+		assert.Equal(t, ResourceType{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment"}, unrecognized.Type)
+		assert.ErrorContains(t, err, "stored object version not recognized; stored as apps/v1alpha9, Kind=Deployment")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		var unrecognized *UnrecognizedObjectError
+		assert.ErrorAs(t, rs.List(context.Background(), &appsv1.DeploymentList{}, &internal.ListOptions{}), &unrecognized)
+
+		var warnings recordingWarnings
+		ctx := warning.WithWarningRecorder(context.Background(), &warnings)
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, rs.List(ctx, list, &internal.ListOptions{URLQuery: url.Values{URLQuerySkipUndecodable: []string{"true"}}}))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, "a", list.Items[0].Name)
+		assert.Contains(t, warnings, "1 rows are skipped since their objects can't be decoded")
+
+		// the unstructured list doesn't need the scheme
+		ulist := &unstructured.UnstructuredList{}
+		require.NoError(t, rs.List(context.Background(), ulist, &internal.ListOptions{}))
+		require.Len(t, ulist.Items, 2)
+		assert.Equal(t, "apps/v1alpha9", ulist.Items[1].GetAPIVersion())
+	})
+
+	t.Run("converted by the scheme", func(t *testing.T) {
+		fallback := newPreparedTestResourceStorage(t, db, false)
+		fallback.codec = notRegisteredCodec{fallback.codec}
+
+		got := &appsv1.Deployment{}
+		require.NoError(t, fallback.Get(context.Background(), "cluster-1", "default", "a", got))
+		assert.Equal(t, types.UID("a"), got.UID)
+
+		list := &appsv1.DeploymentList{}
+		require.NoError(t, fallback.List(context.Background(), list, &internal.ListOptions{URLQuery: url.Values{URLQuerySkipUndecodable: []string{"true"}}}))
+		require.Len(t, list.Items, 1)
+		assert.Equal(t, "a", list.Items[0].Name)
+	})
+}
@@ -19,9 +19,11 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/api/clusterpedia/fields"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage/internalstorage/querybuilder"
 )
 
 func toFindQuery[P any](db *gorm.DB, options P, applyFn func(*gorm.DB, P) (*gorm.DB, error), dryRun bool) (*gorm.DB, error) {
@@ -373,6 +375,55 @@ func TestApplyListOptionsToQuery_LabelSelector(t *testing.T) {
 	}
 }
 
+func TestApplyListOptionsToQuery_AnnotationSelector(t *testing.T) {
+	tests := []struct {
+		name               string
+		annotationSelector string
+		expected           expected
+	}{
+		{
+			"equal with dots and slash",
+			"example.com/team=payments",
+			expected{
+				`SELECT * FROM "resources" WHERE "object" -> 'metadata' -> 'annotations' ->> 'example.com/team' = 'payments'`,
+				"SELECT * FROM `resources` WHERE JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"annotations\".\"example.com/team\"')) = 'payments'",
+				"",
+			},
+		},
+		{
+			"exist and not exist",
+			"example.com/owner,!deprecated",
+			expected{
+				`SELECT * FROM "resources" WHERE "object" -> 'metadata' -> 'annotations' ->> 'deprecated' IS NULL AND "object" -> 'metadata' -> 'annotations' ->> 'example.com/owner' IS NOT NULL`,
+				"SELECT * FROM `resources` WHERE JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"annotations\".\"deprecated\"')) IS NULL AND JSON_UNQUOTE(JSON_EXTRACT(`object`,'$.\"metadata\".\"annotations\".\"example.com/owner\"')) IS NOT NULL",
+				"",
+			},
+		},
+		{
+			"invalid selector",
+			"example.com//team=payments",
+			expected{
+				"",
+				"",
+				`ListOptions.clusterpedia.io "urlQuery" is invalid: annotationSelector: Invalid value: "example.com//team=payments": ` + invalidKeyError(t, "example.com//team"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		listOptions := &internal.ListOptions{
+			URLQuery: url.Values{URLQueryAnnotationSelector: []string{test.annotationSelector}},
+		}
+		testApplyListOptionsToQuery(t, test.name, listOptions, test.expected)
+	}
+}
+
+func invalidKeyError(t *testing.T, key string) string {
+	_, err := labels.Parse(key)
+	require.Error(t, err)
+	return err.Error()
+}
+
 func TestApplyListOptionsToQuery_EnhancedFieldSelector(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -500,6 +551,7 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 	tests := []struct {
 		name     string
 		orderby  []internal.OrderBy
+		rawSQL   bool
 		expected expected
 	}{
 		{
@@ -510,6 +562,7 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 				{Field: "cluster"},
 				{Field: "resource_version"},
 			},
+			false,
 			expected{
 				`SELECT * FROM "resources" ORDER BY namespace,name,cluster,CAST(resource_version as decimal)`,
 				"SELECT * FROM `resources` ORDER BY namespace,name,cluster,CAST(resource_version as decimal)",
@@ -524,6 +577,7 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 				{Field: "cluster", Desc: true},
 				{Field: "resource_version", Desc: true},
 			},
+			false,
 			expected{
 				`SELECT * FROM "resources" ORDER BY namespace,name DESC,cluster DESC,CAST(resource_version as decimal) DESC`,
 				"SELECT * FROM `resources` ORDER BY namespace,name DESC,cluster DESC,CAST(resource_version as decimal) DESC",
@@ -535,6 +589,7 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 			[]internal.OrderBy{
 				{Field: "JSON_EXTRACT(object,'$.status.podIP')"},
 			},
+			true,
 			expected{
 				`SELECT * FROM "resources" ORDER BY JSON_EXTRACT(object,'$.status.podIP')`,
 				"SELECT * FROM `resources` ORDER BY JSON_EXTRACT(object,'$.status.podIP')",
@@ -546,6 +601,7 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 			[]internal.OrderBy{
 				{Field: "JSON_EXTRACT(object,'$.status.podIP')", Desc: true},
 			},
+			true,
 			expected{
 				`SELECT * FROM "resources" ORDER BY JSON_EXTRACT(object,'$.status.podIP') DESC`,
 				"SELECT * FROM `resources` ORDER BY JSON_EXTRACT(object,'$.status.podIP') DESC",
@@ -558,18 +614,35 @@ func TestApplyListOptionsToQuery_OrderBy(t *testing.T) {
 				{Field: "JSON_EXTRACT(object,'$.status.podIP')", Desc: true},
 				{Field: "name"},
 			},
+			true,
 			expected{
 				`SELECT * FROM "resources" ORDER BY JSON_EXTRACT(object,'$.status.podIP') DESC,name`,
 				"SELECT * FROM `resources` ORDER BY JSON_EXTRACT(object,'$.status.podIP') DESC,name",
 				"",
 			},
 		},
+		{
+			"order by custom fields without raw sql",
+			[]internal.OrderBy{
+				{Field: "name"},
+				{Field: "JSON_EXTRACT(object,'$.status.podIP')"},
+			},
+			false,
+			expected{
+				"",
+				"",
+				`orderby[1].field: Unsupported value: "JSON_EXTRACT(object,'$.status.podIP')": supported values: "cluster", "created_at", "name", "namespace", "resource_version"`,
+			},
+		},
 	}
 
 	for _, test := range tests {
+		require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set(fmt.Sprintf("AllowRawSQLQuery=%t", test.rawSQL)))
+
 		listOptions := &internal.ListOptions{OrderBy: test.orderby}
 		testApplyListOptionsToQuery(t, test.name, listOptions, test.expected)
 	}
+	require.NoError(t, utilfeature.DefaultMutableFeatureGate.Set("AllowRawSQLQuery=false"))
 }
 
 func TestApplyListOptionsToQuery_Page(t *testing.T) {
@@ -1288,7 +1361,7 @@ func TestApplyListOptionsURLQueryParameterizedQueryToWhereClause(t *testing.T) {
 				tc.whereSQLParams,
 				tc.testSQLQueriesAssertionTestCase,
 				func(query *gorm.DB, params URLQueryWhereSQLParams) (*gorm.DB, error) {
-					return applyListOptionsURLQueryParameterizedQueryToWhereClause(query, params), nil
+					return querybuilder.ApplyParameterizedWhereSQL(query, params), nil
 				},
 			)
 		})
@@ -1302,7 +1375,7 @@ func TestApplyListOptionsURLQueryParameterizedQueryToWhereClause(t *testing.T) {
 				tc.whereSQLJSONParams,
 				tc.testSQLQueriesAssertionTestCase,
 				func(query *gorm.DB, params URLQueryWhereSQLParams) (*gorm.DB, error) {
-					return applyListOptionsURLQueryParameterizedQueryToWhereClause(query, params), nil
+					return querybuilder.ApplyParameterizedWhereSQL(query, params), nil
 				},
 			)
 		})
@@ -1381,7 +1454,7 @@ func TestApplyListOptionsURLQueryParameterizedQueryToWhereClause(t *testing.T) {
 				tc.whereSQLJSONParams,
 				tc.testSQLQueriesAssertionTestCase,
 				func(query *gorm.DB, params URLQueryWhereSQLParams) (*gorm.DB, error) {
-					return applyListOptionsURLQueryParameterizedQueryToWhereClause(query, params), nil
+					return querybuilder.ApplyParameterizedWhereSQL(query, params), nil
 				},
 			)
 		})
@@ -1524,7 +1597,7 @@ func TestApplyListOptionsURLQueryToWhereClause(t *testing.T) {
 				tc.urlValues,
 				tc.testSQLQueriesAssertionTestCase,
 				func(query *gorm.DB, urlValues url.Values) (*gorm.DB, error) {
-					return applyListOptionsURLQueryToWhereClause(query, urlValues, tc.allowRawSQLQueryEnabled, tc.allowParameterizedSQLQueryEnabled)
+					return querybuilder.ApplyURLQueryWhereSQL(query, urlValues, tc.allowRawSQLQueryEnabled, tc.allowParameterizedSQLQueryEnabled)
 				},
 			)
 		})
@@ -1642,7 +1715,7 @@ func TestApplyListOptionsURLQueryToWhereClause(t *testing.T) {
 				tc.urlValues,
 				tc.testSQLQueriesAssertionTestCase,
 				func(query *gorm.DB, urlValues url.Values) (*gorm.DB, error) {
-					return applyListOptionsURLQueryToWhereClause(query, urlValues, tc.allowRawSQLQueryEnabled, tc.allowParameterizedSQLQueryEnabled)
+					return querybuilder.ApplyURLQueryWhereSQL(query, urlValues, tc.allowRawSQLQueryEnabled, tc.allowParameterizedSQLQueryEnabled)
 				},
 			)
 		})
@@ -1658,3 +1731,45 @@ func assertError(t *testing.T, expectedErr string, err error) {
 		t.Errorf("expected nil error, but got: %#v", err)
 	}
 }
+
+func FuzzApplyListOptionsToQuery(f *testing.F) {
+	for _, seed := range []string{"default", "'; DROP TABLE resources; --", `" OR 1=1 --`, "name) DESC, (SELECT 1", "\x00\n", "$1", "?"} {
+		f.Add(seed)
+	}
+
+	applyFn := func(query *gorm.DB, opts *internal.ListOptions) (*gorm.DB, error) {
+		_, _, query, err := applyListOptionsToQuery(query, opts, nil)
+		return query, err
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		// the marker is used to find the user input in the generated sql
+		hostile := "zqxj" + input
+		opts := &internal.ListOptions{
+			ClusterNames: []string{hostile},
+			Namespaces:   []string{hostile, hostile + "-2"},
+			Names:        []string{hostile},
+			OrderBy:      []internal.OrderBy{{Field: hostile}},
+		}
+
+		err := (QueryLimitConfig{}).validateListOptions(opts)
+		require.Error(t, err, "the order by field must be rejected")
+		assert.True(t, apierrors.IsBadRequest(err))
+
+		dbs := []*gorm.DB{postgresDB}
+		for _, db := range mysqlDBs {
+			dbs = append(dbs, db)
+		}
+		for _, db := range dbs {
+			_, err := toUnexplainedSQL(db, opts, applyFn)
+			require.Error(t, err, "the order by field must not be written to the sql")
+
+			sql, err := toUnexplainedSQL(db, &internal.ListOptions{
+				ClusterNames: opts.ClusterNames,
+				Namespaces:   opts.Namespaces,
+				Names:        opts.Names,
+			}, applyFn)
+			require.NoError(t, err)
+			assert.NotContains(t, sql, "zqxj", "the user input must be parameterized")
+		}
+	})
+}
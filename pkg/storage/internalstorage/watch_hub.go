@@ -0,0 +1,414 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+const (
+	defaultWatchHubBufferSize       = 100
+	defaultWatchHubLogSize          = 1000
+	defaultWatchHubBookmarkInterval = time.Minute
+)
+
+// WatchHubConfig configures the in-process watch hub, the writes of the resource storages are broadcast
+// to the watchers by the hub after they are written to the database.
+//
+// The hub only serves the watches in the same process as the writes, e.g. the clustersynchro-manager,
+// the watches of the other processes, e.g. the apiserver, receive no events unless the hub is fed by the ChangeFeed.
+type WatchHubConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// BufferSize is the number of the events buffered for each watcher, the watcher which falls behind
+	// is closed with the 410 Gone error, Default is 100.
+	BufferSize int `yaml:"bufferSize"`
+
+	// LogSize is the number of the latest events of each resource retained by the hub, the watch is resumed
+	// from the revision of a bookmark by them, and the watch from a revision older than them fails with
+	// the 410 Gone error. The events are retained even if the resource isn't watched, so the deleted objects
+	// are read before they are deleted. Default is 1000.
+	LogSize int `yaml:"logSize"`
+
+	// BookmarkInterval is the interval of the bookmarks sent to the watchers which allow the bookmarks,
+	// the bookmarks are disabled if it is negative, Default is 1m.
+	BookmarkInterval time.Duration `yaml:"bookmarkInterval"`
+}
+
+type hubEvent struct {
+	eventType watch.EventType
+
+	// revision is the revision of the hub when the event is published, the bookmarks carry the revision
+	// of the latest published event.
+	revision int64
+
+	cluster   string
+	namespace string
+	name      string
+	labels    map[string]string
+
+	// object is the object stored in the database.
+	object []byte
+}
+
+// watchHub publishes the events of the resources in the order of their revisions, the revisions are shared
+// by all resources and start from the time the hub is created, so the revisions of the previous processes
+// are older than the retained events.
+type watchHub struct {
+	bufferSize       int
+	logSize          int
+	bookmarkInterval time.Duration
+
+	// start is the revision when the hub is created.
+	start int64
+
+	// fed is set if the events are published by the change feed instead of the writes of the process.
+	fed bool
+
+	lock     sync.Mutex
+	revision int64
+	logs     map[schema.GroupVersionResource]*hubLog
+	watchers map[schema.GroupVersionResource]map[*hubWatcher]struct{}
+}
+
+// hubLog is the latest events of a resource, the events up to the compacted revision are dropped.
+type hubLog struct {
+	events    []*hubEvent
+	compacted int64
+}
+
+func newWatchHub(cfg WatchHubConfig) *watchHub {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	bufferSize := cfg.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultWatchHubBufferSize
+	}
+	logSize := cfg.LogSize
+	if logSize <= 0 {
+		logSize = defaultWatchHubLogSize
+	}
+	bookmarkInterval := cfg.BookmarkInterval
+	if bookmarkInterval == 0 {
+		bookmarkInterval = defaultWatchHubBookmarkInterval
+	}
+	start := time.Now().UnixNano()
+	return &watchHub{
+		bufferSize:       bufferSize,
+		logSize:          logSize,
+		bookmarkInterval: bookmarkInterval,
+		start:            start,
+		revision:         start,
+		logs:             make(map[schema.GroupVersionResource]*hubLog),
+		watchers:         make(map[schema.GroupVersionResource]map[*hubWatcher]struct{}),
+	}
+}
+
+// enabled reports whether the writes publish their events, they are retained by the logs even if there are no watchers.
+// The writes of the hub fed by the change feed don't publish their events, which are published by the feed.
+func (h *watchHub) enabled() bool {
+	return h != nil && !h.fed
+}
+
+// publish broadcasts the event to the watchers of the resource without blocking,
+// the watchers whose buffers are full are evicted.
+func (h *watchHub) publish(gvr schema.GroupVersionResource, event *hubEvent) {
+	if h == nil {
+		return
+	}
+
+	var slowWatchers []*hubWatcher
+	func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+
+		h.revision++
+		event.revision = h.revision
+		log := h.logs[gvr]
+		if log == nil {
+			log = &hubLog{compacted: h.start}
+			h.logs[gvr] = log
+		}
+		log.events = append(log.events, event)
+		if len(log.events) > h.logSize {
+			log.compacted = log.events[0].revision
+			log.events[0] = nil
+			log.events = log.events[1:]
+		}
+
+		for watcher := range h.watchers[gvr] {
+			if !watcher.filter(event) {
+				continue
+			}
+
+			select {
+			case watcher.incoming <- event:
+			default:
+				slowWatchers = append(slowWatchers, watcher)
+			}
+		}
+	}()
+
+	for _, watcher := range slowWatchers {
+		h.remove(watcher, true)
+	}
+}
+
+// subscribe subscribes the events of the resource after the revision, the watch starts from the latest event
+// if the revision is zero. The revision of the dropped events fails with the 410 Gone error.
+func (h *watchHub) subscribe(gvr schema.GroupVersionResource, revision int64, filter func(*hubEvent) bool, decode func(cluster string, object []byte) (runtime.Object, error)) (*hubWatcher, error) {
+	watcher := &hubWatcher{
+		hub:      h,
+		gvr:      gvr,
+		filter:   filter,
+		decode:   decode,
+		incoming: make(chan *hubEvent, h.bufferSize),
+		result:   make(chan watch.Event),
+		done:     make(chan struct{}),
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if revision != 0 {
+		compacted := h.start
+		if log := h.logs[gvr]; log != nil {
+			compacted = log.compacted
+		}
+		if revision < compacted || revision > h.revision {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("the revision %d is compacted or not served by the watch hub", revision))
+		}
+
+		if log := h.logs[gvr]; log != nil {
+			for _, event := range log.events {
+				if event.revision > revision && filter(event) {
+					watcher.pending = append(watcher.pending, event)
+				}
+			}
+		}
+	}
+
+	if h.watchers[gvr] == nil {
+		h.watchers[gvr] = make(map[*hubWatcher]struct{})
+	}
+	h.watchers[gvr][watcher] = struct{}{}
+	return watcher, nil
+}
+
+// bookmark sends the bookmark of the latest revision to the watcher after the events published before it,
+// the bookmark is skipped if the buffer of the watcher is full or no events of the resource are retained.
+// The bookmark carries the latest retained event of the resource, the object of the bookmark is of its type.
+func (h *watchHub) bookmark(watcher *hubWatcher) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, ok := h.watchers[watcher.gvr][watcher]; !ok {
+		return
+	}
+	log := h.logs[watcher.gvr]
+	if log == nil || len(log.events) == 0 {
+		return
+	}
+	latest := log.events[len(log.events)-1]
+
+	select {
+	case watcher.incoming <- &hubEvent{eventType: watch.Bookmark, revision: h.revision, cluster: latest.cluster, object: latest.object}:
+	default:
+	}
+}
+
+// remove unsubscribes the watcher, and closes the incoming channel of the watcher,
+// the watcher sends the 410 Gone error after the buffered events if it is evicted.
+func (h *watchHub) remove(watcher *hubWatcher, evicted bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	watchers := h.watchers[watcher.gvr]
+	if _, ok := watchers[watcher]; !ok {
+		return
+	}
+
+	delete(watchers, watcher)
+	if len(watchers) == 0 {
+		delete(h.watchers, watcher.gvr)
+	}
+	watcher.evicted = evicted
+	close(watcher.incoming)
+}
+
+type hubWatcher struct {
+	hub *watchHub
+	gvr schema.GroupVersionResource
+
+	filter func(*hubEvent) bool
+	// decode decodes the stored object of the cluster.
+	decode func(cluster string, object []byte) (runtime.Object, error)
+
+	// pending are the retained events after the revision which the watch is resumed from,
+	// they are sent before the incoming events.
+	pending  []*hubEvent
+	incoming chan *hubEvent
+	result   chan watch.Event
+
+	// bookmarkInterval is the interval of the bookmarks, the bookmarks are disabled if it is not positive.
+	bookmarkInterval time.Duration
+
+	// evicted is set before the incoming is closed.
+	evicted bool
+
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func (w *hubWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *hubWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		w.hub.remove(w, false)
+		close(w.done)
+	})
+}
+
+func (w *hubWatcher) process(ctx context.Context) {
+	defer close(w.result)
+	defer w.Stop()
+
+	var bookmarks <-chan time.Time
+	if w.bookmarkInterval > 0 {
+		ticker := time.NewTicker(w.bookmarkInterval)
+		defer ticker.Stop()
+		bookmarks = ticker.C
+	}
+
+	for {
+		var event watch.Event
+		if len(w.pending) != 0 {
+			event = w.event(w.pending[0])
+			w.pending = w.pending[1:]
+		} else {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.done:
+				return
+			case <-bookmarks:
+				w.hub.bookmark(w)
+				continue
+			case hubEvent, ok := <-w.incoming:
+				if !ok {
+					if !w.evicted {
+						return
+					}
+
+					err := apierrors.NewResourceExpired("the watcher falls behind the events and is evicted, relist and watch again")
+					event = watch.Event{Type: watch.Error, Object: &err.ErrStatus}
+					break
+				}
+				event = w.event(hubEvent)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.done:
+			return
+		case w.result <- event:
+		}
+		if event.Type == watch.Error {
+			return
+		}
+	}
+}
+
+// event decodes the hub event into the watch event, the object of the bookmark is an empty object
+// of the type of the carried object with the revision as the resource version.
+func (w *hubWatcher) event(hubEvent *hubEvent) watch.Event {
+	obj, err := w.decode(hubEvent.cluster, hubEvent.object)
+	if err == nil && hubEvent.eventType == watch.Bookmark {
+		obj, err = newBookmarkObject(obj, hubEvent.revision)
+	}
+	if err != nil {
+		err := apierrors.NewInternalError(fmt.Errorf("failed to decode the object %s/%s of cluster %s: %w",
+			hubEvent.namespace, hubEvent.name, hubEvent.cluster, err))
+		return watch.Event{Type: watch.Error, Object: &err.ErrStatus}
+	}
+	return watch.Event{Type: hubEvent.eventType, Object: obj}
+}
+
+func newBookmarkObject(obj runtime.Object, revision int64) (runtime.Object, error) {
+	var bookmark runtime.Object
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		bookmark = &unstructured.Unstructured{}
+	} else {
+		bookmark = reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	}
+	bookmark.GetObjectKind().SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if err := meta.NewAccessor().SetResourceVersion(bookmark, strconv.FormatInt(revision, 10)); err != nil {
+		return nil, err
+	}
+	return bookmark, nil
+}
+
+// newHubEventFilter returns the filter of the events matched by the list options,
+// the list options which can't be matched by the metadata of the events are rejected.
+func newHubEventFilter(opts *internal.ListOptions) (func(*hubEvent) bool, error) {
+	switch {
+	case opts.OwnerUID != "" || opts.OwnerName != "":
+		return nil, apierrors.NewBadRequest("the watch does not support filtering by the owner")
+	case opts.Since != nil || opts.Before != nil:
+		return nil, apierrors.NewBadRequest("the watch does not support filtering by the creation time")
+	case opts.EnhancedFieldSelector != nil && !opts.EnhancedFieldSelector.Empty():
+		return nil, apierrors.NewBadRequest("the watch does not support the enhanced field selector")
+	case opts.ExtraLabelSelector != nil && !opts.ExtraLabelSelector.Empty():
+		return nil, apierrors.NewBadRequest("the watch does not support the extra label selector")
+	}
+
+	fieldSelector := opts.FieldSelector
+	if fieldSelector != nil && !fieldSelector.Empty() {
+		for _, requirement := range fieldSelector.Requirements() {
+			if requirement.Field != "metadata.name" && requirement.Field != "metadata.namespace" {
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("the watch does not support the field selector %q", requirement.Field))
+			}
+		}
+	}
+
+	clusters, namespaces, names := sets.New(opts.ClusterNames...), sets.New(opts.Namespaces...), sets.New(opts.Names...)
+	labelSelector := opts.LabelSelector
+	return func(event *hubEvent) bool {
+		if clusters.Len() != 0 && !clusters.Has(event.cluster) {
+			return false
+		}
+		if namespaces.Len() != 0 && !namespaces.Has(event.namespace) {
+			return false
+		}
+		if names.Len() != 0 && !names.Has(event.name) {
+			return false
+		}
+		if labelSelector != nil && !labelSelector.Matches(labels.Set(event.labels)) {
+			return false
+		}
+		if fieldSelector != nil && !fieldSelector.Matches(fields.Set{"metadata.name": event.name, "metadata.namespace": event.namespace}) {
+			return false
+		}
+		return true
+	}, nil
+}
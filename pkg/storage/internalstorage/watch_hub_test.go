@@ -0,0 +1,315 @@
+package internalstorage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func TestHubEventFilter(t *testing.T) {
+	event := &hubEvent{cluster: "cluster-1", namespace: "default", name: "deploy-1", labels: map[string]string{"app": "nginx"}}
+
+	tests := []struct {
+		name    string
+		options *internal.ListOptions
+		matched bool
+	}{
+		{"empty", &internal.ListOptions{}, true},
+		{"clusters", &internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}}, true},
+		{"other clusters", &internal.ListOptions{ClusterNames: []string{"cluster-2"}}, false},
+		{"namespaces", &internal.ListOptions{Namespaces: []string{"default"}}, true},
+		{"other namespaces", &internal.ListOptions{Namespaces: []string{"kube-system"}}, false},
+		{"names", &internal.ListOptions{Names: []string{"deploy-2"}}, false},
+		{"label selector", &internal.ListOptions{ListOptions: metainternal.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "nginx"})}}, true},
+		{"other label selector", &internal.ListOptions{ListOptions: metainternal.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "redis"})}}, false},
+		{"field selector", &internal.ListOptions{ListOptions: metainternal.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", "deploy-1")}}, true},
+		{"other field selector", &internal.ListOptions{ListOptions: metainternal.ListOptions{FieldSelector: fields.OneTermNotEqualSelector("metadata.namespace", "default")}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := newHubEventFilter(test.options)
+			require.NoError(t, err)
+			assert.Equal(t, test.matched, filter(event))
+		})
+	}
+
+	for _, options := range []*internal.ListOptions{
+		{OwnerUID: "owner-uid"},
+		{Since: &metav1.Time{Time: time.Now()}},
+		{ListOptions: metainternal.ListOptions{FieldSelector: fields.OneTermEqualSelector("status.phase", "Running")}},
+	} {
+		_, err := newHubEventFilter(options)
+		assert.True(t, apierrors.IsBadRequest(err))
+	}
+}
+
+func TestWatchHubEvictSlowWatcher(t *testing.T) {
+	hub := newWatchHub(WatchHubConfig{Enabled: true, BufferSize: 2})
+	gvr := appsv1.SchemeGroupVersion.WithResource("deployments")
+	decode := func(_ string, object []byte) (runtime.Object, error) {
+		return &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: string(object)}}, nil
+	}
+	all := func(*hubEvent) bool { return true }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	slow, err := hub.subscribe(gvr, 0, all, decode)
+	require.NoError(t, err)
+	fast, err := hub.subscribe(gvr, 0, all, decode)
+	require.NoError(t, err)
+	go fast.process(ctx)
+
+	received := make(chan string, 10)
+	go func() {
+		for event := range fast.ResultChan() {
+			received <- event.Object.(*metav1.PartialObjectMetadata).Name
+		}
+	}()
+
+	// the slow watcher is not processed, its buffer is full after two events
+	for _, name := range []string{"deploy-1", "deploy-2", "deploy-3"} {
+		hub.publish(gvr, &hubEvent{eventType: watch.Added, object: []byte(name)})
+		select {
+		case got := <-received:
+			assert.Equal(t, name, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the event of %s is not received", name)
+		}
+	}
+	assert.Contains(t, hub.watchers[gvr], fast)
+
+	// the buffered events are sent before the gone error
+	go slow.process(ctx)
+	var events []watch.Event
+	for event := range slow.ResultChan() {
+		events = append(events, event)
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, watch.Added, events[0].Type)
+	assert.Equal(t, watch.Added, events[1].Type)
+	require.Equal(t, watch.Error, events[2].Type)
+	assert.Equal(t, int32(http.StatusGone), events[2].Object.(*metav1.Status).Code)
+
+	fast.Stop()
+	assert.Empty(t, hub.watchers[gvr])
+}
+
+func TestResourceStorageWatch(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	_, err = rs.Watch(context.Background(), &internal.ListOptions{})
+	assert.True(t, apierrors.IsMethodNotSupported(err))
+
+	rs.hub = newWatchHub(WatchHubConfig{Enabled: true})
+	_, err = rs.Watch(context.Background(), &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: "10"}})
+	assert.True(t, apierrors.IsResourceExpired(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{ClusterNames: []string{"cluster-1"}})
+	require.NoError(t, err)
+
+	for _, cluster := range []string{"cluster-2", "cluster-1"} {
+		deploy := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", ResourceVersion: "1"},
+		}
+		require.NoError(t, rs.Create(context.Background(), cluster, deploy))
+		deploy.ResourceVersion = "2"
+		require.NoError(t, rs.Update(context.Background(), cluster, deploy))
+		deletedObj, err := rs.ConvertDeletedObject(deploy)
+		require.NoError(t, err)
+		require.NoError(t, rs.Delete(context.Background(), cluster, deletedObj))
+	}
+
+	expected := []struct {
+		eventType       watch.EventType
+		resourceVersion string
+	}{{watch.Added, "1"}, {watch.Modified, "2"}, {watch.Deleted, "2"}}
+	for _, e := range expected {
+		select {
+		case event := <-watcher.ResultChan():
+			assert.Equal(t, e.eventType, event.Type)
+			metaobj, err := meta.Accessor(event.Object)
+			require.NoError(t, err)
+			assert.Equal(t, "deploy-1", metaobj.GetName())
+			assert.Equal(t, e.resourceVersion, metaobj.GetResourceVersion())
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the %s event is not received", e.eventType)
+		}
+	}
+
+	watcher.Stop()
+	_, ok := <-watcher.ResultChan()
+	assert.False(t, ok)
+}
+
+func TestResourceStorageWatchResume(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.hub = newWatchHub(WatchHubConfig{Enabled: true, LogSize: 2, BookmarkInterval: 10 * time.Millisecond})
+
+	create := func(name string) {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: "1"},
+		}))
+	}
+	receive := func(watcher watch.Interface, eventType watch.EventType) runtime.Object {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event, ok := <-watcher.ResultChan():
+				require.True(t, ok)
+				if event.Type == eventType {
+					return event.Object
+				}
+				require.Equal(t, watch.Bookmark, event.Type)
+			case <-timeout:
+				t.Fatalf("the %s event is not received", eventType)
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{AllowWatchBookmarks: true}})
+	require.NoError(t, err)
+	create("deploy-1")
+	added := receive(watcher, watch.Added)
+
+	// the bookmark is an empty object of the watched type
+	bookmark := receive(watcher, watch.Bookmark)
+	assert.IsType(t, added, bookmark)
+	revision, err := meta.NewAccessor().ResourceVersion(bookmark)
+	require.NoError(t, err)
+	watcher.Stop()
+
+	// the events written while disconnected are sent once the watch is resumed from the bookmark
+	create("deploy-2")
+	create("deploy-3")
+	resumed, err := rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: revision}})
+	require.NoError(t, err)
+	for _, name := range []string{"deploy-2", "deploy-3"} {
+		metaobj, err := meta.Accessor(receive(resumed, watch.Added))
+		require.NoError(t, err)
+		assert.Equal(t, name, metaobj.GetName())
+	}
+	resumed.Stop()
+
+	// the event of deploy-2 is dropped from the log, the bookmark is compacted
+	create("deploy-4")
+	_, err = rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: revision}})
+	assert.True(t, apierrors.IsResourceExpired(err))
+	_, err = rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: "invalid"}})
+	assert.True(t, apierrors.IsResourceExpired(err))
+}
+
+func TestResourceStorageUpdateRecreatedObject(t *testing.T) {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	defer cleanup()
+	require.NoError(t, db.AutoMigrate(&ResourceHistory{}))
+
+	gr := schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(gr, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+
+	recorder, err := newHistoryRecorder(HistoryConfig{Resources: []HistoryResourceConfig{{Group: gr.Group, Resource: gr.Resource}}})
+	require.NoError(t, err)
+	go recorder.run()
+	rs.history = recorder
+	rs.hub = newWatchHub(WatchHubConfig{Enabled: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{})
+	require.NoError(t, err)
+	defer watcher.Stop()
+
+	deploy := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "deploy-1", UID: "uid-1", ResourceVersion: "1",
+			Labels: map[string]string{"generation": "old"}},
+	}
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", deploy))
+	deploy.ResourceVersion = "2"
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", deploy))
+
+	// the namespace is deleted and recreated, the deletion of the old object is missed
+	recreated := deploy.DeepCopy()
+	recreated.UID, recreated.ResourceVersion, recreated.Labels = "uid-2", "5", map[string]string{"generation": "new"}
+	require.NoError(t, rs.Update(context.Background(), "cluster-1", recreated))
+
+	expected := []struct {
+		eventType       watch.EventType
+		uid             string
+		resourceVersion string
+		generation      string
+	}{
+		{watch.Added, "uid-1", "1", "old"},
+		{watch.Modified, "uid-1", "2", "old"},
+		{watch.Deleted, "uid-1", "2", "old"},
+		{watch.Added, "uid-2", "5", "new"},
+	}
+	for _, e := range expected {
+		select {
+		case event := <-watcher.ResultChan():
+			assert.Equal(t, e.eventType, event.Type)
+			metaobj, err := meta.Accessor(event.Object)
+			require.NoError(t, err)
+			assert.Equal(t, e.uid, string(metaobj.GetUID()))
+			assert.Equal(t, e.resourceVersion, metaobj.GetResourceVersion())
+			assert.Equal(t, e.generation, metaobj.GetLabels()["generation"])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the %s event is not received", e.eventType)
+		}
+	}
+
+	// the revisions of the old object are ended by the deletion instead of the modification of the new object
+	recorder.close()
+	revisions, err := rs.ListRevisions(context.Background(), "cluster-1", "default", "deploy-1")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, "2", revisions[0].ResourceVersion)
+	assert.True(t, revisions[0].Deleted)
+	assert.Equal(t, "1", revisions[1].ResourceVersion)
+	assert.False(t, revisions[1].Deleted)
+
+	var got appsv1.Deployment
+	require.NoError(t, rs.Get(context.Background(), "cluster-1", "default", "deploy-1", &got))
+	assert.Equal(t, "uid-2", string(got.UID))
+	assert.Equal(t, "5", got.ResourceVersion)
+}
@@ -0,0 +1,548 @@
+package internalstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	defaultWriteBehindQueueSize    = 10000
+	defaultWriteBehindWorkers      = 2
+	defaultWriteBehindBatchSize    = 100
+	defaultWriteBehindRetryBackoff = time.Second
+)
+
+var (
+	writeBehindQueueDepth = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "write_behind_queue_depth",
+			Help:           "Number of the pending objects in the write-behind queues.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "version", "resource"},
+	)
+
+	writeBehindEnqueuedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "write_behind_enqueued_total",
+			Help:           "Number of the writes accepted by the write-behind queues, partitioned by the result of queued or coalesced with the pending write of the object.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "version", "resource", "result"},
+	)
+
+	writeBehindFlushDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Namespace:      "clusterpedia",
+			Subsystem:      "internalstorage",
+			Name:           "write_behind_flush_duration_seconds",
+			Help:           "Duration of writing the batches dequeued from the write-behind queues.",
+			Buckets:        metrics.DefBuckets,
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"group", "version", "resource"},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(writeBehindQueueDepth, writeBehindEnqueuedTotal, writeBehindFlushDuration)
+}
+
+var errWriteBehindClosed = errors.New("the write-behind queue is closed")
+
+// WriteBehindConfig enables the write-behind mode of the resource storages, the Create, Update and Delete queue the writes
+// in the bounded queue of the resource storage and return, and the workers write each dequeued batch in one transaction.
+// The writes of the same object are coalesced, so only the newest pending state of the object is written.
+//
+// The queued writes are lost if the process crashes, and they aren't synced again until the resources are relisted,
+// since their watch events are consumed, so the mode must only be enabled if the loss is acceptable. The synchro flushes
+// the queue before it saves the watch progress, so the relist isn't skipped by the progress saved before the lost writes.
+// The queued writes aren't visible to the get and list requests until they are written.
+type WriteBehindConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// QueueSize is the maximum number of the pending objects of each resource storage, the writers are blocked
+	// until the queue has room. Default is 10000.
+	QueueSize int `yaml:"queueSize"`
+
+	// Workers is the number of the workers writing the queued objects of each resource storage. Default is 2.
+	Workers int `yaml:"workers"`
+
+	// BatchSize is the maximum number of the objects dequeued by a worker at once. Default is 100.
+	BatchSize int `yaml:"batchSize"`
+}
+
+type writeBehindOptions struct {
+	queueSize    int
+	workers      int
+	batchSize    int
+	retryBackoff time.Duration
+}
+
+// newWriteBehindOptions returns nil if the write-behind mode is disabled.
+func newWriteBehindOptions(cfg WriteBehindConfig) (*writeBehindOptions, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.QueueSize < 0 || cfg.Workers < 0 || cfg.BatchSize < 0 {
+		return nil, fmt.Errorf("writeBehind.queueSize, workers and batchSize must not be negative")
+	}
+
+	options := &writeBehindOptions{
+		queueSize:    cfg.QueueSize,
+		workers:      cfg.Workers,
+		batchSize:    cfg.BatchSize,
+		retryBackoff: defaultWriteBehindRetryBackoff,
+	}
+	if options.queueSize == 0 {
+		options.queueSize = defaultWriteBehindQueueSize
+	}
+	if options.workers == 0 {
+		options.workers = defaultWriteBehindWorkers
+	}
+	if options.batchSize == 0 {
+		options.batchSize = defaultWriteBehindBatchSize
+	}
+	return options, nil
+}
+
+type writeOperation string
+
+const (
+	writeOperationCreate writeOperation = "create"
+	writeOperationUpdate writeOperation = "update"
+	writeOperationDelete writeOperation = "delete"
+)
+
+type queuedWrite struct {
+	key       string
+	operation writeOperation
+	cluster   string
+	namespace string
+	name      string
+	obj       runtime.Object
+
+	// lease is the lease of the cluster held by the writer, it fences the write in the worker.
+	lease *storage.ClusterLease
+}
+
+// writeBehindQueue is the queue of the pending writes of a resource storage, keyed by the objects.
+//
+// The object being written by a worker is not dequeued by the other workers, its newer write is pending
+// until the worker finishes, so the writes of an object are never reordered.
+type writeBehindQueue struct {
+	storage      *ResourceStorage
+	size         int
+	batchSize    int
+	retryBackoff time.Duration
+
+	depth    metrics.GaugeMetric
+	queued   metrics.CounterMetric
+	coalesce metrics.CounterMetric
+	flush    metrics.ObserverMetric
+
+	lock     sync.Mutex
+	notFull  *sync.Cond
+	notEmpty *sync.Cond
+
+	// pending are the newest pending writes of the objects.
+	pending map[string]*queuedWrite
+	// keys are the keys of the pending writes which can be dequeued, in the order of their writes.
+	keys []string
+	// writing are the keys of the objects being written by the workers.
+	writing map[string]bool
+
+	// failed is the number of the writes dropped by the errors since the last flush, and failedErr is the first error.
+	failed    int
+	failedErr error
+
+	closed  bool
+	aborted bool
+	idle    []chan struct{}
+	workers sync.WaitGroup
+}
+
+func newWriteBehindQueue(s *ResourceStorage, options *writeBehindOptions) *writeBehindQueue {
+	gvr := s.storageGVR()
+	q := &writeBehindQueue{
+		storage:      s,
+		size:         options.queueSize,
+		batchSize:    options.batchSize,
+		retryBackoff: options.retryBackoff,
+		depth:        writeBehindQueueDepth.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource),
+		queued:       writeBehindEnqueuedTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, "queued"),
+		coalesce:     writeBehindEnqueuedTotal.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource, "coalesced"),
+		flush:        writeBehindFlushDuration.WithLabelValues(gvr.Group, gvr.Version, gvr.Resource),
+		pending:      make(map[string]*queuedWrite),
+		writing:      make(map[string]bool),
+	}
+	q.notFull = sync.NewCond(&q.lock)
+	q.notEmpty = sync.NewCond(&q.lock)
+
+	q.workers.Add(options.workers)
+	for i := 0; i < options.workers; i++ {
+		go q.run()
+	}
+	return q
+}
+
+// enqueue queues the write of the object, the caller is blocked until the queue has room.
+// The write replaces the pending write of the same object.
+func (q *writeBehindQueue) enqueue(ctx context.Context, operation writeOperation, cluster string, obj runtime.Object) error {
+	metaobj, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	var lease *storage.ClusterLease
+	if l, ok := storage.ClusterLeaseFrom(ctx); ok {
+		lease = &l
+	}
+	key := cluster + "/" + metaobj.GetNamespace() + "/" + metaobj.GetName()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for {
+		if q.closed {
+			return errWriteBehindClosed
+		}
+		if write, ok := q.pending[key]; ok {
+			write.operation, write.obj, write.lease = coalescedOperation(write.operation, operation), obj, lease
+			q.coalesce.Inc()
+			return nil
+		}
+		if len(q.pending) < q.size {
+			break
+		}
+		q.notFull.Wait()
+	}
+
+	q.pending[key] = &queuedWrite{
+		key: key, operation: operation, cluster: cluster, namespace: metaobj.GetNamespace(), name: metaobj.GetName(), obj: obj, lease: lease,
+	}
+	if !q.writing[key] {
+		q.keys = append(q.keys, key)
+		q.notEmpty.Signal()
+	}
+	q.depth.Inc()
+	q.queued.Inc()
+	return nil
+}
+
+// coalescedOperation returns the operation of the pending write replaced by the newer write, the object
+// which may not be created yet is still created by the newer update.
+func coalescedOperation(pending, operation writeOperation) writeOperation {
+	if operation == writeOperationUpdate && pending != writeOperationUpdate {
+		return writeOperationCreate
+	}
+	return operation
+}
+
+// dequeue returns the batch of the pending writes, it returns nil if the queue is closed and drained, or aborted.
+func (q *writeBehindQueue) dequeue() []*queuedWrite {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.keys) == 0 || q.aborted {
+		if q.aborted || (q.closed && len(q.pending) == 0) {
+			return nil
+		}
+		q.notEmpty.Wait()
+	}
+
+	n := len(q.keys)
+	if n > q.batchSize {
+		n = q.batchSize
+	}
+	batch := make([]*queuedWrite, 0, n)
+	for _, key := range q.keys[:n] {
+		batch = append(batch, q.pending[key])
+		delete(q.pending, key)
+		q.writing[key] = true
+	}
+	q.keys = q.keys[n:]
+	q.depth.Add(-float64(n))
+	q.notFull.Broadcast()
+	return batch
+}
+
+// finish releases the written objects, the retried writes are queued again unless they are replaced by the newer writes.
+// The failed writes are reported by the next flush.
+func (q *writeBehindQueue) finish(batch []*queuedWrite, retried []*queuedWrite, failed []error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(failed) != 0 {
+		if q.failed == 0 {
+			q.failedErr = failed[0]
+		}
+		q.failed += len(failed)
+	}
+
+	for _, write := range retried {
+		if _, ok := q.pending[write.key]; !ok {
+			q.pending[write.key] = write
+			q.depth.Inc()
+		}
+	}
+	for _, write := range batch {
+		delete(q.writing, write.key)
+		if _, ok := q.pending[write.key]; ok {
+			q.keys = append(q.keys, write.key)
+			q.notEmpty.Signal()
+		}
+	}
+
+	if len(q.pending) == 0 && len(q.writing) == 0 {
+		for _, idle := range q.idle {
+			close(idle)
+		}
+		q.idle = nil
+		if q.closed {
+			q.notEmpty.Broadcast()
+		}
+	}
+}
+
+func (q *writeBehindQueue) run() {
+	defer q.workers.Done()
+	for {
+		batch := q.dequeue()
+		if batch == nil {
+			return
+		}
+
+		start := time.Now()
+		retried, failed := q.writeBatch(batch)
+		q.flush.Observe(time.Since(start).Seconds())
+		q.finish(batch, retried, failed)
+
+		if len(retried) != 0 {
+			klog.InfoS("Retrying the queued writes failed by the transient errors", "gvr", q.storage.storageGVR(), "count", len(retried), "backoff", q.retryBackoff)
+			time.Sleep(q.retryBackoff)
+		}
+	}
+}
+
+// writeBatch writes the batch in one transaction, each write is isolated by a savepoint, so the failed writes don't
+// roll back the others. The batch is written one by one if the transaction fails, e.g. it is aborted by the deadlock.
+// It returns the writes retried by the transient errors and the errors of the dropped writes.
+func (q *writeBehindQueue) writeBatch(batch []*queuedWrite) (retried []*queuedWrite, failed []error) {
+	ctx := context.Background()
+	if len(batch) > 1 {
+		var errs []error
+		tx := &writeBatch{}
+		err := q.storage.db.WithContext(ctx).Transaction(func(db *gorm.DB) error {
+			tx.db = db
+			batchCtx := context.WithValue(ctx, writeBatchKey{}, tx)
+			errs = make([]error, len(batch))
+			for i, write := range batch {
+				errs[i] = q.write(batchCtx, write)
+			}
+			return nil
+		})
+		if err == nil {
+			tx.committed(ctx)
+			for i, write := range batch {
+				// the objects may be cached by the gets before the transaction is committed
+				q.storage.invalidateGetCaches(write.cluster, write.namespace, write.name)
+				if errs[i] == nil {
+					continue
+				}
+				if retry, failure := q.failure(write, errs[i]); retry {
+					retried = append(retried, write)
+				} else {
+					failed = append(failed, failure)
+				}
+			}
+			return retried, failed
+		}
+		klog.ErrorS(err, "Failed to write the batch in a transaction, write the objects one by one", "gvr", q.storage.storageGVR(), "count", len(batch))
+	}
+
+	for _, write := range batch {
+		err := q.write(ctx, write)
+		if err == nil {
+			continue
+		}
+		if retry, failure := q.failure(write, err); retry {
+			retried = append(retried, write)
+		} else {
+			failed = append(failed, failure)
+		}
+	}
+	return retried, failed
+}
+
+// failure returns true if the write is retried by the transient error, otherwise the write is dropped.
+func (q *writeBehindQueue) failure(write *queuedWrite, err error) (bool, error) {
+	if errors.Is(err, ErrTransient) {
+		return true, nil
+	}
+	klog.ErrorS(err, "Failed to write the queued object", "gvr", q.storage.storageGVR(), "operation", write.operation, "key", write.key)
+	return false, fmt.Errorf("failed to %s %s: %w", write.operation, write.key, err)
+}
+
+// write writes the queued object like the synchro, the created object which exists is updated.
+func (q *writeBehindQueue) write(ctx context.Context, write *queuedWrite) error {
+	s := q.storage
+	if write.lease != nil {
+		ctx = storage.WithClusterLease(ctx, *write.lease)
+	}
+	switch write.operation {
+	case writeOperationCreate:
+		err := inSavepoint(ctx, func(ctx context.Context) error { return s.create(ctx, write.cluster, write.obj) })
+		if errors.Is(err, ErrConflict) {
+			return inSavepoint(ctx, func(ctx context.Context) error { return s.update(ctx, write.cluster, write.obj) })
+		}
+		return err
+	case writeOperationUpdate:
+		return inSavepoint(ctx, func(ctx context.Context) error { return s.update(ctx, write.cluster, write.obj) })
+	}
+	return inSavepoint(ctx, func(ctx context.Context) error { return s.delete(ctx, write.cluster, write.obj) })
+}
+
+// writeBatch is the transaction of the batched writes, the side effects of the writes, e.g. the events of the watchers
+// and the audit records, are deferred until the transaction is committed.
+type writeBatch struct {
+	db      *gorm.DB
+	effects []func(ctx context.Context)
+}
+
+type writeBatchKey struct{}
+
+func writeBatchFrom(ctx context.Context) *writeBatch {
+	batch, _ := ctx.Value(writeBatchKey{}).(*writeBatch)
+	return batch
+}
+
+// committed runs the side effects of the written objects in order.
+func (batch *writeBatch) committed(ctx context.Context) {
+	for _, effect := range batch.effects {
+		effect(ctx)
+	}
+	batch.effects = nil
+}
+
+// writeDB returns the transaction of the batched writes, or the db of the storage if the write isn't batched.
+func (s *ResourceStorage) writeDB(ctx context.Context) *gorm.DB {
+	if batch := writeBatchFrom(ctx); batch != nil {
+		return batch.db.WithContext(ctx)
+	}
+	return s.db.WithContext(ctx)
+}
+
+// afterWrite runs the side effects of the written object, they are deferred until the batch is committed.
+func afterWrite(ctx context.Context, effect func(ctx context.Context)) {
+	if batch := writeBatchFrom(ctx); batch != nil {
+		batch.effects = append(batch.effects, effect)
+		return
+	}
+	effect(ctx)
+}
+
+// inSavepoint runs the write of the batch in a savepoint, the failed write is rolled back without aborting
+// the transaction, and its side effects are discarded.
+func inSavepoint(ctx context.Context, write func(ctx context.Context) error) error {
+	batch := writeBatchFrom(ctx)
+	if batch == nil {
+		return write(ctx)
+	}
+
+	effects := len(batch.effects)
+	var err error
+	_ = batch.db.Transaction(func(*gorm.DB) error {
+		err = write(ctx)
+		return err
+	})
+	if err != nil {
+		batch.effects = batch.effects[:effects]
+	}
+	return err
+}
+
+// flushQueue waits until the queue is drained, it returns the error if some writes are dropped since the last flush.
+func (q *writeBehindQueue) flushQueue(ctx context.Context) error {
+	q.lock.Lock()
+	if len(q.pending) == 0 && len(q.writing) == 0 {
+		defer q.lock.Unlock()
+		return q.takeFailure()
+	}
+	idle := make(chan struct{})
+	q.idle = append(q.idle, idle)
+	q.lock.Unlock()
+
+	select {
+	case <-idle:
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return q.takeFailure()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// takeFailure returns and resets the error of the dropped writes, the caller holds the lock.
+func (q *writeBehindQueue) takeFailure() error {
+	if q.failed == 0 {
+		return nil
+	}
+	err := storage.NewError(ErrWriteDropped, fmt.Errorf("%d queued writes are dropped, the first error: %w", q.failed, q.failedErr))
+	q.failed, q.failedErr = 0, nil
+	return err
+}
+
+// close stops accepting the writes and waits until the queue is drained, the pending writes are dropped
+// if the context is done before.
+func (q *writeBehindQueue) close(ctx context.Context) error {
+	q.lock.Lock()
+	q.closed = true
+	q.notFull.Broadcast()
+	q.notEmpty.Broadcast()
+	q.lock.Unlock()
+
+	if err := q.flushQueue(ctx); err != nil {
+		q.lock.Lock()
+		q.aborted = true
+		dropped := len(q.pending)
+		q.notEmpty.Broadcast()
+		q.lock.Unlock()
+
+		klog.ErrorS(err, "Dropped the pending writes of the closed write-behind queue", "gvr", q.storage.storageGVR(), "count", dropped)
+		return err
+	}
+	q.workers.Wait()
+	return nil
+}
+
+var _ storage.ResourceStorageCloser = &ResourceStorage{}
+
+// Flush waits until the writes queued by the write-behind mode are written.
+func (s *ResourceStorage) Flush(ctx context.Context) error {
+	if s.writeBehind == nil {
+		return nil
+	}
+	return s.writeBehind.flushQueue(ctx)
+}
+
+// Close flushes the writes queued by the write-behind mode and stops the workers, the writes after it fail.
+func (s *ResourceStorage) Close(ctx context.Context) error {
+	if s.writeBehind == nil {
+		return nil
+	}
+	return s.writeBehind.close(ctx)
+}
@@ -0,0 +1,202 @@
+package internalstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storageconfig"
+)
+
+func newWriteBehindTestStorage(t *testing.T, options *writeBehindOptions) *ResourceStorage {
+	db, cleanup, err := newSQLiteDB()
+	require.NoError(t, err)
+	t.Cleanup(cleanup)
+
+	rs := newTestResourceStorage(db, appsv1.SchemeGroupVersion.WithResource("deployments"))
+	config, err := storageconfig.NewStorageConfigFactory().NewLegacyResourceConfig(schema.GroupResource{Group: appsv1.GroupName, Resource: "deployments"}, true)
+	require.NoError(t, err)
+	rs.codec = config.Codec
+	rs.writeBehind = newWriteBehindQueue(rs, options)
+	return rs
+}
+
+func newWriteBehindTestDeployment(name, resourceVersion string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, ResourceVersion: resourceVersion},
+	}
+}
+
+// startWriteBehindWorker starts a worker of the queue created without the workers.
+func startWriteBehindWorker(q *writeBehindQueue) {
+	q.workers.Add(1)
+	go q.run()
+}
+
+func TestWriteBehindCoalescing(t *testing.T) {
+	rs := newWriteBehindTestStorage(t, &writeBehindOptions{queueSize: 10, batchSize: 2})
+	coalesced := func() float64 {
+		value, err := testutil.GetCounterMetricValue(writeBehindEnqueuedTotal.WithLabelValues("apps", "v1", "deployments", "coalesced"))
+		require.NoError(t, err)
+		return value
+	}
+	before := coalesced()
+
+	ctx := context.Background()
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "1")))
+	for i := 2; i <= 5; i++ {
+		require.NoError(t, rs.Update(ctx, "cluster-1", newWriteBehindTestDeployment("a", fmt.Sprint(i))))
+	}
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("b", "1")))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("c", "1")))
+	require.NoError(t, rs.Delete(ctx, "cluster-1", newWriteBehindTestDeployment("c", "")))
+	assert.Equal(t, before+5, coalesced())
+	assert.Len(t, rs.writeBehind.pending, 3)
+
+	// the queued writes aren't visible until they are written
+	assert.ErrorIs(t, rs.Get(ctx, "cluster-1", "default", "a", &appsv1.Deployment{}), ErrNotFound)
+
+	startWriteBehindWorker(rs.writeBehind)
+	require.NoError(t, rs.Flush(ctx))
+
+	// only the newest state of the object is written
+	deploy := &appsv1.Deployment{}
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "a", deploy))
+	assert.Equal(t, "5", deploy.ResourceVersion)
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "b", &appsv1.Deployment{}))
+	assert.ErrorIs(t, rs.Get(ctx, "cluster-1", "default", "c", &appsv1.Deployment{}), ErrNotFound)
+
+	// the created object which exists is updated
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "6")))
+	require.NoError(t, rs.Flush(ctx))
+	require.NoError(t, rs.Get(ctx, "cluster-1", "default", "a", deploy))
+	assert.Equal(t, "6", deploy.ResourceVersion)
+}
+
+func TestWriteBehindBackpressure(t *testing.T) {
+	rs := newWriteBehindTestStorage(t, &writeBehindOptions{queueSize: 2, batchSize: 10})
+
+	ctx := context.Background()
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "1")))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("b", "1")))
+
+	// the write of the pending object is coalesced even though the queue is full
+	require.NoError(t, rs.Update(ctx, "cluster-1", newWriteBehindTestDeployment("a", "2")))
+
+	created := make(chan error)
+	go func() { created <- rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("c", "1")) }()
+	select {
+	case <-created:
+		t.Fatal("the writer isn't blocked by the full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	startWriteBehindWorker(rs.writeBehind)
+	require.NoError(t, <-created)
+	require.NoError(t, rs.Close(ctx))
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, rs.Get(ctx, "cluster-1", "default", name, &appsv1.Deployment{}))
+	}
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	rs := newWriteBehindTestStorage(t, &writeBehindOptions{queueSize: 100, workers: 2, batchSize: 10})
+
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment(fmt.Sprintf("deploy-%d", i), "1")))
+	}
+
+	// the pending writes are written by the close
+	require.NoError(t, rs.Close(ctx))
+	var count int64
+	require.NoError(t, rs.db.Model(&Resource{}).Count(&count).Error)
+	assert.Equal(t, int64(50), count)
+
+	assert.ErrorIs(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "1")), errWriteBehindClosed)
+	assert.NoError(t, rs.Flush(ctx))
+}
+
+func TestWriteBehindCloseTimeout(t *testing.T) {
+	rs := newWriteBehindTestStorage(t, &writeBehindOptions{queueSize: 10, batchSize: 10})
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", newWriteBehindTestDeployment("a", "1")))
+
+	// the pending writes are dropped if they aren't written before the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, rs.Close(ctx), context.DeadlineExceeded)
+	assert.Nil(t, rs.writeBehind.dequeue())
+}
+
+func TestWriteBehindBatchFailure(t *testing.T) {
+	rs := newWriteBehindTestStorage(t, &writeBehindOptions{queueSize: 10, batchSize: 10})
+
+	ctx := context.Background()
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("a", "1")))
+	invalid := newWriteBehindTestDeployment("invalid", "1")
+	invalid.TypeMeta = metav1.TypeMeta{}
+	require.NoError(t, rs.Create(ctx, "cluster-1", invalid))
+	require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment("b", "1")))
+
+	// the failed write doesn't roll back the others in the batch, and it is reported by the flush once
+	startWriteBehindWorker(rs.writeBehind)
+	err := rs.Flush(ctx)
+	assert.ErrorIs(t, err, ErrWriteDropped)
+	assert.ErrorContains(t, err, "1 queued writes are dropped")
+	assert.NoError(t, rs.Flush(ctx))
+
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, rs.Get(ctx, "cluster-1", "default", name, &appsv1.Deployment{}))
+	}
+	assert.ErrorIs(t, rs.Get(ctx, "cluster-1", "default", "invalid", &appsv1.Deployment{}), ErrNotFound)
+}
+
+func TestNewWriteBehindOptions(t *testing.T) {
+	options, err := newWriteBehindOptions(WriteBehindConfig{QueueSize: 10})
+	require.NoError(t, err)
+	assert.Nil(t, options)
+
+	options, err = newWriteBehindOptions(WriteBehindConfig{Enabled: true, QueueSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 10, options.queueSize)
+	assert.Equal(t, defaultWriteBehindWorkers, options.workers)
+	assert.Equal(t, defaultWriteBehindBatchSize, options.batchSize)
+
+	_, err = newWriteBehindOptions(WriteBehindConfig{Enabled: true, Workers: -1})
+	assert.Error(t, err)
+}
+
+func TestWriteBehindBatchEventStream(t *testing.T) {
+	broker := &fakeBroker{}
+	db, rs, stream := newEventStreamTestStorage(t, EventStreamConfig{}, broker)
+	require.NoError(t, stream.start([]*gorm.DB{db}, nil))
+	rs.writeBehind = newWriteBehindQueue(rs, &writeBehindOptions{queueSize: 10, batchSize: 10})
+
+	ctx := context.Background()
+	names := []string{"a", "bb", "ccc"}
+	for _, name := range names {
+		require.NoError(t, rs.Create(ctx, "cluster-1", newWriteBehindTestDeployment(name, "1")))
+	}
+
+	// the events are streamed after the batch is committed, their objects are intact
+	startWriteBehindWorker(rs.writeBehind)
+	require.NoError(t, rs.Flush(ctx))
+	messages := broker.waitFor(t, len(names))
+	require.Len(t, messages, len(names))
+	for i, message := range messages {
+		assert.Equal(t, "cluster-1/default/"+names[i], message.Key)
+		operation, object := decodeEventPayload(t, message)
+		assert.Equal(t, EventOperationCreate, operation)
+		assert.Equal(t, names[i], object["metadata"].(map[string]interface{})["name"])
+	}
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// ClusterLease is the lease of the cluster held by the writer, the fencing token increases
+// each time the lease is acquired by another holder.
+type ClusterLease struct {
+	Cluster   string
+	Holder    string
+	Token     int64
+	ExpiresAt time.Time
+}
+
+type clusterLeaseKeyType int
+
+const clusterLeaseKey clusterLeaseKeyType = iota
+
+// WithClusterLease passes the lease of the writer to the write methods of the ResourceStorage,
+// the storage with the fencing rejects the writes whose leases are stale.
+func WithClusterLease(parent context.Context, lease ClusterLease) context.Context {
+	return context.WithValue(parent, clusterLeaseKey, lease)
+}
+
+// ClusterLeaseFrom returns the lease of the writer passed by WithClusterLease.
+func ClusterLeaseFrom(ctx context.Context) (ClusterLease, bool) {
+	lease, ok := ctx.Value(clusterLeaseKey).(ClusterLease)
+	return lease, ok
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -13,12 +14,14 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/watch"
 	kubecache "k8s.io/client-go/tools/cache"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 	cache "github.com/clusterpedia-io/clusterpedia/pkg/storage/memorystorage/watchcache"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils"
 	utilwatch "github.com/clusterpedia-io/clusterpedia/pkg/utils/watch"
 )
 
@@ -50,6 +53,9 @@ type ResourceStorage struct {
 	CrvSynchro    *cache.ClusterResourceVersionSynchro
 	incoming      chan ClusterWatchEvent
 	storageConfig *storage.ResourceStorageConfig
+
+	// bookmarkInterval is the interval of the bookmarks, the bookmarks are disabled if it is not positive.
+	bookmarkInterval time.Duration
 }
 
 func (s *ResourceStorage) GetStorageConfig() *storage.ResourceStorageConfig {
@@ -224,7 +230,15 @@ func (s *ResourceStorage) Watch(ctx context.Context, options *internal.ListOptio
 		return newErrWatcher(err), nil
 	}
 
-	watcher := cache.NewCacheWatcher(100)
+	var filter cache.FilterFunc
+	if len(options.ClusterNames) != 0 {
+		clusters := sets.New(options.ClusterNames...)
+		filter = func(event *watch.Event) bool {
+			return clusters.Has(utils.ExtractClusterName(event.Object))
+		}
+	}
+
+	watcher := cache.NewCacheWatcher(100, filter)
 	watchCache := s.watchCache
 	watchCache.Lock()
 	defer watchCache.Unlock()
@@ -245,9 +259,28 @@ func (s *ResourceStorage) Watch(ctx context.Context, options *internal.ListOptio
 	}()
 
 	go watcher.Process(ctx, initEvents)
+	if options.AllowWatchBookmarks && s.bookmarkInterval > 0 {
+		go s.sendBookmarks(ctx, watcher)
+	}
 	return watcher, nil
 }
 
+// sendBookmarks sends the bookmarks periodically until the watch is stopped, the watcher can resume
+// from the resource version of the bookmark without relisting.
+func (s *ResourceStorage) sendBookmarks(ctx context.Context, watcher *cache.CacheWatcher) {
+	ticker := time.NewTicker(s.bookmarkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.watchCache.DispatchBookmark(watcher)
+		}
+	}
+}
+
 type errWatcher struct {
 	result chan watch.Event
 }
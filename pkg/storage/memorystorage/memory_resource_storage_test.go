@@ -0,0 +1,180 @@
+package memorystorage
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternal "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+	cache "github.com/clusterpedia-io/clusterpedia/pkg/storage/memorystorage/watchcache"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils"
+)
+
+func newTestObject(cluster string, i int) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("example.io/v1")
+	obj.SetKind("Foo")
+	obj.SetNamespace("default")
+	obj.SetName(fmt.Sprintf("%s-foo-%d", cluster, i))
+	obj.SetResourceVersion(fmt.Sprint(i + 1))
+	return obj
+}
+
+func receiveEvents(t *testing.T, watcher watch.Interface, count int) []watch.Event {
+	var events []watch.Event
+	for len(events) < count {
+		select {
+		case event := <-watcher.ResultChan():
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d events, expected %d", len(events), count)
+		}
+	}
+	return events
+}
+
+func TestWatchAcrossClusters(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "example.io", Version: "v1", Resource: "foos"}
+	rs := &ResourceStorage{
+		watchCache:    cache.NewWatchCache(100, gvr, true),
+		CrvSynchro:    cache.NewClusterResourceVersionSynchro("cluster-1"),
+		storageConfig: &storage.ResourceStorageConfig{MemoryVersion: gvr.GroupVersion()},
+	}
+	clusters := []string{"cluster-1", "cluster-2"}
+	for _, cluster := range clusters {
+		rs.watchCache.AddIndexer(cluster, nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all, err := rs.Watch(ctx, &internal.ListOptions{})
+	require.NoError(t, err)
+	filtered, err := rs.Watch(ctx, &internal.ListOptions{ClusterNames: []string{"cluster-2"}})
+	require.NoError(t, err)
+
+	const count = 10
+	var wg sync.WaitGroup
+	for _, cluster := range clusters {
+		wg.Add(1)
+		go func(cluster string) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				assert.NoError(t, rs.Create(context.Background(), cluster, newTestObject(cluster, i)))
+			}
+		}(cluster)
+	}
+	wg.Wait()
+
+	received := map[string]int{}
+	for _, event := range receiveEvents(t, all, 2*count) {
+		assert.Equal(t, watch.Added, event.Type)
+		received[utils.ExtractClusterName(event.Object)]++
+	}
+	assert.Equal(t, map[string]int{"cluster-1": count, "cluster-2": count}, received)
+
+	for _, event := range receiveEvents(t, filtered, count) {
+		assert.Equal(t, "cluster-2", utils.ExtractClusterName(event.Object))
+	}
+
+	// the objects of the removed cluster are deleted
+	rs.watchCache.CleanCluster("cluster-2")
+	for _, event := range receiveEvents(t, filtered, count) {
+		assert.Equal(t, watch.Deleted, event.Type)
+		assert.Equal(t, "cluster-2", utils.ExtractClusterName(event.Object))
+	}
+	for _, event := range receiveEvents(t, all, count) {
+		assert.Equal(t, watch.Deleted, event.Type)
+		assert.Equal(t, "cluster-2", utils.ExtractClusterName(event.Object))
+	}
+}
+
+func newTestResourceStorage(capacity int) *ResourceStorage {
+	gvr := schema.GroupVersionResource{Group: "example.io", Version: "v1", Resource: "foos"}
+	rs := &ResourceStorage{
+		watchCache:       cache.NewWatchCache(capacity, gvr, true),
+		CrvSynchro:       cache.NewClusterResourceVersionSynchro("cluster-1"),
+		storageConfig:    &storage.ResourceStorageConfig{MemoryVersion: gvr.GroupVersion()},
+		bookmarkInterval: 10 * time.Millisecond,
+	}
+	rs.watchCache.AddIndexer("cluster-1", nil)
+	return rs
+}
+
+func TestWatchResumeFromBookmark(t *testing.T) {
+	rs := newTestResourceStorage(100)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newTestObject("cluster-1", i)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watcher, err := rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{AllowWatchBookmarks: true}})
+	require.NoError(t, err)
+
+	var bookmark *watch.Event
+	for bookmark == nil {
+		select {
+		case event := <-watcher.ResultChan():
+			if event.Type == watch.Bookmark {
+				bookmark = &event
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no bookmark is received")
+		}
+	}
+	rv, err := meta.NewAccessor().ResourceVersion(bookmark.Object)
+	require.NoError(t, err)
+	require.NotEmpty(t, rv)
+
+	// disconnect, and resume from the bookmark after more objects are created
+	cancel()
+	for i := 3; i < 5; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newTestObject("cluster-1", i)))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	watcher, err = rs.Watch(ctx, &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: rv}})
+	require.NoError(t, err)
+
+	events := receiveEvents(t, watcher, 2)
+	for i, event := range events {
+		assert.Equal(t, watch.Added, event.Type)
+		name, err := meta.NewAccessor().Name(event.Object)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("cluster-1-foo-%d", i+3), name)
+	}
+}
+
+func TestWatchCompactedResourceVersion(t *testing.T) {
+	rs := newTestResourceStorage(5)
+	obj := newTestObject("cluster-1", 0)
+	require.NoError(t, rs.Create(context.Background(), "cluster-1", obj))
+	rv := obj.GetResourceVersion()
+
+	// the event of the resource version is compacted out of the watch cache
+	for i := 1; i < 10; i++ {
+		require.NoError(t, rs.Create(context.Background(), "cluster-1", newTestObject("cluster-1", i)))
+	}
+
+	watcher, err := rs.Watch(context.Background(), &internal.ListOptions{ListOptions: metainternal.ListOptions{ResourceVersion: rv}})
+	require.NoError(t, err)
+
+	events := receiveEvents(t, watcher, 1)
+	require.Equal(t, watch.Error, events[0].Type)
+	status, ok := events[0].Object.(*metav1.Status)
+	require.True(t, ok)
+	assert.Equal(t, int32(http.StatusGone), status.Code)
+}
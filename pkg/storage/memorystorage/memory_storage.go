@@ -2,6 +2,7 @@ package memorystorage
 
 import (
 	"context"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
@@ -11,7 +12,8 @@ import (
 )
 
 type StorageFactory struct {
-	clusters map[string]bool
+	clusters         map[string]bool
+	bookmarkInterval time.Duration
 }
 
 func (s *StorageFactory) GetSupportedRequestVerbs() []string {
@@ -42,6 +44,8 @@ func (s *StorageFactory) NewResourceStorage(config *storage.ResourceStorageConfi
 			Codec:         config.Codec,
 			watchCache:    watchCache,
 			storageConfig: config,
+
+			bookmarkInterval: s.bookmarkInterval,
 		}
 
 		storages.resourceStorages[gvr] = resourceStorage
@@ -1,20 +1,43 @@
 package memorystorage
 
 import (
+	"time"
+
+	"github.com/jinzhu/configor"
+
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 )
 
 const (
 	StorageName = "memory"
+
+	defaultBookmarkInterval = time.Minute
 )
 
 func init() {
 	storage.RegisterStorageFactoryFunc(StorageName, NewStorageFactory)
 }
 
-func NewStorageFactory(_ string) (storage.StorageFactory, error) {
+type Config struct {
+	// BookmarkInterval is the interval of the bookmarks sent to the watchers which allow the bookmarks,
+	// the bookmarks are disabled if it is negative, Default is 1m.
+	BookmarkInterval time.Duration `yaml:"bookmarkInterval"`
+}
+
+func NewStorageFactory(configPath string) (storage.StorageFactory, error) {
+	cfg := &Config{}
+	if configPath != "" {
+		if err := configor.Load(cfg, configPath); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.BookmarkInterval == 0 {
+		cfg.BookmarkInterval = defaultBookmarkInterval
+	}
+
 	storageFactory := &StorageFactory{
-		clusters: make(map[string]bool),
+		clusters:         make(map[string]bool),
+		bookmarkInterval: cfg.BookmarkInterval,
 	}
 	return storageFactory, nil
 }
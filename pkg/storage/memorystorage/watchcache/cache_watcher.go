@@ -8,6 +8,9 @@ import (
 	"k8s.io/apimachinery/pkg/watch"
 )
 
+// FilterFunc returns true if the watcher is interested in the event.
+type FilterFunc func(event *watch.Event) bool
+
 // CacheWatcher implements watch.Interface
 type CacheWatcher struct {
 	input   chan *watch.Event
@@ -15,15 +18,18 @@ type CacheWatcher struct {
 	done    chan struct{}
 	stopped bool
 	forget  func()
+	filter  FilterFunc
 }
 
-func NewCacheWatcher(chanSize int) *CacheWatcher {
+// NewCacheWatcher returns the watcher, all events are sent to the watcher if the filter is nil.
+func NewCacheWatcher(chanSize int, filter FilterFunc) *CacheWatcher {
 	return &CacheWatcher{
 		input:   make(chan *watch.Event, chanSize),
 		result:  make(chan watch.Event, chanSize),
 		done:    make(chan struct{}),
 		stopped: false,
 		forget:  func() {},
+		filter:  filter,
 	}
 }
 
@@ -84,9 +90,17 @@ func (c *CacheWatcher) Add(event *watch.Event, timer *time.Timer) bool {
 	}
 }
 
+// convertToWatchEvent returns nil if the watcher is not interested in the event,
+// the error and bookmark events are always sent.
+func (c *CacheWatcher) convertToWatchEvent(event *watch.Event) *watch.Event {
+	if c.filter == nil || event.Type == watch.Error || event.Type == watch.Bookmark || c.filter(event) {
+		return event
+	}
+	return nil
+}
+
 func (c *CacheWatcher) sendWatchCacheEvent(event *watch.Event) {
-	//watchEvent := c.convertToWatchEvent(event)
-	watchEvent := event
+	watchEvent := c.convertToWatchEvent(event)
 	if watchEvent == nil {
 		// Watcher is not interested in that object.
 		return
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"reflect"
 	"sync"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -21,7 +23,7 @@ import (
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 	"github.com/clusterpedia-io/clusterpedia/pkg/scheme"
-	utilwatch "github.com/clusterpedia-io/clusterpedia/pkg/utils/watch"
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils"
 )
 
 // StoreElement keeping the structs of resource in k8s(key, object, labels, fields).
@@ -207,7 +209,13 @@ func (w *WatchCache) processEvent(event watch.Event, resourceVersion *ClusterRes
 
 		w.updateCache(&wcEvent)
 		w.resourceVersion = resourceVersion
-		return updateFunc(elem)
+		if err := updateFunc(elem); err != nil {
+			return err
+		}
+
+		// the event is dispatched with the lock held, so the bookmarks are ordered with the events
+		w.dispatchEvent(&wcEvent)
+		return nil
 	}(); err != nil {
 		return err
 	}
@@ -226,14 +234,11 @@ func (w *WatchCache) Add(obj runtime.Object, clusterName string, resourceVersion
 		return err
 	}
 
-	event := watch.Event{Type: watch.Added, Object: object}
-	err = w.processEvent(event, resourceVersion, f)
-	if err != nil {
-		return err
-	}
+	// the consumers of the multi-cluster watch know where the change happened by the cluster annotation
+	utils.InjectClusterName(object, clusterName)
 
-	w.dispatchEvent(&event)
-	return nil
+	event := watch.Event{Type: watch.Added, Object: object}
+	return w.processEvent(event, resourceVersion, f)
 }
 
 // Update takes runtime.Object as an argument.
@@ -247,14 +252,11 @@ func (w *WatchCache) Update(obj runtime.Object, clusterName string, resourceVers
 		return err
 	}
 
-	event := watch.Event{Type: watch.Modified, Object: object}
-	err = w.processEvent(event, resourceVersion, f)
-	if err != nil {
-		return err
-	}
+	// the consumers of the multi-cluster watch know where the change happened by the cluster annotation
+	utils.InjectClusterName(object, clusterName)
 
-	w.dispatchEvent(&event)
-	return nil
+	event := watch.Event{Type: watch.Modified, Object: object}
+	return w.processEvent(event, resourceVersion, f)
 }
 
 // Delete takes runtime.Object as an argument.
@@ -268,14 +270,11 @@ func (w *WatchCache) Delete(obj runtime.Object, clusterName string, resourceVers
 		return err
 	}
 
-	event := watch.Event{Type: watch.Deleted, Object: object}
-	err = w.processEvent(event, resourceVersion, f)
-	if err != nil {
-		return err
-	}
+	// the consumers of the multi-cluster watch know where the change happened by the cluster annotation
+	utils.InjectClusterName(object, clusterName)
 
-	w.dispatchEvent(&event)
-	return nil
+	event := watch.Event{Type: watch.Deleted, Object: object}
+	return w.processEvent(event, resourceVersion, f)
 }
 
 // Assumes that lock is already held for write.
@@ -477,13 +476,61 @@ func (w *WatchCache) dispatchEvent(event *watch.Event) {
 	}
 }
 
+// DispatchBookmark sends the bookmark event with the resource version of the latest event to the watcher,
+// the watcher can resume from the resource version until the event is removed from the cache.
+// The bookmark is not sent if there is no event in the cache.
+func (w *WatchCache) DispatchBookmark(watcher *CacheWatcher) {
+	w.RLock()
+	defer w.RUnlock()
+
+	if w.endIndex == w.startIndex {
+		return
+	}
+	latest := w.cache[(w.endIndex-1)%w.capacity].Object
+	accessor, err := meta.Accessor(latest)
+	if err != nil {
+		return
+	}
+
+	var object runtime.Object
+	if _, ok := latest.(*unstructured.Unstructured); ok {
+		object = &unstructured.Unstructured{}
+	} else {
+		object = reflect.New(reflect.TypeOf(latest).Elem()).Interface().(runtime.Object)
+	}
+	object.GetObjectKind().SetGroupVersionKind(latest.GetObjectKind().GroupVersionKind())
+	if err := meta.NewAccessor().SetResourceVersion(object, accessor.GetResourceVersion()); err != nil {
+		return
+	}
+	watcher.NonblockingAdd(&watch.Event{Type: watch.Bookmark, Object: object})
+}
+
+// CleanCluster removes the objects of the cluster, the watchers receive the Deleted events of the objects,
+// so the consumers of the multi-cluster watch can remove the objects of the removed cluster.
 func (w *WatchCache) CleanCluster(cluster string) {
-	if !w.DeleteIndexer(cluster) {
+	items, ok := func() ([]interface{}, bool) {
+		w.Lock()
+		defer w.Unlock()
+
+		store, ok := w.stores[cluster]
+		if !ok {
+			return nil, false
+		}
+		delete(w.stores, cluster)
+
+		//clear cache
+		w.startIndex = 0
+		w.endIndex = 0
+		return store.List(), true
+	}()
+	if !ok {
 		return
 	}
 
-	errorEvent := utilwatch.NewErrorEvent(fmt.Errorf("cluster %s has been clean", cluster))
-	w.dispatchEvent(&errorEvent)
+	for _, item := range items {
+		event := watch.Event{Type: watch.Deleted, Object: item.(*StoreElement).Object}
+		w.dispatchEvent(&event)
+	}
 }
 
 func encodeEvent(obj runtime.Object, codec runtime.Codec, memoryVersion schema.GroupVersion) (runtime.Object, error) {
@@ -2,7 +2,12 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"io"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/watch"
@@ -27,6 +32,8 @@ type StorageFactory interface {
 	CleanClusterResource(ctx context.Context, cluster string, gvr schema.GroupVersionResource) error
 }
 
+// ResourceStorage stores the resources of a storage resource. The errors returned by its methods wrap the sentinels
+// of their causes, e.g. ErrNotFound and ErrTransient, the callers branch on errors.Is to retry or drop the operations.
 type ResourceStorage interface {
 	GetStorageConfig() *ResourceStorageConfig
 
@@ -41,6 +48,460 @@ type ResourceStorage interface {
 	Delete(ctx context.Context, cluster string, obj runtime.Object) error
 }
 
+// LatestResourceVersionGetter is optionally implemented by the ResourceStorage which persists the resources,
+// the synchro watches from the latest resource version to skip the initial list when the storage is already warm.
+type LatestResourceVersionGetter interface {
+	// GetLatestResourceVersion returns the max resource version of the stored resources of the cluster,
+	// it returns an empty string if there are no stored resources.
+	GetLatestResourceVersion(ctx context.Context, cluster string) (string, error)
+}
+
+// ClusterLeaser is optionally implemented by the StorageFactory to lease the clusters to the writers, e.g. the shards
+// of the synchros, so a cluster is never synced by two writers concurrently. The storage may reject the writes whose
+// leases are stale by the fencing tokens of the leases, the writers pass their leases by WithClusterLease.
+type ClusterLeaser interface {
+	// AcquireClusterLease acquires the lease of the cluster for the holder, the error wraps ErrConflict
+	// if the lease is held by another holder and not expired.
+	AcquireClusterLease(ctx context.Context, cluster, holder string, duration time.Duration) (ClusterLease, error)
+
+	// RenewClusterLease extends the lease held by the holder, the error wraps ErrFenced
+	// if the lease is acquired by another holder.
+	RenewClusterLease(ctx context.Context, lease ClusterLease, duration time.Duration) (ClusterLease, error)
+}
+
+// ClusterRenamer is optionally implemented by the StorageFactory to rename the stored cluster, e.g. the cluster
+// is re-registered by another name, so its resources and sync state are kept without relisting the resources.
+type ClusterRenamer interface {
+	// RenameCluster renames the cluster of the stored resources in batches, the interrupted rename is resumed by
+	// renaming the cluster again. The error wraps ErrConflict if the new name already has the stored resources.
+	RenameCluster(ctx context.Context, oldName, newName string) error
+}
+
+// ClusterResourceCounter is optionally implemented by the StorageFactory to count the stored resources of a cluster
+// by the storage resources, e.g. the synchro schedules the initial lists of the reconnected cluster by the counts.
+type ClusterResourceCounter interface {
+	CountClusterResources(ctx context.Context, cluster string) (map[schema.GroupVersionResource]int64, error)
+}
+
+// StorageEpochManager is optionally implemented by the StorageFactory whose stored resources may be lost or replaced
+// out of band, e.g. the resources are truncated or an old backup is restored. The epoch is changed when the loss of the
+// resources is detected or the epoch is reset, and the synchros relist the resources of all clusters from scratch.
+type StorageEpochManager interface {
+	// GetStorageEpoch returns the current epoch, the epoch is rewritten if the loss of the resources is detected.
+	GetStorageEpoch(ctx context.Context) (string, error)
+
+	// ResetStorageEpoch rewrites the epoch by a random value, so the resources of all clusters are resynced deliberately.
+	ResetStorageEpoch(ctx context.Context) (string, error)
+}
+
+// PreconditionDeleter is optionally implemented by the ResourceStorage to delete the stored object with the preconditions,
+// e.g. the cleanup tools delete the object only if it isn't recreated with another uid.
+type PreconditionDeleter interface {
+	// DeleteWithPreconditions deletes the stored object only if it matches the uid and the resource version of the preconditions,
+	// the error wraps ErrConflict if the stored object doesn't match them, and wraps ErrNotFound if the object isn't stored.
+	DeleteWithPreconditions(ctx context.Context, cluster, namespace, name string, preconditions *metav1.Preconditions) error
+}
+
+// ResourceStorageCloser is optionally implemented by the ResourceStorage which writes the objects asynchronously,
+// the writer of the storage closes it once it stops writing, so the accepted writes are written.
+type ResourceStorageCloser interface {
+	// Flush waits until the writes accepted before it are written, the error wraps ErrWriteDropped
+	// if some accepted writes are dropped since the last Flush.
+	Flush(ctx context.Context) error
+
+	// Close flushes the accepted writes, and the writes after it fail.
+	Close(ctx context.Context) error
+}
+
+// ResourceCounter is optionally implemented by the ResourceStorage to count the stored resources of a cluster,
+// e.g. the synchro compares the count with the synced resources to verify the storage after the initial list.
+type ResourceCounter interface {
+	CountResources(ctx context.Context, cluster string) (int64, error)
+}
+
+// ResourceAggregator is optionally implemented by the ResourceStorage to aggregate the stored resources
+// matched by the list options, the results are distinct, sorted and paginated by the limit and continue.
+type ResourceAggregator interface {
+	// ListNamespaces returns the namespaces of the stored resources, the cluster-scoped resources are ignored.
+	ListNamespaces(ctx context.Context, opts *internal.ListOptions) ([]string, error)
+
+	// ListClusters returns the clusters which the stored resources belong to.
+	ListClusters(ctx context.Context, opts *internal.ListOptions) ([]string, error)
+}
+
+// ResourceRequestsAggregator is optionally implemented by the ResourceStorage of the pods to sum the resource requests
+// of the containers of the pods matched by the list options, grouped by the clusters and namespaces.
+type ResourceRequestsAggregator interface {
+	// SumResourceRequests returns the summaries sorted by the cluster and namespace, the limit and continue are ignored.
+	SumResourceRequests(ctx context.Context, opts *internal.ListOptions) ([]ResourceRequestsSummary, error)
+}
+
+// ResourceRequestsSummary is the total resource requests of the containers of the pods in a namespace of a cluster,
+// the containers without the requests are counted but not summed.
+type ResourceRequestsSummary struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+
+	Containers int64             `json:"containers"`
+	CPU        resource.Quantity `json:"cpu"`
+	Memory     resource.Quantity `json:"memory"`
+}
+
+// ResourceStreamer is optionally implemented by the ResourceStorage to list the resources without
+// materializing all the objects at once, the objects are decoded and passed to the visitor one by one.
+type ResourceStreamer interface {
+	// ListStream lists the resources like ResourceStorage.List, the list meta with the continue and
+	// the remaining item count is computed up front, and passed to the visitor before the objects.
+	ListStream(ctx context.Context, newObject func() runtime.Object, opts *internal.ListOptions, visitor ListVisitor) error
+}
+
+// ListVisitor visits the streamed list, VisitListMeta is called once before VisitObject.
+type ListVisitor interface {
+	VisitListMeta(meta metav1.ListMeta) error
+	VisitObject(obj runtime.Object) error
+}
+
+// CheckpointStore persists the watch progress of the resources synced from the clusters,
+// the synchro resumes the watch from the saved resource version after restart.
+type CheckpointStore interface {
+	Save(ctx context.Context, cluster string, gvr schema.GroupVersionResource, resourceVersion string) error
+
+	// Load returns an empty string if there is no checkpoint of the resource.
+	Load(ctx context.Context, cluster string, gvr schema.GroupVersionResource) (string, error)
+}
+
+// CheckpointStoreFactory is optionally implemented by the StorageFactory to support the CheckpointStore.
+type CheckpointStoreFactory interface {
+	NewCheckpointStore() CheckpointStore
+}
+
+// SecondaryDataRebuilder is optionally implemented by the StorageFactory to rebuild the secondary data
+// derived from the stored objects, e.g. the owner uid, it is safe to run while the resources are synced.
+type SecondaryDataRebuilder interface {
+	RebuildSecondaryData(ctx context.Context, opts RebuildOptions) (RebuildSummary, error)
+}
+
+type RebuildOptions struct {
+	// VerifyOnly reports the mismatches of the secondary data without fixing them.
+	VerifyOnly bool
+
+	// BatchSize is the number of the resources scanned by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+
+	// RowsPerSecond limits the rate of the scanned resources, the storage uses its default if it is not positive.
+	RowsPerSecond int
+
+	// Cursor resumes the rebuilding from the cursor of the summary, the rebuilding starts from the beginning if it is empty.
+	Cursor string
+
+	// Progress is called with the summary after each batch, the cursor of the summary can be saved to resume the rebuilding.
+	Progress func(RebuildSummary)
+}
+
+type RebuildSummary struct {
+	Scanned    int64
+	Mismatched int64
+	Fixed      int64
+
+	// Conflicted is the number of the mismatched resources which are updated by the sync during the rebuilding,
+	// they are rebuilt by the sync.
+	Conflicted int64
+
+	Cursor string
+}
+
+// ChecksumVerifier is optionally implemented by the StorageFactory to scan the stored objects for the ones mismatched
+// with their checksums, e.g. corrupted by the partial writes, it is safe to run while the resources are synced.
+type ChecksumVerifier interface {
+	VerifyChecksums(ctx context.Context, opts ChecksumVerifyOptions) (ChecksumVerifySummary, error)
+}
+
+type ChecksumVerifyOptions struct {
+	// Repair deletes the mismatched resources, so they are recreated by the sync when the resources are relisted,
+	// the mismatched resources are only reported if it is false.
+	Repair bool
+
+	// BatchSize is the number of the resources scanned by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+
+	// RowsPerSecond limits the rate of the scanned resources, the storage uses its default if it is not positive.
+	RowsPerSecond int
+
+	// Cursor resumes the scanning from the cursor of the summary, the scanning starts from the beginning if it is empty.
+	Cursor string
+
+	// Progress is called with the summary after each batch, the cursor of the summary can be saved to resume the scanning.
+	Progress func(ChecksumVerifySummary)
+}
+
+type ChecksumVerifySummary struct {
+	Scanned int64
+
+	// Unchecked is the number of the resources without the checksums, e.g. the ones written before the checksums are supported.
+	Unchecked int64
+
+	Mismatched int64
+	Repaired   int64
+
+	// Conflicted is the number of the mismatched resources which are updated by the sync during the scanning,
+	// they are no longer corrupted.
+	Conflicted int64
+
+	Cursor string
+}
+
+// EncryptionRewriter is optionally implemented by the StorageFactory to rewrite the stored objects by the current
+// encryption config, e.g. after the key is rotated or the resources are added to or removed from the encryption.
+type EncryptionRewriter interface {
+	RewriteEncryption(ctx context.Context, opts EncryptionRewriteOptions) (EncryptionRewriteSummary, error)
+}
+
+type EncryptionRewriteOptions struct {
+	// DryRun only counts the resources to be rewritten.
+	DryRun bool
+
+	// BatchSize is the number of the resources scanned by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+
+	// RowsPerSecond limits the rate of the scanned resources, the storage uses its default if it is not positive.
+	RowsPerSecond int
+
+	// Cursor resumes the scanning from the cursor of the summary, the scanning starts from the beginning if it is empty.
+	Cursor string
+
+	// Progress is called with the summary after each batch, the cursor of the summary can be saved to resume the scanning.
+	Progress func(EncryptionRewriteSummary)
+}
+
+type EncryptionRewriteSummary struct {
+	Scanned int64
+
+	// Encrypted is the number of the resources encrypted by the current primary key, including the re-encrypted ones.
+	Encrypted int64
+
+	// Decrypted is the number of the encrypted resources whose resources are removed from the encryption.
+	Decrypted int64
+
+	// Failed is the number of the resources which can't be decrypted, e.g. encrypted by the removed key.
+	Failed int64
+
+	// Conflicted is the number of the resources which are updated by the sync during the scanning,
+	// they are written by the current encryption config.
+	Conflicted int64
+
+	Cursor string
+}
+
+// ResourceExporter is optionally implemented by the StorageFactory to export the stored resources to an archive,
+// and import the archive into the empty storage, e.g. for the test fixtures.
+type ResourceExporter interface {
+	// Export streams the resources matched by the list options to the writer in batches,
+	// the limit, continue and order by of the list options are ignored.
+	Export(ctx context.Context, opts ExportOptions, w io.Writer) (ExportManifest, error)
+
+	// Import loads the archive written by Export, the resources which have been stored are skipped,
+	// so the interrupted import can be rerun.
+	Import(ctx context.Context, opts ImportOptions, r io.Reader) (ImportSummary, error)
+}
+
+type ExportFormat string
+
+const (
+	// ExportFormatNDJSON writes each resource as a line of json, the manifest is the last line.
+	ExportFormatNDJSON ExportFormat = "ndjson"
+
+	// ExportFormatTar writes the resources of each GVR as a multi-document yaml file of a tar archive,
+	// the manifest is the `manifest.json` file at the end of the archive.
+	ExportFormatTar ExportFormat = "tar"
+)
+
+type ExportOptions struct {
+	Format      ExportFormat
+	ListOptions *internal.ListOptions
+
+	// BatchSize is the number of the resources read by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+
+	// Cursor resumes the interrupted export, the remaining resources are written to the writer.
+	// The tar archive is resumed by the GVR, so the resumed export should be written to a new archive.
+	Cursor string
+
+	// Progress is called with the cursor after the resources are written, the cursor can be saved to resume the export.
+	Progress func(cursor string)
+}
+
+type ExportManifest struct {
+	ExportedAt time.Time `json:"exportedAt"`
+
+	// Counts is the number of the exported resources keyed by the `<group>/<version>/<resource>`,
+	// the resources exported before the resuming are counted.
+	Counts map[string]int64 `json:"counts"`
+}
+
+type ImportOptions struct {
+	Format ExportFormat
+
+	// BatchSize is the number of the resources written by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+}
+
+type ImportSummary struct {
+	Imported int64
+	Skipped  int64
+}
+
+// ClusterStateDumper is optionally implemented by the StorageFactory to dump the sync state of a cluster,
+// i.e. the stored resources with their created and synced times and the checkpoints, and restore the dump into
+// another storage, e.g. of another dialect, so the cluster is migrated without relisting its resources.
+type ClusterStateDumper interface {
+	// DumpClusterState streams the state of the cluster to the writer as the lines of json,
+	// the manifest with the counts and the checksum of the state is the last line.
+	DumpClusterState(ctx context.Context, cluster string, opts DumpOptions, w io.Writer) (ClusterStateManifest, error)
+
+	// RestoreClusterState loads the dump in batches, and validates the restored state with the manifest at the end.
+	// The resources which have been stored are skipped, and the interrupted restore is resumed by the cursor.
+	RestoreClusterState(ctx context.Context, opts RestoreOptions, r io.Reader) (RestoreSummary, error)
+}
+
+type DumpOptions struct {
+	// BatchSize is the number of the resources read by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+}
+
+type ClusterStateManifest struct {
+	Cluster  string    `json:"cluster"`
+	DumpedAt time.Time `json:"dumpedAt"`
+
+	Resources   int64 `json:"resources"`
+	Checkpoints int64 `json:"checkpoints"`
+
+	// Checksum is the checksum of the resources and the checkpoints regardless of their order and encoding,
+	// so it can be compared across the dialects.
+	Checksum string `json:"checksum"`
+}
+
+type RestoreOptions struct {
+	// BatchSize is the number of the resources written by each batch, the storage uses its default if it is not positive.
+	BatchSize int
+
+	// Cursor resumes the interrupted restore from the last confirmed batch, the records before it are skipped.
+	Cursor string
+
+	// Progress is called with the cursor after each batch is written, the cursor can be saved to resume the restore.
+	Progress func(cursor string)
+}
+
+type RestoreSummary struct {
+	Manifest ClusterStateManifest
+
+	Restored    int64
+	Skipped     int64
+	Checkpoints int64
+
+	Cursor string
+}
+
+// StorageStatsReporter is optionally implemented by the StorageFactory to report the storage usage of the resources,
+// e.g. for the capacity planning. The stats are the result of the last periodic refresh instead of querying on each call.
+type StorageStatsReporter interface {
+	StorageStats() ([]DatabaseStats, error)
+}
+
+type DatabaseStats struct {
+	Name    string `json:"name"`
+	Dialect string `json:"dialect"`
+
+	// TableBytes is the total size of the resources table including the indexes, it is nil if the storage doesn't report it.
+	TableBytes *int64 `json:"tableBytes,omitempty"`
+
+	// RefreshedAt is the time of the last refresh, the stats are empty before the first refresh.
+	RefreshedAt time.Time `json:"refreshedAt"`
+
+	Resources []ResourceStats `json:"resources"`
+}
+
+// ResourceStats is the usage of the resources of a group resource in a cluster, the versions of the group resource are merged.
+type ResourceStats struct {
+	Cluster  string `json:"cluster"`
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+
+	Count int64 `json:"count"`
+
+	// Bytes is the total size of the encoded objects, and AvgBytes is the average size of an object.
+	Bytes    int64 `json:"bytes"`
+	AvgBytes int64 `json:"avgBytes"`
+
+	SyncedAt time.Time `json:"syncedAt"`
+}
+
+// ClusterDiffer is optionally implemented by the StorageFactory to compare the stored resources of two clusters,
+// e.g. to find the resources which aren't migrated from a cluster to another.
+type ClusterDiffer interface {
+	DiffClusters(ctx context.Context, opts DiffOptions) (*DiffReport, error)
+}
+
+type DiffOptions struct {
+	Source string
+	Target string
+
+	// Resources limits the compared resources, the empty version matches all versions of the group resource.
+	// All resources are compared if it is empty. The resources of the clusters are matched by the group, resource,
+	// namespace and name regardless of the versions.
+	Resources []schema.GroupVersionResource
+
+	// NamespaceMapping maps the namespaces of the source cluster to the namespaces of the target cluster,
+	// the resources of the unmapped namespaces are compared with the ones of the same namespaces.
+	NamespaceMapping map[string]string
+
+	// Compare compares the objects of the resources existing in both clusters, the cluster-specific fields,
+	// e.g. the uid, resource version, creation timestamp and status, are ignored.
+	Compare bool
+
+	// Limit is the maximum number of the items of the report, the storage uses its default if it is not positive.
+	Limit int64
+
+	// Continue is the continue of the previous report, the report starts from the beginning if it is empty.
+	Continue string
+}
+
+type DiffType string
+
+const (
+	// DiffMissingInTarget is the resource which only exists in the source cluster.
+	DiffMissingInTarget DiffType = "MissingInTarget"
+
+	// DiffMissingInSource is the resource which only exists in the target cluster.
+	DiffMissingInSource DiffType = "MissingInSource"
+
+	// DiffDifferent is the resource whose objects are different in the clusters, it is only reported by the Compare.
+	DiffDifferent DiffType = "Different"
+)
+
+// DiffReport is a page of the differences of the clusters, the items are reported by the type,
+// and the items of a type are sorted by the group, resource, namespace and name.
+type DiffReport struct {
+	Source string     `json:"source"`
+	Target string     `json:"target"`
+	Items  []DiffItem `json:"items"`
+
+	// Continue is set when there may be more items, it is passed by the DiffOptions of the next request.
+	Continue string `json:"continue,omitempty"`
+}
+
+type DiffItem struct {
+	Type     DiffType `json:"type"`
+	Group    string   `json:"group,omitempty"`
+	Resource string   `json:"resource"`
+
+	// Namespace is the namespace in the target cluster, and SourceNamespace is the namespace in the source cluster
+	// if it is mapped to another namespace.
+	Namespace       string `json:"namespace,omitempty"`
+	SourceNamespace string `json:"sourceNamespace,omitempty"`
+
+	Name string `json:"name"`
+}
+
 type CollectionResourceStorage interface {
 	Get(ctx context.Context, opts *internal.ListOptions) (*internal.CollectionResource, error)
 }
@@ -55,17 +516,64 @@ type ResourceStorageConfig struct {
 	StorageVersion schema.GroupVersion
 
 	Codec runtime.Codec
+
+	// LegacyCodecs are the codecs of the previous storage versions in priority order, the objects which can't be
+	// decoded by the Codec are decoded by them, e.g. the objects encoded before the storage version is changed.
+	LegacyCodecs []VersionedCodec
+}
+
+// VersionedCodec is the codec of a storage version of the resource.
+type VersionedCodec struct {
+	Version schema.GroupVersion
+	Codec   runtime.Codec
 }
 
 type storageRecoverableExceptionError struct {
 	error
 }
 
+func (e storageRecoverableExceptionError) Unwrap() error {
+	return e.error
+}
+
+// Is reports the recoverable exception is transient.
+func (e storageRecoverableExceptionError) Is(target error) bool {
+	return target == ErrTransient
+}
+
 func NewRecoverableException(err error) error {
 	return storageRecoverableExceptionError{err}
 }
 
 func IsRecoverableException(err error) bool {
-	_, ok := err.(storageRecoverableExceptionError)
-	return ok
+	var recoverableErr storageRecoverableExceptionError
+	return errors.As(err, &recoverableErr)
+}
+
+// ResourceMetadataGetter is optionally implemented by the ResourceStorage to get the metadata of the object without
+// reading and decoding the whole object, e.g. for the gets of the PartialObjectMetadata.
+type ResourceMetadataGetter interface {
+	// GetMetadata gets the metadata of the object, the TypeMeta of the into is set to the stored group, version and kind.
+	GetMetadata(ctx context.Context, cluster, namespace, name string, into *metav1.PartialObjectMetadata) error
+}
+
+// ResourceHistoryReader is optionally implemented by the ResourceStorage to read the previous revisions of the objects,
+// the revisions are only recorded for the resources whose history is enabled by the storage.
+type ResourceHistoryReader interface {
+	// GetAt gets the object as it was at the time, it returns NotFound if the object didn't exist at the time
+	// or the revision valid at the time isn't retained.
+	GetAt(ctx context.Context, cluster, namespace, name string, at time.Time, into runtime.Object) error
+
+	// ListRevisions lists the retained revisions of the object, the newest first, the current object isn't included.
+	ListRevisions(ctx context.Context, cluster, namespace, name string) ([]ResourceRevision, error)
+}
+
+// ResourceRevision is a previous revision of the object, which was valid from ValidFrom until ValidUntil.
+type ResourceRevision struct {
+	ResourceVersion string    `json:"resourceVersion"`
+	ValidFrom       time.Time `json:"validFrom"`
+	ValidUntil      time.Time `json:"validUntil"`
+
+	// Deleted is set if the revision is ended by the deletion of the object.
+	Deleted bool `json:"deleted,omitempty"`
 }
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+// DefaultMaxListOptionsItems is the default maximum number of the items of each list in the list options.
+const DefaultMaxListOptionsItems = 1000
+
+// ListOptionsValidation bounds the user-controllable inputs of the list options before they are passed to the storage.
+type ListOptionsValidation struct {
+	// MaxItems is the maximum number of the items of each list, e.g. the clusters, namespaces, names,
+	// the order by fields and the values of the selector requirements.
+	// DefaultMaxListOptionsItems is used if it is not positive.
+	MaxItems int
+
+	// OrderByFields are the allowed order by fields, any field is allowed if it is nil.
+	OrderByFields sets.Set[string]
+
+	// OrderByPath returns whether the order by field not in the OrderByFields is allowed, e.g. the json path of the objects.
+	OrderByPath func(field string) bool
+
+	// SelectorKeys are the keys of the extra label selector whose values are validated,
+	// e.g. the key of the fuzzy name.
+	SelectorKeys sets.Set[string]
+}
+
+// ValidateListOptions validates the list options, a BadRequest error with the field paths of the invalid inputs is returned.
+func ValidateListOptions(opts *internal.ListOptions, validation ListOptionsValidation) error {
+	maxItems := validation.MaxItems
+	if maxItems <= 0 {
+		maxItems = DefaultMaxListOptionsItems
+	}
+
+	var errs field.ErrorList
+	validateValues := func(path *field.Path, values []string) {
+		if len(values) > maxItems {
+			errs = append(errs, field.TooMany(path, len(values), maxItems))
+			return
+		}
+		for i, value := range values {
+			errs = append(errs, validateValue(path.Index(i), value)...)
+		}
+	}
+
+	validateValues(field.NewPath("clusters"), opts.ClusterNames)
+	validateValues(field.NewPath("namespaces"), opts.Namespaces)
+	validateValues(field.NewPath("names"), opts.Names)
+	errs = append(errs, validateValue(field.NewPath("ownerName"), opts.OwnerName)...)
+	errs = append(errs, validateValue(field.NewPath("ownerNamespace"), opts.OwnerNamespace)...)
+	errs = append(errs, validateValue(field.NewPath("ownerUID"), opts.OwnerUID)...)
+
+	orderByPath := field.NewPath("orderby")
+	if len(opts.OrderBy) > maxItems {
+		errs = append(errs, field.TooMany(orderByPath, len(opts.OrderBy), maxItems))
+	} else if validation.OrderByFields != nil {
+		for i, orderBy := range opts.OrderBy {
+			if validation.OrderByFields.Has(orderBy.Field) {
+				continue
+			}
+			if validation.OrderByPath == nil || !validation.OrderByPath(orderBy.Field) {
+				errs = append(errs, field.NotSupported(orderByPath.Index(i).Child("field"), orderBy.Field, sets.List(validation.OrderByFields)))
+			}
+		}
+	}
+
+	if opts.LabelSelector != nil {
+		if requirements, selectable := opts.LabelSelector.Requirements(); selectable {
+			path := field.NewPath("labelSelector")
+			for _, requirement := range requirements {
+				if values := requirement.Values(); values.Len() > maxItems {
+					errs = append(errs, field.TooMany(path.Key(requirement.Key()), values.Len(), maxItems))
+				}
+			}
+		}
+	}
+
+	if opts.ExtraLabelSelector != nil && validation.SelectorKeys != nil {
+		if requirements, selectable := opts.ExtraLabelSelector.Requirements(); selectable {
+			path := field.NewPath("labelSelector")
+			for _, requirement := range requirements {
+				if validation.SelectorKeys.Has(requirement.Key()) {
+					validateValues(path.Key(requirement.Key()), requirement.Values().List())
+				}
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid list options: %s", errs.ToAggregate()))
+	}
+	return nil
+}
+
+func validateValue(path *field.Path, value string) field.ErrorList {
+	if strings.IndexFunc(value, unicode.IsControl) != -1 {
+		return field.ErrorList{field.Invalid(path, value, "must not contain the control characters")}
+	}
+	return nil
+}
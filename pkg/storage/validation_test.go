@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestValidateListOptions(t *testing.T) {
+	fuzzySelector, err := labels.Parse("fuzzy in (foo, bar, baz)")
+	assert.NoError(t, err)
+
+	validation := ListOptionsValidation{
+		MaxItems:      2,
+		OrderByFields: sets.New("name"),
+		OrderByPath:   func(field string) bool { return strings.HasPrefix(field, "spec.") },
+		SelectorKeys:  sets.New("fuzzy"),
+	}
+	tests := []struct {
+		name    string
+		opts    *internal.ListOptions
+		message string
+	}{
+		{
+			"valid",
+			&internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2"}, OrderBy: []internal.OrderBy{{Field: "name"}, {Field: "spec.replicas"}}},
+			"",
+		},
+		{
+			"too many clusters",
+			&internal.ListOptions{ClusterNames: []string{"cluster-1", "cluster-2", "cluster-3"}},
+			"invalid list options: clusters: Too many: 3: must have at most 2 items",
+		},
+		{
+			"control characters",
+			&internal.ListOptions{Namespaces: []string{"default", "kube-system\n"}, OwnerName: "foo\x00"},
+			`invalid list options: [namespaces[1]: Invalid value: "kube-system\n": must not contain the control characters, ownerName: Invalid value: "foo\x00": must not contain the control characters]`,
+		},
+		{
+			"unsupported order by",
+			&internal.ListOptions{OrderBy: []internal.OrderBy{{Field: "name"}, {Field: "name; DROP TABLE resources"}}},
+			`invalid list options: orderby[1].field: Unsupported value: "name; DROP TABLE resources": supported values: "name"`,
+		},
+		{
+			"too many fuzzy values",
+			&internal.ListOptions{ExtraLabelSelector: fuzzySelector},
+			"invalid list options: labelSelector[fuzzy]: Too many: 3: must have at most 2 items",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateListOptions(test.opts, validation)
+			if test.message == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.True(t, apierrors.IsBadRequest(err))
+			assert.Equal(t, test.message, err.Error())
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package clustersynchro
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// clusterLeaseKeeper acquires and renews the lease of the cluster in the storage,
+// the writes of the resource synchros carry the lease, so the storage can fence the writes of the stale holder.
+type clusterLeaseKeeper struct {
+	cluster  string
+	holder   string
+	duration time.Duration
+	leaser   storage.ClusterLeaser
+
+	lease atomic.Pointer[storage.ClusterLease]
+}
+
+// newClusterLeaseKeeper returns nil if the lease is not configured or the storage does not support the ClusterLeaser.
+func newClusterLeaseKeeper(cluster string, factory storage.StorageFactory, config ClusterSyncConfig) *clusterLeaseKeeper {
+	leaser, ok := factory.(storage.ClusterLeaser)
+	if !ok || config.LeaseHolder == "" || config.LeaseDuration <= 0 {
+		return nil
+	}
+	return &clusterLeaseKeeper{cluster: cluster, holder: config.LeaseHolder, duration: config.LeaseDuration, leaser: leaser}
+}
+
+// run keeps the lease until the stopCh is closed, the lease is renewed three times in its duration.
+func (k *clusterLeaseKeeper) run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(k.duration / 3)
+	defer ticker.Stop()
+	for {
+		k.keep()
+
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *clusterLeaseKeeper) keep() {
+	ctx, cancel := context.WithTimeout(context.Background(), k.duration/3)
+	defer cancel()
+
+	current := k.lease.Load()
+	if current == nil {
+		lease, err := k.leaser.AcquireClusterLease(ctx, k.cluster, k.holder, k.duration)
+		if err != nil {
+			klog.ErrorS(err, "Failed to acquire the cluster lease", "cluster", k.cluster, "holder", k.holder)
+			return
+		}
+		klog.InfoS("Acquired the cluster lease", "cluster", k.cluster, "holder", k.holder, "token", lease.Token)
+		k.lease.Store(&lease)
+		return
+	}
+
+	lease, err := k.leaser.RenewClusterLease(ctx, *current, k.duration)
+	if err != nil {
+		klog.ErrorS(err, "Failed to renew the cluster lease", "cluster", k.cluster, "holder", k.holder, "token", current.Token)
+		if errors.Is(err, storage.ErrFenced) {
+			// the lease is acquired by another holder, acquire it again after it expires
+			k.lease.Store(nil)
+		}
+		return
+	}
+	k.lease.Store(&lease)
+}
+
+// current returns the lease held by the keeper.
+func (k *clusterLeaseKeeper) current() (storage.ClusterLease, bool) {
+	if k == nil {
+		return storage.ClusterLease{}, false
+	}
+	if lease := k.lease.Load(); lease != nil {
+		return *lease, true
+	}
+	return storage.ClusterLease{}, false
+}
@@ -0,0 +1,66 @@
+package clustersynchro
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// fakeClusterLeaser leases the cluster to the holder, the lease is taken over by setting the holder.
+type fakeClusterLeaser struct {
+	storage.StorageFactory
+
+	holder string
+	token  int64
+}
+
+func (l *fakeClusterLeaser) AcquireClusterLease(_ context.Context, cluster, holder string, duration time.Duration) (storage.ClusterLease, error) {
+	if l.holder != "" && l.holder != holder {
+		return storage.ClusterLease{}, storage.NewError(storage.ErrConflict, fmt.Errorf("held by %s", l.holder))
+	}
+	if l.holder != holder {
+		l.holder, l.token = holder, l.token+1
+	}
+	return storage.ClusterLease{Cluster: cluster, Holder: holder, Token: l.token, ExpiresAt: time.Now().Add(duration)}, nil
+}
+
+func (l *fakeClusterLeaser) RenewClusterLease(_ context.Context, lease storage.ClusterLease, duration time.Duration) (storage.ClusterLease, error) {
+	if l.holder != lease.Holder || l.token != lease.Token {
+		return storage.ClusterLease{}, storage.NewError(storage.ErrFenced, fmt.Errorf("taken over by %s", l.holder))
+	}
+	lease.ExpiresAt = time.Now().Add(duration)
+	return lease, nil
+}
+
+func TestClusterLeaseKeeper(t *testing.T) {
+	assert.Nil(t, newClusterLeaseKeeper("cluster-1", &fakeClusterLeaser{}, ClusterSyncConfig{LeaseHolder: "shard-a"}))
+
+	leaser := &fakeClusterLeaser{holder: "shard-b"}
+	keeper := newClusterLeaseKeeper("cluster-1", leaser, ClusterSyncConfig{LeaseHolder: "shard-a", LeaseDuration: time.Minute})
+
+	// the lease held by another holder isn't acquired
+	keeper.keep()
+	_, ok := keeper.current()
+	assert.False(t, ok)
+
+	leaser.holder = ""
+	keeper.keep()
+	lease, ok := keeper.current()
+	assert.True(t, ok)
+	assert.Equal(t, "shard-a", lease.Holder)
+
+	// the lease taken over by another holder is dropped
+	leaser.holder, leaser.token = "shard-b", leaser.token+1
+	keeper.keep()
+	_, ok = keeper.current()
+	assert.False(t, ok)
+
+	synchro := &ResourceSynchro{clusterLease: keeper.current}
+	_, ok = storage.ClusterLeaseFrom(synchro.withClusterLease(context.Background()))
+	assert.False(t, ok)
+}
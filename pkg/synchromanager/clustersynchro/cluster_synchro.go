@@ -14,6 +14,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
+	"k8s.io/utils/clock"
 
 	clusterv1alpha2 "github.com/clusterpedia-io/api/cluster/v1alpha2"
 	"github.com/clusterpedia-io/clusterpedia/pkg/discovery"
@@ -28,8 +29,57 @@ import (
 type ClusterSyncConfig struct {
 	MetricsStoreBuilder     *kubestatemetrics.MetricsStoreBuilder
 	PageSizeForResourceSync int64
+
+	// MinPageSizeForResourceSync and MaxPageSizeForResourceSync bound the adaptive page size
+	MinPageSizeForResourceSync int64
+	MaxPageSizeForResourceSync int64
+
+	// RelistOnlyResources are synced by the periodic relist instead of the watch
+	RelistOnlyResources []schema.GroupResource
+
+	// ResourcePriorities are the sync priorities of the resources, the greater value has the higher priority,
+	// and the priority of the unlisted resources is 0. The resources of a priority start syncing after
+	// the resources of all the higher priorities are initially synced.
+	ResourcePriorities map[schema.GroupResource]int
+
+	// InitialSyncSchedule staggers the initial lists of the large resources of the reconnected cluster by their stored counts,
+	// the resources never stored in the storage start immediately. The schedule is disabled if it is nil.
+	InitialSyncSchedule *informer.StartScheduleConfig
+
+	// GVKMismatchPolicy handles the watch events whose version is different from the synced version,
+	// e.g. the storage version of the CRD is changed in the member cluster.
+	GVKMismatchPolicy informer.GVKMismatchPolicy
+
+	// MaxConsecutiveForbidden stops the informer of the resource after the number of consecutive forbidden errors,
+	// the stopped informer is restarted periodically to check whether the permissions are granted again.
+	// 0 means the informer keeps retrying with the backoff.
+	MaxConsecutiveForbidden int
+
+	// ShortWatchThreshold is the duration under which the watch closed without any events is unexpected,
+	// 0 means the default of the informer.
+	ShortWatchThreshold time.Duration
+
+	// LeaseHolder and LeaseDuration are the holder identity and the duration of the leases of the clusters,
+	// the synchro writes the resources of the cluster with its lease, so the stale writer can be fenced by the storage.
+	// The leases are disabled if they are empty, or the storage does not support the ClusterLeaser.
+	LeaseHolder   string
+	LeaseDuration time.Duration
+
+	// InitializedSyncs aggregates whether the initial lists of all the synced resources of each cluster are synced, it can be nil.
+	InitializedSyncs *informer.InitializedSyncs
+
+	// StoreConsistencyChecker verifies the stored resources after the initial lists are synced,
+	// the check is disabled if it is nil or the storage does not support the ResourceCounter.
+	StoreConsistencyChecker *StoreConsistencyChecker
+
+	// StorageEpochCheckInterval is the interval the manager checks the epoch of the storage, the resources of all clusters
+	// are relisted from scratch when the epoch is changed. The check is disabled if it is 0 or the storage doesn't support the epoch.
+	StorageEpochCheckInterval time.Duration
 }
 
+// defaultStartGateTimeout is the max time a resource waits for the resources of the higher priorities.
+const defaultStartGateTimeout = 10 * time.Minute
+
 type ClusterSynchro struct {
 	name string
 
@@ -37,10 +87,17 @@ type ClusterSynchro struct {
 	ClusterStatusUpdater ClusterStatusUpdater
 
 	storage              storage.StorageFactory
+	checkpointStore      storage.CheckpointStore
 	syncConfig           ClusterSyncConfig
 	healthChecker        *healthChecker
 	dynamicDiscovery     discovery.DynamicDiscoveryInterface
 	listerWatcherFactory informer.DynamicListerWatcherFactory
+	// startGates is nil if the priorities of the resources are not configured
+	startGates *informer.PriorityStartGates
+	// startScheduler is nil if the initial sync schedule is not configured
+	startScheduler *informer.StartScheduler
+	// leaseKeeper is nil if the lease of the cluster is disabled
+	leaseKeeper *clusterLeaseKeeper
 
 	closeOnce sync.Once
 	closer    chan struct{}
@@ -107,6 +164,8 @@ func New(name string, config *rest.Config, storage storage.StorageFactory, updat
 		RESTConfig:           config,
 		ClusterStatusUpdater: updater,
 		storage:              storage,
+		checkpointStore:      newCheckpointStore(storage),
+		leaseKeeper:          newClusterLeaseKeeper(name, storage, syncConfig),
 
 		syncConfig:           syncConfig,
 		healthChecker:        healthChecker,
@@ -122,6 +181,12 @@ func New(name string, config *rest.Config, storage storage.StorageFactory, updat
 
 		storageResourceVersions: make(map[schema.GroupVersionResource]map[string]interface{}),
 	}
+	if len(syncConfig.ResourcePriorities) != 0 {
+		synchro.startGates = informer.NewPriorityStartGates(clock.RealClock{}, defaultStartGateTimeout)
+	}
+	if syncConfig.InitialSyncSchedule != nil {
+		synchro.startScheduler = informer.NewStartScheduler(clock.RealClock{}, countStoredResources(storage, name, resourceversions), *syncConfig.InitialSyncSchedule)
+	}
 
 	var refresherOnce sync.Once
 	synchro.dynamicDiscovery.Prepare(discovery.PrepareConfig{
@@ -165,6 +230,14 @@ func New(name string, config *rest.Config, storage storage.StorageFactory, updat
 	return synchro, nil
 }
 
+// newCheckpointStore returns nil if the storage does not support the CheckpointStore.
+func newCheckpointStore(factory storage.StorageFactory) storage.CheckpointStore {
+	if f, ok := factory.(storage.CheckpointStoreFactory); ok {
+		return f.NewCheckpointStore()
+	}
+	return nil
+}
+
 func (s *ClusterSynchro) GetMetricsWriterList() (writers metricsstore.MetricsWriterList) {
 	s.storageResourceSynchros.Range(func(_, value interface{}) bool {
 		if synchro := value.(*ResourceSynchro); synchro.metricsWriter != nil {
@@ -186,6 +259,29 @@ func (s *ClusterSynchro) initWithResourceVersions(resourceversions map[schema.Gr
 	}
 }
 
+// RelistFromScratch reloads the resource versions of the cluster from the storage, and relists all the resources
+// of the cluster from scratch, e.g. the epoch of the storage is changed since the stored resources are lost or replaced.
+func (s *ClusterSynchro) RelistFromScratch(ctx context.Context) error {
+	resourceversions, err := s.storage.GetResourceVersions(ctx, s.name)
+	if err != nil {
+		return fmt.Errorf("failed to get resource versions from storage: %w", err)
+	}
+
+	s.runnerLock.Lock()
+	defer s.runnerLock.Unlock()
+	for storageGVR, rvs := range resourceversions {
+		// the stored resources which are not synced are cleaned by the next negotiation of the synced resources
+		if _, ok := s.storageResourceVersions[storageGVR]; !ok {
+			s.storageResourceVersions[storageGVR] = rvs
+		}
+	}
+	s.storageResourceSynchros.Range(func(key, value interface{}) bool {
+		value.(*ResourceSynchro).relistFromScratch(resourceversions[key.(schema.GroupVersionResource)])
+		return true
+	})
+	return nil
+}
+
 func (s *ClusterSynchro) Run(shutdown <-chan struct{}) {
 	runningCondition := metav1.Condition{
 		Type:               clusterv1alpha2.SynchroRunningCondition,
@@ -198,6 +294,9 @@ func (s *ClusterSynchro) Run(shutdown <-chan struct{}) {
 
 	s.waitGroup.Start(s.monitor)
 	s.waitGroup.Start(s.runner)
+	if s.leaseKeeper != nil {
+		s.waitGroup.StartWithChannel(s.closer, s.leaseKeeper.run)
+	}
 
 	go func() {
 		defer close(s.closed)
@@ -366,9 +465,21 @@ func (s *ClusterSynchro) refreshSyncResources() {
 					ListerWatcher:        s.listerWatcherFactory.ForResource(metav1.NamespaceAll, config.syncResource),
 					ObjectConvertor:      config.convertor,
 					ResourceStorage:      resourceStorage,
+					CheckpointStore:      s.checkpointStore,
 					MetricsStore:         metricsStore,
 					ResourceVersions:     rvs,
 					PageSizeForInformer:  s.syncConfig.PageSizeForResourceSync,
+
+					MinPageSizeForInformer:  s.syncConfig.MinPageSizeForResourceSync,
+					MaxPageSizeForInformer:  s.syncConfig.MaxPageSizeForResourceSync,
+					ForceRelistOnly:         s.isRelistOnlyResource(config.syncResource.GroupResource()),
+					StartGate:               s.startGate(config.syncResource.GroupResource(), storageGVR),
+					GVKMismatchPolicy:       s.syncConfig.GVKMismatchPolicy,
+					MaxConsecutiveForbidden: s.syncConfig.MaxConsecutiveForbidden,
+					ShortWatchThreshold:     s.syncConfig.ShortWatchThreshold,
+					ClusterLease:            s.leaseKeeper.current,
+					InitializedSyncs:        s.syncConfig.InitializedSyncs,
+					StoreConsistencyChecker: s.syncConfig.StoreConsistencyChecker,
 				},
 			)
 			s.waitGroup.StartWithChannel(s.closer, synchro.Run)
@@ -438,6 +549,46 @@ func (s *ClusterSynchro) refreshSyncResources() {
 	}
 }
 
+func (s *ClusterSynchro) isRelistOnlyResource(gr schema.GroupResource) bool {
+	for _, resource := range s.syncConfig.RelistOnlyResources {
+		if resource == gr {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ClusterSynchro) startGate(gr schema.GroupResource, storageGVR schema.GroupVersionResource) informer.StartGate {
+	var priorityGate, scheduledGate informer.StartGate
+	if s.startGates != nil {
+		priorityGate = s.startGates.Gate(s.syncConfig.ResourcePriorities[gr])
+	}
+	if s.startScheduler != nil {
+		scheduledGate = s.startScheduler.Gate(storageGVR)
+	}
+	return informer.CombineStartGates(priorityGate, scheduledGate)
+}
+
+// countStoredResources counts the stored resources of the cluster by the storage, the counts fall back to the numbers of
+// the stored resource versions if the storage does not support the ClusterResourceCounter or the count is failed.
+func countStoredResources(factory storage.StorageFactory, cluster string, resourceversions map[schema.GroupVersionResource]map[string]interface{}) map[schema.GroupVersionResource]int64 {
+	if counter, ok := factory.(storage.ClusterResourceCounter); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		counts, err := counter.CountClusterResources(ctx, cluster)
+		if err == nil {
+			return counts
+		}
+		klog.ErrorS(err, "Failed to count the stored resources, schedule the initial sync by the resource versions", "cluster", cluster)
+	}
+
+	counts := make(map[schema.GroupVersionResource]int64, len(resourceversions))
+	for gvr, rvs := range resourceversions {
+		counts[gvr] = int64(len(rvs))
+	}
+	return counts
+}
+
 func (s *ClusterSynchro) runner() {
 	klog.InfoS("cluster synchro runner is running...", "cluster", s.name)
 	defer klog.InfoS("cluster synchro runner is stopped", "cluster", s.name)
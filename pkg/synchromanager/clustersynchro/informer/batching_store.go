@@ -0,0 +1,136 @@
+package informer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultWatchBatchSize   = 100
+	defaultWatchBatchPeriod = 100 * time.Millisecond
+)
+
+// BatchingStore is the store which writes the objects in batches, e.g. the store backed by the storage.
+// The Reflector detects it by the type assertion, and falls back to the per-item methods of cache.Store otherwise.
+type BatchingStore interface {
+	cache.Store
+
+	AddBatch(objs []interface{}) error
+	UpdateBatch(objs []interface{}) error
+
+	// ReplaceBatch is used instead of Replace to replace the items of the store by the list,
+	// the items are the objects or the cache.ExplicitKey of the objects added by the stream handled list.
+	ReplaceBatch(items []interface{}, resourceVersion string) error
+}
+
+// watchBatcher buffers the Added and Modified watch events for the BatchingStore,
+// the resource version of the buffered events is only acknowledged after they are flushed.
+type watchBatcher struct {
+	store  BatchingStore
+	size   int
+	period time.Duration
+
+	hook                       EventHook
+	setLastSyncResourceVersion func(string)
+
+	lock sync.Mutex
+	// events are the buffered events of the same type, the events of the different types are flushed in order.
+	events           []watch.Event
+	resourceVersions []string
+}
+
+// newWatchBatcher returns nil if the store isn't a BatchingStore.
+func (r *Reflector) newWatchBatcher() *watchBatcher {
+	store, ok := r.store.(BatchingStore)
+	if !ok {
+		return nil
+	}
+
+	period := r.WatchBatchPeriod
+	if period <= 0 {
+		period = defaultWatchBatchPeriod
+	}
+	return &watchBatcher{
+		store:                      store,
+		size:                       r.watchBatchSize(),
+		period:                     period,
+		hook:                       r.EventHook,
+		setLastSyncResourceVersion: r.setLastSyncResourceVersion,
+	}
+}
+
+// watchBatchSize is the max number of the objects written by a batch, it is also used by the stream handled list.
+func (r *Reflector) watchBatchSize() int {
+	if r.WatchBatchSize <= 0 {
+		return defaultWatchBatchSize
+	}
+	return r.WatchBatchSize
+}
+
+// add buffers the event, the buffered events are flushed if the buffer is full or the type of the event is changed.
+func (b *watchBatcher) add(event watch.Event, resourceVersion string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if len(b.events) != 0 && b.events[0].Type != event.Type {
+		b.flushLocked()
+	}
+	b.events = append(b.events, event)
+	b.resourceVersions = append(b.resourceVersions, resourceVersion)
+	if len(b.events) >= b.size {
+		b.flushLocked()
+	}
+}
+
+func (b *watchBatcher) pending() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.events) != 0
+}
+
+// flush writes the buffered events to the store, and then acknowledges the resource version of the last event.
+func (b *watchBatcher) flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.flushLocked()
+}
+
+func (b *watchBatcher) flushLocked() {
+	if len(b.events) == 0 {
+		return
+	}
+
+	objs := make([]interface{}, 0, len(b.events))
+	for _, event := range b.events {
+		objs = append(objs, event.Object)
+	}
+
+	var err error
+	eventType := b.events[0].Type
+	switch eventType {
+	case watch.Added:
+		err = b.store.AddBatch(objs)
+	case watch.Modified:
+		err = b.store.UpdateBatch(objs)
+	default:
+		err = fmt.Errorf("unable to batch the %s watch events", eventType)
+	}
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("unable to write the batch of %d %s watch event objects to store: %v", len(objs), eventType, err))
+	}
+	for i, event := range b.events {
+		notifyEventHook(b.hook, event, b.resourceVersions[i], err)
+	}
+
+	resourceVersion := b.resourceVersions[len(b.resourceVersions)-1]
+	b.setLastSyncResourceVersion(resourceVersion)
+	if rvu, ok := b.store.(ResourceVersionUpdater); ok {
+		rvu.UpdateResourceVersion(resourceVersion)
+	}
+	b.events, b.resourceVersions = nil, nil
+}
@@ -0,0 +1,177 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fakeBatchingStore records the batches and the resource version acknowledged by the reflector at the time.
+type fakeBatchingStore struct {
+	cache.Store
+	reflector *Reflector
+
+	lock    sync.Mutex
+	batches []string
+}
+
+func (s *fakeBatchingStore) record(op string, objs []interface{}) {
+	names := make([]string, 0, len(objs))
+	for _, obj := range objs {
+		if key, ok := obj.(cache.ExplicitKey); ok {
+			names = append(names, string(key))
+			continue
+		}
+		accessor, _ := meta.Accessor(obj)
+		names = append(names, accessor.GetName())
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.batches = append(s.batches, fmt.Sprintf("%s %s acknowledged=%q", op, strings.Join(names, ","), s.reflector.LastSyncResourceVersion()))
+}
+
+func (s *fakeBatchingStore) recorded() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.batches...)
+}
+
+func (s *fakeBatchingStore) AddBatch(objs []interface{}) error {
+	s.record("add", objs)
+	for _, obj := range objs {
+		if err := s.Store.Add(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeBatchingStore) UpdateBatch(objs []interface{}) error {
+	s.record("update", objs)
+	for _, obj := range objs {
+		if err := s.Store.Update(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeBatchingStore) ReplaceBatch(items []interface{}, resourceVersion string) error {
+	s.record("replace", items)
+	return nil
+}
+
+func newBatchingTestReflector(lw cache.ListerWatcher) (*Reflector, *fakeBatchingStore) {
+	store := &fakeBatchingStore{Store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	r := NewReflector(lw, &unstructured.Unstructured{}, store, 0)
+	store.reflector = r
+	return r, store
+}
+
+func newBatchingTestObject(name, rv string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetResourceVersion(rv)
+	return obj
+}
+
+func TestWatchHandlerBatchingStore(t *testing.T) {
+	r, store := newBatchingTestReflector(&ListWatch{})
+	r.WatchBatchSize = 2
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	fw := watch.NewFakeWithChanSize(5, false)
+	fw.Add(newBatchingTestObject("a", "1"))
+	fw.Add(newBatchingTestObject("b", "2"))
+	fw.Add(newBatchingTestObject("c", "3"))
+	fw.Modify(newBatchingTestObject("a", "4"))
+	fw.Delete(newBatchingTestObject("b", "5"))
+	fw.Stop()
+
+	err := watchHandler(fakeClock.Now(), fw, r.store, r.newWatchBatcher(), nil, nil, "test", "test", nil, r.setLastSyncResourceVersion, nil, nil, fakeClock, 0, nil, make(chan error), make(chan struct{}))
+	assert.NoError(t, err)
+
+	// the resource version is acknowledged only after the batch is flushed
+	assert.Equal(t, []string{
+		`add a,b acknowledged=""`,
+		`add c acknowledged="2"`,
+		`update a acknowledged="3"`,
+	}, store.recorded())
+	assert.Equal(t, "5", r.LastSyncResourceVersion())
+	assert.ElementsMatch(t, []string{"default/a", "default/c"}, store.ListKeys())
+}
+
+func TestWatchHandlerBatchingStoreFlushPeriod(t *testing.T) {
+	r, store := newBatchingTestReflector(&ListWatch{})
+	r.WatchBatchPeriod = time.Second
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+
+	fw := watch.NewFake()
+	stopCh := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- watchHandler(fakeClock.Now(), fw, r.store, r.newWatchBatcher(), nil, nil, "test", "test", nil, r.setLastSyncResourceVersion, nil, nil, fakeClock, 0, nil, make(chan error), stopCh)
+	}()
+
+	fw.Add(newBatchingTestObject("a", "1"))
+	assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, store.recorded())
+	assert.Equal(t, "", r.LastSyncResourceVersion())
+
+	fakeClock.Step(time.Second)
+	assert.Eventually(t, func() bool { return len(store.recorded()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "1", r.LastSyncResourceVersion())
+
+	// the buffered events are flushed when the watch is stopped
+	fw.Modify(newBatchingTestObject("a", "2"))
+	close(stopCh)
+	assert.ErrorIs(t, <-errCh, errorStopRequested)
+	assert.Equal(t, []string{`add a acknowledged=""`, `update a acknowledged="1"`}, store.recorded())
+	assert.Equal(t, "2", r.LastSyncResourceVersion())
+}
+
+func TestReflectorListBatchingStore(t *testing.T) {
+	newPage := func(page int, continueToken string) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": "10", "continue": continueToken},
+		}}
+		for i := 0; i < 3; i++ {
+			list.Items = append(list.Items, *newBatchingTestObject(fmt.Sprintf("obj-%d-%d", page, i), "1"))
+		}
+		return list
+	}
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			if options.Continue == "" {
+				return newPage(0, "1"), nil
+			}
+			return newPage(1, ""), nil
+		},
+	}
+	r, store := newBatchingTestReflector(lw)
+	r.WatchListPageSize = 3
+	r.StreamHandleForPaginatedList = true
+	r.WatchBatchSize = 4
+
+	assert.NoError(t, r.list(context.Background()))
+	assert.Equal(t, []string{
+		`add obj-0-0,obj-0-1,obj-0-2,obj-1-0 acknowledged=""`,
+		`add obj-1-1,obj-1-2 acknowledged=""`,
+		`replace default/obj-0-0,default/obj-0-1,default/obj-0-2,default/obj-1-0,default/obj-1-1,default/obj-1-2 acknowledged=""`,
+	}, store.recorded())
+	assert.Equal(t, "10", r.LastSyncResourceVersion())
+}
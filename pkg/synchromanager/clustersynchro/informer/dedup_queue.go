@@ -0,0 +1,106 @@
+package informer
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/lru"
+)
+
+// dedupQueue drops the `Update` calls whose resource version is equal to
+// the last one applied for the same key.
+//
+// Some member clusters deliver repeated Modified events with the same resourceVersion,
+// each one of them would become a pointless storage update.
+//
+// Only the `Update` called by the watch handler is deduplicated,
+// `Resync` and `Replace` are intentionally re-applying the same resource versions,
+// so they are passed through and never be dropped.
+type dedupQueue struct {
+	cache.Queue
+
+	keyFunc  cache.KeyFunc
+	versions *lru.Cache
+	skipped  prometheus.Counter
+}
+
+var _ cache.Queue = &dedupQueue{}
+
+func newDedupQueue(queue cache.Queue, size int, skipped prometheus.Counter) *dedupQueue {
+	return &dedupQueue{
+		Queue:    queue,
+		keyFunc:  cache.DeletionHandlingMetaNamespaceKeyFunc,
+		versions: lru.New(size),
+		skipped:  skipped,
+	}
+}
+
+func (queue *dedupQueue) Add(obj interface{}) error {
+	if err := queue.Queue.Add(obj); err != nil {
+		return err
+	}
+	queue.record(obj)
+	return nil
+}
+
+func (queue *dedupQueue) Update(obj interface{}) error {
+	key, rv, ok := queue.keyAndResourceVersion(obj)
+	if ok {
+		if last, exists := queue.versions.Get(key); exists && last.(string) == rv {
+			if queue.skipped != nil {
+				queue.skipped.Inc()
+			}
+			return nil
+		}
+	}
+
+	if err := queue.Queue.Update(obj); err != nil {
+		return err
+	}
+	if ok {
+		queue.versions.Add(key, rv)
+	}
+	return nil
+}
+
+func (queue *dedupQueue) Delete(obj interface{}) error {
+	if key, err := queue.keyFunc(obj); err == nil {
+		queue.versions.Remove(key)
+	}
+	return queue.Queue.Delete(obj)
+}
+
+func (queue *dedupQueue) Replace(list []interface{}, rv string) error {
+	// There is no need to clear the recorded versions,
+	// a stale version will never be equal to the version of a newer object with the same key.
+	if err := queue.Queue.Replace(list, rv); err != nil {
+		return err
+	}
+	for _, obj := range list {
+		queue.record(obj)
+	}
+	return nil
+}
+
+func (queue *dedupQueue) record(obj interface{}) {
+	if key, rv, ok := queue.keyAndResourceVersion(obj); ok {
+		queue.versions.Add(key, rv)
+	}
+}
+
+func (queue *dedupQueue) keyAndResourceVersion(obj interface{}) (string, string, bool) {
+	// the keys passed by the stream handling of the paginated list do not carry resource versions
+	if _, ok := obj.(cache.ExplicitKey); ok {
+		return "", "", false
+	}
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil || accessor.GetResourceVersion() == "" {
+		return "", "", false
+	}
+	key, err := queue.keyFunc(obj)
+	if err != nil {
+		return "", "", false
+	}
+	return key, accessor.GetResourceVersion(), true
+}
@@ -0,0 +1,83 @@
+package informer
+
+import (
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// EventHook is notified of the outcome of each watch event after it is applied to the store.
+//
+// The hooks are invoked synchronously in the watch loop of the Reflector,
+// implementations should return quickly - any expensive processing should be offloaded.
+type EventHook interface {
+	// OnAdd is called after the Added event is successfully applied to the store.
+	OnAdd(key, resourceVersion string)
+
+	// OnUpdate is called after the Modified event is successfully applied to the store.
+	OnUpdate(key, resourceVersion string)
+
+	// OnDelete is called after the Deleted event is successfully applied to the store.
+	OnDelete(key, resourceVersion string)
+
+	// OnError is called when the event failed to be applied to the store.
+	OnError(eventType watch.EventType, key, resourceVersion string, err error)
+}
+
+type EventHookFuncs struct {
+	AddFunc    func(key, resourceVersion string)
+	UpdateFunc func(key, resourceVersion string)
+	DeleteFunc func(key, resourceVersion string)
+	ErrorFunc  func(eventType watch.EventType, key, resourceVersion string, err error)
+}
+
+var _ EventHook = EventHookFuncs{}
+
+func (h EventHookFuncs) OnAdd(key, resourceVersion string) {
+	if h.AddFunc != nil {
+		h.AddFunc(key, resourceVersion)
+	}
+}
+
+func (h EventHookFuncs) OnUpdate(key, resourceVersion string) {
+	if h.UpdateFunc != nil {
+		h.UpdateFunc(key, resourceVersion)
+	}
+}
+
+func (h EventHookFuncs) OnDelete(key, resourceVersion string) {
+	if h.DeleteFunc != nil {
+		h.DeleteFunc(key, resourceVersion)
+	}
+}
+
+func (h EventHookFuncs) OnError(eventType watch.EventType, key, resourceVersion string, err error) {
+	if h.ErrorFunc != nil {
+		h.ErrorFunc(eventType, key, resourceVersion, err)
+	}
+}
+
+// notifyEventHook notifies the hook of the outcome of the event,
+// it does nothing if the hook is nil.
+func notifyEventHook(hook EventHook, event watch.Event, resourceVersion string, err error) {
+	if hook == nil {
+		return
+	}
+
+	key, keyErr := cache.MetaNamespaceKeyFunc(event.Object)
+	if keyErr != nil && err == nil {
+		err = keyErr
+	}
+	if err != nil {
+		hook.OnError(event.Type, key, resourceVersion, err)
+		return
+	}
+
+	switch event.Type {
+	case watch.Added:
+		hook.OnAdd(key, resourceVersion)
+	case watch.Modified:
+		hook.OnUpdate(key, resourceVersion)
+	case watch.Deleted:
+		hook.OnDelete(key, resourceVersion)
+	}
+}
@@ -0,0 +1,30 @@
+package informer
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// observeForbidden counts the consecutive forbidden errors of the list and watch,
+// e.g. the RBAC of the member cluster is tightened. It returns false if the err isn't a forbidden error.
+func (r *Reflector) observeForbidden(err error) bool {
+	if !apierrors.IsForbidden(err) {
+		return false
+	}
+
+	count := r.consecutiveForbidden.Add(1)
+	klog.Warningf("%s: list and watch of %v is forbidden %d consecutive times, check the RBAC of the cluster: %v", r.name, r.expectedTypeName, count, err)
+	return true
+}
+
+// shouldStopByForbidden returns true if the consecutive forbidden errors reach the MaxConsecutiveForbidden.
+func (r *Reflector) shouldStopByForbidden() bool {
+	return r.MaxConsecutiveForbidden > 0 && r.consecutiveForbidden.Load() >= int64(r.MaxConsecutiveForbidden)
+}
+
+// resetForbidden resets the consecutive forbidden errors after the permissions are granted again.
+func (r *Reflector) resetForbidden() {
+	if count := r.consecutiveForbidden.Swap(0); count != 0 {
+		klog.Infof("%s: list and watch of %v is allowed again after %d consecutive forbidden errors", r.name, r.expectedTypeName, count)
+	}
+}
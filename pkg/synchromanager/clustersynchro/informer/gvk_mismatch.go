@@ -0,0 +1,73 @@
+package informer
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// GVKMismatchPolicy is the handling of the watch events whose gvk is different from the expected gvk,
+// e.g. the storage version of the CRD is changed in the member cluster.
+type GVKMismatchPolicy string
+
+const (
+	// GVKMismatchDrop drops the mismatched events, it is the default policy.
+	GVKMismatchDrop GVKMismatchPolicy = "drop"
+
+	// GVKMismatchAcceptVersion accepts the events of any version of the expected group and kind,
+	// the expected gvk is switched to the version of the accepted event.
+	GVKMismatchAcceptVersion GVKMismatchPolicy = "accept-version"
+
+	// GVKMismatchRelist switches the expected gvk to the version of the event of the expected group and kind,
+	// and stops the watch to relist the resources, so all the resources are replaced by the objects of the new version.
+	GVKMismatchRelist GVKMismatchPolicy = "relist"
+)
+
+func ParseGVKMismatchPolicy(policy string) (GVKMismatchPolicy, error) {
+	switch p := GVKMismatchPolicy(policy); p {
+	case "":
+		return GVKMismatchDrop, nil
+	case GVKMismatchDrop, GVKMismatchAcceptVersion, GVKMismatchRelist:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown gvk mismatch policy: %s", policy)
+}
+
+// gvkMismatchError stops the watch to relist the resources of the new version.
+type gvkMismatchError struct {
+	expected, actual schema.GroupVersionKind
+}
+
+func (e *gvkMismatchError) Error() string {
+	return fmt.Sprintf("expected gvk %v, but watch event object had gvk %v, relist the resources", e.expected, e.actual)
+}
+
+// handleGVKMismatch handles the watch event whose gvk is different from the expected gvk by the GVKMismatchPolicy,
+// it returns true if the event is accepted, or an error to stop the watch and relist the resources.
+// The events of the other group or kind are always dropped.
+func (r *Reflector) handleGVKMismatch(expected, actual schema.GroupVersionKind) (bool, error) {
+	if expected.GroupKind() == actual.GroupKind() {
+		switch r.GVKMismatchPolicy {
+		case GVKMismatchAcceptVersion:
+			klog.InfoS("The version of the watch events is changed, accept the events of the new version",
+				"reflector", r.name, "expected", expected, "actual", actual)
+			r.setExpectedGVK(actual)
+			return true, nil
+		case GVKMismatchRelist:
+			r.setExpectedGVK(actual)
+			return false, &gvkMismatchError{expected: expected, actual: actual}
+		}
+	}
+
+	r.gvkMismatchDroppedEvents.Add(1)
+	if r.GVKMismatchCounter != nil {
+		r.GVKMismatchCounter.Inc()
+	}
+	return false, nil
+}
+
+// setExpectedGVK is only called by the watch loop, which is the only reader of the expectedGVK after the Reflector is created.
+func (r *Reflector) setExpectedGVK(gvk schema.GroupVersionKind) {
+	r.expectedGVK = &gvk
+}
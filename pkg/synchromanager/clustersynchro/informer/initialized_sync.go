@@ -0,0 +1,152 @@
+package informer
+
+import (
+	"context"
+	"sync"
+)
+
+// InitializedSyncs aggregates whether the initial lists of the Reflectors of each cluster are synced,
+// like cache.WaitForCacheSync for all the resources of a cluster. The Reflectors are registered and
+// unregistered dynamically as the synced resources of the cluster are changed.
+type InitializedSyncs struct {
+	lock sync.Mutex
+	// clusters is the registered Reflectors of each cluster by their keys.
+	clusters map[string]map[string]*InitializedSync
+	// changed is closed and renewed when the registrations or their states are changed.
+	changed chan struct{}
+}
+
+func NewInitializedSyncs() *InitializedSyncs {
+	return &InitializedSyncs{
+		clusters: make(map[string]map[string]*InitializedSync),
+		changed:  make(chan struct{}),
+	}
+}
+
+// InitializedSync is the registration of a Reflector, it is uninitialized after it is registered.
+// The registration replaced by the later registration of the same key is stale, its updates are ignored.
+type InitializedSync struct {
+	syncs   *InitializedSyncs
+	cluster string
+	key     string
+	// initialized is guarded by the lock of the syncs.
+	initialized bool
+}
+
+// Register registers the Reflector of the key in the cluster, the previous registration of the key is replaced.
+// It returns nil if the syncs is nil, and the methods of the nil registration are no-ops.
+func (s *InitializedSyncs) Register(cluster, key string) *InitializedSync {
+	if s == nil {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	reflectors, ok := s.clusters[cluster]
+	if !ok {
+		reflectors = make(map[string]*InitializedSync)
+		s.clusters[cluster] = reflectors
+	}
+	registration := &InitializedSync{syncs: s, cluster: cluster, key: key}
+	reflectors[key] = registration
+	s.notify()
+	return registration
+}
+
+// SetInitialized sets whether the initial list of the Reflector is synced,
+// e.g. it is reset to false when the Reflector is restarted and relists the resources.
+func (r *InitializedSync) SetInitialized(initialized bool) {
+	if r == nil {
+		return
+	}
+
+	r.syncs.lock.Lock()
+	defer r.syncs.lock.Unlock()
+
+	if !r.registered() || r.initialized == initialized {
+		return
+	}
+	r.initialized = initialized
+	r.syncs.notify()
+}
+
+// Unregister removes the registration, the later registration of the same key is kept.
+func (r *InitializedSync) Unregister() {
+	if r == nil {
+		return
+	}
+
+	r.syncs.lock.Lock()
+	defer r.syncs.lock.Unlock()
+
+	if !r.registered() {
+		return
+	}
+	reflectors := r.syncs.clusters[r.cluster]
+	if delete(reflectors, r.key); len(reflectors) == 0 {
+		delete(r.syncs.clusters, r.cluster)
+	}
+	r.syncs.notify()
+}
+
+func (r *InitializedSync) registered() bool {
+	return r.syncs.clusters[r.cluster][r.key] == r
+}
+
+// AllInitialized returns whether the initial lists of all the registered Reflectors of the cluster are synced,
+// it is false if there is no registered Reflector of the cluster.
+func (s *InitializedSyncs) AllInitialized(cluster string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.allInitialized(cluster)
+}
+
+func (s *InitializedSyncs) allInitialized(cluster string) bool {
+	reflectors := s.clusters[cluster]
+	if len(reflectors) == 0 {
+		return false
+	}
+	for _, registration := range reflectors {
+		if !registration.initialized {
+			return false
+		}
+	}
+	return true
+}
+
+// WaitForInitialized blocks until the initial lists of all the registered Reflectors of the cluster are synced,
+// it returns the error of the ctx if the ctx is done first.
+func (s *InitializedSyncs) WaitForInitialized(ctx context.Context, cluster string) error {
+	for {
+		s.lock.Lock()
+		initialized, changed := s.allInitialized(cluster), s.changed
+		s.lock.Unlock()
+		if initialized {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Changed returns the channel closed when the registrations or their states of any cluster are changed,
+// Changed should be called again for the next change after the channel is closed.
+func (s *InitializedSyncs) Changed() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.changed
+}
+
+func (s *InitializedSyncs) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -0,0 +1,105 @@
+package informer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializedSyncs(t *testing.T) {
+	syncs := NewInitializedSyncs()
+	assert.False(t, syncs.AllInitialized("cluster-1"), "no registered reflectors")
+
+	pods := syncs.Register("cluster-1", "pods")
+	deploys := syncs.Register("cluster-1", "deployments")
+	other := syncs.Register("cluster-2", "pods")
+	other.SetInitialized(true)
+
+	changed := syncs.Changed()
+	pods.SetInitialized(true)
+	assert.False(t, syncs.AllInitialized("cluster-1"))
+	assert.True(t, syncs.AllInitialized("cluster-2"))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("the change is not notified")
+	}
+
+	// the unchanged state isn't notified
+	changed = syncs.Changed()
+	pods.SetInitialized(true)
+	select {
+	case <-changed:
+		t.Fatal("the unchanged state is notified")
+	default:
+	}
+
+	deploys.SetInitialized(true)
+	assert.True(t, syncs.AllInitialized("cluster-1"))
+
+	// the added resource is uninitialized until its initial list is synced
+	services := syncs.Register("cluster-1", "services")
+	assert.False(t, syncs.AllInitialized("cluster-1"))
+	services.Unregister()
+	assert.True(t, syncs.AllInitialized("cluster-1"))
+
+	// the stale registration of the restarted reflector is ignored
+	restarted := syncs.Register("cluster-1", "pods")
+	pods.SetInitialized(true)
+	pods.Unregister()
+	assert.False(t, syncs.AllInitialized("cluster-1"))
+	restarted.SetInitialized(true)
+	assert.True(t, syncs.AllInitialized("cluster-1"))
+
+	restarted.Unregister()
+	deploys.Unregister()
+	assert.False(t, syncs.AllInitialized("cluster-1"))
+	assert.True(t, syncs.AllInitialized("cluster-2"))
+
+	var nilSyncs *InitializedSyncs
+	nilSyncs.Register("cluster-1", "pods").SetInitialized(true)
+	assert.False(t, nilSyncs.AllInitialized("cluster-1"))
+}
+
+func TestInitializedSyncsWaitForInitialized(t *testing.T) {
+	syncs := NewInitializedSyncs()
+	var registrations []*InitializedSync
+	for i := 0; i < 10; i++ {
+		registrations = append(registrations, syncs.Register("cluster-1", fmt.Sprintf("resource-%d", i)))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, syncs.WaitForInitialized(ctx, "cluster-1"), context.DeadlineExceeded)
+
+	waited := make(chan error)
+	go func() {
+		waited <- syncs.WaitForInitialized(context.Background(), "cluster-1")
+	}()
+
+	// the registrations are updated concurrently
+	var wg sync.WaitGroup
+	for i, registration := range registrations {
+		wg.Add(1)
+		go func(i int, registration *InitializedSync) {
+			defer wg.Done()
+			if i%2 == 0 {
+				registration.Unregister()
+				return
+			}
+			registration.SetInitialized(true)
+		}(i, registration)
+	}
+	wg.Wait()
+
+	select {
+	case err := <-waited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForInitialized is not returned after all the reflectors are initialized")
+	}
+}
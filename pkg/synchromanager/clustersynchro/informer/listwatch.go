@@ -16,6 +16,39 @@ import (
 
 type TweakListOptionsFunc func(*metav1.ListOptions)
 
+// ListerWatcherWithContext is a cache.ListerWatcher whose list and watch requests
+// can be cancelled by the context, Reflector prefers these methods if they are implemented.
+type ListerWatcherWithContext interface {
+	cache.ListerWatcher
+
+	ListWithContext(ctx context.Context, options metav1.ListOptions) (runtime.Object, error)
+	WatchWithContext(ctx context.Context, options metav1.ListOptions) (watch.Interface, error)
+}
+
+// ListWatch implements ListerWatcherWithContext with the context-aware functions.
+type ListWatch struct {
+	ListFunc  func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error)
+	WatchFunc func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error)
+}
+
+var _ ListerWatcherWithContext = &ListWatch{}
+
+func (lw *ListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	return lw.ListWithContext(context.TODO(), options)
+}
+
+func (lw *ListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	return lw.WatchWithContext(context.TODO(), options)
+}
+
+func (lw *ListWatch) ListWithContext(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+	return lw.ListFunc(ctx, options)
+}
+
+func (lw *ListWatch) WatchWithContext(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+	return lw.WatchFunc(ctx, options)
+}
+
 type DynamicListerWatcherFactory interface {
 	ForResource(namespace string, gvr schema.GroupVersionResource) cache.ListerWatcher
 	ForResourceWithOptions(namespace string, gvr schema.GroupVersionResource, optionsFunc TweakListOptionsFunc) cache.ListerWatcher
@@ -43,34 +76,34 @@ type listerWatcherFactory struct {
 
 func (f *listerWatcherFactory) ForResource(namespace string, gvr schema.GroupVersionResource) cache.ListerWatcher {
 	client := dynamic.NewForConfigOrDie(f.config)
-	return &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			return client.Resource(gvr).Namespace(namespace).List(context.TODO(), options)
+	return &ListWatch{
+		ListFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			return client.Resource(gvr).Namespace(namespace).List(ctx, options)
 		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+		WatchFunc: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
 			// the minWatchTimeout for reflector is [5m, 10m],
 			// set to [f.minWatchTimeout, 2 * f.minWatchTimeout].
 			timeoutSeconds := int64(f.minWatchTimeout.Seconds() * (rand.Float64() + 1.0))
 			options.TimeoutSeconds = &timeoutSeconds
-			return client.Resource(gvr).Namespace(namespace).Watch(context.TODO(), options)
+			return client.Resource(gvr).Namespace(namespace).Watch(ctx, options)
 		},
 	}
 }
 
 func (f *listerWatcherFactory) ForResourceWithOptions(namespace string, gvr schema.GroupVersionResource, tweakListOptions TweakListOptionsFunc) cache.ListerWatcher {
 	client := dynamic.NewForConfigOrDie(f.config)
-	return &cache.ListWatch{
-		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+	return &ListWatch{
+		ListFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
 			if tweakListOptions != nil {
 				tweakListOptions(&options)
 			}
-			return client.Resource(gvr).Namespace(namespace).List(context.TODO(), options)
+			return client.Resource(gvr).Namespace(namespace).List(ctx, options)
 		},
-		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+		WatchFunc: func(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
 			if tweakListOptions != nil {
 				tweakListOptions(&options)
 			}
-			return client.Resource(gvr).Namespace(namespace).Watch(context.TODO(), options)
+			return client.Resource(gvr).Namespace(namespace).Watch(ctx, options)
 		},
 	}
 }
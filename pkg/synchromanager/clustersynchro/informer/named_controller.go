@@ -1,9 +1,11 @@
 package informer
 
 import (
+	"context"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -50,14 +52,63 @@ type Config struct {
 	// WatchListPageSize is the requested chunk size of initial and relist watch lists.
 	WatchListPageSize int64
 
+	// AdaptivePageSize adapts the page size of the paginated list within
+	// [MinWatchListPageSize, MaxWatchListPageSize] by the encoded size of the objects.
+	AdaptivePageSize     bool
+	MinWatchListPageSize int64
+	MaxWatchListPageSize int64
+
 	// StreamHandle of paginated list, resources within a pager will be processed
 	// as soon as possible instead of waiting until all resources are pulled before calling the ResourceHandler.
 	StreamHandleForPaginatedList bool
+	// StreamBufferSize is the buffer size of the result stream for StreamHandleForPaginatedList.
+	StreamBufferSize int
+	// PagerReadAhead fetches the next page while the previous page is handled by the result stream.
+	PagerReadAhead bool
 
 	// Force paging, Reflector will sometimes use APIServer's cache,
 	// even if paging is specified APIServer will return all resources for performance,
 	// then it will skip Reflector's streaming memory optimization.
 	ForcePaginatedList bool
+
+	// ForceRelistOnly skips the watch, the resources are only relisted at the FullResyncPeriod,
+	// or at the default relist period if FullResyncPeriod is unset.
+	ForceRelistOnly bool
+
+	// EventHook is notified of the outcome of each watch event applied to the Queue.
+	EventHook EventHook
+
+	// InitialResourceVersion is the resource version restored from the storage,
+	// the first watch starts from it instead of the initial list.
+	InitialResourceVersion string
+
+	// Checkpoint persists the resource version of the bookmark events at most once per CheckpointInterval.
+	Checkpoint         func(resourceVersion string)
+	CheckpointInterval time.Duration
+
+	// ListProgressHandler is notified of the progress of the paginated list with StreamHandleForPaginatedList.
+	ListProgressHandler func(progress ListProgress)
+
+	// InitializedHandler is called after the initial list of the Reflector is synced.
+	InitializedHandler func()
+
+	// StartGate gates the start of the Reflector.
+	StartGate StartGate
+
+	// WatchBatchSize and WatchBatchPeriod bound the watch events buffered if the Queue is a BatchingStore.
+	WatchBatchSize   int
+	WatchBatchPeriod time.Duration
+
+	// GVKMismatchPolicy is the handling of the watch events whose gvk is different from the expected gvk.
+	GVKMismatchPolicy  GVKMismatchPolicy
+	GVKMismatchCounter prometheus.Counter
+
+	// MaxConsecutiveForbidden stops the controller after the number of consecutive forbidden errors.
+	MaxConsecutiveForbidden int
+
+	// ShortWatchThreshold and WatchDurationObserver are passed to the Reflector.
+	ShortWatchThreshold   time.Duration
+	WatchDurationObserver prometheus.Observer
 }
 
 type controller struct {
@@ -78,8 +129,12 @@ func NewNamedController(name string, config *Config) cache.Controller {
 
 func (c *controller) Run(stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
+
+	// the controller is stopped if the reflector stops by itself, e.g. by the consecutive forbidden errors
+	ctx, cancel := context.WithCancel(wait.ContextForChannel(stopCh))
+	defer cancel()
 	go func() {
-		<-stopCh
+		<-ctx.Done()
 		c.config.Queue.Close()
 	}()
 
@@ -95,17 +150,40 @@ func (c *controller) Run(stopCh <-chan struct{}) {
 	}
 	r.ShouldResync = c.config.ShouldResync
 	r.WatchListPageSize = c.config.WatchListPageSize
+	r.AdaptivePageSize = c.config.AdaptivePageSize
+	r.MinWatchListPageSize = c.config.MinWatchListPageSize
+	r.MaxWatchListPageSize = c.config.MaxWatchListPageSize
 	r.ForcePaginatedList = c.config.ForcePaginatedList
 	r.StreamHandleForPaginatedList = c.config.StreamHandleForPaginatedList
+	r.StreamBufferSize = c.config.StreamBufferSize
+	r.PagerReadAhead = c.config.PagerReadAhead
+	r.ForceRelistOnly = c.config.ForceRelistOnly
+	r.EventHook = c.config.EventHook
+	r.InitialResourceVersion = c.config.InitialResourceVersion
+	r.Checkpoint = c.config.Checkpoint
+	r.CheckpointInterval = c.config.CheckpointInterval
+	r.ListProgressHandler = c.config.ListProgressHandler
+	r.InitializedHandler = c.config.InitializedHandler
+	r.StartGate = c.config.StartGate
+	r.WatchBatchSize = c.config.WatchBatchSize
+	r.WatchBatchPeriod = c.config.WatchBatchPeriod
+	r.GVKMismatchPolicy = c.config.GVKMismatchPolicy
+	r.GVKMismatchCounter = c.config.GVKMismatchCounter
+	r.MaxConsecutiveForbidden = c.config.MaxConsecutiveForbidden
+	r.ShortWatchThreshold = c.config.ShortWatchThreshold
+	r.WatchDurationObserver = c.config.WatchDurationObserver
 
 	c.reflectorMutex.Lock()
 	c.reflector = r
 	c.reflectorMutex.Unlock()
 
 	var wg wait.Group
-	wg.StartWithChannel(stopCh, r.Run)
+	wg.StartWithContext(ctx, func(ctx context.Context) {
+		defer cancel()
+		r.RunWithContext(ctx)
+	})
 
-	wait.Until(c.processLoop, time.Second, stopCh)
+	wait.Until(c.processLoop, time.Second, ctx.Done())
 	wg.Wait()
 }
 
@@ -18,19 +18,53 @@ package pager
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const defaultPageSize = 500
 const defaultPageBufferSize = 10
 
+// defaultPageRetryBackoff retries a page at most 5 times in about 15 seconds.
+var defaultPageRetryBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2.0,
+	Jitter:   0.1,
+	Steps:    5,
+	Cap:      10 * time.Second,
+}
+
+// ExpiredContinueError is returned when the continue token of the paginated list is expired,
+// and the list is not able to fall back to a full list.
+// The caller should restart the list.
+type ExpiredContinueError struct {
+	Err error
+}
+
+func (e *ExpiredContinueError) Error() string {
+	return fmt.Sprintf("continue token of the paginated list is expired: %v", e.Err)
+}
+
+func (e *ExpiredContinueError) Unwrap() error {
+	return e.Err
+}
+
+// IsExpiredContinueError returns true if the error is an ExpiredContinueError.
+func IsExpiredContinueError(err error) bool {
+	var expired *ExpiredContinueError
+	return errors.As(err, &expired)
+}
+
 // ListPageFunc returns a list object for the given list options.
 type ListPageFunc func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error)
 
@@ -41,6 +75,9 @@ func SimplePageFunc(fn func(opts metav1.ListOptions) (runtime.Object, error)) Li
 	}
 }
 
+// NextPageSizeFunc returns the page size of the next page by the current page and its requested limit.
+type NextPageSizeFunc func(page runtime.Object, limit int64) int64
+
 // ListPager assists client code in breaking large list queries into multiple
 // smaller chunks of PageSize or smaller. PageFn is expected to accept a
 // metav1.ListOptions that supports paging and return a list. The pager does
@@ -53,6 +90,22 @@ type ListPager struct {
 
 	// Number of pages to buffer
 	PageBufferSize int32
+
+	// NextPageSize adjusts the page size of the subsequent pages in List, it can be nil.
+	NextPageSize NextPageSizeFunc
+
+	// PageRetryBackoff is the backoff of retrying a page on the TooManyRequests and transient network errors,
+	// a page is retried at most PageRetryBackoff.Steps times, zero Steps disables the retry.
+	// The delay suggested by the server is respected.
+	PageRetryBackoff wait.Backoff
+
+	// ReadAhead fetches the next page concurrently while the previous page is consumed
+	// by the result stream in List, it is bounded to one page of read-ahead.
+	ReadAhead bool
+
+	// PageListed is called after the items of each page of the paginated list are listed in List,
+	// with the number of the items and the remaining item count of the page, it can be nil.
+	PageListed func(items int, remainingItemCount *int64)
 }
 
 // New creates a new pager from the provided pager function using the default
@@ -64,6 +117,7 @@ func New(fn ListPageFunc) *ListPager {
 		PageFn:            fn,
 		FullListIfExpired: true,
 		PageBufferSize:    defaultPageBufferSize,
+		PageRetryBackoff:  defaultPageRetryBackoff,
 	}
 }
 
@@ -105,6 +159,8 @@ func (p *ListPager) list(ctx context.Context, options metav1.ListOptions, allocN
 	var list *metainternalversion.List
 	paginatedResult := false
 
+	// readAhead is the page being fetched while the previous page is consumed
+	var readAhead chan pageResult
 	for {
 		select {
 		case <-ctx.Done():
@@ -112,22 +168,35 @@ func (p *ListPager) list(ctx context.Context, options metav1.ListOptions, allocN
 		default:
 		}
 
-		obj, err := p.PageFn(ctx, options)
+		var obj runtime.Object
+		var err error
+		if readAhead != nil {
+			result := <-readAhead
+			obj, err, readAhead = result.obj, result.err, nil
+		} else {
+			obj, err = p.fetchPage(ctx, options)
+		}
 		if err != nil {
 			// Only fallback to full list if an "Expired" errors is returned, FullListIfExpired is true, and
 			// the "Expired" error occurred in page 2 or later (since full list is intended to prevent a pager.List from
 			// failing when the resource versions is established by the first page request falls out of the compaction
 			// during the subsequent list requests).
-			if !apierrors.IsResourceExpired(err) || !p.FullListIfExpired || options.Continue == "" {
+			if !apierrors.IsResourceExpired(err) || options.Continue == "" {
 				return nil, paginatedResult, err
 			}
+			if !p.FullListIfExpired {
+				return nil, paginatedResult, &ExpiredContinueError{Err: err}
+			}
 			// the list expired while we were processing, fall back to a full list at
 			// the requested ResourceVersion.
 			options.Limit = 0
 			options.Continue = ""
 			options.ResourceVersion = requestedResourceVersion
 			options.ResourceVersionMatch = requestedResourceVersionMatch
-			result, err := p.PageFn(ctx, options)
+			result, err := p.fetchPage(ctx, options)
+			if apierrors.IsResourceExpired(err) {
+				err = &ExpiredContinueError{Err: err}
+			}
 			return result, paginatedResult, err
 		}
 		m, err := meta.ListAccessor(obj)
@@ -149,13 +218,46 @@ func (p *ListPager) list(ctx context.Context, options metav1.ListOptions, allocN
 				list.Items = make([]runtime.Object, 0, options.Limit+1)
 			}
 		}
+
+		continueToken := m.GetContinue()
+		if len(continueToken) != 0 {
+			// set the next loop up
+			options.Continue = continueToken
+			if p.NextPageSize != nil {
+				if limit := p.NextPageSize(obj, options.Limit); limit > 0 {
+					options.Limit = limit
+				}
+			}
+			// Clear the ResourceVersion(Match) on the subsequent List calls to avoid the
+			// `specifying resource version is not allowed when using continue` error.
+			// See https://github.com/kubernetes/kubernetes/issues/85221#issuecomment-553748143.
+			options.ResourceVersion = ""
+			options.ResourceVersionMatch = ""
+
+			// fetch the next page while the items of this page are consumed by the result stream,
+			// only one page is read ahead.
+			if p.ReadAhead && resultStream != nil {
+				readAhead = make(chan pageResult, 1)
+				go func(options metav1.ListOptions) {
+					obj, err := p.fetchPage(ctx, options)
+					readAhead <- pageResult{obj: obj, err: err}
+				}(options)
+			}
+		}
+
 		eachListItemFunc := meta.EachListItem
 		if allocNew {
 			eachListItemFunc = meta.EachListItemWithAlloc
 		}
+		var items int
 		if err := eachListItemFunc(obj, func(obj runtime.Object) error {
+			items++
 			if resultStream != nil {
-				resultStream <- obj
+				select {
+				case resultStream <- obj:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			} else {
 				list.Items = append(list.Items, obj)
 			}
@@ -163,24 +265,58 @@ func (p *ListPager) list(ctx context.Context, options metav1.ListOptions, allocN
 		}); err != nil {
 			return nil, paginatedResult, err
 		}
+		if p.PageListed != nil {
+			p.PageListed(items, m.GetRemainingItemCount())
+		}
 
 		// if we have no more items, return the list
-		if len(m.GetContinue()) == 0 {
+		if len(continueToken) == 0 {
 			return list, paginatedResult, nil
 		}
-
-		// set the next loop up
-		options.Continue = m.GetContinue()
-		// Clear the ResourceVersion(Match) on the subsequent List calls to avoid the
-		// `specifying resource version is not allowed when using continue` error.
-		// See https://github.com/kubernetes/kubernetes/issues/85221#issuecomment-553748143.
-		options.ResourceVersion = ""
-		options.ResourceVersionMatch = ""
 		// At this point, result is already paginated.
 		paginatedResult = true
 	}
 }
 
+type pageResult struct {
+	obj runtime.Object
+	err error
+}
+
+// fetchPage calls the PageFn, and retries it with the PageRetryBackoff on the retriable errors.
+func (p *ListPager) fetchPage(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+	backoff := p.PageRetryBackoff
+	for {
+		obj, err := p.PageFn(ctx, options)
+		if err == nil || !isRetriablePageError(err) || backoff.Steps <= 0 {
+			return obj, err
+		}
+
+		delay := backoff.Step()
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > delay {
+			delay = time.Duration(seconds) * time.Second
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// isRetriablePageError returns true if the page can be retried without restarting the list.
+func isRetriablePageError(err error) bool {
+	return apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsConnectionRefused(err) ||
+		utilnet.IsProbableEOF(err) ||
+		utilnet.IsHTTP2ConnectionLost(err)
+}
+
 // EachListItem fetches runtime.Object items using this ListPager and invokes fn on each item. If
 // fn returns an error, processing stops and that error is returned. If fn does not return an error,
 // any error encountered while retrieving the list from the server is returned. If the context
@@ -282,7 +418,7 @@ func (p *ListPager) eachListChunk(ctx context.Context, options metav1.ListOption
 		default:
 		}
 
-		obj, err := p.PageFn(ctx, options)
+		obj, err := p.fetchPage(ctx, options)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,163 @@
+package pager
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// fakeLister lists `total` objects by pages, each page takes `latency` to return.
+func fakeLister(total int, latency time.Duration) ListPageFunc {
+	return func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+		start := 0
+		if options.Continue != "" {
+			start, _ = strconv.Atoi(options.Continue)
+		}
+		end := min(start+int(options.Limit), total)
+
+		if latency != 0 {
+			select {
+			case <-time.After(latency):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{}}
+		list.SetResourceVersion("1")
+		if end < total {
+			list.SetContinue(strconv.Itoa(end))
+		}
+		list.Items = make([]unstructured.Unstructured, 0, end-start)
+		for i := start; i < end; i++ {
+			obj := unstructured.Unstructured{}
+			obj.SetName(strconv.Itoa(i))
+			list.Items = append(list.Items, obj)
+		}
+		return list, nil
+	}
+}
+
+func listWithResultStream(p *ListPager, handle func(obj runtime.Object)) (int, error) {
+	ch := make(chan runtime.Object, defaultPageBufferSize)
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := p.List(WithResultStream(context.Background(), ch), metav1.ListOptions{})
+		errCh <- err
+	}()
+
+	count := 0
+	for obj := range ch {
+		handle(obj)
+		count++
+	}
+	return count, <-errCh
+}
+
+func TestListWithReadAhead(t *testing.T) {
+	for _, readAhead := range []bool{false, true} {
+		p := New(fakeLister(1234, 0))
+		p.PageSize = 100
+		p.ReadAhead = readAhead
+
+		var names []string
+		count, err := listWithResultStream(p, func(obj runtime.Object) {
+			names = append(names, obj.(*unstructured.Unstructured).GetName())
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1234, count)
+		for i, name := range names {
+			assert.Equal(t, strconv.Itoa(i), name)
+		}
+	}
+}
+
+func benchmarkListWithResultStream(b *testing.B, readAhead bool) {
+	for i := 0; i < b.N; i++ {
+		p := New(fakeLister(50000, 2*time.Millisecond))
+		p.ReadAhead = readAhead
+
+		handled := 0
+		if _, err := listWithResultStream(p, func(_ runtime.Object) {
+			// simulate the slow writes of the storage
+			handled++
+			if handled%100 == 0 {
+				time.Sleep(200 * time.Microsecond)
+			}
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListWithResultStream(b *testing.B) {
+	benchmarkListWithResultStream(b, false)
+}
+
+func BenchmarkListWithResultStreamReadAhead(b *testing.B) {
+	benchmarkListWithResultStream(b, true)
+}
+
+func TestListRetryPage(t *testing.T) {
+	tooManyRequests := apierrors.NewTooManyRequests("throttled", 0)
+	tests := []struct {
+		name          string
+		failures      int
+		expectedCalls int
+		expectedErr   bool
+	}{
+		{name: "retry and succeed", failures: 2, expectedCalls: 4 + 2},
+		{name: "retry budget is exhausted", failures: 100, expectedCalls: 2 + 1 + 3, expectedErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := fakeLister(100, 0)
+			calls, failures := 0, 0
+			p := New(func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+				calls++
+				// the second page is throttled
+				if options.Continue == "50" && failures < tt.failures {
+					failures++
+					return nil, tooManyRequests
+				}
+				return lister(ctx, options)
+			})
+			p.PageSize = 25
+			p.PageRetryBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 1, Steps: 3}
+
+			list, _, err := p.List(context.Background(), metav1.ListOptions{})
+			assert.Equal(t, tt.expectedCalls, calls)
+			if tt.expectedErr {
+				assert.True(t, apierrors.IsTooManyRequests(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, 100, meta.LenList(list))
+		})
+	}
+}
+
+func TestListExpiredContinue(t *testing.T) {
+	lister := fakeLister(100, 0)
+	p := New(func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+		if options.Continue != "" {
+			return nil, apierrors.NewResourceExpired("expired")
+		}
+		return lister(ctx, options)
+	})
+	p.PageSize = 25
+	p.FullListIfExpired = false
+
+	_, _, err := p.List(context.Background(), metav1.ListOptions{})
+	assert.True(t, IsExpiredContinueError(err))
+	assert.True(t, apierrors.IsResourceExpired(err))
+}
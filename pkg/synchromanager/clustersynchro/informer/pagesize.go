@@ -0,0 +1,63 @@
+package informer
+
+import (
+	"encoding/json"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultTargetPageBytes is close to the encoded size of a default page(500 objects) of the common resources.
+	defaultTargetPageBytes = 1 << 20
+
+	defaultMinWatchListPageSize = 10
+	defaultMaxWatchListPageSize = 10000
+)
+
+// adaptPageSize is a clspager.NextPageSizeFunc, it learns the page size from the average encoded size
+// of the objects in the first page, and the learned page size is used for the subsequent pages and relists.
+func (r *Reflector) adaptPageSize(page runtime.Object, limit int64) int64 {
+	if learned := r.learnedPageSize.Load(); learned != 0 {
+		return learned
+	}
+
+	count := meta.LenList(page)
+	if count == 0 {
+		return limit
+	}
+	data, err := json.Marshal(page)
+	if err != nil {
+		klog.V(4).Infof("%s: failed to measure the page size of %v: %v", r.name, r.expectedTypeName, err)
+		return limit
+	}
+
+	pageSize := r.boundPageSize(r.targetPageBytes() / max(int64(len(data)/count), 1))
+	r.learnedPageSize.Store(pageSize)
+	r.effectivePageSize.Store(pageSize)
+	klog.V(4).Infof("%s: adapt the page size of %v from %d to %d, average object size is %d bytes",
+		r.name, r.expectedTypeName, limit, pageSize, len(data)/count)
+	return pageSize
+}
+
+func (r *Reflector) targetPageBytes() int64 {
+	if r.TargetPageBytes > 0 {
+		return r.TargetPageBytes
+	}
+	return defaultTargetPageBytes
+}
+
+func (r *Reflector) boundPageSize(pageSize int64) int64 {
+	minSize, maxSize := r.MinWatchListPageSize, r.MaxWatchListPageSize
+	if minSize <= 0 {
+		minSize = defaultMinWatchListPageSize
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxWatchListPageSize
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	return min(max(pageSize, minSize), maxSize)
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net/http"
 	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -32,6 +34,8 @@ import (
 
 const defaultExpectedTypeName = "<unspecified>"
 
+const defaultStreamBufferSize = 10
+
 // Reflector watches a specified resource and causes all changes to be reflected in the given store.
 type Reflector struct {
 	// name identifies this reflector. By default it will be a file:line if possible.
@@ -58,6 +62,8 @@ type Reflector struct {
 	backoffManager wait.BackoffManager
 	// initConnBackoffManager manages backoff the initial connection with the Watch call of ListAndWatch.
 	initConnBackoffManager wait.BackoffManager
+	// forbiddenBackoffManager manages the backoff of ListAndWatch failed by the forbidden errors.
+	forbiddenBackoffManager wait.BackoffManager
 	// MaxInternalErrorRetryDuration defines how long we should retry internal errors returned by watch.
 	MaxInternalErrorRetryDuration time.Duration
 
@@ -86,12 +92,33 @@ type Reflector struct {
 	// etcd, which is significantly less efficient and may lead to serious performance and
 	// scalability problems.
 	WatchListPageSize int64
+
+	// AdaptivePageSize adapts the page size of the paginated list by the average encoded size
+	// of the objects in the first page, the adapted page size is bounded to
+	// [MinWatchListPageSize, MaxWatchListPageSize] and reused by the subsequent relists.
+	AdaptivePageSize     bool
+	MinWatchListPageSize int64
+	MaxWatchListPageSize int64
+	// TargetPageBytes is the expected encoded size of a page for AdaptivePageSize,
+	// If unset, it will default to defaultTargetPageBytes.
+	TargetPageBytes int64
+	// learnedPageSize is the page size adapted by AdaptivePageSize, 0 means it has not been learned.
+	learnedPageSize atomic.Int64
+	// effectivePageSize is the page size of the current or last list.
+	effectivePageSize atomic.Int64
+
 	// Called whenever the ListAndWatch drops the connection with an error.
 	watchErrorHandler WatchErrorHandler
 
 	// StreamHandle of paginated list, resources within a pager will be processed
 	// as soon as possible instead of waiting until all resources are pulled before calling the ResourceHandler.
 	StreamHandleForPaginatedList bool
+	// StreamBufferSize is the buffer size of the result stream for StreamHandleForPaginatedList,
+	// If unset, it will default to defaultStreamBufferSize.
+	StreamBufferSize int
+	// PagerReadAhead fetches the next page while the previous page is handled by the result stream,
+	// it only works with StreamHandleForPaginatedList.
+	PagerReadAhead bool
 
 	// Force paging, Reflector will sometimes use APIServer's cache,
 	// even if paging is specified APIServer will return all resources for performance,
@@ -100,6 +127,78 @@ type Reflector struct {
 
 	// Whether the initialization of the List and the replacing of the store has been completed.
 	hasInitializedSynced atomic.Bool
+
+	// ForceRelistOnly skips the watch, Reflector only relists the resources periodically.
+	ForceRelistOnly bool
+	// relistOnly is true if ForceRelistOnly is set or the watch is unsupported by the resource,
+	// Reflector relists the resources at the resync period instead of watching them.
+	relistOnly atomic.Bool
+
+	// EventHook is notified of the outcome of each watch event applied to the store, it can be nil.
+	EventHook EventHook
+
+	// InitialResourceVersion is the last known resource version restored from the storage,
+	// if set, the first ListAndWatch skips the initial list and starts the watch from it,
+	// and the resources are listed only if the watch returns the "expired" error.
+	InitialResourceVersion string
+	// initialListSkipped is true if the initial list has been skipped by InitialResourceVersion.
+	initialListSkipped bool
+
+	// Checkpoint persists the resource version of the bookmark events as the watch progress, it can be nil.
+	// It is called at most once per CheckpointInterval, and only if the store has no pending items,
+	// that is, all the events before the bookmark have been popped by the consumer of the store.
+	Checkpoint func(resourceVersion string)
+	// CheckpointInterval is the minimum interval between two checkpoints,
+	// If unset, it will default to defaultCheckpointInterval.
+	CheckpointInterval time.Duration
+	// lastCheckpointTime is only accessed by the watch loop.
+	lastCheckpointTime time.Time
+
+	// GVKMismatchPolicy is the handling of the watch events whose gvk is different from the expected gvk,
+	// If unset, the mismatched events are dropped.
+	GVKMismatchPolicy GVKMismatchPolicy
+	// GVKMismatchCounter counts the watch events dropped because of the mismatched gvk, it can be nil.
+	GVKMismatchCounter prometheus.Counter
+	// gvkMismatchDroppedEvents is the number of the watch events dropped because of the mismatched gvk.
+	gvkMismatchDroppedEvents atomic.Int64
+
+	// WatchBatchSize and WatchBatchPeriod bound the watch events buffered for the BatchingStore,
+	// the buffered events are flushed if there are WatchBatchSize events or they are buffered for WatchBatchPeriod.
+	// If unset, they will default to defaultWatchBatchSize and defaultWatchBatchPeriod.
+	WatchBatchSize   int
+	WatchBatchPeriod time.Duration
+
+	// MaxConsecutiveForbidden stops Run after the number of consecutive forbidden errors of the list and watch,
+	// 0 means Run keeps retrying with the forbidden backoff until the permissions are granted.
+	MaxConsecutiveForbidden int
+	// consecutiveForbidden is the number of the consecutive forbidden errors, it is reset after the watch is started.
+	consecutiveForbidden atomic.Int64
+	// stoppedByForbidden is true if Run is stopped by MaxConsecutiveForbidden.
+	stoppedByForbidden atomic.Bool
+
+	// StartGate gates the start of Run, e.g. to sync the resources of the higher priorities first, it can be nil.
+	// The gate is done after the initial list is synced or Run exits.
+	StartGate StartGate
+
+	// ListProgressHandler is notified of the progress of the paginated list with StreamHandleForPaginatedList
+	// after each page is handled, and of the cleared progress after the list is finished, it can be nil.
+	// It is called synchronously by the list, so it should return quickly.
+	ListProgressHandler func(progress ListProgress)
+
+	// InitializedHandler is called after the initial list is synced, it can be nil.
+	// It is called synchronously before the watch is started, so it should return quickly.
+	InitializedHandler func()
+
+	// ShortWatchThreshold is the duration under which the watch closed without any events is treated as an error,
+	// it defaults to one second. The duration is measured by the clock of the reflector instead of the member cluster.
+	ShortWatchThreshold time.Duration
+	// WatchDurationObserver observes the durations of the closed watches, it can be nil.
+	WatchDurationObserver prometheus.Observer
+
+	// listProgressID identifies the current list, the progress reported by the previous lists is ignored.
+	listProgressID   uint64
+	listProgress     ListProgress
+	listProgressLock sync.Mutex
 }
 
 // ResourceVersionUpdater is an interface that allows store implementation to
@@ -144,6 +243,9 @@ var (
 	// We try to spread the load on apiserver by setting timeouts for
 	// watch requests - it is random in [minWatchTimeout, 2*minWatchTimeout].
 	minWatchTimeout = 5 * time.Minute
+
+	// defaultShortWatchThreshold is the duration under which the watch closed without any events is unexpected.
+	defaultShortWatchThreshold = time.Second
 )
 
 // NewNamespaceKeyedIndexerAndReflector creates an Indexer and a Reflector
@@ -170,19 +272,66 @@ func NewReflector(lw cache.ListerWatcher, expectedType interface{}, store cache.
 
 // NewNamedReflector same as NewReflector, but with a specified name for logging
 func NewNamedReflector(name string, lw cache.ListerWatcher, expectedType interface{}, store cache.Store, resyncPeriod time.Duration) *Reflector {
-	realClock := &clock.RealClock{}
+	return NewReflectorWithOptions(lw, expectedType, store, ReflectorOptions{Name: name, ResyncPeriod: resyncPeriod})
+}
+
+// ReflectorOptions configures a Reflector.
+type ReflectorOptions struct {
+	// Name is the Reflector's name. If unset/unspecified, the name defaults to the closest source_file.go:line
+	// in the call stack that is outside this package.
+	Name string
+
+	// ResyncPeriod is the Reflector's resync period. If unset/unspecified, the resync period defaults to 0
+	// (do not resync).
+	ResyncPeriod time.Duration
+
+	// Clock allows tests to control time. If unset defaults to clock.RealClock{},
+	// it is used by the resync, the watch duration, the retry of the internal errors and the default backoff managers.
+	Clock clock.Clock
+
+	// BackoffManager manages the backoff of the restarted ListAndWatch,
+	// InitConnBackoffManager manages the backoff of the watch requests failed by the connection refused and 429 errors.
+	// ForbiddenBackoffManager manages the backoff of ListAndWatch failed by the forbidden errors.
+	// If unset, they default to the exponential backoff managers of the Clock.
+	BackoffManager          wait.BackoffManager
+	InitConnBackoffManager  wait.BackoffManager
+	ForbiddenBackoffManager wait.BackoffManager
+}
+
+// NewReflectorWithOptions creates a new Reflector object which will keep the given store up to date with the server's contents
+// for the given resource, the backoff managers and the clock can be injected by the options.
+func NewReflectorWithOptions(lw cache.ListerWatcher, expectedType interface{}, store cache.Store, options ReflectorOptions) *Reflector {
+	reflectorClock := options.Clock
+	if reflectorClock == nil {
+		reflectorClock = &clock.RealClock{}
+	}
 	r := &Reflector{
-		name:          name,
-		listerWatcher: lw,
-		store:         store,
-		// We used to make the call every 1sec (1 QPS), the goal here is to achieve ~98% traffic reduction when
-		// API server is not healthy. With these parameters, backoff will stop at [30,60) sec interval which is
-		// 0.22 QPS. If we don't backoff for 2min, assume API server is healthy and we reset the backoff.
-		backoffManager:         wait.NewExponentialBackoffManager(800*time.Millisecond, 30*time.Second, 2*time.Minute, 2.0, 1.0, realClock),
-		initConnBackoffManager: wait.NewExponentialBackoffManager(800*time.Millisecond, 30*time.Second, 2*time.Minute, 2.0, 1.0, realClock),
-		resyncPeriod:           resyncPeriod,
-		clock:                  realClock,
-		watchErrorHandler:      WatchErrorHandler(DefaultWatchErrorHandler),
+		name:                    options.Name,
+		listerWatcher:           lw,
+		store:                   store,
+		backoffManager:          options.BackoffManager,
+		initConnBackoffManager:  options.InitConnBackoffManager,
+		forbiddenBackoffManager: options.ForbiddenBackoffManager,
+		resyncPeriod:            options.ResyncPeriod,
+		clock:                   reflectorClock,
+		watchErrorHandler:       WatchErrorHandler(DefaultWatchErrorHandler),
+	}
+	if r.name == "" {
+		r.name = naming.GetNameFromCallsite(internalPackages...)
+	}
+	// We used to make the call every 1sec (1 QPS), the goal here is to achieve ~98% traffic reduction when
+	// API server is not healthy. With these parameters, backoff will stop at [30,60) sec interval which is
+	// 0.22 QPS. If we don't backoff for 2min, assume API server is healthy and we reset the backoff.
+	if r.backoffManager == nil {
+		r.backoffManager = wait.NewExponentialBackoffManager(800*time.Millisecond, 30*time.Second, 2*time.Minute, 2.0, 1.0, reflectorClock)
+	}
+	if r.initConnBackoffManager == nil {
+		r.initConnBackoffManager = wait.NewExponentialBackoffManager(800*time.Millisecond, 30*time.Second, 2*time.Minute, 2.0, 1.0, reflectorClock)
+	}
+	// The forbidden errors are not resolved until the RBAC of the cluster is changed,
+	// so back off in minutes instead of seconds.
+	if r.forbiddenBackoffManager == nil {
+		r.forbiddenBackoffManager = wait.NewExponentialBackoffManager(time.Minute, 10*time.Minute, 30*time.Minute, 2.0, 1.0, reflectorClock)
 	}
 	r.setExpectedType(expectedType)
 	return r
@@ -217,16 +366,54 @@ var internalPackages = []string{"client-go/tools/cache/"}
 // objects and subsequent deltas.
 // Run will exit when stopCh is closed.
 func (r *Reflector) Run(stopCh <-chan struct{}) {
+	r.RunWithContext(wait.ContextForChannel(stopCh))
+}
+
+// RunWithContext is the same as Run, but the in-flight list and watch requests
+// are cancelled as soon as the ctx is done.
+// RunWithContext will exit when ctx is done.
+func (r *Reflector) RunWithContext(ctx context.Context) {
+	if r.StartGate != nil {
+		defer r.StartGate.Done()
+		if err := r.StartGate.Wait(ctx); err != nil {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	klog.V(3).Infof("Starting reflector %s (%s) from %s", r.expectedTypeName, r.resyncPeriod, r.name)
 	wait.BackoffUntil(func() {
-		if err := r.ListAndWatch(stopCh); err != nil {
+		err := r.ListAndWatchWithContext(ctx)
+		if err == nil {
+			return
+		}
+		if !r.observeForbidden(err) {
+			r.watchErrorHandler(r, err)
+			return
+		}
+
+		if r.shouldStopByForbidden() {
+			r.stoppedByForbidden.Store(true)
+			klog.Warningf("%s: stop the reflector of %v after %d consecutive forbidden errors", r.name, r.expectedTypeName, r.consecutiveForbidden.Load())
 			r.watchErrorHandler(r, err)
+			cancel()
+			return
 		}
-	}, r.backoffManager, true, stopCh)
+		r.watchErrorHandler(r, err)
+		r.waitBackoff(ctx, r.forbiddenBackoffManager)
+	}, r.backoffManager, true, ctx.Done())
 	klog.V(3).Infof("Stopping reflector %s (%s) from %s", r.expectedTypeName, r.resyncPeriod, r.name)
 }
 
 var (
+	// defaultRelistPeriod is the relist period of the relist-only mode when the resyncPeriod is unset.
+	defaultRelistPeriod = 5 * time.Minute
+
+	// defaultCheckpointInterval is the minimum interval between two checkpoints when the CheckpointInterval is unset.
+	defaultCheckpointInterval = 30 * time.Second
+
 	// nothing will ever be sent down this channel
 	neverExitWatch <-chan time.Time = make(chan time.Time)
 
@@ -249,6 +436,99 @@ func (r *Reflector) resyncChan() (<-chan time.Time, func() bool) {
 	return t.C(), t.Stop
 }
 
+// ReflectorStatus is the observed state of the Reflector.
+type ReflectorStatus struct {
+	// PageSize is the effective page size of the current or last list,
+	// 0 means the list is not paginated.
+	PageSize int64
+
+	// RelistOnly means the resources are synced by the periodic relist instead of the watch.
+	RelistOnly bool
+
+	// ListProgress is the progress of the current paginated list with StreamHandleForPaginatedList.
+	ListProgress ListProgress
+
+	// GVKMismatchDroppedEvents is the number of the watch events dropped because of the mismatched gvk.
+	GVKMismatchDroppedEvents int64
+
+	// ConsecutiveForbidden is the number of the consecutive forbidden errors of the list and watch,
+	// it is reset after the permissions are granted and the watch is started.
+	ConsecutiveForbidden int64
+
+	// StoppedByForbidden means the Reflector is stopped after MaxConsecutiveForbidden forbidden errors.
+	StoppedByForbidden bool
+}
+
+// ListProgress is the progress of the paginated list handled by the result stream.
+type ListProgress struct {
+	// Listing is true while the paginated list is in progress.
+	Listing bool
+
+	// Items is the number of the items handled by the current list.
+	Items int64
+
+	// Total is the number of all the items of the list, it is valid only if TotalKnown is true.
+	// The total is known only if the server returns the remainingItemCount of the pages.
+	Total      int64
+	TotalKnown bool
+}
+
+// Status returns the observed state of the Reflector.
+func (r *Reflector) Status() ReflectorStatus {
+	r.listProgressLock.Lock()
+	progress := r.listProgress
+	r.listProgressLock.Unlock()
+
+	return ReflectorStatus{
+		PageSize:     r.effectivePageSize.Load(),
+		RelistOnly:   r.ForceRelistOnly || r.relistOnly.Load(),
+		ListProgress: progress,
+
+		GVKMismatchDroppedEvents: r.gvkMismatchDroppedEvents.Load(),
+
+		ConsecutiveForbidden: r.consecutiveForbidden.Load(),
+		StoppedByForbidden:   r.stoppedByForbidden.Load(),
+	}
+}
+
+// startListProgress resets the progress for a new list, and returns the function reporting the progress of the list.
+// The progress reported by the previous lists, e.g. the in-flight list cancelled by the ctx, is ignored.
+func (r *Reflector) startListProgress() func(progress ListProgress) {
+	r.listProgressLock.Lock()
+	r.listProgressID++
+	id := r.listProgressID
+	r.listProgressLock.Unlock()
+
+	report := func(progress ListProgress) {
+		r.listProgressLock.Lock()
+		defer r.listProgressLock.Unlock()
+		if id != r.listProgressID {
+			return
+		}
+		r.listProgress = progress
+		if r.ListProgressHandler != nil {
+			r.ListProgressHandler(progress)
+		}
+	}
+	report(ListProgress{Listing: true})
+	return report
+}
+
+// stopListProgress clears the progress after the list is finished.
+func (r *Reflector) stopListProgress() {
+	r.listProgressLock.Lock()
+	defer r.listProgressLock.Unlock()
+
+	r.listProgressID++
+	if !r.listProgress.Listing {
+		return
+	}
+	r.listProgress = ListProgress{}
+	if r.ListProgressHandler != nil {
+		r.ListProgressHandler(r.listProgress)
+	}
+}
+
 func (r *Reflector) HasInitializedSynced() bool {
 	return r.hasInitializedSynced.Load()
 }
@@ -257,13 +537,40 @@ func (r *Reflector) HasInitializedSynced() bool {
 // and then use the resource version to watch.
 // It returns error if ListAndWatch didn't even try to initialize watch.
 func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
+	return r.ListAndWatchWithContext(wait.ContextForChannel(stopCh))
+}
+
+// ListAndWatchWithContext is the same as ListAndWatch,
+// but the list and watch requests are cancelled when the ctx is done.
+func (r *Reflector) ListAndWatchWithContext(ctx context.Context) error {
 	klog.V(3).Infof("Listing and watching %v from %s", r.expectedTypeName, r.name)
 
-	err := r.list(stopCh)
-	if err != nil {
-		return err
+	if r.InitialResourceVersion != "" && !r.initialListSkipped && !r.ForceRelistOnly {
+		// the store is warm, skip the initial list only once,
+		// the subsequent ListAndWatch always lists the resources.
+		r.initialListSkipped = true
+		klog.V(3).Infof("%s: skip the initial list of %v, watch from the resource version %s", r.name, r.expectedTypeName, r.InitialResourceVersion)
+		r.setLastSyncResourceVersion(r.InitialResourceVersion)
+	} else {
+		err := r.list(ctx)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			// the list is interrupted, the store has not been initialized
+			return nil
+		}
 	}
 	r.hasInitializedSynced.Store(true)
+	if r.InitializedHandler != nil {
+		r.InitializedHandler()
+	}
+	if r.StartGate != nil {
+		r.StartGate.Done()
+	}
+
+	// batcher is nil if the store isn't a BatchingStore
+	batcher := r.newWatchBatcher()
 
 	resyncerrc := make(chan error, 1)
 	cancelCh := make(chan struct{})
@@ -276,13 +583,17 @@ func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
 		for {
 			select {
 			case <-resyncCh:
-			case <-stopCh:
+			case <-ctx.Done():
 				return
 			case <-cancelCh:
 				return
 			}
 			if r.ShouldResync == nil || r.ShouldResync() {
 				klog.V(4).Infof("%s: forcing resync", r.name)
+				if batcher != nil {
+					// resync the store with the buffered watch events
+					batcher.flush()
+				}
 				if err := r.store.Resync(); err != nil {
 					resyncerrc <- err
 					return
@@ -293,11 +604,15 @@ func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
 		}
 	}()
 
+	if r.ForceRelistOnly || r.relistOnly.Load() {
+		return r.relistPeriodically(ctx)
+	}
+
 	retry := cache.NewRetryWithDeadline(r.MaxInternalErrorRetryDuration, time.Minute, apierrors.IsInternalError, r.clock)
 	for {
-		// give the stopCh a chance to stop the loop, even in case of continue statements further down on errors
+		// give the ctx a chance to stop the loop, even in case of continue statements further down on errors
 		select {
-		case <-stopCh:
+		case <-ctx.Done():
 			return nil
 		default:
 		}
@@ -316,24 +631,42 @@ func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
 
 		// start the clock before sending the request, since some proxies won't flush headers until after the first watch event is sent
 		start := r.clock.Now()
-		w, err := r.listerWatcher.Watch(options)
+		w, err := r.watch(ctx, options)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isExpiredError(err) {
+				// the resource version is too old to watch, e.g. the InitialResourceVersion restored from the storage,
+				// return nil to relist the resources.
+				klog.V(4).Infof("%s: watch of %v from the resource version %s is expired: %v", r.name, r.expectedTypeName, options.ResourceVersion, err)
+				return nil
+			}
+			if isWatchUnsupportedError(err) {
+				klog.Warningf("%s: watch of %v is unsupported, switch to relist only: %v", r.name, r.expectedTypeName, err)
+				r.relistOnly.Store(true)
+				return r.relistPeriodically(ctx)
+			}
+
 			// If this is "connection refused" error, it means that most likely apiserver is not responsive.
 			// It doesn't make sense to re-list all objects because most likely we will be able to restart
 			// watch where we ended.
 			// If that's the case begin exponentially backing off and resend watch request.
 			// Do the same for "429" errors.
 			if utilnet.IsConnectionRefused(err) || apierrors.IsTooManyRequests(err) {
-				<-r.initConnBackoffManager.Backoff().C()
+				if !r.waitBackoff(ctx, r.initConnBackoffManager) {
+					return nil
+				}
 				continue
 			}
 			return err
 		}
 
+		r.resetForbidden()
 		// call watchErrorHandler setting ClusterResourceSyncCondition status to Syncing
 		r.watchErrorHandler(r, nil)
 
-		err = watchHandler(start, w, r.store, r.expectedType, r.expectedGVK, r.name, r.expectedTypeName, r.setLastSyncResourceVersion, r.clock, resyncerrc, stopCh)
+		err = watchHandler(start, w, r.store, batcher, r.expectedType, r.expectedGVK, r.name, r.expectedTypeName, r.handleGVKMismatch, r.setLastSyncResourceVersion, r.EventHook, r.checkpoint, r.clock, r.ShortWatchThreshold, r.WatchDurationObserver, resyncerrc, ctx.Done())
 		retry.After(err)
 		if err != nil {
 			if err != errorStopRequested {
@@ -343,9 +676,14 @@ func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
 					// has a semantic that it returns data at least as fresh as provided RV.
 					// So first try to LIST with setting RV to resource version of last observed object.
 					klog.V(4).Infof("%s: watch of %v closed with: %v", r.name, r.expectedTypeName, err)
+				case apierrors.IsForbidden(err):
+					// the permissions are revoked during the watch, back off with the forbidden backoff
+					return err
 				case apierrors.IsTooManyRequests(err):
 					klog.V(2).Infof("%s: watch of %v returned 429 - backing off", r.name, r.expectedTypeName)
-					<-r.initConnBackoffManager.Backoff().C()
+					if !r.waitBackoff(ctx, r.initConnBackoffManager) {
+						return nil
+					}
 					continue
 				case apierrors.IsInternalError(err) && retry.ShouldRetry():
 					klog.V(2).Infof("%s: retrying watch of %v internal error: %v", r.name, r.expectedTypeName, err)
@@ -359,14 +697,95 @@ func (r *Reflector) ListAndWatch(stopCh <-chan struct{}) error {
 	}
 }
 
+// relistPeriodically relists the resources at the resync period until the ctx is done,
+// it is used for the resources that support list but not watch.
+func (r *Reflector) relistPeriodically(ctx context.Context) error {
+	r.resetForbidden()
+	// call watchErrorHandler setting ClusterResourceSyncCondition status to SyncingByRelist
+	r.watchErrorHandler(r, nil)
+
+	period := r.resyncPeriod
+	if period == 0 {
+		period = defaultRelistPeriod
+	}
+	for {
+		t := r.clock.NewTimer(period)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C():
+		}
+
+		klog.V(4).Infof("%s: relist %v", r.name, r.expectedTypeName)
+		if err := r.list(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// checkpoint calls the Checkpoint with the resource version of the bookmark event at a rate-limited interval.
+func (r *Reflector) checkpoint(resourceVersion string) {
+	if r.Checkpoint == nil || resourceVersion == "" {
+		return
+	}
+
+	interval := r.CheckpointInterval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+	now := r.clock.Now()
+	if !r.lastCheckpointTime.IsZero() && now.Sub(r.lastCheckpointTime) < interval {
+		return
+	}
+
+	// the events before the bookmark are still pending in the queue,
+	// skip this bookmark and wait for the next one.
+	if queue, ok := r.store.(cache.Queue); ok && len(queue.ListKeys()) != 0 {
+		return
+	}
+
+	r.lastCheckpointTime = now
+	r.Checkpoint(resourceVersion)
+}
+
+// waitBackoff waits for the backoff, it returns false if the ctx is done before that.
+func (r *Reflector) waitBackoff(ctx context.Context, backoff wait.BackoffManager) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-backoff.Backoff().C():
+		return true
+	}
+}
+
+// listFromServer prefers the context-aware list of the listerWatcher,
+// so that the in-flight requests can be cancelled.
+func (r *Reflector) listFromServer(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+	if lw, ok := r.listerWatcher.(ListerWatcherWithContext); ok {
+		return lw.ListWithContext(ctx, options)
+	}
+	return r.listerWatcher.List(options)
+}
+
+// watch prefers the context-aware watch of the listerWatcher,
+// the TimeoutSeconds of the watch is not enough to stop the watch promptly.
+func (r *Reflector) watch(ctx context.Context, options metav1.ListOptions) (watch.Interface, error) {
+	if lw, ok := r.listerWatcher.(ListerWatcherWithContext); ok {
+		return lw.WatchWithContext(ctx, options)
+	}
+	return r.listerWatcher.Watch(options)
+}
+
 // list simply lists all items and records a resource version obtained from the server at the moment of the call.
 // the resource version can be used for further progress notification (aka. watch).
-func (r *Reflector) list(stopCh <-chan struct{}) error {
+func (r *Reflector) list(ctx context.Context) error {
 	var resourceVersion string
 	options := metav1.ListOptions{ResourceVersion: r.relistResourceVersion()}
 
 	initTrace := trace.New("Reflector ListAndWatch", trace.Field{Key: "name", Value: r.name})
 	defer initTrace.LogIfLong(10 * time.Second)
+	defer r.stopListProgress()
 	var list runtime.Object
 	var itemKeys []interface{}
 	var paginatedResult bool
@@ -381,9 +800,7 @@ func (r *Reflector) list(stopCh <-chan struct{}) error {
 		}()
 		// Attempt to gather list in chunks, if supported by listerWatcher, if not, the first
 		// list request will return the full response.
-		pager := clspager.New(clspager.SimplePageFunc(func(opts metav1.ListOptions) (runtime.Object, error) {
-			return r.listerWatcher.List(opts)
-		}))
+		pager := clspager.New(r.listFromServer)
 		switch {
 		case r.WatchListPageSize != 0:
 			pager.PageSize = r.WatchListPageSize
@@ -406,13 +823,25 @@ func (r *Reflector) list(stopCh <-chan struct{}) error {
 			// we don't introduce regression.
 			pager.PageSize = 0
 		}
+		if r.AdaptivePageSize && pager.PageSize != 0 {
+			if learned := r.learnedPageSize.Load(); learned != 0 {
+				pager.PageSize = learned
+			}
+			pager.NextPageSize = r.adaptPageSize
+		}
+		r.effectivePageSize.Store(pager.PageSize)
+
+		pager.ReadAhead = r.PagerReadAhead
 
 		if r.StreamHandleForPaginatedList {
-			list, itemKeys, paginatedResult, err = r.listWithResultStream(context.Background(), pager, options)
+			list, itemKeys, paginatedResult, err = r.listWithResultStream(ctx, pager, options)
 		} else {
-			list, paginatedResult, err = pager.List(context.Background(), options)
+			list, paginatedResult, err = pager.List(ctx, options)
 		}
 
+		if clspager.IsExpiredContinueError(err) {
+			klog.V(2).Infof("%s: continue token of the paginated list of %v is expired, restart the list: %v", r.name, r.expectedTypeName, err)
+		}
 		if isExpiredError(err) || isTooLargeResourceVersionError(err) {
 			r.setIsLastSyncResourceVersionUnavailable(true)
 			// Retry immediately if the resource version used to list is unavailable.
@@ -424,21 +853,25 @@ func (r *Reflector) list(stopCh <-chan struct{}) error {
 
 			options := metav1.ListOptions{ResourceVersion: r.relistResourceVersion()}
 			if r.StreamHandleForPaginatedList {
-				list, itemKeys, paginatedResult, err = r.listWithResultStream(context.Background(), pager, options)
+				list, itemKeys, paginatedResult, err = r.listWithResultStream(ctx, pager, options)
 			} else {
-				list, paginatedResult, err = pager.List(context.Background(), options)
+				list, paginatedResult, err = pager.List(ctx, options)
 			}
 		}
 		close(listCh)
 	}()
 	select {
-	case <-stopCh:
+	case <-ctx.Done():
 		return nil
 	case r := <-panicCh:
 		panic(r)
 	case <-listCh:
 	}
 	initTrace.Step("Objects listed", trace.Field{Key: "error", Value: err})
+	if err != nil && ctx.Err() != nil {
+		// the in-flight list is cancelled by the ctx
+		return nil
+	}
 	if err != nil {
 		klog.Warningf("%s: failed to list %v: %v", r.name, r.expectedTypeName, err)
 		return fmt.Errorf("failed to list %v: %w", r.expectedTypeName, err)
@@ -495,20 +928,103 @@ func (r *Reflector) list(stopCh <-chan struct{}) error {
 func (r *Reflector) listWithResultStream(ctx context.Context, pager *clspager.ListPager, options metav1.ListOptions) (
 	list runtime.Object, itemKeys []interface{}, paginatedResult bool, err error,
 ) {
-	ch := make(chan runtime.Object, 10)
+	bufferSize := r.StreamBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultStreamBufferSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the progress is reset by each list, including the retry of the expired list
+	reportProgress := r.startListProgress()
+
+	// pages records the progress at the end of each listed page, the progress is reported
+	// after all the items of the page are handled.
+	var pages []ListProgress
+	var pagesLock sync.Mutex
+	var listed int64
+	pager.PageListed = func(items int, remainingItemCount *int64) {
+		pagesLock.Lock()
+		defer pagesLock.Unlock()
+
+		listed += int64(items)
+		page := ListProgress{Listing: true, Items: listed}
+		if remainingItemCount != nil {
+			page.Total, page.TotalKnown = listed+*remainingItemCount, true
+		}
+		pages = append(pages, page)
+	}
+	handledPages := func(handled int64) (progress ListProgress, ok bool) {
+		pagesLock.Lock()
+		defer pagesLock.Unlock()
+
+		for len(pages) != 0 && pages[0].Items <= handled {
+			progress, ok = pages[0], true
+			pages = pages[1:]
+		}
+		return
+	}
+
+	ch := make(chan runtime.Object, bufferSize)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		list, paginatedResult, err = pager.List(clspager.WithResultStream(ctx, ch), options)
 	}()
 
+	// the items are added in batches if the store is a BatchingStore
+	batchingStore, batching := r.store.(BatchingStore)
+	var batch, batchKeys []interface{}
+	addBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := batchingStore.AddBatch(batch); err != nil {
+			return err
+		}
+		itemKeys = append(itemKeys, batchKeys...)
+		batch, batchKeys = nil, nil
+		return nil
+	}
+
 	var key string
+	var handleErr error
 	for obj := range ch {
-		if key, err = cache.MetaNamespaceKeyFunc(obj); err != nil {
-			return
+		if handleErr != nil {
+			// drain the result stream until the pager is stopped
+			continue
 		}
-		if err = r.store.Add(obj); err != nil {
-			return
+		if key, handleErr = cache.MetaNamespaceKeyFunc(obj); handleErr == nil {
+			if batching {
+				batch, batchKeys = append(batch, obj), append(batchKeys, cache.ExplicitKey(key))
+				if len(batch) >= r.watchBatchSize() {
+					handleErr = addBatch()
+				}
+			} else if handleErr = r.store.Add(obj); handleErr == nil {
+				itemKeys = append(itemKeys, cache.ExplicitKey(key))
+			}
+		}
+		if handleErr != nil {
+			// stop the pager if the items are failed to be handled
+			cancel()
+			continue
+		}
+
+		if progress, ok := handledPages(int64(len(itemKeys))); ok {
+			reportProgress(progress)
 		}
-		itemKeys = append(itemKeys, cache.ExplicitKey(key))
+	}
+	if handleErr == nil {
+		handleErr = addBatch()
+	}
+
+	// the result stream is closed before the pager returns, wait for the result of the pager
+	<-done
+	if handleErr != nil {
+		return nil, nil, paginatedResult, handleErr
+	}
+	if progress, ok := handledPages(int64(len(itemKeys))); ok && err == nil {
+		reportProgress(progress)
 	}
 	return
 }
@@ -519,23 +1035,36 @@ func (r *Reflector) syncWith(items []runtime.Object, resourceVersion string) err
 	for _, item := range items {
 		found = append(found, item)
 	}
-	return r.store.Replace(found, resourceVersion)
+	return r.replace(found, resourceVersion)
 }
 
 func (r *Reflector) syncWithKeys(keys []interface{}, resourceVersion string) error {
-	return r.store.Replace(keys, resourceVersion)
+	return r.replace(keys, resourceVersion)
+}
+
+func (r *Reflector) replace(items []interface{}, resourceVersion string) error {
+	if store, ok := r.store.(BatchingStore); ok {
+		return store.ReplaceBatch(items, resourceVersion)
+	}
+	return r.store.Replace(items, resourceVersion)
 }
 
 // watchHandler watches w and sets setLastSyncResourceVersion
 func watchHandler(start time.Time,
 	w watch.Interface,
 	store cache.Store,
+	batcher *watchBatcher,
 	expectedType reflect.Type,
 	expectedGVK *schema.GroupVersionKind,
 	name string,
 	expectedTypeName string,
+	gvkMismatch func(expected, actual schema.GroupVersionKind) (bool, error),
 	setLastSyncResourceVersion func(string),
+	hook EventHook,
+	checkpoint func(resourceVersion string),
 	clock clock.Clock,
+	shortWatchThreshold time.Duration,
+	durationObserver prometheus.Observer,
 	errc chan error,
 	stopCh <-chan struct{},
 ) error {
@@ -545,13 +1074,40 @@ func watchHandler(start time.Time,
 	// we're coming back in with the same watch interface.
 	defer w.Stop()
 
+	// flushC fires when the buffered events of the batcher should be flushed by the period
+	var flushC <-chan time.Time
+	var stopFlushTimer func() bool
+	if batcher != nil {
+		// the buffered events are flushed when the watch is stopped or failed
+		defer batcher.flush()
+		defer func() {
+			if stopFlushTimer != nil {
+				stopFlushTimer()
+			}
+		}()
+	}
+
 loop:
 	for {
+		if batcher != nil {
+			switch pending := batcher.pending(); {
+			case pending && flushC == nil:
+				timer := clock.NewTimer(batcher.period)
+				flushC, stopFlushTimer = timer.C(), timer.Stop
+			case !pending && flushC != nil:
+				stopFlushTimer()
+				flushC, stopFlushTimer = nil, nil
+			}
+		}
+
 		select {
 		case <-stopCh:
 			return errorStopRequested
 		case err := <-errc:
 			return err
+		case <-flushC:
+			batcher.flush()
+			flushC, stopFlushTimer = nil, nil
 		case event, ok := <-w.ResultChan():
 			if !ok {
 				break loop
@@ -567,8 +1123,18 @@ loop:
 			}
 			if expectedGVK != nil {
 				if e, a := *expectedGVK, event.Object.GetObjectKind().GroupVersionKind(); e != a {
-					utilruntime.HandleError(fmt.Errorf("%s: expected gvk %v, but watch event object had gvk %v", name, e, a))
-					continue
+					accepted, err := false, error(nil)
+					if gvkMismatch != nil {
+						accepted, err = gvkMismatch(e, a)
+					}
+					if err != nil {
+						return err
+					}
+					if !accepted {
+						utilruntime.HandleError(fmt.Errorf("%s: expected gvk %v, but watch event object had gvk %v", name, e, a))
+						continue
+					}
+					expectedGVK = &a
 				}
 			}
 			meta, err := meta.Accessor(event.Object)
@@ -577,17 +1143,28 @@ loop:
 				continue
 			}
 			resourceVersion := meta.GetResourceVersion()
+			if batcher != nil {
+				if event.Type == watch.Added || event.Type == watch.Modified {
+					// the resource version is acknowledged after the buffered events are flushed
+					batcher.add(event, resourceVersion)
+					eventCount++
+					continue
+				}
+				batcher.flush()
+			}
 			switch event.Type {
 			case watch.Added:
 				err := store.Add(event.Object)
 				if err != nil {
 					utilruntime.HandleError(fmt.Errorf("%s: unable to add watch event object (%#v) to store: %v", name, event.Object, err))
 				}
+				notifyEventHook(hook, event, resourceVersion, err)
 			case watch.Modified:
 				err := store.Update(event.Object)
 				if err != nil {
 					utilruntime.HandleError(fmt.Errorf("%s: unable to update watch event object (%#v) to store: %v", name, event.Object, err))
 				}
+				notifyEventHook(hook, event, resourceVersion, err)
 			case watch.Deleted:
 				// TODO: Will any consumers need access to the "last known
 				// state", which is passed in event.Object? If so, may need
@@ -596,8 +1173,12 @@ loop:
 				if err != nil {
 					utilruntime.HandleError(fmt.Errorf("%s: unable to delete watch event object (%#v) from store: %v", name, event.Object, err))
 				}
+				notifyEventHook(hook, event, resourceVersion, err)
 			case watch.Bookmark:
 				// A `Bookmark` means watch has synced here, just update the resourceVersion
+				if checkpoint != nil {
+					checkpoint(resourceVersion)
+				}
 			default:
 				utilruntime.HandleError(fmt.Errorf("%s: unable to understand watch event %#v", name, event))
 			}
@@ -609,9 +1190,21 @@ loop:
 		}
 	}
 
+	// the start is read from the same clock, the duration is negative only if the clock jumps backwards,
+	// e.g. the wall clock is adjusted, then the duration is unknown and the watch isn't treated as short.
 	watchDuration := clock.Since(start)
-	if watchDuration < 1*time.Second && eventCount == 0 {
-		return fmt.Errorf("very short watch: %s: Unexpected watch close - watch lasted less than a second and no items received", name)
+	if watchDuration < 0 {
+		klog.V(4).Infof("%s: the clock jumped backwards during the watch of %v, the watch duration is unknown", name, expectedTypeName)
+	} else {
+		if durationObserver != nil {
+			durationObserver.Observe(watchDuration.Seconds())
+		}
+		if shortWatchThreshold <= 0 {
+			shortWatchThreshold = defaultShortWatchThreshold
+		}
+		if watchDuration < shortWatchThreshold && eventCount == 0 {
+			return fmt.Errorf("very short watch: %s: Unexpected watch close - watch lasted less than %s and no items received", name, shortWatchThreshold)
+		}
 	}
 	klog.V(4).Infof("%s: Watch close - %v total %v items received", name, expectedTypeName, eventCount)
 	return nil
@@ -673,6 +1266,16 @@ func isExpiredError(err error) bool {
 	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
 }
 
+// isWatchUnsupportedError returns true if the resource can be listed but not watched,
+// such as some aggregated APIs.
+func isWatchUnsupportedError(err error) bool {
+	if apierrors.IsMethodNotSupported(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	return errors.As(err, &status) && status.Status().Code == http.StatusNotImplemented
+}
+
 func isTooLargeResourceVersionError(err error) bool {
 	if apierrors.HasStatusCause(err, metav1.CauseTypeResourceVersionTooLarge) {
 		return true
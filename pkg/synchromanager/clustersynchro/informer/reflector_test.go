@@ -0,0 +1,631 @@
+package informer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestReflectorRunWithContextCancelsSlowList(t *testing.T) {
+	listStarted := make(chan struct{})
+	listCancelled := make(chan struct{})
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, _ metav1.ListOptions) (runtime.Object, error) {
+			close(listStarted)
+
+			// a slow pager, the page is only returned when the request is cancelled
+			<-ctx.Done()
+			close(listCancelled)
+			return nil, ctx.Err()
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			t.Error("watch should not be called")
+			return watch.NewFake(), nil
+		},
+	}
+	r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.RunWithContext(ctx)
+	}()
+
+	select {
+	case <-listStarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("list is not started")
+	}
+	cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunWithContext did not return after the context was cancelled")
+	}
+	select {
+	case <-listCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the in-flight list request is not cancelled")
+	}
+	assert.False(t, r.HasInitializedSynced())
+}
+
+func TestReflectorRunStopsWatch(t *testing.T) {
+	watchCancelled := make(chan struct{})
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, _ metav1.ListOptions) (runtime.Object, error) {
+			return &unstructured.UnstructuredList{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "1"},
+			}}, nil
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			fw := watch.NewFake()
+			go func() {
+				<-ctx.Done()
+				close(watchCancelled)
+			}()
+			return fw, nil
+		},
+	}
+	r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+
+	stopCh := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		r.Run(stopCh)
+	}()
+
+	assert.Eventually(t, r.HasInitializedSynced, 5*time.Second, 10*time.Millisecond)
+	close(stopCh)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the stopCh was closed")
+	}
+	select {
+	case <-watchCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch request is not cancelled")
+	}
+}
+
+func TestReflectorAdaptivePageSize(t *testing.T) {
+	newPage := func(n int, continueToken string) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": "1", "continue": continueToken},
+		}}
+		for i := 0; i < n; i++ {
+			obj := unstructured.Unstructured{}
+			obj.SetNamespace("default")
+			obj.SetName(fmt.Sprintf("obj-%d-%s", i, continueToken))
+			obj.SetResourceVersion("1")
+			list.Items = append(list.Items, obj)
+		}
+		return list
+	}
+
+	var limits []int64
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			limits = append(limits, options.Limit)
+			if options.Continue == "" {
+				return newPage(5, "next"), nil
+			}
+			return newPage(5, ""), nil
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}
+	r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+	r.WatchListPageSize = 5
+	r.AdaptivePageSize = true
+	r.MinWatchListPageSize = 2
+	r.MaxWatchListPageSize = 1000
+	r.TargetPageBytes = 1
+
+	assert.NoError(t, r.list(context.Background()))
+	assert.Equal(t, []int64{5, 2}, limits)
+	assert.Equal(t, int64(2), r.Status().PageSize)
+
+	// the learned page size is reused by the relist
+	limits = nil
+	assert.NoError(t, r.list(context.Background()))
+	assert.Equal(t, []int64{2, 2}, limits)
+	assert.Equal(t, int64(2), r.Status().PageSize)
+}
+
+func TestReflectorListProgress(t *testing.T) {
+	newPage := func(page int, continueToken string, remaining int64) *unstructured.UnstructuredList {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"resourceVersion": "1", "continue": continueToken},
+		}}
+		list.SetRemainingItemCount(&remaining)
+		for i := 0; i < 2; i++ {
+			obj := unstructured.Unstructured{}
+			obj.SetNamespace("default")
+			obj.SetName(fmt.Sprintf("obj-%d-%d", page, i))
+			obj.SetResourceVersion("1")
+			list.Items = append(list.Items, obj)
+		}
+		return list
+	}
+
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			switch options.Continue {
+			case "":
+				return newPage(0, "1", 4), nil
+			case "1":
+				return newPage(1, "2", 2), nil
+			}
+			return newPage(2, "", 0), nil
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}
+	r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+	r.WatchListPageSize = 2
+	r.StreamHandleForPaginatedList = true
+
+	var reported []ListProgress
+	r.ListProgressHandler = func(progress ListProgress) {
+		reported = append(reported, progress)
+		if progress.Listing {
+			assert.Equal(t, progress, r.listProgress)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		reported = nil
+		assert.NoError(t, r.list(context.Background()))
+
+		// the progress is reset by the relist, and cleared after the list is finished
+		assert.Equal(t, ListProgress{Listing: true}, reported[0])
+		assert.Equal(t, ListProgress{Listing: true, Items: 6, Total: 6, TotalKnown: true}, reported[len(reported)-2])
+		assert.Equal(t, ListProgress{}, reported[len(reported)-1])
+		for _, progress := range reported[1 : len(reported)-1] {
+			assert.True(t, progress.TotalKnown)
+			assert.Equal(t, int64(6), progress.Total)
+		}
+		assert.Equal(t, ListProgress{}, r.Status().ListProgress)
+	}
+
+	// the progress reported by the previous list is ignored
+	report := r.startListProgress()
+	r.stopListProgress()
+	report(ListProgress{Listing: true, Items: 2})
+	assert.Equal(t, ListProgress{}, r.Status().ListProgress)
+}
+
+func TestReflectorSwitchToRelistOnly(t *testing.T) {
+	var lists, watches atomic.Int32
+	lw := &ListWatch{
+		ListFunc: func(ctx context.Context, _ metav1.ListOptions) (runtime.Object, error) {
+			lists.Add(1)
+			return &unstructured.UnstructuredList{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "1"},
+			}}, nil
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			watches.Add(1)
+			return nil, apierrors.NewMethodNotSupported(schema.GroupResource{Group: "metrics.k8s.io", Resource: "pods"}, "watch")
+		},
+	}
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	r := NewReflectorWithOptions(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), ReflectorOptions{Clock: fakeClock})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.ListAndWatchWithContext(ctx) }()
+
+	assert.Eventually(t, func() bool { return r.Status().RelistOnly && fakeClock.HasWaiters() }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), lists.Load())
+
+	fakeClock.Step(defaultRelistPeriod)
+	assert.Eventually(t, func() bool { return lists.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), watches.Load())
+}
+
+func TestWatchHandlerEventHook(t *testing.T) {
+	newObject := func(name, rv string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		obj.SetResourceVersion(rv)
+		return obj
+	}
+
+	var events []string
+	hook := EventHookFuncs{
+		AddFunc:    func(key, rv string) { events = append(events, "add "+key+" "+rv) },
+		UpdateFunc: func(key, rv string) { events = append(events, "update "+key+" "+rv) },
+		DeleteFunc: func(key, rv string) { events = append(events, "delete "+key+" "+rv) },
+		ErrorFunc: func(eventType watch.EventType, key, rv string, err error) {
+			events = append(events, fmt.Sprintf("error %s %s %s", eventType, key, rv))
+		},
+	}
+
+	fw := watch.NewFake()
+	go func() {
+		fw.Add(newObject("a", "1"))
+		fw.Modify(newObject("a", "2"))
+		fw.Delete(newObject("a", "3"))
+		// the store fails to delete the object without name
+		fw.Delete(&unstructured.Unstructured{Object: map[string]interface{}{"metadata": map[string]interface{}{"resourceVersion": "4"}}})
+		fw.Stop()
+	}()
+
+	store := cache.NewStore(func(obj interface{}) (string, error) {
+		if accessor, _ := meta.Accessor(obj); accessor.GetName() == "" {
+			return "", errors.New("name is required")
+		}
+		return cache.MetaNamespaceKeyFunc(obj)
+	})
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	err := watchHandler(fakeClock.Now(), fw, store, nil, nil, nil, "test", "test", nil, func(string) {}, hook, nil, fakeClock, 0, nil, make(chan error), make(chan struct{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"add default/a 1",
+		"update default/a 2",
+		"delete default/a 3",
+		"error DELETED  4",
+	}, events)
+}
+
+func TestReflectorInitialResourceVersionExpired(t *testing.T) {
+	var requests []string
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lw := &ListWatch{
+		ListFunc: func(_ context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			requests = append(requests, "list "+options.ResourceVersion)
+			return &unstructured.UnstructuredList{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "10"},
+			}}, nil
+		},
+		WatchFunc: func(_ context.Context, options metav1.ListOptions) (watch.Interface, error) {
+			requests = append(requests, "watch "+options.ResourceVersion)
+			fw := watch.NewFake()
+			if options.ResourceVersion == "5" {
+				go fw.Error(&apierrors.NewResourceExpired("too old resource version").ErrStatus)
+				return fw, nil
+			}
+			cancel()
+			return fw, nil
+		},
+	}
+	r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+	r.InitialResourceVersion = "5"
+
+	// the initial list is skipped, the watch from the initial resource version is expired
+	assert.NoError(t, r.ListAndWatchWithContext(ctx))
+	assert.True(t, r.HasInitializedSynced())
+	assert.Equal(t, []string{"watch 5"}, requests)
+
+	// fall back to the list
+	assert.NoError(t, r.ListAndWatchWithContext(ctx))
+	assert.Equal(t, []string{"watch 5", "list 5", "watch 10"}, requests)
+	assert.Equal(t, "10", r.LastSyncResourceVersion())
+}
+
+func TestReflectorCheckpoint(t *testing.T) {
+	fifo := cache.NewDeltaFIFOWithOptions(cache.DeltaFIFOOptions{KeyFunction: cache.MetaNamespaceKeyFunc})
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	r := NewReflectorWithOptions(&ListWatch{}, &unstructured.Unstructured{}, fifo, ReflectorOptions{Clock: fakeClock})
+
+	var checkpoints []string
+	r.Checkpoint = func(rv string) { checkpoints = append(checkpoints, rv) }
+
+	fw := watch.NewFake()
+	go func() {
+		bookmark := &unstructured.Unstructured{}
+		bookmark.SetResourceVersion("1")
+		fw.Action(watch.Bookmark, bookmark)
+		fw.Stop()
+	}()
+	err := watchHandler(time.Now(), fw, r.store, nil, nil, nil, "test", "test", nil, r.setLastSyncResourceVersion, nil, r.checkpoint, fakeClock, 0, nil, make(chan error), make(chan struct{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, checkpoints)
+
+	// rate limited by the checkpoint interval
+	r.checkpoint("2")
+	assert.Equal(t, []string{"1"}, checkpoints)
+
+	// the events before the bookmark are pending in the queue
+	fakeClock.Step(defaultCheckpointInterval)
+	obj := &unstructured.Unstructured{}
+	obj.SetNamespace("default")
+	obj.SetName("a")
+	assert.NoError(t, fifo.Add(obj))
+	r.checkpoint("3")
+	assert.Equal(t, []string{"1"}, checkpoints)
+
+	_, err = fifo.Pop(func(interface{}, bool) error { return nil })
+	assert.NoError(t, err)
+	r.checkpoint("4")
+	assert.Equal(t, []string{"1", "4"}, checkpoints)
+}
+
+func TestReflectorGVKMismatch(t *testing.T) {
+	v1 := schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Foo"}
+	v2 := schema.GroupVersionKind{Group: "example.io", Version: "v2", Kind: "Foo"}
+	newObject := func(gvk schema.GroupVersionKind, name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		obj.SetResourceVersion("1")
+		return obj
+	}
+
+	for _, tc := range []struct {
+		policy  GVKMismatchPolicy
+		keys    []string
+		dropped int64
+		relist  bool
+		gvk     schema.GroupVersionKind
+	}{
+		{policy: "", keys: []string{"default/a"}, dropped: 2, gvk: v1},
+		{policy: GVKMismatchAcceptVersion, keys: []string{"default/a", "default/b"}, dropped: 1, gvk: v2},
+		{policy: GVKMismatchRelist, keys: []string{"default/a"}, relist: true, gvk: v2},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			example := &unstructured.Unstructured{}
+			example.SetGroupVersionKind(v1)
+			r := NewReflector(&ListWatch{}, example, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+			r.GVKMismatchPolicy = tc.policy
+
+			fw := watch.NewFakeWithChanSize(3, false)
+			fw.Add(newObject(v1, "a"))
+			fw.Add(newObject(v2, "b"))
+			// the events of the other kind are always dropped
+			fw.Add(newObject(v2.GroupVersion().WithKind("Bar"), "c"))
+			fw.Stop()
+			err := watchHandler(time.Now(), fw, r.store, nil, r.expectedType, r.expectedGVK, "test", "test", r.handleGVKMismatch, r.setLastSyncResourceVersion, nil, nil, clocktesting.NewFakeClock(time.Now()), 0, nil, make(chan error), make(chan struct{}))
+			if tc.relist {
+				var mismatch *gvkMismatchError
+				assert.ErrorAs(t, err, &mismatch)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.ElementsMatch(t, tc.keys, r.store.ListKeys())
+			assert.Equal(t, tc.dropped, r.Status().GVKMismatchDroppedEvents)
+			assert.Equal(t, tc.gvk, *r.expectedGVK)
+		})
+	}
+
+	_, err := ParseGVKMismatchPolicy("unknown")
+	assert.Error(t, err)
+}
+
+func newRunningListWatch(watchFunc func(ctx context.Context) (watch.Interface, error)) *ListWatch {
+	return &ListWatch{
+		ListFunc: func(ctx context.Context, _ metav1.ListOptions) (runtime.Object, error) {
+			return &unstructured.UnstructuredList{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"resourceVersion": "1"},
+			}}, nil
+		},
+		WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+			return watchFunc(ctx)
+		},
+	}
+}
+
+func TestReflectorTooManyRequestsBackoff(t *testing.T) {
+	var watches atomic.Int32
+	lw := newRunningListWatch(func(ctx context.Context) (watch.Interface, error) {
+		if watches.Add(1) == 1 {
+			return nil, apierrors.NewTooManyRequests("too many requests", 1)
+		}
+		return watch.NewFake(), nil
+	})
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	r := NewReflectorWithOptions(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), ReflectorOptions{
+		Clock:                  fakeClock,
+		InitConnBackoffManager: wait.NewExponentialBackoffManager(time.Second, time.Minute, time.Hour, 2.0, 0, fakeClock),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = r.ListAndWatchWithContext(ctx)
+	}()
+
+	// the watch is retried only after the backoff
+	assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), watches.Load())
+	fakeClock.Step(time.Minute)
+	assert.Eventually(t, func() bool { return watches.Load() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-stopped
+}
+
+func TestWatchHandlerVeryShortWatch(t *testing.T) {
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+
+	// the watch is closed immediately without any events
+	fw := watch.NewFake()
+	fw.Stop()
+	err := watchHandler(fakeClock.Now(), fw, store, nil, nil, nil, "test", "test", nil, func(string) {}, nil, nil, fakeClock, 0, nil, make(chan error), make(chan struct{}))
+	assert.ErrorContains(t, err, "very short watch")
+
+	// the watch lasts for more than a second
+	start := fakeClock.Now()
+	fakeClock.Step(time.Second)
+	fw = watch.NewFake()
+	fw.Stop()
+	err = watchHandler(start, fw, store, nil, nil, nil, "test", "test", nil, func(string) {}, nil, nil, fakeClock, 0, nil, make(chan error), make(chan struct{}))
+	assert.NoError(t, err)
+}
+
+// observedDurations is a prometheus.Observer recording the observed values.
+type observedDurations []float64
+
+func (o *observedDurations) Observe(value float64) { *o = append(*o, value) }
+
+func TestWatchHandlerClockSkew(t *testing.T) {
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	var durations observedDurations
+
+	// the clock jumps backwards during the watch, the unknown duration is neither short nor observed
+	start := fakeClock.Now()
+	fakeClock.SetTime(start.Add(-time.Hour))
+	fw := watch.NewFake()
+	fw.Stop()
+	err := watchHandler(start, fw, store, nil, nil, nil, "test", "test", nil, func(string) {}, nil, nil, fakeClock, 0, &durations, make(chan error), make(chan struct{}))
+	assert.NoError(t, err)
+	assert.Empty(t, durations)
+
+	// the watch is short by the configured threshold
+	start = fakeClock.Now()
+	fakeClock.Step(time.Minute)
+	fw = watch.NewFake()
+	fw.Stop()
+	err = watchHandler(start, fw, store, nil, nil, nil, "test", "test", nil, func(string) {}, nil, nil, fakeClock, 2*time.Minute, &durations, make(chan error), make(chan struct{}))
+	assert.ErrorContains(t, err, "very short watch")
+	assert.Equal(t, observedDurations{time.Minute.Seconds()}, durations)
+}
+
+func TestReflectorResyncTimerCleanup(t *testing.T) {
+	lw := newRunningListWatch(func(ctx context.Context) (watch.Interface, error) {
+		return watch.NewFake(), nil
+	})
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	r := NewReflectorWithOptions(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), ReflectorOptions{
+		ResyncPeriod: time.Minute,
+		Clock:        fakeClock,
+	})
+	var resyncs atomic.Int32
+	r.ShouldResync = func() bool {
+		resyncs.Add(1)
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = r.ListAndWatchWithContext(ctx)
+	}()
+
+	for i := int32(1); i <= 2; i++ {
+		assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+		fakeClock.Step(time.Minute)
+		assert.Eventually(t, func() bool { return resyncs.Load() == i }, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// the resync timer is stopped after ListAndWatch returns
+	cancel()
+	<-stopped
+	assert.Eventually(t, func() bool { return !fakeClock.HasWaiters() }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReflectorForbidden(t *testing.T) {
+	var lists atomic.Int32
+	newReflector := func(forbiddenLists int32, maxConsecutiveForbidden int) (*Reflector, *clocktesting.FakeClock) {
+		lw := newRunningListWatch(func(ctx context.Context) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		})
+		listFunc := lw.ListFunc
+		lw.ListFunc = func(ctx context.Context, options metav1.ListOptions) (runtime.Object, error) {
+			if lists.Add(1) <= forbiddenLists {
+				return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("rbac"))
+			}
+			return listFunc(ctx, options)
+		}
+
+		fakeClock := clocktesting.NewFakeClock(time.Now())
+		r := NewReflectorWithOptions(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), ReflectorOptions{
+			Clock:                   fakeClock,
+			ForbiddenBackoffManager: wait.NewExponentialBackoffManager(time.Minute, time.Hour, time.Hour, 2.0, 0, fakeClock),
+		})
+		r.MaxConsecutiveForbidden = maxConsecutiveForbidden
+		return r, fakeClock
+	}
+
+	t.Run("recover", func(t *testing.T) {
+		lists.Store(0)
+		r, fakeClock := newReflector(2, 0)
+		var forbidden atomic.Int32
+		r.watchErrorHandler = func(r *Reflector, err error) {
+			if apierrors.IsForbidden(err) {
+				forbidden.Add(1)
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			r.RunWithContext(ctx)
+		}()
+
+		assert.Eventually(t, func() bool { return r.Status().ConsecutiveForbidden == 1 }, 5*time.Second, 10*time.Millisecond)
+		assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+
+		// the list is retried only after the forbidden backoff
+		fakeClock.Step(30 * time.Second)
+		assert.Never(t, func() bool { return lists.Load() != 1 }, 100*time.Millisecond, 10*time.Millisecond)
+
+		// the consecutive forbidden errors are reset after the permissions are granted and the watch is started
+		assert.Eventually(t, func() bool {
+			fakeClock.Step(time.Hour)
+			return lists.Load() == 3 && r.Status().ConsecutiveForbidden == 0
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(2), forbidden.Load())
+		assert.False(t, r.Status().StoppedByForbidden)
+
+		cancel()
+		<-stopped
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		lists.Store(0)
+		r, fakeClock := newReflector(10, 2)
+
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			r.RunWithContext(context.Background())
+		}()
+
+		// the reflector stops by itself after the consecutive forbidden errors
+		assert.Eventually(t, func() bool {
+			fakeClock.Step(time.Hour)
+			select {
+			case <-stopped:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(2), lists.Load())
+		assert.Equal(t, int64(2), r.Status().ConsecutiveForbidden)
+		assert.True(t, r.Status().StoppedByForbidden)
+	})
+}
@@ -1,6 +1,9 @@
 package informer
 
 import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apiserver/pkg/storage"
 	"k8s.io/client-go/tools/cache"
@@ -31,6 +34,61 @@ type InformerConfig struct {
 	WatchListPageSize            int64
 	ForcePaginatedList           bool
 	StreamHandleForPaginatedList bool
+	StreamBufferSize             int
+	PagerReadAhead               bool
+
+	AdaptivePageSize     bool
+	MinWatchListPageSize int64
+	MaxWatchListPageSize int64
+
+	ForceRelistOnly bool
+
+	// EventHook is notified of the outcome of each watch event, it can be nil.
+	EventHook EventHook
+
+	// InitialResourceVersion skips the initial list and watches from the resource version,
+	// the resources are listed only if the watch is expired.
+	InitialResourceVersion string
+
+	// Checkpoint persists the resource version of the bookmark events as the watch progress, it can be nil.
+	Checkpoint         func(resourceVersion string)
+	CheckpointInterval time.Duration
+
+	// ListProgressHandler is notified of the progress of the paginated list, it can be nil.
+	ListProgressHandler func(progress ListProgress)
+
+	// InitializedHandler is called after the initial list is synced, it can be nil.
+	InitializedHandler func()
+
+	// StartGate gates the start of the reflector, e.g. by the priority of the resource, it can be nil.
+	StartGate StartGate
+
+	// GVKMismatchPolicy is the handling of the watch events whose version is changed, e.g. by the CRD.
+	GVKMismatchPolicy GVKMismatchPolicy
+	// GVKMismatchCounter counts the watch events dropped because of the mismatched gvk, it can be nil.
+	GVKMismatchCounter prometheus.Counter
+
+	// MaxConsecutiveForbidden stops the informer after the number of consecutive forbidden errors of the list and watch,
+	// 0 means the informer keeps retrying until the permissions are granted.
+	MaxConsecutiveForbidden int
+
+	// ShortWatchThreshold is the duration under which the watch closed without any events is unexpected, 0 means one second.
+	ShortWatchThreshold time.Duration
+	// WatchDurationObserver observes the durations of the closed watches, it can be nil.
+	WatchDurationObserver prometheus.Observer
+
+	// DedupModifiedCacheSize is the number of keys whose last applied resource version is tracked
+	// to drop the duplicate Modified events, 0 means disable the deduplication.
+	DedupModifiedCacheSize int
+	// DedupSkippedCounter counts the dropped duplicate Modified events, it can be nil.
+	DedupSkippedCounter prometheus.Counter
+
+	// WrapQueue wraps the queue written by the reflector, it can be nil.
+	// The reflector writes the objects in batches if the wrapped queue implements BatchingStore,
+	// the watch events are buffered for up to WatchBatchSize events or WatchBatchPeriod.
+	WrapQueue        func(queue cache.Queue) cache.Queue
+	WatchBatchSize   int
+	WatchBatchPeriod time.Duration
 }
 
 func NewResourceVersionInformer(name string, config InformerConfig) ResourceVersionInformer {
@@ -54,6 +112,12 @@ func NewResourceVersionInformer(name string, config InformerConfig) ResourceVers
 	if config.ExtraStore != nil {
 		queue = &queueWithExtraStore{Queue: queue, extra: config.ExtraStore}
 	}
+	if config.DedupModifiedCacheSize > 0 {
+		queue = newDedupQueue(queue, config.DedupModifiedCacheSize, config.DedupSkippedCounter)
+	}
+	if config.WrapQueue != nil {
+		queue = config.WrapQueue(queue)
+	}
 
 	informer.controller = NewNamedController(informer.name,
 		&Config{
@@ -69,6 +133,26 @@ func NewResourceVersionInformer(name string, config InformerConfig) ResourceVers
 			WatchListPageSize:            config.WatchListPageSize,
 			ForcePaginatedList:           config.ForcePaginatedList,
 			StreamHandleForPaginatedList: config.StreamHandleForPaginatedList,
+			StreamBufferSize:             config.StreamBufferSize,
+			PagerReadAhead:               config.PagerReadAhead,
+			AdaptivePageSize:             config.AdaptivePageSize,
+			MinWatchListPageSize:         config.MinWatchListPageSize,
+			MaxWatchListPageSize:         config.MaxWatchListPageSize,
+			ForceRelistOnly:              config.ForceRelistOnly,
+			EventHook:                    config.EventHook,
+			InitialResourceVersion:       config.InitialResourceVersion,
+			Checkpoint:                   config.Checkpoint,
+			CheckpointInterval:           config.CheckpointInterval,
+			ListProgressHandler:          config.ListProgressHandler,
+			InitializedHandler:           config.InitializedHandler,
+			StartGate:                    config.StartGate,
+			GVKMismatchPolicy:            config.GVKMismatchPolicy,
+			GVKMismatchCounter:           config.GVKMismatchCounter,
+			MaxConsecutiveForbidden:      config.MaxConsecutiveForbidden,
+			ShortWatchThreshold:          config.ShortWatchThreshold,
+			WatchDurationObserver:        config.WatchDurationObserver,
+			WatchBatchSize:               config.WatchBatchSize,
+			WatchBatchPeriod:             config.WatchBatchPeriod,
 		},
 	)
 	return informer
@@ -0,0 +1,125 @@
+package informer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// StartGate gates the start of the Reflector, e.g. to stagger the initial lists of the resources by priority.
+type StartGate interface {
+	// Wait blocks until the Reflector is allowed to start, it returns the error of the ctx if the ctx is done.
+	Wait(ctx context.Context) error
+
+	// Done is called after the initial list of the Reflector is synced or the Reflector is stopped,
+	// it releases the Reflectors waiting for this gate. Done can be called multiple times.
+	Done()
+}
+
+// PriorityStartGates is a semaphore of the Reflectors of the different priorities,
+// the Reflectors of a priority are started only after the Reflectors of all the higher priorities are done.
+type PriorityStartGates struct {
+	clock clock.Clock
+
+	// timeout is the max waiting time of a gate, so the lower priorities are not blocked forever
+	// by a higher priority resource whose list keeps failing. 0 means no timeout.
+	timeout time.Duration
+
+	lock sync.Mutex
+	// pending is the number of the undone gates of each priority.
+	pending map[int]int
+	// changed is closed and renewed when a gate is done.
+	changed chan struct{}
+}
+
+func NewPriorityStartGates(clock clock.Clock, timeout time.Duration) *PriorityStartGates {
+	return &PriorityStartGates{
+		clock:   clock,
+		timeout: timeout,
+		pending: make(map[int]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// Gate returns the start gate of the Reflector of the priority, the greater value has the higher priority.
+func (g *PriorityStartGates) Gate(priority int) StartGate {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.pending[priority]++
+	return &priorityStartGate{gates: g, priority: priority}
+}
+
+// blocked returns whether there are the undone gates of the priorities higher than the priority,
+// and the channel closed when the gates are changed.
+func (g *PriorityStartGates) blocked(priority int) (bool, <-chan struct{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for p, n := range g.pending {
+		if p > priority && n > 0 {
+			return true, g.changed
+		}
+	}
+	return false, nil
+}
+
+func (g *PriorityStartGates) done(priority int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.pending[priority]--; g.pending[priority] <= 0 {
+		delete(g.pending, priority)
+	}
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+type priorityStartGate struct {
+	gates    *PriorityStartGates
+	priority int
+
+	once sync.Once
+	done atomic.Bool
+}
+
+func (gate *priorityStartGate) Wait(ctx context.Context) error {
+	var timeout <-chan time.Time
+	if gate.gates.timeout > 0 {
+		timer := gate.gates.clock.NewTimer(gate.gates.timeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	for {
+		// the restarted Reflector isn't gated again after the gate is done
+		if gate.done.Load() {
+			return nil
+		}
+
+		blocked, changed := gate.gates.blocked(gate.priority)
+		if !blocked {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			klog.InfoS("Timed out waiting for the reflectors of the higher priorities, start the reflector", "priority", gate.priority)
+			return nil
+		case <-changed:
+		}
+	}
+}
+
+func (gate *priorityStartGate) Done() {
+	gate.once.Do(func() {
+		gate.done.Store(true)
+		gate.gates.done(gate.priority)
+	})
+}
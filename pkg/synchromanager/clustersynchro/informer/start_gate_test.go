@@ -0,0 +1,119 @@
+package informer
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestPriorityStartGatesReflectorOrdering(t *testing.T) {
+	var lock sync.Mutex
+	var started []string
+	startedReflectors := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), started...)
+	}
+
+	gates := NewPriorityStartGates(clock.RealClock{}, 0)
+	newReflector := func(name string, priority int, listed <-chan struct{}) *Reflector {
+		lw := &ListWatch{
+			ListFunc: func(ctx context.Context, _ metav1.ListOptions) (runtime.Object, error) {
+				lock.Lock()
+				started = append(started, name)
+				lock.Unlock()
+
+				// the initial list is synced after the listed is closed
+				select {
+				case <-listed:
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				return &unstructured.UnstructuredList{Object: map[string]interface{}{
+					"metadata": map[string]interface{}{"resourceVersion": "1"},
+				}}, nil
+			},
+			WatchFunc: func(ctx context.Context, _ metav1.ListOptions) (watch.Interface, error) {
+				return watch.NewFake(), nil
+			},
+		}
+		r := NewReflector(lw, &unstructured.Unstructured{}, cache.NewStore(cache.MetaNamespaceKeyFunc), 0)
+		r.StartGate = gates.Gate(priority)
+		return r
+	}
+
+	highListed, mediumListed, lowListed := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	high := newReflector("high", 2, highListed)
+	medium := newReflector("medium", 1, mediumListed)
+	low := newReflector("low", 0, lowListed)
+	defer close(lowListed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, r := range []*Reflector{low, medium, high} {
+		go r.RunWithContext(ctx)
+	}
+
+	assert.Eventually(t, func() bool { return len(startedReflectors()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool { return len(startedReflectors()) != 1 }, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, []string{"high"}, startedReflectors())
+
+	close(highListed)
+	assert.Eventually(t, high.HasInitializedSynced, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return len(startedReflectors()) == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Never(t, func() bool { return len(startedReflectors()) != 2 }, 200*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, []string{"high", "medium"}, startedReflectors())
+
+	close(mediumListed)
+	assert.Eventually(t, func() bool { return len(startedReflectors()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"high", "medium", "low"}, startedReflectors())
+}
+
+func TestPriorityStartGates(t *testing.T) {
+	gates := NewPriorityStartGates(clock.RealClock{}, 0)
+	high, low := gates.Gate(1), gates.Gate(0)
+
+	// the gates of the same priority are not blocked by each other
+	same := gates.Gate(1)
+	assert.NoError(t, same.Wait(context.Background()))
+	same.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, low.Wait(ctx), context.DeadlineExceeded)
+
+	// the stopped reflector releases the lower priorities, even if it has never been synced
+	high.Done()
+	high.Done()
+	assert.NoError(t, low.Wait(context.Background()))
+
+	// the lower priorities are started after the timeout
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	gates = NewPriorityStartGates(fakeClock, time.Minute)
+	_, low = gates.Gate(1), gates.Gate(0)
+	waited := make(chan error, 1)
+	go func() { waited <- low.Wait(context.Background()) }()
+	assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+	fakeClock.Step(time.Minute)
+	select {
+	case err := <-waited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the gate isn't opened after the timeout")
+	}
+
+	// the gate is not blocked again after it is done
+	low.Done()
+	gates.Gate(2)
+	assert.NoError(t, low.Wait(context.Background()))
+}
@@ -0,0 +1,188 @@
+package informer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/clock"
+)
+
+// StartScheduleConfig is the budget of the initial lists of the resources of a reconnected cluster,
+// the large resources are staggered by it to avoid saturating the apiserver of the cluster.
+type StartScheduleConfig struct {
+	// SmallCount is the max stored count of the small resources, which are started immediately.
+	SmallCount int64
+
+	// Window is the time the starts of the large resources are spread over, the smaller resources are started first.
+	Window time.Duration
+
+	// Concurrency is the max number of the large resources listed concurrently, 0 means unlimited.
+	Concurrency int
+}
+
+// ScheduledStart is the start of the Reflector of a resource, which is delayed from the start of the schedule.
+type ScheduledStart struct {
+	Delay time.Duration
+	// Large is true if the start is limited by the concurrency.
+	Large bool
+}
+
+// ScheduleStarts schedules the starts of the resources by their stored counts. The small resources are started
+// immediately, and the large ones are ordered by the counts and spread evenly over the window. The resources
+// without the counts, e.g. the resources never synced, aren't scheduled and start immediately.
+func ScheduleStarts(counts map[schema.GroupVersionResource]int64, config StartScheduleConfig) map[schema.GroupVersionResource]ScheduledStart {
+	schedule := make(map[schema.GroupVersionResource]ScheduledStart, len(counts))
+	var large []schema.GroupVersionResource
+	for gvr, count := range counts {
+		if count <= config.SmallCount {
+			schedule[gvr] = ScheduledStart{}
+			continue
+		}
+		large = append(large, gvr)
+	}
+
+	sort.Slice(large, func(i, j int) bool {
+		if counts[large[i]] != counts[large[j]] {
+			return counts[large[i]] < counts[large[j]]
+		}
+		return large[i].String() < large[j].String()
+	})
+	for i, gvr := range large {
+		schedule[gvr] = ScheduledStart{Delay: config.Window * time.Duration(i) / time.Duration(len(large)), Large: true}
+	}
+	return schedule
+}
+
+// StartScheduler gates the starts of the Reflectors of a cluster by the schedule of the stored counts.
+type StartScheduler struct {
+	clock clock.Clock
+
+	schedule map[schema.GroupVersionResource]ScheduledStart
+	// slots limits the concurrent initial lists of the large resources, it is nil if the concurrency is unlimited.
+	slots chan struct{}
+
+	once  sync.Once
+	start time.Time
+}
+
+func NewStartScheduler(clock clock.Clock, counts map[schema.GroupVersionResource]int64, config StartScheduleConfig) *StartScheduler {
+	scheduler := &StartScheduler{clock: clock, schedule: ScheduleStarts(counts, config)}
+	if config.Concurrency > 0 {
+		scheduler.slots = make(chan struct{}, config.Concurrency)
+	}
+	return scheduler
+}
+
+// Gate returns the start gate of the Reflector of the storage resource, the schedule starts when the first gate is returned.
+// It returns nil if the resource isn't scheduled.
+func (s *StartScheduler) Gate(gvr schema.GroupVersionResource) StartGate {
+	s.once.Do(func() { s.start = s.clock.Now() })
+
+	start, ok := s.schedule[gvr]
+	if !ok || (start.Delay == 0 && (!start.Large || s.slots == nil)) {
+		return nil
+	}
+	return &scheduledStartGate{scheduler: s, at: s.start.Add(start.Delay), limited: start.Large && s.slots != nil}
+}
+
+type scheduledStartGate struct {
+	scheduler *StartScheduler
+	at        time.Time
+	limited   bool
+
+	lock     sync.Mutex
+	acquired bool
+	done     atomic.Bool
+}
+
+func (gate *scheduledStartGate) Wait(ctx context.Context) error {
+	// the restarted Reflector isn't gated again after the gate is done
+	if gate.done.Load() {
+		return nil
+	}
+
+	if delay := gate.at.Sub(gate.scheduler.clock.Now()); delay > 0 {
+		timer := gate.scheduler.clock.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+	if !gate.limited {
+		return nil
+	}
+
+	gate.lock.Lock()
+	acquired := gate.acquired
+	gate.lock.Unlock()
+	if acquired {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case gate.scheduler.slots <- struct{}{}:
+	}
+
+	gate.lock.Lock()
+	defer gate.lock.Unlock()
+	// the slot is released if the gate is done while waiting for it
+	if gate.acquired || gate.done.Load() {
+		<-gate.scheduler.slots
+		return nil
+	}
+	gate.acquired = true
+	return nil
+}
+
+func (gate *scheduledStartGate) Done() {
+	gate.lock.Lock()
+	defer gate.lock.Unlock()
+	if gate.done.Swap(true) {
+		return
+	}
+	if gate.acquired {
+		<-gate.scheduler.slots
+		gate.acquired = false
+	}
+}
+
+// CombineStartGates returns the start gate waiting for all the gates in order, the nil gates are ignored.
+func CombineStartGates(gates ...StartGate) StartGate {
+	var combined startGates
+	for _, gate := range gates {
+		if gate != nil {
+			combined = append(combined, gate)
+		}
+	}
+	switch len(combined) {
+	case 0:
+		return nil
+	case 1:
+		return combined[0]
+	}
+	return combined
+}
+
+type startGates []StartGate
+
+func (gates startGates) Wait(ctx context.Context) error {
+	for _, gate := range gates {
+		if err := gate.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gates startGates) Done() {
+	for _, gate := range gates {
+		gate.Done()
+	}
+}
@@ -0,0 +1,113 @@
+package informer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestScheduleStarts(t *testing.T) {
+	gvr := func(resource string) schema.GroupVersionResource {
+		return schema.GroupVersionResource{Version: "v1", Resource: resource}
+	}
+	counts := map[schema.GroupVersionResource]int64{
+		gvr("configmaps"): 10,
+		gvr("services"):   100,
+		gvr("pods"):       50000,
+		gvr("events"):     200000,
+		gvr("secrets"):    5000,
+		gvr("endpoints"):  5000,
+	}
+
+	schedule := ScheduleStarts(counts, StartScheduleConfig{SmallCount: 100, Window: 4 * time.Minute})
+	assert.Equal(t, map[schema.GroupVersionResource]ScheduledStart{
+		gvr("configmaps"): {},
+		gvr("services"):   {},
+		// the large resources are ordered by the counts and then by the names
+		gvr("endpoints"): {Delay: 0, Large: true},
+		gvr("secrets"):   {Delay: time.Minute, Large: true},
+		gvr("pods"):      {Delay: 2 * time.Minute, Large: true},
+		gvr("events"):    {Delay: 3 * time.Minute, Large: true},
+	}, schedule)
+
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	scheduler := NewStartScheduler(fakeClock, counts, StartScheduleConfig{SmallCount: 100, Window: 4 * time.Minute})
+	assert.Nil(t, scheduler.Gate(gvr("configmaps")))
+	// the resources never stored aren't scheduled
+	assert.Nil(t, scheduler.Gate(gvr("nodes")))
+	// the first large resource is started immediately without the concurrency
+	assert.Nil(t, scheduler.Gate(gvr("endpoints")))
+	events := scheduler.Gate(gvr("events"))
+	require.NotNil(t, events)
+
+	// the gate is opened after the delay from the start of the schedule
+	fakeClock.Step(time.Minute)
+	waited := make(chan error, 1)
+	go func() { waited <- events.Wait(context.Background()) }()
+	assert.Eventually(t, fakeClock.HasWaiters, 5*time.Second, 10*time.Millisecond)
+	fakeClock.Step(time.Minute)
+	select {
+	case <-waited:
+		t.Fatal("the gate is opened before the delay")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fakeClock.Step(time.Minute)
+	select {
+	case err := <-waited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the gate isn't opened after the delay")
+	}
+}
+
+func TestStartSchedulerConcurrency(t *testing.T) {
+	counts := map[schema.GroupVersionResource]int64{
+		{Version: "v1", Resource: "configmaps"}: 10,
+		{Version: "v1", Resource: "pods"}:       1000,
+		{Version: "v1", Resource: "secrets"}:    1000,
+	}
+	scheduler := NewStartScheduler(clock.RealClock{}, counts, StartScheduleConfig{SmallCount: 100, Concurrency: 1})
+	assert.Nil(t, scheduler.Gate(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}))
+	pods := scheduler.Gate(schema.GroupVersionResource{Version: "v1", Resource: "pods"})
+	secrets := scheduler.Gate(schema.GroupVersionResource{Version: "v1", Resource: "secrets"})
+	require.NotNil(t, pods)
+	require.NotNil(t, secrets)
+
+	require.NoError(t, pods.Wait(context.Background()))
+	// the restarted Reflector holding the slot isn't blocked
+	require.NoError(t, pods.Wait(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, secrets.Wait(ctx), context.DeadlineExceeded)
+
+	waited := make(chan error, 1)
+	go func() { waited <- secrets.Wait(context.Background()) }()
+	pods.Done()
+	select {
+	case err := <-waited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot isn't released after the gate is done")
+	}
+	secrets.Done()
+
+	// the done gates aren't gated again
+	require.NoError(t, pods.Wait(context.Background()))
+	assert.Len(t, scheduler.slots, 0)
+}
+
+func TestCombineStartGates(t *testing.T) {
+	assert.Nil(t, CombineStartGates(nil, nil))
+
+	gates := NewPriorityStartGates(clock.RealClock{}, time.Minute)
+	gate := gates.Gate(0)
+	assert.Equal(t, gate, CombineStartGates(nil, gate))
+	assert.Len(t, CombineStartGates(gate, gates.Gate(1)), 2)
+}
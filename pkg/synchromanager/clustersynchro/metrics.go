@@ -0,0 +1,49 @@
+package clustersynchro
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/metrics"
+)
+
+const resourceSynchroSubsystem = "resource_synchro"
+
+var (
+	skippedDuplicateEvents = promauto.With(metrics.DefaultRegistry()).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "clusterpedia",
+			Subsystem: resourceSynchroSubsystem,
+			Name:      "skipped_duplicate_events_total",
+			Help:      "Number of the Modified events dropped because the resource version is unchanged.",
+		}, []string{"cluster", "resource"},
+	)
+
+	gvkMismatchDroppedEvents = promauto.With(metrics.DefaultRegistry()).NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "clusterpedia",
+			Subsystem: resourceSynchroSubsystem,
+			Name:      "gvk_mismatch_dropped_events_total",
+			Help:      "Number of the watch events dropped because the gvk of the object is different from the synced resource.",
+		}, []string{"cluster", "resource"},
+	)
+
+	storeDiscrepancy = promauto.With(metrics.DefaultRegistry()).NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "clusterpedia",
+			Subsystem: resourceSynchroSubsystem,
+			Name:      "store_discrepancy",
+			Help:      "Number of the stored resources minus the number of the synced resources, checked after the initial list is synced.",
+		}, []string{"cluster", "resource"},
+	)
+
+	watchDurations = promauto.With(metrics.DefaultRegistry()).NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "clusterpedia",
+			Subsystem: resourceSynchroSubsystem,
+			Name:      "watch_duration_seconds",
+			Help:      "Durations of the closed watches of the resources, measured by the local clock.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}, []string{"cluster", "resource"},
+	)
+)
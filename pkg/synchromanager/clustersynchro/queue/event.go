@@ -10,6 +10,8 @@ const (
 
 type Event struct {
 	reputCount int
+	// generation is the generation of the queue when the event is enqueued or merged.
+	generation uint64
 
 	Action ActionType
 	Object interface{}
@@ -19,6 +21,10 @@ func (event Event) GetReputCount() int {
 	return event.reputCount
 }
 
+func (event Event) GetGeneration() uint64 {
+	return event.generation
+}
+
 func pressureEvents(older *Event, newer *Event) *Event {
 	if newer == nil {
 		return older
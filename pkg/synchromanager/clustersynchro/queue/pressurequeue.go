@@ -32,6 +32,7 @@ type pressurequeue struct {
 	queue      []string
 	keyFunc    KeyFunc
 	closed     bool
+	generation uint64
 }
 
 func (q *pressurequeue) Add(obj interface{}) error {
@@ -60,7 +61,11 @@ func (q *pressurequeue) queueActionLocked(action ActionType, obj interface{}) er
 	if err != nil {
 		return err
 	}
-	q.put(key, pressureEvents(q.items[key], &Event{Action: action, Object: obj}))
+	event := pressureEvents(q.items[key], &Event{Action: action, Object: obj})
+	if event != nil {
+		event.generation = q.generation
+	}
+	q.put(key, event)
 	return nil
 }
 
@@ -170,6 +175,12 @@ func (q *pressurequeue) Len() int {
 	return len(q.queue)
 }
 
+func (q *pressurequeue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items) + q.processing.Len()
+}
+
 func (q *pressurequeue) DiscardAndRetain(retain int) bool {
 	q.lock.Lock()
 	defer q.lock.Unlock()
@@ -186,6 +197,18 @@ func (q *pressurequeue) DiscardAndRetain(retain int) bool {
 	return true
 }
 
+func (q *pressurequeue) IncGeneration() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.generation++
+}
+
+func (q *pressurequeue) Generation() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.generation
+}
+
 func (q *pressurequeue) Close() {
 	q.lock.Lock()
 	defer q.lock.Unlock()
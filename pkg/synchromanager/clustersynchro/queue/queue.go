@@ -13,7 +13,14 @@ type EventQueue interface {
 	Done(event *Event) error
 
 	Len() int
+	// Pending returns the number of the events in the queue and being processed.
+	Pending() int
 	DiscardAndRetain(retain int) bool
 
+	// IncGeneration starts a new generation of the events, it is called when the store of the informer is replaced,
+	// the writer compares the generation of the event with Generation to find the events enqueued before the replacement.
+	IncGeneration()
+	Generation() uint64
+
 	Close()
 }
@@ -3,17 +3,18 @@ package clustersynchro
 import (
 	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"go.uber.org/atomic"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
-	genericstorage "k8s.io/apiserver/pkg/storage"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
 	metricsstore "k8s.io/kube-state-metrics/v2/pkg/metrics_store"
@@ -35,11 +36,40 @@ type ResourceSynchroConfig struct {
 	cache.ListerWatcher
 	runtime.ObjectConvertor
 	storage.ResourceStorage
+	// CheckpointStore persists the watch progress, it is nil if the storage does not support it.
+	storage.CheckpointStore
 
 	*kubestatemetrics.MetricsStore
 
-	ResourceVersions    map[string]interface{}
-	PageSizeForInformer int64
+	ResourceVersions       map[string]interface{}
+	PageSizeForInformer    int64
+	MinPageSizeForInformer int64
+	MaxPageSizeForInformer int64
+
+	// ForceRelistOnly syncs the resource by the periodic relist instead of the watch
+	ForceRelistOnly bool
+
+	// StartGate delays the first start of the informer by the priority of the resource, it can be nil.
+	StartGate informer.StartGate
+
+	// GVKMismatchPolicy handles the watch events of the other versions, e.g. the storage version of the CRD is changed.
+	GVKMismatchPolicy informer.GVKMismatchPolicy
+
+	// MaxConsecutiveForbidden stops the informer after the number of consecutive forbidden errors, 0 means never stop.
+	MaxConsecutiveForbidden int
+
+	// ShortWatchThreshold is the duration under which the watch closed without any events is unexpected, 0 means the default.
+	ShortWatchThreshold time.Duration
+
+	// ClusterLease returns the lease of the cluster held by the synchro, the resources are written with the lease.
+	// It can be nil if the lease is disabled.
+	ClusterLease func() (storage.ClusterLease, bool)
+
+	// InitializedSyncs registers the informer of the resource, it can be nil.
+	InitializedSyncs *informer.InitializedSyncs
+
+	// StoreConsistencyChecker verifies the stored resources after the initial list is synced, it can be nil.
+	StoreConsistencyChecker *StoreConsistencyChecker
 }
 
 func (c ResourceSynchroConfig) GroupVersionKind() schema.GroupVersionKind {
@@ -54,10 +84,37 @@ type ResourceSynchro struct {
 	storageResource schema.GroupVersionResource
 
 	pageSize          int64
+	minPageSize       int64
+	maxPageSize       int64
+	forceRelistOnly   bool
+	startGate         informer.StartGate
+	gvkMismatchPolicy informer.GVKMismatchPolicy
 	listerWatcher     cache.ListerWatcher
 	metricsExtraStore informer.ExtraStore
 	metricsWriter     *metricsstore.MetricsWriter
 
+	// maxConsecutiveForbidden stops the informer after the consecutive forbidden errors,
+	// the informer is restarted after the forbiddenRestartPeriod to check the permissions again.
+	maxConsecutiveForbidden int
+	stoppedByForbidden      *atomic.Bool
+
+	shortWatchThreshold time.Duration
+
+	// initializedSync is the registration of the informer in the InitializedSyncs of the manager,
+	// it is uninitialized until the initial list of the running informer is synced.
+	initializedSync *informer.InitializedSync
+
+	// consistencyChecking guards the consistency check, lastConsistencyCheck and consistencyRelists
+	// are only accessed by the running check.
+	consistencyChecker   *StoreConsistencyChecker
+	consistencyChecking  *atomic.Bool
+	lastConsistencyCheck time.Time
+	consistencyRelists   int
+	// relistRequested forces the next informer to relist the resources instead of watching from the storage.
+	relistRequested *atomic.Bool
+	// relistInformer stops the running informer to relist the resources by the next one, it is guarded by the rvsLock.
+	relistInformer func()
+
 	queue   queue.EventQueue
 	cache   *informer.ResourceVersionStorage
 	rvs     map[string]interface{}
@@ -67,7 +124,16 @@ type ResourceSynchro struct {
 	storage       storage.ResourceStorage
 	convertor     runtime.ObjectConvertor
 
+	checkpointStore storage.CheckpointStore
+	checkpointing   *atomic.Bool
+	clusterLease    func() (storage.ClusterLease, bool)
+	// storageWriteFailed is true if some events are failed to be written to the storage,
+	// the watch progress is not persisted until the resources are relisted.
+	storageWriteFailed *atomic.Bool
+
 	status atomic.Value // clusterv1alpha2.ClusterResourceSyncCondition
+	// listProgress is the progress of the paginated list of the informer, it is included in the message of the status.
+	listProgress atomic.Value // informer.ListProgress
 
 	startlock sync.Mutex
 	stopped   chan struct{}
@@ -95,9 +161,24 @@ func newResourceSynchro(cluster string, config ResourceSynchroConfig) *ResourceS
 		syncResource:    config.GroupVersionResource,
 		storageResource: storageConfig.StorageGroupResource.WithVersion(storageConfig.StorageVersion.Version),
 
-		pageSize:      config.PageSizeForInformer,
-		listerWatcher: config.ListerWatcher,
-		rvs:           config.ResourceVersions,
+		pageSize:    config.PageSizeForInformer,
+		minPageSize: config.MinPageSizeForInformer,
+		maxPageSize: config.MaxPageSizeForInformer,
+
+		forceRelistOnly: config.ForceRelistOnly,
+		listerWatcher:   config.ListerWatcher,
+		rvs:             config.ResourceVersions,
+
+		startGate:         config.StartGate,
+		gvkMismatchPolicy: config.GVKMismatchPolicy,
+
+		maxConsecutiveForbidden: config.MaxConsecutiveForbidden,
+		stoppedByForbidden:      atomic.NewBool(false),
+		shortWatchThreshold:     config.ShortWatchThreshold,
+
+		consistencyChecker:  config.StoreConsistencyChecker,
+		consistencyChecking: atomic.NewBool(false),
+		relistRequested:     atomic.NewBool(false),
 
 		// all resources saved to the queue are `runtime.Object`
 		queue: queue.NewPressureQueue(cache.MetaNamespaceKeyFunc),
@@ -106,6 +187,11 @@ func newResourceSynchro(cluster string, config ResourceSynchroConfig) *ResourceS
 		convertor:     config.ObjectConvertor,
 		memoryVersion: storageConfig.MemoryVersion,
 
+		checkpointStore:    config.CheckpointStore,
+		checkpointing:      atomic.NewBool(false),
+		clusterLease:       config.ClusterLease,
+		storageWriteFailed: atomic.NewBool(false),
+
 		stopped:              make(chan struct{}),
 		isRunnableForStorage: atomic.NewBool(true),
 		runnableForStorage:   make(chan struct{}),
@@ -116,6 +202,7 @@ func newResourceSynchro(cluster string, config ResourceSynchroConfig) *ResourceS
 	}
 	close(synchro.runnableForStorage)
 	synchro.ctx, synchro.cancel = context.WithCancel(context.Background())
+	synchro.initializedSync = config.InitializedSyncs.Register(cluster, synchro.storageResource.String())
 
 	example := &unstructured.Unstructured{}
 	example.SetGroupVersionKind(config.GroupVersionKind())
@@ -154,10 +241,47 @@ func (synchro *ResourceSynchro) Run(shutdown <-chan struct{}) {
 	<-synchro.stopped
 	synchro.startlock.Unlock()
 
+	synchro.closeStorage()
+	synchro.initializedSync.Unregister()
+	synchro.deleteMetrics()
+
+	// release the resources of the lower priorities if the informer has never been started
+	if synchro.startGate != nil {
+		synchro.startGate.Done()
+	}
+
 	synchro.setStatus(clusterv1alpha2.ResourceSyncStatusStop, "", "")
 	synchro.runningStage = "shutdown"
 }
 
+// storageCloseTimeout is the timeout of flushing the writes accepted by the storage which writes the objects asynchronously.
+const storageCloseTimeout = time.Minute
+
+// closeStorage flushes the writes accepted by the storage when the synchro is shut down,
+// so the cleaning of the resources isn't followed by the pending writes.
+func (synchro *ResourceSynchro) closeStorage() {
+	closer, ok := synchro.storage.(storage.ResourceStorageCloser)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageCloseTimeout)
+	defer cancel()
+	if err := closer.Close(ctx); err != nil {
+		klog.ErrorS(err, "Failed to close the resource storage", "cluster", synchro.cluster, "gvr", synchro.storageResource)
+	}
+}
+
+// deleteMetrics deletes the metrics of the resource when the synchro is shut down,
+// so the series of the removed clusters and resources aren't exported forever.
+func (synchro *ResourceSynchro) deleteMetrics() {
+	resource := synchro.syncResource.String()
+	skippedDuplicateEvents.DeleteLabelValues(synchro.cluster, resource)
+	gvkMismatchDroppedEvents.DeleteLabelValues(synchro.cluster, resource)
+	storeDiscrepancy.DeleteLabelValues(synchro.cluster, resource)
+	watchDurations.DeleteLabelValues(synchro.cluster, resource)
+}
+
 func (synchro *ResourceSynchro) Close() <-chan struct{} {
 	synchro.closeOnce.Do(func() {
 		close(synchro.closer)
@@ -238,28 +362,27 @@ func (synchro *ResourceSynchro) Start(stopCh <-chan struct{}) {
 		default:
 		}
 
-		informerStopCh := make(chan struct{})
+		informerStopCh, informerDone, relistCh := make(chan struct{}), make(chan struct{}), make(chan struct{})
 		go func() {
 			select {
 			case <-stopCh:
 			case <-synchro.closer:
 			case <-stopForStorage:
+			case <-informerDone:
+			case <-relistCh:
 			}
 			close(informerStopCh)
 		}()
 
-		synchro.rvsLock.Lock()
-		if synchro.cache == nil {
-			rvs := make(map[string]interface{}, len(synchro.rvs))
-			for r, v := range synchro.rvs {
-				rvs[r] = v
-			}
-			synchro.cache = informer.NewResourceVersionStorage()
-			synchro.rvsLock.Unlock()
+		warmStorage := synchro.initCache()
 
-			_ = synchro.cache.Replace(rvs)
-		} else {
-			synchro.rvsLock.Unlock()
+		var initialResourceVersion string
+		if !synchro.relistRequested.Swap(false) && warmStorage && clusterpediafeature.FeatureGate.Enabled(features.SkipInitialListForResourceSync) {
+			initialResourceVersion = synchro.latestResourceVersionInStorage()
+		}
+		if initialResourceVersion == "" {
+			// the informer relists the resources, the failed writes will be corrected
+			synchro.storageWriteFailed.Store(false)
 		}
 
 		config := informer.InformerConfig{
@@ -270,22 +393,196 @@ func (synchro *ResourceSynchro) Start(stopCh <-chan struct{}) {
 			ErrorHandler:      synchro.ErrorHandler,
 			ExtraStore:        synchro.metricsExtraStore,
 			WatchListPageSize: synchro.pageSize,
+			ForceRelistOnly:   synchro.forceRelistOnly,
+			StartGate:         synchro.startGate,
+
+			GVKMismatchPolicy:  synchro.gvkMismatchPolicy,
+			GVKMismatchCounter: gvkMismatchDroppedEvents.WithLabelValues(synchro.cluster, synchro.syncResource.String()),
+
+			MaxConsecutiveForbidden: synchro.maxConsecutiveForbidden,
+
+			ShortWatchThreshold:   synchro.shortWatchThreshold,
+			WatchDurationObserver: watchDurations.WithLabelValues(synchro.cluster, synchro.syncResource.String()),
+
+			InitialResourceVersion: initialResourceVersion,
+			WrapQueue:              synchro.wrapInformerQueue,
+		}
+		// the restarted informer is uninitialized until its initial list is synced again
+		synchro.initializedSync.SetInitialized(false)
+		var relistOnce sync.Once
+		relist := func() { relistOnce.Do(func() { close(relistCh) }) }
+		synchro.rvsLock.Lock()
+		synchro.relistInformer = relist
+		synchro.rvsLock.Unlock()
+		config.InitializedHandler = func() {
+			synchro.initializedSync.SetInitialized(true)
+			synchro.verifyStoreConsistency(informerStopCh, relist)
+		}
+		if synchro.checkpointStore != nil && clusterpediafeature.FeatureGate.Enabled(features.SkipInitialListForResourceSync) {
+			config.Checkpoint = synchro.checkpoint
 		}
 		if clusterpediafeature.FeatureGate.Enabled(features.StreamHandlePaginatedListForResourceSync) {
 			config.StreamHandleForPaginatedList = true
+			config.ListProgressHandler = synchro.setListProgress
 		}
 		if clusterpediafeature.FeatureGate.Enabled(features.ForcePaginatedListForResourceSync) {
 			config.ForcePaginatedList = true
 		}
+		if clusterpediafeature.FeatureGate.Enabled(features.AdaptivePageSizeForResourceSync) {
+			config.AdaptivePageSize = true
+			config.MinWatchListPageSize = synchro.minPageSize
+			config.MaxWatchListPageSize = synchro.maxPageSize
+		}
+		if clusterpediafeature.FeatureGate.Enabled(features.DeduplicateModifiedEventsForResourceSync) {
+			config.DedupModifiedCacheSize = defaultDedupModifiedCacheSize
+			config.DedupSkippedCounter = skippedDuplicateEvents.WithLabelValues(synchro.cluster, synchro.syncResource.String())
+		}
 		informer.NewResourceVersionInformer(synchro.cluster, config).Run(informerStopCh)
+		close(informerDone)
 
 		// TODO(Iceber): Optimize status updates in case of storage exceptions
 		if !synchro.isRunnableForStorage.Load() {
 			synchro.setStatus(clusterv1alpha2.ResourceSyncStatusStop, "StorageExpection", "")
 		}
+
+		if synchro.stoppedByForbidden.Swap(false) {
+			// the informer is stopped by the consecutive forbidden errors,
+			// restart it after a while to recover automatically if the permissions are granted again.
+			timer := time.NewTimer(forbiddenRestartPeriod)
+			select {
+			case <-stopCh:
+				timer.Stop()
+				synchro.setStatus(clusterv1alpha2.ResourceSyncStatusStop, "Pause", "")
+				return
+			case <-synchro.closer:
+				timer.Stop()
+				return
+			case <-stopForStorage:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// initCache initializes the cache of the informer by the resource versions written to the storage if the cache is nil,
+// it returns true if the storage is warm.
+func (synchro *ResourceSynchro) initCache() bool {
+	synchro.rvsLock.Lock()
+	if synchro.cache != nil {
+		synchro.rvsLock.Unlock()
+		return false
+	}
+
+	warmStorage := len(synchro.rvs) != 0
+	rvs := make(map[string]interface{}, len(synchro.rvs))
+	for r, v := range synchro.rvs {
+		rvs[r] = v
+	}
+	synchro.rvsLock.Unlock()
+
+	versions := informer.NewResourceVersionStorage()
+	_ = versions.Replace(rvs)
+	synchro.rvsLock.Lock()
+	synchro.cache = versions
+	synchro.rvsLock.Unlock()
+
+	// the keys not written to the storage yet are removed from the rebuilt cache,
+	// the in-flight and queued writes of them are stale.
+	synchro.queue.IncGeneration()
+	return warmStorage
+}
+
+// relistFromScratch replaces the resource versions written to the storage by the rvs loaded from the storage, and relists
+// the resources by the restarted informer without the initial resource version, e.g. the stored resources are lost or replaced.
+// The resources missing in the storage are rewritten, and the stored resources deleted in the cluster are deleted by the relist.
+func (synchro *ResourceSynchro) relistFromScratch(rvs map[string]interface{}) {
+	synchro.rvsLock.Lock()
+	for key := range synchro.rvs {
+		delete(synchro.rvs, key)
+	}
+	for key, rv := range rvs {
+		synchro.rvs[key] = rv
+	}
+	synchro.cache = nil
+	relist := synchro.relistInformer
+	synchro.rvsLock.Unlock()
+
+	synchro.relistRequested.Store(true)
+	if relist != nil {
+		relist()
+	}
+}
+
+// latestResourceVersionInStorage returns the checkpoint of the watch progress or the latest resource version
+// of the resources in the storage, the informer watches from it to skip the initial list when the storage is already warm.
+func (synchro *ResourceSynchro) latestResourceVersionInStorage() string {
+	if synchro.checkpointStore != nil {
+		rv, err := synchro.checkpointStore.Load(synchro.ctx, synchro.cluster, synchro.storageResource)
+		if err != nil {
+			klog.ErrorS(err, "Failed to load the checkpoint from storage", "cluster", synchro.cluster, "gvr", synchro.storageResource)
+		} else if rv != "" {
+			return rv
+		}
 	}
+
+	getter, ok := synchro.storage.(storage.LatestResourceVersionGetter)
+	if !ok {
+		return ""
+	}
+	rv, err := getter.GetLatestResourceVersion(synchro.ctx, synchro.cluster)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get the latest resource version from storage, fall back to the initial list",
+			"cluster", synchro.cluster, "gvr", synchro.storageResource)
+		return ""
+	}
+	return rv
 }
 
+// checkpoint persists the resource version of the bookmark event as the watch progress,
+// only if all the events before the bookmark have been written to the storage. The writes accepted by
+// the storage which writes the objects asynchronously are flushed first, and the dropped writes fail
+// the checkpoints until the resources are relisted.
+func (synchro *ResourceSynchro) checkpoint(resourceVersion string) {
+	if !synchro.isRunnableForStorage.Load() || synchro.storageWriteFailed.Load() || synchro.queue.Pending() != 0 {
+		return
+	}
+	if !synchro.checkpointing.CompareAndSwap(false, true) {
+		return
+	}
+
+	// avoid blocking the watch loop of the reflector
+	go func() {
+		defer synchro.checkpointing.Store(false)
+
+		ctx, cancel := context.WithTimeout(synchro.ctx, 30*time.Second)
+		defer cancel()
+		if closer, ok := synchro.storage.(storage.ResourceStorageCloser); ok {
+			if err := closer.Flush(ctx); err != nil {
+				if errors.Is(err, storage.ErrWriteDropped) {
+					synchro.storageWriteFailed.Store(true)
+				}
+				klog.ErrorS(err, "Failed to flush the resource storage, skip the checkpoint", "cluster", synchro.cluster,
+					"gvr", synchro.storageResource, "resourceVersion", resourceVersion)
+				return
+			}
+		}
+		if synchro.storageWriteFailed.Load() {
+			return
+		}
+		if err := synchro.checkpointStore.Save(ctx, synchro.cluster, synchro.storageResource, resourceVersion); err != nil {
+			klog.ErrorS(err, "Failed to save the checkpoint", "cluster", synchro.cluster,
+				"gvr", synchro.storageResource, "resourceVersion", resourceVersion)
+		}
+	}()
+}
+
+// forbiddenRestartPeriod is the interval to restart the informer stopped by the consecutive forbidden errors.
+var forbiddenRestartPeriod = 30 * time.Minute
+
+// defaultDedupModifiedCacheSize is the number of the keys tracked by the deduplication of Modified events.
+const defaultDedupModifiedCacheSize = 10000
+
 const LastAppliedConfigurationAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
 
 func (synchro *ResourceSynchro) pruneObject(obj *unstructured.Unstructured) {
@@ -381,6 +678,17 @@ func (synchro *ResourceSynchro) processResources() {
 	}
 }
 
+// withClusterLease returns the context carrying the lease of the cluster, the storage fences the writes by it.
+func (synchro *ResourceSynchro) withClusterLease(ctx context.Context) context.Context {
+	if synchro.clusterLease == nil {
+		return ctx
+	}
+	if lease, ok := synchro.clusterLease(); ok {
+		return storage.WithClusterLease(ctx, lease)
+	}
+	return ctx
+}
+
 func (synchro *ResourceSynchro) handleResourceEvent(event *queue.Event) {
 	defer func() { _ = synchro.queue.Done(event) }()
 
@@ -423,12 +731,26 @@ func (synchro *ResourceSynchro) handleResourceEvent(event *queue.Event) {
 
 	// TODO(Iceber): put the event back into the queue to retry?
 	for i := 0; ; i++ {
-		ctx, cancel := context.WithTimeout(synchro.ctx, 30*time.Second)
+		if event.Action != queue.Deleted && synchro.isRemovedFromInformerStore(event, key) {
+			klog.V(4).InfoS("Skip the stale event of the resource removed by the relist", "cluster", synchro.cluster,
+				"action", event.Action, "resource", synchro.storageResource, "key", key)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(synchro.withClusterLease(synchro.ctx), 30*time.Second)
 		err := handler(ctx, obj)
 		cancel()
 		if err == nil {
 			callback(obj)
 
+			// the key is removed by the relist while the object is written to the storage,
+			// delete the resurrected resource after this event.
+			if event.Action != queue.Deleted && synchro.isRemovedFromInformerStore(event, key) {
+				if deleted, err := synchro.storage.ConvertDeletedObject(event.Object); err == nil {
+					_ = synchro.queue.Delete(deleted)
+				}
+			}
+
 			if !synchro.isRunnableForStorage.Load() && synchro.queue.Len() == 0 {
 				// Start the informer after processing the data in the queue to ensure that storage is up and running for a period of time.
 				synchro.setRunnableForStorage()
@@ -439,9 +761,12 @@ func (synchro *ResourceSynchro) handleResourceEvent(event *queue.Event) {
 		if errors.Is(err, context.Canceled) {
 			return
 		}
-		if !storage.IsRecoverableException(err) {
+		// the transient errors, e.g. the lost connection, are retried, the others are dropped.
+		// The fenced writes are retried until the lease of the cluster is acquired again.
+		if !errors.Is(err, storage.ErrTransient) && !errors.Is(err, storage.ErrFenced) {
 			klog.ErrorS(err, "Failed to storage resource", "cluster", synchro.cluster,
 				"action", event.Action, "resource", synchro.storageResource, "key", key)
+			synchro.storageWriteFailed.Store(true)
 
 			if !synchro.isRunnableForStorage.Load() && synchro.queue.Len() == 0 {
 				// if the storage returns an error on stopForStorage that cannot be recovered
@@ -461,6 +786,7 @@ func (synchro *ResourceSynchro) handleResourceEvent(event *queue.Event) {
 				synchro.setStopForStorage()
 			}
 			synchro.queue.DiscardAndRetain(retainInQueue)
+			synchro.storageWriteFailed.Store(true)
 
 			// If the data in the queue is discarded,
 			// the data in the cache will be inconsistent with the data in the `rvs`,
@@ -476,6 +802,39 @@ func (synchro *ResourceSynchro) handleResourceEvent(event *queue.Event) {
 	}
 }
 
+// wrapInformerQueue starts a new generation of the events when the store of the informer is replaced by the relist.
+func (synchro *ResourceSynchro) wrapInformerQueue(q cache.Queue) cache.Queue {
+	return &generationQueue{Queue: q, events: synchro.queue}
+}
+
+type generationQueue struct {
+	cache.Queue
+	events queue.EventQueue
+}
+
+func (q *generationQueue) Replace(list []interface{}, resourceVersion string) error {
+	q.events.IncGeneration()
+	return q.Queue.Replace(list, resourceVersion)
+}
+
+// isRemovedFromInformerStore returns true if the store of the informer is replaced after the event is enqueued,
+// and the key of the event is removed from the store by the replacement, that is, the event is stale.
+// The removed keys are usually deleted by the subsequent Deleted events, but the Deleted events are missing
+// if the events are discarded by the queue or the cache of the store is rebuilt.
+func (synchro *ResourceSynchro) isRemovedFromInformerStore(event *queue.Event, key string) bool {
+	if event.GetGeneration() == synchro.queue.Generation() {
+		return false
+	}
+
+	synchro.rvsLock.Lock()
+	defer synchro.rvsLock.Unlock()
+	if synchro.cache == nil {
+		return false
+	}
+	_, exists, _ := synchro.cache.GetByKey(key)
+	return !exists
+}
+
 func (synchro *ResourceSynchro) setRunnableForStorage() {
 	synchro.isRunnableForStorage.Store(true)
 
@@ -537,7 +896,7 @@ func (synchro *ResourceSynchro) convertToStorageVersion(obj runtime.Object) (run
 
 func (synchro *ResourceSynchro) createOrUpdateResource(ctx context.Context, obj runtime.Object) error {
 	err := synchro.storage.Create(ctx, synchro.cluster, obj)
-	if genericstorage.IsExist(err) {
+	if errors.Is(err, storage.ErrConflict) {
 		return synchro.storage.Update(ctx, synchro.cluster, obj)
 	}
 	return err
@@ -545,7 +904,7 @@ func (synchro *ResourceSynchro) createOrUpdateResource(ctx context.Context, obj
 
 func (synchro *ResourceSynchro) updateOrCreateResource(ctx context.Context, obj runtime.Object) error {
 	err := synchro.storage.Update(ctx, synchro.cluster, obj)
-	if genericstorage.IsNotFound(err) {
+	if errors.Is(err, storage.ErrNotFound) {
 		return synchro.storage.Create(ctx, synchro.cluster, obj)
 	}
 	return err
@@ -565,10 +924,42 @@ func (synchro *ResourceSynchro) setStatus(status string, reason, message string)
 }
 
 func (synchro *ResourceSynchro) Status() clusterv1alpha2.ClusterResourceSyncCondition {
-	return synchro.status.Load().(clusterv1alpha2.ClusterResourceSyncCondition)
+	status := synchro.status.Load().(clusterv1alpha2.ClusterResourceSyncCondition)
+	if progress, ok := synchro.listProgress.Load().(informer.ListProgress); ok && progress.Listing {
+		status.Message = listProgressMessage(status.Message, progress)
+	}
+	return status
+}
+
+func (synchro *ResourceSynchro) setListProgress(progress informer.ListProgress) {
+	synchro.listProgress.Store(progress)
+}
+
+// listProgressMessage appends the progress of the list to the message, e.g. "synced 120000/300000".
+func listProgressMessage(message string, progress informer.ListProgress) string {
+	synced := fmt.Sprintf("synced %d", progress.Items)
+	if progress.TotalKnown {
+		synced = fmt.Sprintf("synced %d/%d", progress.Items, progress.Total)
+	}
+	if message == "" {
+		return synced
+	}
+	return message + "; " + synced
 }
 
 func (synchro *ResourceSynchro) ErrorHandler(r *informer.Reflector, err error) {
+	if apierrors.IsForbidden(err) {
+		status := r.Status()
+		if status.StoppedByForbidden {
+			synchro.stoppedByForbidden.Store(true)
+			synchro.setStatus(clusterv1alpha2.ResourceSyncStatusStop, "Forbidden",
+				fmt.Sprintf("Forbidden — check RBAC, stopped after %d consecutive forbidden errors: %v", status.ConsecutiveForbidden, err))
+			return
+		}
+		synchro.setStatus(clusterv1alpha2.ResourceSyncStatusError, "Forbidden", "Forbidden — check RBAC: "+err.Error())
+		return
+	}
+
 	if err != nil {
 		// TODO(iceber): Use `k8s.io/apimachinery/pkg/api/errors` to resolve the error type and update it to `status.Reason`
 		synchro.setStatus(clusterv1alpha2.ResourceSyncStatusError, "ResourceWatchFailed", err.Error())
@@ -576,10 +967,16 @@ func (synchro *ResourceSynchro) ErrorHandler(r *informer.Reflector, err error) {
 		return
 	}
 
+	// the resource doesn't support watch, it is synced by the periodic relist
+	var reason, message string
+	if r.Status().RelistOnly {
+		reason, message = "SyncingByRelist", "the resource is synced by periodic relist instead of watch"
+	}
+
 	// `reflector` sets a default timeout when watching,
 	// then when re-watching the error handler is called again and the `err` is nil.
 	// if the current status is Syncing, then the status is not updated to avoid triggering a cluster status update
-	if status := synchro.Status(); status.Status != clusterv1alpha2.ResourceSyncStatusSyncing {
-		synchro.setStatus(clusterv1alpha2.ResourceSyncStatusSyncing, "", "")
+	if status := synchro.Status(); status.Status != clusterv1alpha2.ResourceSyncStatusSyncing || status.Reason != reason {
+		synchro.setStatus(clusterv1alpha2.ResourceSyncStatusSyncing, reason, message)
 	}
 }
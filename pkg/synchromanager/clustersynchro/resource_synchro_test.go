@@ -0,0 +1,359 @@
+package clustersynchro
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+// fakeResourceStorage records the resource versions of the written objects,
+// and the writes of the blocked keys wait until they are released.
+type fakeResourceStorage struct {
+	storage.ResourceStorage
+	config *storage.ResourceStorageConfig
+
+	lock    sync.Mutex
+	objects map[string]string
+	blocked map[string]chan struct{}
+	writing chan string
+}
+
+func newFakeResourceStorage(gvr schema.GroupVersionResource) *fakeResourceStorage {
+	return &fakeResourceStorage{
+		config: &storage.ResourceStorageConfig{
+			Namespaced:           true,
+			GroupResource:        gvr.GroupResource(),
+			StorageGroupResource: gvr.GroupResource(),
+			MemoryVersion:        gvr.GroupVersion(),
+			StorageVersion:       gvr.GroupVersion(),
+		},
+		objects: make(map[string]string),
+		blocked: make(map[string]chan struct{}),
+		writing: make(chan string, 10),
+	}
+}
+
+func (s *fakeResourceStorage) GetStorageConfig() *storage.ResourceStorageConfig {
+	return s.config
+}
+
+func (s *fakeResourceStorage) block(key string) (release func()) {
+	ch := make(chan struct{})
+	s.lock.Lock()
+	s.blocked[key] = ch
+	s.lock.Unlock()
+	return func() { close(ch) }
+}
+
+func (s *fakeResourceStorage) write(obj runtime.Object) error {
+	key, _ := cache.MetaNamespaceKeyFunc(obj)
+	s.lock.Lock()
+	blocked := s.blocked[key]
+	s.lock.Unlock()
+	if blocked != nil {
+		s.writing <- key
+		<-blocked
+	}
+
+	accessor, _ := meta.Accessor(obj)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[key] = accessor.GetResourceVersion()
+	return nil
+}
+
+func (s *fakeResourceStorage) Create(_ context.Context, _ string, obj runtime.Object) error {
+	return s.write(obj)
+}
+
+func (s *fakeResourceStorage) Update(_ context.Context, _ string, obj runtime.Object) error {
+	return s.write(obj)
+}
+
+func (s *fakeResourceStorage) ConvertDeletedObject(obj interface{}) (runtime.Object, error) {
+	return obj.(runtime.Object), nil
+}
+
+func (s *fakeResourceStorage) Delete(_ context.Context, _ string, obj runtime.Object) error {
+	key, _ := cache.MetaNamespaceKeyFunc(obj)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *fakeResourceStorage) CountResources(_ context.Context, _ string) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return int64(len(s.objects)), nil
+}
+
+func (s *fakeResourceStorage) stored() map[string]string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	objects := make(map[string]string, len(s.objects))
+	for key, rv := range s.objects {
+		objects[key] = rv
+	}
+	return objects
+}
+
+func newTestDeployment(name, rv string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("apps/v1")
+	obj.SetKind("Deployment")
+	obj.SetNamespace("default")
+	obj.SetName(name)
+	obj.SetResourceVersion(rv)
+	return obj
+}
+
+func TestResourceSynchroStaleWritesAfterReplace(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	resourceStorage := newFakeResourceStorage(gvr)
+	resourceStorage.objects["default/b"] = "1"
+
+	synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+		GroupVersionResource: gvr,
+		Kind:                 "Deployment",
+		ResourceStorage:      resourceStorage,
+		ResourceVersions:     map[string]interface{}{"default/b": "1"},
+	})
+	defer synchro.Close()
+	synchro.initCache()
+
+	// the informer adds a, and the write of a is in flight
+	release := resourceStorage.block("default/a")
+	a := newTestDeployment("a", "2")
+	assert.NoError(t, synchro.cache.Add(a))
+	synchro.OnAdd(a, false)
+	go synchro.processResources()
+	assert.Equal(t, "default/a", <-resourceStorage.writing)
+
+	// the update of b and the addition of c are queued after a
+	b := newTestDeployment("b", "3")
+	assert.NoError(t, synchro.cache.Update(b))
+	synchro.OnUpdate(nil, b)
+	c := newTestDeployment("c", "3")
+	assert.NoError(t, synchro.cache.Add(c))
+	synchro.OnAdd(c, false)
+
+	// the cache is rebuilt by the resource versions in the storage, e.g. after the queued events are discarded,
+	// and then the relist replaces the store, a and c are deleted in the cluster and no Deleted events are enqueued for them.
+	synchro.rvsLock.Lock()
+	synchro.cache = nil
+	synchro.rvsLock.Unlock()
+	synchro.initCache()
+
+	fifo := cache.NewDeltaFIFOWithOptions(cache.DeltaFIFOOptions{
+		KnownObjects:          synchro.cache,
+		EmitDeltaTypeReplaced: true,
+	})
+	b = newTestDeployment("b", "4")
+	assert.NoError(t, synchro.wrapInformerQueue(fifo).Replace([]interface{}{b}, "4"))
+	_, err := fifo.Pop(func(obj interface{}, _ bool) error {
+		for _, delta := range obj.(cache.Deltas) {
+			assert.Equal(t, cache.Replaced, delta.Type)
+			assert.NoError(t, synchro.cache.Update(delta.Object))
+			synchro.OnUpdate(nil, delta.Object)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// the queued write of c is dropped, and the resurrected a is deleted after the in-flight write
+	release()
+	assert.Eventually(t, func() bool {
+		return synchro.queue.Pending() == 0 && len(resourceStorage.stored()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, map[string]string{"default/b": "4"}, resourceStorage.stored())
+	assert.Equal(t, []string{"default/b"}, synchro.cache.ListKeys())
+}
+
+func TestResourceSynchroVerifyStoreConsistency(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	resourceStorage := newFakeResourceStorage(gvr)
+	resourceStorage.objects["default/a"] = "1"
+
+	synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+		GroupVersionResource:    gvr,
+		Kind:                    "Deployment",
+		ResourceStorage:         resourceStorage,
+		ResourceVersions:        map[string]interface{}{"default/a": "1"},
+		StoreConsistencyChecker: NewStoreConsistencyChecker(0, 1, 100),
+	})
+	defer synchro.Close()
+	synchro.initCache()
+
+	// the writes of b and c are failed and dropped
+	assert.NoError(t, synchro.cache.Add(newTestDeployment("b", "2")))
+	assert.NoError(t, synchro.cache.Add(newTestDeployment("c", "3")))
+	relisted := make(chan struct{})
+	synchro.verifyStoreConsistency(make(chan struct{}), func() { close(relisted) })
+	select {
+	case <-relisted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource is not relisted")
+	}
+	assert.Eventually(t, func() bool { return !synchro.consistencyChecking.Load() }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, synchro.relistRequested.Load())
+
+	// the cache is rebuilt by the written resources, the discrepancy within the threshold is only reported
+	synchro.initCache()
+	assert.Equal(t, []string{"default/a"}, synchro.cache.ListKeys())
+	assert.NoError(t, synchro.cache.Add(newTestDeployment("b", "2")))
+	synchro.verifyStoreConsistency(make(chan struct{}), func() { t.Error("the resource is relisted") })
+	assert.Eventually(t, func() bool { return !synchro.consistencyChecking.Load() }, 5*time.Second, 10*time.Millisecond)
+	assert.Zero(t, synchro.consistencyRelists)
+}
+
+func TestResourceSynchroRelistFromScratch(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	resourceStorage := newFakeResourceStorage(gvr)
+	rvs := map[string]interface{}{"default/a": "1", "default/b": "2"}
+
+	synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+		GroupVersionResource: gvr,
+		Kind:                 "Deployment",
+		ResourceStorage:      resourceStorage,
+		ResourceVersions:     rvs,
+	})
+	defer synchro.Close()
+	synchro.initCache()
+
+	relisted := make(chan struct{})
+	synchro.rvsLock.Lock()
+	synchro.relistInformer = func() { close(relisted) }
+	synchro.rvsLock.Unlock()
+
+	// the stored resources are restored from a backup with only the old version of a
+	synchro.relistFromScratch(map[string]interface{}{"default/a": "0"})
+	select {
+	case <-relisted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the informer is not relisted")
+	}
+	assert.True(t, synchro.relistRequested.Load())
+	// the resource versions shared with the cluster synchro are replaced in place
+	assert.Equal(t, map[string]interface{}{"default/a": "0"}, rvs)
+
+	// the cache is rebuilt by the reloaded resource versions, so the relist rewrites a and b
+	assert.True(t, synchro.initCache())
+	assert.Equal(t, []string{"default/a"}, synchro.cache.ListKeys())
+}
+
+// flushingResourceStorage writes the objects asynchronously, the flush returns the error of the dropped writes.
+type flushingResourceStorage struct {
+	*fakeResourceStorage
+	flushErr error
+}
+
+func (s *flushingResourceStorage) Flush(context.Context) error { return s.flushErr }
+
+func (s *flushingResourceStorage) Close(context.Context) error { return nil }
+
+type fakeCheckpointStore struct {
+	saved chan string
+}
+
+func (s *fakeCheckpointStore) Save(_ context.Context, _ string, _ schema.GroupVersionResource, resourceVersion string) error {
+	s.saved <- resourceVersion
+	return nil
+}
+
+func (s *fakeCheckpointStore) Load(context.Context, string, schema.GroupVersionResource) (string, error) {
+	return "", nil
+}
+
+func TestResourceSynchroCheckpointFlush(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	resourceStorage := &flushingResourceStorage{fakeResourceStorage: newFakeResourceStorage(gvr)}
+	checkpoints := &fakeCheckpointStore{saved: make(chan string, 1)}
+	synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+		GroupVersionResource: gvr,
+		Kind:                 "Deployment",
+		ResourceStorage:      resourceStorage,
+		CheckpointStore:      checkpoints,
+	})
+	defer synchro.Close()
+
+	synchro.checkpoint("10")
+	select {
+	case rv := <-checkpoints.saved:
+		assert.Equal(t, "10", rv)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the checkpoint is not saved")
+	}
+	assert.Eventually(t, func() bool { return !synchro.checkpointing.Load() }, 5*time.Second, 10*time.Millisecond)
+
+	// the writes dropped by the storage fail the checkpoints until the resources are relisted
+	resourceStorage.flushErr = storage.NewError(storage.ErrWriteDropped, errors.New("1 queued writes are dropped"))
+	synchro.checkpoint("20")
+	assert.Eventually(t, func() bool { return synchro.storageWriteFailed.Load() }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !synchro.checkpointing.Load() }, 5*time.Second, 10*time.Millisecond)
+
+	resourceStorage.flushErr = nil
+	synchro.checkpoint("30")
+	select {
+	case rv := <-checkpoints.saved:
+		t.Fatalf("the checkpoint %s is saved after the writes are dropped", rv)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// latestResourceVersionStorage persists the resources, so the synchro can watch from the latest resource version.
+type latestResourceVersionStorage struct {
+	*fakeResourceStorage
+}
+
+func (s *latestResourceVersionStorage) GetLatestResourceVersion(context.Context, string) (string, error) {
+	return "100", nil
+}
+
+func TestResourceSynchroLatestResourceVersionInStorage(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	for name, test := range map[string]struct {
+		storage  storage.ResourceStorage
+		expected string
+	}{
+		"latest resource version getter": {storage: &latestResourceVersionStorage{newFakeResourceStorage(gvr)}, expected: "100"},
+		// the storages not persisting the resources, e.g. the memory storage, always list the resources
+		"unsupported": {storage: newFakeResourceStorage(gvr), expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+				GroupVersionResource: gvr,
+				Kind:                 "Deployment",
+				ResourceStorage:      test.storage,
+			})
+			defer synchro.Close()
+			assert.Equal(t, test.expected, synchro.latestResourceVersionInStorage())
+		})
+	}
+}
+
+func TestResourceSynchroDeleteMetrics(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	synchro := newResourceSynchro("cluster-1", ResourceSynchroConfig{
+		GroupVersionResource: gvr,
+		Kind:                 "Deployment",
+		ResourceStorage:      newFakeResourceStorage(gvr),
+	})
+	skippedDuplicateEvents.WithLabelValues("cluster-1", synchro.syncResource.String()).Inc()
+
+	shutdown := make(chan struct{})
+	close(shutdown)
+	synchro.Run(shutdown)
+	assert.False(t, skippedDuplicateEvents.DeleteLabelValues("cluster-1", synchro.syncResource.String()))
+}
@@ -0,0 +1,147 @@
+package clustersynchro
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
+)
+
+const (
+	// maxConsistencyRelists bounds the consecutive relists of a resource triggered by the discrepancy,
+	// e.g. the resources removed from the storage by others are not restored by the relist.
+	maxConsistencyRelists = 3
+
+	// consistencyCheckDrainTimeout is the max waiting time for the queued events to be written before the check,
+	// the check is skipped if the queue is not drained, e.g. the resources keep changing.
+	consistencyCheckDrainTimeout = time.Minute
+)
+
+// StoreConsistencyChecker verifies the number of the stored resources after the initial list of a resource is synced,
+// the stored resources are counted by the storage and compared with the number of the resources in the informer store.
+// The failed writes are dropped after they are logged, so the storage may miss the resources or keep the deleted ones.
+type StoreConsistencyChecker struct {
+	// interval is the min interval between the checks of a resource.
+	interval time.Duration
+
+	// relistThreshold relists the resource if the absolute discrepancy is greater than it,
+	// 0 means the discrepancy is only reported.
+	relistThreshold int64
+
+	// limiter limits the rate of the checks of all the resources, so the storage isn't overloaded by the counts
+	// when the resources of many clusters are synced at the same time.
+	limiter *rate.Limiter
+}
+
+func NewStoreConsistencyChecker(interval time.Duration, relistThreshold int64, checksPerSecond float64) *StoreConsistencyChecker {
+	return &StoreConsistencyChecker{
+		interval:        interval,
+		relistThreshold: relistThreshold,
+		limiter:         rate.NewLimiter(rate.Limit(checksPerSecond), 1),
+	}
+}
+
+// verifyStoreConsistency checks the stored resources in the background after the initial list of the informer is synced,
+// the informer is relisted by relist if the discrepancy exceeds the threshold.
+func (synchro *ResourceSynchro) verifyStoreConsistency(stopCh <-chan struct{}, relist func()) {
+	checker := synchro.consistencyChecker
+	counter, ok := synchro.storage.(storage.ResourceCounter)
+	if checker == nil || !ok {
+		return
+	}
+	if !synchro.consistencyChecking.CompareAndSwap(false, true) {
+		return
+	}
+	if !synchro.lastConsistencyCheck.IsZero() && time.Since(synchro.lastConsistencyCheck) < checker.interval {
+		synchro.consistencyChecking.Store(false)
+		return
+	}
+	synchro.lastConsistencyCheck = time.Now()
+
+	go func() {
+		defer synchro.consistencyChecking.Store(false)
+
+		ctx, cancel := context.WithCancel(synchro.ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-stopCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := checker.limiter.Wait(ctx); err != nil {
+			return
+		}
+		if !synchro.waitForQueueDrained(ctx) {
+			klog.V(4).InfoS("Skip the consistency check of the storage, the events are not drained", "cluster", synchro.cluster, "gvr", synchro.storageResource)
+			return
+		}
+
+		synchro.rvsLock.Lock()
+		versions := synchro.cache
+		synchro.rvsLock.Unlock()
+		if versions == nil {
+			return
+		}
+		synced := int64(len(versions.ListKeys()))
+
+		countCtx, countCancel := context.WithTimeout(ctx, 30*time.Second)
+		stored, err := counter.CountResources(countCtx, synchro.cluster)
+		countCancel()
+		if err != nil {
+			klog.ErrorS(err, "Failed to count the stored resources", "cluster", synchro.cluster, "gvr", synchro.storageResource)
+			return
+		}
+
+		discrepancy := stored - synced
+		storeDiscrepancy.WithLabelValues(synchro.cluster, synchro.syncResource.String()).Set(float64(discrepancy))
+		if discrepancy == 0 {
+			synchro.consistencyRelists = 0
+			return
+		}
+		klog.InfoS("The stored resources are inconsistent with the synced resources", "cluster", synchro.cluster,
+			"gvr", synchro.storageResource, "stored", stored, "synced", synced)
+
+		if checker.relistThreshold <= 0 || (discrepancy <= checker.relistThreshold && -discrepancy <= checker.relistThreshold) {
+			synchro.consistencyRelists = 0
+			return
+		}
+		if synchro.consistencyRelists >= maxConsistencyRelists {
+			klog.InfoS("The discrepancy is not fixed by the relists, stop relisting", "cluster", synchro.cluster,
+				"gvr", synchro.storageResource, "relists", synchro.consistencyRelists)
+			return
+		}
+		synchro.consistencyRelists++
+
+		// the cache is rebuilt by the resource versions written to the storage, so the relist rewrites
+		// the resources whose writes are failed, and deletes the stored resources which are deleted.
+		synchro.rvsLock.Lock()
+		synchro.cache = nil
+		synchro.rvsLock.Unlock()
+		synchro.relistRequested.Store(true)
+		relist()
+	}()
+}
+
+// waitForQueueDrained waits until the queued events are written to the storage, it returns false if the ctx is done
+// or the queue isn't drained within the timeout.
+func (synchro *ResourceSynchro) waitForQueueDrained(ctx context.Context) bool {
+	timeout := time.NewTimer(consistencyCheckDrainTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for synchro.queue.Pending() != 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout.C:
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
@@ -33,6 +33,7 @@ import (
 	kubestatemetrics "github.com/clusterpedia-io/clusterpedia/pkg/kube_state_metrics"
 	"github.com/clusterpedia-io/clusterpedia/pkg/storage"
 	"github.com/clusterpedia-io/clusterpedia/pkg/synchromanager/clustersynchro"
+	"github.com/clusterpedia-io/clusterpedia/pkg/synchromanager/clustersynchro/informer"
 	"github.com/clusterpedia-io/clusterpedia/pkg/synchromanager/features"
 	clusterpediafeature "github.com/clusterpedia-io/clusterpedia/pkg/utils/feature"
 )
@@ -59,11 +60,18 @@ type Manager struct {
 	synchrolock       sync.RWMutex
 	synchros          map[string]*clustersynchro.ClusterSynchro
 	synchroWaitGroup  wait.Group
+
+	// storageEpoch is the epoch of the storage observed by the last check, it is only accessed by the epoch checker.
+	storageEpoch string
 }
 
 var _ kubestatemetrics.ClusterMetricsWriterListGetter = &Manager{}
 
 func NewManager(client crdclientset.Interface, storage storage.StorageFactory, syncConfig clustersynchro.ClusterSyncConfig, shardingName string) *Manager {
+	if syncConfig.InitializedSyncs == nil {
+		syncConfig.InitializedSyncs = informer.NewInitializedSyncs()
+	}
+
 	factory := externalversions.NewSharedInformerFactory(client, 0)
 	clusterinformer := factory.Cluster().V1alpha2().PediaClusters()
 	clusterSyncResourcesInformer := factory.Cluster().V1alpha2().ClusterSyncResources()
@@ -122,6 +130,11 @@ func (manager *Manager) GetMetricsWriterList() map[string]metricsstore.MetricsWr
 	return lists
 }
 
+// InitializedSyncs returns whether the initial lists of all the synced resources of each cluster are synced.
+func (manager *Manager) InitializedSyncs() *informer.InitializedSyncs {
+	return manager.clusterSyncConfig.InitializedSyncs
+}
+
 func (manager *Manager) Run(workers int, stopCh <-chan struct{}) {
 	manager.runLock.Lock()
 	defer manager.runLock.Unlock()
@@ -150,6 +163,14 @@ func (manager *Manager) Run(workers int, stopCh <-chan struct{}) {
 		}()
 	}
 
+	if epochs, ok := manager.storage.(storage.StorageEpochManager); ok && manager.clusterSyncConfig.StorageEpochCheckInterval > 0 {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			wait.Until(func() { manager.checkStorageEpoch(epochs) }, manager.clusterSyncConfig.StorageEpochCheckInterval, manager.stopCh)
+		}()
+	}
+
 	<-manager.stopCh
 	klog.Info("receive stop signal, stop...")
 
@@ -161,6 +182,43 @@ func (manager *Manager) Run(workers int, stopCh <-chan struct{}) {
 	klog.Info("cluster synchro manager stopped.")
 }
 
+// checkStorageEpoch relists the resources of all clusters from scratch if the epoch of the storage is changed,
+// e.g. the stored resources are truncated or restored from a backup, or the epoch is reset by the operators.
+// The relists are retried by the next check if some of the clusters are failed to be relisted.
+func (manager *Manager) checkStorageEpoch(epochs storage.StorageEpochManager) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	epoch, err := epochs.GetStorageEpoch(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to get the storage epoch")
+		return
+	}
+	if manager.storageEpoch == "" || manager.storageEpoch == epoch {
+		manager.storageEpoch = epoch
+		return
+	}
+	klog.InfoS("The storage epoch is changed, relist the resources of all clusters from scratch", "lastEpoch", manager.storageEpoch, "epoch", epoch)
+
+	manager.synchrolock.RLock()
+	synchros := make(map[string]*clustersynchro.ClusterSynchro, len(manager.synchros))
+	for name, synchro := range manager.synchros {
+		synchros[name] = synchro
+	}
+	manager.synchrolock.RUnlock()
+
+	var failed bool
+	for name, synchro := range synchros {
+		if err := synchro.RelistFromScratch(ctx); err != nil {
+			klog.ErrorS(err, "Failed to relist the resources of the cluster from scratch", "cluster", name)
+			failed = true
+		}
+	}
+	if !failed {
+		manager.storageEpoch = epoch
+	}
+}
+
 func (manager *Manager) addCluster(obj interface{}) {
 	manager.enqueue(obj)
 }
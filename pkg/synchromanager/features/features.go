@@ -68,6 +68,29 @@ const (
 	// owner: @27149chen
 	// alpha: v0.8.0
 	IgnoreSyncLease featuregate.Feature = "IgnoreSyncLease"
+
+	// DeduplicateModifiedEventsForResourceSync is a feature gate for ResourceSync's informer to drop the Modified events
+	// whose resource version is unchanged, avoiding the pointless updates of the storage.
+	// The resync and the relist are never deduplicated.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	DeduplicateModifiedEventsForResourceSync featuregate.Feature = "DeduplicateModifiedEventsForResourceSync"
+
+	// AdaptivePageSizeForResourceSync is a feature gate for ResourceSync's reflector to adapt the page size of paginated list
+	// by the encoded size of the resources, the page size is bounded by `--min-page-size` and `--max-page-size`.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	AdaptivePageSizeForResourceSync featuregate.Feature = "AdaptivePageSizeForResourceSync"
+
+	// SkipInitialListForResourceSync is a feature gate for ResourceSync's reflector to skip the initial list
+	// when the storage already has the resources, the reflector watches from the checkpoint saved by the bookmark events
+	// or the latest resource version in the storage, and lists the resources only if the watch is expired.
+	//
+	// owner: @iceber
+	// alpha: v0.8.0
+	SkipInitialListForResourceSync featuregate.Feature = "SkipInitialListForResourceSync"
 )
 
 func init() {
@@ -86,4 +109,8 @@ var defaultClusterSynchroManagerFeatureGates = map[featuregate.Feature]featurega
 	ForcePaginatedListForResourceSync:        {Default: false, PreRelease: featuregate.Alpha},
 	StreamHandlePaginatedListForResourceSync: {Default: false, PreRelease: featuregate.Alpha},
 	IgnoreSyncLease:                          {Default: false, PreRelease: featuregate.Alpha},
+
+	DeduplicateModifiedEventsForResourceSync: {Default: false, PreRelease: featuregate.Alpha},
+	AdaptivePageSizeForResourceSync:          {Default: false, PreRelease: featuregate.Alpha},
+	SkipInitialListForResourceSync:           {Default: false, PreRelease: featuregate.Alpha},
 }
@@ -1,12 +1,19 @@
 package utils
 
 import (
+	"fmt"
+	"strings"
+
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 
 	internal "github.com/clusterpedia-io/api/clusterpedia"
 )
 
+// ShadowAnnotationPrefix is the prefix of the shadow annotations injected into the objects returned by clusterpedia,
+// so the injected annotations never overwrite the annotations of the objects in the member clusters.
+const ShadowAnnotationPrefix = "shadow.clusterpedia.io/"
+
 func ExtractClusterName(obj runtime.Object) string {
 	if m, err := meta.Accessor(obj); err == nil {
 		if annotations := m.GetAnnotations(); annotations != nil {
@@ -17,16 +24,44 @@ func ExtractClusterName(obj runtime.Object) string {
 }
 
 func InjectClusterName(obj runtime.Object, name string) {
+	if err := InjectShadowAnnotations(obj, map[string]string{internal.ShadowAnnotationClusterName: name}); err != nil {
+		panic(err)
+	}
+}
+
+// InjectShadowAnnotations injects the shadow annotations into the object, the typed, unstructured and PartialObjectMetadata
+// objects are injected in the same way by their meta.Accessor. The keys must have the ShadowAnnotationPrefix, and the
+// annotations of the object are copied instead of modified in place, since they may be shared with the other objects.
+func InjectShadowAnnotations(obj runtime.Object, shadows map[string]string) error {
+	for key := range shadows {
+		if !strings.HasPrefix(key, ShadowAnnotationPrefix) {
+			return fmt.Errorf("shadow annotation %q must have the prefix %q", key, ShadowAnnotationPrefix)
+		}
+	}
 	m, err := meta.Accessor(obj)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	annotations := m.GetAnnotations()
-	if annotations == nil {
-		annotations = make(map[string]string)
+	changed := false
+	for key, value := range shadows {
+		if current, ok := annotations[key]; !ok || current != value {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
 	}
 
-	annotations[internal.ShadowAnnotationClusterName] = name
-	m.SetAnnotations(annotations)
+	injected := make(map[string]string, len(annotations)+len(shadows))
+	for key, value := range annotations {
+		injected[key] = value
+	}
+	for key, value := range shadows {
+		injected[key] = value
+	}
+	m.SetAnnotations(injected)
+	return nil
 }
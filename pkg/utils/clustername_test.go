@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	internal "github.com/clusterpedia-io/api/clusterpedia"
+)
+
+func TestInjectShadowAnnotations(t *testing.T) {
+	annotations := map[string]string{"app": "nginx", internal.ShadowAnnotationClusterName: "old-cluster"}
+	shadows := map[string]string{
+		internal.ShadowAnnotationClusterName:          "cluster-1",
+		internal.ShadowAnnotationGroupVersionResource: "apps/v1/deployments",
+	}
+
+	for name, obj := range map[string]runtime.Object{
+		"typed": &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "deploy-1", Annotations: annotations}},
+		"unstructured": &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "deploy-1", "annotations": map[string]interface{}{"app": "nginx", internal.ShadowAnnotationClusterName: "old-cluster"}},
+		}},
+		"partial object metadata": &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "deploy-1", Annotations: annotations}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, InjectShadowAnnotations(obj, shadows))
+			assert.Equal(t, "cluster-1", ExtractClusterName(obj))
+
+			// the annotations of the object itself are kept
+			assert.Equal(t, map[string]string{
+				"app":                                "nginx",
+				internal.ShadowAnnotationClusterName: "cluster-1",
+				internal.ShadowAnnotationGroupVersionResource: "apps/v1/deployments",
+			}, obj.(metav1.Object).GetAnnotations())
+		})
+	}
+	// the shared annotations aren't modified in place
+	assert.Equal(t, "old-cluster", annotations[internal.ShadowAnnotationClusterName])
+
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "deploy-1", Annotations: map[string]string{"app": "nginx"}}}
+	err := InjectShadowAnnotations(deploy, map[string]string{"app": "redis"})
+	assert.ErrorContains(t, err, ShadowAnnotationPrefix)
+	assert.Equal(t, "nginx", deploy.Annotations["app"])
+
+	deploy = &appsv1.Deployment{}
+	InjectClusterName(deploy, "cluster-1")
+	assert.Equal(t, map[string]string{internal.ShadowAnnotationClusterName: "cluster-1"}, deploy.Annotations)
+}
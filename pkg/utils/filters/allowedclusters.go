@@ -0,0 +1,42 @@
+package filters
+
+import (
+	"context"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	genericrequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+// AllowedClustersResolver resolves the clusters which the user is allowed to access,
+// all is true if the user is allowed to access all clusters, including the clusters added later.
+type AllowedClustersResolver interface {
+	AllowedClusters(ctx context.Context, user user.Info) (clusters sets.Set[string], all bool, err error)
+}
+
+// WithAllowedClusters restricts the requester to the clusters resolved by the resolver,
+// it must be installed inside the authentication filter.
+func WithAllowedClusters(handler http.Handler, resolver AllowedClustersResolver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		user, ok := genericrequest.UserFrom(ctx)
+		if !ok {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
+		clusters, all, err := resolver.AllowedClusters(ctx, user)
+		if err != nil {
+			responsewriters.InternalError(w, req, err)
+			return
+		}
+		if !all {
+			req = req.WithContext(request.WithAllowedClusters(ctx, clusters))
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,71 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericrequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+type fakeClustersResolver map[string][]string
+
+func (r fakeClustersResolver) AllowedClusters(_ context.Context, user user.Info) (sets.Set[string], bool, error) {
+	if user.GetName() == "error" {
+		return nil, false, errors.New("failed to resolve")
+	}
+	clusters, ok := r[user.GetName()]
+	if !ok {
+		return nil, true, nil
+	}
+	return sets.New(clusters...), false, nil
+}
+
+func TestWithAllowedClusters(t *testing.T) {
+	resolver := fakeClustersResolver{"team-a": {"cluster-1"}}
+	tests := []struct {
+		name string
+		user user.Info
+
+		expectedCode     int
+		expectedClusters sets.Set[string]
+		expectedAllowed  bool
+	}{
+		{"unauthenticated", nil, http.StatusOK, nil, false},
+		{"all clusters", &user.DefaultInfo{Name: "admin"}, http.StatusOK, nil, false},
+		{"restricted", &user.DefaultInfo{Name: "team-a"}, http.StatusOK, sets.New("cluster-1"), true},
+		{"resolver error", &user.DefaultInfo{Name: "error"}, http.StatusInternalServerError, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				clusters sets.Set[string]
+				ok       bool
+			)
+			handler := WithAllowedClusters(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				clusters, ok = request.AllowedClustersFrom(req.Context())
+			}), resolver)
+
+			req, _ := http.NewRequest("GET", "/apis", nil)
+			if test.user != nil {
+				req = req.WithContext(genericrequest.WithUser(req.Context(), test.user))
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			if recorder.Code != test.expectedCode {
+				t.Errorf("expected code %d, but got %d", test.expectedCode, recorder.Code)
+			}
+			if ok != test.expectedAllowed || !clusters.Equal(test.expectedClusters) {
+				t.Errorf("expected allowed clusters %v(%v), but got %v(%v)", test.expectedClusters, test.expectedAllowed, clusters, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package filters
+
+import (
+	"net/http"
+
+	genericrequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/clusterpedia-io/clusterpedia/pkg/utils/request"
+)
+
+// WithRequester populates the requester with the authenticated user and the path of the request,
+// it must be installed inside the authentication filter.
+func WithRequester(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requester := request.Requester{URI: req.URL.Path}
+		if user, ok := genericrequest.UserFrom(req.Context()); ok {
+			requester.User = user.GetName()
+			requester.Groups = user.GetGroups()
+		}
+		req = req.WithContext(request.WithRequester(req.Context(), requester))
+		handler.ServeHTTP(w, req)
+	})
+}
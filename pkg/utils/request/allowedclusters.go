@@ -0,0 +1,24 @@
+package request
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+type allowedClustersKeyType int
+
+const allowedClustersKey allowedClustersKeyType = iota
+
+// WithAllowedClusters restricts the requester to the clusters, the storages narrow the queries of all clusters
+// to the allowed clusters and reject the queries of the other clusters.
+func WithAllowedClusters(parent context.Context, clusters sets.Set[string]) context.Context {
+	return context.WithValue(parent, allowedClustersKey, clusters)
+}
+
+// AllowedClustersFrom returns the clusters allowed for the requester,
+// the requester is allowed to access all clusters if ok is false.
+func AllowedClustersFrom(ctx context.Context) (clusters sets.Set[string], ok bool) {
+	clusters, ok = ctx.Value(allowedClustersKey).(sets.Set[string])
+	return clusters, ok
+}
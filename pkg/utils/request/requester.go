@@ -0,0 +1,25 @@
+package request
+
+import "context"
+
+type requesterKeyType int
+
+const requesterKey requesterKeyType = iota
+
+// Requester is the identity of the user who sends the request, it is used to attribute the storage queries.
+type Requester struct {
+	User   string
+	Groups []string
+
+	// URI is the path of the request, the query is excluded since it may contain the sensitive values.
+	URI string
+}
+
+func WithRequester(parent context.Context, requester Requester) context.Context {
+	return context.WithValue(parent, requesterKey, requester)
+}
+
+func RequesterFrom(ctx context.Context) (Requester, bool) {
+	requester, ok := ctx.Value(requesterKey).(Requester)
+	return requester, ok
+}
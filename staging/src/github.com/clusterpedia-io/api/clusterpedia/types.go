@@ -20,6 +20,7 @@ const (
 
 	SearchLabelOwnerUID           = "search.clusterpedia.io/owner-uid"
 	SearchLabelOwnerName          = "search.clusterpedia.io/owner-name"
+	SearchLabelOwnerNamespace     = "search.clusterpedia.io/owner-namespace"
 	SearchLabelOwnerGroupResource = "search.clusterpedia.io/owner-gr"
 	SearchLabelOwnerSeniority     = "search.clusterpedia.io/owner-seniority"
 
@@ -52,6 +53,7 @@ type ListOptions struct {
 	OrderBy      []OrderBy
 
 	OwnerName          string
+	OwnerNamespace     string
 	OwnerUID           string
 	OwnerGroupResource schema.GroupResource
 	OwnerSeniority     int
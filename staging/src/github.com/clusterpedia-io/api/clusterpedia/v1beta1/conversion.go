@@ -55,6 +55,7 @@ func Convert_v1beta1_ListOptions_To_clusterpedia_ListOptions(in *ListOptions, ou
 
 	out.OwnerUID = in.OwnerUID
 	out.OwnerName = in.OwnerName
+	out.OwnerNamespace = in.OwnerNamespace
 	if in.OwnerGroupResource != "" {
 		out.OwnerGroupResource = schema.ParseGroupResource(in.OwnerGroupResource)
 	}
@@ -101,6 +102,10 @@ func Convert_v1beta1_ListOptions_To_clusterpedia_ListOptions(in *ListOptions, ou
 					if out.OwnerName == "" && len(values) == 1 {
 						out.OwnerName = values[0]
 					}
+				case clusterpedia.SearchLabelOwnerNamespace:
+					if out.OwnerNamespace == "" && len(values) == 1 {
+						out.OwnerNamespace = values[0]
+					}
 				case clusterpedia.SearchLabelOwnerGroupResource:
 					if out.OwnerGroupResource.Empty() && len(values) == 1 {
 						out.OwnerGroupResource = schema.ParseGroupResource(values[0])
@@ -210,6 +215,7 @@ func Convert_clusterpedia_ListOptions_To_v1beta1_ListOptions(in *clusterpedia.Li
 
 	out.OwnerUID = in.OwnerUID
 	out.OwnerName = in.OwnerName
+	out.OwnerNamespace = in.OwnerNamespace
 	out.OwnerGroupResource = in.OwnerGroupResource.String()
 	out.OwnerSeniority = in.OwnerSeniority
 
@@ -32,6 +32,9 @@ type ListOptions struct {
 	// +optional
 	OwnerName string `json:"ownerName,omitempty"`
 
+	// +optional
+	OwnerNamespace string `json:"ownerNamespace,omitempty"`
+
 	// +optional
 	Since string `json:"since,omitempty"`
 
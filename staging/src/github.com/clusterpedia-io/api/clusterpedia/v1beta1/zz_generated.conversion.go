@@ -199,6 +199,7 @@ func autoConvert_v1beta1_ListOptions_To_clusterpedia_ListOptions(in *ListOptions
 	// WARNING: in.OrderBy requires manual conversion: inconvertible types (string vs []github.com/clusterpedia-io/api/clusterpedia.OrderBy)
 	out.OwnerUID = in.OwnerUID
 	out.OwnerName = in.OwnerName
+	out.OwnerNamespace = in.OwnerNamespace
 	// WARNING: in.Since requires manual conversion: inconvertible types (string vs *k8s.io/apimachinery/pkg/apis/meta/v1.Time)
 	// WARNING: in.Before requires manual conversion: inconvertible types (string vs *k8s.io/apimachinery/pkg/apis/meta/v1.Time)
 	// WARNING: in.OwnerGroupResource requires manual conversion: inconvertible types (string vs k8s.io/apimachinery/pkg/runtime/schema.GroupResource)
@@ -224,6 +225,7 @@ func autoConvert_clusterpedia_ListOptions_To_v1beta1_ListOptions(in *clusterpedi
 	}
 	// WARNING: in.OrderBy requires manual conversion: inconvertible types ([]github.com/clusterpedia-io/api/clusterpedia.OrderBy vs string)
 	out.OwnerName = in.OwnerName
+	out.OwnerNamespace = in.OwnerNamespace
 	out.OwnerUID = in.OwnerUID
 	// WARNING: in.OwnerGroupResource requires manual conversion: inconvertible types (k8s.io/apimachinery/pkg/runtime/schema.GroupResource vs string)
 	out.OwnerSeniority = in.OwnerSeniority
@@ -283,6 +285,13 @@ func autoConvert_url_Values_To_v1beta1_ListOptions(in *url.Values, out *ListOpti
 	} else {
 		out.OwnerName = ""
 	}
+	if values, ok := map[string][]string(*in)["ownerNamespace"]; ok && len(values) > 0 {
+		if err := runtime.Convert_Slice_string_To_string(&values, &out.OwnerNamespace, s); err != nil {
+			return err
+		}
+	} else {
+		out.OwnerNamespace = ""
+	}
 	if values, ok := map[string][]string(*in)["since"]; ok && len(values) > 0 {
 		if err := runtime.Convert_Slice_string_To_string(&values, &out.Since, s); err != nil {
 			return err